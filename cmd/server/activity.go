@@ -0,0 +1,43 @@
+package server
+
+import (
+	"log/slog"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/kafka"
+	"wetalk/pkg/metrics"
+)
+
+// activityMemoryCapacity is the number of events kept by the in-memory export
+const activityMemoryCapacity = 1000
+
+// newActivityStream builds the configured export of the chat activity, events are dropped when there is none
+func (s *Server) newActivityStream(registry *metrics.Registry) (usecase.ActivityStream, error) {
+	cfg := s.cfg.Activity
+
+	switch cfg.Export {
+	case "kafka":
+		options := kafka.DefaultOptions()
+		options.Brokers = cfg.KafkaBrokers
+		options.TLS = cfg.KafkaTLS
+		options.SASLMechanism = cfg.KafkaSASLMechanism
+		options.Username = cfg.KafkaUsername
+		options.Password = cfg.KafkaPassword
+		producer, err := kafka.NewProducer(options)
+		if err != nil {
+			return nil, err
+		}
+
+		dropped := registry.NewCounter("wetalk_activity_dropped_events_total", "Activity events dropped because Kafka was unreachable or too slow.")
+		registry.OnCollect(func() {
+			dropped.Set(float64(producer.Dropped()))
+		})
+
+		slog.Info("Exporting activity to Kafka", "brokers", cfg.KafkaBrokers, "topic", cfg.KafkaTopic)
+		return kafka.NewActivityStream(producer, cfg.KafkaTopic), nil
+	case "memory":
+		slog.Info("Keeping the latest activity events in memory", "capacity", activityMemoryCapacity)
+		return usecase.NewMemActivityStream(activityMemoryCapacity), nil
+	default:
+		return usecase.NewNoopActivityStream(), nil
+	}
+}
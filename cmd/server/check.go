@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"wetalk/infrastructure/db"
+	"wetalk/internal/repository"
+	"wetalk/pkg/config"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// minJWTSecretLength is the shortest JWT secret accepted in production, 256 bits for HS256
+const minJWTSecretLength = 32
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "FAIL"
+	checkSkip = "skip"
+)
+
+// checkReport writes one line per check, followed by what to do about it when it didn't pass
+type checkReport struct {
+	w      io.Writer
+	failed bool
+}
+
+func (r *checkReport) add(status string, name string, detail string, fix string) {
+	if status == checkFail {
+		r.failed = true
+	}
+	fmt.Fprintf(r.w, "%-4s  %-12s %s\n", status, name, detail)
+	if fix != "" {
+		fmt.Fprintf(r.w, "      %-12s -> %s\n", "", fix)
+	}
+}
+
+// Check is the self-check of --check: it validates the configuration read through getenv, checks
+// the JWT secret, connects to MongoDB, Redis and NATS and verifies the indexes exist, then writes
+// a report to w. It reports whether the server can start, warnings don't prevent it
+func Check(ctx context.Context, w io.Writer, getenv func(string) string) bool {
+	report := &checkReport{w: w}
+
+	cfg, err := config.LoadFrom(getenv)
+	if err != nil {
+		for _, err := range configErrors(err) {
+			report.add(checkFail, "config", err.Error(), "")
+		}
+		fmt.Fprintln(w, "The configuration is invalid, the connections were not checked")
+		return false
+	}
+	report.add(checkOK, "config", fmt.Sprintf("APP_ENV=%s, SERVER_ID=%s", cfg.Env, cfg.Server.ServerId), "")
+
+	checkJWTSecret(report, cfg)
+	checkMongo(ctx, report, cfg)
+	checkRedis(ctx, report, cfg)
+	checkNATS(report, cfg)
+
+	if report.failed {
+		fmt.Fprintln(w, "The server would not start or would not run safely, fix the failed checks")
+		return false
+	}
+	fmt.Fprintln(w, "The server is ready to start")
+	return true
+}
+
+// configErrors splits the error of config.Load into its invalid settings
+func configErrors(err error) []error {
+	if joined, ok := errors.Unwrap(err).(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// checkJWTSecret fails a weak secret in production, development only gets a warning
+func checkJWTSecret(report *checkReport, cfg config.Config) {
+	weak := checkWarn
+	if cfg.Env == config.EnvProduction {
+		weak = checkFail
+	}
+	fix := fmt.Sprintf("set JWT_SECRET to at least %d random bytes, such as the output of openssl rand -base64 48", minJWTSecretLength)
+
+	distinct := map[rune]bool{}
+	for _, c := range cfg.JWT.Secret {
+		distinct[c] = true
+	}
+
+	switch {
+	case cfg.UsesDevelopmentSecret():
+		report.add(weak, "jwt secret", "JWT_SECRET is not set, tokens are signed with the public development secret", fix)
+	case len(cfg.JWT.Secret) < minJWTSecretLength:
+		report.add(weak, "jwt secret", fmt.Sprintf("JWT_SECRET is %d bytes long", len(cfg.JWT.Secret)), fix)
+	case len(distinct) < 10:
+		report.add(weak, "jwt secret", fmt.Sprintf("JWT_SECRET only uses %d different characters", len(distinct)), fix)
+	default:
+		report.add(checkOK, "jwt secret", fmt.Sprintf("%d bytes", len(cfg.JWT.Secret)), "")
+	}
+}
+
+// checkMongo connects to the database and verifies the indexes the repositories rely on
+func checkMongo(ctx context.Context, report *checkReport, cfg config.Config) {
+	mongoDb, err := db.NewMongoStore(ctx, cfg.Mongo.URI, cfg.Mongo.Database)
+	if err != nil {
+		report.add(checkFail, "mongodb", err.Error(), "check MONGODB_URI and that MongoDB is reachable from this host")
+		report.add(checkSkip, "indexes", "MongoDB is unreachable", "")
+		return
+	}
+	defer mongoDb.Close(ctx)
+	report.add(checkOK, "mongodb", "connected to the database "+cfg.Mongo.Database, "")
+
+	if cfg.Mongo.Transactions {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		err := mongoDb.DB.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		switch {
+		case err != nil:
+			report.add(checkFail, "transactions", err.Error(), "")
+		case hello.SetName == "" && hello.Msg != "isdbgrid":
+			report.add(checkFail, "transactions", "MONGODB_TRANSACTIONS is set but MongoDB is a standalone server", "run MongoDB as a replica set or unset MONGODB_TRANSACTIONS")
+		default:
+			report.add(checkOK, "transactions", "supported by the deployment", "")
+		}
+	}
+
+	database := *mongoDb.DB
+	missing, err := repository.MissingIndexes(ctx,
+		repository.NewUserRepository(database),
+		repository.NewChatRepository(database),
+		repository.NewMessageRepository(database),
+		repository.NewReceiptRepository(database),
+		repository.NewRefreshTokenRepository(database),
+		repository.NewTwoFactorRepository(database),
+		repository.NewFlaggedMessageRepository(database),
+		repository.NewReportRepository(database),
+		repository.NewScheduledMessageRepository(database),
+		repository.NewAccountMergeRepository(database),
+		repository.NewEmailBounceRepository(database),
+		repository.NewMessagePurgeRepository(database),
+	)
+	switch {
+	case err != nil:
+		report.add(checkFail, "indexes", err.Error(), "")
+	case len(missing) > 0:
+		for _, index := range missing {
+			report.add(checkWarn, "indexes", index+" is missing", "")
+		}
+		report.add(checkWarn, "indexes", fmt.Sprintf("%d missing indexes, the queries on them scan their collection", len(missing)),
+			`the server creates them when it starts, when they stay missing look for "Ensure indexes error" in its logs, such as duplicate emails preventing the unique index on users.email`)
+	default:
+		report.add(checkOK, "indexes", "all the indexes exist", "")
+	}
+}
+
+func checkRedis(ctx context.Context, report *checkReport, cfg config.Config) {
+	if !cfg.Redis.Enabled() {
+		report.add(checkSkip, "redis", "REDIS_ADDR is not set, the hub and the stores only work for this server", "")
+		return
+	}
+
+	client, err := db.NewRedisClient(ctx, cfg.Redis.Addr)
+	if err != nil {
+		report.add(checkFail, "redis", err.Error(), "check REDIS_ADDR and that Redis is reachable from this host")
+		return
+	}
+	defer client.Close()
+	report.add(checkOK, "redis", "connected to "+cfg.Redis.Addr, "")
+}
+
+func checkNATS(report *checkReport, cfg config.Config) {
+	if !cfg.NATS.Enabled() {
+		return
+	}
+
+	conn, err := nats.Connect(cfg.NATS.URL, nats.Name("wetalk-check"))
+	if err != nil {
+		report.add(checkFail, "nats", err.Error(), "check NATS_URL and that NATS is reachable from this host")
+		return
+	}
+	defer conn.Close()
+	report.add(checkOK, "nats", "connected", "")
+}
@@ -0,0 +1,31 @@
+//go:build grpc
+
+package server
+
+import (
+	"log/slog"
+	"net"
+	grpcHandler "wetalk/internal/delivery/grpc"
+	"wetalk/internal/usecase"
+)
+
+// startGRPC serves the gRPC API in the background next to the HTTP server
+func (s *Server) startGRPC(authUc usecase.AuthUsecase, chatUc usecase.ChatUsecase, messageUc usecase.MessageUsecase, bus usecase.EventBus) error {
+	feed := grpcHandler.NewMessageFeed(messageUc)
+	feed.Subscribe(bus)
+
+	listener, err := net.Listen("tcp", ":"+s.cfg.Server.GRPCPort)
+	if err != nil {
+		return err
+	}
+
+	grpcServer := grpcHandler.NewServer(authUc, chatUc, messageUc, feed)
+	go func() {
+		slog.Info("gRPC server is running", "addr", listener.Addr().String())
+		if err := grpcServer.Serve(listener); err != nil {
+			slog.Error("gRPC server error", "error", err)
+		}
+	}()
+
+	return nil
+}
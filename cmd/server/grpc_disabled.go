@@ -0,0 +1,14 @@
+//go:build !grpc
+
+package server
+
+import (
+	"errors"
+
+	"wetalk/internal/usecase"
+)
+
+// startGRPC refuses to start, the gRPC transport is only compiled with the "grpc" build tag
+func (s *Server) startGRPC(authUc usecase.AuthUsecase, chatUc usecase.ChatUsecase, messageUc usecase.MessageUsecase, bus usecase.EventBus) error {
+	return errors.New("GRPC_PORT is set but this binary was built without the grpc build tag")
+}
@@ -2,134 +2,569 @@ package server
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"time"
+	"wetalk/infrastructure/cache"
 	"wetalk/infrastructure/db"
+	"wetalk/infrastructure/scheduler"
 	"wetalk/infrastructure/ws"
 	httpHandler "wetalk/internal/delivery/http"
 	"wetalk/internal/delivery/websocket"
+	"wetalk/internal/entity"
 	"wetalk/internal/repository"
 	"wetalk/internal/usecase"
+	"wetalk/pkg/captcha"
+	"wetalk/pkg/chaos"
+	"wetalk/pkg/config"
 	"wetalk/pkg/jwt"
+	"wetalk/pkg/linkpreview"
+	"wetalk/pkg/metrics"
+	"wetalk/pkg/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/event"
 )
 
-func Run() {
-	err := godotenv.Load()
-	if err != nil {
-		fmt.Println("godotenv: error loading .env file")
+// participantCacheTTL bounds how long the participants of a chat are served from the cache, a
+// membership change made in a transaction may be missed that long
+const participantCacheTTL = time.Minute
+
+// recordCacheTTL bounds how long the users are served from the cache, the chats share the TTL of
+// their participants
+const recordCacheTTL = time.Minute
+
+type Server struct {
+	cfg config.Config
+}
+
+// NewServer creates a server from an already validated configuration
+func NewServer(cfg config.Config) *Server {
+	return &Server{
+		cfg: cfg,
 	}
+}
 
+func (s *Server) Run() error {
+	cfg := s.cfg
 	ctx := context.Background()
 
-	mongoDbHost := os.Getenv("MONGODB_URI")
-	mongoDbName := os.Getenv("MONGODB_DATABASE")
-	mongoDb, err := db.NewMongoStore(ctx, mongoDbHost, mongoDbName)
+	// Fault injection for resilience tests, the injector is nil and injects nothing unless enabled
+	var faults *chaos.Injector
+	if cfg.Chaos.Enabled {
+		slog.Warn("Chaos mode is enabled, faults are injected into Mongo, Redis and websockets")
+		faults = chaos.New(chaos.Config{
+			MongoLatency:            cfg.Chaos.MongoLatency,
+			MongoLatencyRate:        cfg.Chaos.MongoLatencyRate,
+			RedisPublishFailureRate: cfg.Chaos.RedisPublishFailureRate,
+			WebsocketDisconnectRate: cfg.Chaos.WebsocketDisconnectRate,
+		})
+	}
+
+	// Tracing, spans are recorded and exported only when an OTLP endpoint is configured
+	tracer := tracing.Disabled()
+	var mongoMonitor *event.CommandMonitor
+	var tracingHooks []redis.Hook
+	if cfg.Tracing.Enabled() {
+		slog.Info("Exporting traces", "endpoint", cfg.Tracing.TracesURL(), "sample_rate", cfg.Tracing.SampleRate)
+		provider, err := tracing.New(ctx, tracing.Config{
+			ServiceName: cfg.Tracing.ServiceName,
+			InstanceId:  cfg.Server.ServerId,
+			Endpoint:    cfg.Tracing.TracesURL(),
+			Headers:     cfg.Tracing.Headers,
+			SampleRate:  cfg.Tracing.SampleRate,
+		})
+		if err != nil {
+			return err
+		}
+		defer provider.Shutdown(ctx)
+
+		tracer = provider.Tracer(tracing.Name)
+		mongoMonitor = tracing.MongoMonitor(tracer)
+		tracingHooks = tracing.RedisHooks(tracer)
+	}
+
+	mongoDb, err := db.NewMongoStore(ctx, cfg.Mongo.URI, cfg.Mongo.Database, mongoMonitor, faults.MongoMonitor())
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	log.Println("Connected to MongoDB")
+	slog.Info("Connected to MongoDB")
 
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(*mongoDb.DB)
 	chatRepo := repository.NewChatRepository(*mongoDb.DB)
 	messageRepo := repository.NewMessageRepository(*mongoDb.DB)
+	receiptRepo := repository.NewReceiptRepository(*mongoDb.DB)
 	refreshTokenRepo := repository.NewRefreshTokenRepository(*mongoDb.DB)
+	auditRepo := repository.NewAuditRepository(*mongoDb.DB)
+	settingsRepo := repository.NewSettingsRepository(*mongoDb.DB)
+	planRepo := repository.NewPlanRepository(*mongoDb.DB)
+	consentRepo := repository.NewConsentRepository(*mongoDb.DB)
+	outboxRepo := repository.NewOutboxRepository(*mongoDb.DB)
+	inboxRepo := repository.NewInboxRepository(*mongoDb.DB)
+	deviceRepo := repository.NewDeviceRepository(*mongoDb.DB)
+	abuseRepo := repository.NewAbuseRepository(*mongoDb.DB)
+	notificationPreferencesRepo := repository.NewNotificationPreferencesRepository(*mongoDb.DB)
+	twoFactorRepo := repository.NewTwoFactorRepository(*mongoDb.DB)
+	autoResponderRepo := repository.NewAutoResponderRepository(*mongoDb.DB)
+	flaggedMessageRepo := repository.NewFlaggedMessageRepository(*mongoDb.DB)
+	reportRepo := repository.NewReportRepository(*mongoDb.DB)
+	scheduledMessageRepo := repository.NewScheduledMessageRepository(*mongoDb.DB)
+	accountMergeRepo := repository.NewAccountMergeRepository(*mongoDb.DB)
+	emailBounceRepo := repository.NewEmailBounceRepository(*mongoDb.DB)
+	messagePurgeRepo := repository.NewMessagePurgeRepository(*mongoDb.DB)
+
+	// Transactions need a replica set, standalone servers write one collection after the other
+	transactor := repository.NewNoTransactor()
+	if cfg.Mongo.Transactions {
+		transactor = repository.NewMongoTransactor(*mongoDb.DB)
+	}
+
+	// Short-lived sessions (guests, widgets), chat focus, push deduplication, the replay windows, the
+	// server stats, the rate limits, the maintenance mode, the caches and the spam and auto-reply counters
+	// live in Redis so every server sees them
+	var sessionRepo repository.SessionRepository
+	var focusRepo repository.FocusRepository
+	var notificationDedupRepo repository.NotificationDedupRepository
+	var replayRepo repository.ReplayRepository
+	var serverStatsRepo repository.ServerStatsRepository
+	var rateLimitRepo repository.RateLimitRepository
+	var maintenanceRepo repository.MaintenanceRepository
+	var linkPreviewRepo repository.LinkPreviewRepository
+	var messagePageRepo repository.MessagePageRepository
+	var participantCacheRepo repository.ParticipantCacheRepository
+	var recordCacheRepo repository.RecordCacheRepository
+	var sharedCache cache.Cache
+	// Traced first so the spans include the injected failures
+	redisHooks := append(tracingHooks, faults.RedisHooks()...)
+	if cfg.Redis.Enabled() {
+		redisClient, err := db.NewRedisClient(ctx, cfg.Redis.Addr, redisHooks...)
+		if err != nil {
+			return err
+		}
+		sessionRepo = repository.NewRedisSessionRepository(redisClient)
+		focusRepo = repository.NewRedisFocusRepository(redisClient)
+		notificationDedupRepo = repository.NewRedisNotificationDedupRepository(redisClient)
+		replayRepo = repository.NewRedisReplayRepository(redisClient)
+		serverStatsRepo = repository.NewRedisServerStatsRepository(redisClient)
+		rateLimitRepo = repository.NewRedisRateLimitRepository(redisClient)
+		maintenanceRepo = repository.NewRedisMaintenanceRepository(redisClient)
+		linkPreviewRepo = repository.NewRedisLinkPreviewRepository(redisClient)
+		messagePageRepo = repository.NewRedisMessagePageRepository(redisClient)
+		participantCacheRepo = repository.NewRedisParticipantCacheRepository(redisClient)
+		recordCacheRepo = repository.NewRedisRecordCacheRepository(redisClient)
+		sharedCache = cache.NewRedisCache(redisClient)
+	} else {
+		sessionRepo = repository.NewMemSessionRepository(cache.NewMemCache(time.Minute))
+		focusRepo = repository.NewMemFocusRepository()
+		notificationDedupRepo = repository.NewMemNotificationDedupRepository(cache.NewMemCache(time.Minute))
+		replayRepo = repository.NewMemReplayRepository(cache.NewMemCache(time.Minute))
+		serverStatsRepo = repository.NewMemServerStatsRepository()
+		rateLimitRepo = repository.NewMemRateLimitRepository(cache.NewMemCache(time.Minute))
+		maintenanceRepo = repository.NewMemMaintenanceRepository()
+		linkPreviewRepo = repository.NewMemLinkPreviewRepository(cache.NewMemCache(time.Minute))
+		messagePageRepo = repository.NewMemMessagePageRepository(cache.NewMemCache(time.Minute))
+		participantCacheRepo = repository.NewMemParticipantCacheRepository(cache.NewMemCache(time.Minute))
+		recordCacheRepo = repository.NewMemRecordCacheRepository(cache.NewMemCache(time.Minute))
+		sharedCache = cache.NewLocalCache(cache.NewMemCache(time.Minute))
+	}
+	// Every message sent reads its chat, the participants of the chat and its sender
+	chatRepo = repository.NewCachedChatRepository(chatRepo, participantCacheRepo, recordCacheRepo, participantCacheTTL)
+	userRepo = repository.NewCachedUserRepository(userRepo, recordCacheRepo, recordCacheTTL)
+
+	// Every lookup would otherwise be a collection scan
+	if err := repository.EnsureIndexes(ctx, userRepo, chatRepo, messageRepo, receiptRepo, refreshTokenRepo, twoFactorRepo, flaggedMessageRepo, reportRepo, scheduledMessageRepo, accountMergeRepo, emailBounceRepo, messagePurgeRepo); err != nil {
+		slog.ErrorContext(ctx, "Ensure indexes error", "error", err)
+	}
 
 	// Initialize JWT manager
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "your-secret-key-change-this-in-production" // Default for development
-		log.Println("Warning: Using default JWT secret. Set JWT_SECRET in .env for production")
+	if cfg.UsesDevelopmentSecret() {
+		slog.Warn("Using default JWT secret. Set JWT_SECRET in .env for production")
 	}
+	jwtManager := jwt.NewJWTManager(cfg.JWT.Secret, cfg.JWT.AccessTokenDuration, cfg.JWT.RefreshTokenDuration)
 
-	// Access token: 15 minutes, Refresh token: 30 days
-	jwtManager := jwt.NewJWTManager(jwtSecret, 15*time.Minute, 30*24*time.Hour)
+	agePolicy := usecase.AgePolicy{
+		MinimumAge: cfg.Age.MinimumAge,
+		AdultAge:   cfg.Age.AdultAge,
+	}
+
+	// Captcha is disabled unless a provider is configured
+	captchaPolicy := usecase.DefaultCaptchaPolicy()
+	captchaPolicy.LoginFailureThreshold = cfg.Captcha.LoginThreshold
+	if cfg.Captcha.Provider != "" {
+		captchaPolicy.Verifier, err = captcha.NewVerifier(cfg.Captcha.Provider, cfg.Captcha.Secret)
+		if err != nil {
+			return err
+		}
+		slog.Info("Captcha enabled", "provider", cfg.Captcha.Provider)
+	}
+
+	abusePolicy := usecase.DefaultAbusePolicy()
+	abusePolicy.LimitedSendInterval = cfg.Abuse.LimitedSendInterval
+	if len(cfg.Abuse.Rules) > 0 {
+		abusePolicy.Rules = make([]entity.AbuseRule, 0, len(cfg.Abuse.Rules))
+		for _, rule := range cfg.Abuse.Rules {
+			abusePolicy.Rules = append(abusePolicy.Rules, entity.AbuseRule(rule))
+		}
+	}
+
+	// Key metrics are tagged by workspace for Prometheus and the per-tenant usage reports
+	metricsRegistry := metrics.NewRegistry()
+	metricsUc := usecase.NewMetricsUsecase(metricsRegistry, messageRepo, planRepo)
+
+	twoFactorPolicy := usecase.DefaultTwoFactorPolicy()
+	twoFactorPolicy.Issuer = cfg.TwoFactor.Issuer
+
+	securityPolicy := usecase.DefaultSecurityPolicy()
+	securityPolicy.FailedLoginThreshold = cfg.Security.FailedLoginThreshold
+
+	// Other users only see the public profile of a user
+	profilePolicy := usecase.ProfilePolicy{
+		ExposeEmail: cfg.Profile.ExposeEmail,
+	}
 
 	// Initialize use cases
-	authUc := usecase.NewAuthUsecase(userRepo, refreshTokenRepo, jwtManager)
-	userUc := usecase.NewUserUseCase(userRepo)
-	messageUc := usecase.NewMessageUseCase(messageRepo, chatRepo, userRepo)
-	chatUc := usecase.NewChatUsecase(chatRepo, userRepo, messageRepo)
+	abuseUc := usecase.NewAbuseUsecase(abuseRepo, sharedCache, abusePolicy)
+	// Domain events, from the registration hooks to the read models
+	eventBus := usecase.NewEventBus()
+	authUc := usecase.NewAuthUsecase(userRepo, refreshTokenRepo, twoFactorRepo, jwtManager, agePolicy, captchaPolicy, twoFactorPolicy, securityPolicy, eventBus)
+	userUc := usecase.NewUserUseCase(userRepo, profilePolicy)
+	settingsUc := usecase.NewSettingsUsecase(settingsRepo, userRepo)
+	planUc := usecase.NewPlanUsecase(planRepo, userRepo, metricsUc)
+	consentUc := usecase.NewConsentUsecase(consentRepo)
+
+	sessionPolicy := usecase.DefaultSessionPolicy()
+	sessionPolicy.TTL = cfg.Session.GuestSessionTTL
+	sessionUc := usecase.NewSessionUsecase(sessionRepo, sessionPolicy)
+
+	// Runs on the hub goroutine after a connection is gone, so it gets its own bounded context
+	onClientUnregister := func(client *ws.UserClient) error {
+		unregisterCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
 
-	// Check if Redis is enabled
-	redisAddr := os.Getenv("REDIS_ADDR")
-	useRedis := redisAddr != ""
+		_, err := userUc.HandleUnregisterClient(unregisterCtx, client.UserId)
+		return err
+	}
 
 	var hub ws.IHub
-	if useRedis {
-		serverID := os.Getenv("SERVER_ID")
-		if serverID == "" {
-			serverID = "server-1" // Default
+	if cfg.NATS.Enabled() {
+		slog.Info("Using NATS hub", "server_id", cfg.Server.ServerId)
+		natsHub, err := ws.NewNATSHub(cfg.NATS.URL, cfg.Server.ServerId, tracer)
+		if err != nil {
+			return err
 		}
+		hub = natsHub
 
-		log.Printf("Using Redis hub at %s with server ID: %s", redisAddr, serverID)
-		redisHub := ws.NewRedisHub(redisAddr, serverID)
+		natsHub.SetOnClientUnregister(onClientUnregister)
+	} else if cfg.Redis.Enabled() {
+		slog.Info("Using Redis hub", "addr", cfg.Redis.Addr, "transport", cfg.Redis.HubTransport, "server_id", cfg.Server.ServerId)
+		var redisHub ws.IHub
+		if cfg.Redis.HubShadowTransport != "" {
+			slog.Info("Shadowing the Redis hub transport", "transport", cfg.Redis.HubTransport, "shadow_transport", cfg.Redis.HubShadowTransport)
+			redisHub = ws.NewShadowRedisHub(cfg.Redis.Addr, cfg.Server.ServerId, cfg.Redis.HubTransport, cfg.Redis.HubShadowTransport, tracer, redisHooks...)
+		} else if cfg.Redis.HubTransport == "streams" {
+			redisHub = ws.NewRedisStreamHub(cfg.Redis.Addr, cfg.Server.ServerId, tracer, redisHooks...)
+		} else {
+			redisHub = ws.NewRedisHub(cfg.Redis.Addr, cfg.Server.ServerId, tracer, redisHooks...)
+		}
 		hub = redisHub
 
-		redisHub.SetOnClientUnregister(func(client *ws.UserClient) error {
-			_, err := userUc.HandleUnregisterClient(ctx, client.UserId)
-			return err
-		})
+		redisHub.SetOnClientUnregister(onClientUnregister)
 	} else {
-		log.Println("Using in-memory hub (single server)")
+		slog.Info("Using in-memory hub (single server)")
 		memHub := ws.NewHub()
 		hub = memHub
 
-		memHub.SetOnClientUnregister(func(client *ws.UserClient) error {
-			_, err := userUc.HandleUnregisterClient(ctx, client.UserId)
-			return err
-		})
+		memHub.SetOnClientUnregister(onClientUnregister)
 	}
 
 	go hub.Run()
 
-	log.Println("Websocket is running")
+	// Slow consumers show up in the hub metrics before their frames start being dropped
+	ws.RegisterMetrics(hub, metricsRegistry)
 
-	// CORS middleware
-	router := chi.NewRouter()
-	router.Use(middleware.Logger)
-	router.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-			// Handle preflight requests
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
+	chatPolicy := newChatPolicy(cfg.Chat)
+
+	// Events go through the outbox so they survive a crash before reaching the hub,
+	// ephemeral presence such as chat focus is pushed directly
+	hubPublisher := websocket.NewEventPublisher(hub)
+	outboxUc := usecase.NewOutboxUsecase(outboxRepo, hubPublisher)
+	focusUc := usecase.NewFocusUsecase(focusRepo, chatRepo, hubPublisher)
+	replayed, err := outboxUc.ReplayPending(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Replay outbox error", "error", err)
+	} else if replayed > 0 {
+		slog.InfoContext(ctx, "Replayed pending outbox events", "count", replayed)
+	}
+
+	// Read models are kept up to date from domain events
+	inboxUc := usecase.NewInboxUsecase(inboxRepo, chatRepo, userRepo, messageRepo, receiptRepo, hub)
+	inboxUc.Subscribe(eventBus)
+
+	// Connections join the rooms of their chats, a message fan-out is a single send per room
+	roomUc := usecase.NewRoomUsecase(chatRepo, hub)
+	roomUc.Subscribe(eventBus)
+
+	// The newest messages of each chat are kept for instant replays when a chat is opened
+	replayPolicy := usecase.DefaultReplayPolicy()
+	replayPolicy.WindowSize = cfg.Chat.ReplayWindowSize
+	replayUc := usecase.NewReplayUsecase(replayRepo, chatRepo, messageRepo, planUc, replayPolicy)
+	replayUc.Subscribe(eventBus)
+
+	// New messages are pushed to the devices of offline recipients that did not ack them in real time
+	notifier, err := s.newNotifier()
+	if err != nil {
+		return err
+	}
+	// Devices the push providers keep refusing are deactivated, addresses the mail relay bounces flagged
+	deliveryHealthUc := usecase.NewDeliveryHealthUsecase(metricsRegistry, deviceRepo, emailBounceRepo, userRepo, usecase.DefaultDeliveryHealthPolicy())
+	notificationUc := usecase.NewNotificationUsecase(deviceRepo, notificationDedupRepo, notificationPreferencesRepo, chatRepo, userRepo, hub, notifier, deliveryHealthUc, usecase.DefaultNotificationPolicy())
+	notificationUc.Subscribe(eventBus)
+	go notificationUc.Run()
+
+	// The newest page of the opened chats is read from the cache, dropped on every change to their messages
+	messageCacheUc := usecase.NewMessageCacheUsecase(metricsRegistry, messagePageRepo, messageRepo, usecase.DefaultMessageCachePolicy())
+	messageCacheUc.Subscribe(eventBus)
+
+	chatUc := usecase.NewChatUsecase(chatRepo, userRepo, messageRepo, receiptRepo, auditRepo, planUc, messageCacheUc, jwtManager, outboxUc, eventBus, transactor, chatPolicy, profilePolicy)
+	// New users join the default chats of their workspace
+	chatUc.Subscribe(eventBus)
+	// Chat activity is exported for the analytics and moderation pipelines
+	activityStream, err := s.newActivityStream(metricsRegistry)
+	if err != nil {
+		return err
+	}
+	activityExportUc := usecase.NewActivityExportUsecase(activityStream)
+	activityExportUc.Subscribe(eventBus)
+	// Authentication anomalies go to the security webhook and, when enabled, to the account owner
+	securityAlertUc := usecase.NewSecurityAlertUsecase(metricsRegistry, userRepo, s.newSecuritySink(), s.newSecurityMailer(), deliveryHealthUc)
+	securityAlertUc.Subscribe(eventBus)
+	// Group admins' auto-reply rules are evaluated on every stored message
+	autoResponderUc := usecase.NewAutoResponderUsecase(autoResponderRepo, chatRepo, messageRepo, receiptRepo, outboxUc, sharedCache, usecase.DefaultAutoResponderPolicy())
+	autoResponderUc.Subscribe(eventBus)
+	// Server generated messages for a single user are never stored, they skip the outbox
+	ephemeralUc := usecase.NewEphemeralUsecase(hubPublisher)
+	commandUc := usecase.NewCommandUsecase(chatUc, ephemeralUc)
+	moderationUc := usecase.NewModerationUsecase(s.newModerators(), flaggedMessageRepo, usecase.ModerationPolicy{FailOpen: cfg.Moderation.FailOpen})
+	messageUc := usecase.NewMessageUseCase(messageRepo, receiptRepo, chatRepo, userRepo, settingsUc, planUc, usecase.NewWorkspaceContentPolicy(), moderationUc, outboxUc, eventBus, abuseUc, commandUc, transactor, outboxUc)
+	// New messages reach their recipients through the outbox, whichever server stored them
+	go outboxUc.DispatchMessages(ctx, websocket.NewMessageDeliverer(hub, messageUc, tracer))
+
+	// The first link of a message is previewed in the background, the preview follows the message
+	if cfg.LinkPreview.Enabled {
+		linkPreviewUc := usecase.NewLinkPreviewUsecase(linkPreviewRepo, messageRepo, chatRepo, linkpreview.NewFetcher(cfg.LinkPreview.Timeout), outboxUc, usecase.DefaultLinkPreviewPolicy())
+		linkPreviewUc.Subscribe(eventBus)
+		go linkPreviewUc.Run()
+	}
+
+	scheduledMessageUc := usecase.NewScheduledMessageUsecase(scheduledMessageRepo, chatRepo, messageUc, outboxUc)
+	accountMergeUc := usecase.NewAccountMergeUsecase(accountMergeRepo, userRepo, chatRepo, messageRepo, receiptRepo, refreshTokenRepo, auditRepo, transactor)
+
+	// Maintenance jobs, each reports its runs and processed items in the metrics
+	jobs := scheduler.New(metricsRegistry)
+	// Pick up events left behind by servers that died before delivering them
+	jobs.Add(scheduler.Job{
+		Name:     "outbox_replay",
+		Interval: time.Minute,
+		Run: func(ctx context.Context) (int64, error) {
+			replayed, err := outboxUc.ReplayPending(ctx)
+			return int64(replayed), err
+		},
+	})
+	// Messages sent later go out through SaveMessage once they are due
+	jobs.Add(scheduler.Job{
+		Name:     "scheduled_messages",
+		Interval: usecase.ScheduledMessageInterval,
+		Run:      scheduledMessageUc.SendDue,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "outbox_purge",
+		Interval: time.Hour,
+		Run:      outboxUc.PurgeSent,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "empty_chat_purge",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) (int64, error) {
+			purged, err := chatUc.PurgeEmptyChats(ctx)
+			return int64(purged), err
+		},
+	})
+	// Deleted chats can be restored until they are past their retention
+	jobs.Add(scheduler.Job{
+		Name:     "deleted_chat_purge",
+		Interval: time.Hour,
+		Timeout:  time.Hour,
+		Run:      chatUc.PurgeDeletedChats,
+	})
+	// Messages are deleted for good once they are past the retention of their workspace
+	jobs.Add(scheduler.Job{
+		Name:     "expired_message_purge",
+		Interval: time.Hour,
+		Run:      messageUc.PurgeExpiredMessages,
+	})
+	// Disappearing messages go a few seconds after their expiresAt, the clients hide them on time
+	jobs.Add(scheduler.Job{
+		Name:     "disappearing_message_purge",
+		Interval: 10 * time.Second,
+		Run:      messageUc.DeleteDisappearedMessages,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "refresh_token_purge",
+		Interval: time.Hour,
+		Run:      authUc.PurgeExpiredRefreshTokens,
 	})
+	jobs.Add(scheduler.Job{
+		Name:     "deactivated_device_purge",
+		Interval: time.Hour,
+		Run:      deliveryHealthUc.PurgeDeactivatedDevices,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "invitation_expiry",
+		Interval: time.Hour,
+		Run:      chatUc.ExpireInvitations,
+	})
+	// Guests lose their access at expiresAt, the sweeper removes them and tells their groups
+	jobs.Add(scheduler.Job{
+		Name:     "guest_expiry",
+		Interval: time.Minute,
+		Run:      chatUc.ExpireGuests,
+	})
+	// Pins made for a while go once they expire, the pin listings hide them on time
+	jobs.Add(scheduler.Job{
+		Name:     "pin_expiry",
+		Interval: time.Minute,
+		Run:      chatUc.UnpinExpiredMessages,
+	})
+	// Presence and unread counts drift when a server dies between two updates, the corrections
+	// are counted in the metrics by kind
+	repairUc := usecase.NewRepairUsecase(metricsRegistry, userRepo, inboxRepo, receiptRepo, hub)
+	jobs.Add(scheduler.Job{
+		Name:     "presence_repair",
+		Interval: 5 * time.Minute,
+		Run:      repairUc.RepairPresence,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "unread_repair",
+		Interval: time.Hour,
+		Run:      repairUc.RepairUnreadCounts,
+	})
+	// Every server reports its client count and throughput for the back-office
+	adminUc := usecase.NewAdminUsecase(userRepo, chatRepo, messageRepo, refreshTokenRepo, auditRepo, serverStatsRepo, flaggedMessageRepo, maintenanceRepo, messagePurgeRepo, metricsUc, outboxUc, eventBus, hub, cfg.Server.ServerId)
+	reportUc := usecase.NewReportUsecase(reportRepo, messageRepo, chatRepo, userRepo, abuseUc)
+	quotaUc := usecase.NewQuotaUsecase(rateLimitRepo, messageRepo, userRepo, planUc, usecase.RateLimitPolicy{
+		Requests: int64(cfg.API.RateLimitRequests),
+		Window:   cfg.API.RateLimitWindow,
+	})
+	jobs.Add(scheduler.Job{
+		Name:     "server_stats_report",
+		Interval: usecase.ServerStatsInterval,
+		Run:      adminUc.ReportServerStats,
+	})
+	// A purge runs to completion on the server that claims it, its claim is renewed after every batch
+	jobs.Add(scheduler.Job{
+		Name:     "message_purges",
+		Interval: usecase.MessagePurgeInterval,
+		Timeout:  time.Hour,
+		Run:      adminUc.RunMessagePurges,
+	})
+	// Measure the storage of every workspace, the aggregation scans all messages so it runs on its own schedule
+	jobs.Add(scheduler.Job{
+		Name:     "storage_metrics",
+		Interval: cfg.Metrics.StorageInterval,
+		Run: func(ctx context.Context) (int64, error) {
+			return 0, metricsUc.RefreshStorage(ctx)
+		},
+	})
+	jobs.Start(ctx)
+
+	slog.Info("Websocket is running")
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(httpHandler.NewTracingMiddleware(tracer))
+	router.Use(httpHandler.RequestLogger)
+	router.Use(httpHandler.NewCORSMiddleware(httpHandler.CORSOptions{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+		ExcludedPaths:    cfg.CORS.ExcludedPaths,
+	}).Handler)
+	apiVersions, err := httpHandler.NewAPIVersionMiddleware(cfg.API.DefaultVersion)
+	if err != nil {
+		return err
+	}
+	router.Use(apiVersions.Negotiate)
 
 	// Initialize handlers
-	websocketH := websocket.NewWebsocketHandler(hub, userUc, messageUc, chatUc)
-	httpH := httpHandler.NewHttpHandler(chatUc, userUc)
+	heartbeat := ws.Heartbeat{
+		PingInterval: cfg.Websocket.PingInterval,
+		PongTimeout:  cfg.Websocket.PongTimeout,
+	}
+	slowClient := ws.SlowClientPolicy{
+		QueueSize:         cfg.Websocket.SendQueueSize,
+		SaturationTimeout: cfg.Websocket.SlowClientTimeout,
+		CoalescedEvents:   cfg.Websocket.CoalescedEvents,
+	}
+	websocketH := websocket.NewWebsocketHandler(hub, userUc, messageUc, chatUc, focusUc, notificationUc, metricsUc, replayUc, ephemeralUc, scheduledMessageUc, roomUc, heartbeat, slowClient, cfg.Websocket.EnvelopeV2MinAppVersion, faults, tracer)
+	httpH := httpHandler.NewHttpHandler(chatUc, userUc, inboxUc, focusUc, messageUc)
 	authH := httpHandler.NewAuthHandler(authUc)
+	settingsH := httpHandler.NewSettingsHandler(settingsUc)
+	planH := httpHandler.NewPlanHandler(planUc)
+	consentH := httpHandler.NewConsentHandler(consentUc)
+	sessionH := httpHandler.NewSessionHandler(sessionUc)
+	notificationH := httpHandler.NewNotificationHandler(notificationUc)
+	autoResponderH := httpHandler.NewAutoResponderHandler(autoResponderUc)
+	sseH := httpHandler.NewSSEHandler(hub, userUc, metricsUc)
+	graphqlH := httpHandler.NewGraphQLHandler(chatUc, userUc, messageUc, authUc, consentUc, abuseUc, metricsUc, hub, heartbeat)
+	abuseH := httpHandler.NewAbuseHandler(abuseUc)
+	metricsH := httpHandler.NewMetricsHandler(metricsUc, metricsRegistry, hub, cfg.Metrics.Token)
+	adminH := httpHandler.NewAdminHandler(adminUc)
+	reportH := httpHandler.NewReportHandler(reportUc)
+	quotaH := httpHandler.NewQuotaHandler(quotaUc)
+	scheduledMessageH := httpHandler.NewScheduledMessageHandler(scheduledMessageUc)
+	accountMergeH := httpHandler.NewAccountMergeHandler(accountMergeUc)
+	deliveryHealthH := httpHandler.NewDeliveryHealthHandler(deliveryHealthUc)
+	router.Use(metricsH.CountErrors)
+	go sseH.Run()
 	authMiddleware := httpHandler.NewAuthMiddleware(authUc)
+	consentMiddleware := httpHandler.NewConsentMiddleware(consentUc)
+	abuseMiddleware := httpHandler.NewAbuseMiddleware(abuseUc)
+	rateLimitMiddleware := httpHandler.NewRateLimitMiddleware(quotaUc, cfg.API.RateLimitEnforce)
+	maintenanceMiddleware := httpHandler.NewMaintenanceMiddleware(adminUc, authUc)
 
 	// Map routes
-	httpHandler.MapHttpRoutes(router, *httpH, *websocketH, *authH, authMiddleware)
+	httpHandler.MapHttpRoutes(router, *httpH, *websocketH, *authH, *settingsH, *planH, *consentH, *sessionH, *notificationH, *autoResponderH, sseH, graphqlH, *abuseH, *metricsH, *adminH, *reportH, *quotaH, *scheduledMessageH, *accountMergeH, *deliveryHealthH, authMiddleware, consentMiddleware, abuseMiddleware, rateLimitMiddleware, maintenanceMiddleware)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if cfg.Server.GRPCPort != "" {
+		if err := s.startGRPC(authUc, chatUc, messageUc, eventBus); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("HTTP server is running on :%s", port)
-
-	if err := http.ListenAndServe(":"+port, router); err != nil {
-		log.Fatal(err)
+	addr := ":" + cfg.Server.Port
+	if cfg.Server.TLS.Enabled() {
+		slog.Info("HTTPS server is running", "addr", addr)
+		return http.ListenAndServeTLS(addr, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile, router)
 	}
+
+	slog.Info("HTTP server is running", "addr", addr)
+	return http.ListenAndServe(addr, router)
+}
+
+// newChatPolicy starts from the default chat policy so the rules without a setting keep working
+// values, then applies the configured ones
+func newChatPolicy(cfg config.ChatConfig) usecase.ChatPolicy {
+	policy := usecase.DefaultChatPolicy()
+	policy.EmptyChatGracePeriod = cfg.EmptyChatGracePeriod
+	policy.InvitationTTL = cfg.InvitationTTL
+	policy.InviteLinkTTL = cfg.InviteLinkTTL
+	policy.MaxPinnedMessages = cfg.MaxPinnedMessages
+	policy.DeletedChatRetention = cfg.DeletedChatRetention
+	policy.DefaultChatIds = cfg.DefaultChatIds
+	policy.DefaultHistoryAccess = cfg.DefaultHistoryAccess
+	return policy
 }
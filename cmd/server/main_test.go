@@ -0,0 +1,85 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/config"
+)
+
+func loadChatPolicy(t *testing.T, env map[string]string) usecase.ChatPolicy {
+	t.Helper()
+
+	cfg, err := config.LoadFrom(func(key string) string {
+		if key == "MONGODB_DATABASE" {
+			return "wetalk_test"
+		}
+		return env[key]
+	})
+	if err != nil {
+		t.Fatalf("LoadFrom: %v", err)
+	}
+	return newChatPolicy(cfg.Chat)
+}
+
+func TestNewChatPolicyDefaults(t *testing.T) {
+	policy := loadChatPolicy(t, nil)
+	want := usecase.DefaultChatPolicy()
+
+	if policy.EmptyChatGracePeriod != want.EmptyChatGracePeriod {
+		t.Errorf("EmptyChatGracePeriod = %v, want %v", policy.EmptyChatGracePeriod, want.EmptyChatGracePeriod)
+	}
+	if policy.InvitationTTL != want.InvitationTTL {
+		t.Errorf("InvitationTTL = %v, want %v", policy.InvitationTTL, want.InvitationTTL)
+	}
+	if policy.InviteLinkTTL != want.InviteLinkTTL {
+		t.Errorf("InviteLinkTTL = %v, want %v", policy.InviteLinkTTL, want.InviteLinkTTL)
+	}
+	if policy.MaxPinnedMessages != want.MaxPinnedMessages {
+		t.Errorf("MaxPinnedMessages = %d, want %d", policy.MaxPinnedMessages, want.MaxPinnedMessages)
+	}
+	if policy.DeletedChatRetention != want.DeletedChatRetention {
+		t.Errorf("DeletedChatRetention = %v, want %v", policy.DeletedChatRetention, want.DeletedChatRetention)
+	}
+	if len(policy.DefaultChatIds) != 0 {
+		t.Errorf("DefaultChatIds = %v, want none", policy.DefaultChatIds)
+	}
+	if policy.DefaultHistoryAccess != want.DefaultHistoryAccess {
+		t.Errorf("DefaultHistoryAccess = %q, want %q", policy.DefaultHistoryAccess, want.DefaultHistoryAccess)
+	}
+}
+
+func TestNewChatPolicyFromEnv(t *testing.T) {
+	policy := loadChatPolicy(t, map[string]string{
+		"EMPTY_CHAT_GRACE_PERIOD":     "2h",
+		"INVITATION_TTL":              "72h",
+		"INVITE_LINK_TTL":             "30m",
+		"MAX_PINNED_MESSAGES":         "5",
+		"DELETED_CHAT_RETENTION":      "240h",
+		"DEFAULT_CHAT_IDS":            "general, random",
+		"CHAT_DEFAULT_HISTORY_ACCESS": "none",
+	})
+
+	if policy.EmptyChatGracePeriod != 2*time.Hour {
+		t.Errorf("EmptyChatGracePeriod = %v, want 2h", policy.EmptyChatGracePeriod)
+	}
+	if policy.InvitationTTL != 72*time.Hour {
+		t.Errorf("InvitationTTL = %v, want 72h", policy.InvitationTTL)
+	}
+	if policy.InviteLinkTTL != 30*time.Minute {
+		t.Errorf("InviteLinkTTL = %v, want 30m", policy.InviteLinkTTL)
+	}
+	if policy.MaxPinnedMessages != 5 {
+		t.Errorf("MaxPinnedMessages = %d, want 5", policy.MaxPinnedMessages)
+	}
+	if policy.DeletedChatRetention != 240*time.Hour {
+		t.Errorf("DeletedChatRetention = %v, want 240h", policy.DeletedChatRetention)
+	}
+	if want := []string{"general", "random"}; !reflect.DeepEqual(policy.DefaultChatIds, want) {
+		t.Errorf("DefaultChatIds = %v, want %v", policy.DefaultChatIds, want)
+	}
+	if policy.DefaultHistoryAccess != "none" {
+		t.Errorf("DefaultHistoryAccess = %q, want none", policy.DefaultHistoryAccess)
+	}
+}
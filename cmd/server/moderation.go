@@ -0,0 +1,20 @@
+package server
+
+import (
+	"log/slog"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/moderation"
+)
+
+// newModerators builds the moderators run on every message, the word lists of the workspaces
+// first and the moderation service when one is configured
+func (s *Server) newModerators() []usecase.Moderator {
+	cfg := s.cfg.Moderation
+
+	moderators := []usecase.Moderator{usecase.NewKeywordModerator()}
+	if cfg.HTTPURL != "" {
+		slog.Info("Moderating messages with the moderation service", "fail_open", cfg.FailOpen)
+		moderators = append(moderators, moderation.NewHTTPModerator(cfg.HTTPURL, cfg.HTTPToken, cfg.HTTPTimeout))
+	}
+	return moderators
+}
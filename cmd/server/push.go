@@ -0,0 +1,50 @@
+package server
+
+import (
+	"log/slog"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/push"
+)
+
+// newNotifier builds the push providers that are configured, notifications are logged when there is none
+func (s *Server) newNotifier() (usecase.Notifier, error) {
+	cfg := s.cfg.Push
+	senders := map[string]push.Sender{}
+
+	if cfg.FCMCredentialsFile != "" {
+		fcm, err := push.NewFCMSender(cfg.FCMCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		senders[entity.DevicePlatformAndroid] = fcm
+		senders[entity.DevicePlatformWeb] = fcm
+		slog.Info("Push notifications enabled through FCM for android and web devices")
+	}
+
+	if cfg.APNs.Enabled() {
+		apns, err := push.NewAPNsSender(push.APNsOptions{
+			KeyFile: cfg.APNs.KeyFile,
+			KeyId:   cfg.APNs.KeyId,
+			TeamId:  cfg.APNs.TeamId,
+			Topic:   cfg.APNs.Topic,
+			Sandbox: cfg.APNs.Sandbox,
+		})
+		if err != nil {
+			return nil, err
+		}
+		senders[entity.DevicePlatformIOS] = apns
+		slog.Info("Push notifications enabled through APNs for ios devices")
+	}
+
+	var fallback push.Sender
+	if cfg.WebhookURL != "" {
+		fallback = push.NewWebhookSender(cfg.WebhookURL, cfg.WebhookSecret)
+		slog.Info("Push notifications enabled through the webhook for the remaining devices")
+	}
+
+	if len(senders) == 0 && fallback == nil {
+		return usecase.NewLogNotifier(), nil
+	}
+	return push.NewRouter(senders, fallback), nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"log/slog"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/mail"
+	"wetalk/pkg/siem"
+)
+
+// newSecuritySink builds the configured destination of the security events, they are logged when there is none
+func (s *Server) newSecuritySink() usecase.SecuritySink {
+	cfg := s.cfg.Security
+
+	if cfg.WebhookURL == "" {
+		return usecase.NewLogSecuritySink()
+	}
+
+	slog.Info("Reporting security events to the webhook")
+	return siem.NewWebhookSink(cfg.WebhookURL, cfg.WebhookSecret)
+}
+
+// newSecurityMailer builds the mailer notifying users of the security events on their account,
+// it is nil when users are not notified
+func (s *Server) newSecurityMailer() usecase.Mailer {
+	cfg := s.cfg.Security
+
+	if !cfg.NotifyUsers {
+		return nil
+	}
+
+	slog.Info("Emailing users about the security events on their account", "smtp_addr", cfg.SMTP.Addr)
+	return mail.NewSMTPSender(mail.Options{
+		Addr:     cfg.SMTP.Addr,
+		Username: cfg.SMTP.Username,
+		Password: cfg.SMTP.Password,
+		From:     cfg.SMTP.From,
+	})
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a store of short-lived strings and counters. The local cache keeps them for this
+// server, the Redis cache shares them between every server of the deployment, so the state held
+// in a Cache behaves the same on one server and on many
+type Cache interface {
+	// Get returns the value at key, found is false when it is missing or expired
+	Get(ctx context.Context, key string) (value string, found bool, err error)
+	// Set stores the value at key, it never expires when ttl is zero
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Increment adds delta to the counter at key and returns its new value. A missing counter
+	// starts from zero and expires after ttl, incrementing it doesn't extend it
+	Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Keys returns the keys starting with prefix, in no particular order
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+type localCache struct {
+	// mu makes the first increment of a counter atomic
+	mu  sync.Mutex
+	mem *MemCache
+}
+
+// NewLocalCache serves the Cache from a MemCache, for the deployments running a single server
+func NewLocalCache(mem *MemCache) Cache {
+	return &localCache{
+		mem: mem,
+	}
+}
+
+func (c *localCache) Get(ctx context.Context, key string) (string, bool, error) {
+	value, ok := c.mem.Get(key)
+	if !ok {
+		return "", false, nil
+	}
+	// Counters are kept as integers
+	return fmt.Sprint(value), true, nil
+}
+
+func (c *localCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	c.mem.Set(key, value, ttl)
+	return nil
+}
+
+func (c *localCache) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		c.mem.Delete(key)
+	}
+	return nil
+}
+
+func (c *localCache) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.mem.Exists(key) {
+		c.mem.Set(key, delta, ttl)
+		return delta, nil
+	}
+	return c.mem.Increment(key, delta)
+}
+
+func (c *localCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	for _, key := range c.mem.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,19 +14,39 @@ type MemCache struct {
 	items sync.Map
 	stop  chan struct{}
 	wg    sync.WaitGroup
+
+	// loads holds the GetOrSet loaders in flight, per key
+	loadsMu sync.Mutex
+	loads   map[string]*load
 }
 
 type item struct {
 	mu         sync.Mutex
 	value      any
-	expiration int64 // unix nano; 0 means no expiration
+	expiration atomic.Int64 // unix nano; 0 means no expiration
+}
+
+// load is a GetOrSet loader call shared by the callers that missed the same key
+type load struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+func newItem(value any, ttl time.Duration) *item {
+	it := &item{value: value}
+	if ttl > 0 {
+		it.expiration.Store(time.Now().Add(ttl).UnixNano())
+	}
+	return it
 }
 
 // NewMemCache creates a new MemCache. If cleanupInterval > 0,
 // a background goroutine will periodically remove expired items.
 func NewMemCache(cleanupInterval time.Duration) *MemCache {
 	m := &MemCache{
-		stop: make(chan struct{}),
+		stop:  make(chan struct{}),
+		loads: make(map[string]*load),
 	}
 	if cleanupInterval > 0 {
 		m.wg.Add(1)
@@ -47,14 +68,84 @@ func NewMemCache(cleanupInterval time.Duration) *MemCache {
 }
 
 func (m *MemCache) Set(key string, value any, ttl time.Duration) {
+	m.items.Store(key, newItem(value, ttl))
+}
+
+// SetNX stores the value only when the key is missing or expired, it reports whether it did.
+// It can be used as a lock that is released by Delete or when ttl runs out
+func (m *MemCache) SetNX(key string, value any, ttl time.Duration) bool {
+	it := newItem(value, ttl)
+	for {
+		actual, loaded := m.items.LoadOrStore(key, it)
+		if !loaded {
+			return true
+		}
+		if !actual.(*item).isExpired() {
+			return false
+		}
+		// Take the place of the expired item, unless another caller did
+		if m.items.CompareAndSwap(key, actual, it) {
+			return true
+		}
+	}
+}
+
+// Expire changes the TTL of a key that is present, it never expires when ttl is zero. It reports
+// whether the key was present
+func (m *MemCache) Expire(key string, ttl time.Duration) bool {
+	v, ok := m.items.Load(key)
+	if !ok {
+		return false
+	}
+	it := v.(*item)
+	if it.isExpired() {
+		m.items.CompareAndDelete(key, it)
+		return false
+	}
+
 	var exp int64
 	if ttl > 0 {
 		exp = time.Now().Add(ttl).UnixNano()
 	}
-	m.items.Store(key, &item{
-		value:      value,
-		expiration: exp,
-	})
+	it.expiration.Store(exp)
+	return true
+}
+
+// GetOrSet returns the value at key, calling loader and storing its value for ttl on a miss.
+// Concurrent misses of the same key wait for a single loader call and share its result, a
+// loader error is returned to all of them and nothing is stored
+func (m *MemCache) GetOrSet(key string, loader func() (any, error), ttl time.Duration) (any, error) {
+	if value, ok := m.Get(key); ok {
+		return value, nil
+	}
+
+	m.loadsMu.Lock()
+	if call, ok := m.loads[key]; ok {
+		m.loadsMu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	// The value may have been stored by a loader that finished after the first check
+	if value, ok := m.Get(key); ok {
+		m.loadsMu.Unlock()
+		return value, nil
+	}
+	call := &load{done: make(chan struct{})}
+	m.loads[key] = call
+	m.loadsMu.Unlock()
+
+	defer func() {
+		m.loadsMu.Lock()
+		delete(m.loads, key)
+		m.loadsMu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = loader()
+	if call.err == nil {
+		m.Set(key, call.value, ttl)
+	}
+	return call.value, call.err
 }
 
 func (m *MemCache) Get(key string) (any, bool) {
@@ -64,7 +155,7 @@ func (m *MemCache) Get(key string) (any, bool) {
 	}
 	it := v.(*item)
 	if it.isExpired() {
-		m.items.Delete(key)
+		m.items.CompareAndDelete(key, it)
 		return nil, false
 	}
 	return it.value, true
@@ -99,7 +190,7 @@ func (m *MemCache) Keys() []string {
 	now := time.Now().UnixNano()
 	m.items.Range(func(k, v any) bool {
 		it := v.(*item)
-		if it.expiration == 0 || now <= it.expiration {
+		if exp := it.expiration.Load(); exp == 0 || now <= exp {
 			if ks, ok := k.(string); ok {
 				keys = append(keys, ks)
 			}
@@ -113,7 +204,7 @@ func (m *MemCache) Range(f func(key, value any) bool) {
 	now := time.Now().UnixNano()
 	m.items.Range(func(k, v any) bool {
 		it := v.(*item)
-		if it.expiration == 0 || now <= it.expiration {
+		if exp := it.expiration.Load(); exp == 0 || now <= exp {
 			return f(k, it.value)
 		}
 		return true
@@ -127,10 +218,7 @@ var ErrNotInteger = errors.New("value is not an integer")
 
 func (m *MemCache) Increment(key string, delta int64) (int64, error) {
 	// Ensure an item exists for the key.
-	actual, _ := m.items.LoadOrStore(key, &item{
-		value:      int64(0),
-		expiration: 0,
-	})
+	actual, _ := m.items.LoadOrStore(key, newItem(int64(0), 0))
 	it := actual.(*item)
 
 	it.mu.Lock()
@@ -139,7 +227,7 @@ func (m *MemCache) Increment(key string, delta int64) (int64, error) {
 	if it.isExpired() {
 		// treat as not present: reset
 		it.value = int64(0)
-		it.expiration = 0
+		it.expiration.Store(0)
 	}
 
 	switch v := it.value.(type) {
@@ -182,18 +270,19 @@ func (m *MemCache) Decrement(key string, delta int64) (int64, error) {
 }
 
 func (it *item) isExpired() bool {
-	if it == nil || it.expiration == 0 {
+	if it == nil {
 		return false
 	}
-	return time.Now().UnixNano() > it.expiration
+	exp := it.expiration.Load()
+	return exp != 0 && time.Now().UnixNano() > exp
 }
 
 func (m *MemCache) cleanup() {
 	now := time.Now().UnixNano()
 	m.items.Range(func(k, v any) bool {
 		it := v.(*item)
-		if it.expiration != 0 && now > it.expiration {
-			m.items.Delete(k)
+		if exp := it.expiration.Load(); exp != 0 && now > exp {
+			m.items.CompareAndDelete(k, it)
 		}
 		return true
 	})
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisScanCount is how many keys Keys asks Redis to look at per round trip
+const redisScanCount = 500
+
+// incrementScript sets the expiration of a counter it creates, in the same round trip
+var incrementScript = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if value == tonumber(ARGV[1]) and tonumber(ARGV[2]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value
+`)
+
+type redisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache serves the Cache from Redis, every server sees the same values
+func NewRedisCache(client *redis.Client) Cache {
+	return &redisCache{
+		client: client,
+	}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := c.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
+
+func (c *redisCache) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := incrementScript.Run(ctx, c.client, []string{key}, delta, ttl.Milliseconds()).Int64()
+	if err != nil && isNotInteger(err) {
+		return 0, ErrNotInteger
+	}
+	return value, err
+}
+
+// isNotInteger reports whether Redis refused to increment a value that is not a counter
+func isNotInteger(err error) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && strings.Contains(redisErr.Error(), "not an integer")
+}
+
+func (c *redisCache) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	iter := c.client.Scan(ctx, 0, escapePattern(prefix)+"*", redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// escapePattern quotes the glob characters of a prefix so SCAN matches it literally
+func escapePattern(prefix string) string {
+	escaped := make([]byte, 0, len(prefix))
+	for i := 0; i < len(prefix); i++ {
+		switch prefix[i] {
+		case '*', '?', '[', ']', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, prefix[i])
+	}
+	return string(escaped)
+}
@@ -3,9 +3,11 @@ package db
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -15,7 +17,10 @@ type MongoStore struct {
 	DB     *mongo.Database
 }
 
-func NewMongoStore(ctx context.Context, uri, dbName string) (*MongoStore, error) {
+// NewMongoStore connects to Mongo, the monitors observe every command in order and nil ones are
+// skipped. Commands are logged with the context of the repository call, so they carry the
+// request id of the caller
+func NewMongoStore(ctx context.Context, uri, dbName string, monitors ...*event.CommandMonitor) (*MongoStore, error) {
 	if uri == "" {
 		uri = os.Getenv("MONGODB_URI")
 		if uri == "" {
@@ -31,7 +36,8 @@ func NewMongoStore(ctx context.Context, uri, dbName string) (*MongoStore, error)
 	}
 
 	clientOpts := options.Client().ApplyURI(uri).
-		SetMaxPoolSize(100)
+		SetMaxPoolSize(100).
+		SetMonitor(commandLogger(monitors))
 
 	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -70,3 +76,33 @@ func (m *MongoStore) Ping(ctx context.Context) error {
 	defer cancel()
 	return m.Client.Ping(pingCtx, nil)
 }
+
+// commandLogger logs finished commands at debug level and failed ones as warnings, then hands
+// the events to the monitors
+func commandLogger(monitors []*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, monitor := range monitors {
+				if monitor != nil && monitor.Started != nil {
+					monitor.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			slog.DebugContext(ctx, "Mongo command", "command", e.CommandName, "duration", e.Duration)
+			for _, monitor := range monitors {
+				if monitor != nil && monitor.Succeeded != nil {
+					monitor.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			slog.WarnContext(ctx, "Mongo command failed", "command", e.CommandName, "duration", e.Duration, "error", e.Failure)
+			for _, monitor := range monitors {
+				if monitor != nil && monitor.Failed != nil {
+					monitor.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,115 @@
+// Package scheduler runs maintenance jobs on a fixed interval. Runs are spread with a
+// random jitter so servers started together don't hit the database at the same time
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+	"wetalk/pkg/metrics"
+)
+
+// Job is a task run periodically, Run returns how many items it processed
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Jitter is the most a run is delayed by, a tenth of the interval when zero
+	Jitter time.Duration
+	// Timeout bounds a single run, the interval when zero
+	Timeout time.Duration
+	Run     func(ctx context.Context) (int64, error)
+}
+
+// Scheduler runs every job in its own goroutine, runs of the same job never overlap
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs []Job
+
+	runs        *metrics.Vec
+	processed   *metrics.Vec
+	duration    *metrics.Vec
+	lastSuccess *metrics.Vec
+}
+
+func New(registry *metrics.Registry) *Scheduler {
+	return &Scheduler{
+		runs:        registry.NewCounter("wetalk_job_runs_total", "Runs of the maintenance jobs by result.", "job", "result"),
+		processed:   registry.NewCounter("wetalk_job_processed_total", "Items processed by the maintenance jobs.", "job"),
+		duration:    registry.NewGauge("wetalk_job_duration_seconds", "Duration of the last run of a maintenance job.", "job"),
+		lastSuccess: registry.NewGauge("wetalk_job_last_success_timestamp_seconds", "Unix time of the last successful run of a maintenance job.", "job"),
+	}
+}
+
+// Add registers a job, it must be called before Start
+func (s *Scheduler) Add(job Job) {
+	if job.Jitter <= 0 {
+		job.Jitter = job.Interval / 10
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = job.Interval
+	}
+
+	s.mu.Lock()
+	s.jobs = append(s.jobs, job)
+	s.mu.Unlock()
+}
+
+// Start runs the jobs until the context is done, the first run of a job happens within its jitter
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	delay := jitter(job.Jitter)
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(ctx, job)
+		delay = job.Interval + jitter(job.Jitter)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	runCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	processed, err := job.Run(runCtx)
+	s.duration.Set(time.Since(start).Seconds(), job.Name)
+	if processed > 0 {
+		s.processed.Add(float64(processed), job.Name)
+	}
+
+	if err != nil {
+		s.runs.Inc(job.Name, "error")
+		slog.ErrorContext(ctx, "Job error", "job", job.Name, "error", err)
+		return
+	}
+
+	s.runs.Inc(job.Name, "success")
+	s.lastSuccess.Set(float64(time.Now().Unix()), job.Name)
+	if processed > 0 {
+		slog.InfoContext(ctx, "Job processed items", "job", job.Name, "processed", processed)
+	}
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
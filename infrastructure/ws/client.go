@@ -1,44 +1,373 @@
 package ws
 
 import (
-	"log"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
+	"wetalk/internal/entity"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 const (
 	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 512
+
+	// streamQueueSize bounds the queue of stream clients, their transport drains it as it fills
+	streamQueueSize = 256
 )
 
+// Heartbeat is how a websocket is kept alive, a ping is sent every PingInterval and a connection
+// that stays silent for PongTimeout, pongs included, is closed
+type Heartbeat struct {
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+}
+
+// SlowClientPolicy is how a connection reading slower than it is written to is treated. Its queue
+// grows up to QueueSize, newer frames are dropped beyond and the connection is closed once the
+// queue stayed full for SaturationTimeout, never when it is zero
+type SlowClientPolicy struct {
+	QueueSize         int
+	SaturationTimeout time.Duration
+	// CoalescedEvents are the event types carrying the whole state of what they describe, such as
+	// the viewers of a chat. A queued one is replaced by a newer one about the same chat and user
+	CoalescedEvents []string
+}
+
+// Envelopes of the chat messages sent to a connection
+const (
+	// EnvelopeV1 sends chat messages as bare frames, the only envelope older apps understand
+	EnvelopeV1 = 1
+	// EnvelopeV2 wraps chat messages in an event of type "message" like every other event
+	EnvelopeV2 = 2
+)
+
+// messageFrameType is the type of the chat messages wrapped for EnvelopeV2
+const messageFrameType = "message"
+
+type queuedFrame struct {
+	data     []byte
+	queuedAt time.Time
+	// key identifies the coalesced events superseding each other, empty for the other frames
+	key string
+}
+
 type UserClient struct {
 	UserId string
-	hub    IHub
-	conn   *websocket.Conn
-	send   chan []byte
+	// ConnectionId identifies this connection among the user's devices
+	ConnectionId string
+	// Client is the app holding the connection as it reported itself and Envelope how its chat
+	// messages are framed, EnvelopeV1 when zero. Both are set before the client is registered
+	Client   entity.ClientInfo
+	Envelope int
+	// Rooms are the chats whose rooms the user joins on this server when the client is registered
+	Rooms     []string
+	hub       IHub
+	conn      *websocket.Conn
+	heartbeat Heartbeat
+	policy    SlowClientPolicy
+	// lastSeen is when the peer was last heard from in unix nanoseconds, a frame or a pong
+	lastSeen atomic.Int64
+
+	queueMu sync.Mutex
+	queue   []queuedFrame
+	// closed is set once the hub unregistered the client, the frames left are still written
+	closed bool
+	// saturatedSince is when the queue became full, zero while it has room
+	saturatedSince time.Time
+	dropped        int
+	// ready is signaled when a frame is queued or the client is closed
+	ready chan struct{}
+
+	filterMu sync.RWMutex
+	// unsubscribed are the event types the connection asked not to get, the hub drops them
+	// before they are queued
+	unsubscribed map[string]bool
 }
 
-func NewClient(userId string, hub IHub, conn *websocket.Conn) *UserClient {
+// ClientStats describes the send buffer of a connection, a consumer that can't keep up
+// shows a filling buffer and an old oldest frame before frames start being dropped
+type ClientStats struct {
+	UserId       string `json:"userId"`
+	ConnectionId string `json:"connectionId"`
+	Queued       int    `json:"queued"`
+	Capacity     int    `json:"capacity"`
+	// OldestQueuedSeconds is how long the next frame to be written has been waiting
+	OldestQueuedSeconds float64 `json:"oldestQueuedSeconds"`
+	// Dropped is the number of frames dropped because the buffer was full
+	Dropped int `json:"dropped"`
+	// Client is the app holding the connection, Envelope how its chat messages are framed
+	Client   entity.ClientInfo `json:"client"`
+	Envelope int               `json:"envelope"`
+}
+
+func NewClient(userId string, hub IHub, conn *websocket.Conn, heartbeat Heartbeat, policy SlowClientPolicy) *UserClient {
+	client := &UserClient{
+		UserId:       userId,
+		ConnectionId: uuid.New().String(),
+		hub:          hub,
+		conn:         conn,
+		heartbeat:    heartbeat,
+		policy:       policy,
+		ready:        make(chan struct{}, 1),
+	}
+	client.lastSeen.Store(time.Now().UnixNano())
+	return client
+}
+
+// NewStreamClient creates a client without a websocket, another transport such as
+// server-sent events reads its outgoing messages through Messages
+func NewStreamClient(userId string, hub IHub) *UserClient {
 	return &UserClient{
-		UserId: userId,
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
+		UserId:       userId,
+		ConnectionId: uuid.New().String(),
+		hub:          hub,
+		policy:       SlowClientPolicy{QueueSize: streamQueueSize},
+		ready:        make(chan struct{}, 1),
+	}
+}
+
+// Receive returns the next outgoing message of the client, ok is false once the hub unregistered the client
+func (c *UserClient) Receive() (message []byte, ok bool) {
+	for {
+		message, ok, closed := c.dequeue()
+		if ok || closed {
+			return message, ok
+		}
+		<-c.ready
+	}
+}
+
+// Send queues a frame for this connection only, such as the answer to a frame it sent. It
+// reports false when the frame was dropped
+func (c *UserClient) Send(message []byte) bool {
+	return c.enqueue(message)
+}
+
+// enqueue queues a frame without blocking, it reports false when the frame was dropped because
+// the queue is full
+func (c *UserClient) enqueue(message []byte) bool {
+	if c.Envelope == EnvelopeV2 {
+		message = wrapMessageFrame(message)
+	}
+	key := c.coalesceKey(message)
+
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	if key != "" {
+		for i := range c.queue {
+			if c.queue[i].key == key {
+				// The newer state takes the place of the queued one
+				c.queue[i].data = message
+				backpressure.coalesced.Add(1)
+				return true
+			}
+		}
+	}
+
+	if len(c.queue) >= c.policy.QueueSize {
+		if c.saturatedSince.IsZero() {
+			c.saturatedSince = time.Now()
+		}
+		c.dropped++
+		backpressure.dropped.Add(1)
+		return false
+	}
+
+	c.queue = append(c.queue, queuedFrame{data: message, queuedAt: time.Now(), key: key})
+	c.signal()
+	return true
+}
+
+// dequeue takes the next frame out of the queue, closed is true once the queue is empty and
+// the hub unregistered the client
+func (c *UserClient) dequeue() (message []byte, ok bool, closed bool) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if len(c.queue) == 0 {
+		return nil, false, c.closed
+	}
+
+	message = c.queue[0].data
+	c.queue[0] = queuedFrame{}
+	c.queue = c.queue[1:]
+	if len(c.queue) < c.policy.QueueSize {
+		c.saturatedSince = time.Time{}
+	}
+	return message, true, false
+}
+
+// close stops queueing frames, the consumer gets the ones left then sees the client closed
+func (c *UserClient) close() {
+	c.queueMu.Lock()
+	c.closed = true
+	c.queueMu.Unlock()
+
+	c.signal()
+}
+
+func (c *UserClient) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
 	}
 }
 
+// coalesceKey returns the type of a coalesced event with the chat and user it is about, empty
+// for the other frames
+func (c *UserClient) coalesceKey(message []byte) string {
+	coalesced := false
+	for _, eventType := range c.policy.CoalescedEvents {
+		if bytes.Contains(message, []byte(`"`+eventType+`"`)) {
+			coalesced = true
+			break
+		}
+	}
+	if !coalesced {
+		return ""
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			ChatId string `json:"chatId"`
+			UserId string `json:"userId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message, &event); err != nil || !slices.Contains(c.policy.CoalescedEvents, event.Type) {
+		return ""
+	}
+	return event.Type + "/" + event.Data.ChatId + "/" + event.Data.UserId
+}
+
+// Unsubscribe stops the events of the types from being sent to this connection
+func (c *UserClient) Unsubscribe(eventTypes ...string) {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+
+	if c.unsubscribed == nil {
+		c.unsubscribed = make(map[string]bool)
+	}
+	for _, eventType := range eventTypes {
+		c.unsubscribed[eventType] = true
+	}
+}
+
+// Subscribe sends the events of the types to this connection again
+func (c *UserClient) Subscribe(eventTypes ...string) {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+
+	for _, eventType := range eventTypes {
+		delete(c.unsubscribed, eventType)
+	}
+}
+
+// filtering reports whether the connection unsubscribed from any event type
+func (c *UserClient) filtering() bool {
+	c.filterMu.RLock()
+	defer c.filterMu.RUnlock()
+	return len(c.unsubscribed) > 0
+}
+
+// subscribed reports whether the connection gets the events of the type, frames without a type
+// such as chat messages always go through
+func (c *UserClient) subscribed(eventType string) bool {
+	c.filterMu.RLock()
+	defer c.filterMu.RUnlock()
+	return eventType == "" || !c.unsubscribed[eventType]
+}
+
+// frameType returns the type of an event frame, empty for the other frames
+func frameType(message []byte) string {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(message, &event); err != nil {
+		return ""
+	}
+	return event.Type
+}
+
+// wrapMessageFrame wraps a bare chat message in an event of type "message", the event frames
+// are returned as they are
+func wrapMessageFrame(message []byte) []byte {
+	if frameType(message) != "" {
+		return message
+	}
+
+	prefix := `{"type":"` + messageFrameType + `","data":`
+	framed := make([]byte, 0, len(prefix)+len(message)+1)
+	framed = append(framed, prefix...)
+	framed = append(framed, message...)
+	return append(framed, '}')
+}
+
+// seen pushes the read deadline back, the peer just proved it is alive
+func (c *UserClient) seen() {
+	now := time.Now()
+	c.lastSeen.Store(now.UnixNano())
+	c.conn.SetReadDeadline(now.Add(c.heartbeat.PongTimeout))
+}
+
+// unresponsive reports whether the websocket stayed silent past its pong timeout. Stream clients
+// have no heartbeat, their transport notices the peer going away
+func (c *UserClient) unresponsive(now time.Time) bool {
+	if c.conn == nil {
+		return false
+	}
+	return now.Sub(time.Unix(0, c.lastSeen.Load())) > c.heartbeat.PongTimeout
+}
+
+// saturated reports whether the queue stayed full past the saturation timeout of the policy
+func (c *UserClient) saturated(now time.Time) bool {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	return c.policy.SaturationTimeout > 0 && !c.saturatedSince.IsZero() && now.Sub(c.saturatedSince) > c.policy.SaturationTimeout
+}
+
+// Stats returns the occupancy of the send buffer and the age of its oldest frame
+func (c *UserClient) Stats(now time.Time) ClientStats {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	stats := ClientStats{
+		UserId:       c.UserId,
+		ConnectionId: c.ConnectionId,
+		Queued:       len(c.queue),
+		Capacity:     c.policy.QueueSize,
+		Dropped:      c.dropped,
+		Client:       c.Client,
+		Envelope:     max(c.Envelope, EnvelopeV1),
+	}
+	if len(c.queue) > 0 {
+		stats.OldestQueuedSeconds = now.Sub(c.queue[0].queuedAt).Seconds()
+	}
+	return stats
+}
+
 func (c *UserClient) ReadPump(handler func([]byte)) {
 	defer func() {
 		c.hub.UnregisterClient(c)
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.seen()
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.seen()
 		return nil
 	})
 
@@ -46,16 +375,17 @@ func (c *UserClient) ReadPump(handler func([]byte)) {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Error: %v", err)
+				slog.Warn("Unexpected websocket close", "user_id", c.UserId, "connection_id", c.ConnectionId, "error", err)
 			}
 			break
 		}
+		c.seen()
 		handler(message)
 	}
 }
 
 func (c *UserClient) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.heartbeat.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -63,15 +393,22 @@ func (c *UserClient) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case <-c.ready:
+			for {
+				message, ok, closed := c.dequeue()
+				if closed {
+					c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+					c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+					return
+				}
+				if !ok {
+					break
+				}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				return
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					return
+				}
 			}
 
 		case <-ticker.C:
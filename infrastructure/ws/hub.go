@@ -1,22 +1,31 @@
 package ws
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Hub struct {
-	clients            map[string]*UserClient
+	// clients holds every connection of a user, keyed by userId then connectionId
+	clients map[string]map[string]*UserClient
+	// rooms groups the connected users by chat
+	rooms              rooms
 	broadcast          chan []byte
 	Register           chan *UserClient
 	Unregister         chan *UserClient
 	mu                 sync.RWMutex
 	OnClientUnregister func(client *UserClient) error
+	OnRoomDelivered    RoomDelivered
 }
 
 func NewHub() IHub {
 	return &Hub{
-		clients:    make(map[string]*UserClient),
+		clients:    make(map[string]map[string]*UserClient),
+		rooms:      newRooms(),
 		broadcast:  make(chan []byte, 256),
 		Register:   make(chan *UserClient),
 		Unregister: make(chan *UserClient),
@@ -24,37 +33,31 @@ func NewHub() IHub {
 }
 
 func (h *Hub) Run() {
+	reaper := time.NewTicker(reapInterval)
+	defer reaper.Stop()
+
 	for {
 		select {
 		case client := <-h.Register:
 			h.mu.Lock()
-			h.clients[client.UserId] = client
+			addConnection(h.clients, client)
+			h.rooms.joinAll(client.UserId, client.Rooms)
 			h.mu.Unlock()
-			log.Printf("%s is connected", client.UserId)
+			slog.Info("Client connected", "user_id", client.UserId, "connection_id", client.ConnectionId)
 
 		case client := <-h.Unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[client.UserId]; ok {
-				delete(h.clients, client.UserId)
-				close(client.send)
-				log.Printf("%s is disconnected", client.UserId)
-			}
-			h.mu.Unlock()
+			h.unregister(client)
 
-			if h.OnClientUnregister != nil {
-				if err := h.OnClientUnregister(client); err != nil {
-					log.Printf("OnClientUnregister error: %v", err)
-				}
-			}
+		case <-reaper.C:
+			reapClients(&h.mu, h.clients, h.unregister)
 
 		case message := <-h.broadcast:
 			h.mu.RLock()
-			for userId, client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, userId)
+			for userId, connections := range h.clients {
+				for _, client := range connections {
+					if !client.enqueue(message) {
+						slog.Warn("Failed to broadcast to client", "user_id", userId, "connection_id", client.ConnectionId)
+					}
 				}
 			}
 			h.mu.RUnlock()
@@ -62,38 +65,306 @@ func (h *Hub) Run() {
 	}
 }
 
+func (h *Hub) unregister(client *UserClient) {
+	h.mu.Lock()
+	removed, lastConnection := removeConnection(h.clients, client)
+	if removed {
+		client.close()
+		slog.Info("Client disconnected", "user_id", client.UserId, "connection_id", client.ConnectionId)
+	}
+	if lastConnection {
+		h.rooms.leaveAll(client.UserId)
+	}
+	h.mu.Unlock()
+
+	// The user only goes offline once their last device disconnects
+	if lastConnection && h.OnClientUnregister != nil {
+		if err := h.OnClientUnregister(client); err != nil {
+			slog.Error("OnClientUnregister error", "user_id", client.UserId, "connection_id", client.ConnectionId, "error", err)
+		}
+	}
+}
+
 func (h *Hub) Broadcast(message []byte) {
 	h.broadcast <- message
 }
 
-func (h *Hub) SendToClient(clientID string, message []byte) {
+// SendToClient delivers the message to every connection of the user
+func (h *Hub) SendToClient(ctx context.Context, clientID string, message []byte) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	client, exists := h.clients[clientID]
-	if exists {
-		select {
-		case client.send <- message:
-		default:
-			log.Printf("Failed to send to client: %s", clientID)
+	return deliver(h.clients[clientID], message, func(client *UserClient) {
+		slog.Warn("Failed to send to client", "user_id", clientID, "connection_id", client.ConnectionId)
+	})
+}
+
+// SendToUsers delivers the message to every connection of the users under a single lock
+func (h *Hub) SendToUsers(ctx context.Context, userIDs []string, message []byte) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sent := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		delivered := deliver(h.clients[userID], message, func(client *UserClient) {
+			slog.Warn("Failed to send to client", "user_id", userID, "connection_id", client.ConnectionId)
+		})
+		if delivered {
+			sent = append(sent, userID)
 		}
 	}
+	return sent
+}
+
+// JoinRoom adds the user to the room when they are connected, the rooms of the users connecting
+// later come with their client
+func (h *Hub) JoinRoom(ctx context.Context, roomID string, userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.clients[userID]) > 0 {
+		h.rooms.join(roomID, userID)
+	}
+}
+
+func (h *Hub) LeaveRoom(ctx context.Context, roomID string, userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rooms.leave(roomID, userID)
 }
 
+// SendToRoom delivers the message to every connection of the members of the room under a single lock
+func (h *Hub) SendToRoom(ctx context.Context, roomID string, message []byte, send RoomSend) {
+	h.mu.RLock()
+	sent := deliverToRoom(h.clients, h.rooms, roomID, message, send, "")
+	h.mu.RUnlock()
+
+	if send.Track && len(sent) > 0 && h.OnRoomDelivered != nil {
+		h.OnRoomDelivered(ctx, roomID, sent, message)
+	}
+}
+
+// IsConnected reports whether the user has an open connection
+func (h *Hub) IsConnected(clientID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[clientID]) > 0
+}
+
+// GetClientCount returns the number of open connections
 func (h *Hub) GetClientCount() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return len(h.clients)
+	return countConnections(h.clients)
+}
+
+// Stats returns the send buffer of every open connection, slowest consumers first
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return collectStats(h.clients)
+}
+
+// LocalUserIds returns the users with an open connection
+func (h *Hub) LocalUserIds() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return userIds(h.clients)
+}
+
+// Reconcile has nothing to do, a single server shares its presence with no one
+func (h *Hub) Reconcile(ctx context.Context) (int, error) {
+	return 0, nil
 }
 
 func (h *Hub) RegisterClient(client *UserClient) {
-    h.Register <- client
+	h.Register <- client
 }
 
 func (h *Hub) UnregisterClient(client *UserClient) {
-    h.Unregister <- client
+	h.Unregister <- client
 }
 
 func (h *Hub) SetOnClientUnregister(callback func(client *UserClient) error) {
-    h.OnClientUnregister = callback
+	h.OnClientUnregister = callback
+}
+
+func (h *Hub) SetOnRoomDelivered(callback RoomDelivered) {
+	h.OnRoomDelivered = callback
+}
+
+// deliver queues the message on the connections of a user subscribed to its event and calls
+// dropped for the ones whose queue is full. It reports whether the user has it, a connection that
+// unsubscribed from the event counts as served since it asked not to get it
+func deliver(connections map[string]*UserClient, message []byte, dropped func(client *UserClient)) bool {
+	eventType, typed := "", false
+
+	sent := false
+	for _, client := range connections {
+		if client.filtering() {
+			// Only the connections filtering events pay for decoding the frame
+			if !typed {
+				eventType, typed = frameType(message), true
+			}
+			if !client.subscribed(eventType) {
+				backpressure.filtered.Add(1)
+				sent = true
+				continue
+			}
+		}
+
+		if client.enqueue(message) {
+			sent = true
+		} else {
+			dropped(client)
+		}
+	}
+	return sent
+}
+
+func addConnection(clients map[string]map[string]*UserClient, client *UserClient) {
+	connections, ok := clients[client.UserId]
+	if !ok {
+		connections = make(map[string]*UserClient)
+		clients[client.UserId] = connections
+	}
+	connections[client.ConnectionId] = client
+}
+
+// removeConnection reports whether the connection was registered and whether it was the user's last one
+func removeConnection(clients map[string]map[string]*UserClient, client *UserClient) (bool, bool) {
+	connections, ok := clients[client.UserId]
+	if !ok {
+		return false, false
+	}
+	if _, ok := connections[client.ConnectionId]; !ok {
+		return false, false
+	}
+
+	delete(connections, client.ConnectionId)
+	if len(connections) > 0 {
+		return true, false
+	}
+
+	delete(clients, client.UserId)
+	return true, true
+}
+
+// reapInterval is how often the hubs look for connections to close, websockets that stopped
+// answering pings and clients that stopped reading
+const reapInterval = 10 * time.Second
+
+// backpressure counts what the slow client policies did since the server started, and the frames
+// the connections unsubscribed from
+var backpressure struct {
+	dropped      atomic.Int64
+	coalesced    atomic.Int64
+	disconnected atomic.Int64
+	filtered     atomic.Int64
+}
+
+// reapClients closes and unregisters the websockets that stayed silent past their pong timeout,
+// their read deadline normally ends them but this catches the ones whose pumps are stuck and
+// would keep the user online. Clients whose queue stayed full past the saturation timeout of
+// their policy are closed too, they would only miss more frames
+func reapClients(mu *sync.RWMutex, clients map[string]map[string]*UserClient, unregister func(client *UserClient)) {
+	now := time.Now()
+	var unresponsive, slow []*UserClient
+
+	mu.RLock()
+	for _, connections := range clients {
+		for _, client := range connections {
+			if client.unresponsive(now) {
+				unresponsive = append(unresponsive, client)
+			} else if client.saturated(now) {
+				slow = append(slow, client)
+			}
+		}
+	}
+	mu.RUnlock()
+
+	for _, client := range unresponsive {
+		slog.Warn("Reaping unresponsive client", "user_id", client.UserId, "connection_id", client.ConnectionId)
+		disconnect(client, unregister)
+	}
+	for _, client := range slow {
+		slog.Warn("Disconnecting slow client", "user_id", client.UserId, "connection_id", client.ConnectionId, "queued", client.Stats(now).Queued)
+		backpressure.disconnected.Add(1)
+		disconnect(client, unregister)
+	}
+}
+
+// disconnect unregisters the client and closes its websocket, which ends both of its pumps
+func disconnect(client *UserClient, unregister func(client *UserClient)) {
+	if client.conn != nil {
+		client.conn.Close()
+	}
+	unregister(client)
+}
+
+// HubStats is the state of the send buffers of the connections held by a server
+type HubStats struct {
+	Connections int `json:"connections"`
+	// Queued is the number of frames waiting in every send buffer
+	Queued              int     `json:"queued"`
+	OldestQueuedSeconds float64 `json:"oldestQueuedSeconds"`
+	// Dropped, Coalesced and SlowDisconnects count what the slow client policies did since the
+	// server started: frames dropped from full buffers, queued events replaced by newer ones and
+	// connections closed for staying saturated
+	Dropped         int64 `json:"dropped"`
+	Coalesced       int64 `json:"coalesced"`
+	SlowDisconnects int64 `json:"slowDisconnects"`
+	// Filtered counts the frames not queued because their connection unsubscribed from their event
+	Filtered int64         `json:"filtered"`
+	Clients  []ClientStats `json:"clients"`
+	// Shadow compares the transports of a hub running in shadow mode, see NewShadowRedisHub
+	Shadow *ShadowStats `json:"shadow,omitempty"`
+}
+
+func collectStats(clients map[string]map[string]*UserClient) HubStats {
+	now := time.Now()
+	stats := HubStats{
+		Dropped:         backpressure.dropped.Load(),
+		Coalesced:       backpressure.coalesced.Load(),
+		SlowDisconnects: backpressure.disconnected.Load(),
+		Filtered:        backpressure.filtered.Load(),
+		Clients:         make([]ClientStats, 0, countConnections(clients)),
+	}
+
+	for _, connections := range clients {
+		for _, client := range connections {
+			clientStats := client.Stats(now)
+			stats.Connections++
+			stats.Queued += clientStats.Queued
+			if clientStats.OldestQueuedSeconds > stats.OldestQueuedSeconds {
+				stats.OldestQueuedSeconds = clientStats.OldestQueuedSeconds
+			}
+			stats.Clients = append(stats.Clients, clientStats)
+		}
+	}
+
+	sort.Slice(stats.Clients, func(i, j int) bool {
+		if stats.Clients[i].OldestQueuedSeconds != stats.Clients[j].OldestQueuedSeconds {
+			return stats.Clients[i].OldestQueuedSeconds > stats.Clients[j].OldestQueuedSeconds
+		}
+		return stats.Clients[i].Queued > stats.Clients[j].Queued
+	})
+
+	return stats
+}
+
+func userIds(clients map[string]map[string]*UserClient) []string {
+	ids := make([]string, 0, len(clients))
+	for userId := range clients {
+		ids = append(ids, userId)
+	}
+	return ids
+}
+
+func countConnections(clients map[string]map[string]*UserClient) int {
+	count := 0
+	for _, connections := range clients {
+		count += len(connections)
+	}
+	return count
 }
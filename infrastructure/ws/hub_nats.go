@@ -0,0 +1,729 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"wetalk/pkg/tracing"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	natsUserSubjectPrefix = "wetalk.user."
+	// natsRoomSubjectPrefix starts the subjects of the rooms, a server subscribes to the rooms it
+	// holds members of
+	natsRoomSubjectPrefix = "wetalk.room."
+	// natsPresenceSubject carries the announcements of the users held by every server
+	natsPresenceSubject = "wetalk.presence"
+	// natsPresenceBatch keeps the heartbeats well under the default 1MB payload limit of NATS
+	natsPresenceBatch = 1000
+)
+
+// NATSHub shares connections between servers through NATS. A server subscribes to the subject of
+// every user holding a connection on it, so a message published to that subject reaches each
+// server of the user. Servers announce their users on a presence subject to know who is online
+// elsewhere, an announcement is forgotten once it is older than USER_HEARTBEAT_EXPIRY
+type NATSHub struct {
+	// Local connections (in-memory map), keyed by userId then connectionId
+	clients map[string]map[string]*UserClient
+	// userSubs are the subscriptions to the subjects of the local users
+	userSubs map[string]*nats.Subscription
+	// rooms groups the local users by chat, roomSubs are the subscriptions to the subjects of its rooms
+	rooms    rooms
+	roomSubs map[string]*nats.Subscription
+	mu       sync.RWMutex
+
+	// remoteUsers is the last heartbeat of the other servers holding a user, keyed by userId then serverId
+	remoteUsers map[string]map[string]time.Time
+	remoteMu    sync.RWMutex
+
+	conn     *nats.Conn
+	serverID string
+	tracer   trace.Tracer
+
+	// Channels
+	Register   chan *UserClient
+	Unregister chan *UserClient
+	broadcast  chan []byte
+
+	// Callbacks
+	OnClientUnregister func(client *UserClient) error
+	OnRoomDelivered    RoomDelivered
+}
+
+// NATSMessage is the envelope published to the subject of a user or of a room
+type NATSMessage struct {
+	FromServerID string `json:"fromServerId"`
+	ToUserID     string `json:"toUserId"`
+	EventType    string `json:"eventType"`
+	Payload      []byte `json:"payload"`
+	// RoomID is the room a message published to its subject is for, RoomSend tunes it. On the
+	// subject of a user, RoomOp has the user join or leave the room instead
+	RoomID   string   `json:"roomId,omitempty"`
+	RoomSend RoomSend `json:"roomSend"`
+	RoomOp   string   `json:"roomOp,omitempty"`
+	// TraceParent continues the sender's trace on the receiving server
+	TraceParent string `json:"traceParent,omitempty"`
+}
+
+// natsPresence announces the users a server holds, or the ones it no longer holds
+type natsPresence struct {
+	ServerID string   `json:"serverId"`
+	UserIDs  []string `json:"userIds,omitempty"`
+	Online   bool     `json:"online"`
+	// Sync asks the other servers to announce their users right away, sent by servers that just
+	// (re)connected and know nothing about the others
+	Sync bool `json:"sync,omitempty"`
+}
+
+// NewNATSHub creates a hub sharing connections through NATS. A lost connection to NATS is dialed
+// again without end and the client restores the subscriptions, the messages published meanwhile
+// are buffered by the client and sent once it is back. The tracer follows messages across servers
+func NewNATSHub(natsURL string, serverID string, tracer trace.Tracer) (IHub, error) {
+	h := &NATSHub{
+		clients:     make(map[string]map[string]*UserClient),
+		userSubs:    make(map[string]*nats.Subscription),
+		rooms:       newRooms(),
+		roomSubs:    make(map[string]*nats.Subscription),
+		remoteUsers: make(map[string]map[string]time.Time),
+		serverID:    serverID,
+		tracer:      tracer,
+		Register:    make(chan *UserClient),
+		Unregister:  make(chan *UserClient),
+		broadcast:   make(chan []byte, 256),
+	}
+
+	conn, err := nats.Connect(natsURL,
+		nats.Name("wetalk-"+serverID),
+		nats.MaxReconnects(-1),
+		nats.ReconnectHandler(h.onReconnect),
+		nats.DisconnectErrHandler(h.onDisconnect),
+		nats.ErrorHandler(h.onError),
+	)
+	if err != nil {
+		return nil, err
+	}
+	h.conn = conn
+
+	return h, nil
+}
+
+func (h *NATSHub) Run() {
+	if _, err := h.conn.Subscribe(natsPresenceSubject, h.receivePresence); err != nil {
+		slog.Error("Subscribe to NATS presence error", "server_id", h.serverID, "error", err)
+	}
+	h.publishPresence(natsPresence{ServerID: h.serverID, Online: true, Sync: true})
+	h.startUserHeartbeat()
+
+	reaper := time.NewTicker(reapInterval)
+	defer reaper.Stop()
+
+	for {
+		select {
+		case client := <-h.Register:
+			h.mu.Lock()
+			addConnection(h.clients, client)
+			firstConnection := len(h.clients[client.UserId]) == 1
+			if firstConnection {
+				h.subscribeUser(client.UserId)
+			}
+			for _, roomID := range h.rooms.joinAll(client.UserId, client.Rooms) {
+				h.subscribeRoom(roomID)
+			}
+			h.mu.Unlock()
+
+			// Announce this user has a connection on this server
+			if firstConnection {
+				h.publishPresence(natsPresence{ServerID: h.serverID, UserIDs: []string{client.UserId}, Online: true})
+			}
+
+			slog.Info("Client connected", "server_id", h.serverID, "user_id", client.UserId, "connection_id", client.ConnectionId)
+
+		case client := <-h.Unregister:
+			h.unregister(client)
+
+		case <-reaper.C:
+			reapClients(&h.mu, h.clients, h.unregister)
+
+		case message := <-h.broadcast:
+			h.broadcastLocal(message)
+		}
+	}
+}
+
+func (h *NATSHub) unregister(client *UserClient) {
+	h.mu.Lock()
+	removed, lastConnection := removeConnection(h.clients, client)
+	if removed {
+		client.close()
+		slog.Info("Client disconnected", "server_id", h.serverID, "user_id", client.UserId, "connection_id", client.ConnectionId)
+	}
+	if lastConnection {
+		h.unsubscribeUser(client.UserId)
+		for _, roomID := range h.rooms.leaveAll(client.UserId) {
+			h.unsubscribeRoom(roomID)
+		}
+	}
+	h.mu.Unlock()
+
+	if !lastConnection {
+		return
+	}
+
+	// Remove this server from the user's presence
+	h.publishPresence(natsPresence{ServerID: h.serverID, UserIDs: []string{client.UserId}, Online: false})
+
+	if h.OnClientUnregister != nil {
+		if err := h.OnClientUnregister(client); err != nil {
+			slog.Error("OnClientUnregister error", "user_id", client.UserId, "connection_id", client.ConnectionId, "error", err)
+		}
+	}
+}
+
+// natsUserSubject is the subject of a user, the characters NATS gives a meaning to are replaced
+func natsUserSubject(userID string) string {
+	return natsUserSubjectPrefix + natsToken(userID)
+}
+
+// natsRoomSubject is the subject of a room
+func natsRoomSubject(roomID string) string {
+	return natsRoomSubjectPrefix + natsToken(roomID)
+}
+
+func natsToken(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, id)
+}
+
+// subscribeUser starts receiving the messages of a user, h.mu must be held
+func (h *NATSHub) subscribeUser(userID string) {
+	sub, err := h.conn.Subscribe(natsUserSubject(userID), h.receive)
+	if err != nil {
+		// Subscriptions made while NATS is unreachable are sent once it is back, only a closed
+		// connection fails them
+		slog.Error("Subscribe to NATS user subject error", "server_id", h.serverID, "user_id", userID, "error", err)
+		return
+	}
+	h.userSubs[userID] = sub
+}
+
+// unsubscribeUser stops receiving the messages of a user, h.mu must be held
+func (h *NATSHub) unsubscribeUser(userID string) {
+	sub, ok := h.userSubs[userID]
+	if !ok {
+		return
+	}
+	delete(h.userSubs, userID)
+
+	if err := sub.Unsubscribe(); err != nil {
+		slog.Error("Unsubscribe from NATS user subject error", "server_id", h.serverID, "user_id", userID, "error", err)
+	}
+}
+
+// subscribeRoom starts receiving the messages of a room, h.mu must be held
+func (h *NATSHub) subscribeRoom(roomID string) {
+	sub, err := h.conn.Subscribe(natsRoomSubject(roomID), h.receiveRoom)
+	if err != nil {
+		// Subscriptions made while NATS is unreachable are sent once it is back, only a closed
+		// connection fails them
+		slog.Error("Subscribe to NATS room subject error", "server_id", h.serverID, "room_id", roomID, "error", err)
+		return
+	}
+	h.roomSubs[roomID] = sub
+}
+
+// unsubscribeRoom stops receiving the messages of a room, h.mu must be held
+func (h *NATSHub) unsubscribeRoom(roomID string) {
+	sub, ok := h.roomSubs[roomID]
+	if !ok {
+		return
+	}
+	delete(h.roomSubs, roomID)
+
+	if err := sub.Unsubscribe(); err != nil {
+		slog.Error("Unsubscribe from NATS room subject error", "server_id", h.serverID, "room_id", roomID, "error", err)
+	}
+}
+
+// receive delivers a message published to the subject of a local user by another server
+func (h *NATSHub) receive(msg *nats.Msg) {
+	var natsMsg NATSMessage
+	if err := json.Unmarshal(msg.Data, &natsMsg); err != nil {
+		slog.Error("Unmarshal NATS message error", "server_id", h.serverID, "error", err)
+		return
+	}
+
+	// The sender already delivered to its own connections
+	if natsMsg.FromServerID == h.serverID {
+		return
+	}
+
+	slog.Debug("Received message from NATS", "server_id", h.serverID, "from_server_id", natsMsg.FromServerID,
+		"event", natsMsg.EventType, "user_id", natsMsg.ToUserID)
+
+	if natsMsg.RoomOp != "" {
+		h.applyRoomOp(natsMsg.RoomOp, natsMsg.RoomID, natsMsg.ToUserID)
+		return
+	}
+
+	ctx := tracing.WithTraceParent(context.Background(), natsMsg.TraceParent)
+	_, span := h.tracer.Start(ctx, "nats.receive", trace.WithSpanKind(trace.SpanKindConsumer))
+	span.SetAttributes(
+		attribute.String("messaging.source.name", natsMsg.FromServerID),
+		attribute.String("wetalk.event", natsMsg.EventType),
+		attribute.Bool("wetalk.delivered", h.sendLocal(natsMsg.ToUserID, natsMsg.Payload)),
+	)
+	span.End()
+}
+
+// receiveRoom delivers a message published to the subject of a room by another server
+func (h *NATSHub) receiveRoom(msg *nats.Msg) {
+	var natsMsg NATSMessage
+	if err := json.Unmarshal(msg.Data, &natsMsg); err != nil {
+		slog.Error("Unmarshal NATS message error", "server_id", h.serverID, "error", err)
+		return
+	}
+
+	// The sender already delivered to its own connections
+	if natsMsg.FromServerID == h.serverID {
+		return
+	}
+
+	ctx := tracing.WithTraceParent(context.Background(), natsMsg.TraceParent)
+	ctx, span := h.tracer.Start(ctx, "nats.receive", trace.WithSpanKind(trace.SpanKindConsumer))
+	span.SetAttributes(
+		attribute.String("messaging.source.name", natsMsg.FromServerID),
+		attribute.String("wetalk.event", natsMsg.EventType),
+		attribute.String("wetalk.room_id", natsMsg.RoomID),
+	)
+	sent := h.sendLocalToRoom(natsMsg.RoomID, natsMsg.Payload, natsMsg.RoomSend)
+	span.SetAttributes(attribute.Int("wetalk.delivered", len(sent)))
+	span.End()
+
+	// Acking the members may take a while, keep reading the connection meanwhile
+	if natsMsg.RoomSend.Track && len(sent) > 0 && h.OnRoomDelivered != nil {
+		go h.OnRoomDelivered(ctx, natsMsg.RoomID, sent, natsMsg.Payload)
+	}
+}
+
+// JoinRoom adds the user to the room here when they are connected, and on the other servers
+// holding one of their connections through the subject of the user
+func (h *NATSHub) JoinRoom(ctx context.Context, roomID string, userID string) {
+	h.applyRoomOp(roomOpJoin, roomID, userID)
+	h.publishRoomOp(ctx, roomOpJoin, roomID, userID)
+}
+
+// LeaveRoom removes the user from the room here and on the other servers holding one of their connections
+func (h *NATSHub) LeaveRoom(ctx context.Context, roomID string, userID string) {
+	h.applyRoomOp(roomOpLeave, roomID, userID)
+	h.publishRoomOp(ctx, roomOpLeave, roomID, userID)
+}
+
+// applyRoomOp has a local user join or leave a room, the subject of the room is subscribed to
+// while it holds members here
+func (h *NATSHub) applyRoomOp(op string, roomID string, userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch op {
+	case roomOpJoin:
+		// The rooms of the users connecting later come with their client
+		if len(h.clients[userID]) > 0 && h.rooms.join(roomID, userID) {
+			h.subscribeRoom(roomID)
+		}
+	case roomOpLeave:
+		if h.rooms.leave(roomID, userID) {
+			h.unsubscribeRoom(roomID)
+		}
+	}
+}
+
+// publishRoomOp has the other servers holding the user apply a room operation
+func (h *NATSHub) publishRoomOp(ctx context.Context, op string, roomID string, userID string) {
+	if !h.isConnectedRemote(userID) {
+		return
+	}
+
+	msgBytes, err := json.Marshal(NATSMessage{
+		FromServerID: h.serverID,
+		ToUserID:     userID,
+		RoomID:       roomID,
+		RoomOp:       op,
+		TraceParent:  tracing.TraceParent(ctx),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Marshal NATS message error", "error", err)
+		return
+	}
+	if err := h.conn.Publish(natsUserSubject(userID), msgBytes); err != nil {
+		slog.ErrorContext(ctx, "Publish room operation to NATS error", "room_id", roomID, "user_id", userID, "error", err)
+	}
+}
+
+// SendToRoom delivers the message to the local members of the room, then publishes it once to
+// the subject of the room for the other servers holding members of it
+func (h *NATSHub) SendToRoom(ctx context.Context, roomID string, message []byte, send RoomSend) {
+	sent := h.sendLocalToRoom(roomID, message, send)
+	if send.Track && len(sent) > 0 && h.OnRoomDelivered != nil {
+		h.OnRoomDelivered(ctx, roomID, sent, message)
+	}
+
+	ctx, span := h.tracer.Start(ctx, "nats.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+	subject := natsRoomSubject(roomID)
+	eventType := payloadEventType(message)
+	span.SetAttributes(
+		attribute.String("messaging.destination.name", subject),
+		attribute.String("wetalk.event", eventType),
+	)
+
+	msgBytes, err := json.Marshal(NATSMessage{
+		FromServerID: h.serverID,
+		EventType:    eventType,
+		Payload:      message,
+		RoomID:       roomID,
+		RoomSend:     send,
+		TraceParent:  tracing.TraceParent(ctx),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Marshal NATS message error", "error", err)
+		tracing.RecordError(span, err)
+		return
+	}
+	if err := h.conn.Publish(subject, msgBytes); err != nil {
+		slog.ErrorContext(ctx, "Publish to NATS error", "room_id", roomID, "error", err)
+		tracing.RecordError(span, err)
+	}
+}
+
+// sendLocalToRoom delivers a message to the members of the room on this server, it returns the
+// members that got it
+func (h *NATSHub) sendLocalToRoom(roomID string, message []byte, send RoomSend) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return deliverToRoom(h.clients, h.rooms, roomID, message, send, h.serverID)
+}
+
+// Send to every device of a user, on this server and on the other servers holding a connection
+func (h *NATSHub) SendToClient(ctx context.Context, userID string, message []byte) bool {
+	sentLocal := h.sendLocal(userID, message)
+
+	sentRemote := false
+	if h.isConnectedRemote(userID) {
+		sentRemote = h.publishToNATS(ctx, userID, message)
+	}
+	return sentLocal || sentRemote
+}
+
+// SendToUsers sends to every device of the users. NATS buffers the publishes, so the subject of
+// every remote user is published to under a single span without waiting for the server
+func (h *NATSHub) SendToUsers(ctx context.Context, userIDs []string, message []byte) []string {
+	sent := make(map[string]bool, len(userIDs))
+	h.mu.RLock()
+	for _, userID := range userIDs {
+		delivered := deliver(h.clients[userID], message, func(client *UserClient) {
+			slog.Warn("Failed to send to local client", "server_id", h.serverID, "user_id", userID, "connection_id", client.ConnectionId)
+		})
+		if delivered {
+			sent[userID] = true
+		}
+	}
+	h.mu.RUnlock()
+
+	ctx, span := h.tracer.Start(ctx, "nats.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+	eventType := payloadEventType(message)
+	span.SetAttributes(attribute.String("wetalk.event", eventType))
+
+	for _, userID := range userIDs {
+		if !h.isConnectedRemote(userID) {
+			continue
+		}
+
+		msgBytes, err := json.Marshal(NATSMessage{
+			FromServerID: h.serverID,
+			ToUserID:     userID,
+			EventType:    eventType,
+			Payload:      message,
+			TraceParent:  tracing.TraceParent(ctx),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Marshal NATS message error", "error", err)
+			tracing.RecordError(span, err)
+			break
+		}
+		if err := h.conn.Publish(natsUserSubject(userID), msgBytes); err != nil {
+			slog.ErrorContext(ctx, "Publish to NATS error", "user_id", userID, "error", err)
+			tracing.RecordError(span, err)
+			continue
+		}
+		sent[userID] = true
+	}
+
+	users := make([]string, 0, len(sent))
+	for _, userID := range userIDs {
+		if sent[userID] {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
+
+// sendLocal delivers a message to the user's connections on this server
+func (h *NATSHub) sendLocal(userID string, message []byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return deliver(h.clients[userID], message, func(client *UserClient) {
+		slog.Warn("Failed to send to local client", "server_id", h.serverID, "user_id", userID, "connection_id", client.ConnectionId)
+	})
+}
+
+// publishToNATS publishes to the subject of the user, it reports whether the message left this server
+func (h *NATSHub) publishToNATS(ctx context.Context, userID string, message []byte) bool {
+	ctx, span := h.tracer.Start(ctx, "nats.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	subject := natsUserSubject(userID)
+	eventType := payloadEventType(message)
+	span.SetAttributes(
+		attribute.String("messaging.destination.name", subject),
+		attribute.String("wetalk.event", eventType),
+	)
+
+	natsMsg := NATSMessage{
+		FromServerID: h.serverID,
+		ToUserID:     userID,
+		EventType:    eventType,
+		Payload:      message,
+		TraceParent:  tracing.TraceParent(ctx),
+	}
+
+	msgBytes, err := json.Marshal(natsMsg)
+	if err != nil {
+		slog.ErrorContext(ctx, "Marshal NATS message error", "error", err)
+		tracing.RecordError(span, err)
+		return false
+	}
+
+	if err := h.conn.Publish(subject, msgBytes); err != nil {
+		slog.ErrorContext(ctx, "Publish to NATS error", "user_id", userID, "error", err)
+		tracing.RecordError(span, err)
+		return false
+	}
+
+	slog.DebugContext(ctx, "Published message to NATS", "server_id", h.serverID, "user_id", userID)
+	return true
+}
+
+// IsConnected reports whether the user has a connection here or on a server whose heartbeat is recent
+func (h *NATSHub) IsConnected(userID string) bool {
+	h.mu.RLock()
+	local := len(h.clients[userID]) > 0
+	h.mu.RUnlock()
+
+	return local || h.isConnectedRemote(userID)
+}
+
+func (h *NATSHub) isConnectedRemote(userID string) bool {
+	h.remoteMu.RLock()
+	defer h.remoteMu.RUnlock()
+
+	minSeen := time.Now().Add(-USER_HEARTBEAT_EXPIRY)
+	for _, seen := range h.remoteUsers[userID] {
+		if seen.After(minSeen) {
+			return true
+		}
+	}
+	return false
+}
+
+// receivePresence records the users announced by the other servers
+func (h *NATSHub) receivePresence(msg *nats.Msg) {
+	var presence natsPresence
+	if err := json.Unmarshal(msg.Data, &presence); err != nil {
+		slog.Error("Unmarshal NATS presence error", "server_id", h.serverID, "error", err)
+		return
+	}
+	if presence.ServerID == h.serverID {
+		return
+	}
+
+	now := time.Now()
+	h.remoteMu.Lock()
+	for _, userID := range presence.UserIDs {
+		servers, ok := h.remoteUsers[userID]
+		if presence.Online {
+			if !ok {
+				servers = make(map[string]time.Time)
+				h.remoteUsers[userID] = servers
+			}
+			servers[presence.ServerID] = now
+			continue
+		}
+
+		delete(servers, presence.ServerID)
+		if len(servers) == 0 {
+			delete(h.remoteUsers, userID)
+		}
+	}
+	h.remoteMu.Unlock()
+
+	// Answer off the reading goroutine of the connection
+	if presence.Sync {
+		go h.announceUsers()
+	}
+}
+
+func (h *NATSHub) publishPresence(presence natsPresence) {
+	data, err := json.Marshal(presence)
+	if err != nil {
+		slog.Error("Marshal NATS presence error", "server_id", h.serverID, "error", err)
+		return
+	}
+	if err := h.conn.Publish(natsPresenceSubject, data); err != nil {
+		slog.Error("Publish NATS presence error", "server_id", h.serverID, "error", err)
+	}
+}
+
+// announceUsers records that this server holds connections of its local users
+func (h *NATSHub) announceUsers() {
+	h.mu.RLock()
+	userIDs := userIds(h.clients)
+	h.mu.RUnlock()
+
+	for start := 0; start < len(userIDs); start += natsPresenceBatch {
+		end := min(start+natsPresenceBatch, len(userIDs))
+		h.publishPresence(natsPresence{ServerID: h.serverID, UserIDs: userIDs[start:end], Online: true})
+	}
+}
+
+// onReconnect catches up on the presence announcements missed while NATS was unreachable
+func (h *NATSHub) onReconnect(conn *nats.Conn) {
+	slog.Info("Reconnected to NATS", "server_id", h.serverID, "url", conn.ConnectedUrlRedacted())
+	go func() {
+		h.announceUsers()
+		h.publishPresence(natsPresence{ServerID: h.serverID, Online: true, Sync: true})
+	}()
+}
+
+func (h *NATSHub) onDisconnect(conn *nats.Conn, err error) {
+	if err != nil {
+		slog.Warn("Disconnected from NATS", "server_id", h.serverID, "error", err)
+	}
+}
+
+// onError reports the errors of the subscriptions, such as a slow handler dropping messages
+func (h *NATSHub) onError(conn *nats.Conn, sub *nats.Subscription, err error) {
+	subject := ""
+	if sub != nil {
+		subject = sub.Subject
+	}
+	slog.Error("NATS error", "server_id", h.serverID, "subject", subject, "error", err)
+}
+
+// pruneRemoteUsers forgets the announcements of the servers that stopped sending heartbeats
+func (h *NATSHub) pruneRemoteUsers() {
+	minSeen := time.Now().Add(-USER_HEARTBEAT_EXPIRY)
+
+	h.remoteMu.Lock()
+	defer h.remoteMu.Unlock()
+
+	for userID, servers := range h.remoteUsers {
+		for serverID, seen := range servers {
+			if seen.Before(minSeen) {
+				delete(servers, serverID)
+			}
+		}
+		if len(servers) == 0 {
+			delete(h.remoteUsers, userID)
+		}
+	}
+}
+
+// Broadcast to all local clients
+func (h *NATSHub) broadcastLocal(message []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for userId, connections := range h.clients {
+		for _, client := range connections {
+			if !client.enqueue(message) {
+				slog.Warn("Failed to broadcast to client", "server_id", h.serverID, "user_id", userId, "connection_id", client.ConnectionId)
+			}
+		}
+	}
+}
+
+func (h *NATSHub) Broadcast(message []byte) {
+	h.broadcast <- message
+}
+
+// GetClientCount returns the number of open connections on this server
+func (h *NATSHub) GetClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return countConnections(h.clients)
+}
+
+// Stats returns the send buffer of every connection on this server, slowest consumers first
+func (h *NATSHub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return collectStats(h.clients)
+}
+
+// LocalUserIds returns the users with an open connection on this server
+func (h *NATSHub) LocalUserIds() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return userIds(h.clients)
+}
+
+// Reconcile announces the local users again and asks the other servers to do the same, the
+// presence shared through NATS only lives in memory so nothing is left to correct
+func (h *NATSHub) Reconcile(ctx context.Context) (int, error) {
+	h.announceUsers()
+	h.publishPresence(natsPresence{ServerID: h.serverID, Online: true, Sync: true})
+	return 0, nil
+}
+
+func (h *NATSHub) RegisterClient(client *UserClient) {
+	h.Register <- client
+}
+
+func (h *NATSHub) UnregisterClient(client *UserClient) {
+	h.Unregister <- client
+}
+
+func (h *NATSHub) SetOnClientUnregister(callback func(client *UserClient) error) {
+	h.OnClientUnregister = callback
+}
+
+func (h *NATSHub) SetOnRoomDelivered(callback RoomDelivered) {
+	h.OnRoomDelivered = callback
+}
+
+func (h *NATSHub) startUserHeartbeat() {
+	ticker := time.NewTicker(USER_HEARTBEAT_TTL)
+
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			h.announceUsers()
+			h.pruneRemoteUsers()
+		}
+	}()
+}
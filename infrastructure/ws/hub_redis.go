@@ -2,231 +2,750 @@
 package ws
 
 import (
-    "context"
-    "encoding/json"
-    "log"
-    "sync"
-    "time"
-
-    "github.com/redis/go-redis/v9"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wetalk/pkg/tracing"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-    USER_HEARTBEAT_EXPIRY = 1 * time.Minute
-    USER_HEARTBEAT_TTL    = 30 * time.Second
+	USER_HEARTBEAT_EXPIRY = 1 * time.Minute
+	USER_HEARTBEAT_TTL    = 30 * time.Second
 )
 
-type RedisHub struct {
-    // Local connections (in-memory map)
-    clients    map[string]*UserClient
-    mu         sync.RWMutex
+// sendBatchSize bounds the users whose servers are looked up by one Redis pipeline in SendToUsers
+const sendBatchSize = 500
 
-    // Redis for distributed messaging
-    redisClient *redis.Client
-    pubsub      *redis.PubSub
-    serverID    string
+// reconcileScanCount is the number of keys asked for per SCAN call while reconciling the presence
+const reconcileScanCount = 1000
 
-    // Channels
-    Register   chan *UserClient
-    Unregister chan *UserClient
-    broadcast  chan []byte
+const REDIS_EVENT_MESSAGE = "message"
 
-    // Callbacks
-    OnClientUnregister func(client *UserClient) error
+type RedisHub struct {
+	// Local connections (in-memory map), keyed by userId then connectionId
+	clients map[string]map[string]*UserClient
+	// rooms groups the local users by chat
+	rooms rooms
+	mu    sync.RWMutex
+
+	// Redis for distributed messaging
+	redisClient *redis.Client
+	transport   serverTransport
+	serverID    string
+	tracer      trace.Tracer
+
+	// Channels
+	Register   chan *UserClient
+	Unregister chan *UserClient
+	broadcast  chan []byte
+
+	// Callbacks
+	OnClientUnregister func(client *UserClient) error
+	OnRoomDelivered    RoomDelivered
 }
 
+// RedisMessage is the envelope exchanged between servers. Besides the payload
+// it carries its target so a server only receives messages meant for it.
 type RedisMessage struct {
-    FromServerID string `json:"fromServerId"`
-    ToUserID     string `json:"toUserId"`
-    Payload      []byte `json:"payload"`
+	FromServerID   string `json:"fromServerId"`
+	TargetServerID string `json:"targetServerId"`
+	ToUserID       string `json:"toUserId"`
+	EventType      string `json:"eventType"`
+	Payload        []byte `json:"payload"`
+	// ToUserIDs replaces ToUserID when the envelope carries a message to several users of the server
+	ToUserIDs []string `json:"toUserIds,omitempty"`
+	// RoomID replaces the users when the envelope carries a message to the members of a room the
+	// server holds, RoomSend tunes it. With a RoomOp it has ToUserID join or leave the room instead
+	RoomID   string   `json:"roomId,omitempty"`
+	RoomSend RoomSend `json:"roomSend"`
+	RoomOp   string   `json:"roomOp,omitempty"`
+	// TraceParent continues the sender's trace on the receiving server
+	TraceParent string `json:"traceParent,omitempty"`
 }
 
-func NewRedisHub(redisAddr string, serverID string) IHub {
-    rdb := redis.NewClient(&redis.Options{
-        Addr: redisAddr,
-    })
+// serverTransport carries the envelopes from a server to another
+type serverTransport interface {
+	// Publish sends an envelope to a server, it reports whether the server will get it
+	Publish(ctx context.Context, targetServerID string, envelope []byte) (bool, error)
+	// Consume hands every envelope sent to this server to handle, it blocks forever
+	Consume(handle func(envelope []byte))
+}
 
-    hub := &RedisHub{
-        clients:     make(map[string]*UserClient),
-        redisClient: rdb,
-        serverID:    serverID,
-        Register:    make(chan *UserClient),
-        Unregister:  make(chan *UserClient),
-        broadcast:   make(chan []byte, 256),
-    }
+// NewRedisHub creates a hub sharing connections through Redis Pub/Sub, the hooks wrap every Redis
+// command. The tracer follows messages across servers. Messages published while
+// a server is briefly disconnected from Redis are lost, see NewRedisStreamHub
+func NewRedisHub(redisAddr string, serverID string, tracer trace.Tracer, hooks ...redis.Hook) IHub {
+	rdb := newRedisClient(redisAddr, hooks)
+	return newRedisHub(rdb, serverID, tracer, newPubSubTransport(rdb, serverID))
+}
 
-    // Subscribe to Redis channels
-    hub.pubsub = rdb.PSubscribe(context.Background(), "messages:*")
+func newRedisClient(redisAddr string, hooks []redis.Hook) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	for _, hook := range hooks {
+		rdb.AddHook(hook)
+	}
+	return rdb
+}
 
-    return hub
+func newRedisHub(rdb *redis.Client, serverID string, tracer trace.Tracer, transport serverTransport) *RedisHub {
+	return &RedisHub{
+		clients:     make(map[string]map[string]*UserClient),
+		rooms:       newRooms(),
+		redisClient: rdb,
+		transport:   transport,
+		serverID:    serverID,
+		tracer:      tracer,
+		Register:    make(chan *UserClient),
+		Unregister:  make(chan *UserClient),
+		broadcast:   make(chan []byte, 256),
+	}
 }
 
 func (h *RedisHub) Run() {
-    // Start Redis subscriber in separate goroutine
-    go h.subscribeRedis()
-    h.startUserHeartbeat()
-
-    for {
-        select {
-        case client := <-h.Register:
-            h.mu.Lock()
-            h.clients[client.UserId] = client
-            h.mu.Unlock()
-
-            // Announce this user is on this server
-            h.redisClient.Set(
-                context.Background(),
-                "user:"+client.UserId+":server",
-                h.serverID,
-                USER_HEARTBEAT_EXPIRY,
-            )
-
-            log.Printf("[%s] %s connected", h.serverID, client.UserId)
-
-        case client := <-h.Unregister:
-            h.mu.Lock()
-            if _, ok := h.clients[client.UserId]; ok {
-                delete(h.clients, client.UserId)
-                close(client.send)
-
-                // Remove from Redis
-                h.redisClient.Del(
-                    context.Background(),
-                    "user:"+client.UserId+":server",
-                )
-
-                log.Printf("[%s] %s disconnected", h.serverID, client.UserId)
-            }
-            h.mu.Unlock()
-
-            if h.OnClientUnregister != nil {
-                if err := h.OnClientUnregister(client); err != nil {
-                    log.Printf("OnClientUnregister error: %v", err)
-                }
-            }
-
-        case message := <-h.broadcast:
-            h.broadcastLocal(message)
-        }
-    }
-}
-
-// Subscribe to Redis messages (CONSUMER)
-func (h *RedisHub) subscribeRedis() {
-    ch := h.pubsub.Channel()
-
-    log.Printf("[%s] Redis subscriber started", h.serverID)
-
-    for msg := range ch {
-        // Received message from Redis
-        var redisMsg RedisMessage
-        if err := json.Unmarshal([]byte(msg.Payload), &redisMsg); err != nil {
-            log.Printf("Error unmarshaling Redis message: %v", err)
-            continue
-        }
-
-        // Don't process messages we sent ourselves
-        if redisMsg.FromServerID == h.serverID {
-            continue
-        }
-
-        h.mu.RLock()
-        _, existsLocally := h.clients[redisMsg.ToUserID]
-        h.mu.RUnlock()
-        if !existsLocally {
-      		continue
-        }
-
-
-        log.Printf("[%s] Received message from Redis for user %s",
-            h.serverID, redisMsg.ToUserID)
-
-        // Send to local client if connected here
-        h.SendToClient(redisMsg.ToUserID, redisMsg.Payload)
-    }
-}
-
-// Send to specific client (checks local first, then Redis)
-func (h *RedisHub) SendToClient(userID string, message []byte) {
-    h.mu.RLock()
-    client, existsLocally := h.clients[userID]
-    h.mu.RUnlock()
-
-    if existsLocally {
-        // Fast path: User is connected to THIS server
-        select {
-        case client.send <- message:
-            log.Printf("[%s] Sent message to local client %s", h.serverID, userID)
-        default:
-            log.Printf("[%s] Failed to send to local client %s", h.serverID, userID)
-        }
-    } else {
-        // Slow path: User might be on ANOTHER server
-        // Publish to Redis for other servers to handle
-        h.publishToRedis(userID, message)
-    }
-}
-
-// Publish to Redis (PRODUCER)
-func (h *RedisHub) publishToRedis(userID string, message []byte) {
-    ctx := context.Background()
-
-    redisMsg := RedisMessage{
-        FromServerID: h.serverID,
-        ToUserID:     userID,
-        Payload:      message,
-    }
-
-    msgBytes, err := json.Marshal(redisMsg)
-    if err != nil {
-        log.Printf("Error marshaling Redis message: %v", err)
-        return
-    }
-
-    // Publish to specific user channel
-    err = h.redisClient.Publish(ctx, "messages:"+userID, msgBytes).Err()
-    if err != nil {
-        log.Printf("Error publishing to Redis: %v", err)
-        return
-    }
-
-    log.Printf("[%s] Published message to Redis for user %s", h.serverID, userID)
+	// Start Redis consumer in separate goroutine
+	go h.transport.Consume(h.receive)
+	h.startUserHeartbeat()
+
+	reaper := time.NewTicker(reapInterval)
+	defer reaper.Stop()
+
+	for {
+		select {
+		case client := <-h.Register:
+			h.mu.Lock()
+			addConnection(h.clients, client)
+			opened := h.rooms.joinAll(client.UserId, client.Rooms)
+			h.mu.Unlock()
+
+			// Announce this user has a connection on this server, and the rooms it now holds members of
+			h.announceUsers(context.Background(), client.UserId)
+			h.announceRooms(context.Background(), opened...)
+
+			slog.Info("Client connected", "server_id", h.serverID, "user_id", client.UserId, "connection_id", client.ConnectionId)
+
+		case client := <-h.Unregister:
+			h.unregister(client)
+
+		case <-reaper.C:
+			reapClients(&h.mu, h.clients, h.unregister)
+
+		case message := <-h.broadcast:
+			h.broadcastLocal(message)
+		}
+	}
+}
+
+func (h *RedisHub) unregister(client *UserClient) {
+	h.mu.Lock()
+	removed, lastConnection := removeConnection(h.clients, client)
+	if removed {
+		client.close()
+		slog.Info("Client disconnected", "server_id", h.serverID, "user_id", client.UserId, "connection_id", client.ConnectionId)
+	}
+	var emptied []string
+	if lastConnection {
+		emptied = h.rooms.leaveAll(client.UserId)
+	}
+	h.mu.Unlock()
+
+	if !lastConnection {
+		return
+	}
+
+	// Remove this server from the user's presence and from the rooms it holds no member of anymore
+	h.redisClient.ZRem(context.Background(), userServersKey(client.UserId), h.serverID)
+	h.withdrawRooms(context.Background(), emptied...)
+
+	if h.OnClientUnregister != nil {
+		if err := h.OnClientUnregister(client); err != nil {
+			slog.Error("OnClientUnregister error", "user_id", client.UserId, "connection_id", client.ConnectionId, "error", err)
+		}
+	}
+}
+
+// receive delivers an envelope sent by another server (CONSUMER)
+func (h *RedisHub) receive(envelope []byte) {
+	var redisMsg RedisMessage
+	if err := json.Unmarshal(envelope, &redisMsg); err != nil {
+		slog.Error("Unmarshal Redis message error", "server_id", h.serverID, "error", err)
+		return
+	}
+
+	// Don't process messages we sent ourselves or that were routed elsewhere
+	if redisMsg.FromServerID == h.serverID || redisMsg.TargetServerID != h.serverID {
+		return
+	}
+
+	slog.Debug("Received message from Redis", "server_id", h.serverID, "from_server_id", redisMsg.FromServerID,
+		"event", redisMsg.EventType, "user_id", redisMsg.ToUserID, "room_id", redisMsg.RoomID)
+
+	if redisMsg.RoomOp != "" {
+		h.applyRoomOp(redisMsg.RoomOp, redisMsg.RoomID, redisMsg.ToUserID)
+		return
+	}
+
+	ctx := tracing.WithTraceParent(context.Background(), redisMsg.TraceParent)
+	ctx, span := h.tracer.Start(ctx, "redis.receive", trace.WithSpanKind(trace.SpanKindConsumer))
+	span.SetAttributes(
+		attribute.String("messaging.source.name", redisMsg.FromServerID),
+		attribute.String("wetalk.event", redisMsg.EventType),
+	)
+	if redisMsg.RoomID != "" {
+		sent := h.sendLocalToRoom(redisMsg.RoomID, redisMsg.Payload, redisMsg.RoomSend)
+		span.SetAttributes(
+			attribute.String("wetalk.room_id", redisMsg.RoomID),
+			attribute.Int("wetalk.delivered", len(sent)),
+		)
+		// Acking the members may take a while, keep consuming meanwhile
+		if redisMsg.RoomSend.Track && len(sent) > 0 && h.OnRoomDelivered != nil {
+			go h.OnRoomDelivered(ctx, redisMsg.RoomID, sent, redisMsg.Payload)
+		}
+	} else if len(redisMsg.ToUserIDs) > 0 {
+		span.SetAttributes(attribute.Int("wetalk.delivered", len(h.sendLocalToUsers(redisMsg.ToUserIDs, redisMsg.Payload))))
+	} else {
+		span.SetAttributes(attribute.Bool("wetalk.delivered", h.sendLocal(redisMsg.ToUserID, redisMsg.Payload)))
+	}
+	span.End()
+}
+
+// Send to every device of a user, on this server and on the other servers holding a connection
+func (h *RedisHub) SendToClient(ctx context.Context, userID string, message []byte) bool {
+	sentLocal := h.sendLocal(userID, message)
+	sentRemote := h.publishToRedis(ctx, userID, message)
+	return sentLocal || sentRemote
+}
+
+// sendLocal delivers a message to the user's connections on this server
+func (h *RedisHub) sendLocal(userID string, message []byte) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return deliver(h.clients[userID], message, func(client *UserClient) {
+		slog.Warn("Failed to send to local client", "server_id", h.serverID, "user_id", userID, "connection_id", client.ConnectionId)
+	})
+}
+
+// SendToUsers sends to every device of the users. The servers holding them are looked up by
+// batches of pipelined lookups, then each server gets a single envelope for all of its users
+func (h *RedisHub) SendToUsers(ctx context.Context, userIDs []string, message []byte) []string {
+	// Keep publishing for the callers whose request is over, only their trace matters here
+	ctx = context.WithoutCancel(ctx)
+
+	sent := h.sendLocalToUsers(userIDs, message)
+	usersByServer := make(map[string][]string)
+	for start := 0; start < len(userIDs); start += sendBatchSize {
+		end := min(start+sendBatchSize, len(userIDs))
+		for serverID, serverUserIDs := range h.resolveServers(ctx, userIDs[start:end]) {
+			usersByServer[serverID] = append(usersByServer[serverID], serverUserIDs...)
+		}
+	}
+	for userID := range h.publishToServers(ctx, usersByServer, message) {
+		sent[userID] = true
+	}
+
+	users := make([]string, 0, len(sent))
+	for _, userID := range userIDs {
+		if sent[userID] {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
+
+// sendLocalToUsers delivers a message to the connections of the users on this server, it returns
+// the users that got it
+func (h *RedisHub) sendLocalToUsers(userIDs []string, message []byte) map[string]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sent := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		delivered := deliver(h.clients[userID], message, func(client *UserClient) {
+			slog.Warn("Failed to send to local client", "server_id", h.serverID, "user_id", userID, "connection_id", client.ConnectionId)
+		})
+		if delivered {
+			sent[userID] = true
+		}
+	}
+	return sent
+}
+
+// resolveServers looks the other servers holding the users up with one pipeline, it returns the
+// users held by each of them
+func (h *RedisHub) resolveServers(ctx context.Context, userIDs []string) map[string][]string {
+	minScore := strconv.FormatInt(time.Now().Add(-USER_HEARTBEAT_EXPIRY).Unix(), 10)
+	lookups := make([]*redis.StringSliceCmd, len(userIDs))
+	_, err := h.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, userID := range userIDs {
+			lookups[i] = pipe.ZRangeByScore(ctx, userServersKey(userID), &redis.ZRangeBy{
+				Min: minScore,
+				Max: "+inf",
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Resolve servers of users error", "users", len(userIDs), "error", err)
+		return nil
+	}
+
+	usersByServer := make(map[string][]string)
+	for i, lookup := range lookups {
+		for _, serverID := range lookup.Val() {
+			if serverID != h.serverID {
+				usersByServer[serverID] = append(usersByServer[serverID], userIDs[i])
+			}
+		}
+	}
+	return usersByServer
+}
+
+// publishToServers publishes a single envelope to each server for all of its users, it returns
+// the users another server received the message for
+func (h *RedisHub) publishToServers(ctx context.Context, usersByServer map[string][]string, message []byte) map[string]bool {
+	eventType := payloadEventType(message)
+	received := make(map[string]bool)
+	for targetServerID, targetUserIDs := range usersByServer {
+		msgBytes, err := json.Marshal(RedisMessage{
+			FromServerID:   h.serverID,
+			TargetServerID: targetServerID,
+			ToUserIDs:      targetUserIDs,
+			EventType:      eventType,
+			Payload:        message,
+			TraceParent:    tracing.TraceParent(ctx),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Marshal Redis message error", "error", err)
+			return received
+		}
+
+		ok, err := h.transport.Publish(ctx, targetServerID, msgBytes)
+		if err != nil {
+			slog.ErrorContext(ctx, "Publish to Redis error", "target_server_id", targetServerID, "users", len(targetUserIDs), "error", err)
+			continue
+		}
+		if ok {
+			for _, userID := range targetUserIDs {
+				received[userID] = true
+			}
+		}
+	}
+
+	return received
+}
+
+// JoinRoom adds the user to the room here when they are connected, and on the other servers
+// holding one of their connections
+func (h *RedisHub) JoinRoom(ctx context.Context, roomID string, userID string) {
+	h.applyRoomOp(roomOpJoin, roomID, userID)
+	h.publishRoomOp(ctx, roomOpJoin, roomID, userID)
+}
+
+// LeaveRoom removes the user from the room here and on the other servers holding one of their connections
+func (h *RedisHub) LeaveRoom(ctx context.Context, roomID string, userID string) {
+	h.applyRoomOp(roomOpLeave, roomID, userID)
+	h.publishRoomOp(ctx, roomOpLeave, roomID, userID)
+}
+
+// applyRoomOp has a local user join or leave a room, the room is announced once it gets its
+// first member here and withdrawn once its last one leaves
+func (h *RedisHub) applyRoomOp(op string, roomID string, userID string) {
+	h.mu.Lock()
+	changed := false
+	switch op {
+	case roomOpJoin:
+		// The rooms of the users connecting later come with their client
+		if len(h.clients[userID]) > 0 {
+			changed = h.rooms.join(roomID, userID)
+		}
+	case roomOpLeave:
+		changed = h.rooms.leave(roomID, userID)
+	}
+	h.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if op == roomOpJoin {
+		h.announceRooms(context.Background(), roomID)
+	} else {
+		h.withdrawRooms(context.Background(), roomID)
+	}
+}
+
+// publishRoomOp has the other servers holding the user apply a room operation
+func (h *RedisHub) publishRoomOp(ctx context.Context, op string, roomID string, userID string) {
+	ctx = context.WithoutCancel(ctx)
+
+	for targetServerID := range h.resolveServers(ctx, []string{userID}) {
+		msgBytes, err := json.Marshal(RedisMessage{
+			FromServerID:   h.serverID,
+			TargetServerID: targetServerID,
+			ToUserID:       userID,
+			RoomID:         roomID,
+			RoomOp:         op,
+			TraceParent:    tracing.TraceParent(ctx),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Marshal Redis message error", "error", err)
+			return
+		}
+		if _, err := h.transport.Publish(ctx, targetServerID, msgBytes); err != nil {
+			slog.ErrorContext(ctx, "Publish room operation to Redis error", "target_server_id", targetServerID, "room_id", roomID, "user_id", userID, "error", err)
+		}
+	}
+}
+
+// SendToRoom delivers the message to the local members of the room, then publishes a single
+// envelope to each other server holding members of it
+func (h *RedisHub) SendToRoom(ctx context.Context, roomID string, message []byte, send RoomSend) {
+	// Keep publishing for the callers whose request is over, only their trace matters here
+	ctx = context.WithoutCancel(ctx)
+
+	sent := h.sendLocalToRoom(roomID, message, send)
+	if send.Track && len(sent) > 0 && h.OnRoomDelivered != nil {
+		h.OnRoomDelivered(ctx, roomID, sent, message)
+	}
+
+	minScore := strconv.FormatInt(time.Now().Add(-USER_HEARTBEAT_EXPIRY).Unix(), 10)
+	serverIDs, err := h.redisClient.ZRangeByScore(ctx, roomServersKey(roomID), &redis.ZRangeBy{
+		Min: minScore,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "Resolve servers of room error", "room_id", roomID, "error", err)
+		return
+	}
+
+	eventType := payloadEventType(message)
+	for _, targetServerID := range serverIDs {
+		if targetServerID == h.serverID {
+			continue
+		}
+
+		msgBytes, err := json.Marshal(RedisMessage{
+			FromServerID:   h.serverID,
+			TargetServerID: targetServerID,
+			RoomID:         roomID,
+			RoomSend:       send,
+			EventType:      eventType,
+			Payload:        message,
+			TraceParent:    tracing.TraceParent(ctx),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Marshal Redis message error", "error", err)
+			return
+		}
+		if _, err := h.transport.Publish(ctx, targetServerID, msgBytes); err != nil {
+			slog.ErrorContext(ctx, "Publish to Redis error", "target_server_id", targetServerID, "room_id", roomID, "error", err)
+		}
+	}
+}
+
+// sendLocalToRoom delivers a message to the members of the room on this server, it returns the
+// members that got it
+func (h *RedisHub) sendLocalToRoom(roomID string, message []byte, send RoomSend) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return deliverToRoom(h.clients, h.rooms, roomID, message, send, h.serverID)
+}
+
+// Publish to Redis (PRODUCER), reports whether another server holding the user received it
+func (h *RedisHub) publishToRedis(ctx context.Context, userID string, message []byte) bool {
+	// Keep publishing for the callers whose request is over, only their trace matters here
+	ctx = context.WithoutCancel(ctx)
+
+	// Find out which other servers hold one of the user's connections
+	minScore := strconv.FormatInt(time.Now().Add(-USER_HEARTBEAT_EXPIRY).Unix(), 10)
+	serverIDs, err := h.redisClient.ZRangeByScore(ctx, userServersKey(userID), &redis.ZRangeBy{
+		Min: minScore,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "Resolve servers of user error", "user_id", userID, "error", err)
+		return false
+	}
+
+	eventType := payloadEventType(message)
+	published := false
+
+	for _, targetServerID := range serverIDs {
+		if targetServerID == h.serverID {
+			continue
+		}
+
+		redisMsg := RedisMessage{
+			FromServerID:   h.serverID,
+			TargetServerID: targetServerID,
+			ToUserID:       userID,
+			EventType:      eventType,
+			Payload:        message,
+			TraceParent:    tracing.TraceParent(ctx),
+		}
+
+		msgBytes, err := json.Marshal(redisMsg)
+		if err != nil {
+			slog.ErrorContext(ctx, "Marshal Redis message error", "error", err)
+			return published
+		}
+
+		// Publish to the server holding the user
+		received, err := h.transport.Publish(ctx, targetServerID, msgBytes)
+		if err != nil {
+			slog.ErrorContext(ctx, "Publish to Redis error", "target_server_id", targetServerID, "user_id", userID, "error", err)
+			continue
+		}
+		if received {
+			published = true
+		}
+
+		slog.DebugContext(ctx, "Published message to Redis", "server_id", h.serverID, "target_server_id", targetServerID, "user_id", userID)
+	}
+
+	return published
+}
+
+// IsConnected reports whether the user has a connection here or on a server whose heartbeat is recent
+func (h *RedisHub) IsConnected(userID string) bool {
+	h.mu.RLock()
+	local := len(h.clients[userID]) > 0
+	h.mu.RUnlock()
+	if local {
+		return true
+	}
+
+	minScore := strconv.FormatInt(time.Now().Add(-USER_HEARTBEAT_EXPIRY).Unix(), 10)
+	count, err := h.redisClient.ZCount(context.Background(), userServersKey(userID), minScore, "+inf").Result()
+	if err != nil {
+		slog.Error("Resolve presence of user error", "user_id", userID, "error", err)
+		return false
+	}
+	return count > 0
+}
+
+// payloadEventType returns the "type" field of a payload, the payloads without one are messages
+func payloadEventType(payload []byte) string {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.Type == "" {
+		return REDIS_EVENT_MESSAGE
+	}
+	return event.Type
+}
+
+func serverChannel(serverID string) string {
+	return "messages:server:" + serverID
+}
+
+// pubSubTransport publishes to a channel per server, a server only gets the envelopes
+// published while it is subscribed
+type pubSubTransport struct {
+	client   *redis.Client
+	pubsub   *redis.PubSub
+	serverID string
+}
+
+func newPubSubTransport(rdb *redis.Client, serverID string) *pubSubTransport {
+	return &pubSubTransport{
+		client: rdb,
+		// Subscribe only to this server's channel instead of every user channel
+		pubsub:   rdb.Subscribe(context.Background(), serverChannel(serverID)),
+		serverID: serverID,
+	}
+}
+
+func (t *pubSubTransport) Publish(ctx context.Context, targetServerID string, envelope []byte) (bool, error) {
+	receivers, err := t.client.Publish(ctx, serverChannel(targetServerID), envelope).Result()
+	return receivers > 0, err
+}
+
+func (t *pubSubTransport) Consume(handle func(envelope []byte)) {
+	slog.Info("Redis subscriber started", "server_id", t.serverID)
+
+	for msg := range t.pubsub.Channel() {
+		handle([]byte(msg.Payload))
+	}
+}
+
+// userServersKey is a sorted set of the servers holding a user's connections,
+// scored by the time of their last heartbeat
+func userServersKey(userID string) string {
+	return "user:" + userID + ":servers"
+}
+
+// roomServersKey is a sorted set of the servers holding members of a room, scored by the time of
+// their last heartbeat
+func roomServersKey(roomID string) string {
+	return "room:" + roomID + ":servers"
+}
+
+// announceRooms records that this server holds members of the given rooms
+func (h *RedisHub) announceRooms(ctx context.Context, roomIDs ...string) {
+	if len(roomIDs) == 0 {
+		return
+	}
+	score := float64(time.Now().Unix())
+	pipe := h.redisClient.Pipeline()
+
+	for _, roomID := range roomIDs {
+		pipe.ZAdd(ctx, roomServersKey(roomID), redis.Z{
+			Score:  score,
+			Member: h.serverID,
+		})
+		pipe.Expire(ctx, roomServersKey(roomID), USER_HEARTBEAT_EXPIRY)
+	}
+
+	_, _ = pipe.Exec(ctx)
+}
+
+// withdrawRooms records that this server holds no member of the given rooms anymore
+func (h *RedisHub) withdrawRooms(ctx context.Context, roomIDs ...string) {
+	if len(roomIDs) == 0 {
+		return
+	}
+	pipe := h.redisClient.Pipeline()
+	for _, roomID := range roomIDs {
+		pipe.ZRem(ctx, roomServersKey(roomID), h.serverID)
+	}
+	_, _ = pipe.Exec(ctx)
+}
+
+// localRoomIds returns the rooms holding members on this server
+func (h *RedisHub) localRoomIds() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.rooms.ids()
+}
+
+// announceUsers records that this server holds connections of the given users
+func (h *RedisHub) announceUsers(ctx context.Context, userIDs ...string) {
+	score := float64(time.Now().Unix())
+	pipe := h.redisClient.Pipeline()
+
+	for _, userID := range userIDs {
+		pipe.ZAdd(ctx, userServersKey(userID), redis.Z{
+			Score:  score,
+			Member: h.serverID,
+		})
+		pipe.Expire(ctx, userServersKey(userID), USER_HEARTBEAT_EXPIRY)
+	}
+
+	_, _ = pipe.Exec(ctx)
 }
 
 // Broadcast to all local clients
 func (h *RedisHub) broadcastLocal(message []byte) {
-    h.mu.RLock()
-    defer h.mu.RUnlock()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-    for userId, client := range h.clients {
-        select {
-        case client.send <- message:
-        default:
-            log.Printf("Failed to send to client: %s", userId)
-        }
-    }
+	for userId, connections := range h.clients {
+		for _, client := range connections {
+			if !client.enqueue(message) {
+				slog.Warn("Failed to broadcast to client", "server_id", h.serverID, "user_id", userId, "connection_id", client.ConnectionId)
+			}
+		}
+	}
 }
 
 func (h *RedisHub) Broadcast(message []byte) {
-    h.broadcast <- message
+	h.broadcast <- message
 }
 
+// GetClientCount returns the number of open connections on this server
 func (h *RedisHub) GetClientCount() int {
-    h.mu.RLock()
-    defer h.mu.RUnlock()
-    return len(h.clients)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return countConnections(h.clients)
+}
+
+// Stats returns the send buffer of every connection on this server, slowest consumers first
+func (h *RedisHub) Stats() HubStats {
+	h.mu.RLock()
+	stats := collectStats(h.clients)
+	h.mu.RUnlock()
+
+	if shadow, ok := h.transport.(*shadowTransport); ok {
+		shadowStats := shadow.Stats()
+		stats.Shadow = &shadowStats
+	}
+	return stats
+}
+
+// LocalUserIds returns the users with an open connection on this server
+func (h *RedisHub) LocalUserIds() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return userIds(h.clients)
+}
+
+// Reconcile removes this server from the presence of the users it holds no connection of, left
+// behind by a failed removal or a restart under the same server id, and announces the local users
+// Redis lost. Every local user is announced at the end, in case one connected during the scan
+func (h *RedisHub) Reconcile(ctx context.Context) (int, error) {
+	local := make(map[string]bool)
+	for _, userID := range h.LocalUserIds() {
+		local[userID] = true
+	}
+
+	corrected := 0
+	iter := h.redisClient.Scan(ctx, 0, userServersKey("*"), reconcileScanCount).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		userID := strings.TrimSuffix(strings.TrimPrefix(key, "user:"), ":servers")
+		if local[userID] {
+			_, err := h.redisClient.ZScore(ctx, key, h.serverID).Result()
+			if err == nil {
+				delete(local, userID)
+			} else if !errors.Is(err, redis.Nil) {
+				return corrected, err
+			}
+			continue
+		}
+
+		removed, err := h.redisClient.ZRem(ctx, key, h.serverID).Result()
+		if err != nil {
+			return corrected, err
+		}
+		corrected += int(removed)
+	}
+	if err := iter.Err(); err != nil {
+		return corrected, err
+	}
+
+	// The local users left were missing from Redis
+	corrected += len(local)
+	h.announceUsers(ctx, h.LocalUserIds()...)
+	h.announceRooms(ctx, h.localRoomIds()...)
+
+	return corrected, nil
 }
 
 func (h *RedisHub) RegisterClient(client *UserClient) {
-    h.Register <- client
+	h.Register <- client
 }
 
 func (h *RedisHub) UnregisterClient(client *UserClient) {
-    h.Unregister <- client
+	h.Unregister <- client
 }
 
 func (h *RedisHub) SetOnClientUnregister(callback func(client *UserClient) error) {
-    h.OnClientUnregister = callback
+	h.OnClientUnregister = callback
+}
+
+func (h *RedisHub) SetOnRoomDelivered(callback RoomDelivered) {
+	h.OnRoomDelivered = callback
 }
 
 func (h *RedisHub) startUserHeartbeat() {
@@ -239,13 +758,8 @@ func (h *RedisHub) startUserHeartbeat() {
 		for {
 			select {
 			case <-ticker.C:
-	    		pipe := h.redisClient.Pipeline()
-
-				for userID := range h.clients {
-					pipe.Expire(ctx, "user:"+userID+":server", USER_HEARTBEAT_EXPIRY)
-				}
-
-				_, _ = pipe.Exec(ctx)
+				h.announceUsers(ctx, h.LocalUserIds()...)
+				h.announceRooms(ctx, h.localRoomIds()...)
 
 			case <-ctx.Done():
 				return
@@ -0,0 +1,161 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// streamGroup is the consumer group reading the stream of a server, the server is its only consumer
+	streamGroup = "hub"
+	// streamMaxLen caps every stream, approximately, so a dead server's stream can't grow forever
+	streamMaxLen = 10000
+	// streamMaxAge drops the envelopes that waited too long, a late typing or presence event is worse than none
+	streamMaxAge  = 2 * time.Minute
+	streamBatch   = 100
+	streamBlock   = 5 * time.Second
+	streamBackoff = time.Second
+)
+
+// RedisStreamHub is a RedisHub exchanging envelopes through a Redis Stream per server instead
+// of Pub/Sub. Every server reads its stream through a consumer group and acknowledges the
+// entries it delivered, so the envelopes sent while it was disconnected from Redis, or that it
+// received but didn't acknowledge before a crash, are delivered once it is back
+type RedisStreamHub struct {
+	*RedisHub
+}
+
+// NewRedisStreamHub creates a hub sharing connections through Redis Streams, the hooks wrap every
+// Redis command. The tracer follows messages across servers
+func NewRedisStreamHub(redisAddr string, serverID string, tracer trace.Tracer, hooks ...redis.Hook) IHub {
+	rdb := newRedisClient(redisAddr, hooks)
+	transport := &streamTransport{
+		client:   rdb,
+		serverID: serverID,
+	}
+
+	return &RedisStreamHub{
+		RedisHub: newRedisHub(rdb, serverID, tracer, transport),
+	}
+}
+
+func serverStream(serverID string) string {
+	return "messages:stream:server:" + serverID
+}
+
+type streamTransport struct {
+	client   *redis.Client
+	serverID string
+}
+
+// Publish appends the envelope to the stream of the server, it is delivered once the server reads it
+func (t *streamTransport) Publish(ctx context.Context, targetServerID string, envelope []byte) (bool, error) {
+	err := t.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: serverStream(targetServerID),
+		MaxLen: streamMaxLen,
+		Approx: true,
+		Values: map[string]any{"envelope": envelope},
+	}).Err()
+	return err == nil, err
+}
+
+// Consume reads the stream of this server. The entries left pending by a previous run or a lost
+// connection are replayed first, then new entries are read as they come
+func (t *streamTransport) Consume(handle func(envelope []byte)) {
+	ctx := context.Background()
+	stream := serverStream(t.serverID)
+
+	slog.Info("Redis stream consumer started", "server_id", t.serverID, "stream", stream)
+
+	replaying := true
+	groupReady := false
+	for {
+		if !groupReady {
+			if err := t.ensureGroup(ctx, stream); err != nil {
+				slog.Error("Create Redis stream group error", "server_id", t.serverID, "error", err)
+				time.Sleep(streamBackoff)
+				continue
+			}
+			groupReady = true
+		}
+
+		// "0" reads the entries delivered to this consumer but never acknowledged, ">" the new ones
+		start := ">"
+		if replaying {
+			start = "0"
+		}
+
+		streams, err := t.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    streamGroup,
+			Consumer: t.serverID,
+			Streams:  []string{stream, start},
+			Count:    streamBatch,
+			Block:    streamBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			slog.Error("Read Redis stream error", "server_id", t.serverID, "error", err)
+			// An evicted stream loses its group
+			groupReady = !strings.HasPrefix(err.Error(), "NOGROUP")
+			replaying = true
+			time.Sleep(streamBackoff)
+			continue
+		}
+
+		var entries []redis.XMessage
+		for _, s := range streams {
+			entries = append(entries, s.Messages...)
+		}
+		if replaying && len(entries) == 0 {
+			replaying = false
+			continue
+		}
+		if replaying {
+			slog.Info("Replaying pending Redis stream entries", "server_id", t.serverID, "count", len(entries))
+		}
+
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+
+			envelope, ok := entry.Values["envelope"].(string)
+			if !ok || streamEntryAge(entry.ID) > streamMaxAge {
+				continue
+			}
+			handle([]byte(envelope))
+		}
+
+		if err := t.client.XAck(ctx, stream, streamGroup, ids...).Err(); err != nil {
+			slog.Error("Acknowledge Redis stream entries error", "server_id", t.serverID, "count", len(ids), "error", err)
+		}
+	}
+}
+
+// ensureGroup creates the consumer group and the stream if needed, new groups start at the end
+// of the stream
+func (t *streamTransport) ensureGroup(ctx context.Context, stream string) error {
+	err := t.client.XGroupCreateMkStream(ctx, stream, streamGroup, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// streamEntryAge reads the age of an entry from its id, "<milliseconds>-<sequence>"
+func streamEntryAge(id string) time.Duration {
+	millis, _, _ := strings.Cut(id, "-")
+	added, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Since(time.UnixMilli(added))
+}
@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// shadowMatchWindow is how long an envelope delivered by one transport waits for the same
+	// envelope on the other before it is counted as missing there
+	shadowMatchWindow   = 30 * time.Second
+	shadowSweepInterval = 10 * time.Second
+)
+
+// ShadowStats compares the deliveries of the shadow transport with the ones of the primary
+// transport since the server started, see NewShadowRedisHub
+type ShadowStats struct {
+	Primary TransportStats `json:"primary"`
+	Shadow  TransportStats `json:"shadow"`
+	// Matched counts the envelopes both transports delivered
+	Matched int64 `json:"matched"`
+	// ShadowLagSeconds sums how much later than the primary transport the shadow delivered the
+	// matched envelopes, it is negative when the shadow is faster
+	ShadowLagSeconds float64 `json:"shadowLagSeconds"`
+}
+
+type TransportStats struct {
+	Transport     string `json:"transport"`
+	Published     int64  `json:"published"`
+	PublishErrors int64  `json:"publishErrors"`
+	Received      int64  `json:"received"`
+	// Missing counts the envelopes the other transport delivered and this one didn't within the
+	// match window
+	Missing int64 `json:"missing"`
+}
+
+// NewShadowRedisHub creates a Redis hub delivering through the primary transport, "pubsub" or
+// "streams", that publishes every envelope through the shadow transport as well. What the shadow
+// delivers is only compared with the primary deliveries, see HubStats.Shadow, so a new transport
+// can be validated in production before the hub is switched to it
+func NewShadowRedisHub(redisAddr string, serverID string, primary string, shadow string, tracer trace.Tracer, hooks ...redis.Hook) IHub {
+	rdb := newRedisClient(redisAddr, hooks)
+	transport := &shadowTransport{
+		primary: newRedisTransport(rdb, serverID, primary),
+		shadow:  newRedisTransport(rdb, serverID, shadow),
+		pending: make(map[[sha256.Size]byte]*shadowArrivals),
+		stats: ShadowStats{
+			Primary: TransportStats{Transport: primary},
+			Shadow:  TransportStats{Transport: shadow},
+		},
+	}
+	return newRedisHub(rdb, serverID, tracer, transport)
+}
+
+// newRedisTransport builds the transport of the kind, "streams" or else Pub/Sub
+func newRedisTransport(rdb *redis.Client, serverID string, kind string) serverTransport {
+	if kind == "streams" {
+		return &streamTransport{
+			client:   rdb,
+			serverID: serverID,
+		}
+	}
+	return newPubSubTransport(rdb, serverID)
+}
+
+// shadowTransport publishes through two transports and delivers what the primary one receives
+type shadowTransport struct {
+	primary serverTransport
+	shadow  serverTransport
+
+	mu sync.Mutex
+	// pending are the arrival times of the envelopes delivered by a single transport so far
+	pending map[[sha256.Size]byte]*shadowArrivals
+	stats   ShadowStats
+}
+
+type shadowArrivals struct {
+	primary []time.Time
+	shadow  []time.Time
+}
+
+// Publish reports what the primary transport did, the shadow can only fail in the stats
+func (t *shadowTransport) Publish(ctx context.Context, targetServerID string, envelope []byte) (bool, error) {
+	received, err := t.primary.Publish(ctx, targetServerID, envelope)
+	_, shadowErr := t.shadow.Publish(ctx, targetServerID, envelope)
+
+	t.mu.Lock()
+	countPublish(&t.stats.Primary, err)
+	countPublish(&t.stats.Shadow, shadowErr)
+	t.mu.Unlock()
+
+	return received, err
+}
+
+func countPublish(stats *TransportStats, err error) {
+	if err != nil {
+		stats.PublishErrors++
+		return
+	}
+	stats.Published++
+}
+
+func (t *shadowTransport) Consume(handle func(envelope []byte)) {
+	go t.sweep()
+	go t.shadow.Consume(func(envelope []byte) {
+		t.observe(envelope, false)
+	})
+
+	t.primary.Consume(func(envelope []byte) {
+		t.observe(envelope, true)
+		handle(envelope)
+	})
+}
+
+// observe matches an envelope with the same envelope delivered by the other transport, identical
+// envelopes are matched in the order they arrived
+func (t *shadowTransport) observe(envelope []byte, fromPrimary bool) {
+	now := time.Now()
+	key := sha256.Sum256(envelope)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	arrivals := t.pending[key]
+	if arrivals == nil {
+		arrivals = &shadowArrivals{}
+		t.pending[key] = arrivals
+	}
+
+	if fromPrimary {
+		t.stats.Primary.Received++
+		if len(arrivals.shadow) == 0 {
+			arrivals.primary = append(arrivals.primary, now)
+			return
+		}
+		t.stats.Matched++
+		t.stats.ShadowLagSeconds += arrivals.shadow[0].Sub(now).Seconds()
+		arrivals.shadow = arrivals.shadow[1:]
+	} else {
+		t.stats.Shadow.Received++
+		if len(arrivals.primary) == 0 {
+			arrivals.shadow = append(arrivals.shadow, now)
+			return
+		}
+		t.stats.Matched++
+		t.stats.ShadowLagSeconds += now.Sub(arrivals.primary[0]).Seconds()
+		arrivals.primary = arrivals.primary[1:]
+	}
+
+	if len(arrivals.primary) == 0 && len(arrivals.shadow) == 0 {
+		delete(t.pending, key)
+	}
+}
+
+// sweep counts the envelopes left unmatched past the match window as missing on the other transport
+func (t *shadowTransport) sweep() {
+	ticker := time.NewTicker(shadowSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cutoff := time.Now().Add(-shadowMatchWindow)
+
+		t.mu.Lock()
+		for key, arrivals := range t.pending {
+			var expired int
+			arrivals.primary, expired = dropBefore(arrivals.primary, cutoff)
+			t.stats.Shadow.Missing += int64(expired)
+			arrivals.shadow, expired = dropBefore(arrivals.shadow, cutoff)
+			t.stats.Primary.Missing += int64(expired)
+
+			if len(arrivals.primary) == 0 && len(arrivals.shadow) == 0 {
+				delete(t.pending, key)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// dropBefore removes the times before the cutoff from the sorted times and returns how many it removed
+func dropBefore(times []time.Time, cutoff time.Time) ([]time.Time, int) {
+	dropped := 0
+	for dropped < len(times) && times[dropped].Before(cutoff) {
+		dropped++
+	}
+	return times[dropped:], dropped
+}
+
+func (t *shadowTransport) Stats() ShadowStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
@@ -1,11 +1,36 @@
 package ws
 
+import "context"
+
 type IHub interface {
     Run()
     RegisterClient(client *UserClient)
     UnregisterClient(client *UserClient)
-    SendToClient(userID string, message []byte)
+    // SendToClient reports whether the message was handed to at least one connection of the user,
+    // ctx carries the trace of the sender across servers
+    SendToClient(ctx context.Context, userID string, message []byte) bool
+    // SendToUsers sends one message to many users at once, such as the members of a channel, and
+    // returns the users it was handed to. It costs a round trip per server rather than per user
+    SendToUsers(ctx context.Context, userIDs []string, message []byte) []string
+    // JoinRoom adds the user to the room of a chat on every server holding one of their
+    // connections, a connection joins the rooms listed in UserClient.Rooms when it registers
+    JoinRoom(ctx context.Context, roomID string, userID string)
+    // LeaveRoom removes the user from the room on every server holding one of their connections
+    LeaveRoom(ctx context.Context, roomID string, userID string)
+    // SendToRoom sends one message to the members of a room, whatever their number every server
+    // holding members gets it once
+    SendToRoom(ctx context.Context, roomID string, message []byte, send RoomSend)
+    SetOnRoomDelivered(callback RoomDelivered)
+    // IsConnected reports whether the user holds a live connection on any server
+    IsConnected(userID string) bool
     Broadcast(message []byte)
     GetClientCount() int
     SetOnClientUnregister(callback func(client *UserClient) error)
+    // Stats describes the send buffers of the connections held by this server
+    Stats() HubStats
+    // LocalUserIds returns the users holding a connection on this server
+    LocalUserIds() []string
+    // Reconcile makes the presence this server shares with the others match its connections again,
+    // it returns the number of entries corrected
+    Reconcile(ctx context.Context) (int, error)
 }
@@ -0,0 +1,60 @@
+package ws
+
+import (
+	"wetalk/pkg/metrics"
+)
+
+// RegisterMetrics exposes the send buffers of the hub, refreshed on every scrape. Per connection
+// series are only kept for connections with queued frames so idle consumers don't flood the scrape
+func RegisterMetrics(hub IHub, registry *metrics.Registry) {
+	connections := registry.NewGauge("wetalk_hub_connections", "Connections held by the hub of this server.")
+	queued := registry.NewGauge("wetalk_hub_queued_frames", "Frames waiting in the send buffers of this server.")
+	oldest := registry.NewGauge("wetalk_hub_oldest_queued_seconds", "Age of the oldest frame waiting in a send buffer of this server.")
+	clientQueued := registry.NewGauge("wetalk_hub_client_queued_frames", "Frames waiting in the send buffer of a connection.", "user", "connection")
+	clientOccupancy := registry.NewGauge("wetalk_hub_client_buffer_occupancy", "Share of the send buffer of a connection in use, frames are dropped at 1.", "user", "connection")
+	dropped := registry.NewCounter("wetalk_hub_dropped_frames_total", "Frames dropped because the send buffer of their connection was full.")
+	coalesced := registry.NewCounter("wetalk_hub_coalesced_frames_total", "Queued events replaced by a newer one about the same chat.")
+	slowDisconnects := registry.NewCounter("wetalk_hub_slow_disconnects_total", "Connections closed because their send buffer stayed full.")
+	filtered := registry.NewCounter("wetalk_hub_filtered_frames_total", "Frames not sent because their connection unsubscribed from their event.")
+	clientOldest := registry.NewGauge("wetalk_hub_client_oldest_queued_seconds", "Age of the oldest frame waiting in the send buffer of a connection.", "user", "connection")
+	shadowPublished := registry.NewCounter("wetalk_hub_shadow_published_total", "Envelopes published by a transport of the hub in shadow mode.", "role", "transport")
+	shadowPublishErrors := registry.NewCounter("wetalk_hub_shadow_publish_errors_total", "Envelopes a transport of the hub in shadow mode failed to publish.", "role", "transport")
+	shadowReceived := registry.NewCounter("wetalk_hub_shadow_received_total", "Envelopes delivered by a transport of the hub in shadow mode.", "role", "transport")
+	shadowMissing := registry.NewCounter("wetalk_hub_shadow_missing_total", "Envelopes delivered by the other transport of the hub in shadow mode but not by this one.", "role", "transport")
+	shadowMatched := registry.NewCounter("wetalk_hub_shadow_matched_total", "Envelopes delivered by both transports of the hub in shadow mode.")
+	shadowLag := registry.NewCounter("wetalk_hub_shadow_lag_seconds_sum", "Delay of the shadow transport behind the primary one summed over the matched envelopes, negative when it is faster.")
+
+	registry.OnCollect(func() {
+		stats := hub.Stats()
+		connections.Set(float64(stats.Connections))
+		queued.Set(float64(stats.Queued))
+		oldest.Set(stats.OldestQueuedSeconds)
+		dropped.Set(float64(stats.Dropped))
+		coalesced.Set(float64(stats.Coalesced))
+		slowDisconnects.Set(float64(stats.SlowDisconnects))
+		filtered.Set(float64(stats.Filtered))
+
+		if stats.Shadow != nil {
+			for role, transport := range map[string]TransportStats{"primary": stats.Shadow.Primary, "shadow": stats.Shadow.Shadow} {
+				shadowPublished.Set(float64(transport.Published), role, transport.Transport)
+				shadowPublishErrors.Set(float64(transport.PublishErrors), role, transport.Transport)
+				shadowReceived.Set(float64(transport.Received), role, transport.Transport)
+				shadowMissing.Set(float64(transport.Missing), role, transport.Transport)
+			}
+			shadowMatched.Set(float64(stats.Shadow.Matched))
+			shadowLag.Set(stats.Shadow.ShadowLagSeconds)
+		}
+
+		clientQueued.Reset()
+		clientOccupancy.Reset()
+		clientOldest.Reset()
+		for _, client := range stats.Clients {
+			if client.Queued == 0 {
+				continue
+			}
+			clientQueued.Set(float64(client.Queued), client.UserId, client.ConnectionId)
+			clientOccupancy.Set(float64(client.Queued)/float64(client.Capacity), client.UserId, client.ConnectionId)
+			clientOldest.Set(client.OldestQueuedSeconds, client.UserId, client.ConnectionId)
+		}
+	})
+}
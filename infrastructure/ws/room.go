@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Room operations an envelope carries to the other servers holding a user
+const (
+	roomOpJoin  = "join"
+	roomOpLeave = "leave"
+)
+
+// RoomSend tunes a message sent to the members of a room
+type RoomSend struct {
+	// ExceptUserID is a member left out, such as the sender of a chat message
+	ExceptUserID string `json:"exceptUserId,omitempty"`
+	// Track hands the members each server delivered the message to to the OnRoomDelivered callback
+	Track bool `json:"track,omitempty"`
+}
+
+// RoomDelivered gets the members of a room a server handed a tracked message to
+type RoomDelivered func(ctx context.Context, roomID string, userIDs []string, message []byte)
+
+// rooms groups the users holding a connection on a server by the chats they take part in, so a
+// message to a chat reaches its members here with a single send. The hubs guard it with their lock
+type rooms struct {
+	// members are the users of each room, joined the rooms of each user
+	members map[string]map[string]bool
+	joined  map[string]map[string]bool
+}
+
+func newRooms() rooms {
+	return rooms{
+		members: make(map[string]map[string]bool),
+		joined:  make(map[string]map[string]bool),
+	}
+}
+
+// join reports whether the user is the first member of the room on this server
+func (r rooms) join(roomID string, userID string) bool {
+	members, ok := r.members[roomID]
+	if !ok {
+		members = make(map[string]bool)
+		r.members[roomID] = members
+	}
+	if members[userID] {
+		return false
+	}
+	members[userID] = true
+
+	joined, ok := r.joined[userID]
+	if !ok {
+		joined = make(map[string]bool)
+		r.joined[userID] = joined
+	}
+	joined[roomID] = true
+	return len(members) == 1
+}
+
+// leave reports whether the user was the last member of the room on this server
+func (r rooms) leave(roomID string, userID string) bool {
+	members, ok := r.members[roomID]
+	if !ok || !members[userID] {
+		return false
+	}
+	delete(members, userID)
+	delete(r.joined[userID], roomID)
+	if len(r.joined[userID]) == 0 {
+		delete(r.joined, userID)
+	}
+
+	if len(members) > 0 {
+		return false
+	}
+	delete(r.members, roomID)
+	return true
+}
+
+// joinAll adds the user to the rooms, it returns the ones the user is the first member of
+func (r rooms) joinAll(userID string, roomIDs []string) []string {
+	var opened []string
+	for _, roomID := range roomIDs {
+		if r.join(roomID, userID) {
+			opened = append(opened, roomID)
+		}
+	}
+	return opened
+}
+
+// leaveAll removes the user from every room once their last connection is gone, it returns the
+// rooms left without members
+func (r rooms) leaveAll(userID string) []string {
+	var emptied []string
+	for roomID := range r.joined[userID] {
+		if r.leave(roomID, userID) {
+			emptied = append(emptied, roomID)
+		}
+	}
+	return emptied
+}
+
+func (r rooms) ids() []string {
+	ids := make([]string, 0, len(r.members))
+	for roomID := range r.members {
+		ids = append(ids, roomID)
+	}
+	return ids
+}
+
+// deliverToRoom queues the message on the connections of the members of the room, it returns the
+// members that got it. The lock of the hub must be held
+func deliverToRoom(clients map[string]map[string]*UserClient, rooms rooms, roomID string, message []byte, send RoomSend, serverID string) []string {
+	members := rooms.members[roomID]
+	sent := make([]string, 0, len(members))
+	for userID := range members {
+		if userID == send.ExceptUserID {
+			continue
+		}
+		delivered := deliver(clients[userID], message, func(client *UserClient) {
+			slog.Warn("Failed to send to room member", "server_id", serverID, "room_id", roomID, "user_id", userID, "connection_id", client.ConnectionId)
+		})
+		if delivered {
+			sent = append(sent, userID)
+		}
+	}
+	return sent
+}
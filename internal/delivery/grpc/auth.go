@@ -0,0 +1,96 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "user"
+
+// publicMethodPrefix is the service callable without an access token
+const publicMethodPrefix = "/wetalk.v1.AuthService/"
+
+type authInterceptor struct {
+	authUc usecase.AuthUsecase
+}
+
+func newAuthInterceptor(authUc usecase.AuthUsecase) *authInterceptor {
+	return &authInterceptor{
+		authUc: authUc,
+	}
+}
+
+func (i *authInterceptor) unary(ctx context.Context, req any, info *grpclib.UnaryServerInfo, handler grpclib.UnaryHandler) (any, error) {
+	if strings.HasPrefix(info.FullMethod, publicMethodPrefix) {
+		return handler(ctx, req)
+	}
+
+	ctx, err := i.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (i *authInterceptor) stream(srv any, stream grpclib.ServerStream, info *grpclib.StreamServerInfo, handler grpclib.StreamHandler) error {
+	ctx, err := i.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticate validates the "authorization: Bearer <token>" metadata and stores the claims in the context
+func (i *authInterceptor) authenticate(ctx context.Context) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing metadata")
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+
+	token, found := strings.CutPrefix(values[0], "Bearer ")
+	if !found {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
+	}
+
+	claims, err := i.authUc.ValidateAccessToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired token")
+	}
+
+	return context.WithValue(ctx, claimsContextKey, claims), nil
+}
+
+type authenticatedStream struct {
+	grpclib.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// userFromContext returns the caller authenticated by the interceptor
+func userFromContext(ctx context.Context) (*entity.TokenClaims, error) {
+	claims, ok := ctx.Value(claimsContextKey).(*entity.TokenClaims)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return claims, nil
+}
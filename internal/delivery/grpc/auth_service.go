@@ -0,0 +1,145 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"context"
+	"net"
+
+	"wetalk/internal/delivery/grpc/pb"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type AuthService struct {
+	pb.UnimplementedAuthServiceServer
+	authUc usecase.AuthUsecase
+}
+
+func NewAuthService(authUc usecase.AuthUsecase) *AuthService {
+	return &AuthService{
+		authUc: authUc,
+	}
+}
+
+func (s *AuthService) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.AuthResponse, error) {
+	if req.GetUsername() == "" || req.GetEmail() == "" || req.GetPassword() == "" || req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "username, email, password, and name are required")
+	}
+
+	response, err := s.authUc.Register(ctx, entity.RegisterRequest{
+		Username:     req.GetUsername(),
+		Email:        req.GetEmail(),
+		Password:     req.GetPassword(),
+		Name:         req.GetName(),
+		Birthdate:    req.GetBirthdate(),
+		CaptchaToken: req.GetCaptchaToken(),
+		RemoteIp:     peerIp(ctx),
+		UserAgent:    peerUserAgent(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toPbAuthResponse(response), nil
+}
+
+func (s *AuthService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.AuthResponse, error) {
+	if req.GetEmail() == "" || req.GetPassword() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email and password are required")
+	}
+
+	response, err := s.authUc.Login(ctx, entity.LoginRequest{
+		Email:        req.GetEmail(),
+		Password:     req.GetPassword(),
+		CaptchaToken: req.GetCaptchaToken(),
+		RemoteIp:     peerIp(ctx),
+		UserAgent:    peerUserAgent(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toPbAuthResponse(response), nil
+}
+
+func (s *AuthService) CompleteTwoFactorChallenge(ctx context.Context, req *pb.TwoFactorChallengeRequest) (*pb.AuthResponse, error) {
+	if req.GetChallengeToken() == "" || (req.GetCode() == "" && req.GetRecoveryCode() == "") {
+		return nil, status.Error(codes.InvalidArgument, "challenge token and a code or recovery code are required")
+	}
+
+	response, err := s.authUc.CompleteTwoFactorChallenge(ctx, entity.TwoFactorChallengeRequest{
+		ChallengeToken: req.GetChallengeToken(),
+		Code:           req.GetCode(),
+		RecoveryCode:   req.GetRecoveryCode(),
+		RemoteIp:       peerIp(ctx),
+		UserAgent:      peerUserAgent(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toPbAuthResponse(response), nil
+}
+
+func (s *AuthService) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.AuthResponse, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	response, err := s.authUc.RefreshToken(ctx, entity.RefreshTokenRequest{
+		RefreshToken: req.GetRefreshToken(),
+		RemoteIp:     peerIp(ctx),
+		UserAgent:    peerUserAgent(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toPbAuthResponse(response), nil
+}
+
+func (s *AuthService) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.Empty, error) {
+	if req.GetRefreshToken() == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	if err := s.authUc.Logout(ctx, req.GetRefreshToken()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.Empty{}, nil
+}
+
+// peerIp returns the address of the caller, used by the captcha verification and recorded with the sign in
+func peerIp(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// peerUserAgent is the user agent the client sent in its metadata
+func peerUserAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("user-agent")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,147 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"context"
+
+	"wetalk/internal/delivery/grpc/pb"
+	"wetalk/internal/usecase"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type ChatService struct {
+	pb.UnimplementedChatServiceServer
+	chatUc usecase.ChatUsecase
+}
+
+func NewChatService(chatUc usecase.ChatUsecase) *ChatService {
+	return &ChatService{
+		chatUc: chatUc,
+	}
+}
+
+func (s *ChatService) ListChats(ctx context.Context, req *pb.ListChatsRequest) (*pb.ListChatsResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chats, err := s.chatUc.Index(ctx, user.UserId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &pb.ListChatsResponse{
+		Chats: make([]*pb.Chat, 0, len(chats)),
+	}
+	for _, chat := range chats {
+		response.Chats = append(response.Chats, toPbChat(chat))
+	}
+	return response, nil
+}
+
+func (s *ChatService) GetChat(ctx context.Context, req *pb.GetChatRequest) (*pb.ChatDetail, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "chatId is required")
+	}
+
+	detail, err := s.chatUc.Get(ctx, req.GetChatId(), user.UserId)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.ChatDetail{
+		Chat:         toPbChat(detail.Chat),
+		Participants: toPbUsers(detail.Participants),
+	}, nil
+}
+
+func (s *ChatService) CreatePersonalChat(ctx context.Context, req *pb.CreatePersonalChatRequest) (*pb.CreateChatResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetParticipantId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "participantId is required")
+	}
+
+	chatId, err := s.chatUc.CreatePersonalChat(ctx, user.UserId, req.GetParticipantId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.CreateChatResponse{ChatId: chatId}, nil
+}
+
+func (s *ChatService) CreateGroupChat(ctx context.Context, req *pb.CreateGroupChatRequest) (*pb.CreateChatResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	chatId, err := s.chatUc.CreateGroupChat(ctx, req.GetName(), req.GetDescription(), user.UserId, req.GetUserIds())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.CreateChatResponse{ChatId: chatId}, nil
+}
+
+func (s *ChatService) InviteUsers(ctx context.Context, req *pb.InviteUsersRequest) (*pb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == "" || len(req.GetUserIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "chatId and userIds are required")
+	}
+
+	if err := s.chatUc.InviteUsersToGroup(ctx, req.GetChatId(), user.UserId, req.GetUserIds(), req.GetNote()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.Empty{}, nil
+}
+
+func (s *ChatService) LeaveGroup(ctx context.Context, req *pb.LeaveGroupRequest) (*pb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "chatId is required")
+	}
+
+	if err := s.chatUc.LeaveGroup(ctx, req.GetChatId(), user.UserId); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.Empty{}, nil
+}
+
+func (s *ChatService) GetParticipants(ctx context.Context, req *pb.GetParticipantsRequest) (*pb.GetParticipantsResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "chatId is required")
+	}
+
+	participants, err := s.chatUc.GetParticipants(ctx, req.GetChatId(), user.UserId, nil)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.GetParticipantsResponse{Participants: toPbUsers(participants)}, nil
+}
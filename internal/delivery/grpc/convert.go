@@ -0,0 +1,102 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"wetalk/internal/delivery/grpc/pb"
+	"wetalk/internal/entity"
+)
+
+func toPbUser(user entity.User) *pb.User {
+	return &pb.User{
+		Id:       user.Id,
+		Username: user.Username,
+		Email:    user.Email,
+		Name:     user.Name,
+		IsOnline: user.IsOnline,
+	}
+}
+
+func toPbPublicUser(user entity.PublicUser) *pb.User {
+	return &pb.User{
+		Id:       user.Id,
+		Username: user.Username,
+		Email:    user.Email,
+		Name:     user.Name,
+		IsOnline: user.Status == entity.UserStatusOnline,
+	}
+}
+
+func toPbUsers(users []entity.PublicUser) []*pb.User {
+	result := make([]*pb.User, 0, len(users))
+	for _, user := range users {
+		result = append(result, toPbPublicUser(user))
+	}
+	return result
+}
+
+func toPbChat(chat entity.Chat) *pb.Chat {
+	return &pb.Chat{
+		Id:                chat.Id,
+		Name:              chat.Name,
+		Type:              string(chat.Type),
+		CreatedBy:         chat.CreatedBy,
+		Description:       chat.Description,
+		Avatar:            chat.Avatar,
+		CreatedAt:         chat.CreatedAt.UnixMilli(),
+		UpdatedAt:         chat.UpdatedAt.UnixMilli(),
+		MembershipVersion: chat.MembershipVersion,
+	}
+}
+
+func toPbMessage(message entity.Message) *pb.Message {
+	attachments := make([]*pb.Attachment, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		attachments = append(attachments, &pb.Attachment{
+			Url:      attachment.Url,
+			Name:     attachment.Name,
+			MimeType: attachment.MimeType,
+			Size:     attachment.Size,
+		})
+	}
+
+	return &pb.Message{
+		Id:               message.Id,
+		ChatId:           message.ChatId,
+		SenderId:         message.SenderId,
+		Message:          message.Message,
+		Timestamp:        message.Timestamp,
+		IsRead:           message.IsRead,
+		Attachments:      attachments,
+		ReplyToMessageId: message.ReplyToMessageId,
+		DeliveryState:    message.DeliveryState,
+	}
+}
+
+func fromPbAttachments(attachments []*pb.Attachment) []entity.Attachment {
+	result := make([]entity.Attachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		result = append(result, entity.Attachment{
+			Url:      attachment.GetUrl(),
+			Name:     attachment.GetName(),
+			MimeType: attachment.GetMimeType(),
+			Size:     attachment.GetSize(),
+		})
+	}
+	return result
+}
+
+func toPbAuthResponse(response entity.AuthResponse) *pb.AuthResponse {
+	if response.TwoFactorRequired {
+		return &pb.AuthResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    response.ChallengeToken,
+		}
+	}
+
+	return &pb.AuthResponse{
+		AccessToken:  response.AccessToken,
+		RefreshToken: response.RefreshToken,
+		User:         toPbUser(response.User),
+	}
+}
@@ -0,0 +1,12 @@
+// Package grpc exposes the chat, message and auth usecases over gRPC for backend
+// services that don't speak the browser websocket protocol.
+//
+// The transport is compiled with the "grpc" build tag. The protobuf bindings in the pb
+// package are generated from api/proto/wetalk/v1/wetalk.proto and committed, regenerate
+// them with protoc, protoc-gen-go and protoc-gen-go-grpc after changing the proto file:
+//
+//	go generate ./internal/delivery/grpc
+//	go build -tags grpc ./...
+package grpc
+
+//go:generate protoc -I ../../../api/proto --go_out=../../.. --go_opt=module=wetalk --go-grpc_out=../../.. --go-grpc_opt=module=wetalk wetalk/v1/wetalk.proto
@@ -0,0 +1,50 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"errors"
+	"log/slog"
+	"wetalk/internal/usecase"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodes maps the usecase errors, and every error wrapping them, to gRPC status codes, the same way the HTTP
+// handlers map them to status codes
+var errorCodes = []struct {
+	code codes.Code
+	errs []error
+}{
+	{codes.Canceled, []error{usecase.ErrCanceled}},
+	{codes.DeadlineExceeded, []error{usecase.ErrTimeout}},
+	{codes.Unavailable, []error{usecase.ErrModerationUnavailable}},
+	{codes.NotFound, []error{usecase.ErrChatNotFound, usecase.ErrInvitationNotFound, usecase.ErrMessageNotFound, usecase.ErrMemberNotFound}},
+	{codes.PermissionDenied, []error{usecase.ErrNotParticipant, usecase.ErrNotAdmin, usecase.ErrChannelReadOnly, usecase.ErrRestrictedAccount, usecase.ErrAccountSuspended, usecase.ErrAccountMuted}},
+	{codes.Unauthenticated, []error{usecase.ErrInvalidCredentials, usecase.ErrInvalidRefreshToken, usecase.ErrExpiredRefreshToken, usecase.ErrRevokedRefreshToken,
+		usecase.ErrInvalidChallenge, usecase.ErrInvalidTwoFactorCode}},
+	{codes.AlreadyExists, []error{usecase.ErrEmailAlreadyTaken, usecase.ErrUsernameAlreadyTaken, usecase.ErrPersonalChatExists, usecase.ErrAlreadyParticipant}},
+	{codes.FailedPrecondition, []error{usecase.ErrInvitationResponded, usecase.ErrLastChannelAdmin}},
+	{codes.ResourceExhausted, []error{usecase.ErrGroupSizeLimit, usecase.ErrAttachmentSizeLimit, usecase.ErrSendRateLimited}},
+	{codes.InvalidArgument, []error{usecase.ErrInvalidChatType, usecase.ErrCannotInviteToPersonal, usecase.ErrCannotLeavePersonal, usecase.ErrInvalidInvitation,
+		usecase.ErrLastAdmin, usecase.ErrCannotRemoveSelf, usecase.ErrInvalidReply, usecase.ErrMessageRejected,
+		usecase.ErrAttachmentTooLarge, usecase.ErrAttachmentTypeRejected, usecase.ErrUnderMinimumAge, usecase.ErrInvalidCaptcha}},
+}
+
+// toStatus maps the usecase errors to gRPC status codes, validation errors are invalid arguments
+func toStatus(err error) error {
+	for _, entry := range errorCodes {
+		if usecase.IsAny(err, entry.errs...) {
+			return status.Error(entry.code, err.Error())
+		}
+	}
+
+	var validationErr *usecase.ValidationError
+	if errors.As(err, &validationErr) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	slog.Error("gRPC internal error", "error", err)
+	return status.Error(codes.Internal, "internal server error")
+}
@@ -0,0 +1,98 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+)
+
+// feedBufferSize is how many messages a slow subscriber may fall behind before messages are dropped
+const feedBufferSize = 64
+
+// MessageFeed fans the messages created on this server out to the SubscribeMessages streams.
+// Messages saved by other servers are not seen, backend services should subscribe to every instance
+type MessageFeed struct {
+	messageUc usecase.MessageUsecase
+
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// Subscription receives the messages of the chats its user participates in
+type Subscription struct {
+	UserId   string
+	ChatIds  []string
+	Messages chan entity.Message
+}
+
+func NewMessageFeed(messageUc usecase.MessageUsecase) *MessageFeed {
+	return &MessageFeed{
+		messageUc:     messageUc,
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers the feed on the domain event bus
+func (f *MessageFeed) Subscribe(bus usecase.EventBus) {
+	bus.Subscribe(entity.EventMessageCreated, f.onMessageCreated)
+}
+
+// Add starts a subscription, chatIds narrows it to some chats when not empty
+func (f *MessageFeed) Add(userId string, chatIds []string) *Subscription {
+	subscription := &Subscription{
+		UserId:   userId,
+		ChatIds:  chatIds,
+		Messages: make(chan entity.Message, feedBufferSize),
+	}
+
+	f.mu.Lock()
+	f.subscriptions[subscription] = struct{}{}
+	f.mu.Unlock()
+
+	return subscription
+}
+
+func (f *MessageFeed) Remove(subscription *Subscription) {
+	f.mu.Lock()
+	delete(f.subscriptions, subscription)
+	f.mu.Unlock()
+}
+
+func (f *MessageFeed) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok {
+		return
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.subscriptions) == 0 {
+		return
+	}
+
+	receivers, err := f.messageUc.GetReceiver(ctx, message.ChatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Message feed get receivers error", "error", err)
+		return
+	}
+
+	for subscription := range f.subscriptions {
+		if !slices.Contains(receivers, subscription.UserId) {
+			continue
+		}
+		if len(subscription.ChatIds) > 0 && !slices.Contains(subscription.ChatIds, message.ChatId) {
+			continue
+		}
+
+		// Never block the request that saved the message on a slow stream
+		select {
+		case subscription.Messages <- message:
+		default:
+			slog.WarnContext(ctx, "Message feed dropped message", "message_id", message.Id, "user_id", subscription.UserId)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"wetalk/internal/delivery/grpc/pb"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type MessageService struct {
+	pb.UnimplementedMessageServiceServer
+	chatUc    usecase.ChatUsecase
+	messageUc usecase.MessageUsecase
+	feed      *MessageFeed
+}
+
+func NewMessageService(chatUc usecase.ChatUsecase, messageUc usecase.MessageUsecase, feed *MessageFeed) *MessageService {
+	return &MessageService{
+		chatUc:    chatUc,
+		messageUc: messageUc,
+		feed:      feed,
+	}
+}
+
+func (s *MessageService) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*pb.Message, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == "" || (req.GetMessage() == "" && len(req.GetAttachments()) == 0) {
+		return nil, status.Error(codes.InvalidArgument, "chatId and a message or attachment are required")
+	}
+
+	// Same participation check as the websocket before saving
+	if err := s.chatUc.CanPost(ctx, req.GetChatId(), user.UserId); err != nil {
+		return nil, toStatus(err)
+	}
+
+	timestamp := req.GetTimestamp()
+	if timestamp == 0 {
+		timestamp = time.Now().UnixMilli()
+	}
+
+	saved, err := s.messageUc.SaveMessage(ctx, entity.Message{
+		ChatId:           req.GetChatId(),
+		SenderId:         user.UserId,
+		Message:          req.GetMessage(),
+		Timestamp:        timestamp,
+		Attachments:      fromPbAttachments(req.GetAttachments()),
+		ReplyToMessageId: req.GetReplyToMessageId(),
+	})
+	if errors.Is(err, usecase.ErrCommandHandled) {
+		// Slash commands answer through an ephemeral event, nothing was stored
+		return &pb.Message{ChatId: req.GetChatId()}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toPbMessage(saved), nil
+}
+
+func (s *MessageService) ListMessages(ctx context.Context, req *pb.ListMessagesRequest) (*pb.ListMessagesResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetChatId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "chatId is required")
+	}
+
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = 50
+	}
+
+	messages, err := s.chatUc.GetMessages(ctx, req.GetChatId(), user.UserId, limit, int(req.GetOffset()), nil)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &pb.ListMessagesResponse{
+		Messages: make([]*pb.Message, 0, len(messages)),
+	}
+	for _, message := range messages {
+		response.Messages = append(response.Messages, toPbMessage(message))
+	}
+	return response, nil
+}
+
+func (s *MessageService) MarkAsRead(ctx context.Context, req *pb.MarkAsReadRequest) (*pb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetMessageId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "messageId is required")
+	}
+
+	if err := s.messageUc.MarkAsRead(ctx, req.GetMessageId(), user.UserId); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &pb.Empty{}, nil
+}
+
+// SubscribeMessages streams the new messages of the caller's chats until the client goes away
+func (s *MessageService) SubscribeMessages(req *pb.SubscribeMessagesRequest, stream pb.MessageService_SubscribeMessagesServer) error {
+	ctx := stream.Context()
+
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Narrowing to chats the caller is not part of is refused up front
+	for _, chatId := range req.GetChatIds() {
+		if _, err := s.chatUc.Get(ctx, chatId, user.UserId); err != nil {
+			return toStatus(err)
+		}
+	}
+
+	subscription := s.feed.Add(user.UserId, req.GetChatIds())
+	defer s.feed.Remove(subscription)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message := <-subscription.Messages:
+			if err := stream.Send(toPbMessage(message)); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build grpc
+
+package grpc
+
+import (
+	"wetalk/internal/delivery/grpc/pb"
+	"wetalk/internal/usecase"
+
+	grpclib "google.golang.org/grpc"
+)
+
+// NewServer creates the gRPC server with the auth, chat and message services registered
+func NewServer(authUc usecase.AuthUsecase, chatUc usecase.ChatUsecase, messageUc usecase.MessageUsecase, feed *MessageFeed) *grpclib.Server {
+	interceptor := newAuthInterceptor(authUc)
+
+	server := grpclib.NewServer(
+		grpclib.UnaryInterceptor(interceptor.unary),
+		grpclib.StreamInterceptor(interceptor.stream),
+	)
+
+	pb.RegisterAuthServiceServer(server, NewAuthService(authUc))
+	pb.RegisterChatServiceServer(server, NewChatService(chatUc))
+	pb.RegisterMessageServiceServer(server, NewMessageService(chatUc, messageUc, feed))
+
+	return server
+}
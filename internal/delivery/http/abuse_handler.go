@@ -0,0 +1,142 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AbuseHandler struct {
+	abuseUc usecase.AbuseUsecase
+}
+
+func NewAbuseHandler(abuseUc usecase.AbuseUsecase) *AbuseHandler {
+	return &AbuseHandler{
+		abuseUc: abuseUc,
+	}
+}
+
+// GET /account/restriction - Get the active restriction of the current user
+func (h *AbuseHandler) GetRestriction(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	restriction, err := h.abuseUc.GetRestriction(r.Context(), userClaims.UserId)
+	if err != nil {
+		if !errors.Is(err, usecase.ErrNoRestriction) {
+			slog.ErrorContext(r.Context(), "Get restriction error", "error", err)
+		}
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    restriction,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /account/appeal - Appeal the active restriction of the current user
+func (h *AbuseHandler) SubmitAppeal(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.AppealRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if req.Message == "" {
+		response := Response{Message: "message is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	restriction, err := h.abuseUc.SubmitAppeal(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Submit appeal error", "error", err)
+
+		writeError(w, r, err, "failed to submit appeal")
+		return
+	}
+
+	response := Response{
+		Message: "appeal submitted",
+		Data:    restriction,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/abuse/queue - List the restrictions and appeals waiting for review (super admin only)
+func (h *AbuseHandler) AdminReviewQueue(w http.ResponseWriter, r *http.Request) {
+	restrictions, err := h.abuseUc.GetReviewQueue(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get review queue error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    restrictions,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/abuse/:userId/review - Uphold or lift the restriction of a user (super admin only)
+func (h *AbuseHandler) AdminReview(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.ReviewRestrictionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	restriction, err := h.abuseUc.Review(r.Context(), userId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Review restriction error", "error", err)
+
+		writeError(w, r, err, "failed to review restriction")
+		return
+	}
+
+	response := Response{
+		Message: "restriction reviewed",
+		Data:    restriction,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,147 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AccountMergeHandler struct {
+	accountMergeUc usecase.AccountMergeUsecase
+}
+
+func NewAccountMergeHandler(accountMergeUc usecase.AccountMergeUsecase) *AccountMergeHandler {
+	return &AccountMergeHandler{
+		accountMergeUc: accountMergeUc,
+	}
+}
+
+// POST /admin/merges - Merge a duplicate account into the primary account of the same person (super admin only)
+func (h *AccountMergeHandler) AdminMergeAccounts(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.MergeAccountsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+	if req.PrimaryId == "" || req.DuplicateId == "" {
+		response := Response{Message: "primaryId and duplicateId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	merge, err := h.accountMergeUc.Merge(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Merge accounts error", "error", err)
+
+		writeError(w, r, err, "failed to merge accounts")
+		return
+	}
+
+	response := Response{
+		Message: "accounts merged",
+		Data:    merge,
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// GET /admin/merges/:mergeId - Get what an account merge moved (super admin only)
+func (h *AccountMergeHandler) AdminGetMerge(w http.ResponseWriter, r *http.Request) {
+	mergeId := chi.URLParam(r, "mergeId")
+	if mergeId == "" {
+		response := Response{Message: "mergeId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	merge, err := h.accountMergeUc.Get(r.Context(), mergeId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get account merge error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    merge,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/users/:userId/merges - Merges the user was the primary or the duplicate account of, newest first (super admin only)
+func (h *AccountMergeHandler) AdminListUserMerges(w http.ResponseWriter, r *http.Request) {
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	merges, err := h.accountMergeUc.ListByUser(r.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List account merges error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    merges,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/merges/:mergeId/rollback - Give the duplicate account back what the merge moved (super admin only)
+func (h *AccountMergeHandler) AdminRollbackMerge(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	mergeId := chi.URLParam(r, "mergeId")
+	if mergeId == "" {
+		response := Response{Message: "mergeId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// The reason is optional
+	var req entity.RollbackAccountMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	merge, err := h.accountMergeUc.Rollback(r.Context(), mergeId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Roll back account merge error", "error", err)
+
+		writeError(w, r, err, "failed to roll back account merge")
+		return
+	}
+
+	response := Response{
+		Message: "account merge rolled back",
+		Data:    merge,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
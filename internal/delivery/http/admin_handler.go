@@ -0,0 +1,470 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AdminHandler struct {
+	adminUc usecase.AdminUsecase
+}
+
+func NewAdminHandler(adminUc usecase.AdminUsecase) *AdminHandler {
+	return &AdminHandler{
+		adminUc: adminUc,
+	}
+}
+
+// GET /admin/users?q=&role=&workspaceId=&deactivated=&limit=&offset= - Search the users of every workspace (super admin only)
+func (h *AdminHandler) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAdminUserFilter(r)
+	if err != nil {
+		writeError(w, r, err, "invalid search filter")
+		return
+	}
+
+	users, err := h.adminUc.SearchUsers(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Search users error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message:    "success",
+		Data:       users,
+		Pagination: &Pagination{Limit: usecase.AdminUserLimit(filter.Limit), Offset: filter.Offset, Count: len(users)},
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// parseAdminUserFilter reads the user search filters from the query string
+func parseAdminUserFilter(r *http.Request) (entity.AdminUserFilter, error) {
+	query := r.URL.Query()
+	filter := entity.AdminUserFilter{
+		Query:       strings.TrimSpace(query.Get("q")),
+		Role:        query.Get("role"),
+		WorkspaceId: query.Get("workspaceId"),
+	}
+
+	if value := query.Get("deactivated"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return entity.AdminUserFilter{}, &usecase.ValidationError{Field: "deactivated", Err: errors.New("deactivated must be true or false")}
+		}
+		filter.Deactivated = &parsed
+	}
+
+	if err := parsePage(query, &filter.Limit, &filter.Offset); err != nil {
+		return entity.AdminUserFilter{}, err
+	}
+
+	return filter, nil
+}
+
+// parsePage reads the limit and offset of a back-office list from the query string
+func parsePage(query url.Values, limit *int, offset *int) error {
+	for name, target := range map[string]*int{"limit": limit, "offset": offset} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return &usecase.ValidationError{Field: name, Err: fmt.Errorf("%s must be a positive number", name)}
+			}
+			*target = parsed
+		}
+	}
+	return nil
+}
+
+// POST /admin/users/:userId/deactivate - Deactivate an account, it can't sign in anymore (super admin only)
+func (h *AdminHandler) AdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// The reason is optional
+	var req entity.DeactivateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	user, err := h.adminUc.DeactivateUser(r.Context(), userId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Deactivate user error", "error", err)
+
+		writeError(w, r, err, "failed to deactivate user")
+		return
+	}
+
+	response := Response{
+		Message: "user deactivated",
+		Data:    user,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/users/:userId/reactivate - Let a deactivated account sign in again (super admin only)
+func (h *AdminHandler) AdminReactivateUser(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	user, err := h.adminUc.ReactivateUser(r.Context(), userId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Reactivate user error", "error", err)
+
+		writeError(w, r, err, "failed to reactivate user")
+		return
+	}
+
+	response := Response{
+		Message: "user reactivated",
+		Data:    user,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/chats/:chatId - Get the metadata of any chat, participants included (super admin only)
+func (h *AdminHandler) AdminGetChat(w http.ResponseWriter, r *http.Request) {
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	chat, err := h.adminUc.GetChat(r.Context(), chatId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get chat error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    chat,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/chats/:chatId/restore - Bring back a deleted chat before it is purged (super admin only)
+func (h *AdminHandler) AdminRestoreChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	chat, err := h.adminUc.RestoreChat(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Restore chat error", "error", err)
+
+		writeError(w, r, err, "failed to restore chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat restored",
+		Data:    chat,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /admin/messages/:messageId - Delete an abusive message from its chat (super admin only)
+func (h *AdminHandler) AdminDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	messageId := chi.URLParam(r, "messageId")
+	if messageId == "" {
+		response := Response{Message: "messageId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// The reason is optional
+	var req entity.DeleteMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.adminUc.DeleteMessage(r.Context(), messageId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Delete message error", "error", err)
+
+		writeError(w, r, err, "failed to delete message")
+		return
+	}
+
+	response := Response{Message: "message deleted successfully"}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/chats/:chatId/message-purges/preview - Count the messages of a chat a purge would delete (super admin only)
+func (h *AdminHandler) AdminPreviewMessagePurge(w http.ResponseWriter, r *http.Request) {
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.MessagePurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	preview, err := h.adminUc.PreviewMessagePurge(r.Context(), chatId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Preview message purge error", "error", err)
+
+		writeError(w, r, err, "failed to preview message purge")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    preview,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/chats/:chatId/message-purges - Delete the messages of a chat matching a filter in the background (super admin only)
+func (h *AdminHandler) AdminStartMessagePurge(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.MessagePurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	purge, err := h.adminUc.StartMessagePurge(r.Context(), chatId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Start message purge error", "error", err)
+
+		writeError(w, r, err, "failed to start message purge")
+		return
+	}
+
+	response := Response{
+		Message: "message purge started",
+		Data:    purge,
+	}
+	writeJSON(w, r, http.StatusAccepted, response)
+}
+
+// GET /admin/message-purges/:purgeId - Progress of a message purge (super admin only)
+func (h *AdminHandler) AdminGetMessagePurge(w http.ResponseWriter, r *http.Request) {
+	purgeId := chi.URLParam(r, "purgeId")
+	if purgeId == "" {
+		response := Response{Message: "purgeId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	purge, err := h.adminUc.GetMessagePurge(r.Context(), purgeId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get message purge error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    purge,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/servers - Client count and message throughput of every server of the deployment (super admin only)
+func (h *AdminHandler) AdminListServers(w http.ResponseWriter, r *http.Request) {
+	servers, err := h.adminUc.ListServerStats(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List server stats error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    servers,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/moderation/flags?status= - Messages flagged by the moderation, pending by default (super admin only)
+func (h *AdminHandler) AdminGetFlaggedMessages(w http.ResponseWriter, r *http.Request) {
+	flagged, err := h.adminUc.GetFlaggedMessages(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get flagged messages error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    flagged,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/moderation/flags/:flagId/review - Keep or delete a flagged message (super admin only)
+func (h *AdminHandler) AdminReviewFlaggedMessage(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	flagId := chi.URLParam(r, "flagId")
+	if flagId == "" {
+		response := Response{Message: "flagId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.ReviewFlaggedMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	flagged, err := h.adminUc.ReviewFlaggedMessage(r.Context(), flagId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Review flagged message error", "error", err)
+
+		writeError(w, r, err, "failed to review flagged message")
+		return
+	}
+
+	response := Response{
+		Message: "flagged message reviewed successfully",
+		Data:    flagged,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/maintenance - Whether the API is down for maintenance (super admin only)
+func (h *AdminHandler) AdminGetMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	mode, err := h.adminUc.GetMaintenanceMode(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get maintenance mode error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    mode,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /admin/maintenance - Take the API down for everyone but the super admins, or bring it back (super admin only)
+func (h *AdminHandler) AdminSetMaintenanceMode(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.SetMaintenanceModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	mode, err := h.adminUc.SetMaintenanceMode(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Set maintenance mode error", "error", err)
+
+		writeError(w, r, err, "failed to set maintenance mode")
+		return
+	}
+
+	response := Response{
+		Message: "maintenance mode updated",
+		Data:    mode,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
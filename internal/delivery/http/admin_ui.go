@@ -0,0 +1,31 @@
+package http
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// adminUIFiles is the back-office served at /admin/ui/, a static client of the /admin API
+//
+//go:embed adminui
+var adminUIFiles embed.FS
+
+// AdminUI serves the embedded back-office. The pages are public, the data comes from the /admin
+// API with the token of a super admin signed in on the page
+func AdminUI() http.Handler {
+	files, err := fs.Sub(adminUIFiles, "adminui")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.StripPrefix("/admin/ui/", http.FileServer(http.FS(files)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Scripts and styles only come from the embedded files, and the page can't be framed
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,348 @@
+// WeTalk back-office, a thin client of the /admin API. The access token is kept for the tab only
+'use strict';
+
+const PAGE_SIZE = 50;
+const TOKEN_KEY = 'wetalk-admin-token';
+
+const state = {
+    challengeToken: '',
+    usersOffset: 0,
+    reportsOffset: 0,
+};
+
+const $ = (id) => document.getElementById(id);
+
+// api calls the server in the response format of API version 1 and returns the data of the response
+async function api(method, path, body) {
+    const headers = { 'API-Version': '1' };
+    const token = sessionStorage.getItem(TOKEN_KEY);
+    if (token) {
+        headers['Authorization'] = 'Bearer ' + token;
+    }
+    if (body !== undefined) {
+        headers['Content-Type'] = 'application/json';
+    }
+
+    const res = await fetch(path, {
+        method,
+        headers,
+        credentials: 'same-origin',
+        body: body === undefined ? undefined : JSON.stringify(body),
+    });
+    const payload = await res.json().catch(() => ({}));
+
+    if (res.status === 401 && token) {
+        signOut('Your session expired, sign in again');
+    }
+    if (!res.ok) {
+        throw new Error(payload.message || res.statusText);
+    }
+    return payload.data;
+}
+
+function showError(err) {
+    $('error').textContent = err ? err.message : '';
+}
+
+function formatTime(value) {
+    return value ? new Date(value).toLocaleString() : '';
+}
+
+// row builds a table row, the cells are text or elements
+function row(cells) {
+    const tr = document.createElement('tr');
+    for (const cell of cells) {
+        const td = document.createElement('td');
+        if (cell instanceof Node) {
+            td.appendChild(cell);
+        } else {
+            td.textContent = cell === undefined || cell === null ? '' : String(cell);
+        }
+        tr.appendChild(td);
+    }
+    return tr;
+}
+
+function fillRows(tbody, rows, columns) {
+    tbody.replaceChildren(...rows);
+    if (rows.length === 0) {
+        const tr = document.createElement('tr');
+        const td = document.createElement('td');
+        td.colSpan = columns;
+        td.className = 'empty';
+        td.textContent = 'Nothing to show';
+        tr.appendChild(td);
+        tbody.appendChild(tr);
+    }
+}
+
+function button(label, className, onClick) {
+    const el = document.createElement('button');
+    el.textContent = label;
+    if (className) {
+        el.className = className;
+    }
+    el.addEventListener('click', async () => {
+        el.disabled = true;
+        try {
+            await onClick();
+            showError(null);
+        } catch (err) {
+            showError(err);
+        } finally {
+            el.disabled = false;
+        }
+    });
+    return el;
+}
+
+// Sign in
+
+$('signin-form').addEventListener('submit', async (event) => {
+    event.preventDefault();
+    const form = event.target;
+    $('signin-error').textContent = '';
+
+    try {
+        let data;
+        if (state.challengeToken) {
+            data = await api('POST', '/auth/2fa/challenge', {
+                challengeToken: state.challengeToken,
+                code: form.code.value.trim(),
+            });
+        } else {
+            data = await api('POST', '/auth/login', {
+                email: form.email.value.trim(),
+                password: form.password.value,
+            });
+        }
+
+        if (data.twoFactorRequired) {
+            state.challengeToken = data.challengeToken;
+            $('code-field').classList.remove('hidden');
+            form.code.focus();
+            return;
+        }
+
+        state.challengeToken = '';
+        sessionStorage.setItem(TOKEN_KEY, data.accessToken);
+        form.reset();
+        await start();
+    } catch (err) {
+        $('signin-error').textContent = err.message;
+    }
+});
+
+function signOut(message) {
+    sessionStorage.removeItem(TOKEN_KEY);
+    state.challengeToken = '';
+    $('code-field').classList.add('hidden');
+    $('app').classList.add('hidden');
+    $('signin').classList.remove('hidden');
+    $('signin-error').textContent = message || '';
+}
+
+$('signout').addEventListener('click', () => signOut());
+
+// start checks the account is a super admin before showing the back-office
+async function start() {
+    try {
+        const mode = await api('GET', '/admin/maintenance');
+        $('signin').classList.add('hidden');
+        $('app').classList.remove('hidden');
+        showMaintenance(mode);
+        show('servers');
+    } catch (err) {
+        signOut(err.message === 'forbidden' ? 'This account is not a super admin' : err.message);
+    }
+}
+
+// Views
+
+const loaders = {
+    servers: loadServers,
+    users: loadUsers,
+    reports: loadReports,
+    maintenance: loadMaintenance,
+};
+
+function show(view) {
+    document.querySelectorAll('nav button').forEach((el) => {
+        el.classList.toggle('active', el.dataset.view === view);
+    });
+    document.querySelectorAll('.view').forEach((el) => {
+        el.classList.toggle('hidden', el.id !== 'view-' + view);
+    });
+    loaders[view]().then(() => showError(null), showError);
+}
+
+document.querySelectorAll('nav button').forEach((el) => {
+    el.addEventListener('click', () => show(el.dataset.view));
+});
+
+// Servers
+
+async function loadServers() {
+    const servers = await api('GET', '/admin/servers');
+    fillRows($('servers-rows'), servers.map((server) => row([
+        server.serverId,
+        server.clients,
+        server.messagesPerSecond.toFixed(2),
+        server.errorsPerSecond.toFixed(2),
+        formatTime(server.reportedAt),
+    ])), 5);
+}
+
+$('servers-refresh').addEventListener('click', () => loadServers().then(() => showError(null), showError));
+
+// Users
+
+async function loadUsers() {
+    const form = $('users-form');
+    const query = new URLSearchParams({ limit: PAGE_SIZE, offset: state.usersOffset });
+    if (form.q.value.trim()) {
+        query.set('q', form.q.value.trim());
+    }
+    if (form.deactivated.value) {
+        query.set('deactivated', form.deactivated.value);
+    }
+
+    const users = await api('GET', '/admin/users?' + query);
+    fillRows($('users-rows'), users.map((user) => {
+        const action = user.deactivatedAt
+            ? button('Reactivate', 'secondary', async () => {
+                await api('POST', '/admin/users/' + encodeURIComponent(user.id) + '/reactivate');
+                await loadUsers();
+            })
+            : button('Deactivate', 'danger', async () => {
+                const reason = prompt('Deactivate ' + user.username + '? Reason (optional)');
+                if (reason === null) {
+                    return;
+                }
+                await api('POST', '/admin/users/' + encodeURIComponent(user.id) + '/deactivate', { reason });
+                await loadUsers();
+            });
+        return row([
+            user.username,
+            user.name,
+            user.email,
+            user.workspaceId,
+            user.role,
+            user.deactivatedAt ? 'Deactivated ' + formatTime(user.deactivatedAt) : 'Active',
+            action,
+        ]);
+    }), 7);
+
+    $('users-prev').disabled = state.usersOffset === 0;
+    $('users-next').disabled = users.length < PAGE_SIZE;
+}
+
+$('users-form').addEventListener('submit', (event) => {
+    event.preventDefault();
+    state.usersOffset = 0;
+    loadUsers().then(() => showError(null), showError);
+});
+$('users-prev').addEventListener('click', () => {
+    state.usersOffset = Math.max(0, state.usersOffset - PAGE_SIZE);
+    loadUsers().then(() => showError(null), showError);
+});
+$('users-next').addEventListener('click', () => {
+    state.usersOffset += PAGE_SIZE;
+    loadUsers().then(() => showError(null), showError);
+});
+
+// Reports
+
+async function loadReports() {
+    const form = $('reports-form');
+    const query = new URLSearchParams({ status: form.status.value, limit: PAGE_SIZE, offset: state.reportsOffset });
+    if (form.targetType.value) {
+        query.set('targetType', form.targetType.value);
+    }
+
+    const reports = await api('GET', '/admin/reports?' + query);
+    fillRows($('reports-rows'), reports.map((report) => {
+        const resolve = (decision) => async () => {
+            const note = prompt('Note (optional)');
+            if (note === null) {
+                return;
+            }
+            await api('POST', '/admin/reports/' + encodeURIComponent(report.id) + '/resolve', { decision, note });
+            await loadReports();
+        };
+
+        const actions = document.createElement('span');
+        if (report.status === 'open') {
+            actions.appendChild(button('Resolve', '', resolve('resolve')));
+            actions.appendChild(button('Dismiss', 'secondary', resolve('dismiss')));
+        } else {
+            actions.textContent = report.status + ' ' + formatTime(report.resolvedAt) + (report.note ? ': ' + report.note : '');
+        }
+
+        return row([
+            formatTime(report.createdAt),
+            report.targetType,
+            report.reportedUserId,
+            report.reason,
+            report.message ? report.message.message : '',
+            actions,
+        ]);
+    }), 6);
+
+    $('reports-prev').disabled = state.reportsOffset === 0;
+    $('reports-next').disabled = reports.length < PAGE_SIZE;
+}
+
+$('reports-form').addEventListener('submit', (event) => {
+    event.preventDefault();
+    state.reportsOffset = 0;
+    loadReports().then(() => showError(null), showError);
+});
+$('reports-prev').addEventListener('click', () => {
+    state.reportsOffset = Math.max(0, state.reportsOffset - PAGE_SIZE);
+    loadReports().then(() => showError(null), showError);
+});
+$('reports-next').addEventListener('click', () => {
+    state.reportsOffset += PAGE_SIZE;
+    loadReports().then(() => showError(null), showError);
+});
+
+// Maintenance
+
+async function loadMaintenance() {
+    showMaintenance(await api('GET', '/admin/maintenance'));
+}
+
+function showMaintenance(mode) {
+    const banner = $('banner');
+    banner.classList.toggle('hidden', !mode.enabled);
+    banner.textContent = 'Maintenance mode is on, the API only answers super admins';
+
+    $('maintenance-state').textContent = mode.enabled
+        ? 'On since ' + formatTime(mode.updatedAt) + (mode.message ? ': ' + mode.message : '')
+        : 'Off' + (mode.updatedAt ? ' since ' + formatTime(mode.updatedAt) : '');
+    $('maintenance-form').message.value = mode.message || '';
+}
+
+$('maintenance-form').addEventListener('submit', async (event) => {
+    event.preventDefault();
+    const enabled = event.submitter && event.submitter.value === 'true';
+    if (enabled && !confirm('Take the API down for every user but the super admins?')) {
+        return;
+    }
+
+    try {
+        const mode = await api('PUT', '/admin/maintenance', {
+            enabled,
+            message: event.target.message.value.trim(),
+        });
+        showMaintenance(mode);
+        showError(null);
+    } catch (err) {
+        showError(err);
+    }
+});
+
+if (sessionStorage.getItem(TOKEN_KEY)) {
+    start();
+}
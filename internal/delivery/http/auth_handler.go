@@ -2,11 +2,14 @@ package http
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
 	"wetalk/internal/entity"
 	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type AuthHandler struct {
@@ -27,9 +30,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		response := Response{
 			Message: "invalid request body",
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
@@ -38,9 +39,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		response := Response{
 			Message: "email, username, password, and name are required",
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
@@ -49,9 +48,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		response := Response{
 			Message: "password must be at least 6 characters",
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
@@ -60,32 +57,17 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		response := Response{
 			Message: "username must be at least 3 characters",
 		}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
+	req.RemoteIp = clientIp(r)
+	req.UserAgent = r.UserAgent()
 	authResponse, err := h.authUc.Register(r.Context(), req)
 	if err != nil {
-		log.Printf("Register error: %v", err)
-
-		statusCode := http.StatusInternalServerError
-		message := "internal server error"
-
-		switch err {
-		case usecase.ErrEmailAlreadyTaken:
-			statusCode = http.StatusConflict
-			message = "email already taken"
-		case usecase.ErrUsernameAlreadyTaken:
-			statusCode = http.StatusConflict
-			message = "username already taken"
-		}
+		slog.ErrorContext(r.Context(), "Register error", "error", err)
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
@@ -99,9 +81,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		Message: "registration successful",
 		Data:    authResponse,
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusCreated, response)
 }
 
 // POST /auth/login
@@ -110,36 +90,79 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response := Response{Message: "invalid request body"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	if req.Email == "" || req.Password == "" {
 		response := Response{Message: "email and password are required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
+	req.RemoteIp = clientIp(r)
+	req.UserAgent = r.UserAgent()
 	authResponse, err := h.authUc.Login(r.Context(), req)
 	if err != nil {
-		log.Printf("Login error: %v", err)
+		slog.ErrorContext(r.Context(), "Login error", "error", err)
 
-		statusCode := http.StatusInternalServerError
-		message := "internal server error"
+		// A missing or failed captcha is part of the sign in, not a malformed request
+		writeError(w, r, err, "internal server error",
+			errorStatus{usecase.ErrCaptchaRequired, http.StatusUnauthorized},
+			errorStatus{usecase.ErrInvalidCaptcha, http.StatusUnauthorized},
+		)
+		return
+	}
 
-		if err == usecase.ErrInvalidCredentials {
-			statusCode = http.StatusUnauthorized
-			message = "invalid email or password"
+	// The password was right, the login continues with POST /auth/2fa/challenge
+	if authResponse.TwoFactorRequired {
+		response := Response{
+			Message: "two-factor authentication required",
+			Data: entity.AuthResponse{
+				TwoFactorRequired: true,
+				ChallengeToken:    authResponse.ChallengeToken,
+			},
 		}
+		writeJSON(w, r, http.StatusOK, response)
+		return
+	}
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	// Set refresh token as HttpOnly cookie
+	h.setRefreshTokenCookie(w, authResponse.RefreshToken)
+
+	// Don't send refresh token in JSON response (it's in cookie)
+	authResponse.RefreshToken = ""
+
+	response := Response{
+		Message: "login successful",
+		Data:    authResponse,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /auth/2fa/challenge
+func (h *AuthHandler) CompleteTwoFactorChallenge(w http.ResponseWriter, r *http.Request) {
+	var req entity.TwoFactorChallengeRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if req.ChallengeToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		response := Response{Message: "challengeToken and a code or recoveryCode are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	req.RemoteIp = clientIp(r)
+	req.UserAgent = r.UserAgent()
+	authResponse, err := h.authUc.CompleteTwoFactorChallenge(r.Context(), req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Two-factor challenge error", "error", err)
+
+		writeError(w, r, err, "internal server error", errorStatus{usecase.ErrInvalidTwoFactorCode, http.StatusUnauthorized})
 		return
 	}
 
@@ -153,59 +176,107 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		Message: "login successful",
 		Data:    authResponse,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /auth/2fa/setup
+func (h *AuthHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// Get user from context (set by auth middleware)
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	setup, err := h.authUc.SetupTwoFactor(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Two-factor setup error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "scan the provisioning uri with an authenticator app, then verify a code to enable two-factor authentication",
+		Data:    setup,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /auth/2fa/verify
+func (h *AuthHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// Get user from context (set by auth middleware)
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.TwoFactorVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		response := Response{Message: "code is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	enabled, err := h.authUc.EnableTwoFactor(r.Context(), userClaims.UserId, req.Code)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Two-factor verify error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "two-factor authentication enabled, store the recovery codes somewhere safe",
+		Data:    enabled,
+	}
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // POST /auth/refresh
 func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	// The body is optional, it reports the app and carries the token when there is no cookie
+	var req entity.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		req = entity.RefreshTokenRequest{}
+	}
+
 	// Try to get refresh token from cookie first
-	refreshToken := ""
+	refreshToken := req.RefreshToken
 	cookie, err := r.Cookie("refresh_token")
-	if err == nil {
+	if err == nil && cookie.Value != "" {
 		refreshToken = cookie.Value
 	}
 
-	// If not in cookie, try to get from request body
-	if refreshToken == "" {
-		var req entity.RefreshTokenRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err == nil {
-			refreshToken = req.RefreshToken
-		}
-	}
-
 	if refreshToken == "" {
 		response := Response{Message: "refresh token is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	authResponse, err := h.authUc.RefreshToken(r.Context(), refreshToken)
+	authResponse, err := h.authUc.RefreshToken(r.Context(), entity.RefreshTokenRequest{
+		RefreshToken: refreshToken,
+		RemoteIp:     clientIp(r),
+		UserAgent:    r.UserAgent(),
+		Client:       req.Client,
+	})
 	if err != nil {
-		log.Printf("Refresh token error: %v", err)
+		slog.ErrorContext(r.Context(), "Refresh token error", "error", err)
 
-		statusCode := http.StatusUnauthorized
+		// Every failure signs the client out, only the message tells why
 		message := "invalid or expired refresh token"
-
-		switch err {
-		case usecase.ErrInvalidRefreshToken:
-			message = "invalid refresh token"
-		case usecase.ErrExpiredRefreshToken:
-			message = "refresh token has expired"
-		case usecase.ErrRevokedRefreshToken:
-			message = "refresh token has been revoked"
+		if usecase.IsAny(err, usecase.ErrInvalidRefreshToken, usecase.ErrExpiredRefreshToken, usecase.ErrRevokedRefreshToken) {
+			message = err.Error()
 		}
 
 		// Clear the invalid cookie
 		h.clearRefreshTokenCookie(w)
 
 		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
@@ -219,9 +290,7 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		Message: "token refreshed successfully",
 		Data:    authResponse,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // POST /auth/logout
@@ -244,7 +313,7 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	if refreshToken != "" {
 		err := h.authUc.Logout(r.Context(), refreshToken)
 		if err != nil {
-			log.Printf("Logout error: %v", err)
+			slog.ErrorContext(r.Context(), "Logout error", "error", err)
 		}
 	}
 
@@ -254,9 +323,7 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	response := Response{
 		Message: "logout successful",
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // POST /auth/logout-all
@@ -265,19 +332,15 @@ func (h *AuthHandler) LogoutAllDevices(w http.ResponseWriter, r *http.Request) {
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	err := h.authUc.LogoutAllDevices(r.Context(), userClaims.UserId)
 	if err != nil {
-		log.Printf("Logout all devices error: %v", err)
+		slog.ErrorContext(r.Context(), "Logout all devices error", "error", err)
 		response := Response{Message: "internal server error"}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusInternalServerError, response)
 		return
 	}
 
@@ -287,9 +350,93 @@ func (h *AuthHandler) LogoutAllDevices(w http.ResponseWriter, r *http.Request) {
 	response := Response{
 		Message: "logged out from all devices successfully",
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /auth/sessions - List the signed in devices of the user with the app they reported
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	// Get user from context (set by auth middleware)
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	sessions, err := h.authUc.ListSessions(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List sessions error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    sessions,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/users/:userId/sessions - List the signed in devices of a user with the app they reported (super admin only)
+func (h *AuthHandler) AdminListUserSessions(w http.ResponseWriter, r *http.Request) {
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	sessions, err := h.authUc.ListSessions(r.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List user sessions error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    sessions,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /user/me/password
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Get user from context (set by auth middleware)
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// The cookie identifies the current device better than the body
+	cookie, err := r.Cookie("refresh_token")
+	if err == nil {
+		req.RefreshToken = cookie.Value
+	}
+
+	err = h.authUc.ChangePassword(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Change password error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "password changed successfully, other devices have been logged out",
+	}
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // Helper function to set refresh token cookie
@@ -319,4 +466,12 @@ func (h *AuthHandler) clearRefreshTokenCookie(w http.ResponseWriter) {
 		Expires:  time.Unix(0, 0),
 	}
 	http.SetCookie(w, cookie)
-}
\ No newline at end of file
+}
+// clientIp returns the address of the client without the port
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
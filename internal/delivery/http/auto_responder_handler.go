@@ -0,0 +1,121 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AutoResponderHandler struct {
+	autoResponderUc usecase.AutoResponderUsecase
+}
+
+func NewAutoResponderHandler(autoResponderUc usecase.AutoResponderUsecase) *AutoResponderHandler {
+	return &AutoResponderHandler{
+		autoResponderUc: autoResponderUc,
+	}
+}
+
+// GET /chat/:chatId/auto-responder - Get the auto-reply rules of a chat
+func (h *AutoResponderHandler) GetAutoResponder(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	autoResponder, err := h.autoResponderUc.Get(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get auto-responder error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    autoResponder,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /chat/:chatId/auto-responder - Replace the auto-reply rules of a group chat (admin only)
+func (h *AutoResponderHandler) UpdateAutoResponder(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.UpdateAutoResponderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	autoResponder, err := h.autoResponderUc.Update(r.Context(), chatId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update auto-responder error", "error", err)
+
+		writeError(w, r, err, "failed to update auto-responder")
+		return
+	}
+
+	response := Response{
+		Message: "auto-responder updated successfully",
+		Data:    autoResponder,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/auto-responder - Turn the auto-responder of a group chat off (admin only)
+func (h *AutoResponderHandler) DeleteAutoResponder(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.autoResponderUc.Delete(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Delete auto-responder error", "error", err)
+
+		writeError(w, r, err, "failed to delete auto-responder")
+		return
+	}
+
+	response := Response{Message: "auto-responder deleted successfully"}
+	writeJSON(w, r, http.StatusOK, response)
+}
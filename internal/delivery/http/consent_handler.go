@@ -0,0 +1,134 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+)
+
+type ConsentHandler struct {
+	consentUc usecase.ConsentUsecase
+}
+
+func NewConsentHandler(consentUc usecase.ConsentUsecase) *ConsentHandler {
+	return &ConsentHandler{
+		consentUc: consentUc,
+	}
+}
+
+// GET /legal/documents - Get the latest terms of service and privacy policy
+func (h *ConsentHandler) GetDocuments(w http.ResponseWriter, r *http.Request) {
+	documents, err := h.consentUc.GetLatestDocuments(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get legal documents error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    documents,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /legal/pending - Get the documents the authenticated user still has to accept
+func (h *ConsentHandler) GetPendingDocuments(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	documents, err := h.consentUc.GetPendingDocuments(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get pending documents error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    documents,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /legal/accept - Accept the latest version of a legal document
+func (h *ConsentHandler) AcceptDocument(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.AcceptDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if req.Type == "" || req.Version == "" {
+		response := Response{Message: "type and version are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	ipAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ipAddress = r.RemoteAddr
+	}
+
+	err = h.consentUc.Accept(r.Context(), userClaims.UserId, req, ipAddress)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Accept document error", "error", err)
+
+		writeError(w, r, err, "failed to record acceptance")
+		return
+	}
+
+	response := Response{
+		Message: "document accepted",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/legal/documents - Publish a new document version (super admin only)
+func (h *ConsentHandler) PublishDocument(w http.ResponseWriter, r *http.Request) {
+	var req entity.PublishDocumentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if req.Version == "" || (req.Url == "" && req.Content == "") {
+		response := Response{Message: "version and either url or content are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	document, err := h.consentUc.PublishDocument(r.Context(), req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Publish document error", "error", err)
+
+		writeError(w, r, err, "failed to publish document")
+		return
+	}
+
+	response := Response{
+		Message: "document published successfully",
+		Data:    document,
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
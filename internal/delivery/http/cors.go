@@ -0,0 +1,160 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures which browser origins may call the API
+type CORSOptions struct {
+	// AllowedOrigins are exact origins, "*" or wildcard subdomains such as "https://*.example.com"
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts of the allowed origins can read
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+	// ExcludedPaths are path prefixes that never get CORS headers
+	ExcludedPaths []string
+}
+
+type wildcardOrigin struct {
+	scheme string
+	suffix string // ".example.com"
+}
+
+type CORSMiddleware struct {
+	options   CORSOptions
+	allowAll  bool
+	exact     map[string]bool
+	wildcards []wildcardOrigin
+}
+
+func NewCORSMiddleware(options CORSOptions) *CORSMiddleware {
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	}
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = []string{"Content-Type", "Authorization", APIVersionHeader}
+	}
+	if len(options.ExposedHeaders) == 0 {
+		options.ExposedHeaders = []string{APIVersionHeader, RateLimitLimitHeader, RateLimitRemainingHeader, RateLimitResetHeader, "Retry-After"}
+	}
+
+	m := &CORSMiddleware{
+		options: options,
+		exact:   make(map[string]bool),
+	}
+
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			m.allowAll = true
+			continue
+		}
+
+		if scheme, host, ok := strings.Cut(origin, "://*."); ok {
+			m.wildcards = append(m.wildcards, wildcardOrigin{
+				scheme: scheme,
+				suffix: "." + strings.ToLower(host),
+			})
+			continue
+		}
+
+		m.exact[strings.ToLower(origin)] = true
+	}
+
+	return m
+}
+
+// Handler applies CORS headers and answers preflight requests
+func (m *CORSMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.isExcluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on the origin, so caches must not share it across origins
+		w.Header().Add("Vary", "Origin")
+
+		isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if isPreflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !m.isAllowed(origin) {
+			if isPreflight {
+				// No CORS headers, the browser rejects the actual request
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A literal "*" can't be combined with credentials, so echo the origin instead
+		if m.allowAll && !m.options.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if m.options.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if isPreflight {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(m.options.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(m.options.AllowedHeaders, ", "))
+			if m.options.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(m.options.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(m.options.ExposedHeaders, ", "))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *CORSMiddleware) isAllowed(origin string) bool {
+	if m.allowAll {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+
+	if len(m.wildcards) == 0 {
+		return false
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, wildcard := range m.wildcards {
+		if parsed.Scheme == wildcard.scheme && strings.HasSuffix(parsed.Host, wildcard.suffix) && len(parsed.Host) > len(wildcard.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *CORSMiddleware) isExcluded(path string) bool {
+	for _, prefix := range m.options.ExcludedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
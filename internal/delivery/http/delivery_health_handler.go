@@ -0,0 +1,80 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type DeliveryHealthHandler struct {
+	deliveryHealthUc usecase.DeliveryHealthUsecase
+}
+
+func NewDeliveryHealthHandler(deliveryHealthUc usecase.DeliveryHealthUsecase) *DeliveryHealthHandler {
+	return &DeliveryHealthHandler{
+		deliveryHealthUc: deliveryHealthUc,
+	}
+}
+
+// GET /admin/delivery-health - Deactivated devices, bounced email addresses and the last cleanup (super admin only)
+func (h *DeliveryHealthHandler) AdminGetDeliveryHealth(w http.ResponseWriter, r *http.Request) {
+	health, err := h.deliveryHealthUc.GetStats(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get delivery health error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    health,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/delivery-health/cleanup - Delete the devices deactivated past the retention now (super admin only)
+func (h *DeliveryHealthHandler) AdminCleanupDevices(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.deliveryHealthUc.PurgeDeactivatedDevices(r.Context()); err != nil {
+		slog.ErrorContext(r.Context(), "Purge deactivated devices error", "error", err)
+
+		writeError(w, r, err, "failed to purge deactivated devices")
+		return
+	}
+
+	health, err := h.deliveryHealthUc.GetStats(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get delivery health error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "deactivated devices purged",
+		Data:    health,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /admin/users/:userId/email-bounces - Let emails go to the user's address again once it is fixed (super admin only)
+func (h *DeliveryHealthHandler) AdminClearEmailBounces(w http.ResponseWriter, r *http.Request) {
+	userId := chi.URLParam(r, "userId")
+	if userId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if err := h.deliveryHealthUc.ClearEmailBounces(r.Context(), userId); err != nil {
+		slog.ErrorContext(r.Context(), "Clear email bounces error", "error", err)
+
+		writeError(w, r, err, "failed to clear email bounces")
+		return
+	}
+
+	response := Response{Message: "email bounces cleared"}
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"wetalk/pkg/naming"
+)
+
+// APIVersionHeader picks the response format of a request, the server echoes the version it used
+const APIVersionHeader = "API-Version"
+
+const apiVersionContextKey contextKey = "apiVersion"
+
+// ResponseFormat is how the JSON responses of an API version are written
+type ResponseFormat struct {
+	// SnakeCase renames the fields of the responses from camelCase to snake_case, keys of maps
+	// such as the user ids of a presence list are renamed as well
+	SnakeCase bool
+	// DataEnvelope wraps the responses in {"data": ..., "meta": {"message": ..., "pagination": ...}}
+	// instead of {"message": ..., "data": ...}
+	DataEnvelope bool
+}
+
+// apiVersions are the formats clients can ask for, 1 is the format the API always had and
+// 1-compat the one of the clients written against snake_case APIs
+var apiVersions = map[string]ResponseFormat{
+	"1":        {},
+	"1-compat": {SnakeCase: true, DataEnvelope: true},
+}
+
+// Pagination describes the page of a list response, it is only written in the data envelope
+type Pagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// Count is the number of items of this page, a page shorter than the limit is the last one
+	Count int `json:"count"`
+}
+
+type envelope struct {
+	Data any          `json:"data"`
+	Meta envelopeMeta `json:"meta"`
+}
+
+type envelopeMeta struct {
+	Message    string      `json:"message"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// APIVersionMiddleware negotiates the response format of every request from the API-Version header
+type APIVersionMiddleware struct {
+	defaultVersion string
+}
+
+// NewAPIVersionMiddleware answers with the format of defaultVersion the requests that don't ask
+// for a version
+func NewAPIVersionMiddleware(defaultVersion string) (*APIVersionMiddleware, error) {
+	if _, ok := apiVersions[defaultVersion]; !ok {
+		return nil, fmt.Errorf("unknown API version %q, supported versions are %s", defaultVersion, supportedAPIVersions())
+	}
+	return &APIVersionMiddleware{defaultVersion: defaultVersion}, nil
+}
+
+func (m *APIVersionMiddleware) Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := strings.TrimSpace(r.Header.Get(APIVersionHeader))
+		if version == "" {
+			version = m.defaultVersion
+		}
+
+		w.Header().Add("Vary", APIVersionHeader)
+		format, ok := apiVersions[version]
+		if !ok {
+			// Answered in the default format, the client can't be expected to read its own
+			response := Response{Message: fmt.Sprintf("unsupported API version %q, supported versions are %s", version, supportedAPIVersions())}
+			r = r.WithContext(context.WithValue(r.Context(), apiVersionContextKey, apiVersions[m.defaultVersion]))
+			writeJSON(w, r, http.StatusBadRequest, response)
+			return
+		}
+
+		w.Header().Set(APIVersionHeader, version)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionContextKey, format)))
+	})
+}
+
+func supportedAPIVersions() string {
+	versions := make([]string, 0, len(apiVersions))
+	for version := range apiVersions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ", ")
+}
+
+// responseFormat returns the format negotiated for the request, the format of version 1 when
+// the request didn't go through the middleware
+func responseFormat(r *http.Request) ResponseFormat {
+	format, _ := r.Context().Value(apiVersionContextKey).(ResponseFormat)
+	return format
+}
+
+// writeJSON writes the response in the format of the API version of the request, every JSON
+// response of the handlers goes through it
+func writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, response Response) {
+	format := responseFormat(r)
+
+	var body any = response
+	if format.DataEnvelope {
+		body = envelope{
+			Data: response.Data,
+			Meta: envelopeMeta{Message: response.Message, Pagination: response.Pagination},
+		}
+	}
+
+	if format.SnakeCase {
+		renamed, err := renameFields(body, naming.SnakeCase)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Rename response fields error", "error", err)
+			statusCode = http.StatusInternalServerError
+			renamed = map[string]any{"message": "internal server error"}
+		}
+		body = renamed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+// renameFields goes through the JSON of the body to rename its fields, numbers are kept as written
+func renameFields(body any, rename func(string) string) (any, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return naming.RenameKeys(document, rename), nil
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"wetalk/internal/usecase"
+)
+
+// statusClientClosedRequest answers requests whose client went away, it is never read but shows up
+// in the logs and metrics
+const statusClientClosedRequest = 499
+
+// errorStatus maps an error, and every error wrapping it, to a status code
+type errorStatus struct {
+	err    error
+	status int
+}
+
+// errorStatuses is how every handler answers the usecase errors
+var errorStatuses = []errorStatus{
+	// 400
+	{usecase.ErrInvalidChatType, http.StatusBadRequest},
+	{usecase.ErrCannotInviteToPersonal, http.StatusBadRequest},
+	{usecase.ErrCannotLeavePersonal, http.StatusBadRequest},
+	{usecase.ErrCannotRemoveSelf, http.StatusBadRequest},
+	{usecase.ErrCannotDeactivateSelf, http.StatusBadRequest},
+	{usecase.ErrCannotReportSelf, http.StatusBadRequest},
+	{usecase.ErrInvalidMembershipVersion, http.StatusBadRequest},
+	{usecase.ErrUnderMinimumAge, http.StatusBadRequest},
+	{usecase.ErrInvalidCaptcha, http.StatusBadRequest},
+	{usecase.ErrInvalidTwoFactorCode, http.StatusBadRequest},
+	{usecase.ErrTwoFactorNotSetUp, http.StatusBadRequest},
+	{usecase.ErrMergeWorkspaceMismatch, http.StatusBadRequest},
+	{usecase.ErrGuestCannotBeAdmin, http.StatusBadRequest},
+
+	// 401
+	{usecase.ErrInvalidCredentials, http.StatusUnauthorized},
+	{usecase.ErrInvalidChallenge, http.StatusUnauthorized},
+	{usecase.ErrInvalidRefreshToken, http.StatusUnauthorized},
+	{usecase.ErrExpiredRefreshToken, http.StatusUnauthorized},
+	{usecase.ErrRevokedRefreshToken, http.StatusUnauthorized},
+
+	// 403
+	{usecase.ErrNotParticipant, http.StatusForbidden},
+	{usecase.ErrNotAdmin, http.StatusForbidden},
+	{usecase.ErrChannelReadOnly, http.StatusForbidden},
+	{usecase.ErrNotWorkspaceAdmin, http.StatusForbidden},
+	{usecase.ErrInvalidInvitation, http.StatusForbidden},
+	{usecase.ErrRestrictedAccount, http.StatusForbidden},
+	{usecase.ErrAccountSuspended, http.StatusForbidden},
+	{usecase.ErrAccountDeactivated, http.StatusForbidden},
+	{usecase.ErrGroupSizeLimit, http.StatusForbidden},
+	{usecase.ErrScheduledMessageLimit, http.StatusForbidden},
+	{usecase.ErrInvalidPassword, http.StatusForbidden},
+	{usecase.ErrInviteOnly, http.StatusForbidden},
+	{usecase.ErrCannotPinMessage, http.StatusForbidden},
+	{usecase.ErrPinLimit, http.StatusForbidden},
+
+	// 404
+	{usecase.ErrChatNotFound, http.StatusNotFound},
+	{usecase.ErrInvitationNotFound, http.StatusNotFound},
+	{usecase.ErrMemberNotFound, http.StatusNotFound},
+	{usecase.ErrMessageNotFound, http.StatusNotFound},
+	{usecase.ErrUserNotFound, http.StatusNotFound},
+	{usecase.ErrAutoResponderNotFound, http.StatusNotFound},
+	{usecase.ErrDeviceNotFound, http.StatusNotFound},
+	{usecase.ErrDocumentNotFound, http.StatusNotFound},
+	{usecase.ErrSessionNotFound, http.StatusNotFound},
+	{usecase.ErrNoRestriction, http.StatusNotFound},
+	{usecase.ErrFlaggedMessageNotFound, http.StatusNotFound},
+	{usecase.ErrReportNotFound, http.StatusNotFound},
+	{usecase.ErrScheduledMessageNotFound, http.StatusNotFound},
+	{usecase.ErrAccountMergeNotFound, http.StatusNotFound},
+	{usecase.ErrMessagePurgeNotFound, http.StatusNotFound},
+	{usecase.ErrInvalidInviteLink, http.StatusNotFound},
+	{usecase.ErrInviteLinkNotFound, http.StatusNotFound},
+	{usecase.ErrJoinRequestNotFound, http.StatusNotFound},
+	{usecase.ErrPinNotFound, http.StatusNotFound},
+
+	// 409
+	{usecase.ErrEmailAlreadyTaken, http.StatusConflict},
+	{usecase.ErrUsernameAlreadyTaken, http.StatusConflict},
+	{usecase.ErrLastAdmin, http.StatusConflict},
+	{usecase.ErrLastChannelAdmin, http.StatusConflict},
+	{usecase.ErrAlreadyParticipant, http.StatusConflict},
+	{usecase.ErrInvitationResponded, http.StatusConflict},
+	{usecase.ErrOutdatedVersion, http.StatusConflict},
+	{usecase.ErrAppealAlreadySent, http.StatusConflict},
+	{usecase.ErrTwoFactorAlreadyEnabled, http.StatusConflict},
+	{usecase.ErrAlreadyReported, http.StatusConflict},
+	{usecase.ErrAccountMerged, http.StatusConflict},
+	{usecase.ErrPrimaryDeactivated, http.StatusConflict},
+	{usecase.ErrMergeNotLatest, http.StatusConflict},
+	{usecase.ErrJoinRequestResponded, http.StatusConflict},
+	{usecase.ErrMessageAlreadyPinned, http.StatusConflict},
+
+	// 410
+	{usecase.ErrInvitationExpired, http.StatusGone},
+	{usecase.ErrInviteLinkExpired, http.StatusGone},
+	{usecase.ErrInviteLinkRevoked, http.StatusGone},
+
+	// 503
+	{usecase.ErrModerationUnavailable, http.StatusServiceUnavailable},
+
+	// Queries stopped early
+	{usecase.ErrCanceled, statusClientClosedRequest},
+	{usecase.ErrTimeout, http.StatusServiceUnavailable},
+}
+
+// writeError answers err with the status and the message of the first matching override or
+// errorStatuses entry, the context wrapped around it stays in the logs. Validation errors are a
+// 400 naming the field, anything else is a 500 with the fallback message, the handlers log the
+// error before.
+func writeError(w http.ResponseWriter, r *http.Request, err error, fallback string, overrides ...errorStatus) {
+	statusCode := http.StatusInternalServerError
+	response := Response{Message: fallback}
+
+	var validationErr *usecase.ValidationError
+	matched := false
+	for _, candidate := range append(overrides, errorStatuses...) {
+		if errors.Is(err, candidate.err) {
+			statusCode = candidate.status
+			response.Message = candidate.err.Error()
+			matched = true
+			break
+		}
+	}
+	if !matched && errors.As(err, &validationErr) {
+		statusCode = http.StatusBadRequest
+		response = Response{
+			Message: validationErr.Error(),
+			Data:    map[string]string{"field": validationErr.Field},
+		}
+	}
+
+	writeJSON(w, r, statusCode, response)
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"wetalk/internal/entity"
+	"wetalk/pkg/naming"
+)
+
+// parseFields reads the fields query parameter of a list, the comma separated JSON names of the
+// fields of model to return. computed are the fields added to the JSON of model by its encoder
+func parseFields(r *http.Request, model any, computed ...string) (entity.Fields, error) {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var names []string
+	t := reflect.TypeOf(model)
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	names = append(names, computed...)
+
+	// The fields are asked for by the names the API version of the request writes them with
+	snakeCase := responseFormat(r).SnakeCase
+	known := make(map[string]string, len(names))
+	for _, name := range names {
+		if snakeCase {
+			known[naming.SnakeCase(name)] = name
+		} else {
+			known[name] = name
+		}
+	}
+
+	var fields entity.Fields
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// sparse keeps only the selected fields of the items of a list
+func sparse(items any, fields entity.Fields) (any, error) {
+	if len(fields) == 0 {
+		return items, nil
+	}
+
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &objects); err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		for name := range object {
+			if !fields.Has(name) {
+				delete(object, name)
+			}
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"wetalk/infrastructure/ws"
+	"wetalk/internal/usecase"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+// graphqlMaxBodySize bounds the body of a GraphQL request
+const graphqlMaxBodySize = 1 << 20
+
+// graphqlRequest is the body of a GraphQL request, and the payload of a subscribe message
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+// GraphQLHandler serves the chats and messages as a GraphQL API. Queries and mutations are
+// posted, subscriptions run over a websocket bridged to the hub, see Subscribe
+type GraphQLHandler struct {
+	chatUc    usecase.ChatUsecase
+	userUc    usecase.UserUsecase
+	messageUc usecase.MessageUsecase
+	authUc    usecase.AuthUsecase
+	consentUc usecase.ConsentUsecase
+	abuseUc   usecase.AbuseUsecase
+	metricsUc usecase.MetricsUsecase
+	hub       ws.IHub
+	heartbeat ws.Heartbeat
+
+	schema *graphql.Schema
+}
+
+func NewGraphQLHandler(chatUc usecase.ChatUsecase, userUc usecase.UserUsecase, messageUc usecase.MessageUsecase, authUc usecase.AuthUsecase, consentUc usecase.ConsentUsecase, abuseUc usecase.AbuseUsecase, metricsUc usecase.MetricsUsecase, hub ws.IHub, heartbeat ws.Heartbeat) *GraphQLHandler {
+	h := &GraphQLHandler{
+		chatUc:    chatUc,
+		userUc:    userUc,
+		messageUc: messageUc,
+		authUc:    authUc,
+		consentUc: consentUc,
+		abuseUc:   abuseUc,
+		metricsUc: metricsUc,
+		hub:       hub,
+		heartbeat: heartbeat,
+	}
+	h.schema = h.newGraphQLSchema()
+	return h
+}
+
+// POST /graphql - Run a GraphQL query or mutation
+func (h *GraphQLHandler) Query(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, graphqlMaxBodySize)).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// Field errors are part of a successful response, only a request that could not run is a 400
+	result := h.schema.Exec(r.Context(), req.Query, req.OperationName, req.Variables)
+	presentGraphQLErrors(r.Context(), result.Errors)
+	status := http.StatusOK
+	if result.Data == nil && len(result.Errors) > 0 {
+		status = http.StatusBadRequest
+	}
+
+	// GraphQL results keep the shape of the spec whatever the API version of the request
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
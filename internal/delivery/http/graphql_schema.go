@@ -0,0 +1,911 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	wsDelivery "wetalk/internal/delivery/websocket"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+const (
+	// graphqlMaxPageSize bounds the "first" argument of the message connections, 20 by default
+	graphqlMaxPageSize = 100
+	// graphqlMaxDepth bounds how deeply the selections of an operation nest, fragments included,
+	// so a client can't have the resolvers walk the graph without end
+	graphqlMaxDepth = 10
+)
+
+var (
+	ErrInvalidCursor         = errors.New("invalid cursor")
+	ErrInvalidPageSize       = errors.New("first must be between 1 and 100")
+	ErrSubscriptionTransport = errors.New("subscriptions must be sent over a websocket")
+)
+
+// graphqlErrorStatuses are the errors of the resolvers themselves, on top of errorStatuses
+var graphqlErrorStatuses = []errorStatus{
+	{ErrInvalidCursor, 400},
+	{ErrInvalidPageSize, 400},
+	{ErrSubscriptionTransport, 400},
+}
+
+// graphqlSchema exposes the chats, participants and messages of the viewer, the mutations
+// sending messages and managing groups, and the hub events as subscriptions. Timestamps and
+// counters beyond 32 bits are Floats, the Int of GraphQL can't hold them
+const graphqlSchema = `
+scalar Time
+scalar JSON
+
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+type Query {
+	me: Profile!
+	chats: [Chat!]!
+	chat(id: ID!): Chat!
+	participants(chatId: ID!): [User!]!
+	messages(chatId: ID!, first: Int = 20, after: String): MessageConnection!
+}
+
+type Mutation {
+	# sendMessage is null when the message was a command, answered on the websocket of the sender
+	sendMessage(chatId: ID!, message: String!, replyToMessageId: ID, clientMessageId: String, attachments: [AttachmentInput!]): Message
+	createPersonalChat(userId: ID!): Chat!
+	createGroupChat(name: String!, description: String, userIds: [ID!]!): Chat!
+	updateChat(chatId: ID!, name: String, description: String, avatar: String, historyAccess: String, joinPolicy: String, keepWhenEmpty: Boolean): Chat!
+	deleteChat(chatId: ID!): Boolean!
+	inviteToGroup(chatId: ID!, userIds: [ID!]!, note: String): Boolean!
+	leaveGroup(chatId: ID!): Boolean!
+	removeMember(chatId: ID!, userId: ID!): Boolean!
+	updateParticipantRole(chatId: ID!, userId: ID!, role: String!): Boolean!
+	respondToInvitation(invitationId: ID!, accept: Boolean!): Boolean!
+}
+
+type Subscription {
+	messageCreated(chatId: ID): Message!
+	# events are the other events of the hub, of the given types or all of them
+	events(chatId: ID, types: [String!]): Event!
+}
+
+type User {
+	id: ID!
+	username: String!
+	name: String!
+	avatar: String!
+	bio: String!
+	status: String!
+	# email is null unless the profile policy exposes it
+	email: String
+}
+
+type Profile {
+	id: ID!
+	username: String!
+	email: String!
+	name: String!
+	bio: String!
+	avatar: String!
+	isOnline: Boolean!
+	workspaceId: ID
+	role: String!
+	isRestricted: Boolean!
+	timezone: String!
+	locale: String!
+	createdAt: Time!
+	updatedAt: Time!
+}
+
+type Chat {
+	id: ID!
+	name: String!
+	type: String!
+	createdBy: ID!
+	workspaceId: ID
+	createdAt: Time!
+	updatedAt: Time!
+	description: String!
+	avatar: String!
+	keepWhenEmpty: Boolean!
+	membershipVersion: Float!
+	historyAccess: String!
+	joinPolicy: String!
+	isPinned: Boolean!
+	pinnedAt: Time
+	isMuted: Boolean!
+	mutedUntil: Time
+	isArchived: Boolean!
+	notificationMode: String!
+	mutedThreads: [ID!]!
+	participants: [User!]!
+	messages(first: Int = 20, after: String): MessageConnection!
+}
+
+type Message {
+	id: ID!
+	chatId: ID!
+	senderId: ID!
+	sender: User!
+	type: String!
+	clientMessageId: String
+	message: String!
+	# timestamp is when the server stored the message, in unix milliseconds
+	timestamp: Float!
+	seq: Float!
+	isRead: Boolean!
+	attachments: [Attachment!]!
+	replyToMessageId: ID
+	replyTo: QuotedMessage
+	mentions: [ID!]!
+	isAutoReply: Boolean!
+	deliveryState: String!
+	receipts: [Receipt!]!
+	deliveredAt: Time
+	readAt: Time
+}
+
+type Attachment {
+	url: String!
+	name: String!
+	mimeType: String!
+	size: Float!
+}
+
+input AttachmentInput {
+	url: String!
+	name: String!
+	mimeType: String!
+	size: Float!
+}
+
+type QuotedMessage {
+	messageId: ID!
+	senderId: ID!
+	snippet: String!
+}
+
+type Receipt {
+	userId: ID!
+	state: String!
+	deliveredAt: Time
+	readAt: Time
+}
+
+type MessageConnection {
+	edges: [MessageEdge!]!
+	pageInfo: PageInfo!
+}
+
+type MessageEdge {
+	cursor: String!
+	node: Message!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+
+type Event {
+	type: String!
+	chatId: ID
+	data: JSON
+}
+`
+
+// viewerId is the user running the operation, set by the auth middleware or the connection_init
+// of the subscription websocket
+func viewerId(ctx context.Context) string {
+	claims, ok := ctx.Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		return ""
+	}
+	return claims.UserId
+}
+
+// newGraphQLSchema binds graphqlSchema to the resolvers, the fields without a method of their
+// own read the entity they wrap
+func (h *GraphQLHandler) newGraphQLSchema() *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &graphqlResolver{h: h},
+		graphql.UseFieldResolvers(),
+		graphql.MaxDepth(graphqlMaxDepth),
+	)
+}
+
+// graphqlResolver resolves the fields of Query, Mutation and Subscription
+type graphqlResolver struct {
+	h *GraphQLHandler
+}
+
+func (r *graphqlResolver) Me(ctx context.Context) (*profileResolver, error) {
+	user, err := r.h.userUc.Get(ctx, viewerId(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &profileResolver{user}, nil
+}
+
+func (r *graphqlResolver) Chats(ctx context.Context) ([]*chatResolver, error) {
+	chats, err := r.h.chatUc.Index(ctx, viewerId(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*chatResolver, len(chats))
+	for i, chat := range chats {
+		resolvers[i] = &chatResolver{Chat: chat, h: r.h}
+	}
+	return resolvers, nil
+}
+
+func (r *graphqlResolver) Chat(ctx context.Context, args struct{ Id graphql.ID }) (*chatResolver, error) {
+	return r.h.chat(ctx, string(args.Id))
+}
+
+func (r *graphqlResolver) Participants(ctx context.Context, args struct{ ChatId graphql.ID }) ([]*userResolver, error) {
+	return r.h.participants(ctx, string(args.ChatId))
+}
+
+func (r *graphqlResolver) Messages(ctx context.Context, args struct {
+	ChatId graphql.ID
+	First  int32
+	After  *string
+}) (*messageConnectionResolver, error) {
+	return r.h.messageConnection(ctx, string(args.ChatId), graphqlPageArgs{First: args.First, After: args.After})
+}
+
+func (r *graphqlResolver) SendMessage(ctx context.Context, args struct {
+	ChatId           graphql.ID
+	Message          string
+	ReplyToMessageId *graphql.ID
+	ClientMessageId  *string
+	Attachments      *[]graphqlAttachmentInput
+}) (*messageResolver, error) {
+	userId := viewerId(ctx)
+	chatId := string(args.ChatId)
+
+	// Check participation before saving, the members of a channel only read it
+	if err := r.h.chatUc.CanPost(ctx, chatId, userId); err != nil {
+		return nil, err
+	}
+
+	message := entity.Message{
+		ChatId:   chatId,
+		SenderId: userId,
+		Message:  args.Message,
+	}
+	if args.ReplyToMessageId != nil {
+		message.ReplyToMessageId = string(*args.ReplyToMessageId)
+	}
+	if args.ClientMessageId != nil {
+		message.ClientMessageId = *args.ClientMessageId
+	}
+	if args.Attachments != nil {
+		for _, attachment := range *args.Attachments {
+			message.Attachments = append(message.Attachments, entity.Attachment{
+				Url:      attachment.Url,
+				Name:     attachment.Name,
+				MimeType: attachment.MimeType,
+				Size:     int64(attachment.Size),
+			})
+		}
+	}
+
+	saved, err := r.h.messageUc.SaveMessage(ctx, message)
+	if errors.Is(err, usecase.ErrCommandHandled) {
+		// The command answered its issuer on the websocket, nothing was stored
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &messageResolver{Message: saved, h: r.h}, nil
+}
+
+func (r *graphqlResolver) CreatePersonalChat(ctx context.Context, args struct{ UserId graphql.ID }) (*chatResolver, error) {
+	chatId, err := r.h.chatUc.CreatePersonalChat(ctx, viewerId(ctx), string(args.UserId))
+	if err != nil {
+		return nil, err
+	}
+	return r.h.chat(ctx, chatId)
+}
+
+func (r *graphqlResolver) CreateGroupChat(ctx context.Context, args struct {
+	Name        string
+	Description *string
+	UserIds     []graphql.ID
+}) (*chatResolver, error) {
+	chatId, err := r.h.chatUc.CreateGroupChat(ctx, args.Name, stringValue(args.Description), viewerId(ctx), graphqlIds(args.UserIds))
+	if err != nil {
+		return nil, err
+	}
+	return r.h.chat(ctx, chatId)
+}
+
+func (r *graphqlResolver) UpdateChat(ctx context.Context, args struct {
+	ChatId        graphql.ID
+	Name          *string
+	Description   *string
+	Avatar        *string
+	HistoryAccess *string
+	JoinPolicy    *string
+	KeepWhenEmpty *bool
+}) (*chatResolver, error) {
+	chat, err := r.h.chatUc.Update(ctx, string(args.ChatId), viewerId(ctx), entity.UpdateChatRequest{
+		Name:          args.Name,
+		Description:   args.Description,
+		Avatar:        args.Avatar,
+		HistoryAccess: args.HistoryAccess,
+		JoinPolicy:    args.JoinPolicy,
+		KeepWhenEmpty: args.KeepWhenEmpty,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chatResolver{Chat: chat, h: r.h}, nil
+}
+
+func (r *graphqlResolver) DeleteChat(ctx context.Context, args struct{ ChatId graphql.ID }) (bool, error) {
+	return true, r.h.chatUc.Delete(ctx, string(args.ChatId), viewerId(ctx))
+}
+
+func (r *graphqlResolver) InviteToGroup(ctx context.Context, args struct {
+	ChatId  graphql.ID
+	UserIds []graphql.ID
+	Note    *string
+}) (bool, error) {
+	return true, r.h.chatUc.InviteUsersToGroup(ctx, string(args.ChatId), viewerId(ctx), graphqlIds(args.UserIds), stringValue(args.Note))
+}
+
+func (r *graphqlResolver) LeaveGroup(ctx context.Context, args struct{ ChatId graphql.ID }) (bool, error) {
+	return true, r.h.chatUc.LeaveGroup(ctx, string(args.ChatId), viewerId(ctx))
+}
+
+func (r *graphqlResolver) RemoveMember(ctx context.Context, args struct{ ChatId, UserId graphql.ID }) (bool, error) {
+	return true, r.h.chatUc.RemoveMember(ctx, string(args.ChatId), viewerId(ctx), string(args.UserId))
+}
+
+func (r *graphqlResolver) UpdateParticipantRole(ctx context.Context, args struct {
+	ChatId graphql.ID
+	UserId graphql.ID
+	Role   string
+}) (bool, error) {
+	return true, r.h.chatUc.UpdateParticipantRole(ctx, string(args.ChatId), viewerId(ctx), string(args.UserId), args.Role)
+}
+
+func (r *graphqlResolver) RespondToInvitation(ctx context.Context, args struct {
+	InvitationId graphql.ID
+	Accept       bool
+}) (bool, error) {
+	return true, r.h.chatUc.RespondToInvitation(ctx, string(args.InvitationId), viewerId(ctx), args.Accept)
+}
+
+func (r *graphqlResolver) MessageCreated(ctx context.Context, args struct{ ChatId *graphql.ID }) (<-chan *messageResolver, error) {
+	chatId := graphqlIdValue(args.ChatId)
+	return listen(ctx, func(event hubEvent) (*messageResolver, bool) {
+		if !event.isMessage() || (chatId != "" && event.chatId() != chatId) {
+			return nil, false
+		}
+		message, err := event.message()
+		if err != nil {
+			return nil, false
+		}
+		return &messageResolver{Message: message, h: r.h}, true
+	})
+}
+
+func (r *graphqlResolver) Events(ctx context.Context, args struct {
+	ChatId *graphql.ID
+	Types  *[]string
+}) (<-chan *eventResolver, error) {
+	chatId := graphqlIdValue(args.ChatId)
+	var types []string
+	if args.Types != nil {
+		types = *args.Types
+	}
+	return listen(ctx, func(event hubEvent) (*eventResolver, bool) {
+		if event.isMessage() || (len(types) > 0 && !slices.Contains(types, event.Type)) ||
+			(chatId != "" && event.chatId() != chatId) {
+			return nil, false
+		}
+		return &eventResolver{event}, true
+	})
+}
+
+// chat returns the chat with the settings of the viewer, participation is checked
+func (h *GraphQLHandler) chat(ctx context.Context, chatId string) (*chatResolver, error) {
+	detail, err := h.chatUc.Get(ctx, chatId, viewerId(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &chatResolver{Chat: detail.Chat, h: h}, nil
+}
+
+func (h *GraphQLHandler) participants(ctx context.Context, chatId string) ([]*userResolver, error) {
+	users, err := h.chatUc.GetParticipants(ctx, chatId, viewerId(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*userResolver, len(users))
+	for i, user := range users {
+		resolvers[i] = &userResolver{user}
+	}
+	return resolvers, nil
+}
+
+type graphqlPageArgs struct {
+	First int32
+	After *string
+}
+
+// messageConnection pages through the messages of a chat from the newest. Cursors are positions
+// from the newest message, so a page may repeat the messages sent since the previous one
+func (h *GraphQLHandler) messageConnection(ctx context.Context, chatId string, page graphqlPageArgs) (*messageConnectionResolver, error) {
+	first := int(page.First)
+	if first < 1 || first > graphqlMaxPageSize {
+		return nil, ErrInvalidPageSize
+	}
+
+	offset := 0
+	if page.After != nil && *page.After != "" {
+		var err error
+		if offset, err = decodeCursor(*page.After); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	// One more message tells whether there is a next page
+	messages, err := h.chatUc.GetMessages(ctx, chatId, viewerId(ctx), first+1, offset, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	connection := &messageConnectionResolver{edges: []*messageEdgeResolver{}}
+	if len(messages) > first {
+		messages = messages[:first]
+		connection.pageInfo.HasNextPage = true
+	}
+	for i, message := range messages {
+		connection.edges = append(connection.edges, &messageEdgeResolver{
+			Cursor: encodeCursor(offset + i + 1),
+			node:   &messageResolver{Message: message, h: h},
+		})
+	}
+	if len(connection.edges) > 0 {
+		endCursor := connection.edges[len(connection.edges)-1].Cursor
+		connection.pageInfo.EndCursor = &endCursor
+	}
+	return connection, nil
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	raw, found := strings.CutPrefix(string(decoded), "offset:")
+	if !found {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(raw)
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+type userResolver struct {
+	entity.PublicUser
+}
+
+func (u *userResolver) ID() graphql.ID {
+	return graphql.ID(u.Id)
+}
+
+func (u *userResolver) Email() *string {
+	return optionalString(u.PublicUser.Email)
+}
+
+type profileResolver struct {
+	entity.User
+}
+
+func (p *profileResolver) ID() graphql.ID {
+	return graphql.ID(p.Id)
+}
+
+func (p *profileResolver) WorkspaceID() *graphql.ID {
+	return optionalId(p.User.WorkspaceId)
+}
+
+func (p *profileResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: p.User.CreatedAt}
+}
+
+func (p *profileResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: p.User.UpdatedAt}
+}
+
+type chatResolver struct {
+	entity.Chat
+	h *GraphQLHandler
+}
+
+func (c *chatResolver) ID() graphql.ID {
+	return graphql.ID(c.Id)
+}
+
+func (c *chatResolver) Type() string {
+	return string(c.Chat.Type)
+}
+
+func (c *chatResolver) CreatedBy() graphql.ID {
+	return graphql.ID(c.Chat.CreatedBy)
+}
+
+func (c *chatResolver) WorkspaceID() *graphql.ID {
+	return optionalId(c.Chat.WorkspaceId)
+}
+
+func (c *chatResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: c.Chat.CreatedAt}
+}
+
+func (c *chatResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: c.Chat.UpdatedAt}
+}
+
+func (c *chatResolver) MembershipVersion() float64 {
+	return float64(c.Chat.MembershipVersion)
+}
+
+func (c *chatResolver) PinnedAt() *graphql.Time {
+	return optionalTime(c.Chat.PinnedAt)
+}
+
+func (c *chatResolver) MutedUntil() *graphql.Time {
+	return optionalTime(c.Chat.MutedUntil)
+}
+
+func (c *chatResolver) MutedThreads() []graphql.ID {
+	return graphqlIdList(c.Chat.MutedThreads)
+}
+
+func (c *chatResolver) Participants(ctx context.Context) ([]*userResolver, error) {
+	return c.h.participants(ctx, c.Id)
+}
+
+func (c *chatResolver) Messages(ctx context.Context, args graphqlPageArgs) (*messageConnectionResolver, error) {
+	return c.h.messageConnection(ctx, c.Id, args)
+}
+
+type messageResolver struct {
+	entity.Message
+	h *GraphQLHandler
+}
+
+func (m *messageResolver) ID() graphql.ID {
+	return graphql.ID(m.Id)
+}
+
+func (m *messageResolver) ChatID() graphql.ID {
+	return graphql.ID(m.Message.ChatId)
+}
+
+func (m *messageResolver) SenderID() graphql.ID {
+	return graphql.ID(m.Message.SenderId)
+}
+
+func (m *messageResolver) Sender(ctx context.Context) (*userResolver, error) {
+	user, err := m.h.userUc.GetPublic(ctx, m.Message.SenderId)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{user}, nil
+}
+
+func (m *messageResolver) ClientMessageID() *string {
+	return optionalString(m.Message.ClientMessageId)
+}
+
+func (m *messageResolver) Timestamp() float64 {
+	return float64(m.Message.Timestamp)
+}
+
+func (m *messageResolver) Seq() float64 {
+	return float64(m.Message.Seq)
+}
+
+func (m *messageResolver) Attachments() []*attachmentResolver {
+	attachments := make([]*attachmentResolver, len(m.Message.Attachments))
+	for i, attachment := range m.Message.Attachments {
+		attachments[i] = &attachmentResolver{attachment}
+	}
+	return attachments
+}
+
+func (m *messageResolver) ReplyToMessageID() *graphql.ID {
+	return optionalId(m.Message.ReplyToMessageId)
+}
+
+func (m *messageResolver) ReplyTo() *quotedMessageResolver {
+	if m.Message.ReplyTo == nil {
+		return nil
+	}
+	return &quotedMessageResolver{*m.Message.ReplyTo}
+}
+
+func (m *messageResolver) Mentions() []graphql.ID {
+	return graphqlIdList(m.Message.Mentions)
+}
+
+func (m *messageResolver) Receipts() []*receiptResolver {
+	receipts := make([]*receiptResolver, len(m.Message.Receipts))
+	for i, receipt := range m.Message.Receipts {
+		receipts[i] = &receiptResolver{receipt}
+	}
+	return receipts
+}
+
+func (m *messageResolver) DeliveredAt() *graphql.Time {
+	return optionalTime(m.Message.DeliveredAt)
+}
+
+func (m *messageResolver) ReadAt() *graphql.Time {
+	return optionalTime(m.Message.ReadAt)
+}
+
+type attachmentResolver struct {
+	entity.Attachment
+}
+
+func (a *attachmentResolver) Size() float64 {
+	return float64(a.Attachment.Size)
+}
+
+type graphqlAttachmentInput struct {
+	Url      string
+	Name     string
+	MimeType string
+	Size     float64
+}
+
+type quotedMessageResolver struct {
+	entity.QuotedMessage
+}
+
+func (q *quotedMessageResolver) MessageID() graphql.ID {
+	return graphql.ID(q.QuotedMessage.MessageId)
+}
+
+func (q *quotedMessageResolver) SenderID() graphql.ID {
+	return graphql.ID(q.QuotedMessage.SenderId)
+}
+
+type receiptResolver struct {
+	entity.MessageReceipt
+}
+
+func (r *receiptResolver) UserID() graphql.ID {
+	return graphql.ID(r.MessageReceipt.UserId)
+}
+
+func (r *receiptResolver) DeliveredAt() *graphql.Time {
+	return optionalTime(r.MessageReceipt.DeliveredAt)
+}
+
+func (r *receiptResolver) ReadAt() *graphql.Time {
+	return optionalTime(r.MessageReceipt.ReadAt)
+}
+
+type messageConnectionResolver struct {
+	edges    []*messageEdgeResolver
+	pageInfo pageInfoResolver
+}
+
+func (c *messageConnectionResolver) Edges() []*messageEdgeResolver {
+	return c.edges
+}
+
+func (c *messageConnectionResolver) PageInfo() *pageInfoResolver {
+	return &c.pageInfo
+}
+
+type messageEdgeResolver struct {
+	Cursor string
+	node   *messageResolver
+}
+
+func (e *messageEdgeResolver) Node() *messageResolver {
+	return e.node
+}
+
+type pageInfoResolver struct {
+	HasNextPage bool
+	EndCursor   *string
+}
+
+type eventResolver struct {
+	hubEvent
+}
+
+func (e *eventResolver) ChatID() *graphql.ID {
+	return optionalId(e.chatId())
+}
+
+func (e *eventResolver) Data() *graphqlJSON {
+	if len(e.hubEvent.Data) == 0 {
+		return nil
+	}
+	return &graphqlJSON{e.hubEvent.Data}
+}
+
+// graphqlJSON is the JSON scalar, a value passed through as is like the data of the events
+type graphqlJSON struct {
+	json.RawMessage
+}
+
+func (graphqlJSON) ImplementsGraphQLType(name string) bool {
+	return name == "JSON"
+}
+
+func (j *graphqlJSON) UnmarshalGraphQL(input any) error {
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	j.RawMessage = encoded
+	return nil
+}
+
+func graphqlIds(ids []graphql.ID) []string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = string(id)
+	}
+	return values
+}
+
+func graphqlIdList(values []string) []graphql.ID {
+	ids := make([]graphql.ID, len(values))
+	for i, value := range values {
+		ids[i] = graphql.ID(value)
+	}
+	return ids
+}
+
+func graphqlIdValue(id *graphql.ID) string {
+	if id == nil {
+		return ""
+	}
+	return string(*id)
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func optionalId(value string) *graphql.ID {
+	if value == "" {
+		return nil
+	}
+	id := graphql.ID(value)
+	return &id
+}
+
+func optionalTime(value *time.Time) *graphql.Time {
+	if value == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *value}
+}
+
+// hubEvent is a frame the hub sends to the viewer, a typed entity.Event or a new message
+type hubEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	raw  []byte
+}
+
+func parseHubEvent(frame []byte) (hubEvent, bool) {
+	var event hubEvent
+	if err := json.Unmarshal(frame, &event); err != nil {
+		return hubEvent{}, false
+	}
+	event.raw = frame
+	return event, true
+}
+
+// isMessage tells the new messages apart, they are the only frames without a type
+func (e hubEvent) isMessage() bool {
+	return e.Type == ""
+}
+
+func (e hubEvent) chatId() string {
+	source := e.Data
+	if e.isMessage() {
+		source = e.raw
+	}
+
+	var payload struct {
+		ChatId string `json:"chatId"`
+	}
+	json.Unmarshal(source, &payload)
+	return payload.ChatId
+}
+
+// message reads a new message frame as the message it carries
+func (e hubEvent) message() (entity.Message, error) {
+	var outgoing wsDelivery.OutgoingMessage
+	if err := json.Unmarshal(e.raw, &outgoing); err != nil {
+		return entity.Message{}, err
+	}
+
+	return entity.Message{
+		Id:               outgoing.MessageId,
+		ChatId:           outgoing.ChatId,
+		SenderId:         outgoing.UserId,
+		Message:          outgoing.Message,
+		Timestamp:        outgoing.Timestamp,
+		Seq:              outgoing.Seq,
+		IsRead:           outgoing.IsRead,
+		Attachments:      outgoing.Attachments,
+		ReplyToMessageId: outgoing.ReplyToMessageId,
+		ReplyTo:          outgoing.ReplyTo,
+	}, nil
+}
+
+// presentGraphQLErrors rewrites the errors returned by the resolvers like writeError does: the
+// usecase errors the clients are told about keep their message, the others are hidden behind a
+// generic one. The errors of the document itself are left as they are
+func presentGraphQLErrors(ctx context.Context, errs []*gqlerrors.QueryError) {
+	for _, queryErr := range errs {
+		if queryErr.ResolverError != nil {
+			queryErr.Message, queryErr.Extensions = presentGraphQLError(ctx, queryErr.ResolverError)
+		}
+	}
+}
+
+func presentGraphQLError(ctx context.Context, err error) (string, map[string]any) {
+	for _, candidate := range append(graphqlErrorStatuses, errorStatuses...) {
+		if errors.Is(err, candidate.err) {
+			return candidate.err.Error(), map[string]any{"status": candidate.status}
+		}
+	}
+
+	var validationErr *usecase.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Error(), map[string]any{"status": 400, "field": validationErr.Field}
+	}
+
+	slog.ErrorContext(ctx, "GraphQL resolver error", "error", err)
+	return "internal server error", map[string]any{"status": 500}
+}
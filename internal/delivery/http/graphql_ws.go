@@ -0,0 +1,411 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"wetalk/infrastructure/ws"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/logger"
+
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+const (
+	// graphqlSubprotocol is the protocol of the subscriptions websocket, the one of the graphql-ws
+	// clients (https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md)
+	graphqlSubprotocol = "graphql-transport-ws"
+	// graphqlInitTimeout is how long a new connection has to send its connection_init
+	graphqlInitTimeout = 10 * time.Second
+	// graphqlWriteWait bounds the writes to a connection
+	graphqlWriteWait = 10 * time.Second
+	// graphqlListenerBuffer is how many events a subscription may fall behind before it misses some
+	graphqlListenerBuffer = 64
+)
+
+// Close codes of the graphql-transport-ws protocol
+const (
+	graphqlCloseBadRequest         = 4400
+	graphqlCloseUnauthorized       = 4401
+	graphqlCloseForbidden          = 4403
+	graphqlCloseBadSubprotocol     = 4406
+	graphqlCloseInitTimeout        = 4408
+	graphqlCloseSubscriberExists   = 4409
+	graphqlCloseTooManyInitRequest = 4429
+)
+
+var graphqlUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{graphqlSubprotocol},
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+type graphqlMessage struct {
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type graphqlConnKey struct{}
+
+// graphqlConnection is a subscriptions websocket. Its hub client receives the events of the user
+// once, they are handed to the listeners of the running subscriptions
+type graphqlConnection struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	listeners  map[*hubListener]struct{}
+	operations map[string]context.CancelFunc
+}
+
+// hubListener is a running subscription, deliver hands it an event without blocking
+type hubListener struct {
+	deliver func(hubEvent)
+}
+
+// GET /graphql - Run GraphQL subscriptions over a websocket, authenticated by the Authorization of connection_init
+func (h *GraphQLHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	conn, err := graphqlUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Upgrade error", "error", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(graphqlMaxBodySize)
+
+	c := &graphqlConnection{
+		conn:       conn,
+		listeners:  make(map[*hubListener]struct{}),
+		operations: make(map[string]context.CancelFunc),
+	}
+	if conn.Subprotocol() != graphqlSubprotocol {
+		c.close(graphqlCloseBadSubprotocol, "Subprotocol not acceptable")
+		return
+	}
+
+	// The connection outlives the upgrade request, so keep its values but
+	// tie cancellation to the lifetime of the connection instead
+	connCtx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+
+	claims, ok := h.initialize(connCtx, c)
+	if !ok {
+		return
+	}
+	connCtx = context.WithValue(connCtx, UserContextKey, claims)
+	connCtx = context.WithValue(connCtx, graphqlConnKey{}, c)
+	connCtx = logger.With(connCtx, slog.String("user_id", claims.UserId))
+
+	user, err := h.userUc.Get(connCtx, claims.UserId)
+	if err != nil {
+		slog.ErrorContext(connCtx, "Get user error", "error", err)
+		c.close(websocket.CloseInternalServerErr, "internal server error")
+		return
+	}
+	user.IsOnline = true
+	if err := h.userUc.Update(connCtx, user); err != nil {
+		slog.ErrorContext(connCtx, "Update user error", "error", err)
+		c.close(websocket.CloseInternalServerErr, "internal server error")
+		return
+	}
+
+	client := ws.NewStreamClient(claims.UserId, h.hub)
+	h.hub.RegisterClient(client)
+	defer h.hub.UnregisterClient(client)
+	h.metricsUc.ConnectionOpened(user.GetWorkspaceId())
+	defer h.metricsUc.ConnectionClosed(user.GetWorkspaceId())
+
+	go c.dispatch(client)
+	go c.keepAlive(connCtx, h.heartbeat)
+	c.send(graphqlMessage{Type: "connection_ack"})
+
+	h.serve(connCtx, c)
+}
+
+// initialize waits for the connection_init of the client and authenticates its payload like the
+// protected routes authenticate a request, consent and suspension included
+func (h *GraphQLHandler) initialize(ctx context.Context, c *graphqlConnection) (*entity.TokenClaims, bool) {
+	c.conn.SetReadDeadline(time.Now().Add(graphqlInitTimeout))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			c.close(graphqlCloseInitTimeout, "Connection initialisation timeout")
+		}
+		return nil, false
+	}
+
+	var message graphqlMessage
+	if err := json.Unmarshal(data, &message); err != nil || message.Type != "connection_init" {
+		c.close(graphqlCloseUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	var payload map[string]any
+	json.Unmarshal(message.Payload, &payload)
+	authorization, _ := payload["Authorization"].(string)
+	if authorization == "" {
+		authorization, _ = payload["authorization"].(string)
+	}
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found {
+		c.close(graphqlCloseForbidden, "Forbidden")
+		return nil, false
+	}
+	claims, err := h.authUc.ValidateAccessToken(token)
+	if err != nil {
+		c.close(graphqlCloseForbidden, "Forbidden")
+		return nil, false
+	}
+
+	pending, err := h.consentUc.GetPendingDocuments(ctx, claims.UserId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get pending documents error", "error", err)
+		c.close(websocket.CloseInternalServerErr, "internal server error")
+		return nil, false
+	}
+	if len(pending) > 0 {
+		c.close(graphqlCloseForbidden, "consent required")
+		return nil, false
+	}
+
+	if err := h.abuseUc.CheckNotSuspended(ctx, claims.UserId); err != nil {
+		if !errors.Is(err, usecase.ErrAccountSuspended) {
+			slog.ErrorContext(ctx, "Check suspension error", "error", err)
+			c.close(websocket.CloseInternalServerErr, "internal server error")
+			return nil, false
+		}
+		c.close(graphqlCloseForbidden, err.Error())
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// serve reads the messages of the client until the connection goes away
+func (h *GraphQLHandler) serve(ctx context.Context, c *graphqlConnection) {
+	seen := func() {
+		c.conn.SetReadDeadline(time.Now().Add(h.heartbeat.PongTimeout))
+	}
+	seen()
+	c.conn.SetPongHandler(func(string) error {
+		seen()
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+				slog.WarnContext(ctx, "Unexpected GraphQL websocket close", "error", err)
+			}
+			return
+		}
+		seen()
+
+		var message graphqlMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			c.close(graphqlCloseBadRequest, "Invalid message received")
+			return
+		}
+
+		switch message.Type {
+		case "ping":
+			c.send(graphqlMessage{Type: "pong"})
+		case "pong":
+		case "connection_init":
+			c.close(graphqlCloseTooManyInitRequest, "Too many initialisation requests")
+			return
+		case "subscribe":
+			var req graphqlRequest
+			if message.Id == "" || json.Unmarshal(message.Payload, &req) != nil || req.Query == "" {
+				c.close(graphqlCloseBadRequest, "Invalid message received")
+				return
+			}
+			opCtx, ok := c.start(ctx, message.Id)
+			if !ok {
+				c.close(graphqlCloseSubscriberExists, "Subscriber for "+message.Id+" already exists")
+				return
+			}
+			go h.run(opCtx, c, message.Id, req)
+		case "complete":
+			c.finish(message.Id)
+		default:
+			c.close(graphqlCloseBadRequest, "Invalid message received")
+			return
+		}
+	}
+}
+
+// run answers an operation of the connection, a subscription sends its results until it is
+// completed by the client or its stream ends. Queries and mutations go through Subscribe as well,
+// their stream is their single result
+func (h *GraphQLHandler) run(ctx context.Context, c *graphqlConnection, id string, req graphqlRequest) {
+	defer c.finish(id)
+
+	results, err := h.schema.Subscribe(ctx, req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		c.sendPayload(id, "error", []*gqlerrors.QueryError{gqlerrors.Errorf("%s", err)})
+		return
+	}
+	first := true
+	for value := range results {
+		result := value.(*graphql.Response)
+		presentGraphQLErrors(ctx, result.Errors)
+
+		// An operation that could not start has errors and no data
+		if first && result.Data == nil && len(result.Errors) > 0 {
+			c.sendPayload(id, "error", result.Errors)
+			return
+		}
+		first = false
+		c.sendPayload(id, "next", result)
+	}
+
+	// The client already knows about the subscriptions it completed
+	if ctx.Err() == nil {
+		c.send(graphqlMessage{Id: id, Type: "complete"})
+	}
+}
+
+// listen streams the events of the hub that accept turns into a value of the subscription, until
+// the subscription ends
+func listen[T any](ctx context.Context, accept func(hubEvent) (T, bool)) (<-chan T, error) {
+	c, ok := ctx.Value(graphqlConnKey{}).(*graphqlConnection)
+	if !ok {
+		return nil, ErrSubscriptionTransport
+	}
+
+	events := make(chan T, graphqlListenerBuffer)
+	l := &hubListener{deliver: func(event hubEvent) {
+		value, ok := accept(event)
+		if !ok {
+			return
+		}
+		// A subscription too slow to keep up misses events instead of holding the others back
+		select {
+		case events <- value:
+		default:
+		}
+	}}
+	c.mu.Lock()
+	c.listeners[l] = struct{}{}
+	c.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		delete(c.listeners, l)
+		close(events)
+		c.mu.Unlock()
+	}()
+	return events, nil
+}
+
+// dispatch hands the events sent to the hub client to the listeners, the connection is closed
+// once the hub unregisters the client
+func (c *graphqlConnection) dispatch(client *ws.UserClient) {
+	for {
+		frame, ok := client.Receive()
+		if !ok {
+			break
+		}
+		event, ok := parseHubEvent(frame)
+		if !ok {
+			continue
+		}
+
+		c.mu.Lock()
+		for l := range c.listeners {
+			l.deliver(event)
+		}
+		c.mu.Unlock()
+	}
+
+	c.conn.Close()
+}
+
+// keepAlive pings the client, serve closes the connection once it stops answering
+func (c *graphqlConnection) keepAlive(ctx context.Context, heartbeat ws.Heartbeat) {
+	ticker := time.NewTicker(heartbeat.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			c.conn.SetWriteDeadline(time.Now().Add(graphqlWriteWait))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			c.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// start registers an operation, ok is false when its id is already running
+func (c *graphqlConnection) start(ctx context.Context, id string) (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.operations[id]; exists {
+		return nil, false
+	}
+	opCtx, cancel := context.WithCancel(ctx)
+	c.operations[id] = cancel
+	return opCtx, true
+}
+
+func (c *graphqlConnection) finish(id string) {
+	c.mu.Lock()
+	cancel, ok := c.operations[id]
+	delete(c.operations, id)
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (c *graphqlConnection) sendPayload(id string, messageType string, payload any) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Encode GraphQL payload error", "error", err)
+		return
+	}
+	c.send(graphqlMessage{Id: id, Type: messageType, Payload: encoded})
+}
+
+func (c *graphqlConnection) send(message graphqlMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(graphqlWriteWait))
+	if err := c.conn.WriteJSON(message); err != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *graphqlConnection) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(graphqlWriteWait))
+	c.conn.Close()
+}
@@ -2,8 +2,13 @@ package http
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"wetalk/internal/entity"
 	"wetalk/internal/usecase"
 
@@ -11,31 +16,45 @@ import (
 )
 
 type HttpHandler struct {
-	chatUc usecase.ChatUsecase
-	userUc usecase.UserUsecase
+	chatUc    usecase.ChatUsecase
+	userUc    usecase.UserUsecase
+	inboxUc   usecase.InboxUsecase
+	focusUc   usecase.FocusUsecase
+	messageUc usecase.MessageUsecase
 }
 
-func NewHttpHandler(chatUc usecase.ChatUsecase, userUc usecase.UserUsecase) *HttpHandler {
+func NewHttpHandler(chatUc usecase.ChatUsecase, userUc usecase.UserUsecase, inboxUc usecase.InboxUsecase, focusUc usecase.FocusUsecase, messageUc usecase.MessageUsecase) *HttpHandler {
 	return &HttpHandler{
-		chatUc: chatUc,
-		userUc: userUc,
+		chatUc:    chatUc,
+		userUc:    userUc,
+		inboxUc:   inboxUc,
+		focusUc:   focusUc,
+		messageUc: messageUc,
 	}
 }
 
 type Response struct {
 	Message string `json:"message"`
 	Data    any    `json:"data"`
+	// Pagination is only written by the API versions with a data envelope
+	Pagination *Pagination `json:"-"`
 }
 
 // GET /user - Get list of users
 func (h *HttpHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.userUc.Index(r.Context())
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	users, err := h.userUc.Index(r.Context(), userClaims.UserId)
 	if err != nil {
-		log.Printf("List users error: %v", err)
-		response := Response{Message: "internal server error"}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		slog.ErrorContext(r.Context(), "List users error", "error", err)
+
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
@@ -43,40 +62,73 @@ func (h *HttpHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 		Message: "success",
 		Data:    users,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/compliance/users - Get the age verification status of every user (super admin only)
+func (h *HttpHandler) AdminComplianceReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.userUc.GetComplianceReport(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Compliance report error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    report,
+	}
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// GET /user/chats - Get list of chats for authenticated user
+// GET /user/chats?archived=&fields= - Get list of chats for authenticated user, archived chats only when archived=true
 func (h *HttpHandler) ListUserChats(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
-	chats, err := h.chatUc.Index(r.Context(), userClaims.UserId)
+	archived := false
+	if archivedStr := r.URL.Query().Get("archived"); archivedStr != "" {
+		parsed, err := strconv.ParseBool(archivedStr)
+		if err != nil {
+			response := Response{Message: "invalid archived value"}
+			writeJSON(w, r, http.StatusBadRequest, response)
+			return
+		}
+		archived = parsed
+	}
+
+	fields, err := parseFields(r, entity.InboxEntry{})
 	if err != nil {
-		log.Printf("List chats error: %v", err)
-		response := Response{Message: "internal server error"}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		response := Response{Message: err.Error()}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	chats, err := h.inboxUc.Index(r.Context(), userClaims.UserId, archived, fields)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List chats error", "error", err)
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	data, err := sparse(chats, fields)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Select chat fields error", "error", err)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
 	response := Response{
 		Message: "success",
-		Data:    chats,
+		Data:    data,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
 // POST /chat/personal - Create a personal chat (1-on-1)
@@ -85,44 +137,33 @@ func (h *HttpHandler) CreatePersonalChat(w http.ResponseWriter, r *http.Request)
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	var req entity.CreatePersonalChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response := Response{Message: "invalid request body"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	if req.ParticipantId == "" {
 		response := Response{Message: "participantId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	if req.ParticipantId == userClaims.UserId {
 		response := Response{Message: "cannot create chat with yourself"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	chatId, err := h.chatUc.CreatePersonalChat(r.Context(), userClaims.UserId, req.ParticipantId)
 	if err != nil {
-		log.Printf("Create personal chat error: %v", err)
-		response := Response{Message: "failed to create personal chat"}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		slog.ErrorContext(r.Context(), "Create personal chat error", "error", err)
+		writeError(w, r, err, "failed to create personal chat")
 		return
 	}
 
@@ -130,9 +171,7 @@ func (h *HttpHandler) CreatePersonalChat(w http.ResponseWriter, r *http.Request)
 		Message: "personal chat created successfully",
 		Data:    map[string]string{"chatId": chatId},
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusCreated, response)
 }
 
 // POST /chat/group - Create a group chat
@@ -141,44 +180,34 @@ func (h *HttpHandler) CreateGroupChat(w http.ResponseWriter, r *http.Request) {
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	var req entity.CreateGroupChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response := Response{Message: "invalid request body"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	if req.Name == "" {
 		response := Response{Message: "group name is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	if len(req.UserIds) == 0 {
 		response := Response{Message: "at least one participant is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	chatId, err := h.chatUc.CreateGroupChat(r.Context(), req.Name, req.Description, userClaims.UserId, req.UserIds)
 	if err != nil {
-		log.Printf("Create group chat error: %v", err)
-		response := Response{Message: "failed to create group chat"}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		slog.ErrorContext(r.Context(), "Create group chat error", "error", err)
+
+		writeError(w, r, err, "failed to create group chat")
 		return
 	}
 
@@ -186,9 +215,39 @@ func (h *HttpHandler) CreateGroupChat(w http.ResponseWriter, r *http.Request) {
 		Message: "group chat created successfully",
 		Data:    map[string]string{"chatId": chatId},
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// POST /chat/channel - Create a broadcast channel, only its admins post
+func (h *HttpHandler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.CreateChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	chatId, err := h.chatUc.CreateChannel(r.Context(), req.Name, req.Description, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Create channel error", "error", err)
+
+		writeError(w, r, err, "failed to create channel")
+		return
+	}
+
+	response := Response{
+		Message: "channel created successfully",
+		Data:    map[string]string{"chatId": chatId},
+	}
+	writeJSON(w, r, http.StatusCreated, response)
 }
 
 // GET /chat/:chatId - Get chat details with participants
@@ -197,41 +256,22 @@ func (h *HttpHandler) GetChat(w http.ResponseWriter, r *http.Request) {
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	chatId := chi.URLParam(r, "chatId")
 	if chatId == "" {
 		response := Response{Message: "chatId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
 	chatDetail, err := h.chatUc.Get(r.Context(), chatId, userClaims.UserId)
 	if err != nil {
-		log.Printf("Get chat error: %v", err)
-
-		statusCode := http.StatusInternalServerError
-		message := "internal server error"
-
-		switch err {
-		case usecase.ErrNotParticipant:
-			statusCode = http.StatusForbidden
-			message = "you are not a participant of this chat"
-		case usecase.ErrChatNotFound:
-			statusCode = http.StatusNotFound
-			message = "chat not found"
-		}
+		slog.ErrorContext(r.Context(), "Get chat error", "error", err)
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
@@ -239,344 +279,1670 @@ func (h *HttpHandler) GetChat(w http.ResponseWriter, r *http.Request) {
 		Message: "success",
 		Data:    chatDetail,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// GET /chat/:chatId/messages - Get messages for a chat
-func (h *HttpHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
+// GET /chat/:chatId/participants?fields= - Get the public profiles of the participants of a chat
+func (h *HttpHandler) ListParticipants(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	chatId := chi.URLParam(r, "chatId")
 	if chatId == "" {
 		response := Response{Message: "chatId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	messages, err := h.chatUc.GetMessages(r.Context(), chatId, userClaims.UserId, 100, 0)
+	fields, err := parseFields(r, entity.PublicUser{})
 	if err != nil {
-		log.Printf("Get messages error: %v", err)
+		response := Response{Message: err.Error()}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		message := "internal server error"
+	participants, err := h.chatUc.GetParticipants(r.Context(), chatId, userClaims.UserId, fields)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get participants error", "error", err)
 
-		if err == usecase.ErrNotParticipant {
-			statusCode = http.StatusForbidden
-			message = "you are not a participant of this chat"
-		}
+		writeError(w, r, err, "internal server error")
+		return
+	}
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	data, err := sparse(participants, fields)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Select participant fields error", "error", err)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
 	response := Response{
 		Message: "success",
-		Data:    messages,
+		Data:    data,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// POST /chat/:chatId/invite - Invite users to a group chat
-func (h *HttpHandler) InviteUsersToGroup(w http.ResponseWriter, r *http.Request) {
+// GET /chat/:chatId/messages?fields= - Get messages for a chat
+func (h *HttpHandler) GetMessages(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	chatId := chi.URLParam(r, "chatId")
 	if chatId == "" {
 		response := Response{Message: "chatId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	var req entity.InviteUsersRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		response := Response{Message: "invalid request body"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	// sentAt is added by the encoder of the messages
+	fields, err := parseFields(r, entity.Message{}, "sentAt")
+	if err != nil {
+		response := Response{Message: err.Error()}
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	if len(req.UserIds) == 0 {
-		response := Response{Message: "at least one user is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	messages, err := h.chatUc.GetMessages(r.Context(), chatId, userClaims.UserId, 100, 0, fields)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get messages error", "error", err)
+
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
-	err := h.chatUc.InviteUsersToGroup(r.Context(), chatId, userClaims.UserId, req.UserIds)
+	data, err := sparse(messages, fields)
 	if err != nil {
-		log.Printf("Invite users error: %v", err)
+		slog.ErrorContext(r.Context(), "Select message fields error", "error", err)
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    data,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /messages/search?q=&chatId=&from=&before=&after=&hasAttachment=&hasLink=&limit=&offset= - Search the messages of the user's chats
+func (h *HttpHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		message := "failed to invite users"
+	filter, err := parseMessageSearchFilter(r)
+	if err != nil {
+		writeError(w, r, err, "invalid search filter")
+		return
+	}
 
-		if err == usecase.ErrNotParticipant {
-			statusCode = http.StatusForbidden
-			message = "you are not a participant of this chat"
-		} else if err == usecase.ErrNotAdmin {
-			statusCode = http.StatusForbidden
-			message = "only admins can invite users"
-		} else if err == usecase.ErrCannotInviteToPersonal {
-			statusCode = http.StatusBadRequest
-			message = "cannot invite users to personal chat"
-		}
+	messages, err := h.chatUc.SearchMessages(r.Context(), userClaims.UserId, filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Search messages error", "error", err)
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
 	response := Response{
-		Message: "invitations sent successfully",
+		Message:    "success",
+		Data:       messages,
+		Pagination: &Pagination{Limit: usecase.SearchLimit(filter.Limit), Offset: filter.Offset, Count: len(messages)},
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// POST /chat/:chatId/leave - Leave a group chat
-func (h *HttpHandler) LeaveGroup(w http.ResponseWriter, r *http.Request) {
+// parseMessageSearchFilter reads the search filters from the query string
+func parseMessageSearchFilter(r *http.Request) (entity.MessageSearchFilter, error) {
+	query := r.URL.Query()
+	filter := entity.MessageSearchFilter{
+		Query:    strings.TrimSpace(query.Get("q")),
+		ChatId:   query.Get("chatId"),
+		SenderId: query.Get("from"),
+	}
+
+	for name, target := range map[string]*int64{"before": &filter.Before, "after": &filter.After} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return entity.MessageSearchFilter{}, &usecase.ValidationError{Field: name, Err: fmt.Errorf("%s must be a timestamp in milliseconds", name)}
+			}
+			*target = parsed
+		}
+	}
+
+	for name, target := range map[string]**bool{"hasAttachment": &filter.HasAttachment, "hasLink": &filter.HasLink} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return entity.MessageSearchFilter{}, &usecase.ValidationError{Field: name, Err: fmt.Errorf("%s must be true or false", name)}
+			}
+			*target = &parsed
+		}
+	}
+
+	for name, target := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return entity.MessageSearchFilter{}, &usecase.ValidationError{Field: name, Err: fmt.Errorf("%s must be a positive number", name)}
+			}
+			*target = parsed
+		}
+	}
+
+	return filter, nil
+}
+
+// GET /chat/:chatId/messages/:messageId/thread - Get a message and its replies
+func (h *HttpHandler) GetThread(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	chatId := chi.URLParam(r, "chatId")
-	if chatId == "" {
-		response := Response{Message: "chatId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	messageId := chi.URLParam(r, "messageId")
+	if chatId == "" || messageId == "" {
+		response := Response{Message: "chatId and messageId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	err := h.chatUc.LeaveGroup(r.Context(), chatId, userClaims.UserId)
+	thread, err := h.chatUc.GetThread(r.Context(), chatId, messageId, userClaims.UserId)
 	if err != nil {
-		log.Printf("Leave group error: %v", err)
+		slog.ErrorContext(r.Context(), "Get thread error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    thread,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /chat/:chatId/messages/:messageId/context?around=25 - Get a message and the messages around it
+func (h *HttpHandler) GetMessageContext(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		message := "failed to leave group"
+	chatId := chi.URLParam(r, "chatId")
+	messageId := chi.URLParam(r, "messageId")
+	if chatId == "" || messageId == "" {
+		response := Response{Message: "chatId and messageId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
 
-		if err == usecase.ErrNotParticipant {
-			statusCode = http.StatusForbidden
-			message = "you are not a participant of this chat"
+	var around int
+	if aroundStr := r.URL.Query().Get("around"); aroundStr != "" {
+		parsed, err := strconv.Atoi(aroundStr)
+		if err != nil || parsed < 0 {
+			response := Response{Message: "around must be a positive number"}
+			writeJSON(w, r, http.StatusBadRequest, response)
+			return
 		}
+		around = parsed
+	}
+
+	messageContext, err := h.chatUc.GetMessageContext(r.Context(), chatId, messageId, userClaims.UserId, around)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get message context error", "error", err)
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
 	response := Response{
-		Message: "left group successfully",
+		Message: "success",
+		Data:    messageContext,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// GET /invitations - Get pending invitations for authenticated user
-func (h *HttpHandler) GetPendingInvitations(w http.ResponseWriter, r *http.Request) {
+// GET /chat/:chatId/pins - Get the pinned messages of a chat in the order the admins gave them
+func (h *HttpHandler) ListPinnedMessages(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
-	invitations, err := h.chatUc.GetPendingInvitations(r.Context(), userClaims.UserId)
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	pins, err := h.chatUc.GetPinnedMessages(r.Context(), chatId, userClaims.UserId)
 	if err != nil {
-		log.Printf("Get invitations error: %v", err)
-		response := Response{Message: "internal server error"}
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		slog.ErrorContext(r.Context(), "Get pinned messages error", "error", err)
+
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
 	response := Response{
 		Message: "success",
-		Data:    invitations,
+		Data:    pins,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// POST /invitations/:invitationId/respond - Accept or reject an invitation
-func (h *HttpHandler) RespondToInvitation(w http.ResponseWriter, r *http.Request) {
+// POST /chat/:chatId/messages/:messageId/pin - Pin a message for every participant (admin or sender), for a number of days or until it is unpinned
+func (h *HttpHandler) PinMessage(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
-	invitationId := chi.URLParam(r, "invitationId")
-	if invitationId == "" {
-		response := Response{Message: "invitationId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	chatId := chi.URLParam(r, "chatId")
+	messageId := chi.URLParam(r, "messageId")
+	if chatId == "" || messageId == "" {
+		response := Response{Message: "chatId and messageId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	var req entity.RespondInvitationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	// The body is optional, without a duration the message stays pinned until it is unpinned
+	var req entity.PinMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		response := Response{Message: "invalid request body"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	err := h.chatUc.RespondToInvitation(r.Context(), invitationId, userClaims.UserId, req.Accept)
+	pin, err := h.chatUc.PinMessage(r.Context(), chatId, messageId, userClaims.UserId, time.Duration(req.DurationDays)*24*time.Hour)
 	if err != nil {
-		log.Printf("Respond to invitation error: %v", err)
+		slog.ErrorContext(r.Context(), "Pin message error", "error", err)
+
+		writeError(w, r, err, "failed to pin message")
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		message := "failed to respond to invitation"
+	response := Response{
+		Message: "message pinned successfully",
+		Data:    pin,
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
 
-		if err == usecase.ErrInvitationNotFound {
-			statusCode = http.StatusNotFound
-			message = "invitation not found"
-		} else if err == usecase.ErrInvalidInvitation {
-			statusCode = http.StatusForbidden
-			message = "invalid invitation"
-		}
+// DELETE /chat/:chatId/messages/:messageId/pin - Unpin a message (admin or sender)
+func (h *HttpHandler) UnpinMessage(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	chatId := chi.URLParam(r, "chatId")
+	messageId := chi.URLParam(r, "messageId")
+	if chatId == "" || messageId == "" {
+		response := Response{Message: "chatId and messageId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	message := "invitation rejected"
-	if req.Accept {
-		message = "invitation accepted"
+	err := h.chatUc.UnpinMessage(r.Context(), chatId, messageId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unpin message error", "error", err)
+
+		writeError(w, r, err, "failed to unpin message")
+		return
 	}
 
 	response := Response{
-		Message: message,
+		Message: "message unpinned successfully",
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// GET /user/:id - Get user by ID
-func (h *HttpHandler) GetUser(w http.ResponseWriter, r *http.Request) {
-	userId := chi.URLParam(r, "id")
+// PUT /chat/:chatId/pins/order - Order the pinned messages of a chat (admin)
+func (h *HttpHandler) ReorderPins(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
 
-	response := Response{}
-	user, err := h.userUc.Get(r.Context(), userId)
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.ReorderPinsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	pins, err := h.chatUc.ReorderPins(r.Context(), chatId, userClaims.UserId, req.MessageIds)
 	if err != nil {
-		log.Printf("Get user error: %v", err)
-		response.Message = "user not found"
-		w.WriteHeader(http.StatusNotFound)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		slog.ErrorContext(r.Context(), "Reorder pins error", "error", err)
+
+		writeError(w, r, err, "failed to reorder pins")
 		return
 	}
 
-	response.Message = "success"
-	response.Data = user
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	response := Response{
+		Message: "pins reordered successfully",
+		Data:    pins,
+	}
+	writeJSON(w, r, http.StatusOK, response)
 }
 
-// DELETE /chat/:chatId - Delete a chat (admin only)
-func (h *HttpHandler) DeleteChat(w http.ResponseWriter, r *http.Request) {
+// GET /chat/:chatId/membership-version - Get the current membership version of a chat
+func (h *HttpHandler) GetMembershipVersion(w http.ResponseWriter, r *http.Request) {
 	// Get user from context
 	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
 	if !ok {
 		response := Response{Message: "unauthorized"}
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusUnauthorized, response)
 		return
 	}
 
 	chatId := chi.URLParam(r, "chatId")
 	if chatId == "" {
 		response := Response{Message: "chatId is required"}
-		w.WriteHeader(http.StatusBadRequest)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeJSON(w, r, http.StatusBadRequest, response)
 		return
 	}
 
-	err := h.chatUc.Delete(r.Context(), chatId, userClaims.UserId)
+	version, err := h.chatUc.GetMembershipVersion(r.Context(), chatId, userClaims.UserId)
 	if err != nil {
-		log.Printf("Delete chat error: %v", err)
+		slog.ErrorContext(r.Context(), "Get membership version error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    version,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /chat/:chatId/membership?since=version - Get the member list changes since a membership version
+func (h *HttpHandler) GetMembershipDiff(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
 
-		statusCode := http.StatusInternalServerError
-		message := "failed to delete chat"
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
 
-		if err == usecase.ErrNotAdmin {
-			statusCode = http.StatusForbidden
-			message = "only admins can delete the chat"
-		} else if err == usecase.ErrChatNotFound {
-			statusCode = http.StatusNotFound
-			message = "chat not found"
+	var since int64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		parsed, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil {
+			response := Response{Message: "since must be a membership version"}
+			writeJSON(w, r, http.StatusBadRequest, response)
+			return
 		}
+		since = parsed
+	}
+
+	diff, err := h.chatUc.GetMembershipDiff(r.Context(), chatId, userClaims.UserId, since)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get membership diff error", "error", err)
 
-		response := Response{Message: message}
-		w.WriteHeader(statusCode)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		writeError(w, r, err, "internal server error")
 		return
 	}
 
 	response := Response{
-		Message: "chat deleted successfully",
+		Message: "success",
+		Data:    diff,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /chat/:chatId/viewers - Get the participants that currently have the chat open
+func (h *HttpHandler) GetChatViewers(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	viewers, err := h.focusUc.GetViewers(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get chat viewers error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    viewers,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/read - Mark the chat as read up to a message or a timestamp, everything when the body is empty
+func (h *HttpHandler) ReadChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.MarkChatReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	horizon, err := h.messageUc.MarkChatAsRead(r.Context(), chatId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Read chat error", "error", err)
+
+		writeError(w, r, err, "failed to mark chat as read")
+		return
+	}
+
+	response := Response{
+		Message: "chat marked as read",
+		Data:    horizon,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/invite - Invite users to a group chat
+func (h *HttpHandler) InviteUsersToGroup(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.InviteUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if len(req.UserIds) == 0 {
+		response := Response{Message: "at least one user is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.InviteUsersToGroup(r.Context(), chatId, userClaims.UserId, req.UserIds, req.Note)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Invite users error", "error", err)
+
+		writeError(w, r, err, "failed to invite users")
+		return
+	}
+
+	response := Response{
+		Message: "invitations sent successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/guests - Add a user to a group chat until expiresAt (admin only)
+func (h *HttpHandler) AddGuest(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.AddGuestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if req.UserId == "" {
+		response := Response{Message: "userId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.AddGuest(r.Context(), chatId, userClaims.UserId, req.UserId, req.ExpiresAt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Add guest error", "error", err)
+
+		writeError(w, r, err, "failed to add guest")
+		return
+	}
+
+	response := Response{
+		Message: "guest added successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/leave - Leave a group chat
+func (h *HttpHandler) LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.LeaveGroup(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Leave group error", "error", err)
+
+		writeError(w, r, err, "failed to leave group")
+		return
+	}
+
+	response := Response{
+		Message: "left group successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/subscribe - Subscribe to a channel of the user's workspace
+func (h *HttpHandler) SubscribeChannel(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.SubscribeChannel(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Subscribe channel error", "error", err)
+
+		writeError(w, r, err, "failed to subscribe to channel")
+		return
+	}
+
+	response := Response{
+		Message: "subscribed to channel successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/subscribe - Unsubscribe from a channel
+func (h *HttpHandler) UnsubscribeChannel(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.UnsubscribeChannel(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unsubscribe channel error", "error", err)
+
+		writeError(w, r, err, "failed to unsubscribe from channel")
+		return
+	}
+
+	response := Response{
+		Message: "unsubscribed from channel successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /invitations - Get pending invitations for authenticated user
+func (h *HttpHandler) GetPendingInvitations(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	invitations, err := h.chatUc.GetPendingInvitations(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get invitations error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    invitations,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /invitations/:invitationId/respond - Accept or reject an invitation
+func (h *HttpHandler) RespondToInvitation(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	invitationId := chi.URLParam(r, "invitationId")
+	if invitationId == "" {
+		response := Response{Message: "invitationId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.RespondInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.RespondToInvitation(r.Context(), invitationId, userClaims.UserId, req.Accept)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Respond to invitation error", "error", err)
+
+		writeError(w, r, err, "failed to respond to invitation")
+		return
+	}
+
+	message := "invitation rejected"
+	if req.Accept {
+		message = "invitation accepted"
+	}
+
+	response := Response{
+		Message: message,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /invitations/:invitationId - Cancel a pending invitation (inviter or group admin only)
+func (h *HttpHandler) CancelInvitation(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	invitationId := chi.URLParam(r, "invitationId")
+	if invitationId == "" {
+		response := Response{Message: "invitationId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.CancelInvitation(r.Context(), invitationId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Cancel invitation error", "error", err)
+
+		writeError(w, r, err, "failed to cancel invitation")
+		return
+	}
+
+	response := Response{
+		Message: "invitation cancelled",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /chat/:chatId/invitations - Get the pending invitations of a group (admin only)
+func (h *HttpHandler) ListChatInvitations(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	invitations, err := h.chatUc.GetChatInvitations(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get chat invitations error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    invitations,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/invite-link - Create an invite link of a group or a channel (admin only)
+func (h *HttpHandler) CreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// The body is optional, a link with the default expiry is created without it
+	var req entity.CreateInviteLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	link, err := h.chatUc.CreateInviteLink(r.Context(), chatId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Create invite link error", "error", err)
+
+		writeError(w, r, err, "failed to create invite link")
+		return
+	}
+
+	response := Response{
+		Message: "invite link created successfully",
+		Data:    link,
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// GET /chat/:chatId/invite-link - Get the invite links of a chat still letting users join (admin only)
+func (h *HttpHandler) ListInviteLinks(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	links, err := h.chatUc.GetInviteLinks(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get invite links error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    links,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/invite-link/:linkId - Revoke an invite link (admin only)
+func (h *HttpHandler) RevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	linkId := chi.URLParam(r, "linkId")
+	if chatId == "" || linkId == "" {
+		response := Response{Message: "chatId and linkId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.RevokeInviteLink(r.Context(), chatId, linkId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Revoke invite link error", "error", err)
+
+		writeError(w, r, err, "failed to revoke invite link")
+		return
+	}
+
+	response := Response{
+		Message: "invite link revoked",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/join/:token - Join a chat through an invite link, or ask to join it when the link requires approval
+func (h *HttpHandler) JoinByInviteLink(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		response := Response{Message: "token is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	result, err := h.chatUc.JoinByInviteLink(r.Context(), token, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Join by invite link error", "error", err)
+
+		writeError(w, r, err, "failed to join chat")
+		return
+	}
+
+	if !result.Joined {
+		response := Response{
+			Message: "join request sent",
+			Data:    result,
+		}
+		writeJSON(w, r, http.StatusAccepted, response)
+		return
+	}
+
+	response := Response{
+		Message: "joined chat successfully",
+		Data:    result,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/join-request - Join a group or ask its admins to, as its join policy allows
+func (h *HttpHandler) RequestToJoin(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	result, err := h.chatUc.RequestToJoin(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Request to join error", "error", err)
+
+		writeError(w, r, err, "failed to join chat")
+		return
+	}
+
+	if !result.Joined {
+		response := Response{
+			Message: "join request sent",
+			Data:    result,
+		}
+		writeJSON(w, r, http.StatusAccepted, response)
+		return
+	}
+
+	response := Response{
+		Message: "joined chat successfully",
+		Data:    result,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /chat/:chatId/join-requests - Get the join requests of a chat waiting for an answer (admin only)
+func (h *HttpHandler) ListJoinRequests(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	requests, err := h.chatUc.GetJoinRequests(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get join requests error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    requests,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/join-requests/:requestId/respond - Approve or deny a join request (admin only)
+func (h *HttpHandler) RespondToJoinRequest(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	requestId := chi.URLParam(r, "requestId")
+	if chatId == "" || requestId == "" {
+		response := Response{Message: "chatId and requestId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.RespondJoinRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.RespondToJoinRequest(r.Context(), chatId, requestId, userClaims.UserId, req.Approve)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Respond to join request error", "error", err)
+
+		writeError(w, r, err, "failed to respond to join request")
+		return
+	}
+
+	message := "join request denied"
+	if req.Approve {
+		message = "join request approved"
+	}
+
+	response := Response{
+		Message: message,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /user/:id - Get user by ID
+func (h *HttpHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userId := chi.URLParam(r, "id")
+
+	response := Response{}
+	user, err := h.userUc.GetPublic(r.Context(), userId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get user error", "error", err)
+		response.Message = "user not found"
+		writeJSON(w, r, http.StatusNotFound, response)
+		return
+	}
+
+	response.Message = "success"
+	response.Data = user
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /user/me - Get the full profile of the authenticated user
+func (h *HttpHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	user, err := h.userUc.Get(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get profile error", "error", err)
+		response := Response{Message: "user not found"}
+		writeJSON(w, r, http.StatusNotFound, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    user,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /user/me - Update the profile of the authenticated user
+func (h *HttpHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	user, err := h.userUc.UpdateProfile(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update profile error", "error", err)
+
+		writeError(w, r, err, "failed to update profile")
+		return
+	}
+
+	response := Response{
+		Message: "profile updated successfully",
+		Data:    user,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /chat/:chatId - Update group chat metadata (admin only)
+func (h *HttpHandler) UpdateChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.UpdateChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	if req.Name != nil && *req.Name == "" {
+		response := Response{Message: "group name cannot be empty"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	chat, err := h.chatUc.Update(r.Context(), chatId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update chat error", "error", err)
+
+		writeError(w, r, err, "failed to update chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat updated successfully",
+		Data:    chat,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/settings - Update the settings shared by the participants, such as disappearing messages and the pin limit
+func (h *HttpHandler) UpdateChatSettings(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.ChatSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	chat, err := h.chatUc.UpdateSettings(r.Context(), chatId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update chat settings error", "error", err)
+
+		writeError(w, r, err, "failed to update chat settings")
+		return
+	}
+
+	response := Response{
+		Message: "chat settings updated successfully",
+		Data:    chat,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId - Delete a chat (admin only)
+func (h *HttpHandler) DeleteChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.Delete(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Delete chat error", "error", err)
+
+		writeError(w, r, err, "failed to delete chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat deleted successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/participants/:userId/role - Promote or demote a participant (admin only)
+func (h *HttpHandler) UpdateParticipantRole(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	targetUserId := chi.URLParam(r, "userId")
+	if chatId == "" || targetUserId == "" {
+		response := Response{Message: "chatId and userId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.UpdateParticipantRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.UpdateParticipantRole(r.Context(), chatId, userClaims.UserId, targetUserId, req.Role)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update participant role error", "error", err)
+
+		writeError(w, r, err, "failed to update participant role")
+		return
+	}
+
+	response := Response{
+		Message: "participant role updated successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/participants/:userId - Remove a member from a group chat (admin only)
+func (h *HttpHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	targetUserId := chi.URLParam(r, "userId")
+	if chatId == "" || targetUserId == "" {
+		response := Response{Message: "chatId and userId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.RemoveMember(r.Context(), chatId, userClaims.UserId, targetUserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Remove member error", "error", err)
+
+		writeError(w, r, err, "failed to remove member")
+		return
+	}
+
+	response := Response{
+		Message: "member removed successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/mute - Mute the notifications of a chat, optionally for durationMinutes
+func (h *HttpHandler) MuteChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	// The body is optional, without a duration the chat stays muted until it is unmuted
+	var req entity.MuteChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.MuteChat(r.Context(), chatId, userClaims.UserId, time.Duration(req.DurationMinutes)*time.Minute)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Mute chat error", "error", err)
+
+		writeError(w, r, err, "failed to mute chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat muted successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/mute - Unmute the notifications of a chat
+func (h *HttpHandler) UnmuteChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.UnmuteChat(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unmute chat error", "error", err)
+
+		writeError(w, r, err, "failed to unmute chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat unmuted successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /chat/:chatId/notification-mode - Get notified of every message of a chat or only of the mentions
+func (h *HttpHandler) SetNotificationMode(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.NotificationModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.SetNotificationMode(r.Context(), chatId, userClaims.UserId, req.Mode)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Set notification mode error", "error", err)
+
+		writeError(w, r, err, "failed to set notification mode")
+		return
+	}
+
+	response := Response{
+		Message: "notification mode updated successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/messages/:messageId/mute - Mute the replies to a message, the rest of the chat still notifies
+func (h *HttpHandler) MuteThread(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	messageId := chi.URLParam(r, "messageId")
+	if chatId == "" || messageId == "" {
+		response := Response{Message: "chatId and messageId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.MuteThread(r.Context(), chatId, messageId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Mute thread error", "error", err)
+
+		writeError(w, r, err, "failed to mute thread")
+		return
+	}
+
+	response := Response{
+		Message: "thread muted successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/messages/:messageId/mute - Unmute the replies to a message
+func (h *HttpHandler) UnmuteThread(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	messageId := chi.URLParam(r, "messageId")
+	if chatId == "" || messageId == "" {
+		response := Response{Message: "chatId and messageId are required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.UnmuteThread(r.Context(), chatId, messageId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unmute thread error", "error", err)
+
+		writeError(w, r, err, "failed to unmute thread")
+		return
+	}
+
+	response := Response{
+		Message: "thread unmuted successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/pin - Pin a chat to the top of the chat list
+func (h *HttpHandler) PinChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.PinChat(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Pin chat error", "error", err)
+
+		writeError(w, r, err, "failed to pin chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat pinned successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /chat/:chatId/pin - Unpin a chat
+func (h *HttpHandler) UnpinChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.UnpinChat(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unpin chat error", "error", err)
+
+		writeError(w, r, err, "failed to unpin chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat unpinned successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/archive - Archive a chat out of the default chat list
+func (h *HttpHandler) ArchiveChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.ArchiveChat(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Archive chat error", "error", err)
+
+		writeError(w, r, err, "failed to archive chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat archived successfully",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /chat/:chatId/unarchive - Move a chat back to the default chat list
+func (h *HttpHandler) UnarchiveChat(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	chatId := chi.URLParam(r, "chatId")
+	if chatId == "" {
+		response := Response{Message: "chatId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.chatUc.UnarchiveChat(r.Context(), chatId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unarchive chat error", "error", err)
+
+		writeError(w, r, err, "failed to unarchive chat")
+		return
+	}
+
+	response := Response{
+		Message: "chat unarchived successfully",
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, r, http.StatusOK, response)
 }
@@ -0,0 +1,189 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"wetalk/infrastructure/ws"
+	"wetalk/internal/usecase"
+	"wetalk/pkg/metrics"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	// liveMetricsInterval is the default sampling interval of the admin metrics stream
+	liveMetricsInterval    = time.Second
+	minLiveMetricsInterval = 250 * time.Millisecond
+	maxLiveMetricsInterval = time.Minute
+)
+
+type MetricsHandler struct {
+	metricsUc usecase.MetricsUsecase
+	registry  *metrics.Registry
+	hub       ws.IHub
+	// scrapeToken must be sent as a bearer token by Prometheus, scraping is disabled when empty
+	scrapeToken string
+}
+
+func NewMetricsHandler(metricsUc usecase.MetricsUsecase, registry *metrics.Registry, hub ws.IHub, scrapeToken string) *MetricsHandler {
+	return &MetricsHandler{
+		metricsUc:   metricsUc,
+		registry:    registry,
+		hub:         hub,
+		scrapeToken: scrapeToken,
+	}
+}
+
+// authorizeOperator checks the METRICS_TOKEN bearer token, the operator endpoints don't exist without one
+func (h *MetricsHandler) authorizeOperator(w http.ResponseWriter, r *http.Request) bool {
+	if h.scrapeToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.scrapeToken)) != 1 {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return false
+	}
+
+	return true
+}
+
+// GET /metrics - Prometheus scrape endpoint, authenticated with the METRICS_TOKEN bearer token
+func (h *MetricsHandler) Scrape(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeOperator(w, r) {
+		return
+	}
+
+	h.registry.Handler().ServeHTTP(w, r)
+}
+
+// GET /debug/hub - Send buffers of every connection on this server, slowest consumers first,
+// authenticated with the METRICS_TOKEN bearer token
+func (h *MetricsHandler) DebugHub(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeOperator(w, r) {
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    h.hub.Stats(),
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// CountErrors counts the server errors answered by the HTTP API for the live metrics, requests
+// abandoned by their client are counted apart so they don't look like failures
+func (h *MetricsHandler) CountErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The wrapper keeps the flusher and hijacker the event streams and websockets need
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		switch {
+		case ww.Status() == statusClientClosedRequest:
+			h.metricsUc.RecordCancellation("http")
+		case ww.Status() >= http.StatusInternalServerError:
+			h.metricsUc.RecordError("http")
+		}
+	})
+}
+
+// GET /admin/metrics/stream?interval=1s - Live counters of this server as server-sent events (super admin only)
+func (h *MetricsHandler) AdminStream(w http.ResponseWriter, r *http.Request) {
+	interval := liveMetricsInterval
+	if raw := r.URL.Query().Get("interval"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < minLiveMetricsInterval || parsed > maxLiveMetricsInterval {
+			response := Response{Message: fmt.Sprintf("interval must be a duration between %s and %s", minLiveMetricsInterval, maxLiveMetricsInterval)}
+			writeJSON(w, r, http.StatusBadRequest, response)
+			return
+		}
+		interval = parsed
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := h.metricsUc.Counters()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := h.metricsUc.Counters()
+		data, err := json.Marshal(current.LiveSince(previous))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Marshal live metrics error", "error", err)
+			return
+		}
+		previous = current
+
+		fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data)
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// GET /admin/metrics/workspaces?period=2026-01 - Usage of every workspace for a billing period (super admin only)
+func (h *MetricsHandler) AdminListTenantUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.metricsUc.ListTenantUsage(r.Context(), r.URL.Query().Get("period"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List tenant usage error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    usage,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/metrics/workspaces/:workspaceId?period=2026-01 - Usage of one workspace for a billing period (super admin only)
+func (h *MetricsHandler) AdminGetTenantUsage(w http.ResponseWriter, r *http.Request) {
+	workspaceId := chi.URLParam(r, "workspaceId")
+	if workspaceId == "" {
+		response := Response{Message: "workspaceId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	usage, err := h.metricsUc.GetTenantUsage(r.Context(), workspaceId, r.URL.Query().Get("period"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get tenant usage error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    usage,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
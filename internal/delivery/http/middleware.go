@@ -2,16 +2,88 @@ package http
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+	"wetalk/internal/entity"
 	"wetalk/internal/usecase"
+	"wetalk/pkg/logger"
+	"wetalk/pkg/tracing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type contextKey string
 
 const UserContextKey contextKey = "user"
 
+// RequestLogger tags the request context with the request id so every log line of the request
+// carries it, echoes the id back in the X-Request-Id header and logs the request once served.
+// It must run after chi's RequestID middleware
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := middleware.GetReqID(r.Context())
+		w.Header().Set(middleware.RequestIDHeader, requestId)
+		ctx := logger.With(r.Context(), slog.String("request_id", requestId))
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		slog.InfoContext(ctx, "HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// NewTracingMiddleware records a span per request, continuing the trace of an incoming traceparent
+// header. The trace id is added to the log lines of the request
+func NewTracingMiddleware(tracer trace.Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := tracing.WithTraceParent(r.Context(), r.Header.Get("traceparent"))
+			ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+			if traceId := span.SpanContext().TraceID(); traceId.IsValid() {
+				ctx = logger.With(ctx, slog.String("trace_id", traceId.String()))
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			// The route is only known once chi matched it
+			if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(attribute.String("http.route", route))
+			}
+			span.SetAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.Int("http.response.status_code", ww.Status()),
+			)
+			if ww.Status() >= http.StatusInternalServerError {
+				tracing.RecordError(span, errors.New(http.StatusText(ww.Status())))
+			}
+		})
+	}
+}
+
 type AuthMiddleware struct {
 	authUc usecase.AuthUsecase
 }
@@ -27,9 +99,7 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			response := Response{Message: "authorization header required"}
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+			writeJSON(w, r, http.StatusUnauthorized, response)
 			return
 		}
 
@@ -37,9 +107,7 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			response := Response{Message: "invalid authorization header format"}
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+			writeJSON(w, r, http.StatusUnauthorized, response)
 			return
 		}
 
@@ -47,14 +115,224 @@ func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 		claims, err := m.authUc.ValidateAccessToken(token)
 		if err != nil {
 			response := Response{Message: "invalid or expired token"}
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+			writeJSON(w, r, http.StatusUnauthorized, response)
 			return
 		}
 
 		// Add user claims to context
 		ctx := context.WithValue(r.Context(), UserContextKey, claims)
+		ctx = logger.With(ctx, slog.String("user_id", claims.UserId))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
-}
\ No newline at end of file
+}
+
+// RequireSuperAdmin only lets platform operators through, it must run after Authenticate
+func (m *AuthMiddleware) RequireSuperAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+		if !ok {
+			response := Response{Message: "unauthorized"}
+			writeJSON(w, r, http.StatusUnauthorized, response)
+			return
+		}
+
+		isSuperAdmin, err := m.authUc.IsSuperAdmin(r.Context(), userClaims.UserId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Check super admin error", "error", err)
+		}
+		if !isSuperAdmin {
+			response := Response{Message: "forbidden"}
+			writeJSON(w, r, http.StatusForbidden, response)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type ConsentMiddleware struct {
+	consentUc usecase.ConsentUsecase
+}
+
+func NewConsentMiddleware(consentUc usecase.ConsentUsecase) *ConsentMiddleware {
+	return &ConsentMiddleware{
+		consentUc: consentUc,
+	}
+}
+
+// RequireConsent blocks users that haven't accepted the latest legal documents with
+// 451 Unavailable For Legal Reasons, it must run after Authenticate
+func (m *ConsentMiddleware) RequireConsent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+		if !ok {
+			response := Response{Message: "unauthorized"}
+			writeJSON(w, r, http.StatusUnauthorized, response)
+			return
+		}
+
+		pending, err := m.consentUc.GetPendingDocuments(r.Context(), userClaims.UserId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Get pending documents error", "error", err)
+			response := Response{Message: "internal server error"}
+			writeJSON(w, r, http.StatusInternalServerError, response)
+			return
+		}
+
+		if len(pending) > 0 {
+			response := Response{
+				Message: "consent required",
+				Data:    pending,
+			}
+			writeJSON(w, r, http.StatusUnavailableForLegalReasons, response)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type AbuseMiddleware struct {
+	abuseUc usecase.AbuseUsecase
+}
+
+func NewAbuseMiddleware(abuseUc usecase.AbuseUsecase) *AbuseMiddleware {
+	return &AbuseMiddleware{
+		abuseUc: abuseUc,
+	}
+}
+
+// RejectSuspended blocks suspended accounts with 403 Forbidden, it must run after Authenticate.
+// Suspended users can still sign in to read their restriction and appeal it
+func (m *AbuseMiddleware) RejectSuspended(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+		if !ok {
+			response := Response{Message: "unauthorized"}
+			writeJSON(w, r, http.StatusUnauthorized, response)
+			return
+		}
+
+		err := m.abuseUc.CheckNotSuspended(r.Context(), userClaims.UserId)
+		if err != nil {
+			if !errors.Is(err, usecase.ErrAccountSuspended) {
+				slog.ErrorContext(r.Context(), "Check suspension error", "error", err)
+			}
+			writeError(w, r, err, "internal server error")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Headers of the API rate limit, the reset is a unix timestamp in seconds
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+type RateLimitMiddleware struct {
+	quotaUc usecase.QuotaUsecase
+	// enforce answers the requests over the limit with 429, otherwise the limit is only advertised
+	enforce bool
+}
+
+func NewRateLimitMiddleware(quotaUc usecase.QuotaUsecase, enforce bool) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		quotaUc: quotaUc,
+		enforce: enforce,
+	}
+}
+
+// Limit counts the request against the rate limit of the user and writes the X-RateLimit headers,
+// it must run after Authenticate. The requests go through when the limiter fails
+func (m *RateLimitMiddleware) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+		if !ok {
+			response := Response{Message: "unauthorized"}
+			writeJSON(w, r, http.StatusUnauthorized, response)
+			return
+		}
+
+		limit, err := m.quotaUc.Hit(r.Context(), userClaims.UserId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Rate limit error", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(RateLimitLimitHeader, strconv.FormatInt(limit.Limit, 10))
+		w.Header().Set(RateLimitRemainingHeader, strconv.FormatInt(limit.Remaining, 10))
+		w.Header().Set(RateLimitResetHeader, strconv.FormatInt(limit.ResetAt.Unix(), 10))
+
+		if limit.Exceeded && m.enforce {
+			retryAfter := int64(math.Ceil(time.Until(limit.ResetAt).Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(max(retryAfter, 1), 10))
+			response := Response{Message: "rate limit exceeded, retry after the reset"}
+			writeJSON(w, r, http.StatusTooManyRequests, response)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type MaintenanceMiddleware struct {
+	adminUc usecase.AdminUsecase
+	authUc  usecase.AuthUsecase
+}
+
+func NewMaintenanceMiddleware(adminUc usecase.AdminUsecase, authUc usecase.AuthUsecase) *MaintenanceMiddleware {
+	return &MaintenanceMiddleware{
+		adminUc: adminUc,
+		authUc:  authUc,
+	}
+}
+
+// RejectDuringMaintenance answers 503 while the maintenance mode is on, the super admins still
+// get through to turn it off. It must run after Authenticate, the requests go through when the
+// mode can't be read
+func (m *MaintenanceMiddleware) RejectDuringMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+		if !ok {
+			response := Response{Message: "unauthorized"}
+			writeJSON(w, r, http.StatusUnauthorized, response)
+			return
+		}
+
+		mode, err := m.adminUc.GetMaintenanceMode(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Get maintenance mode error", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !mode.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		isSuperAdmin, err := m.authUc.IsSuperAdmin(r.Context(), userClaims.UserId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Check super admin error", "error", err)
+		}
+		if isSuperAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message := mode.Message
+		if message == "" {
+			message = usecase.ErrUnderMaintenance.Error()
+		}
+		response := Response{Message: message}
+		writeJSON(w, r, http.StatusServiceUnavailable, response)
+	})
+}
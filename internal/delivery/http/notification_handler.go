@@ -0,0 +1,166 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type NotificationHandler struct {
+	notificationUc usecase.NotificationUsecase
+}
+
+func NewNotificationHandler(notificationUc usecase.NotificationUsecase) *NotificationHandler {
+	return &NotificationHandler{
+		notificationUc: notificationUc,
+	}
+}
+
+// POST /devices - Register a device for push notifications
+func (h *NotificationHandler) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.RegisterDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	device, err := h.notificationUc.RegisterDevice(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Register device error", "error", err)
+
+		writeError(w, r, err, "failed to register device")
+		return
+	}
+
+	response := Response{
+		Message: "device registered",
+		Data:    device,
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// GET /devices - List the devices registered by the current user
+func (h *NotificationHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	devices, err := h.notificationUc.GetDevices(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List devices error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    devices,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /devices/:deviceId - Stop sending push notifications to a device
+func (h *NotificationHandler) RemoveDevice(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	deviceId := chi.URLParam(r, "deviceId")
+	if deviceId == "" {
+		response := Response{Message: "deviceId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.notificationUc.RemoveDevice(r.Context(), userClaims.UserId, deviceId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Remove device error", "error", err)
+
+		writeError(w, r, err, "failed to remove device")
+		return
+	}
+
+	response := Response{
+		Message: "device removed",
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /notifications/preferences - Get the push notification preferences of the current user
+func (h *NotificationHandler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	preferences, err := h.notificationUc.GetPreferences(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get notification preferences error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    preferences,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /notifications/preferences - Replace the push notification preferences of the current user
+func (h *NotificationHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.UpdateNotificationPreferencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	preferences, err := h.notificationUc.UpdatePreferences(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update notification preferences error", "error", err)
+		response := Response{Message: "failed to update notification preferences"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "notification preferences updated",
+		Data:    preferences,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
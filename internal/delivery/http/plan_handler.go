@@ -0,0 +1,109 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type PlanHandler struct {
+	planUc usecase.PlanUsecase
+}
+
+func NewPlanHandler(planUc usecase.PlanUsecase) *PlanHandler {
+	return &PlanHandler{
+		planUc: planUc,
+	}
+}
+
+// GET /workspace/plan - Get the plan, limits and usage of the authenticated user's workspace
+func (h *PlanHandler) GetWorkspacePlan(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	plan, err := h.planUc.GetUserWorkspacePlan(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get workspace plan error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    plan,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// GET /admin/workspaces/:workspaceId/plan - Get the plan and usage of any workspace (super admin only)
+func (h *PlanHandler) AdminGetWorkspacePlan(w http.ResponseWriter, r *http.Request) {
+	workspaceId := chi.URLParam(r, "workspaceId")
+	if workspaceId == "" {
+		response := Response{Message: "workspaceId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	plan, err := h.planUc.GetWorkspacePlan(r.Context(), workspaceId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Admin get workspace plan error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    plan,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /admin/workspaces/:workspaceId/plan - Assign a plan to a workspace (super admin only)
+func (h *PlanHandler) AdminAssignPlan(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	workspaceId := chi.URLParam(r, "workspaceId")
+	if workspaceId == "" {
+		response := Response{Message: "workspaceId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.AssignPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	plan, err := h.planUc.AssignPlan(r.Context(), userClaims.UserId, workspaceId, req.Plan)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Assign plan error", "error", err)
+
+		writeError(w, r, err, "failed to assign plan")
+		return
+	}
+
+	response := Response{
+		Message: "plan assigned successfully",
+		Data:    plan,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+)
+
+type QuotaHandler struct {
+	quotaUc usecase.QuotaUsecase
+}
+
+func NewQuotaHandler(quotaUc usecase.QuotaUsecase) *QuotaHandler {
+	return &QuotaHandler{
+		quotaUc: quotaUc,
+	}
+}
+
+// GET /user/limits - Get the rate limit and quota usage of the authenticated user
+func (h *QuotaHandler) GetUserLimits(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	limits, err := h.quotaUc.GetUserLimits(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get user limits error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    limits,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ReportHandler struct {
+	reportUc usecase.ReportUsecase
+}
+
+func NewReportHandler(reportUc usecase.ReportUsecase) *ReportHandler {
+	return &ReportHandler{
+		reportUc: reportUc,
+	}
+}
+
+// POST /reports - Report a message or a user to the admins
+func (h *ReportHandler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.CreateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	report, err := h.reportUc.Create(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Create report error", "error", err)
+
+		writeError(w, r, err, "failed to create report")
+		return
+	}
+
+	response := Response{
+		Message: "report sent successfully",
+		Data:    report,
+	}
+	writeJSON(w, r, http.StatusCreated, response)
+}
+
+// GET /admin/reports?status=&targetType=&limit=&offset= - Reports of the users, oldest first (super admin only)
+func (h *ReportHandler) AdminListReports(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := entity.ReportFilter{
+		Status:     query.Get("status"),
+		TargetType: query.Get("targetType"),
+	}
+	if err := parsePage(query, &filter.Limit, &filter.Offset); err != nil {
+		writeError(w, r, err, "invalid report filter")
+		return
+	}
+
+	reports, err := h.reportUc.List(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List reports error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message:    "success",
+		Data:       reports,
+		Pagination: &Pagination{Limit: usecase.ReportLimit(filter.Limit), Offset: filter.Offset, Count: len(reports)},
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// POST /admin/reports/:reportId/resolve - Close a report as resolved or dismissed (super admin only)
+func (h *ReportHandler) AdminResolveReport(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	reportId := chi.URLParam(r, "reportId")
+	if reportId == "" {
+		response := Response{Message: "reportId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	var req entity.ResolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	report, err := h.reportUc.Resolve(r.Context(), reportId, userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Resolve report error", "error", err)
+
+		writeError(w, r, err, "failed to resolve report")
+		return
+	}
+
+	response := Response{
+		Message: "report resolved successfully",
+		Data:    report,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
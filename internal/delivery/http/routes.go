@@ -7,8 +7,16 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-func MapHttpRoutes(r *chi.Mux, httpHandler HttpHandler, websocketHandler wsDelivery.WebsocketHandler, authHandler AuthHandler, authMiddleware *AuthMiddleware) {
+func MapHttpRoutes(r *chi.Mux, httpHandler HttpHandler, websocketHandler wsDelivery.WebsocketHandler, authHandler AuthHandler, settingsHandler SettingsHandler, planHandler PlanHandler, consentHandler ConsentHandler, sessionHandler SessionHandler, notificationHandler NotificationHandler, autoResponderHandler AutoResponderHandler, sseHandler *SSEHandler, graphqlHandler *GraphQLHandler, abuseHandler AbuseHandler, metricsHandler MetricsHandler, adminHandler AdminHandler, reportHandler ReportHandler, quotaHandler QuotaHandler, scheduledMessageHandler ScheduledMessageHandler, accountMergeHandler AccountMergeHandler, deliveryHealthHandler DeliveryHealthHandler, authMiddleware *AuthMiddleware, consentMiddleware *ConsentMiddleware, abuseMiddleware *AbuseMiddleware, rateLimitMiddleware *RateLimitMiddleware, maintenanceMiddleware *MaintenanceMiddleware) {
 	r.Handle("/ws/{userId}", http.HandlerFunc(websocketHandler.HandleWebSocket))
+	// GraphQL subscriptions authenticate in the connection_init of their websocket
+	r.Get("/graphql", http.HandlerFunc(graphqlHandler.Subscribe))
+	r.Get("/metrics", http.HandlerFunc(metricsHandler.Scrape))
+	r.Get("/debug/hub", http.HandlerFunc(metricsHandler.DebugHub))
+
+	// Back-office pages, they sign in and call the admin routes like any client
+	r.Get("/admin/ui", http.RedirectHandler("/admin/ui/", http.StatusMovedPermanently).ServeHTTP)
+	r.Handle("/admin/ui/*", AdminUI())
 
 	// Auth routes (public)
 	r.Route("/auth", func(r chi.Router) {
@@ -16,21 +24,57 @@ func MapHttpRoutes(r *chi.Mux, httpHandler HttpHandler, websocketHandler wsDeliv
 		r.Post("/login", http.HandlerFunc(authHandler.Login))
 		r.Post("/refresh", http.HandlerFunc(authHandler.RefreshToken))
 		r.Post("/logout", http.HandlerFunc(authHandler.Logout))
+		r.Post("/2fa/challenge", http.HandlerFunc(authHandler.CompleteTwoFactorChallenge))
 
 		// Protected auth routes
 		r.Group(func(r chi.Router) {
 			r.Use(authMiddleware.Authenticate)
 			r.Post("/logout-all", http.HandlerFunc(authHandler.LogoutAllDevices))
+			r.Get("/sessions", http.HandlerFunc(authHandler.ListSessions))
+			r.Post("/2fa/setup", http.HandlerFunc(authHandler.SetupTwoFactor))
+			r.Post("/2fa/verify", http.HandlerFunc(authHandler.VerifyTwoFactor))
+		})
+	})
+
+	// Legal document routes, reachable without prior consent
+	r.Route("/legal", func(r chi.Router) {
+		r.Get("/documents", http.HandlerFunc(consentHandler.GetDocuments))
+
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware.Authenticate)
+			r.Get("/pending", http.HandlerFunc(consentHandler.GetPendingDocuments))
+			r.Post("/accept", http.HandlerFunc(consentHandler.AcceptDocument))
 		})
 	})
 
+	// Account routes stay reachable while suspended so the restriction can be appealed
+	r.Route("/account", func(r chi.Router) {
+		r.Use(authMiddleware.Authenticate)
+		r.Get("/restriction", http.HandlerFunc(abuseHandler.GetRestriction))
+		r.Post("/appeal", http.HandlerFunc(abuseHandler.SubmitAppeal))
+	})
+
 	// Protected routes
 	r.Group(func(r chi.Router) {
 		r.Use(authMiddleware.Authenticate)
+		r.Use(consentMiddleware.RequireConsent)
+		r.Use(abuseMiddleware.RejectSuspended)
+		r.Use(rateLimitMiddleware.Limit)
+		r.Use(maintenanceMiddleware.RejectDuringMaintenance)
+
+		// Server-sent events fallback for clients that can't open a websocket
+		r.Get("/sse", http.HandlerFunc(sseHandler.Stream))
+
+		// GraphQL queries and mutations over the chats and messages
+		r.Post("/graphql", http.HandlerFunc(graphqlHandler.Query))
 
 		// User routes
 		r.Route("/user", func(r chi.Router) {
 			r.Get("/", http.HandlerFunc(httpHandler.ListUsers))
+			r.Get("/me", http.HandlerFunc(httpHandler.GetProfile))
+			r.Get("/limits", http.HandlerFunc(quotaHandler.GetUserLimits))
+			r.Put("/me", http.HandlerFunc(httpHandler.UpdateProfile))
+			r.Put("/me/password", http.HandlerFunc(authHandler.ChangePassword))
 			r.Get("/{id}", http.HandlerFunc(httpHandler.GetUser))
 			r.Get("/chats", http.HandlerFunc(httpHandler.ListUserChats))
 		})
@@ -40,21 +84,140 @@ func MapHttpRoutes(r *chi.Mux, httpHandler HttpHandler, websocketHandler wsDeliv
 			// Create chats
 			r.Post("/personal", http.HandlerFunc(httpHandler.CreatePersonalChat))
 			r.Post("/group", http.HandlerFunc(httpHandler.CreateGroupChat))
+			r.Post("/channel", http.HandlerFunc(httpHandler.CreateChannel))
+			r.Post("/join/{token}", http.HandlerFunc(httpHandler.JoinByInviteLink))
 
 			// Chat operations
 			r.Get("/{chatId}", http.HandlerFunc(httpHandler.GetChat))
+			r.Put("/{chatId}", http.HandlerFunc(httpHandler.UpdateChat))
 			r.Delete("/{chatId}", http.HandlerFunc(httpHandler.DeleteChat))
+			r.Post("/{chatId}/settings", http.HandlerFunc(httpHandler.UpdateChatSettings))
+			r.Get("/{chatId}/participants", http.HandlerFunc(httpHandler.ListParticipants))
 			r.Get("/{chatId}/messages", http.HandlerFunc(httpHandler.GetMessages))
+			r.Get("/{chatId}/messages/{messageId}/thread", http.HandlerFunc(httpHandler.GetThread))
+			r.Get("/{chatId}/messages/{messageId}/context", http.HandlerFunc(httpHandler.GetMessageContext))
+			r.Get("/{chatId}/pins", http.HandlerFunc(httpHandler.ListPinnedMessages))
+			r.Put("/{chatId}/pins/order", http.HandlerFunc(httpHandler.ReorderPins))
+			r.Post("/{chatId}/messages/{messageId}/pin", http.HandlerFunc(httpHandler.PinMessage))
+			r.Delete("/{chatId}/messages/{messageId}/pin", http.HandlerFunc(httpHandler.UnpinMessage))
+			r.Get("/{chatId}/membership-version", http.HandlerFunc(httpHandler.GetMembershipVersion))
+			r.Get("/{chatId}/membership", http.HandlerFunc(httpHandler.GetMembershipDiff))
+			r.Get("/{chatId}/viewers", http.HandlerFunc(httpHandler.GetChatViewers))
+			r.Post("/{chatId}/read", http.HandlerFunc(httpHandler.ReadChat))
+
+			// Participant settings
+			r.Post("/{chatId}/mute", http.HandlerFunc(httpHandler.MuteChat))
+			r.Delete("/{chatId}/mute", http.HandlerFunc(httpHandler.UnmuteChat))
+			r.Put("/{chatId}/notification-mode", http.HandlerFunc(httpHandler.SetNotificationMode))
+			r.Post("/{chatId}/messages/{messageId}/mute", http.HandlerFunc(httpHandler.MuteThread))
+			r.Delete("/{chatId}/messages/{messageId}/mute", http.HandlerFunc(httpHandler.UnmuteThread))
+			r.Post("/{chatId}/pin", http.HandlerFunc(httpHandler.PinChat))
+			r.Delete("/{chatId}/pin", http.HandlerFunc(httpHandler.UnpinChat))
+			r.Post("/{chatId}/archive", http.HandlerFunc(httpHandler.ArchiveChat))
+			r.Post("/{chatId}/unarchive", http.HandlerFunc(httpHandler.UnarchiveChat))
 
 			// Group chat operations
 			r.Post("/{chatId}/invite", http.HandlerFunc(httpHandler.InviteUsersToGroup))
+			r.Get("/{chatId}/invitations", http.HandlerFunc(httpHandler.ListChatInvitations))
+			r.Post("/{chatId}/invite-link", http.HandlerFunc(httpHandler.CreateInviteLink))
+			r.Get("/{chatId}/invite-link", http.HandlerFunc(httpHandler.ListInviteLinks))
+			r.Delete("/{chatId}/invite-link/{linkId}", http.HandlerFunc(httpHandler.RevokeInviteLink))
+			r.Post("/{chatId}/join-request", http.HandlerFunc(httpHandler.RequestToJoin))
+			r.Get("/{chatId}/join-requests", http.HandlerFunc(httpHandler.ListJoinRequests))
+			r.Post("/{chatId}/join-requests/{requestId}/respond", http.HandlerFunc(httpHandler.RespondToJoinRequest))
+			r.Post("/{chatId}/guests", http.HandlerFunc(httpHandler.AddGuest))
 			r.Post("/{chatId}/leave", http.HandlerFunc(httpHandler.LeaveGroup))
+			r.Post("/{chatId}/participants/{userId}/role", http.HandlerFunc(httpHandler.UpdateParticipantRole))
+			r.Delete("/{chatId}/participants/{userId}", http.HandlerFunc(httpHandler.RemoveMember))
+			r.Get("/{chatId}/auto-responder", http.HandlerFunc(autoResponderHandler.GetAutoResponder))
+			r.Put("/{chatId}/auto-responder", http.HandlerFunc(autoResponderHandler.UpdateAutoResponder))
+			r.Delete("/{chatId}/auto-responder", http.HandlerFunc(autoResponderHandler.DeleteAutoResponder))
+
+			// Channel operations
+			r.Post("/{chatId}/subscribe", http.HandlerFunc(httpHandler.SubscribeChannel))
+			r.Delete("/{chatId}/subscribe", http.HandlerFunc(httpHandler.UnsubscribeChannel))
+		})
+
+		// Message routes
+		r.Route("/messages", func(r chi.Router) {
+			r.Get("/search", http.HandlerFunc(httpHandler.SearchMessages))
+		})
+
+		// Report routes
+		r.Post("/reports", http.HandlerFunc(reportHandler.CreateReport))
+
+		// Scheduled message routes, messages are scheduled on the websocket
+		r.Route("/scheduled-messages", func(r chi.Router) {
+			r.Get("/", http.HandlerFunc(scheduledMessageHandler.ListScheduledMessages))
+			r.Delete("/{scheduledId}", http.HandlerFunc(scheduledMessageHandler.CancelScheduledMessage))
+		})
+
+		// Workspace routes
+		r.Route("/workspace", func(r chi.Router) {
+			r.Get("/settings", http.HandlerFunc(settingsHandler.GetWorkspaceSettings))
+			r.Put("/settings", http.HandlerFunc(settingsHandler.UpdateWorkspaceSettings))
+			r.Get("/plan", http.HandlerFunc(planHandler.GetWorkspacePlan))
+		})
+
+		// Back-office routes
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(authMiddleware.RequireSuperAdmin)
+			r.Get("/workspaces/{workspaceId}/plan", http.HandlerFunc(planHandler.AdminGetWorkspacePlan))
+			r.Put("/workspaces/{workspaceId}/plan", http.HandlerFunc(planHandler.AdminAssignPlan))
+			r.Post("/legal/documents", http.HandlerFunc(consentHandler.PublishDocument))
+			r.Get("/compliance/users", http.HandlerFunc(httpHandler.AdminComplianceReport))
+			r.Get("/sessions", http.HandlerFunc(sessionHandler.AdminListSessions))
+			r.Delete("/sessions", http.HandlerFunc(sessionHandler.AdminInvalidateSubject))
+			r.Delete("/sessions/{sessionId}", http.HandlerFunc(sessionHandler.AdminInvalidateSession))
+			r.Get("/abuse/queue", http.HandlerFunc(abuseHandler.AdminReviewQueue))
+			r.Post("/abuse/{userId}/review", http.HandlerFunc(abuseHandler.AdminReview))
+			r.Get("/metrics/stream", http.HandlerFunc(metricsHandler.AdminStream))
+			r.Get("/metrics/workspaces", http.HandlerFunc(metricsHandler.AdminListTenantUsage))
+			r.Get("/metrics/workspaces/{workspaceId}", http.HandlerFunc(metricsHandler.AdminGetTenantUsage))
+			r.Get("/users", http.HandlerFunc(adminHandler.AdminSearchUsers))
+			r.Post("/users/{userId}/deactivate", http.HandlerFunc(adminHandler.AdminDeactivateUser))
+			r.Post("/users/{userId}/reactivate", http.HandlerFunc(adminHandler.AdminReactivateUser))
+			r.Get("/users/{userId}/merges", http.HandlerFunc(accountMergeHandler.AdminListUserMerges))
+			r.Get("/users/{userId}/sessions", http.HandlerFunc(authHandler.AdminListUserSessions))
+			r.Delete("/users/{userId}/email-bounces", http.HandlerFunc(deliveryHealthHandler.AdminClearEmailBounces))
+			r.Post("/merges", http.HandlerFunc(accountMergeHandler.AdminMergeAccounts))
+			r.Get("/merges/{mergeId}", http.HandlerFunc(accountMergeHandler.AdminGetMerge))
+			r.Post("/merges/{mergeId}/rollback", http.HandlerFunc(accountMergeHandler.AdminRollbackMerge))
+			r.Get("/chats/{chatId}", http.HandlerFunc(adminHandler.AdminGetChat))
+			r.Post("/chats/{chatId}/restore", http.HandlerFunc(adminHandler.AdminRestoreChat))
+			r.Delete("/messages/{messageId}", http.HandlerFunc(adminHandler.AdminDeleteMessage))
+			r.Post("/chats/{chatId}/message-purges/preview", http.HandlerFunc(adminHandler.AdminPreviewMessagePurge))
+			r.Post("/chats/{chatId}/message-purges", http.HandlerFunc(adminHandler.AdminStartMessagePurge))
+			r.Get("/message-purges/{purgeId}", http.HandlerFunc(adminHandler.AdminGetMessagePurge))
+			r.Get("/servers", http.HandlerFunc(adminHandler.AdminListServers))
+			r.Get("/delivery-health", http.HandlerFunc(deliveryHealthHandler.AdminGetDeliveryHealth))
+			r.Post("/delivery-health/cleanup", http.HandlerFunc(deliveryHealthHandler.AdminCleanupDevices))
+			r.Get("/moderation/flags", http.HandlerFunc(adminHandler.AdminGetFlaggedMessages))
+			r.Post("/moderation/flags/{flagId}/review", http.HandlerFunc(adminHandler.AdminReviewFlaggedMessage))
+			r.Get("/reports", http.HandlerFunc(reportHandler.AdminListReports))
+			r.Post("/reports/{reportId}/resolve", http.HandlerFunc(reportHandler.AdminResolveReport))
+			r.Get("/maintenance", http.HandlerFunc(adminHandler.AdminGetMaintenanceMode))
+			r.Put("/maintenance", http.HandlerFunc(adminHandler.AdminSetMaintenanceMode))
+		})
+
+		// Push notification device routes
+		r.Route("/devices", func(r chi.Router) {
+			r.Get("/", http.HandlerFunc(notificationHandler.ListDevices))
+			r.Post("/", http.HandlerFunc(notificationHandler.RegisterDevice))
+			r.Delete("/{deviceId}", http.HandlerFunc(notificationHandler.RemoveDevice))
+		})
+
+		// Push notification preference routes
+		r.Route("/notifications/preferences", func(r chi.Router) {
+			r.Get("/", http.HandlerFunc(notificationHandler.GetPreferences))
+			r.Put("/", http.HandlerFunc(notificationHandler.UpdatePreferences))
 		})
 
 		// Invitation routes
 		r.Route("/invitations", func(r chi.Router) {
 			r.Get("/", http.HandlerFunc(httpHandler.GetPendingInvitations))
 			r.Post("/{invitationId}/respond", http.HandlerFunc(httpHandler.RespondToInvitation))
+			r.Delete("/{invitationId}", http.HandlerFunc(httpHandler.CancelInvitation))
 		})
 	})
 }
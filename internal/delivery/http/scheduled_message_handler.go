@@ -0,0 +1,77 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ScheduledMessageHandler struct {
+	scheduledUc usecase.ScheduledMessageUsecase
+}
+
+func NewScheduledMessageHandler(scheduledUc usecase.ScheduledMessageUsecase) *ScheduledMessageHandler {
+	return &ScheduledMessageHandler{
+		scheduledUc: scheduledUc,
+	}
+}
+
+// GET /scheduled-messages?chatId= - Messages of the authenticated user waiting to be sent, the next due first
+func (h *ScheduledMessageHandler) ListScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	scheduled, err := h.scheduledUc.ListPending(r.Context(), userClaims.UserId, r.URL.Query().Get("chatId"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List scheduled messages error", "error", err)
+
+		writeError(w, r, err, "internal server error")
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    scheduled,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /scheduled-messages/:scheduledId - Cancel a message before it is sent
+func (h *ScheduledMessageHandler) CancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	scheduledId := chi.URLParam(r, "scheduledId")
+	if scheduledId == "" {
+		response := Response{Message: "scheduledId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	scheduled, err := h.scheduledUc.Cancel(r.Context(), scheduledId, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Cancel scheduled message error", "error", err)
+
+		writeError(w, r, err, "failed to cancel scheduled message")
+		return
+	}
+
+	response := Response{
+		Message: "scheduled message canceled",
+		Data:    scheduled,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+	"wetalk/internal/usecase"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SessionHandler struct {
+	sessionUc usecase.SessionUsecase
+}
+
+func NewSessionHandler(sessionUc usecase.SessionUsecase) *SessionHandler {
+	return &SessionHandler{
+		sessionUc: sessionUc,
+	}
+}
+
+// GET /admin/sessions?subject= - List the active sessions of a subject (super admin only)
+func (h *SessionHandler) AdminListSessions(w http.ResponseWriter, r *http.Request) {
+	subject := r.URL.Query().Get("subject")
+	if subject == "" {
+		response := Response{Message: "subject is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	sessions, err := h.sessionUc.GetBySubject(r.Context(), subject)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "List sessions error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    sessions,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /admin/sessions/:sessionId - Invalidate a single session (super admin only)
+func (h *SessionHandler) AdminInvalidateSession(w http.ResponseWriter, r *http.Request) {
+	sessionId := chi.URLParam(r, "sessionId")
+	if sessionId == "" {
+		response := Response{Message: "sessionId is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.sessionUc.Invalidate(r.Context(), sessionId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Invalidate session error", "error", err)
+
+		writeError(w, r, err, "failed to invalidate session")
+		return
+	}
+
+	response := Response{Message: "session invalidated successfully"}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// DELETE /admin/sessions?subject= - Invalidate every session of a subject (super admin only)
+func (h *SessionHandler) AdminInvalidateSubject(w http.ResponseWriter, r *http.Request) {
+	subject := r.URL.Query().Get("subject")
+	if subject == "" {
+		response := Response{Message: "subject is required"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	err := h.sessionUc.InvalidateSubject(r.Context(), subject)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Invalidate subject sessions error", "error", err)
+		response := Response{Message: "failed to invalidate sessions"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{Message: "sessions invalidated successfully"}
+	writeJSON(w, r, http.StatusOK, response)
+}
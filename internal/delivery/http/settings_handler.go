@@ -0,0 +1,76 @@
+package http
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+)
+
+type SettingsHandler struct {
+	settingsUc usecase.SettingsUsecase
+}
+
+func NewSettingsHandler(settingsUc usecase.SettingsUsecase) *SettingsHandler {
+	return &SettingsHandler{
+		settingsUc: settingsUc,
+	}
+}
+
+// GET /workspace/settings - Get the settings of the authenticated user's workspace
+func (h *SettingsHandler) GetWorkspaceSettings(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	settings, err := h.settingsUc.GetUserWorkspaceSettings(r.Context(), userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Get workspace settings error", "error", err)
+		response := Response{Message: "internal server error"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+
+	response := Response{
+		Message: "success",
+		Data:    settings,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
+
+// PUT /workspace/settings - Replace the moderation, attachment and retention settings (workspace admin only)
+func (h *SettingsHandler) UpdateWorkspaceSettings(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	var req entity.UpdateWorkspaceSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response := Response{Message: "invalid request body"}
+		writeJSON(w, r, http.StatusBadRequest, response)
+		return
+	}
+
+	settings, err := h.settingsUc.UpdateUserWorkspaceSettings(r.Context(), userClaims.UserId, req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Update workspace settings error", "error", err)
+
+		writeError(w, r, err, "failed to update workspace settings")
+		return
+	}
+
+	response := Response{
+		Message: "workspace settings updated successfully",
+		Data:    settings,
+	}
+	writeJSON(w, r, http.StatusOK, response)
+}
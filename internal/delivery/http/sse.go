@@ -0,0 +1,167 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wetalk/infrastructure/ws"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+)
+
+const (
+	// sseHeartbeatInterval keeps proxies from closing an idle stream
+	sseHeartbeatInterval = 15 * time.Second
+	// sseResumeWindow is how long a dropped stream keeps buffering events for a reconnection.
+	// Streams live in memory, so resuming requires reconnecting to the same server
+	sseResumeWindow = 30 * time.Second
+	// sseRetry is the reconnection delay suggested to EventSource, in milliseconds
+	sseRetry = 3000
+)
+
+// SSEHandler delivers the same events as the websocket as server-sent events, for
+// clients behind proxies that don't allow websockets
+type SSEHandler struct {
+	hub       ws.IHub
+	userUc    usecase.UserUsecase
+	metricsUc usecase.MetricsUsecase
+
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+func NewSSEHandler(hub ws.IHub, userUc usecase.UserUsecase, metricsUc usecase.MetricsUsecase) *SSEHandler {
+	return &SSEHandler{
+		hub:       hub,
+		userUc:    userUc,
+		metricsUc: metricsUc,
+		streams:   make(map[string]*stream),
+	}
+}
+
+// Run unregisters the streams whose consumer did not come back within the resume window
+func (h *SSEHandler) Run() {
+	ticker := time.NewTicker(sseResumeWindow / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.mu.Lock()
+		for id, s := range h.streams {
+			if s.expired(sseResumeWindow) {
+				delete(h.streams, id)
+				h.metricsUc.ConnectionClosed(s.workspaceId)
+				go h.hub.UnregisterClient(s.client)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// GET /sse - Stream real-time events, resumes from the Last-Event-ID header after a reconnection
+func (h *SSEHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	// Get user from context
+	userClaims, ok := r.Context().Value(UserContextKey).(*entity.TokenClaims)
+	if !ok {
+		response := Response{Message: "unauthorized"}
+		writeJSON(w, r, http.StatusUnauthorized, response)
+		return
+	}
+
+	s, lastSeq, err := h.openStream(r, userClaims.UserId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Open event stream error", "error", err)
+		response := Response{Message: "failed to open event stream"}
+		writeJSON(w, r, http.StatusInternalServerError, response)
+		return
+	}
+	defer s.detach()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		events, gap, closed := s.eventsAfter(lastSeq)
+		if gap {
+			// Some events are gone, the client has to refetch its state
+			fmt.Fprintf(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, e := range events {
+			fmt.Fprintf(w, "id: %s:%d\ndata: %s\n\n", s.id, e.seq, e.data)
+			lastSeq = e.seq
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		if closed {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.notify:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+	}
+}
+
+// openStream resumes the stream named by Last-Event-ID, or registers a new one with the hub
+func (h *SSEHandler) openStream(r *http.Request, userId string) (*stream, int64, error) {
+	if streamId, seq, ok := parseLastEventId(r.Header.Get("Last-Event-ID")); ok {
+		h.mu.Lock()
+		s, exists := h.streams[streamId]
+		h.mu.Unlock()
+
+		if exists && s.userId == userId && s.attach() {
+			return s, seq, nil
+		}
+	}
+
+	user, err := h.userUc.Get(r.Context(), userId)
+	if err != nil {
+		return nil, 0, err
+	}
+	user.IsOnline = true
+	if err := h.userUc.Update(r.Context(), user); err != nil {
+		return nil, 0, err
+	}
+
+	s := newStream(userId, user.GetWorkspaceId(), h.hub)
+	s.attach()
+	h.hub.RegisterClient(s.client)
+	h.metricsUc.ConnectionOpened(s.workspaceId)
+	go s.pump()
+
+	h.mu.Lock()
+	h.streams[s.id] = s
+	h.mu.Unlock()
+
+	return s, 0, nil
+}
+
+// parseLastEventId splits an event id formatted as "<streamId>:<seq>"
+func parseLastEventId(lastEventId string) (string, int64, bool) {
+	streamId, rawSeq, found := strings.Cut(lastEventId, ":")
+	if !found || streamId == "" {
+		return "", 0, false
+	}
+
+	seq, err := strconv.ParseInt(rawSeq, 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return streamId, seq, true
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"sync"
+	"time"
+
+	"wetalk/infrastructure/ws"
+
+	"github.com/google/uuid"
+)
+
+// historySize is how many events a stream keeps to replay after a reconnection
+const historySize = 256
+
+type event struct {
+	seq  int64
+	data []byte
+}
+
+// stream is a hub client kept alive across the reconnections of one SSE consumer.
+// It buffers the outgoing events so a reconnecting consumer can resume where it stopped
+type stream struct {
+	id          string
+	userId      string
+	workspaceId string
+	client      *ws.UserClient
+
+	mu         sync.Mutex
+	seq        int64
+	history    []event
+	attached   bool
+	detachedAt time.Time
+	closed     bool
+
+	// notify is signaled when an event is buffered or the stream is closed
+	notify chan struct{}
+}
+
+func newStream(userId string, workspaceId string, hub ws.IHub) *stream {
+	return &stream{
+		id:          uuid.New().String(),
+		userId:      userId,
+		workspaceId: workspaceId,
+		client:      ws.NewStreamClient(userId, hub),
+		notify:      make(chan struct{}, 1),
+	}
+}
+
+// pump buffers the messages sent by the hub until it unregisters the client
+func (s *stream) pump() {
+	for {
+		message, ok := s.client.Receive()
+		if !ok {
+			break
+		}
+
+		s.mu.Lock()
+		s.seq++
+		s.history = append(s.history, event{seq: s.seq, data: message})
+		if len(s.history) > historySize {
+			s.history = s.history[len(s.history)-historySize:]
+		}
+		s.mu.Unlock()
+
+		s.signal()
+	}
+
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.signal()
+}
+
+func (s *stream) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// eventsAfter returns the buffered events newer than seq. gap is true when some of
+// them were already dropped from the history and the consumer missed events
+func (s *stream) eventsAfter(seq int64) (events []event, gap bool, closed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.history) > 0 && s.history[0].seq > seq+1 {
+		gap = true
+	}
+	for _, e := range s.history {
+		if e.seq > seq {
+			events = append(events, e)
+		}
+	}
+	return events, gap, s.closed
+}
+
+// attach marks the stream as consumed, only one consumer may read a stream at a time
+func (s *stream) attach() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.attached || s.closed {
+		return false
+	}
+	s.attached = true
+	return true
+}
+
+func (s *stream) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attached = false
+	s.detachedAt = time.Now()
+}
+
+// expired reports whether the consumer has been gone for longer than the resume window
+func (s *stream) expired(resumeWindow time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed || (!s.attached && time.Since(s.detachedAt) > resumeWindow)
+}
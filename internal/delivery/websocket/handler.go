@@ -3,16 +3,28 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
-	"sync"
-
+	"time"
 	"wetalk/infrastructure/ws"
 	"wetalk/internal/entity"
 	"wetalk/internal/usecase"
+	"wetalk/pkg/chaos"
+	"wetalk/pkg/logger"
+	"wetalk/pkg/tracing"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// messageTimeout bounds the work done for a single incoming frame
+	messageTimeout = 10 * time.Second
+	// unregisterTimeout bounds the cleanup done after a connection closes
+	unregisterTimeout = 5 * time.Second
 )
 
 var upgrader = websocket.Upgrader{
@@ -24,18 +36,47 @@ var upgrader = websocket.Upgrader{
 }
 
 type WebsocketHandler struct {
-	hub       ws.IHub
-	userUc    usecase.UserUsecase
-	messageUc usecase.MessageUsecase
-	chatUc    usecase.ChatUsecase
+	hub            ws.IHub
+	userUc         usecase.UserUsecase
+	messageUc      usecase.MessageUsecase
+	chatUc         usecase.ChatUsecase
+	focusUc        usecase.FocusUsecase
+	notificationUc usecase.NotificationUsecase
+	metricsUc      usecase.MetricsUsecase
+	replayUc       usecase.ReplayUsecase
+	ephemeralUc    usecase.EphemeralUsecase
+	scheduledUc    usecase.ScheduledMessageUsecase
+	roomUc         usecase.RoomUsecase
+	heartbeat      ws.Heartbeat
+	slowClient     ws.SlowClientPolicy
+	// envelopeV2MinAppVersion is the first app version getting chat messages in the v2 envelope,
+	// none when empty
+	envelopeV2MinAppVersion string
+	// faults drops connections at random in resilience tests, nil otherwise
+	faults *chaos.Injector
+	// tracer records a span per incoming frame, a no-op one when tracing is disabled
+	tracer trace.Tracer
 }
 
-func NewWebsocketHandler(hub ws.IHub, userUc usecase.UserUsecase, messageUc usecase.MessageUsecase, chatUc usecase.ChatUsecase) *WebsocketHandler {
+func NewWebsocketHandler(hub ws.IHub, userUc usecase.UserUsecase, messageUc usecase.MessageUsecase, chatUc usecase.ChatUsecase, focusUc usecase.FocusUsecase, notificationUc usecase.NotificationUsecase, metricsUc usecase.MetricsUsecase, replayUc usecase.ReplayUsecase, ephemeralUc usecase.EphemeralUsecase, scheduledUc usecase.ScheduledMessageUsecase, roomUc usecase.RoomUsecase, heartbeat ws.Heartbeat, slowClient ws.SlowClientPolicy, envelopeV2MinAppVersion string, faults *chaos.Injector, tracer trace.Tracer) *WebsocketHandler {
 	return &WebsocketHandler{
-		hub:       hub,
-		userUc:    userUc,
-		messageUc: messageUc,
-		chatUc:    chatUc,
+		hub:            hub,
+		userUc:         userUc,
+		messageUc:      messageUc,
+		chatUc:         chatUc,
+		focusUc:        focusUc,
+		notificationUc: notificationUc,
+		metricsUc:      metricsUc,
+		replayUc:       replayUc,
+		ephemeralUc:    ephemeralUc,
+		scheduledUc:    scheduledUc,
+		roomUc:         roomUc,
+		heartbeat:      heartbeat,
+		slowClient:     slowClient,
+
+		envelopeV2MinAppVersion: envelopeV2MinAppVersion,
+		faults:                  faults,
+		tracer:                  tracer,
 	}
 }
 
@@ -50,38 +91,97 @@ func (h *WebsocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 
 	user, err := h.userUc.Get(ctx, userId)
 	if err != nil {
-		log.Printf("Get user error: %v", err)
+		slog.ErrorContext(ctx, "Get user error", "error", err)
 		return
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Upgrade error: %v", err)
+		slog.ErrorContext(ctx, "Upgrade error", "error", err)
 		return
 	}
 
 	user.IsOnline = true
 	err = h.userUc.Update(ctx, user)
 	if err != nil {
-		log.Printf("Update user error: %v", err)
+		slog.ErrorContext(ctx, "Update user error", "error", err)
 		return
 	}
 
-	client := ws.NewClient(user.Id, h.hub, conn)
+	// The connection outlives the upgrade request, so keep its values but
+	// tie cancellation to the lifetime of the connection instead
+	connCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+
+	client := ws.NewClient(user.Id, h.hub, conn, h.heartbeat, h.slowClient)
+	client.Client = clientInfo(r)
+	client.Envelope = h.envelope(client.Client)
+	connCtx = logger.With(connCtx, slog.String("user_id", client.UserId), slog.String("connection_id", client.ConnectionId))
+	// Without its rooms the connection only misses chat messages until a chat is opened
+	client.Rooms, err = h.roomUc.Rooms(connCtx, client.UserId)
+	if err != nil {
+		slog.ErrorContext(connCtx, "Get rooms error", "error", err)
+	}
+	slog.InfoContext(connCtx, "Websocket connected", "platform", client.Client.Platform, "app_version", client.Client.AppVersion, "envelope", client.Envelope)
 	h.hub.RegisterClient(client)
+	h.metricsUc.ConnectionOpened(user.GetWorkspaceId())
+	defer h.metricsUc.ConnectionClosed(user.GetWorkspaceId())
 
 	go client.WritePump()
+	go h.faults.DisconnectRandomly(connCtx, func() { conn.Close() })
 	client.ReadPump(func(data []byte) {
-		h.handleMessage(ctx, client, data)
+		msgCtx, cancelMsg := context.WithTimeout(connCtx, messageTimeout)
+		defer cancelMsg()
+
+		msgCtx, span := h.tracer.Start(msgCtx, "ws.message", trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		span.SetAttributes(
+			attribute.String("wetalk.user_id", client.UserId),
+			attribute.String("wetalk.connection_id", client.ConnectionId),
+		)
+		if client.Client.AppVersion != "" {
+			span.SetAttributes(attribute.String("wetalk.app_version", client.Client.AppVersion))
+		}
+
+		h.handleMessage(msgCtx, client, data)
 	})
+
+	// The connection is gone, it no longer views any chat
+	blurCtx, cancelBlur := context.WithTimeout(connCtx, unregisterTimeout)
+	defer cancelBlur()
+	if err := h.focusUc.Blur(blurCtx, client.UserId, client.ConnectionId); err != nil {
+		slog.ErrorContext(blurCtx, "Blur on disconnect error", "error", err)
+	}
+}
+
+// clientInfo reads the app the client reports in the query of the connect request, such as
+// ?platform=ios&appVersion=2.4.1&label=Work%20phone
+func clientInfo(r *http.Request) entity.ClientInfo {
+	query := r.URL.Query()
+	return entity.ClientInfo{
+		Platform:   query.Get("platform"),
+		AppVersion: query.Get("appVersion"),
+		Label:      query.Get("label"),
+	}.Sanitized()
+}
+
+// envelope picks how chat messages are framed for the app, the apps older than the configured
+// version and the ones not reporting their version keep the v1 envelope
+func (h *WebsocketHandler) envelope(client entity.ClientInfo) int {
+	if h.envelopeV2MinAppVersion == "" || !client.AppVersionAtLeast(h.envelopeV2MinAppVersion) {
+		return ws.EnvelopeV1
+	}
+	return ws.EnvelopeV2
 }
 
 func (h *WebsocketHandler) HandleUnregisterClient(client *ws.UserClient) {
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), unregisterTimeout)
+	defer cancel()
+	ctx = logger.With(ctx, slog.String("user_id", client.UserId), slog.String("connection_id", client.ConnectionId))
 
 	user, err := h.userUc.Get(ctx, client.UserId)
 	if err != nil {
-		log.Printf("Get user error: %v", err)
+		slog.ErrorContext(ctx, "Get user error", "error", err)
 		return
 	}
 
@@ -89,15 +189,28 @@ func (h *WebsocketHandler) HandleUnregisterClient(client *ws.UserClient) {
 
 	err = h.userUc.Update(ctx, user)
 	if err != nil {
-		log.Printf("HandleUnregisterClient error: %v", err)
+		slog.ErrorContext(ctx, "HandleUnregisterClient error", "error", err)
 		return
 	}
 }
 
 func (h *WebsocketHandler) handleMessage(ctx context.Context, client *ws.UserClient, data []byte) {
-	// Try to parse as read acknowledgment first
+	// Typed frames first
+	var frame ClientFrame
+	if err := json.Unmarshal(data, &frame); err == nil && frame.Type != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("wetalk.frame", frame.Type))
+		h.handleFrame(ctx, client, frame)
+		return
+	}
+
+	// Try to parse as read acknowledgment
 	var readAck MessageReadAck
 	if err := json.Unmarshal(data, &readAck); err == nil && readAck.MessageId != "" {
+		// Ephemeral messages are never stored, there is nothing to mark
+		if entity.IsEphemeralId(readAck.MessageId) {
+			return
+		}
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("wetalk.frame", "read_ack"))
 		h.handleReadAcknowledgment(ctx, client, readAck)
 		return
 	}
@@ -106,108 +219,221 @@ func (h *WebsocketHandler) handleMessage(ctx context.Context, client *ws.UserCli
 	var message IncomingMessage
 	err := json.Unmarshal(data, &message)
 	if err != nil {
-		log.Printf("Unknown message: %v", err)
+		slog.WarnContext(ctx, "Unknown message", "error", err)
+		h.ephemeralUc.Send(ctx, client.UserId, entity.EphemeralMessage{
+			Kind:    entity.EphemeralKindError,
+			Message: "the message could not be read",
+		})
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("wetalk.frame", "message"))
+
+	// Check participation before saving, the members of a channel only read it. Only the
+	// participants of a chat that still exists may send to it
+	if err := h.chatUc.CanPost(ctx, message.ChatId, client.UserId); err != nil {
+		if !usecase.IsAny(err, usecase.ErrChatNotFound, usecase.ErrNotParticipant, usecase.ErrChannelReadOnly) {
+			slog.ErrorContext(ctx, "Check chat participation error", "chat_id", message.ChatId, "error", err)
+			return
+		}
+		slog.WarnContext(ctx, "Message to a chat the sender can't post to", "chat_id", message.ChatId, "error", err)
+		h.sendEvent(ctx, client, entity.EventMessageRejected, newMessageRejected(message, err))
 		return
 	}
 
-	// Get chat details - pass userId to check participation
-	chatDetail, err := h.chatUc.Get(ctx, message.ChatId, client.UserId)
-	if err != nil {
-		log.Printf("Get chat error: %v", err)
+	// Save message to database
+	messageEntity := entity.Message{
+		ChatId:      message.ChatId,
+		SenderId:    client.UserId,
+		Message:     message.Message,
+		Timestamp:   message.Timestamp,
+		IsRead:      false,
+		Attachments: message.Attachments,
+
+		ReplyToMessageId: message.ReplyToMessageId,
+		Mentions:         message.Mentions,
+		ClientMessageId:  message.ClientMessageId,
+	}
+
+	// A message sent later is only checked when it is due, see ScheduledMessageUsecase
+	if message.ScheduledAt != nil {
+		h.scheduleMessage(ctx, client, message, messageEntity)
 		return
 	}
 
-	sender, err := h.userUc.Get(ctx, client.UserId)
+	savedMessage, err := h.messageUc.SaveMessage(ctx, messageEntity)
+	if errors.Is(err, usecase.ErrCommandHandled) {
+		// The command answered its issuer, there is nothing to fan out
+		return
+	}
 	if err != nil {
-		log.Printf("Get sender user error: %v", err)
+		slog.ErrorContext(ctx, "Save message error", "chat_id", message.ChatId, "error", err)
+		tracing.RecordError(trace.SpanFromContext(ctx), err)
+
+		var validationErr *usecase.ValidationError
+		if errors.As(err, &validationErr) || usecase.IsAny(err, usecase.ErrMessageRejected, usecase.ErrAttachmentTooLarge, usecase.ErrAttachmentTypeRejected, usecase.ErrAttachmentSizeLimit, usecase.ErrInvalidReply,
+			usecase.ErrAccountSuspended, usecase.ErrAccountMuted, usecase.ErrSendRateLimited, usecase.ErrModerationUnavailable, usecase.ErrChannelReadOnly) {
+			h.sendEvent(ctx, client, entity.EventMessageRejected, newMessageRejected(message, err))
+		}
 		return
 	}
 
-	// Save message to database
-	messageEntity := entity.Message{
-		ChatId:    message.ChatId,
-		SenderId:  client.UserId,
-		Message:   message.Message,
-		Timestamp: message.Timestamp,
-		IsRead:    false,
+	// The recipients get it from the outbox dispatcher, the sending connection gets the ack. A
+	// retried message is acked again with the message the first try stored
+	h.replyEvent(ctx, client, entity.EventMessageAck, MessageAck{
+		ChatId:          savedMessage.ChatId,
+		ClientMessageId: savedMessage.ClientMessageId,
+		MessageId:       savedMessage.Id,
+		Timestamp:       savedMessage.Timestamp,
+		Seq:             savedMessage.Seq,
+		SentAt:          entity.FormatTimestamp(savedMessage.Timestamp),
+		DeliveryState:   savedMessage.DeliveryState,
+	})
+	slog.DebugContext(ctx, "Message saved", "message_id", savedMessage.Id)
+}
+
+// newMessageRejected dates the rejection with the clock of the server, like the messages it stores
+func newMessageRejected(message IncomingMessage, reason error) MessageRejected {
+	now := time.Now().UnixMilli()
+	return MessageRejected{
+		ChatId:          message.ChatId,
+		ClientMessageId: message.ClientMessageId,
+		Timestamp:       now,
+		SentAt:          entity.FormatTimestamp(now),
+		Reason:          reason.Error(),
 	}
-	messageId, err := h.messageUc.SaveMessage(ctx, messageEntity)
+}
+
+func (h *WebsocketHandler) scheduleMessage(ctx context.Context, client *ws.UserClient, message IncomingMessage, messageEntity entity.Message) {
+	scheduled, err := h.scheduledUc.Schedule(ctx, messageEntity, *message.ScheduledAt)
 	if err != nil {
-		log.Printf("Save message error: %v", err)
+		slog.ErrorContext(ctx, "Schedule message error", "chat_id", message.ChatId, "error", err)
+
+		var validationErr *usecase.ValidationError
+		if errors.As(err, &validationErr) || usecase.IsAny(err, usecase.ErrScheduledMessageLimit, usecase.ErrNotParticipant) {
+			h.sendEvent(ctx, client, entity.EventMessageRejected, newMessageRejected(message, err))
+		}
 		return
 	}
 
-	// Get participants - now returns []entity.User
-	participants, err := h.chatUc.GetParticipants(ctx, chatDetail.Chat.Id, client.UserId)
+	h.sendEvent(ctx, client, entity.EventMessageScheduled, scheduled)
+}
+
+func (h *WebsocketHandler) handleReadAcknowledgment(ctx context.Context, client *ws.UserClient, readAck MessageReadAck) {
+	err := h.messageUc.MarkAsRead(ctx, readAck.MessageId, client.UserId)
 	if err != nil {
-		log.Printf("GetParticipants error: %v", err)
+		slog.ErrorContext(ctx, "Mark message as read error", "message_id", readAck.MessageId, "error", err)
 		return
 	}
 
-	if len(participants) == 0 {
-		log.Printf("No participants in chat: %s", chatDetail.Chat.Id)
-		h.chatUc.Delete(ctx, chatDetail.Chat.Id, client.UserId)
-		return
+	// A read message was obviously delivered, don't push it
+	if err := h.notificationUc.AckDelivery(ctx, client.UserId, readAck.MessageId); err != nil {
+		slog.ErrorContext(ctx, "Ack delivery error", "error", err)
+	}
+
+	slog.DebugContext(ctx, "Message marked as read", "message_id", readAck.MessageId)
+}
+
+func (h *WebsocketHandler) handleFrame(ctx context.Context, client *ws.UserClient, frame ClientFrame) {
+	switch frame.Type {
+	case FrameChatFocus:
+		if err := h.focusUc.Focus(ctx, client.UserId, client.ConnectionId, frame.ChatId); err != nil {
+			slog.ErrorContext(ctx, "Chat focus error", "error", err)
+			return
+		}
+		// The user takes part in the chat, a room the connection missed is joined now
+		h.hub.JoinRoom(ctx, frame.ChatId, client.UserId)
+	case FrameChatBlur:
+		if err := h.focusUc.Blur(ctx, client.UserId, client.ConnectionId); err != nil {
+			slog.ErrorContext(ctx, "Chat blur error", "error", err)
+		}
+	case FrameMessageDelivered:
+		if entity.IsEphemeralId(frame.MessageId) {
+			return
+		}
+		if err := h.messageUc.MarkDelivered(ctx, frame.MessageId, client.UserId); err != nil {
+			slog.ErrorContext(ctx, "Mark message as delivered error", "error", err)
+		}
+		if err := h.notificationUc.AckDelivery(ctx, client.UserId, frame.MessageId); err != nil {
+			slog.ErrorContext(ctx, "Ack delivery error", "error", err)
+		}
+	case FrameChatReplay:
+		messages, err := h.replayUc.GetRecent(ctx, frame.ChatId, client.UserId, frame.Limit)
+		if err != nil {
+			slog.ErrorContext(ctx, "Chat replay error", "error", err)
+			return
+		}
+		h.sendEvent(ctx, client, entity.EventChatReplay, entity.ChatReplay{
+			ChatId:   frame.ChatId,
+			Messages: messages,
+		})
+	case FrameReadChat:
+		_, err := h.messageUc.MarkChatAsRead(ctx, frame.ChatId, client.UserId, entity.MarkChatReadRequest{
+			MessageId: frame.MessageId,
+			UpTo:      frame.UpTo,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Read chat error", "chat_id", frame.ChatId, "error", err)
+		}
+	case FrameEventsUnsubscribe, FrameEventsSubscribe:
+		h.updateSubscriptions(ctx, client, frame)
+	default:
+		slog.WarnContext(ctx, "Unknown frame type", "type", frame.Type)
+		h.ephemeralUc.Send(ctx, client.UserId, entity.EphemeralMessage{
+			Kind:    entity.EphemeralKindError,
+			Message: "unknown frame type " + frame.Type,
+		})
+	}
+}
+
+// updateSubscriptions applies an events.subscribe or events.unsubscribe frame to the connection,
+// the frame is refused as a whole when one of its classes is unknown
+func (h *WebsocketHandler) updateSubscriptions(ctx context.Context, client *ws.UserClient, frame ClientFrame) {
+	var eventTypes []string
+	for _, class := range frame.Classes {
+		classTypes, ok := entity.EventClasses[class]
+		if !ok {
+			h.ephemeralUc.Send(ctx, client.UserId, entity.EphemeralMessage{
+				Kind:    entity.EphemeralKindError,
+				Message: "unknown event class " + class,
+			})
+			return
+		}
+		eventTypes = append(eventTypes, classTypes...)
 	}
 
-	userIds := make([]string, 0, len(participants))
-	for _, participant := range participants {
-		userIds = append(userIds, participant.Id)
+	if frame.Type == FrameEventsUnsubscribe {
+		client.Unsubscribe(eventTypes...)
+	} else {
+		client.Subscribe(eventTypes...)
 	}
+	slog.DebugContext(ctx, "Event subscriptions updated", "frame", frame.Type, "classes", frame.Classes)
+}
 
-	onlineUsers, err := h.userUc.GetOnlineUser(ctx, userIds)
+// replyEvent sends the event to the connection only, not to the other devices of the user
+func (h *WebsocketHandler) replyEvent(ctx context.Context, client *ws.UserClient, eventType string, data any) {
+	eventBytes, err := json.Marshal(entity.Event{
+		Type: eventType,
+		Data: data,
+	})
 	if err != nil {
-		log.Printf("GetOnlineUser error: %v", err)
+		slog.ErrorContext(ctx, "Marshal event error", "event", eventType, "error", err)
 		return
 	}
 
-	userMap := make(map[string]bool)
-	for _, user := range onlineUsers {
-		userMap[user.Id] = true
+	if !client.Send(eventBytes) {
+		slog.WarnContext(ctx, "Failed to reply to client", "event", eventType, "connection_id", client.ConnectionId)
 	}
-
-	var wg sync.WaitGroup
-
-	for _, participant := range participants {
-		if participant.Id == client.UserId {
-			continue
-		}
-		wg.Add(1)
-		go func(userId string) {
-			defer wg.Done()
-			if _, exists := userMap[userId]; !exists {
-				return
-			}
-
-			outgoingMsg := OutgoingMessage{
-				ChatId:    message.ChatId,
-				MessageId: messageId,
-				UserId:    client.UserId,
-				UserName:  sender.Name,
-				Message:   message.Message,
-				Timestamp: message.Timestamp,
-				IsRead:    false,
-			}
-			messageBytes, err := json.Marshal(outgoingMsg)
-			if err != nil {
-				log.Printf("Marshal message error: %v", err)
-				return
-			}
-
-			h.hub.SendToClient(userId, messageBytes)
-
-		}(participant.Id)
-	}
-
-	wg.Wait()
 }
 
-func (h *WebsocketHandler) handleReadAcknowledgment(ctx context.Context, client *ws.UserClient, readAck MessageReadAck) {
-	err := h.messageUc.MarkAsRead(ctx, readAck.MessageId)
+func (h *WebsocketHandler) sendEvent(ctx context.Context, client *ws.UserClient, eventType string, data any) {
+	eventBytes, err := json.Marshal(entity.Event{
+		Type: eventType,
+		Data: data,
+	})
 	if err != nil {
-		log.Printf("Mark message as read error: %v", err)
+		slog.ErrorContext(ctx, "Marshal event error", "event", eventType, "error", err)
 		return
 	}
 
-	log.Printf("Message %s marked as read by user %s", readAck.MessageId, client.UserId)
+	h.hub.SendToClient(ctx, client.UserId, eventBytes)
 }
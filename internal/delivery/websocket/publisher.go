@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"wetalk/infrastructure/ws"
+	"wetalk/internal/entity"
+	"wetalk/internal/usecase"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type eventPublisher struct {
+	hub ws.IHub
+}
+
+// NewEventPublisher returns a usecase.EventPublisher that delivers events through the hub
+func NewEventPublisher(hub ws.IHub) usecase.EventPublisher {
+	return &eventPublisher{
+		hub: hub,
+	}
+}
+
+func (p *eventPublisher) PublishToUsers(ctx context.Context, userIds []string, eventType string, data any) {
+	eventBytes, err := json.Marshal(entity.Event{
+		Type: eventType,
+		Data: data,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Marshal event error", "event", eventType, "error", err)
+		return
+	}
+
+	p.hub.SendToUsers(ctx, userIds, eventBytes)
+}
+
+type messageDeliverer struct {
+	hub       ws.IHub
+	messageUc usecase.MessageUsecase
+	tracer    trace.Tracer
+}
+
+// NewMessageDeliverer returns a usecase.MessageDeliverer that sends new messages to the room of
+// their chat, every server holding members of the room acks the members it delivered them to
+func NewMessageDeliverer(hub ws.IHub, messageUc usecase.MessageUsecase, tracer trace.Tracer) usecase.MessageDeliverer {
+	d := &messageDeliverer{
+		hub:       hub,
+		messageUc: messageUc,
+		tracer:    tracer,
+	}
+	hub.SetOnRoomDelivered(d.ackDelivered)
+	return d
+}
+
+// DeliverMessage encodes the message once and sends it to the room of its chat, the recipients
+// are the members of the room but the sender
+func (d *messageDeliverer) DeliverMessage(ctx context.Context, recipientIds []string, fanout entity.MessageFanout) {
+	message := fanout.Message
+
+	ctx, span := d.tracer.Start(ctx, "ws.fanout", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("wetalk.message_id", message.Id),
+		attribute.Int("wetalk.recipients", len(recipientIds)),
+	)
+
+	messageBytes, err := json.Marshal(OutgoingMessage{
+		ChatId:      message.ChatId,
+		MessageId:   message.Id,
+		UserId:      message.SenderId,
+		UserName:    fanout.SenderName,
+		Message:     message.Message,
+		Timestamp:   message.Timestamp,
+		Seq:         message.Seq,
+		SentAt:      entity.FormatTimestamp(message.Timestamp),
+		IsRead:      false,
+		Attachments: message.Attachments,
+
+		ReplyToMessageId: message.ReplyToMessageId,
+		ReplyTo:          message.ReplyTo,
+
+		Mentions: message.Mentions,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Marshal message error", "error", err)
+		return
+	}
+
+	// Channel posts have no receipts to ack
+	d.hub.SendToRoom(ctx, message.ChatId, messageBytes, ws.RoomSend{
+		ExceptUserID: message.SenderId,
+		Track:        !fanout.Broadcast,
+	})
+}
+
+// ackDelivered acks to the sender the members of the room a server handed the message to, on the
+// server that sent it or on any other one holding members of the room
+func (d *messageDeliverer) ackDelivered(ctx context.Context, roomId string, userIds []string, payload []byte) {
+	var message OutgoingMessage
+	if err := json.Unmarshal(payload, &message); err != nil || message.MessageId == "" {
+		slog.ErrorContext(ctx, "Unmarshal delivered message error", "chat_id", roomId, "error", err)
+		return
+	}
+
+	for _, recipientId := range userIds {
+		if err := d.messageUc.MarkDelivered(ctx, message.MessageId, recipientId); err != nil {
+			slog.ErrorContext(ctx, "Mark message as delivered error", "message_id", message.MessageId, "recipient_id", recipientId, "error", err)
+		}
+	}
+}
@@ -1,12 +1,54 @@
 package websocket
 
+import (
+	"time"
+	"wetalk/internal/entity"
+)
+
 type IncomingMessage struct {
-	Message   string `json:"message"`
-	ChatId    string `json:"chatId"`
-	Timestamp int64  `json:"timestamp"`
+	Message     string              `json:"message"`
+	ChatId      string              `json:"chatId"`
+	Timestamp   int64               `json:"timestamp"`
+	Attachments []entity.Attachment `json:"attachments,omitempty"`
+	// ClientMessageId is an idempotency key chosen by the client, a message retried with the
+	// same key is stored once
+	ClientMessageId string `json:"clientMessageId,omitempty"`
+
+	ReplyToMessageId string `json:"replyToMessageId,omitempty"`
+	// Mentions are the user IDs of the participants the message mentions
+	Mentions []string `json:"mentions,omitempty"`
+	// ScheduledAt sends the message later instead, the sender gets a message_scheduled event
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"`
 }
 
 type MessageReadAck struct {
 	MessageId string `json:"messageId"`
 	ChatId    string `json:"chatId"`
 }
+
+// Typed frames sent by clients, anything without a type is a message or a read acknowledgment
+const (
+	FrameChatFocus = "chat.focus"
+	FrameChatBlur  = "chat.blur"
+	// FrameMessageDelivered acks that the message reached this device, so it is not pushed
+	FrameMessageDelivered = "message.delivered"
+	// FrameChatReplay asks for the most recent messages of a chat, answered with a chat_replay event
+	FrameChatReplay = "chat.replay"
+	// FrameReadChat reads the chat up to MessageId or UpTo at once, everything without either
+	FrameReadChat = "read_chat"
+	// FrameEventsUnsubscribe stops the events of Classes from being sent to this connection,
+	// FrameEventsSubscribe sends them again. See entity.EventClasses
+	FrameEventsUnsubscribe = "events.unsubscribe"
+	FrameEventsSubscribe   = "events.subscribe"
+)
+
+type ClientFrame struct {
+	Type      string `json:"type"`
+	ChatId    string `json:"chatId,omitempty"`
+	MessageId string `json:"messageId,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	// UpTo is the read horizon of read_chat frames, in unix milliseconds
+	UpTo int64 `json:"upTo,omitempty"`
+	// Classes are the event classes of events.subscribe and events.unsubscribe frames
+	Classes []string `json:"classes,omitempty"`
+}
@@ -1,11 +1,46 @@
 package websocket
 
+import "wetalk/internal/entity"
+
 type OutgoingMessage struct {
 	MessageId string `json:"messageId"`
 	UserId    string `json:"userId"`
 	UserName  string `json:"userName"`
 	Message   string `json:"message"`
 	Timestamp int64  `json:"timestamp"`
+	Seq       int64  `json:"seq,omitempty"`
 	IsRead    bool   `json:"isRead"`
 	ChatId    string `json:"chatId"`
+	// SentAt is Timestamp as ISO-8601 in UTC
+	SentAt string `json:"sentAt"`
+
+	Attachments []entity.Attachment `json:"attachments,omitempty"`
+
+	ReplyToMessageId string                `json:"replyToMessageId,omitempty"`
+	ReplyTo          *entity.QuotedMessage `json:"replyTo,omitempty"`
+
+	Mentions []string `json:"mentions,omitempty"`
+}
+
+// MessageAck is the payload of the message_ack event, the client matches it to the message it
+// sent by ClientMessageId and keeps MessageId, Timestamp and Seq from then on
+type MessageAck struct {
+	ChatId          string `json:"chatId"`
+	ClientMessageId string `json:"clientMessageId,omitempty"`
+	MessageId       string `json:"messageId"`
+	Timestamp       int64  `json:"timestamp"`
+	Seq             int64  `json:"seq,omitempty"`
+	SentAt          string `json:"sentAt"`
+	DeliveryState   string `json:"deliveryState,omitempty"`
+}
+
+// MessageRejected tells the sender that a message was refused, by the workspace policy or because
+// the chat is gone or the sender no longer takes part in it. The client matches it to the message
+// it sent by ClientMessageId, Timestamp is when the server refused it
+type MessageRejected struct {
+	ChatId          string `json:"chatId"`
+	ClientMessageId string `json:"clientMessageId,omitempty"`
+	Timestamp       int64  `json:"timestamp"`
+	SentAt          string `json:"sentAt"`
+	Reason          string `json:"reason"`
 }
@@ -0,0 +1,91 @@
+package entity
+
+import "time"
+
+// Abuse signals counted by the auto-moderation rules
+const (
+	AbuseSignalReport        = "report"
+	AbuseSignalSpam          = "spam"
+	AbuseSignalModerationHit = "moderation_hit"
+)
+
+// Actions applied to an account when a rule fires, from the least to the most severe
+const (
+	AbuseActionRateLimit = "rate_limit"
+	AbuseActionMute      = "mute"
+	AbuseActionSuspend   = "suspend"
+)
+
+const (
+	ReviewStatusPending = "pending"
+	ReviewStatusUpheld  = "upheld"
+	ReviewStatusLifted  = "lifted"
+)
+
+const (
+	AppealStatusPending  = "pending"
+	AppealStatusApproved = "approved"
+	AppealStatusRejected = "rejected"
+)
+
+// AbuseSignal is a single piece of evidence against a user
+type AbuseSignal struct {
+	Id        string    `bson:"_id" json:"id"`
+	UserId    string    `bson:"userId" json:"userId"`
+	Kind      string    `bson:"kind" json:"kind"` // "report", "spam" or "moderation_hit"
+	SourceId  string    `bson:"sourceId,omitempty" json:"sourceId,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// AbuseRule applies an action once a user collects Threshold signals of a kind within Window
+type AbuseRule struct {
+	Signal    string        `json:"signal"`
+	Threshold int           `json:"threshold"`
+	Window    time.Duration `json:"window"`
+	Action    string        `json:"action"`
+	// Duration of the action, zero keeps it until an admin lifts it
+	Duration time.Duration `json:"duration"`
+}
+
+// AccountRestriction is the current automatic restriction of an account, there is at most one per user
+type AccountRestriction struct {
+	UserId       string     `bson:"_id" json:"userId"`
+	Action       string     `bson:"action" json:"action"`
+	Reason       string     `bson:"reason" json:"reason"`
+	Until        *time.Time `bson:"until,omitempty" json:"until,omitempty"`
+	ReviewStatus string     `bson:"reviewStatus" json:"reviewStatus"`
+	ReviewedBy   string     `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	Appeal       *Appeal    `bson:"appeal,omitempty" json:"appeal,omitempty"`
+	// LastMessageAt paces the messages of rate limited accounts
+	LastMessageAt *time.Time `bson:"lastMessageAt,omitempty" json:"-"`
+	CreatedAt     time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time  `bson:"updatedAt" json:"updatedAt"`
+}
+
+// IsActive reports whether the restriction still applies at the given time
+func (r AccountRestriction) IsActive(now time.Time) bool {
+	if r.ReviewStatus == ReviewStatusLifted {
+		return false
+	}
+	return r.Until == nil || now.Before(*r.Until)
+}
+
+type Appeal struct {
+	Message     string     `bson:"message" json:"message"`
+	Status      string     `bson:"status" json:"status"`
+	SubmittedAt time.Time  `bson:"submittedAt" json:"submittedAt"`
+	ReviewedAt  *time.Time `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+}
+
+type AppealRequest struct {
+	Message string `json:"message"`
+}
+
+const (
+	ReviewDecisionUphold = "uphold"
+	ReviewDecisionLift   = "lift"
+)
+
+type ReviewRestrictionRequest struct {
+	Decision string `json:"decision"` // "uphold" or "lift"
+}
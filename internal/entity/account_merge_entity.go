@@ -0,0 +1,43 @@
+package entity
+
+import "time"
+
+const (
+	AccountMergeStatusMerged     = "merged"
+	AccountMergeStatusRolledBack = "rolled_back"
+)
+
+// AccountMerge records a duplicate account folded into the primary account of the same person by a
+// super admin. What was moved is marked with the duplicate, so the merge can be rolled back as long
+// as the primary account was not merged itself
+type AccountMerge struct {
+	Id          string `bson:"_id" json:"id"`
+	PrimaryId   string `bson:"primaryId" json:"primaryId"`
+	DuplicateId string `bson:"duplicateId" json:"duplicateId"`
+	Status      string `bson:"status" json:"status"`
+	Reason      string `bson:"reason,omitempty" json:"reason,omitempty"`
+	// MovedChatIds are the chats the duplicate took part in that the primary account joined
+	MovedChatIds []string `bson:"movedChatIds" json:"movedChatIds"`
+	// ClosedChatIds are the chats both took part in, the participation of the duplicate was closed
+	ClosedChatIds []string `bson:"closedChatIds" json:"closedChatIds"`
+	MessagesMoved int64    `bson:"messagesMoved" json:"messagesMoved"`
+	ReceiptsMoved int64    `bson:"receiptsMoved" json:"receiptsMoved"`
+	// TokensMoved are the refresh tokens of the duplicate, its devices sign in as the primary account
+	TokensMoved int64 `bson:"tokensMoved" json:"tokensMoved"`
+	// DuplicateDeactivated is false when the duplicate was already deactivated, a rollback leaves it so
+	DuplicateDeactivated bool       `bson:"duplicateDeactivated" json:"duplicateDeactivated"`
+	MergedBy             string     `bson:"mergedBy" json:"mergedBy"`
+	MergedAt             time.Time  `bson:"mergedAt" json:"mergedAt"`
+	RolledBackBy         string     `bson:"rolledBackBy,omitempty" json:"rolledBackBy,omitempty"`
+	RolledBackAt         *time.Time `bson:"rolledBackAt,omitempty" json:"rolledBackAt,omitempty"`
+}
+
+type MergeAccountsRequest struct {
+	PrimaryId   string `json:"primaryId"`
+	DuplicateId string `json:"duplicateId"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type RollbackAccountMergeRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,25 @@
+package entity
+
+import "time"
+
+// ActivityEvent is a domain event exported to the pipelines outside the service, such as analytics
+// or moderation, so they can follow the chat activity without querying Mongo
+type ActivityEvent struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	// WorkspaceId is set when the event carries it, message events don't
+	WorkspaceId string `json:"workspaceId,omitempty"`
+	ChatId      string `json:"chatId,omitempty"`
+	// ActorId is the user behind the event
+	ActorId    string    `json:"actorId,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+	Data       any       `json:"data"`
+}
+
+// ActivityUser is the part of a new user that is exported, the email and birthdate stay private
+type ActivityUser struct {
+	Id           string `json:"id"`
+	Username     string `json:"username"`
+	Name         string `json:"name"`
+	IsRestricted bool   `json:"isRestricted"`
+}
@@ -0,0 +1,52 @@
+package entity
+
+import "time"
+
+// AdminUserFilter searches the users of every workspace from the back-office
+type AdminUserFilter struct {
+	// Query matches the username, name or email, case insensitive
+	Query       string
+	Role        string
+	WorkspaceId string
+	Deactivated *bool
+	Limit       int
+	Offset      int
+}
+
+type DeactivateUserRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type DeleteMessageRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// AdminChatDetail is the metadata of a chat as seen from the back-office, without its messages
+type AdminChatDetail struct {
+	Chat
+	Participants []ChatParticipant `json:"participants"`
+	MessageCount int64             `json:"messageCount"`
+}
+
+// ServerStats is the last report of a server of the deployment
+type ServerStats struct {
+	ServerId          string    `json:"serverId"`
+	Clients           int       `json:"clients"`
+	MessagesPerSecond float64   `json:"messagesPerSecond"`
+	ErrorsPerSecond   float64   `json:"errorsPerSecond"`
+	ReportedAt        time.Time `json:"reportedAt"`
+}
+
+// MaintenanceMode takes the API down for everyone but the super admins, the message tells the
+// users why and for how long
+type MaintenanceMode struct {
+	Enabled   bool       `json:"enabled"`
+	Message   string     `json:"message,omitempty"`
+	UpdatedBy string     `json:"updatedBy,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+type SetMaintenanceModeRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
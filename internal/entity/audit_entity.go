@@ -0,0 +1,33 @@
+package entity
+
+import "time"
+
+const (
+	AuditActionChatDeleted     = "chat_deleted"
+	AuditActionChatRestored    = "chat_restored"
+	AuditActionEmptyChatPurged = "empty_chat_purged"
+	AuditActionUserDeactivated = "user_deactivated"
+	AuditActionUserReactivated = "user_reactivated"
+	AuditActionMessageDeleted  = "message_deleted"
+	// The user of the message purges is the sender they were filtered on, if any
+	AuditActionMessagesPurged = "messages_purged"
+	// A deleted chat is purged once its restore window is over
+	AuditActionDeletedChatPurged = "deleted_chat_purged"
+	// The user of the account merges is the duplicate, the merge record has the rest
+	AuditActionAccountsMerged         = "accounts_merged"
+	AuditActionAccountMergeRolledBack = "account_merge_rolled_back"
+
+	AuditActionMaintenanceEnabled  = "maintenance_enabled"
+	AuditActionMaintenanceDisabled = "maintenance_disabled"
+)
+
+type AuditLog struct {
+	Id        string    `bson:"_id" json:"id"`
+	Action    string    `bson:"action" json:"action"`
+	ChatId    string    `bson:"chatId,omitempty" json:"chatId,omitempty"`
+	UserId    string    `bson:"userId,omitempty" json:"userId,omitempty"`
+	MessageId string    `bson:"messageId,omitempty" json:"messageId,omitempty"`
+	ActorId   string    `bson:"actorId,omitempty" json:"actorId,omitempty"` // empty for system actions
+	Reason    string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
@@ -5,17 +5,38 @@ type RegisterRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	Name     string `json:"name"`
+	// Birthdate is formatted as YYYY-MM-DD
+	Birthdate string `json:"birthdate,omitempty"`
+
+	CaptchaToken string `json:"captchaToken,omitempty"`
+	RemoteIp     string `json:"-"`
+	UserAgent    string `json:"-"`
+
+	// Client is the app signing up, shown in the sessions of the user
+	Client ClientInfo `json:"client,omitempty"`
 }
 
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+
+	// CaptchaToken is only required after repeated failed logins
+	CaptchaToken string `json:"captchaToken,omitempty"`
+	RemoteIp     string `json:"-"`
+	UserAgent    string `json:"-"`
+
+	// Client is the app signing in, shown in the sessions of the user
+	Client ClientInfo `json:"client,omitempty"`
 }
 
 type AuthResponse struct {
 	AccessToken  string `json:"accessToken"`
 	RefreshToken string `json:"refreshToken,omitempty"` // Only in JSON response, not cookie
 	User         User   `json:"user"`
+
+	// TwoFactorRequired means the login must be completed with POST /auth/2fa/challenge
+	TwoFactorRequired bool   `json:"twoFactorRequired,omitempty"`
+	ChallengeToken    string `json:"challengeToken,omitempty"`
 }
 
 type TokenClaims struct {
@@ -26,4 +47,9 @@ type TokenClaims struct {
 
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refreshToken"`
+	RemoteIp     string `json:"-"`
+	UserAgent    string `json:"-"`
+
+	// Client replaces the app of the session, such as after an update, the previous one is kept when empty
+	Client ClientInfo `json:"client,omitempty"`
 }
\ No newline at end of file
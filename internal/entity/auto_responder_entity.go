@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AutoResponderSenderId is the sender of the messages posted by the auto-responder
+const AutoResponderSenderId = "auto-responder"
+
+// AutoResponder holds the auto-reply rules a group admin set up for a chat
+type AutoResponder struct {
+	ChatId    string          `bson:"_id" json:"chatId"`
+	Rules     []AutoReplyRule `bson:"rules" json:"rules"`
+	Away      *AwayMessage    `bson:"away,omitempty" json:"away,omitempty"`
+	UpdatedBy string          `bson:"updatedBy" json:"updatedBy"`
+	UpdatedAt time.Time       `bson:"updatedAt" json:"updatedAt"`
+}
+
+// AutoReplyRule answers a message containing Keyword with Response
+type AutoReplyRule struct {
+	Keyword  string `bson:"keyword" json:"keyword"`
+	Response string `bson:"response" json:"response"`
+}
+
+// Matches reports whether the text contains the keyword as whole words, ignoring case
+func (r AutoReplyRule) Matches(text string) bool {
+	pattern := `(?i)(^|\W)` + regexp.QuoteMeta(strings.TrimSpace(r.Keyword)) + `($|\W)`
+	matched, err := regexp.MatchString(pattern, text)
+	return err == nil && matched
+}
+
+// AwayMessage is posted when a message arrives outside of the office hours
+type AwayMessage struct {
+	Message string `bson:"message" json:"message"`
+	// Timezone is an IANA name such as "Europe/Paris", UTC when empty
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	// Start and End bound the office hours as "15:04", End before Start spans midnight
+	Start string `bson:"start" json:"start"`
+	End   string `bson:"end" json:"end"`
+	// Weekdays are the working days, 0 is Sunday. Every day is a working day when empty
+	Weekdays []int `bson:"weekdays,omitempty" json:"weekdays,omitempty"`
+}
+
+// IsAway reports whether t falls outside of the office hours
+func (a AwayMessage) IsAway(t time.Time) bool {
+	location, err := time.LoadLocation(a.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	t = t.In(location)
+
+	if len(a.Weekdays) > 0 {
+		working := false
+		for _, day := range a.Weekdays {
+			if time.Weekday(day) == t.Weekday() {
+				working = true
+			}
+		}
+		if !working {
+			return true
+		}
+	}
+
+	start, startErr := time.Parse("15:04", a.Start)
+	end, endErr := time.Parse("15:04", a.End)
+	if startErr != nil || endErr != nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	if startMinute <= endMinute {
+		return minute < startMinute || minute >= endMinute
+	}
+	return minute < startMinute && minute >= endMinute
+}
+
+type UpdateAutoResponderRequest struct {
+	Rules []AutoReplyRule `json:"rules"`
+	Away  *AwayMessage    `json:"away,omitempty"`
+}
@@ -1,22 +1,139 @@
 package entity
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type ChatType string
 
 const (
 	ChatTypePersonal ChatType = "personal"
 	ChatTypeGroup    ChatType = "group"
+	// ChatTypeChannel is a broadcast chat, only its admins post and the members subscribe to read
+	ChatTypeChannel ChatType = "channel"
+)
+
+const (
+	ParticipantRoleAdmin  = "admin"
+	ParticipantRoleMember = "member"
+)
+
+// History access of the members joining a group chat
+const (
+	// HistoryAccessNone only shows the messages sent after joining
+	HistoryAccessNone = "none"
+	// HistoryAccessLastDay also shows the messages of the 24 hours before joining
+	HistoryAccessLastDay = "last_24h"
+	HistoryAccessAll     = "all"
+)
+
+// IsValidHistoryAccess reports whether the value is one of the history access levels
+func IsValidHistoryAccess(access string) bool {
+	return access == HistoryAccessNone || access == HistoryAccessLastDay || access == HistoryAccessAll
+}
+
+// Join policies of a group chat, how users join it besides invitations and invite links
+const (
+	// JoinPolicyOpen lets the users of the workspace join by asking to
+	JoinPolicyOpen = "open"
+	// JoinPolicyApproval makes their join requests wait for an admin
+	JoinPolicyApproval = "approval"
+	// JoinPolicyInviteOnly refuses join requests, the policy of the chats that didn't choose one
+	JoinPolicyInviteOnly = "invite_only"
+)
+
+// IsValidJoinPolicy reports whether the value is one of the join policies
+func IsValidJoinPolicy(policy string) bool {
+	return policy == JoinPolicyOpen || policy == JoinPolicyApproval || policy == JoinPolicyInviteOnly
+}
+
+// Notification modes of a participant for a chat
+const (
+	NotificationModeAll = "all"
+	// NotificationModeMentions only notifies the participant of the messages mentioning them
+	NotificationModeMentions = "mentions"
 )
 
+// IsValidNotificationMode reports whether the value is one of the notification modes
+func IsValidNotificationMode(mode string) bool {
+	return mode == NotificationModeAll || mode == NotificationModeMentions
+}
+
 type Chat struct {
 	Id          string    `bson:"_id" json:"id"`
 	Name        string    `bson:"name" json:"name"`
 	Type        ChatType  `bson:"type" json:"type"`
 	CreatedBy   string    `bson:"createdBy" json:"createdBy"`
+	WorkspaceId string    `bson:"workspaceId" json:"workspaceId"`
 	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
 	Description string    `bson:"description,omitempty" json:"description,omitempty"`
+	Avatar      string    `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	// EmptySince is set when the last participant leaves a group chat
+	EmptySince    *time.Time `bson:"emptySince,omitempty" json:"emptySince,omitempty"`
+	KeepWhenEmpty bool       `bson:"keepWhenEmpty" json:"keepWhenEmpty"`
+	// MembershipVersion is bumped on every join, leave and role change
+	MembershipVersion int64 `bson:"membershipVersion" json:"membershipVersion"`
+	// HistoryAccess is how much history the members joining later can read, the server default when empty
+	HistoryAccess string `bson:"historyAccess,omitempty" json:"historyAccess,omitempty"`
+	// JoinPolicy is whether users may ask to join a group, JoinPolicyInviteOnly when empty
+	JoinPolicy string `bson:"joinPolicy,omitempty" json:"joinPolicy,omitempty"`
+	// MessageTTL is how many seconds the messages of the chat are kept before they disappear, forever when 0
+	MessageTTL int64 `bson:"messageTTL,omitempty" json:"messageTTL,omitempty"`
+	// PinLimit is how many messages the chat can have pinned at once, the server limit when 0
+	PinLimit int `bson:"pinLimit,omitempty" json:"pinLimit,omitempty"`
+	// DeletedAt is set when the chat is deleted, a super admin may restore it until it is purged
+	DeletedAt *time.Time `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+
+	// IsPinned, PinnedAt, IsMuted, MutedUntil and IsArchived are filled per requester from their ChatParticipant
+	IsPinned   bool       `bson:"-" json:"isPinned"`
+	PinnedAt   *time.Time `bson:"-" json:"pinnedAt,omitempty"`
+	IsMuted    bool       `bson:"-" json:"isMuted"`
+	MutedUntil *time.Time `bson:"-" json:"mutedUntil,omitempty"`
+	IsArchived bool       `bson:"-" json:"isArchived"`
+	// NotificationMode and MutedThreads are filled per requester as well
+	NotificationMode string   `bson:"-" json:"notificationMode"`
+	MutedThreads     []string `bson:"-" json:"mutedThreads,omitempty"`
+}
+
+// HasAdmins reports whether the chat is run by admins, users join and leave groups and channels
+func (c Chat) HasAdmins() bool {
+	return c.Type == ChatTypeGroup || c.Type == ChatTypeChannel
+}
+
+const (
+	MembershipChangeAdded       = "added"
+	MembershipChangeRemoved     = "removed"
+	MembershipChangeRoleUpdated = "role_updated"
+)
+
+// MembershipChange records a single membership change of a chat at a given version
+type MembershipChange struct {
+	Id        string    `bson:"_id" json:"id"`
+	ChatId    string    `bson:"chatId" json:"chatId"`
+	Version   int64     `bson:"version" json:"version"`
+	UserId    string    `bson:"userId" json:"userId"`
+	Action    string    `bson:"action" json:"action"` // "added", "removed" or "role_updated"
+	Role      string    `bson:"role,omitempty" json:"role,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+type MembershipVersion struct {
+	ChatId  string `json:"chatId"`
+	Version int64  `json:"version"`
+}
+
+// MembershipDiff is what changed in the member list since a version.
+// When Reset is set, Added holds the full member list and the client should replace its copy
+type MembershipDiff struct {
+	ChatId      string            `json:"chatId"`
+	FromVersion int64             `json:"fromVersion"`
+	Version     int64             `json:"version"`
+	Reset       bool              `json:"reset"`
+	Added       []ChatParticipant `json:"added"`
+	Updated     []ChatParticipant `json:"updated"`
+	Removed     []string          `json:"removed"`
 }
 
 type ChatParticipant struct {
@@ -26,6 +143,54 @@ type ChatParticipant struct {
 	Role      string    `bson:"role" json:"role"` // "admin" or "member"
 	JoinedAt  time.Time `bson:"joinedAt" json:"joinedAt"`
 	IsActive  bool      `bson:"isActive" json:"isActive"`
+	// Muted silences the push notifications of the chat for this participant, until MutedUntil when set
+	Muted      bool       `bson:"muted" json:"muted"`
+	MutedUntil *time.Time `bson:"mutedUntil,omitempty" json:"mutedUntil,omitempty"`
+	// PinnedAt keeps the chat at the top of the participant's chat list
+	PinnedAt *time.Time `bson:"pinnedAt,omitempty" json:"pinnedAt,omitempty"`
+	// ArchivedAt moves the chat out of the participant's default chat list
+	ArchivedAt *time.Time `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	// HistoryFrom hides the messages sent before it from the participant, set on join from the
+	// HistoryAccess of the chat
+	HistoryFrom *time.Time `bson:"historyFrom,omitempty" json:"historyFrom,omitempty"`
+	// NotificationMode is how the messages of the chat notify the participant, NotificationModeAll when empty
+	NotificationMode string `bson:"notificationMode,omitempty" json:"notificationMode,omitempty"`
+	// MutedThreads are the messages whose replies the participant muted, the rest of the chat still notifies them
+	MutedThreads []string `bson:"mutedThreads,omitempty" json:"mutedThreads,omitempty"`
+	// ExpiresAt ends the membership of a guest, they are no longer a participant past it and are
+	// removed from the chat shortly after
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+// IsGuest reports whether the participant was added for a limited time
+func (p ChatParticipant) IsGuest() bool {
+	return p.ExpiresAt != nil
+}
+
+// IsExpiredAt reports whether the guest access of the participant ended at the given time
+func (p ChatParticipant) IsExpiredAt(now time.Time) bool {
+	return p.ExpiresAt != nil && !now.Before(*p.ExpiresAt)
+}
+
+// IsMutedAt reports whether the participant muted the chat at the given time
+func (p ChatParticipant) IsMutedAt(now time.Time) bool {
+	if !p.Muted {
+		return false
+	}
+	return p.MutedUntil == nil || now.Before(*p.MutedUntil)
+}
+
+// Notifies reports whether a message of the chat notifies the participant and counts in their unread
+// badge. A mention always does, a reply in a thread they muted doesn't and in mentions only mode no
+// other message does. Muting the whole chat only silences its push notifications, see IsMutedAt
+func (p ChatParticipant) Notifies(message Message) bool {
+	if slices.Contains(message.Mentions, p.UserId) {
+		return true
+	}
+	if message.ReplyToMessageId != "" && slices.Contains(p.MutedThreads, message.ReplyToMessageId) {
+		return false
+	}
+	return p.NotificationMode != NotificationModeMentions
 }
 
 type ChatInvitation struct {
@@ -33,14 +198,104 @@ type ChatInvitation struct {
 	ChatId     string    `bson:"chatId" json:"chatId"`
 	InviterId  string    `bson:"inviterId" json:"inviterId"`
 	InviteeId  string    `bson:"inviteeId" json:"inviteeId"`
-	Status     string    `bson:"status" json:"status"` // "pending", "accepted", "rejected"
+	Status     string    `bson:"status" json:"status"` // "pending", "accepted", "rejected", "expired", "cancelled"
 	CreatedAt  time.Time `bson:"createdAt" json:"createdAt"`
 	RespondedAt *time.Time `bson:"respondedAt,omitempty" json:"respondedAt,omitempty"`
+	// ExpiresAt is when a pending invitation can no longer be accepted, the invitations sent before
+	// it existed expire after the invitation TTL
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	// Note is a short message from the inviter shown in the invite card
+	Note string `bson:"note,omitempty" json:"note,omitempty"`
+
+	// Preview is filled when the invitation is handed to the invitee
+	Preview *InvitationPreview `bson:"-" json:"preview,omitempty"`
+}
+
+// IsExpiredAt reports whether the invitation is past its expiry, the sweeper marks it expired later
+func (i ChatInvitation) IsExpiredAt(now time.Time) bool {
+	return i.ExpiresAt != nil && !i.ExpiresAt.After(now)
+}
+
+// MessagePin keeps a message at hand for every participant of its chat
+type MessagePin struct {
+	Id        string    `bson:"_id" json:"-"`
+	ChatId    string    `bson:"chatId" json:"chatId"`
+	MessageId string    `bson:"messageId" json:"messageId"`
+	PinnedBy  string    `bson:"pinnedBy" json:"pinnedBy"`
+	PinnedAt  time.Time `bson:"pinnedAt" json:"pinnedAt"`
+	// MessageTimestamp lets the pins go with their messages when the retention deletes them
+	MessageTimestamp int64 `bson:"messageTimestamp" json:"-"`
+	// Position is the place the admins gave the pin. The pins made since they last ordered them
+	// have none and come first, the latest first
+	Position int `bson:"position,omitempty" json:"position,omitempty"`
+	// ExpiresAt is when the message is unpinned on its own, it stays pinned when nil
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+
+	// Message is filled when the pin is handed to the participants
+	Message *Message `bson:"-" json:"message,omitempty"`
+}
+
+// InvitationPreview describes the group an invitation leads to
+type InvitationPreview struct {
+	ChatName    string `json:"chatName"`
+	Description string `json:"description,omitempty"`
+	Avatar      string `json:"avatar,omitempty"`
+	MemberCount int64  `json:"memberCount"`
+	InviterName string `json:"inviterName"`
+}
+
+// ChatInviteLink lets any user holding its token join a group or a channel, until the link
+// expires or an admin revokes it
+type ChatInviteLink struct {
+	Id        string `bson:"_id" json:"id"`
+	ChatId    string `bson:"chatId" json:"chatId"`
+	CreatedBy string `bson:"createdBy" json:"createdBy"`
+	// RequiresApproval turns a join through the link into a join request an admin answers
+	RequiresApproval bool       `bson:"requiresApproval" json:"requiresApproval"`
+	CreatedAt        time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpiresAt        time.Time  `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt        *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+
+	// Token is the signed join token, it is only handed out when the link is created
+	Token string `bson:"-" json:"token,omitempty"`
+}
+
+// IsUsableAt reports whether the link still lets users join at the given time
+func (l ChatInviteLink) IsUsableAt(now time.Time) bool {
+	return l.RevokedAt == nil && now.Before(l.ExpiresAt)
+}
+
+const (
+	JoinRequestStatusPending  = "pending"
+	JoinRequestStatusApproved = "approved"
+	JoinRequestStatusDenied   = "denied"
+)
+
+// ChatJoinRequest is a user asking to join a group, one of its admins approves or denies it
+type ChatJoinRequest struct {
+	Id     string `bson:"_id" json:"id"`
+	ChatId string `bson:"chatId" json:"chatId"`
+	UserId string `bson:"userId" json:"userId"`
+	// LinkId is the invite link the user followed
+	LinkId      string     `bson:"linkId,omitempty" json:"linkId,omitempty"`
+	Status      string     `bson:"status" json:"status"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	RespondedAt *time.Time `bson:"respondedAt,omitempty" json:"respondedAt,omitempty"`
+	RespondedBy string     `bson:"respondedBy,omitempty" json:"respondedBy,omitempty"`
+}
+
+// JoinChatResult tells whether following an invite link or asking to join joined the chat, or
+// left a join request for its admins
+type JoinChatResult struct {
+	ChatId string `json:"chatId"`
+	Joined bool   `json:"joined"`
+	// JoinRequest is waiting for an admin when the link requires approval
+	JoinRequest *ChatJoinRequest `json:"joinRequest,omitempty"`
 }
 
 type ChatDetailResponse struct {
 	Chat         Chat   `json:"chat"`
-	Participants []User `json:"participants"`
+	Participants []PublicUser `json:"participants"`
 }
 
 type CreatePersonalChatRequest struct {
@@ -53,10 +308,84 @@ type CreateGroupChatRequest struct {
 	UserIds     []string `json:"userIds"`
 }
 
+// CreateChannelRequest creates a channel with its creator as the only admin, the members subscribe themselves
+type CreateChannelRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// UpdateChatRequest only changes the fields that are present
+type UpdateChatRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Avatar      *string `json:"avatar,omitempty"`
+	// KeepWhenEmpty stops the chat from being purged once everyone leaves
+	KeepWhenEmpty *bool `json:"keepWhenEmpty,omitempty"`
+	// HistoryAccess applies to the members joining from now on: "none", "last_24h" or "all"
+	HistoryAccess *string `json:"historyAccess,omitempty"`
+	// JoinPolicy applies to the join requests from now on: "open", "approval" or "invite_only"
+	JoinPolicy *string `json:"joinPolicy,omitempty"`
+}
+
+// ChatSettingsRequest only changes the settings that are present
+type ChatSettingsRequest struct {
+	// MessageTTL makes the messages sent from now on disappear after that many seconds, 0 turns it off
+	MessageTTL *int64 `json:"messageTTL,omitempty"`
+	// PinLimit lowers how many messages the chat can have pinned at once, 0 goes back to the server
+	// limit. The pins past a lowered limit stay until they are unpinned
+	PinLimit *int `json:"pinLimit,omitempty"`
+}
+
+type PinMessageRequest struct {
+	// DurationDays unpins the message after that many days, zero keeps it pinned until it is unpinned
+	DurationDays int `json:"durationDays,omitempty"`
+}
+
+// ReorderPinsRequest lists every pinned message of the chat in its new order
+type ReorderPinsRequest struct {
+	MessageIds []string `json:"messageIds"`
+}
+
 type InviteUsersRequest struct {
 	UserIds []string `json:"userIds"`
+	Note    string   `json:"note,omitempty"`
 }
 
 type RespondInvitationRequest struct {
 	Accept bool `json:"accept"`
 }
+
+// CreateInviteLinkRequest creates an invite link expiring at ExpiresAt, after the default TTL without it
+type CreateInviteLinkRequest struct {
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	RequiresApproval bool       `json:"requiresApproval,omitempty"`
+}
+
+type RespondJoinRequestRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// AddGuestRequest adds a user to a group until ExpiresAt, without an invitation
+type AddGuestRequest struct {
+	UserId    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type MuteChatRequest struct {
+	// DurationMinutes mutes the chat for a while, zero keeps it muted until it is unmuted
+	DurationMinutes int `json:"durationMinutes,omitempty"`
+}
+
+type NotificationModeRequest struct {
+	Mode string `json:"mode"`
+}
+
+// MarkChatReadRequest reads the chat up to a message or a timestamp, everything sent so far without either
+type MarkChatReadRequest struct {
+	MessageId string `json:"messageId,omitempty"`
+	UpTo      int64  `json:"upTo,omitempty"`
+}
+
+type UpdateParticipantRoleRequest struct {
+	Role string `json:"role"` // "admin" or "member"
+}
@@ -0,0 +1,19 @@
+package entity
+
+// CommandCall is a slash command typed in a chat, such as "/mute 1h"
+type CommandCall struct {
+	ChatId string
+	UserId string
+	// Name is the command without the slash, lowercased
+	Name string
+	// Args is the rest of the text, trimmed
+	Args string
+}
+
+// CommandResult is the outcome of a command, both fields are optional
+type CommandResult struct {
+	// Reply is sent to the issuer only, as an ephemeral message
+	Reply string
+	// Message is stored and delivered to the chat in place of the command text
+	Message string
+}
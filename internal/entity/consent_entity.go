@@ -0,0 +1,39 @@
+package entity
+
+import "time"
+
+const (
+	LegalDocumentTerms   = "terms"
+	LegalDocumentPrivacy = "privacy"
+)
+
+// LegalDocument is a published version of the terms of service or privacy policy
+type LegalDocument struct {
+	Id          string    `bson:"_id" json:"id"`
+	Type        string    `bson:"type" json:"type"` // "terms" or "privacy"
+	Version     string    `bson:"version" json:"version"`
+	Url         string    `bson:"url,omitempty" json:"url,omitempty"`
+	Content     string    `bson:"content,omitempty" json:"content,omitempty"`
+	PublishedAt time.Time `bson:"publishedAt" json:"publishedAt"`
+}
+
+type ConsentRecord struct {
+	Id           string    `bson:"_id" json:"id"`
+	UserId       string    `bson:"userId" json:"userId"`
+	DocumentType string    `bson:"documentType" json:"documentType"`
+	Version      string    `bson:"version" json:"version"`
+	AcceptedAt   time.Time `bson:"acceptedAt" json:"acceptedAt"`
+	IpAddress    string    `bson:"ipAddress,omitempty" json:"ipAddress,omitempty"`
+}
+
+type AcceptDocumentRequest struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+type PublishDocumentRequest struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	Url     string `json:"url,omitempty"`
+	Content string `json:"content,omitempty"`
+}
@@ -0,0 +1,57 @@
+package entity
+
+import "time"
+
+// Reasons a device was deactivated
+const (
+	// DeviceDeactivatedUnregistered means the push provider no longer knows the token, a hard bounce
+	DeviceDeactivatedUnregistered = "unregistered"
+	// DeviceDeactivatedFailing means too many pushes to the device failed in a row
+	DeviceDeactivatedFailing = "failing"
+)
+
+// EmailBounce tracks the emails the mail relay refused for an address. A hard bounce, such as an
+// unknown mailbox, flags the address at once and soft bounces once enough of them happen in a row
+type EmailBounce struct {
+	Email         string    `bson:"_id" json:"email"`
+	UserId        string    `bson:"userId" json:"userId"`
+	SoftBounces   int       `bson:"softBounces" json:"softBounces"`
+	LastError     string    `bson:"lastError" json:"lastError"`
+	LastBouncedAt time.Time `bson:"lastBouncedAt" json:"lastBouncedAt"`
+	// FlaggedAt is set once no email is sent to the address anymore
+	FlaggedAt *time.Time `bson:"flaggedAt,omitempty" json:"flaggedAt,omitempty"`
+}
+
+// IsFlagged reports whether no email is sent to the address anymore
+func (b EmailBounce) IsFlagged() bool {
+	return b.FlaggedAt != nil
+}
+
+// DeliveryHealth sums up the failed push and email deliveries for the back-office
+type DeliveryHealth struct {
+	Devices DeviceDeliveryStats `json:"devices"`
+	Emails  EmailDeliveryStats  `json:"emails"`
+	// LastCleanup is the last purge of the deactivated devices run by this server, nil before the first
+	LastCleanup *DeliveryCleanup `json:"lastCleanup,omitempty"`
+}
+
+type DeviceDeliveryStats struct {
+	Active int64 `json:"active"`
+	// Failing are the active devices whose last pushes failed
+	Failing int64 `json:"failing"`
+	// Deactivated counts the deactivated devices by reason
+	Deactivated map[string]int64 `json:"deactivated"`
+}
+
+type EmailDeliveryStats struct {
+	// Flagged are the addresses no email is sent to anymore
+	Flagged int64 `json:"flagged"`
+	// SoftBounced are the addresses whose last emails bounced, not flagged yet
+	SoftBounced int64 `json:"softBounced"`
+}
+
+// DeliveryCleanup is a purge of the devices deactivated for longer than the retention
+type DeliveryCleanup struct {
+	DevicesDeleted int64     `json:"devicesDeleted"`
+	RanAt          time.Time `json:"ranAt"`
+}
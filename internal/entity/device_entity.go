@@ -0,0 +1,166 @@
+package entity
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DevicePlatformAndroid = "android"
+	DevicePlatformIOS     = "ios"
+	DevicePlatformWeb     = "web"
+)
+
+// Device is an installation of the app that can receive push notifications
+type Device struct {
+	Id        string    `bson:"_id" json:"id"`
+	UserId    string    `bson:"userId" json:"userId"`
+	Platform  string    `bson:"platform" json:"platform"` // "android", "ios" or "web"
+	PushToken string    `bson:"pushToken" json:"-"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	// Failures counts the pushes that failed in a row, the soft bounces. The device is deactivated
+	// once they reach the threshold, or at once when the provider no longer knows the token
+	Failures    int    `bson:"failures,omitempty" json:"failures,omitempty"`
+	LastFailure string `bson:"lastFailure,omitempty" json:"lastFailure,omitempty"`
+	// DeactivatedAt stops the pushes to the device, registering its token again reactivates it
+	DeactivatedAt      *time.Time `bson:"deactivatedAt,omitempty" json:"deactivatedAt,omitempty"`
+	DeactivationReason string     `bson:"deactivationReason,omitempty" json:"deactivationReason,omitempty"`
+}
+
+// IsActive reports whether the device still gets push notifications
+func (d Device) IsActive() bool {
+	return d.DeactivatedAt == nil
+}
+
+// IsValidDevicePlatform reports whether the value is one of the device platforms
+func IsValidDevicePlatform(platform string) bool {
+	return platform == DevicePlatformAndroid || platform == DevicePlatformIOS || platform == DevicePlatformWeb
+}
+
+// maxClientInfoLength bounds every field of a ClientInfo, in characters
+const maxClientInfoLength = 64
+
+// ClientInfo describes the app a sign in or a connection comes from, as reported by the client
+type ClientInfo struct {
+	Platform   string `bson:"platform,omitempty" json:"platform,omitempty"` // "android", "ios" or "web"
+	AppVersion string `bson:"appVersion,omitempty" json:"appVersion,omitempty"`
+	// Label names the device for its owner, such as "Work laptop"
+	Label string `bson:"label,omitempty" json:"label,omitempty"`
+}
+
+// Sanitized drops an unknown platform and cuts the fields to a sane length. Clients report
+// anything, so the info is cleaned rather than refused
+func (c ClientInfo) Sanitized() ClientInfo {
+	platform := strings.ToLower(strings.TrimSpace(c.Platform))
+	if !IsValidDevicePlatform(platform) {
+		platform = ""
+	}
+	return ClientInfo{
+		Platform:   platform,
+		AppVersion: truncateRunes(strings.TrimSpace(c.AppVersion), maxClientInfoLength),
+		Label:      truncateRunes(strings.TrimSpace(c.Label), maxClientInfoLength),
+	}
+}
+
+// IsEmpty reports whether the client reported nothing
+func (c ClientInfo) IsEmpty() bool {
+	return c.Platform == "" && c.AppVersion == "" && c.Label == ""
+}
+
+// AppVersionAtLeast compares dotted versions such as "3.2.0" number by number, the suffix of a
+// number such as "-beta" is ignored. An app that reported no version is older than any
+func (c ClientInfo) AppVersionAtLeast(version string) bool {
+	have, ok := parseAppVersion(c.AppVersion)
+	if !ok {
+		return false
+	}
+	want, _ := parseAppVersion(version)
+
+	for i := 0; i < max(len(have), len(want)); i++ {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// parseAppVersion returns the numbers of a dotted version, ok is false when it doesn't start with one
+func parseAppVersion(version string) ([]int, bool) {
+	version = strings.TrimPrefix(version, "v")
+	if version == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(version, ".")
+	numbers := make([]int, 0, len(parts))
+	for _, part := range parts {
+		digits := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		if digits < 0 {
+			digits = len(part)
+		}
+		number, err := strconv.Atoi(part[:digits])
+		if err != nil {
+			if len(numbers) == 0 {
+				return nil, false
+			}
+			break
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, true
+}
+
+// truncateRunes cuts a string to at most limit characters
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit])
+}
+
+type RegisterDeviceRequest struct {
+	Platform  string `json:"platform"`
+	PushToken string `json:"pushToken"`
+}
+
+// PushNotification is what is sent to a device when a message was not delivered in real time
+type PushNotification struct {
+	UserId    string `json:"userId"`
+	ChatId    string `json:"chatId"`
+	MessageId string `json:"messageId"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+}
+
+// NotificationPreferences decides which messages are pushed to the devices of a user,
+// single chats are muted on the ChatParticipant instead
+type NotificationPreferences struct {
+	UserId      string    `bson:"_id" json:"userId"`
+	Enabled     bool      `bson:"enabled" json:"enabled"`
+	ShowPreview bool      `bson:"showPreview" json:"showPreview"` // false hides the message text from the notification
+	UpdatedAt   time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+type UpdateNotificationPreferencesRequest struct {
+	Enabled     bool `json:"enabled"`
+	ShowPreview bool `json:"showPreview"`
+}
+
+// DefaultNotificationPreferences returns the preferences used until a user saves their own
+func DefaultNotificationPreferences(userId string) NotificationPreferences {
+	return NotificationPreferences{
+		UserId:      userId,
+		Enabled:     true,
+		ShowPreview: true,
+	}
+}
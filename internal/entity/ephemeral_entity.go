@@ -0,0 +1,32 @@
+package entity
+
+import "strings"
+
+// EphemeralIdPrefix starts the id of every ephemeral message, they can't be acked or read
+const EphemeralIdPrefix = "eph_"
+
+// Kinds of ephemeral messages
+const (
+	EphemeralKindCommandResult = "command_result"
+	EphemeralKindError         = "error"
+	EphemeralKindPrompt        = "prompt"
+)
+
+// EphemeralMessage is the payload of the ephemeral_message event, a server generated message
+// for a single user. It is never stored, so clients must not send delivery or read acks for it
+type EphemeralMessage struct {
+	Id string `json:"id"`
+	// ChatId is empty for the messages that aren't about a chat
+	ChatId    string `json:"chatId,omitempty"`
+	Kind      string `json:"kind"`
+	Command   string `json:"command,omitempty"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+	// SentAt is Timestamp as ISO-8601 in UTC
+	SentAt string `json:"sentAt"`
+}
+
+// IsEphemeralId reports whether a message id belongs to an ephemeral message
+func IsEphemeralId(id string) bool {
+	return strings.HasPrefix(id, EphemeralIdPrefix)
+}
@@ -0,0 +1,183 @@
+package entity
+
+import "time"
+
+// Real-time event types pushed to clients over the websocket
+const (
+	EventChatUpdated     = "chat_updated"
+	EventMessageRejected = "message_rejected"
+	// EventMessageAck tells the connection that sent a message that it was stored, and under which id
+	EventMessageAck = "message_ack"
+
+	EventInvitationReceived  = "invitation_received"
+	EventInvitationResponded = "invitation_responded"
+	EventInvitationCancelled = "invitation_cancelled"
+	EventMessageRead         = "message_read"
+	EventMessageDelivered    = "message_delivered"
+	// EventChatRead carries the ReadHorizon of a participant who read a chat at once
+	EventChatRead = "chat_read"
+	// EventMessageDeleted carries a MessageDeleted, the message was removed by moderation
+	EventMessageDeleted = "message_deleted"
+	// EventMessagesDeleted carries a MessagesDeleted, a batch of messages removed by a message purge
+	EventMessagesDeleted = "messages_deleted"
+	// EventMessagePurgeUpdated carries the MessagePurge after every batch, sent to the admin who started it
+	EventMessagePurgeUpdated = "message_purge_updated"
+	// EventMessagesExpired carries a MessagesExpired, the messages reached the MessageTTL of their chat
+	EventMessagesExpired = "messages_expired"
+	// EventLinkPreview carries a LinkPreviewReady, the preview of the first link of a message
+	EventLinkPreview = "link_preview"
+	// EventMessagePinned and EventMessageUnpinned carry the MessagePin, sent to the participants of the chat
+	EventMessagePinned   = "message_pinned"
+	EventMessageUnpinned = "message_unpinned"
+	// EventMessagePinsReordered carries a MessagePinOrder, sent to the participants of the chat
+	EventMessagePinsReordered = "message_pins_reordered"
+	// EventJoinRequestReceived carries a ChatJoinRequest waiting for an answer, sent to the admins of the chat
+	EventJoinRequestReceived = "join_request_received"
+	// EventJoinRequestResponded carries the ChatJoinRequest an admin approved or denied, sent to its
+	// user and to the admins of the chat
+	EventJoinRequestResponded = "join_request_responded"
+
+	EventMemberJoined  = "member_joined"
+	EventMemberLeft    = "member_left"
+	EventMemberRemoved = "member_removed"
+	// EventRoleChanged carries the MembershipEvent of a member promoted or demoted by an admin
+	EventRoleChanged = "role_changed"
+	// EventChatDeleted carries the deleted Chat, sent to its participants and pending invitees
+	EventChatDeleted = "chat_deleted"
+	// EventSystemMessage carries a Message of MessageTypeSystem, such as "Alice added Bob" or the end of a guest access
+	EventSystemMessage = "system_message"
+
+	EventChatViewers = "chat_viewers"
+	EventChatReplay  = "chat_replay"
+	// EventAutoReply carries a Message posted by the auto-responder of the chat
+	EventAutoReply = "auto_reply"
+	// EventEphemeralMessage carries an EphemeralMessage, such as the answer to a slash command
+	EventEphemeralMessage = "ephemeral_message"
+	// EventMessageScheduled carries the ScheduledMessage created by a message sent with a scheduledAt
+	EventMessageScheduled = "message_scheduled"
+	// EventScheduledMessageFailed carries a ScheduledMessage that could not be sent when it was due
+	EventScheduledMessageFailed = "scheduled_message_failed"
+)
+
+// Event classes a connection can unsubscribe from, the high-frequency events low-power clients
+// can do without
+const (
+	EventClassPresence = "presence"
+	EventClassReceipts = "receipts"
+	EventClassTyping   = "typing"
+)
+
+// EventClasses are the event types of each class. The server sends no typing events yet, the
+// class is accepted so clients can unsubscribe from them ahead
+var EventClasses = map[string][]string{
+	EventClassPresence: {EventChatViewers},
+	EventClassReceipts: {EventMessageRead, EventMessageDelivered, EventChatRead},
+	EventClassTyping:   {},
+}
+
+// Domain event types dispatched on the internal event bus only
+const (
+	EventChatCreated    = "chat_created"
+	EventChatRestored   = "chat_restored"
+	EventMessageCreated = "message_created"
+	// EventMessageUpdated carries a Message whose stored document changed, such as by its link preview
+	EventMessageUpdated = "message_updated"
+	// EventParticipantUpdated carries the ChatParticipant after its owner muted, pinned or archived the chat
+	EventParticipantUpdated = "participant_updated"
+	// EventUserRegistered carries the new User, without its password
+	EventUserRegistered = "user_registered"
+	// EventSecurityAlert carries a SecurityEvent raised by the authentication
+	EventSecurityAlert = "security_alert"
+)
+
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// MembershipEvent is the payload of the member_* events
+type MembershipEvent struct {
+	ChatId  string `json:"chatId"`
+	UserId  string `json:"userId"`
+	ActorId string `json:"actorId,omitempty"`
+	Role    string `json:"role,omitempty"`
+	// Reason tells why a member was removed without an actor, see MembershipReasonGuestExpired
+	Reason string `json:"reason,omitempty"`
+}
+
+// MembershipReasonGuestExpired removes a guest whose access ended
+const MembershipReasonGuestExpired = "guest_expired"
+
+// ReadReceipt is the payload of the message_read event, sent to the author of the message
+type ReadReceipt struct {
+	ChatId    string    `json:"chatId"`
+	MessageId string    `json:"messageId"`
+	ReaderId  string    `json:"readerId"`
+	ReadAt    time.Time `json:"readAt"`
+	// Quiet is set when the message was left out of the unread count of the reader
+	Quiet bool `json:"-"`
+}
+
+// ReadHorizon is the payload of the chat_read event, sent to the other participants. Every message
+// of the chat up to ReadUpTo is read by the reader
+type ReadHorizon struct {
+	ChatId   string    `json:"chatId"`
+	ReaderId string    `json:"readerId"`
+	ReadUpTo int64     `json:"readUpTo"`
+	ReadAt   time.Time `json:"readAt"`
+}
+
+// MessageDeleted is the payload of the message_deleted event, sent to the participants of the chat
+type MessageDeleted struct {
+	ChatId    string    `json:"chatId"`
+	MessageId string    `json:"messageId"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// MessagesDeleted is the payload of the messages_deleted event, clients drop the messages from their cache
+type MessagesDeleted struct {
+	ChatId     string    `json:"chatId"`
+	MessageIds []string  `json:"messageIds"`
+	DeletedAt  time.Time `json:"deletedAt"`
+}
+
+// MessagesExpired is the payload of the messages_expired event, clients remove the messages from view
+type MessagesExpired struct {
+	ChatId     string   `json:"chatId"`
+	MessageIds []string `json:"messageIds"`
+}
+
+// MessagePinOrder is the payload of the message_pins_reordered event, the pinned messages of the
+// chat in the order the admins gave them
+type MessagePinOrder struct {
+	ChatId     string   `json:"chatId"`
+	MessageIds []string `json:"messageIds"`
+}
+
+// LinkPreviewReady is the payload of the link_preview event, sent to the participants once the
+// preview of a message was read
+type LinkPreviewReady struct {
+	ChatId      string      `json:"chatId"`
+	MessageId   string      `json:"messageId"`
+	LinkPreview LinkPreview `json:"linkPreview"`
+}
+
+// DeliveryReceipt is the payload of the message_delivered event, sent to the author of the message
+type DeliveryReceipt struct {
+	ChatId      string    `json:"chatId"`
+	MessageId   string    `json:"messageId"`
+	RecipientId string    `json:"recipientId"`
+	DeliveredAt time.Time `json:"deliveredAt"`
+}
+
+// ChatViewers is the payload of the chat_viewers event, the users that currently have the chat open
+type ChatViewers struct {
+	ChatId  string   `json:"chatId"`
+	UserIds []string `json:"userIds"`
+}
+
+// ChatReplay is the payload of the chat_replay event, the most recent messages of a chat newest first
+type ChatReplay struct {
+	ChatId   string    `json:"chatId"`
+	Messages []Message `json:"messages"`
+}
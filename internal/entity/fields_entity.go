@@ -0,0 +1,20 @@
+package entity
+
+import "slices"
+
+// Fields is a sparse fieldset, the JSON names of the fields a client wants in a list. Every field
+// is returned when it is empty
+type Fields []string
+
+// Has reports whether any of the fields is part of the response
+func (f Fields) Has(names ...string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if slices.Contains(f, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// InboxEntry is the denormalized chat list row of a user, kept up to date from domain events
+type InboxEntry struct {
+	Id          string        `bson:"_id" json:"-"` // "{userId}:{chatId}"
+	UserId      string        `bson:"userId" json:"-"`
+	ChatId      string        `bson:"chatId" json:"id"`
+	Type        ChatType      `bson:"type" json:"type"`
+	Name        string        `bson:"name" json:"name"`
+	Avatar      string        `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	LastMessage *InboxMessage `bson:"lastMessage,omitempty" json:"lastMessage,omitempty"`
+	UnreadCount int64         `bson:"unreadCount" json:"unreadCount"`
+	UpdatedAt   time.Time     `bson:"updatedAt" json:"updatedAt"`
+	// PinnedAt and IsArchived mirror the ChatParticipant of the user
+	PinnedAt   *time.Time `bson:"pinnedAt,omitempty" json:"pinnedAt,omitempty"`
+	IsArchived bool       `bson:"isArchived" json:"isArchived"`
+
+	// ParticipantCount and OnlineCount are computed when the list is read
+	ParticipantCount int `bson:"-" json:"participantCount"`
+	OnlineCount      int `bson:"-" json:"onlineCount"`
+}
+
+type InboxMessage struct {
+	MessageId string `bson:"messageId" json:"messageId"`
+	SenderId  string `bson:"senderId" json:"senderId"`
+	Snippet   string `bson:"snippet" json:"snippet"`
+	Timestamp int64  `bson:"timestamp" json:"timestamp"`
+}
+
+// MarshalJSON adds sentAt, the timestamp written like the other times of the payloads
+func (m InboxMessage) MarshalJSON() ([]byte, error) {
+	type inboxMessage InboxMessage
+	return json.Marshal(struct {
+		inboxMessage
+		SentAt string `json:"sentAt"`
+	}{inboxMessage(m), FormatTimestamp(m.Timestamp)})
+}
+
+// UnreadCount is the number of messages of a chat a user didn't read
+type UnreadCount struct {
+	UserId string `bson:"userId"`
+	ChatId string `bson:"chatId"`
+	Count  int64  `bson:"count"`
+}
+
+// InboxEntryId returns the id of the inbox entry of a user for a chat
+func InboxEntryId(userId string, chatId string) string {
+	return userId + ":" + chatId
+}
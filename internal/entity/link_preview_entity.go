@@ -0,0 +1,16 @@
+package entity
+
+// LinkPreview is the OpenGraph metadata of the page a link points to, the title of the page and
+// its description meta tag stand in for the missing og:title and og:description
+type LinkPreview struct {
+	Url         string `bson:"url" json:"url"`
+	Title       string `bson:"title,omitempty" json:"title,omitempty"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	Image       string `bson:"image,omitempty" json:"image,omitempty"`
+	SiteName    string `bson:"siteName,omitempty" json:"siteName,omitempty"`
+}
+
+// IsEmpty reports whether the page had nothing to show, such previews are not attached
+func (p LinkPreview) IsEmpty() bool {
+	return p.Title == "" && p.Description == ""
+}
@@ -1,16 +1,199 @@
 package entity
 
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MessageTypeSystem marks the messages the server posts about the chat itself, sent by SystemSenderId
+const MessageTypeSystem = "system"
+
+// SystemSenderId is the sender of the system messages
+const SystemSenderId = "system"
+
 type Message struct {
-	Id        string `bson:"_id" json:"id"`
-	ChatId    string `bson:"chatId" json:"chatId"`
+	Id       string `bson:"_id" json:"id"`
+	ChatId   string `bson:"chatId" json:"chatId"`
+	SenderId string `bson:"senderId" json:"senderId"`
+	Message  string `bson:"message" json:"message"`
+	// Timestamp is when the server stored the message, in unix milliseconds
+	Timestamp int64 `bson:"timestamp" json:"timestamp"`
+	// Seq orders the messages of the chat, it is assigned when the message is stored and only
+	// increases. It can skip numbers, a gap is not a lost message. Messages stored before it
+	// existed have none
+	Seq    int64 `bson:"seq,omitempty" json:"seq,omitempty"`
+	IsRead bool  `bson:"isRead" json:"isRead"`
+	// Type is MessageTypeSystem for system messages, empty for the ones users send
+	Type string `bson:"type,omitempty" json:"type,omitempty"`
+	// ClientMessageId is the idempotency key the sending client chose, a retry of the message
+	// with the same key returns the stored message instead of sending it again
+	ClientMessageId string `bson:"clientMessageId,omitempty" json:"clientMessageId,omitempty"`
+
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	// HasLink is derived from the text when the message is stored so searches can filter on it
+	HasLink bool `bson:"hasLink,omitempty" json:"-"`
+
+	ReplyToMessageId string         `bson:"replyToMessageId,omitempty" json:"replyToMessageId,omitempty"`
+	ReplyTo          *QuotedMessage `bson:"replyTo,omitempty" json:"replyTo,omitempty"`
+
+	// Mentions are the participants the message mentions, it notifies them whatever their notification mode
+	Mentions []string `bson:"mentions,omitempty" json:"mentions,omitempty"`
+
+	// IsAutoReply marks the messages posted by the auto-responder of the chat, they never trigger one
+	IsAutoReply bool `bson:"isAutoReply,omitempty" json:"isAutoReply,omitempty"`
+
+	// LinkPreview describes the first link of the text, it is added once the page was read
+	LinkPreview *LinkPreview `bson:"linkPreview,omitempty" json:"linkPreview,omitempty"`
+
+	// ExpiresAt is when the message disappears, set from the MessageTTL of its chat when it is sent
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+
+	// DeliveryState and Receipts are filled per requester when listing messages, see MessageReceipt
+	DeliveryState string           `bson:"-" json:"deliveryState,omitempty"`
+	Receipts      []MessageReceipt `bson:"-" json:"receipts,omitempty"`
+	// DeliveredAt and ReadAt go with the DeliveryState: when the requester got the message, or for
+	// its author when the last recipient did
+	DeliveredAt *time.Time `bson:"-" json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `bson:"-" json:"readAt,omitempty"`
+}
+
+// IsSystem reports whether the server posted the message, system messages notify nobody
+func (m Message) IsSystem() bool {
+	return m.Type == MessageTypeSystem
+}
+
+// MarshalJSON adds sentAt, the timestamp written like the other times of the payloads
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	return json.Marshal(struct {
+		message
+		SentAt string `json:"sentAt"`
+	}{message(m), FormatTimestamp(m.Timestamp)})
+}
+
+// FormatTimestamp writes a timestamp in unix milliseconds as ISO-8601 in UTC, whatever the timezone
+// of the server
+func FormatTimestamp(timestamp int64) string {
+	return time.UnixMilli(timestamp).UTC().Format(time.RFC3339Nano)
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+// ContainsLink reports whether the text of the message contains a web link
+func (m Message) ContainsLink() bool {
+	return linkPattern.MatchString(m.Message)
+}
+
+// FirstLink returns the first web link of the text with its scheme, empty when there is none
+func (m Message) FirstLink() string {
+	link := strings.TrimRight(linkPattern.FindString(m.Message), ".,;:!?)]}'\"")
+	if link == "" {
+		return ""
+	}
+	if strings.HasPrefix(strings.ToLower(link), "www.") {
+		return "https://" + link
+	}
+	return link
+}
+
+// Delivery states of a message for one recipient, in the order they are reached
+const (
+	DeliveryStateSent      = "sent"
+	DeliveryStateDelivered = "delivered"
+	DeliveryStateRead      = "read"
+)
+
+// MessageReceipt tracks the delivery of a message to one recipient
+type MessageReceipt struct {
+	Id          string     `bson:"_id" json:"-"`
+	MessageId   string     `bson:"messageId" json:"messageId"`
+	ChatId      string     `bson:"chatId" json:"chatId"`
+	SenderId    string     `bson:"senderId" json:"-"`
+	UserId      string     `bson:"userId" json:"userId"`
+	State       string     `bson:"state" json:"state"` // "sent", "delivered" or "read"
+	DeliveredAt *time.Time `bson:"deliveredAt,omitempty" json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `bson:"readAt,omitempty" json:"readAt,omitempty"`
+	// Timestamp is the one of the message, receipts written before it was recorded have none
+	Timestamp int64 `bson:"timestamp,omitempty" json:"-"`
+	// Quiet is set when the message didn't notify the recipient, see ChatParticipant.Notifies. It
+	// is left out of their unread count
+	Quiet bool `bson:"quiet,omitempty" json:"-"`
+}
+
+// DeliveryStateRank orders the delivery states, unknown states rank lowest
+func DeliveryStateRank(state string) int {
+	switch state {
+	case DeliveryStateSent:
+		return 1
+	case DeliveryStateDelivered:
+		return 2
+	case DeliveryStateRead:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// QuotedMessage is a snippet of the message being replied to, stored with the reply
+// so clients can render it without fetching the original
+type QuotedMessage struct {
+	MessageId string `bson:"messageId" json:"messageId"`
 	SenderId  string `bson:"senderId" json:"senderId"`
-	Message   string `bson:"message" json:"message"`
-	Timestamp int64  `bson:"timestamp" json:"timestamp"`
-	IsRead    bool   `bson:"isRead" json:"isRead"`
+	Snippet   string `bson:"snippet" json:"snippet"`
+}
+
+type MessageThread struct {
+	Root    Message   `json:"root"`
+	Replies []Message `json:"replies"`
+}
+
+// MessageContext is a message with the messages sent right before and after it, both oldest first
+type MessageContext struct {
+	Message       Message   `json:"message"`
+	Before        []Message `json:"before"`
+	After         []Message `json:"after"`
+	HasMoreBefore bool      `json:"hasMoreBefore"`
+	HasMoreAfter  bool      `json:"hasMoreAfter"`
+}
+
+// Attachment describes a file uploaded by the client, the server only stores its metadata
+type Attachment struct {
+	Url      string `bson:"url" json:"url"`
+	Name     string `bson:"name" json:"name"`
+	MimeType string `bson:"mimeType" json:"mimeType"`
+	Size     int64  `bson:"size" json:"size"`
 }
 
 type MessageIndexFilter struct {
 	ChatId string `bson:"chatId"`
 	Limit  int    `bson:"limit"`
 	Offset int    `bson:"offset"`
-}
\ No newline at end of file
+	Since  int64  `bson:"since"` // only messages sent at or after this timestamp
+	Fields Fields `bson:"-"`      // only these fields are read, all of them when empty
+}
+
+// MessageSearchFilter narrows a message search, zero values don't filter
+type MessageSearchFilter struct {
+	// Query matches the text of the message, case insensitive
+	Query string
+	// ChatId limits the search to one chat, every chat of the user is searched otherwise
+	ChatId        string
+	SenderId      string
+	Before        int64 // only messages sent before this timestamp
+	After         int64 // only messages sent after this timestamp
+	HasAttachment *bool
+	HasLink       *bool
+	Limit         int
+	Offset        int
+
+	// Scopes are the chats the search may read and their history cutoff, set by the usecase
+	Scopes []MessageSearchScope
+}
+
+// MessageSearchScope lets a search read the chats sent at or after Since
+type MessageSearchScope struct {
+	ChatIds []string
+	Since   int64
+}
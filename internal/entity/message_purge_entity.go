@@ -0,0 +1,57 @@
+package entity
+
+import "time"
+
+const (
+	MessagePurgeStatusPending = "pending"
+	// MessagePurgeStatusRunning is a purge claimed by a server that is deleting its messages
+	MessagePurgeStatusRunning = "running"
+	MessagePurgeStatusDone    = "done"
+)
+
+// MessagePurgeFilter selects the messages of a chat a purge deletes, zero values don't filter
+type MessagePurgeFilter struct {
+	SenderId string `bson:"senderId,omitempty" json:"senderId,omitempty"`
+	After    int64  `bson:"after,omitempty" json:"after,omitempty"`   // only messages sent after this timestamp
+	Before   int64  `bson:"before,omitempty" json:"before,omitempty"` // only messages sent before this timestamp
+	// HasLink only deletes the messages containing a link
+	HasLink bool `bson:"hasLink,omitempty" json:"hasLink,omitempty"`
+}
+
+// IsEmpty reports whether the filter would match every message of the chat
+func (f MessagePurgeFilter) IsEmpty() bool {
+	return f.SenderId == "" && f.After == 0 && f.Before == 0 && !f.HasLink
+}
+
+// MessagePurge deletes the messages of a chat matching a filter in the background, a super admin
+// follows its progress until it is done
+type MessagePurge struct {
+	Id          string             `bson:"_id" json:"id"`
+	ChatId      string             `bson:"chatId" json:"chatId"`
+	Filter      MessagePurgeFilter `bson:"filter" json:"filter"`
+	Reason      string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	RequestedBy string             `bson:"requestedBy" json:"requestedBy"`
+
+	Status string `bson:"status" json:"status"`
+	// Matched is how many messages matched when the purge was started
+	Matched int64 `bson:"matched" json:"matched"`
+	Deleted int64 `bson:"deleted" json:"deleted"`
+	// ClaimedAt is renewed after every batch, a claim left alone for a few minutes belongs to a
+	// server that died and is taken over
+	ClaimedAt  *time.Time `bson:"claimedAt,omitempty" json:"-"`
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time  `bson:"updatedAt" json:"updatedAt"`
+	FinishedAt *time.Time `bson:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+type MessagePurgeRequest struct {
+	MessagePurgeFilter
+	Reason string `json:"reason,omitempty"`
+}
+
+// MessagePurgePreview is how many messages a purge with the filter would delete now
+type MessagePurgePreview struct {
+	ChatId  string             `json:"chatId"`
+	Filter  MessagePurgeFilter `json:"filter"`
+	Matched int64              `json:"matched"`
+}
@@ -0,0 +1,58 @@
+package entity
+
+import "time"
+
+const (
+	// ModerationCategoryKeyword is a blocked word of the workspace
+	ModerationCategoryKeyword = "keyword"
+	// ModerationCategoryProfanity is a word of the built-in profanity list
+	ModerationCategoryProfanity = "profanity"
+	// ModerationCategoryExternal is a text flagged by the moderation service without a category
+	ModerationCategoryExternal = "external"
+)
+
+const (
+	FlagStatusPending   = "pending"
+	FlagStatusDismissed = "dismissed"
+	FlagStatusRemoved   = "removed"
+)
+
+const (
+	FlagDecisionDismiss = "dismiss"
+	FlagDecisionRemove  = "remove"
+)
+
+// ModerationVerdict is what the moderators found in the text of a message, the text is clean
+// when it has no category
+type ModerationVerdict struct {
+	// Moderators are the names of the moderators that flagged the text
+	Moderators []string `bson:"moderators,omitempty" json:"moderators,omitempty"`
+	Categories []string `bson:"categories,omitempty" json:"categories,omitempty"`
+	// Matches are the passages of the text to mask, a verdict without matches can't be masked
+	Matches []string `bson:"matches,omitempty" json:"matches,omitempty"`
+}
+
+func (v ModerationVerdict) Flagged() bool {
+	return len(v.Categories) > 0
+}
+
+// FlaggedMessage is a message stored by a workspace moderating with the flag action, waiting for
+// an admin to keep or remove it
+type FlaggedMessage struct {
+	Id          string            `bson:"_id" json:"id"`
+	MessageId   string            `bson:"messageId" json:"messageId"`
+	ChatId      string            `bson:"chatId" json:"chatId"`
+	SenderId    string            `bson:"senderId" json:"senderId"`
+	WorkspaceId string            `bson:"workspaceId" json:"workspaceId"`
+	Text        string            `bson:"text" json:"text"`
+	Verdict     ModerationVerdict `bson:"verdict" json:"verdict"`
+	Status      string            `bson:"status" json:"status"`
+	ReviewedBy  string            `bson:"reviewedBy,omitempty" json:"reviewedBy,omitempty"`
+	ReviewedAt  *time.Time        `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+	CreatedAt   time.Time         `bson:"createdAt" json:"createdAt"`
+}
+
+type ReviewFlaggedMessageRequest struct {
+	Decision string `json:"decision"` // "dismiss" or "remove"
+	Reason   string `json:"reason,omitempty"`
+}
@@ -0,0 +1,38 @@
+package entity
+
+import "time"
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+)
+
+const (
+	// OutboxKindEvent is a real-time event, rows written before kinds existed have none and are events too
+	OutboxKindEvent = "event"
+	// OutboxKindMessage is the fan-out of a new chat message, written with the message
+	OutboxKindMessage = "message"
+)
+
+// OutboxEvent is a real-time event persisted before it is handed to the hub,
+// so it can be replayed if the server dies before delivering it
+type OutboxEvent struct {
+	Id          string     `bson:"_id" json:"id"`
+	Kind        string     `bson:"kind,omitempty" json:"kind,omitempty"`
+	Recipients  []string   `bson:"recipients" json:"recipients"`
+	Type        string     `bson:"type" json:"type"`
+	Data        string     `bson:"data" json:"data"` // JSON encoded event data, a MessageFanout for messages
+	Status      string     `bson:"status" json:"status"`
+	Attempts    int        `bson:"attempts" json:"attempts"`
+	LockedUntil *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	SentAt      *time.Time `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+}
+
+// MessageFanout is what the recipients of a new message are sent
+type MessageFanout struct {
+	Message    Message `json:"message"`
+	SenderName string  `json:"senderName"`
+	// Broadcast is set for the posts of a channel, their delivery is not tracked per recipient
+	Broadcast bool `json:"broadcast,omitempty"`
+}
@@ -0,0 +1,118 @@
+package entity
+
+import "time"
+
+const (
+	PlanFree = "free"
+	PlanPro  = "pro"
+)
+
+// Usage metrics tracked per workspace and billing period
+const (
+	UsageMetricMessages        = "messages"
+	UsageMetricAttachmentBytes = "attachment_bytes"
+)
+
+// PlanLimits describes what a plan allows, a zero value means unlimited
+type PlanLimits struct {
+	HistoryDays        int   `json:"historyDays"`
+	MaxGroupSize       int   `json:"maxGroupSize"`
+	MaxAttachmentBytes int64 `json:"maxAttachmentBytes"`
+}
+
+var planLimits = map[string]PlanLimits{
+	PlanFree: {
+		HistoryDays:        90,
+		MaxGroupSize:       50,
+		MaxAttachmentBytes: 5 * 1024 * 1024,
+	},
+	PlanPro: {
+		HistoryDays:        0,
+		MaxGroupSize:       1000,
+		MaxAttachmentBytes: 100 * 1024 * 1024,
+	},
+}
+
+// GetPlanLimits returns the limits of a plan and whether the plan exists
+func GetPlanLimits(plan string) (PlanLimits, bool) {
+	limits, ok := planLimits[plan]
+	return limits, ok
+}
+
+type PlanAssignment struct {
+	WorkspaceId string    `bson:"_id" json:"workspaceId"`
+	Plan        string    `bson:"plan" json:"plan"`
+	AssignedBy  string    `bson:"assignedBy" json:"assignedBy"`
+	AssignedAt  time.Time `bson:"assignedAt" json:"assignedAt"`
+}
+
+type UsageRecord struct {
+	Id          string `bson:"_id" json:"id"`
+	WorkspaceId string `bson:"workspaceId" json:"workspaceId"`
+	Metric      string `bson:"metric" json:"metric"`
+	Period      string `bson:"period" json:"period"` // billing month, e.g. "2026-01"
+	Value       int64  `bson:"value" json:"value"`
+}
+
+type WorkspacePlan struct {
+	WorkspaceId string           `json:"workspaceId"`
+	Plan        string           `json:"plan"`
+	Limits      PlanLimits       `json:"limits"`
+	Period      string           `json:"period"`
+	Usage       map[string]int64 `json:"usage"`
+}
+
+type AssignPlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// WorkspaceStorage is what the messages of a workspace take in the database
+type WorkspaceStorage struct {
+	WorkspaceId string `bson:"_id" json:"workspaceId"`
+	Messages    int64  `bson:"messages" json:"messages"`
+	Bytes       int64  `bson:"bytes" json:"bytes"`
+}
+
+// TenantUsage is the usage report of a workspace used for chargeback and capacity planning
+type TenantUsage struct {
+	WorkspaceId string           `json:"workspaceId"`
+	Period      string           `json:"period"`
+	Usage       map[string]int64 `json:"usage"`
+	// StoredMessages and StorageBytes were measured at StorageMeasuredAt
+	StoredMessages    int64      `json:"storedMessages"`
+	StorageBytes      int64      `json:"storageBytes"`
+	StorageMeasuredAt *time.Time `json:"storageMeasuredAt,omitempty"`
+	// ActiveConnections only counts the connections held by the server answering
+	ActiveConnections int64 `json:"activeConnections"`
+}
+
+// MetricsCounters are the live counters of this server read from the metrics registry at At
+type MetricsCounters struct {
+	At          time.Time
+	Connections float64
+	Messages    float64
+	Errors      float64
+}
+
+// LiveMetrics is a sample of the live counters pushed to the admin dashboard
+type LiveMetrics struct {
+	Timestamp         time.Time `json:"timestamp"`
+	Connections       int64     `json:"connections"`
+	MessagesPerSecond float64   `json:"messagesPerSecond"`
+	ErrorsPerSecond   float64   `json:"errorsPerSecond"`
+}
+
+// LiveSince turns the counters into rates over the time elapsed since the previous counters
+func (c MetricsCounters) LiveSince(previous MetricsCounters) LiveMetrics {
+	live := LiveMetrics{
+		Timestamp:   c.At,
+		Connections: int64(c.Connections),
+	}
+
+	elapsed := c.At.Sub(previous.At).Seconds()
+	if elapsed > 0 {
+		live.MessagesPerSecond = (c.Messages - previous.Messages) / elapsed
+		live.ErrorsPerSecond = (c.Errors - previous.Errors) / elapsed
+	}
+	return live
+}
@@ -0,0 +1,32 @@
+package entity
+
+import "time"
+
+// RateLimit is where a user stands in the current window of the API rate limit
+type RateLimit struct {
+	Limit     int64     `json:"limit"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"resetAt"`
+	// Exceeded is set once the user sent more requests than the limit in the window
+	Exceeded bool `json:"-"`
+}
+
+// QuotaUsage is the consumption of a quota, a zero limit means unlimited
+type QuotaUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit,omitempty"`
+	// ResetAt is when the usage starts again from zero, absent for the quotas that never reset
+	ResetAt *time.Time `json:"resetAt,omitempty"`
+}
+
+// UserLimits is the quota usage of a user, for the clients to back off before they are limited
+type UserLimits struct {
+	Plan string `json:"plan"`
+	// RateLimit is absent when the API is not rate limited
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+	// MessagesToday counts the messages sent since midnight in the timezone of the user
+	MessagesToday QuotaUsage `json:"messagesToday"`
+	// Storage is the size of the messages of the user, attachments included
+	Storage            QuotaUsage `json:"storage"`
+	MaxAttachmentBytes int64      `json:"maxAttachmentBytes,omitempty"`
+}
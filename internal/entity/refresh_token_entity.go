@@ -12,4 +12,16 @@ type RefreshToken struct {
 	IsRevoked    bool      `bson:"isRevoked" json:"isRevoked"`
 	DeviceInfo   string    `bson:"deviceInfo,omitempty" json:"deviceInfo,omitempty"`
 	IpAddress    string    `bson:"ipAddress,omitempty" json:"ipAddress,omitempty"`
+	// Client is the app the token was issued to, as it reported itself
+	Client ClientInfo `bson:"client,omitempty" json:"client,omitempty"`
+}
+
+// LoginSession is a signed in device of a user as listed to them, a refresh token without its value
+type LoginSession struct {
+	Id        string     `json:"id"`
+	Client    ClientInfo `json:"client"`
+	UserAgent string     `json:"userAgent,omitempty"`
+	IpAddress string     `json:"ipAddress,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
 }
\ No newline at end of file
@@ -0,0 +1,60 @@
+package entity
+
+import "time"
+
+const (
+	ReportTargetMessage = "message"
+	ReportTargetUser    = "user"
+)
+
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+const (
+	ReportDecisionResolve = "resolve"
+	ReportDecisionDismiss = "dismiss"
+)
+
+// Report is a message or a user reported by another user, waiting for an admin
+type Report struct {
+	Id         string `bson:"_id" json:"id"`
+	ReporterId string `bson:"reporterId" json:"reporterId"`
+	TargetType string `bson:"targetType" json:"targetType"` // "message" or "user"
+	// TargetId is the id of the reported message or user
+	TargetId string `bson:"targetId" json:"targetId"`
+	// ReportedUserId is the reported user, the sender of a reported message
+	ReportedUserId string `bson:"reportedUserId" json:"reportedUserId"`
+	ChatId         string `bson:"chatId,omitempty" json:"chatId,omitempty"`
+	Reason         string `bson:"reason" json:"reason"`
+	// Message is the reported message as it was when reported, it stays as evidence once the
+	// message is edited or deleted
+	Message *Message `bson:"message,omitempty" json:"message,omitempty"`
+
+	Status     string     `bson:"status" json:"status"`
+	ResolvedBy string     `bson:"resolvedBy,omitempty" json:"resolvedBy,omitempty"`
+	ResolvedAt *time.Time `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	Note       string     `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt  time.Time  `bson:"createdAt" json:"createdAt"`
+}
+
+// CreateReportRequest reports either a message or a user
+type CreateReportRequest struct {
+	MessageId string `json:"messageId,omitempty"`
+	UserId    string `json:"userId,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+type ReportFilter struct {
+	Status     string
+	TargetType string
+	Limit      int
+	Offset     int
+}
+
+type ResolveReportRequest struct {
+	Decision string `json:"decision"` // "resolve" or "dismiss"
+	Note     string `json:"note,omitempty"`
+}
@@ -0,0 +1,49 @@
+package entity
+
+import "time"
+
+const (
+	ScheduledMessageStatusPending = "pending"
+	// ScheduledMessageStatusSending is a message claimed by a server that is storing it
+	ScheduledMessageStatusSending  = "sending"
+	ScheduledMessageStatusSent     = "sent"
+	ScheduledMessageStatusFailed   = "failed"
+	ScheduledMessageStatusCanceled = "canceled"
+)
+
+// ScheduledMessage is a message its sender asked to send later, it is stored like any other
+// message once it is due
+type ScheduledMessage struct {
+	Id               string       `bson:"_id" json:"id"`
+	ChatId           string       `bson:"chatId" json:"chatId"`
+	SenderId         string       `bson:"senderId" json:"senderId"`
+	Message          string       `bson:"message" json:"message"`
+	Attachments      []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	ReplyToMessageId string       `bson:"replyToMessageId,omitempty" json:"replyToMessageId,omitempty"`
+	ScheduledAt      time.Time    `bson:"scheduledAt" json:"scheduledAt"`
+
+	Status string `bson:"status" json:"status"`
+	// ClaimedAt is when a server started sending the message, a claim older than a few minutes
+	// belongs to a server that died and is taken over
+	ClaimedAt *time.Time `bson:"claimedAt,omitempty" json:"-"`
+	// RetryAt delays a due message that could not be sent for a reason that may go away
+	RetryAt *time.Time `bson:"retryAt,omitempty" json:"-"`
+	// MessageId is the stored message once sent
+	MessageId string `bson:"messageId,omitempty" json:"messageId,omitempty"`
+	// Error is why a failed message could not be sent
+	Error     string    `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ToMessage is the message to store once the scheduled message is due
+func (s ScheduledMessage) ToMessage(now time.Time) Message {
+	return Message{
+		ChatId:           s.ChatId,
+		SenderId:         s.SenderId,
+		Message:          s.Message,
+		Timestamp:        now.UnixMilli(),
+		Attachments:      s.Attachments,
+		ReplyToMessageId: s.ReplyToMessageId,
+	}
+}
@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// Types of the authentication anomalies reported as security events
+const (
+	// SecurityEventNewClient is a successful login from an IP address or a device the account never signed in from
+	SecurityEventNewClient = "login_new_client"
+	// SecurityEventLoginFailures is an account reaching the failed login threshold
+	SecurityEventLoginFailures = "login_failures"
+	// SecurityEventRefreshTokenReuse is a revoked refresh token presented again, it was likely stolen
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+)
+
+// SecurityEvent is an authentication anomaly, it is sent to the security webhook of the operator
+// and optionally to the owner of the account by email
+type SecurityEvent struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	// UserId is empty for failed logins on an email no account uses
+	UserId    string `json:"userId,omitempty"`
+	Email     string `json:"email,omitempty"`
+	RemoteIp  string `json:"remoteIp,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+	// FailedAttempts is the number of failed logins within the window, for login_failures
+	FailedAttempts int       `json:"failedAttempts,omitempty"`
+	OccurredAt     time.Time `json:"occurredAt"`
+}
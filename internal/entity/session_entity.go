@@ -0,0 +1,32 @@
+package entity
+
+import "time"
+
+const (
+	SessionKindGuest  = "guest"
+	SessionKindWidget = "widget"
+)
+
+// Session is a short-lived identity, such as a guest chat token, kept apart from refresh tokens
+type Session struct {
+	Id          string            `json:"id"`
+	Kind        string            `json:"kind"`    // "guest" or "widget"
+	Subject     string            `json:"subject"` // Identity the session was issued for
+	DisplayName string            `json:"displayName,omitempty"`
+	WorkspaceId string            `json:"workspaceId,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	RemoteIp    string            `json:"remoteIp,omitempty"`
+	UserAgent   string            `json:"userAgent,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+type CreateSessionRequest struct {
+	Kind        string            `json:"kind"`
+	Subject     string            `json:"subject"`
+	DisplayName string            `json:"displayName,omitempty"`
+	WorkspaceId string            `json:"workspaceId,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	RemoteIp    string            `json:"-"`
+	UserAgent   string            `json:"-"`
+}
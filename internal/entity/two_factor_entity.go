@@ -0,0 +1,50 @@
+package entity
+
+import "time"
+
+// TwoFactor holds the TOTP settings of a user, the secret is pending until a first code is verified
+type TwoFactor struct {
+	UserId  string `bson:"_id" json:"-"`
+	Secret  string `bson:"secret" json:"-"`
+	Enabled bool   `bson:"enabled" json:"enabled"`
+	// RecoveryCodes are SHA-256 hashes, each code can be used once instead of a TOTP code
+	RecoveryCodes []string `bson:"recoveryCodes" json:"-"`
+	// LastUsedStep is the time step of the last accepted code, codes can't be replayed
+	LastUsedStep int64      `bson:"lastUsedStep" json:"-"`
+	CreatedAt    time.Time  `bson:"createdAt" json:"createdAt"`
+	EnabledAt    *time.Time `bson:"enabledAt,omitempty" json:"enabledAt,omitempty"`
+}
+
+// TwoFactorChallenge is the pending second login step of a user who passed the password check
+type TwoFactorChallenge struct {
+	Id        string    `bson:"_id" json:"-"` // Token handed to the client
+	UserId    string    `bson:"userId" json:"-"`
+	Attempts  int       `bson:"attempts" json:"-"`
+	CreatedAt time.Time `bson:"createdAt" json:"-"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"-"`
+	// Client is the app that passed the password check, the session is issued to it
+	Client ClientInfo `bson:"client,omitempty" json:"-"`
+}
+
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioningUri"`
+}
+
+type TwoFactorVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorEnabledResponse lists the recovery codes, they are only shown once
+type TwoFactorEnabledResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TwoFactorChallengeRequest completes a login with either a TOTP code or a recovery code
+type TwoFactorChallengeRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recoveryCode,omitempty"`
+	RemoteIp       string `json:"-"`
+	UserAgent      string `json:"-"`
+}
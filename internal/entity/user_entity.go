@@ -8,11 +8,111 @@ type User struct {
 	Email        string    `bson:"email" json:"email"`
 	Password     string    `bson:"password" json:"-"` // Don't expose password in JSON
 	Name         string    `bson:"name" json:"name"`
+	Bio          string    `bson:"bio,omitempty" json:"bio,omitempty"`
+	Avatar       string    `bson:"avatar,omitempty" json:"avatar,omitempty"`
 	IsOnline     bool      `bson:"isOnline" json:"isOnline"`
+	WorkspaceId  string    `bson:"workspaceId,omitempty" json:"workspaceId,omitempty"`
+	Role         string    `bson:"role,omitempty" json:"role,omitempty"` // "admin" of their workspace or empty
+	Birthdate    *time.Time `bson:"birthdate,omitempty" json:"-"`
+	IsRestricted bool      `bson:"isRestricted" json:"isRestricted"` // Minors get a stricter filter and no discovery
+	// Timezone (IANA name) and Locale (BCP 47 tag) shape the dates written for the user
+	Timezone     string    `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	Locale       string    `bson:"locale,omitempty" json:"locale,omitempty"`
+	// DeactivatedAt is set by a super admin, a deactivated user can't sign in anymore
+	DeactivatedAt *time.Time `bson:"deactivatedAt,omitempty" json:"deactivatedAt,omitempty"`
+	// MergedInto is the account this one was merged into, see AccountMerge
+	MergedInto string `bson:"mergedInto,omitempty" json:"mergedInto,omitempty"`
 	CreatedAt    time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt    time.Time `bson:"updatedAt" json:"updatedAt"`
 }
 
+const (
+	UserStatusOnline  = "online"
+	UserStatusOffline = "offline"
+)
+
+// PublicUser is the profile of a user as seen by other users
+type PublicUser struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Avatar   string `json:"avatar,omitempty"`
+	Bio      string `json:"bio,omitempty"`
+	Status   string `json:"status"`
+	// Email is only set when the profile policy exposes it
+	Email string `json:"email,omitempty"`
+}
+
+const (
+	// UserRoleAdmin manages the user's own workspace
+	UserRoleAdmin = "admin"
+	// UserRoleSuperAdmin operates the whole deployment (plans, back-office)
+	UserRoleSuperAdmin = "superadmin"
+)
+
+// GetWorkspaceId returns the workspace of the user, falling back to the default one
+func (u User) GetWorkspaceId() string {
+	if u.WorkspaceId == "" {
+		return DefaultWorkspaceId
+	}
+	return u.WorkspaceId
+}
+
+// Location returns the timezone of the user, UTC when unset or unknown
+func (u User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// AgeAt returns the age of the user at the given time, or -1 if the birthdate is unknown
+func (u User) AgeAt(now time.Time) int {
+	if u.Birthdate == nil {
+		return -1
+	}
+
+	// Birthdates are dates at midnight UTC, today is read in UTC as well
+	now = now.UTC()
+	age := now.Year() - u.Birthdate.Year()
+	if now.Month() < u.Birthdate.Month() || (now.Month() == u.Birthdate.Month() && now.Day() < u.Birthdate.Day()) {
+		age--
+	}
+	return age
+}
+
+type ComplianceStatus struct {
+	UserId       string `json:"userId"`
+	Username     string `json:"username"`
+	HasBirthdate bool   `json:"hasBirthdate"`
+	Age          int    `json:"age,omitempty"`
+	IsRestricted bool   `json:"isRestricted"`
+}
+
+// UpdateProfileRequest only changes the fields that are present
+type UpdateProfileRequest struct {
+	Name     *string `json:"name,omitempty"`
+	Username *string `json:"username,omitempty"`
+	Bio      *string `json:"bio,omitempty"`
+	Avatar   *string `json:"avatar,omitempty"`
+	// Timezone and Locale are reset to the defaults when set to an empty string
+	Timezone *string `json:"timezone,omitempty"`
+	Locale   *string `json:"locale,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+	// RefreshToken identifies the session to keep when the cookie isn't sent
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
 type UserIndexFilter struct {
 	Ids []string `bson:"ids"`
+	// Fields are the fields of the public profile to read, all of them when empty
+	Fields Fields `bson:"-"`
 }
\ No newline at end of file
@@ -0,0 +1,83 @@
+package entity
+
+import "time"
+
+// DefaultWorkspaceId is used for users and chats that don't belong to a specific workspace
+const DefaultWorkspaceId = "default"
+
+const (
+	ModerationActionReject = "reject"
+	// ModerationActionMask redacts the matched words, the rest of the message is stored
+	ModerationActionMask = "mask"
+	// ModerationActionRedact is accepted in the settings as another name of mask
+	ModerationActionRedact = "redact"
+	// ModerationActionFlag stores the message as is and queues it for an admin review
+	ModerationActionFlag = "flag"
+)
+
+// IsValidModerationAction reports whether the action is one of the stored moderation actions
+func IsValidModerationAction(action string) bool {
+	switch action {
+	case ModerationActionReject, ModerationActionMask, ModerationActionFlag:
+		return true
+	}
+	return false
+}
+
+type WorkspaceSettings struct {
+	WorkspaceId string             `bson:"_id" json:"workspaceId"`
+	Moderation  ModerationSettings `bson:"moderation" json:"moderation"`
+	Attachments AttachmentSettings `bson:"attachments" json:"attachments"`
+	// RetentionDays is how long messages are kept, 0 keeps them forever
+	RetentionDays int       `bson:"retentionDays" json:"retentionDays"`
+	UpdatedAt     time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+type ModerationSettings struct {
+	BlockedWords []string `bson:"blockedWords" json:"blockedWords"`
+	Action       string   `bson:"action" json:"action"` // "reject", "mask" or "flag"
+	// BlockProfanity adds the built-in profanity list to the blocked words
+	BlockProfanity bool `bson:"blockProfanity" json:"blockProfanity"`
+	// RestrictedBlockedWords are additionally blocked for restricted (minor) accounts
+	RestrictedBlockedWords []string `bson:"restrictedBlockedWords" json:"restrictedBlockedWords"`
+}
+
+type AttachmentSettings struct {
+	MaxSizeBytes     int64    `bson:"maxSizeBytes" json:"maxSizeBytes"`         // 0 means no limit
+	AllowedMimeTypes []string `bson:"allowedMimeTypes" json:"allowedMimeTypes"` // empty allows any type
+}
+
+type UpdateWorkspaceSettingsRequest struct {
+	Moderation    ModerationSettings `json:"moderation"`
+	Attachments   AttachmentSettings `json:"attachments"`
+	RetentionDays int                `json:"retentionDays"`
+}
+
+// DefaultWorkspaceSettings returns the settings used until a workspace configures its own
+func DefaultWorkspaceSettings(workspaceId string) WorkspaceSettings {
+	return WorkspaceSettings{
+		WorkspaceId: workspaceId,
+		Moderation: ModerationSettings{
+			BlockedWords:           []string{},
+			Action:                 ModerationActionReject,
+			RestrictedBlockedWords: []string{},
+		},
+		Attachments: AttachmentSettings{
+			MaxSizeBytes:     10 * 1024 * 1024,
+			AllowedMimeTypes: []string{},
+		},
+	}
+}
+
+// ForRestrictedUser returns the stricter settings applied to restricted accounts:
+// the extra word list and the profanity list are enforced and blocked content is always rejected
+func (s WorkspaceSettings) ForRestrictedUser() WorkspaceSettings {
+	blockedWords := make([]string, 0, len(s.Moderation.BlockedWords)+len(s.Moderation.RestrictedBlockedWords))
+	blockedWords = append(blockedWords, s.Moderation.BlockedWords...)
+	blockedWords = append(blockedWords, s.Moderation.RestrictedBlockedWords...)
+
+	s.Moderation.BlockedWords = blockedWords
+	s.Moderation.BlockProfanity = true
+	s.Moderation.Action = ModerationActionReject
+	return s
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRestrictionNotFound = errors.New("account restriction not found")
+)
+
+type AbuseRepository interface {
+	CreateSignal(ctx context.Context, signal entity.AbuseSignal) error
+	CountSignals(ctx context.Context, userId string, kind string, since time.Time) (int64, error)
+
+	GetRestriction(ctx context.Context, userId string) (entity.AccountRestriction, error)
+	SaveRestriction(ctx context.Context, restriction entity.AccountRestriction) error
+	// ClaimSendSlot records a message of a rate limited user, it returns false when the previous one is too recent
+	ClaimSendSlot(ctx context.Context, userId string, interval time.Duration) (bool, error)
+	// GetReviewQueue returns the restrictions waiting for an admin, oldest first
+	GetReviewQueue(ctx context.Context) ([]entity.AccountRestriction, error)
+}
+
+type abuseRepository struct {
+	db mongo.Database
+}
+
+func NewAbuseRepository(db mongo.Database) AbuseRepository {
+	return &abuseRepository{
+		db: db,
+	}
+}
+
+func (r *abuseRepository) CreateSignal(ctx context.Context, signal entity.AbuseSignal) error {
+	collection := r.db.Collection("abuse_signals")
+	signal.Id = uuid.New().String()
+	signal.CreatedAt = time.Now()
+
+	_, err := collection.InsertOne(ctx, signal)
+	return err
+}
+
+func (r *abuseRepository) CountSignals(ctx context.Context, userId string, kind string, since time.Time) (int64, error) {
+	collection := r.db.Collection("abuse_signals")
+	filter := bson.M{
+		"userId":    userId,
+		"kind":      kind,
+		"createdAt": bson.M{"$gte": since},
+	}
+
+	return collection.CountDocuments(ctx, filter)
+}
+
+func (r *abuseRepository) GetRestriction(ctx context.Context, userId string) (entity.AccountRestriction, error) {
+	collection := r.db.Collection("account_restrictions")
+	filter := bson.M{"_id": userId}
+
+	var restriction entity.AccountRestriction
+	err := collection.FindOne(ctx, filter).Decode(&restriction)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.AccountRestriction{}, ErrRestrictionNotFound
+		}
+		return entity.AccountRestriction{}, err
+	}
+
+	return restriction, nil
+}
+
+func (r *abuseRepository) SaveRestriction(ctx context.Context, restriction entity.AccountRestriction) error {
+	collection := r.db.Collection("account_restrictions")
+	filter := bson.M{"_id": restriction.UserId}
+	restriction.UpdatedAt = time.Now()
+
+	_, err := collection.ReplaceOne(ctx, filter, restriction, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *abuseRepository) ClaimSendSlot(ctx context.Context, userId string, interval time.Duration) (bool, error) {
+	collection := r.db.Collection("account_restrictions")
+	now := time.Now()
+	filter := bson.M{
+		"_id": userId,
+		"$or": bson.A{
+			bson.M{"lastMessageAt": bson.M{"$exists": false}},
+			bson.M{"lastMessageAt": bson.M{"$lte": now.Add(-interval)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"lastMessageAt": now}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+func (r *abuseRepository) GetReviewQueue(ctx context.Context) ([]entity.AccountRestriction, error) {
+	collection := r.db.Collection("account_restrictions")
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"reviewStatus": entity.ReviewStatusPending},
+			bson.M{"appeal.status": entity.AppealStatusPending},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: 1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	restrictions := []entity.AccountRestriction{}
+	if err := cursor.All(ctx, &restrictions); err != nil {
+		return nil, err
+	}
+
+	return restrictions, nil
+}
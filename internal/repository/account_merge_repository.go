@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAccountMergeNotFound = errors.New("account merge not found")
+)
+
+// mergedFromField lists, on every document an account merge moved, the accounts it was moved
+// from. It is a list so a document moved by merges on top of each other goes back one at a time
+const mergedFromField = "mergedFrom"
+
+// mergeMove hands the documents over to toUserId, remembering fromUserId
+func mergeMove(userField string, fromUserId string, toUserId string) bson.M {
+	return bson.M{
+		"$set":      bson.M{userField: toUserId},
+		"$addToSet": bson.M{mergedFromField: fromUserId},
+	}
+}
+
+// mergeRestoreFilter matches the documents moved from fromUserId that toUserId still holds
+func mergeRestoreFilter(userField string, fromUserId string, toUserId string) bson.M {
+	return bson.M{userField: toUserId, mergedFromField: fromUserId}
+}
+
+// mergeRestore gives the documents back to fromUserId
+func mergeRestore(userField string, fromUserId string) bson.M {
+	return bson.M{
+		"$set":  bson.M{userField: fromUserId},
+		"$pull": bson.M{mergedFromField: fromUserId},
+	}
+}
+
+type AccountMergeRepository interface {
+	Create(ctx context.Context, merge entity.AccountMerge) (entity.AccountMerge, error)
+	Get(ctx context.Context, mergeId string) (entity.AccountMerge, error)
+	// ListByUser returns the merges the user was the primary or the duplicate account of, newest first
+	ListByUser(ctx context.Context, userId string) ([]entity.AccountMerge, error)
+	// SetRolledBack closes a merge, it returns ErrAccountMergeNotFound when it is already rolled back
+	SetRolledBack(ctx context.Context, mergeId string, adminId string) (entity.AccountMerge, error)
+	Indexes() []CollectionIndexes
+}
+
+type accountMergeRepository struct {
+	db mongo.Database
+}
+
+func NewAccountMergeRepository(db mongo.Database) AccountMergeRepository {
+	return &accountMergeRepository{
+		db: db,
+	}
+}
+
+func (r *accountMergeRepository) Create(ctx context.Context, merge entity.AccountMerge) (entity.AccountMerge, error) {
+	collection := r.db.Collection("account_merges")
+	merge.Id = uuid.New().String()
+	merge.Status = entity.AccountMergeStatusMerged
+	merge.MergedAt = time.Now()
+
+	if _, err := collection.InsertOne(ctx, merge); err != nil {
+		return entity.AccountMerge{}, err
+	}
+	return merge, nil
+}
+
+func (r *accountMergeRepository) Get(ctx context.Context, mergeId string) (entity.AccountMerge, error) {
+	collection := r.db.Collection("account_merges")
+
+	var merge entity.AccountMerge
+	err := collection.FindOne(ctx, bson.M{"_id": mergeId}).Decode(&merge)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.AccountMerge{}, ErrAccountMergeNotFound
+		}
+		return entity.AccountMerge{}, err
+	}
+
+	return merge, nil
+}
+
+func (r *accountMergeRepository) ListByUser(ctx context.Context, userId string) ([]entity.AccountMerge, error) {
+	collection := r.db.Collection("account_merges")
+	filter := bson.M{"$or": bson.A{
+		bson.M{"primaryId": userId},
+		bson.M{"duplicateId": userId},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "mergedAt", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	merges := []entity.AccountMerge{}
+	if err := cursor.All(ctx, &merges); err != nil {
+		return nil, err
+	}
+
+	return merges, nil
+}
+
+func (r *accountMergeRepository) SetRolledBack(ctx context.Context, mergeId string, adminId string) (entity.AccountMerge, error) {
+	collection := r.db.Collection("account_merges")
+	filter := bson.M{"_id": mergeId, "status": entity.AccountMergeStatusMerged}
+	update := bson.M{"$set": bson.M{
+		"status":       entity.AccountMergeStatusRolledBack,
+		"rolledBackBy": adminId,
+		"rolledBackAt": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var merge entity.AccountMerge
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&merge)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.AccountMerge{}, ErrAccountMergeNotFound
+		}
+		return entity.AccountMerge{}, err
+	}
+
+	return merge, nil
+}
+
+// Indexes serve ListByUser
+func (r *accountMergeRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("account_merges"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "primaryId", Value: 1}}},
+			{Keys: bson.D{{Key: "duplicateId", Value: 1}}},
+		},
+	}}
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrQueryCanceled = errors.New("query canceled")
+	ErrQueryTimeout  = errors.New("query took too long")
+)
+
+// Time limits of the heavy aggregations, Mongo stops them once exceeded instead of finishing work
+// nobody waits for
+const (
+	chatIndexMaxTime      = 5 * time.Second
+	personalChatMaxTime   = 2 * time.Second
+	participantIdsMaxTime = 5 * time.Second
+	storageMaxTime        = time.Minute
+	unreadCountMaxTime    = time.Minute
+	deliveryStatsMaxTime  = 10 * time.Second
+)
+
+// aggregate runs a pipeline with a time limit and decodes every result. It doesn't start when the
+// caller already went away, and a canceled or timed out run wraps ErrQueryCanceled or ErrQueryTimeout
+func aggregate(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, maxTime time.Duration, results any) error {
+	if err := ctx.Err(); err != nil {
+		return queryError(ctx, err)
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(maxTime))
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if err := cursor.All(ctx, results); err != nil {
+		return queryError(ctx, err)
+	}
+	return nil
+}
+
+// queryError tells a query abandoned by its caller and one stopped by its time limit apart from
+// the other failures
+func queryError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", ErrQueryCanceled, err)
+	case mongo.IsTimeout(err):
+		return fmt.Errorf("%w: %w", ErrQueryTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AuditRepository interface {
+	Create(ctx context.Context, auditLog entity.AuditLog) error
+	GetByChatId(ctx context.Context, chatId string) ([]entity.AuditLog, error)
+}
+
+type auditRepository struct {
+	db mongo.Database
+}
+
+func NewAuditRepository(db mongo.Database) AuditRepository {
+	return &auditRepository{
+		db: db,
+	}
+}
+
+// Create stores a new audit record
+func (r *auditRepository) Create(ctx context.Context, auditLog entity.AuditLog) error {
+	collection := r.db.Collection("audit_logs")
+	auditLog.Id = uuid.New().String()
+	auditLog.CreatedAt = time.Now()
+
+	_, err := collection.InsertOne(ctx, auditLog)
+	return err
+}
+
+// GetByChatId returns the audit trail of a chat, newest first
+func (r *auditRepository) GetByChatId(ctx context.Context, chatId string) ([]entity.AuditLog, error) {
+	collection := r.db.Collection("audit_logs")
+	filter := bson.M{"chatId": chatId}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var auditLogs []entity.AuditLog
+	err = cursor.All(ctx, &auditLogs)
+	if err != nil {
+		return nil, err
+	}
+
+	return auditLogs, nil
+}
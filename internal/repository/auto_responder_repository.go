@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAutoResponderNotFound = errors.New("auto-responder not found")
+)
+
+type AutoResponderRepository interface {
+	Get(ctx context.Context, chatId string) (entity.AutoResponder, error)
+	Upsert(ctx context.Context, autoResponder entity.AutoResponder) error
+	Delete(ctx context.Context, chatId string) error
+}
+
+type autoResponderRepository struct {
+	db mongo.Database
+}
+
+func NewAutoResponderRepository(db mongo.Database) AutoResponderRepository {
+	return &autoResponderRepository{
+		db: db,
+	}
+}
+
+func (r *autoResponderRepository) Get(ctx context.Context, chatId string) (entity.AutoResponder, error) {
+	collection := r.db.Collection("auto_responders")
+	filter := bson.M{"_id": chatId}
+
+	var autoResponder entity.AutoResponder
+	err := collection.FindOne(ctx, filter).Decode(&autoResponder)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.AutoResponder{}, ErrAutoResponderNotFound
+		}
+		return entity.AutoResponder{}, err
+	}
+
+	return autoResponder, nil
+}
+
+func (r *autoResponderRepository) Upsert(ctx context.Context, autoResponder entity.AutoResponder) error {
+	collection := r.db.Collection("auto_responders")
+	filter := bson.M{"_id": autoResponder.ChatId}
+
+	_, err := collection.ReplaceOne(ctx, filter, autoResponder, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *autoResponderRepository) Delete(ctx context.Context, chatId string) error {
+	collection := r.db.Collection("auto_responders")
+	filter := bson.M{"_id": chatId}
+
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrAutoResponderNotFound
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -17,6 +18,11 @@ var (
 	ErrNotAdmin            = errors.New("user is not an admin")
 	ErrInvitationNotFound  = errors.New("invitation not found")
 	ErrPersonalChatExists  = errors.New("personal chat already exists")
+	ErrInviteLinkNotFound  = errors.New("invite link not found")
+	ErrJoinRequestNotFound = errors.New("join request not found")
+	ErrPinNotFound         = errors.New("pin not found")
+	ErrAlreadyPinned       = errors.New("message already pinned")
+	ErrPinLimitReached     = errors.New("pin limit reached")
 )
 
 type ChatRepository interface {
@@ -25,7 +31,26 @@ type ChatRepository interface {
 	Get(ctx context.Context, chatId string) (entity.Chat, error)
 	Create(ctx context.Context, chat entity.Chat) (string, error)
 	Update(ctx context.Context, chat entity.Chat) error
+	// Delete soft deletes the chat: its active participants and pending invitations are set aside
+	// along with it, Restore brings all of them back until Purge deletes them for good. Its messages
+	// are left as they are, they are read through the chat or the participations of their reader,
+	// which hide them once the chat is deleted. Run it in a transaction to set all of them aside or none
 	Delete(ctx context.Context, chatId string) error
+	// GetDeleted returns a chat that was deleted and not purged yet, the other queries skip them
+	GetDeleted(ctx context.Context, chatId string) (entity.Chat, error)
+	Restore(ctx context.Context, chatId string) error
+	// GetDeletedBefore returns up to limit chats deleted before the given time, oldest first
+	GetDeletedBefore(ctx context.Context, before time.Time, limit int64) ([]entity.Chat, error)
+	// Purge deletes a deleted chat with its participants, invitations, invite links, join requests,
+	// pins, pin count, membership changes and message sequence, its messages are deleted beforehand
+	Purge(ctx context.Context, chatId string) error
+	SetEmptySince(ctx context.Context, chatId string, emptySince *time.Time) error
+	// SetMessageTTL sets how many seconds the messages of the chat are kept, 0 keeps them forever
+	SetMessageTTL(ctx context.Context, chatId string, messageTTL int64) error
+	// SetPinLimit sets how many messages the chat can have pinned at once, 0 is the server limit
+	SetPinLimit(ctx context.Context, chatId string, pinLimit int) error
+	GetEmptyChatsBefore(ctx context.Context, before time.Time) ([]entity.Chat, error)
+	GetIdsByWorkspace(ctx context.Context, workspaceId string) ([]string, error)
 
 	// Participant operations
 	AddParticipants(ctx context.Context, chatParticipants []entity.ChatParticipant) error
@@ -34,6 +59,25 @@ type ChatRepository interface {
 	IsParticipant(ctx context.Context, userId, chatId string) (bool, error)
 	IsAdmin(ctx context.Context, userId, chatId string) (bool, error)
 	RemoveParticipant(ctx context.Context, userId, chatId string) error
+	UpdateParticipantRole(ctx context.Context, userId, chatId, role string) error
+	CountAdmins(ctx context.Context, chatId string) (int64, error)
+	CountParticipants(ctx context.Context, chatId string) (int64, error)
+	GetParticipantIdsByChats(ctx context.Context, chatIds []string) (map[string][]string, error)
+	GetMembershipChanges(ctx context.Context, chatId string, sinceVersion int64) ([]entity.MembershipChange, error)
+	GetParticipationsByUser(ctx context.Context, userId string) ([]entity.ChatParticipant, error)
+	// GetExpiredGuests returns the guests still active whose access ended before the given time
+	GetExpiredGuests(ctx context.Context, before time.Time, limit int64) ([]entity.ChatParticipant, error)
+	SetParticipantMuted(ctx context.Context, userId, chatId string, muted bool, mutedUntil *time.Time) error
+	SetParticipantPinned(ctx context.Context, userId, chatId string, pinnedAt *time.Time) error
+	SetParticipantArchived(ctx context.Context, userId, chatId string, archivedAt *time.Time) error
+	SetParticipantNotificationMode(ctx context.Context, userId, chatId, mode string) error
+	// SetThreadMuted mutes or unmutes the replies of a message of the chat for a participant
+	SetThreadMuted(ctx context.Context, userId, chatId, messageId string, muted bool) error
+	// MoveParticipations hands the chats of an account over to the one it is merged into. Where both
+	// take part the participation of the account is closed instead, it returns the chats of each kind
+	MoveParticipations(ctx context.Context, fromUserId, toUserId string) (moved []string, closed []string, err error)
+	// RestoreParticipations gives the participations MoveParticipations moved or closed back to their account
+	RestoreParticipations(ctx context.Context, fromUserId, toUserId string) error
 
 	// Personal chat operations
 	GetPersonalChatBetweenUsers(ctx context.Context, userId1, userId2 string) (entity.Chat, error)
@@ -41,9 +85,51 @@ type ChatRepository interface {
 	// Invitation operations
 	CreateInvitation(ctx context.Context, invitation entity.ChatInvitation) (string, error)
 	GetInvitation(ctx context.Context, invitationId string) (entity.ChatInvitation, error)
+	// GetPendingInvitations and GetPendingInvitationsByChat leave out the invitations past their expiry
 	GetPendingInvitations(ctx context.Context, userId string) ([]entity.ChatInvitation, error)
+	GetPendingInvitationsByChat(ctx context.Context, chatId string) ([]entity.ChatInvitation, error)
+	// UpdateInvitationStatus answers a pending invitation, it fails with ErrInvitationNotFound once
+	// the invitation was answered, cancelled or expired
 	UpdateInvitationStatus(ctx context.Context, invitationId, status string) error
 	GetInvitationByUserAndChat(ctx context.Context, userId, chatId string) (entity.ChatInvitation, error)
+	// ExpireInvitations marks the pending invitations past their expiry as expired, and the ones
+	// without an expiry created before createdBefore
+	ExpireInvitations(ctx context.Context, now time.Time, createdBefore time.Time) (int64, error)
+
+	// Invite link operations
+	CreateInviteLink(ctx context.Context, link entity.ChatInviteLink) (entity.ChatInviteLink, error)
+	GetInviteLink(ctx context.Context, linkId string) (entity.ChatInviteLink, error)
+	// GetActiveInviteLinks returns the links of a chat neither revoked nor expired, newest first
+	GetActiveInviteLinks(ctx context.Context, chatId string) ([]entity.ChatInviteLink, error)
+	// RevokeInviteLink fails with ErrInviteLinkNotFound when the chat has no such link left to revoke
+	RevokeInviteLink(ctx context.Context, chatId, linkId string) error
+
+	// Join request operations
+	CreateJoinRequest(ctx context.Context, request entity.ChatJoinRequest) (entity.ChatJoinRequest, error)
+	GetJoinRequest(ctx context.Context, requestId string) (entity.ChatJoinRequest, error)
+	// GetPendingJoinRequest returns the request of a user still waiting for an answer in a chat
+	GetPendingJoinRequest(ctx context.Context, userId, chatId string) (entity.ChatJoinRequest, error)
+	GetPendingJoinRequestsByChat(ctx context.Context, chatId string) ([]entity.ChatJoinRequest, error)
+	// UpdateJoinRequestStatus answers a pending request, it fails with ErrJoinRequestNotFound once
+	// the request was answered
+	UpdateJoinRequestStatus(ctx context.Context, requestId, status, respondedBy string) error
+
+	// Pinned message operations
+	// PinMessage fails with ErrAlreadyPinned, or with ErrPinLimitReached when the chat has limit pins
+	PinMessage(ctx context.Context, pin entity.MessagePin, limit int) (entity.MessagePin, error)
+	UnpinMessage(ctx context.Context, chatId, messageId string) error
+	// UnpinMessages drops the pins of deleted messages of the chat
+	UnpinMessages(ctx context.Context, chatId string, messageIds []string) error
+	// UnpinOlderThan drops the pins of the messages of the chats sent before the timestamp
+	UnpinOlderThan(ctx context.Context, chatIds []string, timestamp int64) error
+	// GetPins returns the unexpired pins of a chat in the order the admins gave them, the pins
+	// made since come first, the latest first
+	GetPins(ctx context.Context, chatId string) ([]entity.MessagePin, error)
+	// SetPinOrder gives the pins of the chat their position, in the order of the message ids
+	SetPinOrder(ctx context.Context, chatId string, messageIds []string) error
+	// GetExpiredPins returns up to limit pins that expired before the given time, the oldest first
+	GetExpiredPins(ctx context.Context, before time.Time, limit int64) ([]entity.MessagePin, error)
+	Indexes() []CollectionIndexes
 }
 
 type chatRepository struct {
@@ -69,16 +155,12 @@ func (r *chatRepository) Index(ctx context.Context, userId string) ([]entity.Cha
 	matchStage := bson.D{{Key: "$match", Value: bson.D{
 		{Key: "participants.userId", Value: userId},
 		{Key: "participants.isActive", Value: true},
+		{Key: "deletedAt", Value: bson.M{"$exists": false}},
 	}}}
 	sortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: "updatedAt", Value: -1}}}}
 
-	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{lookupStage, matchStage, sortStage})
-	if err != nil {
-		return nil, err
-	}
-
 	var chats []entity.Chat
-	err = cursor.All(ctx, &chats)
+	err := aggregate(ctx, collection, mongo.Pipeline{lookupStage, matchStage, sortStage}, chatIndexMaxTime, &chats)
 	if err != nil {
 		return nil, err
 	}
@@ -86,15 +168,15 @@ func (r *chatRepository) Index(ctx context.Context, userId string) ([]entity.Cha
 	return chats, nil
 }
 
-// Get returns a chat by ID
+// Get returns a chat by ID, a deleted chat is not found
 func (r *chatRepository) Get(ctx context.Context, chatId string) (entity.Chat, error) {
 	collection := r.db.Collection("chats")
-	filter := bson.M{"_id": chatId}
+	filter := bson.M{"_id": chatId, "deletedAt": bson.M{"$exists": false}}
 
 	var chat entity.Chat
 	err := collection.FindOne(ctx, filter).Decode(&chat)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.Chat{}, ErrChatNotFound
 		}
 		return entity.Chat{}, err
@@ -128,7 +210,11 @@ func (r *chatRepository) Update(ctx context.Context, chat entity.Chat) error {
 		"$set": bson.M{
 			"name":        chat.Name,
 			"description": chat.Description,
-			"updatedAt":   chat.UpdatedAt,
+			"avatar":        chat.Avatar,
+			"keepWhenEmpty": chat.KeepWhenEmpty,
+			"historyAccess": chat.HistoryAccess,
+			"joinPolicy":    chat.JoinPolicy,
+			"updatedAt":     chat.UpdatedAt,
 		},
 	}
 
@@ -136,24 +222,240 @@ func (r *chatRepository) Update(ctx context.Context, chat entity.Chat) error {
 	return err
 }
 
-// Delete deletes a chat
+// Delete sets the participants and invitations aside before the chat. Without a transaction a
+// delete that failed half way leaves the chat in place, for its creator to delete again
 func (r *chatRepository) Delete(ctx context.Context, chatId string) error {
+	now := time.Now()
+
+	participants := r.db.Collection("chat_participants")
+	_, err := participants.UpdateMany(ctx,
+		bson.M{"chatId": chatId, "isActive": true},
+		bson.M{"$set": bson.M{"isActive": false, "chatDeletedAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	invitations := r.db.Collection("chat_invitations")
+	_, err = invitations.UpdateMany(ctx,
+		bson.M{"chatId": chatId, "status": "pending"},
+		bson.M{"$set": bson.M{"status": "chat_deleted", "chatDeletedAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+
+	chats := r.db.Collection("chats")
+	filter := bson.M{"_id": chatId, "deletedAt": bson.M{"$exists": false}}
+	_, err = chats.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"deletedAt": now, "updatedAt": now}})
+	return err
+}
+
+func (r *chatRepository) GetDeleted(ctx context.Context, chatId string) (entity.Chat, error) {
+	collection := r.db.Collection("chats")
+	filter := bson.M{"_id": chatId, "deletedAt": bson.M{"$exists": true}}
+
+	var chat entity.Chat
+	err := collection.FindOne(ctx, filter).Decode(&chat)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+
+	return chat, nil
+}
+
+// Restore brings back the participants and invitations the delete set aside before the chat, the
+// ones that had left or answered before stay as they were
+func (r *chatRepository) Restore(ctx context.Context, chatId string) error {
+	participants := r.db.Collection("chat_participants")
+	_, err := participants.UpdateMany(ctx,
+		bson.M{"chatId": chatId, "chatDeletedAt": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"isActive": true}, "$unset": bson.M{"chatDeletedAt": ""}},
+	)
+	if err != nil {
+		return err
+	}
+
+	invitations := r.db.Collection("chat_invitations")
+	_, err = invitations.UpdateMany(ctx,
+		bson.M{"chatId": chatId, "status": "chat_deleted"},
+		bson.M{"$set": bson.M{"status": "pending"}, "$unset": bson.M{"chatDeletedAt": ""}},
+	)
+	if err != nil {
+		return err
+	}
+
+	chats := r.db.Collection("chats")
+	filter := bson.M{"_id": chatId, "deletedAt": bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{"deletedAt": ""}, "$set": bson.M{"updatedAt": time.Now()}}
+	result, err := chats.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrChatNotFound
+	}
+
+	return nil
+}
+
+func (r *chatRepository) GetDeletedBefore(ctx context.Context, before time.Time, limit int64) ([]entity.Chat, error) {
+	collection := r.db.Collection("chats")
+	filter := bson.M{"deletedAt": bson.M{"$lte": before}}
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: 1}}).SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var chats []entity.Chat
+	err = cursor.All(ctx, &chats)
+	if err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// Purge deletes the chat last, a purge that failed half way is run again
+func (r *chatRepository) Purge(ctx context.Context, chatId string) error {
+	for _, name := range []string{"chat_participants", "chat_invitations", "chat_invite_links", "chat_join_requests", "chat_pins", "membership_changes"} {
+		if _, err := r.db.Collection(name).DeleteMany(ctx, bson.M{"chatId": chatId}); err != nil {
+			return err
+		}
+	}
+	// The message sequence and the pin count of a chat are keyed by the chat itself
+	for _, name := range []string{"message_sequences", "chat_pin_counts"} {
+		if _, err := r.db.Collection(name).DeleteOne(ctx, bson.M{"_id": chatId}); err != nil {
+			return err
+		}
+	}
+
+	chats := r.db.Collection("chats")
+	_, err := chats.DeleteOne(ctx, bson.M{"_id": chatId, "deletedAt": bson.M{"$exists": true}})
+	return err
+}
+
+// SetEmptySince marks a chat as empty since the given time, nil clears the mark
+func (r *chatRepository) SetEmptySince(ctx context.Context, chatId string, emptySince *time.Time) error {
+	collection := r.db.Collection("chats")
+	filter := bson.M{"_id": chatId}
+
+	update := bson.M{"$unset": bson.M{"emptySince": ""}}
+	if emptySince != nil {
+		update = bson.M{"$set": bson.M{"emptySince": *emptySince}}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *chatRepository) SetPinLimit(ctx context.Context, chatId string, pinLimit int) error {
 	collection := r.db.Collection("chats")
 	filter := bson.M{"_id": chatId}
-	_, err := collection.DeleteOne(ctx, filter)
+
+	update := bson.M{
+		"$unset": bson.M{"pinLimit": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+	}
+	if pinLimit > 0 {
+		update = bson.M{"$set": bson.M{"pinLimit": pinLimit, "updatedAt": time.Now()}}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *chatRepository) SetMessageTTL(ctx context.Context, chatId string, messageTTL int64) error {
+	collection := r.db.Collection("chats")
+	filter := bson.M{"_id": chatId}
+
+	update := bson.M{
+		"$unset": bson.M{"messageTTL": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+	}
+	if messageTTL > 0 {
+		update = bson.M{"$set": bson.M{"messageTTL": messageTTL, "updatedAt": time.Now()}}
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
 	return err
 }
 
+// GetEmptyChatsBefore returns chats that have been empty since before the given time
+// and are not flagged to be kept
+func (r *chatRepository) GetEmptyChatsBefore(ctx context.Context, before time.Time) ([]entity.Chat, error) {
+	collection := r.db.Collection("chats")
+	filter := bson.M{
+		"emptySince":    bson.M{"$lte": before},
+		"keepWhenEmpty": bson.M{"$ne": true},
+		"deletedAt":     bson.M{"$exists": false},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var chats []entity.Chat
+	err = cursor.All(ctx, &chats)
+	if err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// GetIdsByWorkspace returns the IDs of all chats that belong to a workspace
+func (r *chatRepository) GetIdsByWorkspace(ctx context.Context, workspaceId string) ([]string, error) {
+	collection := r.db.Collection("chats")
+	filter := bson.M{"workspaceId": workspaceId, "deletedAt": bson.M{"$exists": false}}
+	if workspaceId == entity.DefaultWorkspaceId {
+		// Chats created before workspaces existed have no workspaceId
+		filter["workspaceId"] = bson.M{"$in": bson.A{nil, "", workspaceId}}
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var chats []entity.Chat
+	err = cursor.All(ctx, &chats)
+	if err != nil {
+		return nil, err
+	}
+
+	chatIds := make([]string, 0, len(chats))
+	for _, chat := range chats {
+		chatIds = append(chatIds, chat.Id)
+	}
+
+	return chatIds, nil
+}
+
 // AddParticipants adds participants to a chat
 func (r *chatRepository) AddParticipants(ctx context.Context, chatParticipants []entity.ChatParticipant) error {
 	collection := r.db.Collection("chat_participants")
 
 	var participants []interface{}
+	changes := make(map[string][]entity.MembershipChange)
 	for _, participant := range chatParticipants {
 		participant.Id = uuid.New().String()
 		participant.JoinedAt = time.Now()
 		participant.IsActive = true
 		participants = append(participants, participant)
+
+		changes[participant.ChatId] = append(changes[participant.ChatId], entity.MembershipChange{
+			ChatId: participant.ChatId,
+			UserId: participant.UserId,
+			Action: entity.MembershipChangeAdded,
+			Role:   participant.Role,
+		})
 	}
 
 	_, err := collection.InsertMany(ctx, participants)
@@ -161,16 +463,22 @@ func (r *chatRepository) AddParticipants(ctx context.Context, chatParticipants [
 		return err
 	}
 
+	for chatId, chatChanges := range changes {
+		if err := r.recordMembershipChanges(ctx, chatId, chatChanges); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // GetParticipants returns all participants of a chat
 func (r *chatRepository) GetParticipants(ctx context.Context, chatId string) ([]entity.ChatParticipant, error) {
 	collection := r.db.Collection("chat_participants")
-	filter := bson.M{
+	filter := unexpired(bson.M{
 		"chatId":   chatId,
 		"isActive": true,
-	}
+	})
 
 	cursor, err := collection.Find(ctx, filter)
 	if err != nil {
@@ -189,16 +497,16 @@ func (r *chatRepository) GetParticipants(ctx context.Context, chatId string) ([]
 // GetParticipantByUserAndChat returns a specific participant
 func (r *chatRepository) GetParticipantByUserAndChat(ctx context.Context, userId, chatId string) (entity.ChatParticipant, error) {
 	collection := r.db.Collection("chat_participants")
-	filter := bson.M{
+	filter := unexpired(bson.M{
 		"userId":   userId,
 		"chatId":   chatId,
 		"isActive": true,
-	}
+	})
 
 	var participant entity.ChatParticipant
 	err := collection.FindOne(ctx, filter).Decode(&participant)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.ChatParticipant{}, ErrNotParticipant
 		}
 		return entity.ChatParticipant{}, err
@@ -207,108 +515,540 @@ func (r *chatRepository) GetParticipantByUserAndChat(ctx context.Context, userId
 	return participant, nil
 }
 
-// IsParticipant checks if a user is a participant in a chat
-func (r *chatRepository) IsParticipant(ctx context.Context, userId, chatId string) (bool, error) {
+// GetParticipationsByUser returns the active participations of a user in every chat
+func (r *chatRepository) GetParticipationsByUser(ctx context.Context, userId string) ([]entity.ChatParticipant, error) {
 	collection := r.db.Collection("chat_participants")
-	filter := bson.M{
+	filter := unexpired(bson.M{
 		"userId":   userId,
-		"chatId":   chatId,
 		"isActive": true,
+	})
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
 	}
 
-	count, err := collection.CountDocuments(ctx, filter)
+	var participants []entity.ChatParticipant
+	err = cursor.All(ctx, &participants)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return count > 0, nil
+	return participants, nil
 }
 
-// IsAdmin checks if a user is an admin of a chat
-func (r *chatRepository) IsAdmin(ctx context.Context, userId, chatId string) (bool, error) {
+func (r *chatRepository) GetExpiredGuests(ctx context.Context, before time.Time, limit int64) ([]entity.ChatParticipant, error) {
 	collection := r.db.Collection("chat_participants")
 	filter := bson.M{
-		"userId":   userId,
-		"chatId":   chatId,
-		"isActive": true,
-		"role":     "admin",
+		"isActive":  true,
+		"expiresAt": bson.M{"$lte": before},
 	}
+	opts := options.Find().SetSort(bson.D{{Key: "expiresAt", Value: 1}}).SetLimit(limit)
 
-	count, err := collection.CountDocuments(ctx, filter)
+	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return count > 0, nil
+	var participants []entity.ChatParticipant
+	err = cursor.All(ctx, &participants)
+	if err != nil {
+		return nil, err
+	}
+
+	return participants, nil
 }
 
-// RemoveParticipant removes a participant from a chat
-func (r *chatRepository) RemoveParticipant(ctx context.Context, userId, chatId string) error {
+// unexpired adds to a filter that the documents must not be past their expiresAt, for the guests
+// and the pins the sweepers didn't remove yet
+func unexpired(filter bson.M) bson.M {
+	filter["$or"] = bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gt": time.Now()}},
+	}
+	return filter
+}
+
+// SetParticipantMuted mutes or unmutes a chat for a participant, a nil mutedUntil mutes it until unmuted
+func (r *chatRepository) SetParticipantMuted(ctx context.Context, userId, chatId string, muted bool, mutedUntil *time.Time) error {
 	collection := r.db.Collection("chat_participants")
 	filter := bson.M{
-		"userId": userId,
-		"chatId": chatId,
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"isActive": false,
-		},
+	update := bson.M{"$set": bson.M{"muted": muted}}
+	if mutedUntil != nil {
+		update["$set"].(bson.M)["mutedUntil"] = *mutedUntil
+	} else {
+		update["$unset"] = bson.M{"mutedUntil": ""}
 	}
 
-	_, err := collection.UpdateOne(ctx, filter, update)
-	return err
-}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotParticipant
+	}
 
-// GetPersonalChatBetweenUsers finds an existing personal chat between two users
-func (r *chatRepository) GetPersonalChatBetweenUsers(ctx context.Context, userId1, userId2 string) (entity.Chat, error) {
-	collection := r.db.Collection("chats")
+	return nil
+}
 
-	// Find chats where both users are participants and type is personal
-	lookupStage := bson.D{{Key: "$lookup", Value: bson.D{
-		{Key: "from", Value: "chat_participants"},
-		{Key: "localField", Value: "_id"},
-		{Key: "foreignField", Value: "chatId"},
-		{Key: "as", Value: "participants"},
-	}}}
+// SetParticipantPinned pins a chat for a participant, a nil pinnedAt unpins it
+func (r *chatRepository) SetParticipantPinned(ctx context.Context, userId, chatId string, pinnedAt *time.Time) error {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+	}
 
-	matchStage := bson.D{{Key: "$match", Value: bson.D{
-		{Key: "type", Value: entity.ChatTypePersonal},
-		{Key: "participants.userId", Value: bson.D{{Key: "$all", Value: bson.A{userId1, userId2}}}},
-	}}}
+	update := bson.M{"$unset": bson.M{"pinnedAt": ""}}
+	if pinnedAt != nil {
+		update = bson.M{"$set": bson.M{"pinnedAt": *pinnedAt}}
+	}
 
-	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{lookupStage, matchStage})
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return entity.Chat{}, err
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotParticipant
 	}
-	defer cursor.Close(ctx)
 
-	var chats []entity.Chat
-	if err := cursor.All(ctx, &chats); err != nil {
-		return entity.Chat{}, err
+	return nil
+}
+
+// SetParticipantArchived archives a chat for a participant, a nil archivedAt unarchives it
+func (r *chatRepository) SetParticipantArchived(ctx context.Context, userId, chatId string, archivedAt *time.Time) error {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
 	}
 
-	if len(chats) == 0 {
-		return entity.Chat{}, mongo.ErrNoDocuments
+	update := bson.M{"$unset": bson.M{"archivedAt": ""}}
+	if archivedAt != nil {
+		update = bson.M{"$set": bson.M{"archivedAt": *archivedAt}}
 	}
 
-	return chats[0], nil
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotParticipant
+	}
+
+	return nil
 }
 
-// CreateInvitation creates a new chat invitation
-func (r *chatRepository) CreateInvitation(ctx context.Context, invitation entity.ChatInvitation) (string, error) {
-	collection := r.db.Collection("chat_invitations")
+// SetParticipantNotificationMode sets how the messages of a chat notify a participant, NotificationModeAll is the default and not stored
+func (r *chatRepository) SetParticipantNotificationMode(ctx context.Context, userId, chatId, mode string) error {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+	}
 
-	invitation.Id = uuid.New().String()
-	invitation.Status = "pending"
-	invitation.CreatedAt = time.Now()
+	update := bson.M{"$unset": bson.M{"notificationMode": ""}}
+	if mode != entity.NotificationModeAll {
+		update = bson.M{"$set": bson.M{"notificationMode": mode}}
+	}
 
-	_, err := collection.InsertOne(ctx, invitation)
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotParticipant
 	}
 
-	return invitation.Id, nil
+	return nil
+}
+
+func (r *chatRepository) SetThreadMuted(ctx context.Context, userId, chatId, messageId string, muted bool) error {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+	}
+
+	update := bson.M{"$pull": bson.M{"mutedThreads": messageId}}
+	if muted {
+		update = bson.M{"$addToSet": bson.M{"mutedThreads": messageId}}
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotParticipant
+	}
+
+	return nil
+}
+
+func (r *chatRepository) MoveParticipations(ctx context.Context, fromUserId, toUserId string) ([]string, []string, error) {
+	collection := r.db.Collection("chat_participants")
+
+	participations, err := r.GetParticipationsByUser(ctx, fromUserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	joined, err := r.GetParticipationsByUser(ctx, toUserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	shared := make(map[string]bool, len(joined))
+	for _, participant := range joined {
+		shared[participant.ChatId] = true
+	}
+
+	// The participations of deleted chats are moved along, the chat has no membership to record
+	moved, closed := []string{}, []string{}
+	for _, participant := range participations {
+		changes := []entity.MembershipChange{{
+			ChatId: participant.ChatId,
+			UserId: fromUserId,
+			Action: entity.MembershipChangeRemoved,
+		}}
+
+		update := mergeMove("userId", fromUserId, toUserId)
+		if shared[participant.ChatId] {
+			update = bson.M{
+				"$set":      bson.M{"isActive": false},
+				"$addToSet": bson.M{mergedFromField: fromUserId},
+			}
+			closed = append(closed, participant.ChatId)
+		} else {
+			changes = append(changes, entity.MembershipChange{
+				ChatId: participant.ChatId,
+				UserId: toUserId,
+				Action: entity.MembershipChangeAdded,
+				Role:   participant.Role,
+			})
+			moved = append(moved, participant.ChatId)
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": participant.Id}, update); err != nil {
+			return nil, nil, err
+		}
+		if err := r.recordMembershipChanges(ctx, participant.ChatId, changes); err != nil && !errors.Is(err, ErrChatNotFound) {
+			return nil, nil, err
+		}
+	}
+
+	return moved, closed, nil
+}
+
+func (r *chatRepository) RestoreParticipations(ctx context.Context, fromUserId, toUserId string) error {
+	collection := r.db.Collection("chat_participants")
+
+	cursor, err := collection.Find(ctx, bson.M{mergedFromField: fromUserId})
+	if err != nil {
+		return err
+	}
+	var participations []entity.ChatParticipant
+	if err := cursor.All(ctx, &participations); err != nil {
+		return err
+	}
+
+	for _, participant := range participations {
+		var changes []entity.MembershipChange
+		var update bson.M
+		switch participant.UserId {
+		case toUserId:
+			update = mergeRestore("userId", fromUserId)
+			if participant.IsActive {
+				changes = []entity.MembershipChange{
+					{ChatId: participant.ChatId, UserId: toUserId, Action: entity.MembershipChangeRemoved},
+					{ChatId: participant.ChatId, UserId: fromUserId, Action: entity.MembershipChangeAdded, Role: participant.Role},
+				}
+			}
+		case fromUserId:
+			update = bson.M{
+				"$set":  bson.M{"isActive": true},
+				"$pull": bson.M{mergedFromField: fromUserId},
+			}
+			changes = []entity.MembershipChange{
+				{ChatId: participant.ChatId, UserId: fromUserId, Action: entity.MembershipChangeAdded, Role: participant.Role},
+			}
+		default:
+			// Moved on by a later merge, that one is rolled back first
+			continue
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": participant.Id}, update); err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		if err := r.recordMembershipChanges(ctx, participant.ChatId, changes); err != nil && !errors.Is(err, ErrChatNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsParticipant checks if a user is a participant in a chat
+func (r *chatRepository) IsParticipant(ctx context.Context, userId, chatId string) (bool, error) {
+	collection := r.db.Collection("chat_participants")
+	filter := unexpired(bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+	})
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// IsAdmin checks if a user is an admin of a chat
+func (r *chatRepository) IsAdmin(ctx context.Context, userId, chatId string) (bool, error) {
+	collection := r.db.Collection("chat_participants")
+	filter := unexpired(bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+		"role":     entity.ParticipantRoleAdmin,
+	})
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// RemoveParticipant removes a participant from a chat
+func (r *chatRepository) RemoveParticipant(ctx context.Context, userId, chatId string) error {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"isActive": false,
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	return r.recordMembershipChanges(ctx, chatId, []entity.MembershipChange{
+		{
+			ChatId: chatId,
+			UserId: userId,
+			Action: entity.MembershipChangeRemoved,
+		},
+	})
+}
+
+// UpdateParticipantRole changes the role of an active participant
+func (r *chatRepository) UpdateParticipantRole(ctx context.Context, userId, chatId, role string) error {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"userId":   userId,
+		"chatId":   chatId,
+		"isActive": true,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"role": role,
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	return r.recordMembershipChanges(ctx, chatId, []entity.MembershipChange{
+		{
+			ChatId: chatId,
+			UserId: userId,
+			Action: entity.MembershipChangeRoleUpdated,
+			Role:   role,
+		},
+	})
+}
+
+// recordMembershipChanges bumps the membership version of the chat and stores each change at its own version
+func (r *chatRepository) recordMembershipChanges(ctx context.Context, chatId string, changes []entity.MembershipChange) error {
+	chats := r.db.Collection("chats")
+	filter := bson.M{"_id": chatId}
+	update := bson.M{"$inc": bson.M{"membershipVersion": len(changes)}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var chat entity.Chat
+	err := chats.FindOneAndUpdate(ctx, filter, update, opts).Decode(&chat)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrChatNotFound
+		}
+		return err
+	}
+
+	now := time.Now()
+	firstVersion := chat.MembershipVersion - int64(len(changes)) + 1
+
+	var documents []interface{}
+	for i, change := range changes {
+		change.Id = uuid.New().String()
+		change.Version = firstVersion + int64(i)
+		change.CreatedAt = now
+		documents = append(documents, change)
+	}
+
+	_, err = r.db.Collection("membership_changes").InsertMany(ctx, documents)
+	return err
+}
+
+// GetMembershipChanges returns the membership changes of a chat after the given version, oldest first
+func (r *chatRepository) GetMembershipChanges(ctx context.Context, chatId string, sinceVersion int64) ([]entity.MembershipChange, error) {
+	collection := r.db.Collection("membership_changes")
+	filter := bson.M{
+		"chatId":  chatId,
+		"version": bson.M{"$gt": sinceVersion},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	changes := []entity.MembershipChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// GetParticipantIdsByChats returns the ids of the active participants of each chat
+func (r *chatRepository) GetParticipantIdsByChats(ctx context.Context, chatIds []string) (map[string][]string, error) {
+	collection := r.db.Collection("chat_participants")
+
+	matchStage := bson.D{{Key: "$match", Value: unexpired(bson.M{
+		"chatId":   bson.M{"$in": chatIds},
+		"isActive": true,
+	})}}
+	groupStage := bson.D{{Key: "$group", Value: bson.M{
+		"_id":     "$chatId",
+		"userIds": bson.M{"$push": "$userId"},
+	}}}
+
+	var groups []struct {
+		ChatId  string   `bson:"_id"`
+		UserIds []string `bson:"userIds"`
+	}
+	if err := aggregate(ctx, collection, mongo.Pipeline{matchStage, groupStage}, participantIdsMaxTime, &groups); err != nil {
+		return nil, err
+	}
+
+	userIdsByChat := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		userIdsByChat[group.ChatId] = group.UserIds
+	}
+
+	return userIdsByChat, nil
+}
+
+// CountAdmins returns the number of active admins in a chat
+func (r *chatRepository) CountAdmins(ctx context.Context, chatId string) (int64, error) {
+	collection := r.db.Collection("chat_participants")
+	filter := bson.M{
+		"chatId":   chatId,
+		"isActive": true,
+		"role":     entity.ParticipantRoleAdmin,
+	}
+
+	return collection.CountDocuments(ctx, filter)
+}
+
+// CountParticipants returns the number of active participants in a chat
+func (r *chatRepository) CountParticipants(ctx context.Context, chatId string) (int64, error) {
+	collection := r.db.Collection("chat_participants")
+	filter := unexpired(bson.M{
+		"chatId":   chatId,
+		"isActive": true,
+	})
+
+	return collection.CountDocuments(ctx, filter)
+}
+
+// GetPersonalChatBetweenUsers finds an existing personal chat between two users
+func (r *chatRepository) GetPersonalChatBetweenUsers(ctx context.Context, userId1, userId2 string) (entity.Chat, error) {
+	collection := r.db.Collection("chats")
+
+	// Find chats where both users are participants and type is personal
+	lookupStage := bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: "chat_participants"},
+		{Key: "localField", Value: "_id"},
+		{Key: "foreignField", Value: "chatId"},
+		{Key: "as", Value: "participants"},
+	}}}
+
+	matchStage := bson.D{{Key: "$match", Value: bson.D{
+		{Key: "type", Value: entity.ChatTypePersonal},
+		{Key: "participants.userId", Value: bson.D{{Key: "$all", Value: bson.A{userId1, userId2}}}},
+		{Key: "deletedAt", Value: bson.M{"$exists": false}},
+	}}}
+
+	var chats []entity.Chat
+	if err := aggregate(ctx, collection, mongo.Pipeline{lookupStage, matchStage}, personalChatMaxTime, &chats); err != nil {
+		return entity.Chat{}, err
+	}
+
+	if len(chats) == 0 {
+		return entity.Chat{}, ErrChatNotFound
+	}
+
+	return chats[0], nil
+}
+
+// CreateInvitation creates a new chat invitation
+func (r *chatRepository) CreateInvitation(ctx context.Context, invitation entity.ChatInvitation) (string, error) {
+	collection := r.db.Collection("chat_invitations")
+
+	invitation.Id = uuid.New().String()
+	invitation.Status = "pending"
+	invitation.CreatedAt = time.Now()
+
+	_, err := collection.InsertOne(ctx, invitation)
+	if err != nil {
+		return "", err
+	}
+
+	return invitation.Id, nil
 }
 
 // GetInvitation returns an invitation by ID
@@ -319,7 +1059,7 @@ func (r *chatRepository) GetInvitation(ctx context.Context, invitationId string)
 	var invitation entity.ChatInvitation
 	err := collection.FindOne(ctx, filter).Decode(&invitation)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.ChatInvitation{}, ErrInvitationNotFound
 		}
 		return entity.ChatInvitation{}, err
@@ -330,18 +1070,29 @@ func (r *chatRepository) GetInvitation(ctx context.Context, invitationId string)
 
 // GetPendingInvitations returns all pending invitations for a user
 func (r *chatRepository) GetPendingInvitations(ctx context.Context, userId string) ([]entity.ChatInvitation, error) {
-	collection := r.db.Collection("chat_invitations")
-	filter := bson.M{
+	return r.findInvitations(ctx, unexpiredInvitation(bson.M{
 		"inviteeId": userId,
 		"status":    "pending",
-	}
+	}))
+}
 
-	cursor, err := collection.Find(ctx, filter)
+// GetPendingInvitationsByChat returns the pending invitations of a chat, oldest first
+func (r *chatRepository) GetPendingInvitationsByChat(ctx context.Context, chatId string) ([]entity.ChatInvitation, error) {
+	return r.findInvitations(ctx, unexpiredInvitation(bson.M{
+		"chatId": chatId,
+		"status": "pending",
+	}), options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+}
+
+func (r *chatRepository) findInvitations(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]entity.ChatInvitation, error) {
+	collection := r.db.Collection("chat_invitations")
+
+	cursor, err := collection.Find(ctx, filter, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	var invitations []entity.ChatInvitation
+	invitations := []entity.ChatInvitation{}
 	err = cursor.All(ctx, &invitations)
 	if err != nil {
 		return nil, err
@@ -350,10 +1101,20 @@ func (r *chatRepository) GetPendingInvitations(ctx context.Context, userId strin
 	return invitations, nil
 }
 
-// UpdateInvitationStatus updates the status of an invitation
+// unexpiredInvitation adds to a filter of pending invitations that they must not be past their
+// expiry, the ones the sweeper didn't mark yet
+func unexpiredInvitation(filter bson.M) bson.M {
+	filter["$or"] = bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gt": time.Now()}},
+	}
+	return filter
+}
+
+// UpdateInvitationStatus updates the status of a pending invitation
 func (r *chatRepository) UpdateInvitationStatus(ctx context.Context, invitationId, status string) error {
 	collection := r.db.Collection("chat_invitations")
-	filter := bson.M{"_id": invitationId}
+	filter := bson.M{"_id": invitationId, "status": "pending"}
 	now := time.Now()
 
 	update := bson.M{
@@ -363,23 +1124,53 @@ func (r *chatRepository) UpdateInvitationStatus(ctx context.Context, invitationI
 		},
 	}
 
-	_, err := collection.UpdateOne(ctx, filter, update)
-	return err
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+func (r *chatRepository) ExpireInvitations(ctx context.Context, now time.Time, createdBefore time.Time) (int64, error) {
+	collection := r.db.Collection("chat_invitations")
+	filter := bson.M{
+		"status": "pending",
+		"$or": bson.A{
+			bson.M{"expiresAt": bson.M{"$lte": now}},
+			bson.M{"expiresAt": bson.M{"$exists": false}, "createdAt": bson.M{"$lt": createdBefore}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      "expired",
+			"respondedAt": time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
 }
 
 // GetInvitationByUserAndChat finds a pending invitation for a user in a chat
 func (r *chatRepository) GetInvitationByUserAndChat(ctx context.Context, userId, chatId string) (entity.ChatInvitation, error) {
 	collection := r.db.Collection("chat_invitations")
-	filter := bson.M{
+	filter := unexpiredInvitation(bson.M{
 		"inviteeId": userId,
 		"chatId":    chatId,
 		"status":    "pending",
-	}
+	})
 
 	var invitation entity.ChatInvitation
 	err := collection.FindOne(ctx, filter).Decode(&invitation)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.ChatInvitation{}, ErrInvitationNotFound
 		}
 		return entity.ChatInvitation{}, err
@@ -387,3 +1178,384 @@ func (r *chatRepository) GetInvitationByUserAndChat(ctx context.Context, userId,
 
 	return invitation, nil
 }
+
+// CreateInviteLink stores a new invite link of a chat
+func (r *chatRepository) CreateInviteLink(ctx context.Context, link entity.ChatInviteLink) (entity.ChatInviteLink, error) {
+	collection := r.db.Collection("chat_invite_links")
+
+	link.Id = uuid.New().String()
+	link.CreatedAt = time.Now()
+
+	if _, err := collection.InsertOne(ctx, link); err != nil {
+		return entity.ChatInviteLink{}, err
+	}
+
+	return link, nil
+}
+
+// GetInviteLink returns an invite link by ID, revoked and expired ones included
+func (r *chatRepository) GetInviteLink(ctx context.Context, linkId string) (entity.ChatInviteLink, error) {
+	collection := r.db.Collection("chat_invite_links")
+
+	var link entity.ChatInviteLink
+	err := collection.FindOne(ctx, bson.M{"_id": linkId}).Decode(&link)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.ChatInviteLink{}, ErrInviteLinkNotFound
+		}
+		return entity.ChatInviteLink{}, err
+	}
+
+	return link, nil
+}
+
+func (r *chatRepository) GetActiveInviteLinks(ctx context.Context, chatId string) ([]entity.ChatInviteLink, error) {
+	collection := r.db.Collection("chat_invite_links")
+	filter := bson.M{
+		"chatId":    chatId,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	links := []entity.ChatInviteLink{}
+	if err := cursor.All(ctx, &links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (r *chatRepository) RevokeInviteLink(ctx context.Context, chatId, linkId string) error {
+	collection := r.db.Collection("chat_invite_links")
+	filter := bson.M{"_id": linkId, "chatId": chatId, "revokedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revokedAt": time.Now()}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInviteLinkNotFound
+	}
+
+	return nil
+}
+
+// CreateJoinRequest stores a new pending join request
+func (r *chatRepository) CreateJoinRequest(ctx context.Context, request entity.ChatJoinRequest) (entity.ChatJoinRequest, error) {
+	collection := r.db.Collection("chat_join_requests")
+
+	request.Id = uuid.New().String()
+	request.Status = entity.JoinRequestStatusPending
+	request.CreatedAt = time.Now()
+
+	if _, err := collection.InsertOne(ctx, request); err != nil {
+		return entity.ChatJoinRequest{}, err
+	}
+
+	return request, nil
+}
+
+func (r *chatRepository) GetJoinRequest(ctx context.Context, requestId string) (entity.ChatJoinRequest, error) {
+	return r.findJoinRequest(ctx, bson.M{"_id": requestId})
+}
+
+func (r *chatRepository) GetPendingJoinRequest(ctx context.Context, userId, chatId string) (entity.ChatJoinRequest, error) {
+	return r.findJoinRequest(ctx, bson.M{
+		"userId": userId,
+		"chatId": chatId,
+		"status": entity.JoinRequestStatusPending,
+	})
+}
+
+func (r *chatRepository) findJoinRequest(ctx context.Context, filter bson.M) (entity.ChatJoinRequest, error) {
+	collection := r.db.Collection("chat_join_requests")
+
+	var request entity.ChatJoinRequest
+	err := collection.FindOne(ctx, filter).Decode(&request)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.ChatJoinRequest{}, ErrJoinRequestNotFound
+		}
+		return entity.ChatJoinRequest{}, err
+	}
+
+	return request, nil
+}
+
+// GetPendingJoinRequestsByChat returns the pending join requests of a chat, oldest first
+func (r *chatRepository) GetPendingJoinRequestsByChat(ctx context.Context, chatId string) ([]entity.ChatJoinRequest, error) {
+	collection := r.db.Collection("chat_join_requests")
+	filter := bson.M{"chatId": chatId, "status": entity.JoinRequestStatusPending}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []entity.ChatJoinRequest{}
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+func (r *chatRepository) UpdateJoinRequestStatus(ctx context.Context, requestId, status, respondedBy string) error {
+	collection := r.db.Collection("chat_join_requests")
+	filter := bson.M{"_id": requestId, "status": entity.JoinRequestStatusPending}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      status,
+			"respondedAt": time.Now(),
+			"respondedBy": respondedBy,
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJoinRequestNotFound
+	}
+
+	return nil
+}
+
+// PinMessage stores the pin before counting it. The pin count of a chat only goes up while it is
+// under the limit, so pins made at the same time can't go past it, and a pin that didn't fit goes again
+func (r *chatRepository) PinMessage(ctx context.Context, pin entity.MessagePin, limit int) (entity.MessagePin, error) {
+	collection := r.db.Collection("chat_pins")
+
+	pin.Id = uuid.New().String()
+	pin.PinnedAt = time.Now()
+
+	// The unique index of the pins keeps the first pin of a message
+	if _, err := collection.InsertOne(ctx, pin); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return entity.MessagePin{}, ErrAlreadyPinned
+		}
+		return entity.MessagePin{}, err
+	}
+
+	counted, err := r.countPin(ctx, pin.ChatId, limit)
+	if err == nil && !counted {
+		err = ErrPinLimitReached
+	}
+	if err != nil {
+		if _, deleteErr := collection.DeleteOne(ctx, bson.M{"_id": pin.Id}); deleteErr != nil {
+			return entity.MessagePin{}, deleteErr
+		}
+		return entity.MessagePin{}, err
+	}
+
+	return pin, nil
+}
+
+// countPin adds a pin to the pin count of the chat, it returns false when the count is at the limit
+func (r *chatRepository) countPin(ctx context.Context, chatId string, limit int) (bool, error) {
+	collection := r.db.Collection("chat_pin_counts")
+
+	// The count is created on its own, an upsert filtering on it would insert a second count of a
+	// chat at the limit
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": chatId},
+		bson.M{"$setOnInsert": bson.M{"count": 0}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": chatId, "count": bson.M{"$lt": limit}},
+		bson.M{"$inc": bson.M{"count": 1}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// uncountPins takes removed pins off the pin count of the chat
+func (r *chatRepository) uncountPins(ctx context.Context, chatId string, removed int64) error {
+	if removed == 0 {
+		return nil
+	}
+
+	collection := r.db.Collection("chat_pin_counts")
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": chatId}, bson.M{"$inc": bson.M{"count": -removed}})
+	return err
+}
+
+func (r *chatRepository) UnpinMessage(ctx context.Context, chatId, messageId string) error {
+	collection := r.db.Collection("chat_pins")
+
+	result, err := collection.DeleteOne(ctx, bson.M{"chatId": chatId, "messageId": messageId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrPinNotFound
+	}
+
+	return r.uncountPins(ctx, chatId, result.DeletedCount)
+}
+
+func (r *chatRepository) UnpinMessages(ctx context.Context, chatId string, messageIds []string) error {
+	if len(messageIds) == 0 {
+		return nil
+	}
+
+	collection := r.db.Collection("chat_pins")
+	result, err := collection.DeleteMany(ctx, bson.M{"chatId": chatId, "messageId": bson.M{"$in": messageIds}})
+	if err != nil {
+		return err
+	}
+
+	return r.uncountPins(ctx, chatId, result.DeletedCount)
+}
+
+// UnpinOlderThan looks the pins up first, there are few of them and they are counted per chat
+func (r *chatRepository) UnpinOlderThan(ctx context.Context, chatIds []string, timestamp int64) error {
+	collection := r.db.Collection("chat_pins")
+	filter := bson.M{"chatId": bson.M{"$in": chatIds}, "messageTimestamp": bson.M{"$lt": timestamp}}
+	opts := options.Find().SetProjection(bson.M{"chatId": 1, "messageId": 1})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+
+	var pins []entity.MessagePin
+	if err := cursor.All(ctx, &pins); err != nil {
+		return err
+	}
+
+	messageIds := map[string][]string{}
+	for _, pin := range pins {
+		messageIds[pin.ChatId] = append(messageIds[pin.ChatId], pin.MessageId)
+	}
+	for chatId, ids := range messageIds {
+		if err := r.UnpinMessages(ctx, chatId, ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *chatRepository) GetPins(ctx context.Context, chatId string) ([]entity.MessagePin, error) {
+	collection := r.db.Collection("chat_pins")
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "pinnedAt", Value: -1}})
+
+	cursor, err := collection.Find(ctx, unexpired(bson.M{"chatId": chatId}), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pins := []entity.MessagePin{}
+	if err := cursor.All(ctx, &pins); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+func (r *chatRepository) SetPinOrder(ctx context.Context, chatId string, messageIds []string) error {
+	if len(messageIds) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(messageIds))
+	for i, messageId := range messageIds {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"chatId": chatId, "messageId": messageId}).
+			SetUpdate(bson.M{"$set": bson.M{"position": i + 1}}))
+	}
+
+	collection := r.db.Collection("chat_pins")
+	_, err := collection.BulkWrite(ctx, models)
+	return err
+}
+
+func (r *chatRepository) GetExpiredPins(ctx context.Context, before time.Time, limit int64) ([]entity.MessagePin, error) {
+	collection := r.db.Collection("chat_pins")
+	filter := bson.M{"expiresAt": bson.M{"$lte": before}}
+	opts := options.Find().SetSort(bson.D{{Key: "expiresAt", Value: 1}}).SetLimit(limit)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var pins []entity.MessagePin
+	if err := cursor.All(ctx, &pins); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+// Indexes are the indexes backing participant lookups, the invitation inbox, the invite links,
+// join requests and pins of a chat and the purge of deleted chats
+func (r *chatRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{
+		{
+			Collection: r.db.Collection("chat_participants"),
+			Models: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "userId", Value: 1}}},
+				{Keys: bson.D{{Key: "userId", Value: 1}}},
+				// Only guests have an expiry
+				{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+			},
+		},
+		{
+			Collection: r.db.Collection("chats"),
+			Models: []mongo.IndexModel{
+				// Only deleted chats have a deletedAt
+				{Keys: bson.D{{Key: "deletedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+			},
+		},
+		{
+			Collection: r.db.Collection("chat_invitations"),
+			Models: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "inviteeId", Value: 1}, {Key: "status", Value: 1}}},
+				{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+				{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expiresAt", Value: 1}}},
+				{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "status", Value: 1}}},
+			},
+		},
+		{
+			Collection: r.db.Collection("chat_invite_links"),
+			Models: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			},
+		},
+		{
+			Collection: r.db.Collection("chat_join_requests"),
+			Models: []mongo.IndexModel{
+				{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "status", Value: 1}}},
+				{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "chatId", Value: 1}, {Key: "status", Value: 1}}},
+			},
+		},
+		{
+			Collection: r.db.Collection("chat_pins"),
+			Models: []mongo.IndexModel{
+				// A message is pinned once
+				{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "messageId", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "position", Value: 1}, {Key: "pinnedAt", Value: -1}}},
+				{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+			},
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrDocumentNotFound = errors.New("legal document not found")
+)
+
+type ConsentRepository interface {
+	CreateDocument(ctx context.Context, document entity.LegalDocument) (string, error)
+	GetLatestDocument(ctx context.Context, documentType string) (entity.LegalDocument, error)
+	CreateConsent(ctx context.Context, consent entity.ConsentRecord) error
+	HasAccepted(ctx context.Context, userId, documentType, version string) (bool, error)
+}
+
+type consentRepository struct {
+	db mongo.Database
+}
+
+func NewConsentRepository(db mongo.Database) ConsentRepository {
+	return &consentRepository{
+		db: db,
+	}
+}
+
+// CreateDocument publishes a new version of a legal document
+func (r *consentRepository) CreateDocument(ctx context.Context, document entity.LegalDocument) (string, error) {
+	collection := r.db.Collection("legal_documents")
+	document.Id = uuid.New().String()
+	document.PublishedAt = time.Now()
+
+	_, err := collection.InsertOne(ctx, document)
+	if err != nil {
+		return "", err
+	}
+
+	return document.Id, nil
+}
+
+// GetLatestDocument returns the most recently published version of a document type
+func (r *consentRepository) GetLatestDocument(ctx context.Context, documentType string) (entity.LegalDocument, error) {
+	collection := r.db.Collection("legal_documents")
+	filter := bson.M{"type": documentType}
+	opts := options.FindOne().SetSort(bson.D{{Key: "publishedAt", Value: -1}})
+
+	var document entity.LegalDocument
+	err := collection.FindOne(ctx, filter, opts).Decode(&document)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.LegalDocument{}, ErrDocumentNotFound
+		}
+		return entity.LegalDocument{}, err
+	}
+
+	return document, nil
+}
+
+// CreateConsent records that a user accepted a document version
+func (r *consentRepository) CreateConsent(ctx context.Context, consent entity.ConsentRecord) error {
+	collection := r.db.Collection("consents")
+	consent.Id = uuid.New().String()
+	consent.AcceptedAt = time.Now()
+
+	_, err := collection.InsertOne(ctx, consent)
+	return err
+}
+
+// HasAccepted checks if a user accepted a specific document version
+func (r *consentRepository) HasAccepted(ctx context.Context, userId, documentType, version string) (bool, error) {
+	collection := r.db.Collection("consents")
+	filter := bson.M{
+		"userId":       userId,
+		"documentType": documentType,
+		"version":      version,
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
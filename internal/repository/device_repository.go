@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+)
+
+type DeviceRepository interface {
+	// Upsert registers a push token, registering the same token again refreshes the existing device
+	Upsert(ctx context.Context, device entity.Device) (entity.Device, error)
+	GetByUser(ctx context.Context, userId string) ([]entity.Device, error)
+	Delete(ctx context.Context, deviceId string, userId string) error
+	// RecordFailure counts a failed push and deactivates the device once the failures in a row
+	// reach the threshold, deactivated reports whether this failure did
+	RecordFailure(ctx context.Context, deviceId string, failure string, threshold int) (device entity.Device, deactivated bool, err error)
+	// ResetFailures clears the failures of a device a push reached
+	ResetFailures(ctx context.Context, deviceId string) error
+	// Deactivate stops the pushes to an active device
+	Deactivate(ctx context.Context, deviceId string, reason string) error
+	Stats(ctx context.Context) (entity.DeviceDeliveryStats, error)
+	// DeleteDeactivatedBefore deletes the devices deactivated before the given time
+	DeleteDeactivatedBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type deviceRepository struct {
+	db mongo.Database
+}
+
+func NewDeviceRepository(db mongo.Database) DeviceRepository {
+	return &deviceRepository{
+		db: db,
+	}
+}
+
+func (r *deviceRepository) Upsert(ctx context.Context, device entity.Device) (entity.Device, error) {
+	collection := r.db.Collection("devices")
+	now := time.Now()
+
+	// A token moves to the user that registers it last, e.g. after a logout and login on the same phone
+	// and registering a token again gives a deactivated device a fresh start
+	filter := bson.M{"pushToken": device.PushToken}
+	update := bson.M{
+		"$set": bson.M{
+			"userId":    device.UserId,
+			"platform":  device.Platform,
+			"updatedAt": now,
+		},
+		"$unset": bson.M{
+			"failures":           "",
+			"lastFailure":        "",
+			"deactivatedAt":      "",
+			"deactivationReason": "",
+		},
+		"$setOnInsert": bson.M{
+			"_id":       uuid.New().String(),
+			"createdAt": now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var saved entity.Device
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved)
+	if err != nil {
+		return entity.Device{}, err
+	}
+
+	return saved, nil
+}
+
+func (r *deviceRepository) GetByUser(ctx context.Context, userId string) ([]entity.Device, error) {
+	collection := r.db.Collection("devices")
+	filter := bson.M{"userId": userId}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []entity.Device{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+func (r *deviceRepository) Delete(ctx context.Context, deviceId string, userId string) error {
+	collection := r.db.Collection("devices")
+	filter := bson.M{"_id": deviceId, "userId": userId}
+
+	result, err := collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrDeviceNotFound
+	}
+
+	return nil
+}
+
+func (r *deviceRepository) RecordFailure(ctx context.Context, deviceId string, failure string, threshold int) (entity.Device, bool, error) {
+	collection := r.db.Collection("devices")
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailure": failure},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var device entity.Device
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": deviceId}, update, opts).Decode(&device)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.Device{}, false, ErrDeviceNotFound
+		}
+		return entity.Device{}, false, err
+	}
+	if !device.IsActive() || device.Failures < threshold {
+		return device, false, nil
+	}
+
+	if err := r.Deactivate(ctx, deviceId, entity.DeviceDeactivatedFailing); err != nil {
+		return entity.Device{}, false, err
+	}
+	now := time.Now()
+	device.DeactivatedAt = &now
+	device.DeactivationReason = entity.DeviceDeactivatedFailing
+	return device, true, nil
+}
+
+func (r *deviceRepository) ResetFailures(ctx context.Context, deviceId string) error {
+	collection := r.db.Collection("devices")
+	filter := bson.M{"_id": deviceId, "failures": bson.M{"$gt": 0}}
+	update := bson.M{"$unset": bson.M{"failures": "", "lastFailure": ""}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *deviceRepository) Deactivate(ctx context.Context, deviceId string, reason string) error {
+	collection := r.db.Collection("devices")
+	filter := bson.M{"_id": deviceId, "deactivatedAt": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{
+		"deactivatedAt":      time.Now(),
+		"deactivationReason": reason,
+	}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *deviceRepository) Stats(ctx context.Context) (entity.DeviceDeliveryStats, error) {
+	collection := r.db.Collection("devices")
+	groupStage := bson.D{{Key: "$group", Value: bson.M{
+		"_id":   bson.M{"$ifNull": bson.A{"$deactivationReason", ""}},
+		"count": bson.M{"$sum": 1},
+		"failing": bson.M{"$sum": bson.M{
+			"$cond": bson.A{bson.M{"$gt": bson.A{"$failures", 0}}, 1, 0},
+		}},
+	}}}
+
+	var groups []struct {
+		Reason  string `bson:"_id"`
+		Count   int64  `bson:"count"`
+		Failing int64  `bson:"failing"`
+	}
+	if err := aggregate(ctx, collection, mongo.Pipeline{groupStage}, deliveryStatsMaxTime, &groups); err != nil {
+		return entity.DeviceDeliveryStats{}, err
+	}
+
+	stats := entity.DeviceDeliveryStats{Deactivated: map[string]int64{}}
+	for _, group := range groups {
+		if group.Reason == "" {
+			stats.Active = group.Count
+			stats.Failing = group.Failing
+			continue
+		}
+		stats.Deactivated[group.Reason] = group.Count
+	}
+	return stats, nil
+}
+
+func (r *deviceRepository) DeleteDeactivatedBefore(ctx context.Context, before time.Time) (int64, error) {
+	collection := r.db.Collection("devices")
+	filter := bson.M{"deactivatedAt": bson.M{"$lt": before}}
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmailBounceRepository tracks the addresses the mail relay refused, by lowercased address
+type EmailBounceRepository interface {
+	// RecordBounce counts a bounce of the address. A permanent one flags it at once, soft bounces
+	// once they reach the threshold in a row. It returns the bounces as updated
+	RecordBounce(ctx context.Context, email string, userId string, failure string, permanent bool, threshold int) (entity.EmailBounce, error)
+	// IsFlagged reports whether no email is sent to the address anymore
+	IsFlagged(ctx context.Context, email string) (bool, error)
+	// Clear forgets the bounces of an address, once an email reached it or an admin fixed it
+	Clear(ctx context.Context, email string) error
+	Stats(ctx context.Context) (entity.EmailDeliveryStats, error)
+	Indexes() []CollectionIndexes
+}
+
+type emailBounceRepository struct {
+	db mongo.Database
+}
+
+func NewEmailBounceRepository(db mongo.Database) EmailBounceRepository {
+	return &emailBounceRepository{
+		db: db,
+	}
+}
+
+func emailBounceId(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (r *emailBounceRepository) RecordBounce(ctx context.Context, email string, userId string, failure string, permanent bool, threshold int) (entity.EmailBounce, error) {
+	collection := r.db.Collection("email_bounces")
+	now := time.Now()
+
+	set := bson.M{
+		"userId":        userId,
+		"lastError":     failure,
+		"lastBouncedAt": now,
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"softBounces": 1}}
+	if permanent {
+		set["flaggedAt"] = now
+		update = bson.M{"$set": set, "$setOnInsert": bson.M{"softBounces": 0}}
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var bounce entity.EmailBounce
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": emailBounceId(email)}, update, opts).Decode(&bounce)
+	if err != nil {
+		return entity.EmailBounce{}, err
+	}
+
+	if !bounce.IsFlagged() && bounce.SoftBounces >= threshold {
+		filter := bson.M{"_id": bounce.Email, "flaggedAt": bson.M{"$exists": false}}
+		if _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"flaggedAt": now}}); err != nil {
+			return entity.EmailBounce{}, err
+		}
+		bounce.FlaggedAt = &now
+	}
+
+	return bounce, nil
+}
+
+func (r *emailBounceRepository) IsFlagged(ctx context.Context, email string) (bool, error) {
+	collection := r.db.Collection("email_bounces")
+	filter := bson.M{"_id": emailBounceId(email), "flaggedAt": bson.M{"$exists": true}}
+
+	err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *emailBounceRepository) Clear(ctx context.Context, email string) error {
+	collection := r.db.Collection("email_bounces")
+
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": emailBounceId(email)})
+	return err
+}
+
+func (r *emailBounceRepository) Stats(ctx context.Context) (entity.EmailDeliveryStats, error) {
+	collection := r.db.Collection("email_bounces")
+
+	flagged, err := collection.CountDocuments(ctx, bson.M{"flaggedAt": bson.M{"$exists": true}})
+	if err != nil {
+		return entity.EmailDeliveryStats{}, err
+	}
+	softBounced, err := collection.CountDocuments(ctx, bson.M{"flaggedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return entity.EmailDeliveryStats{}, err
+	}
+
+	return entity.EmailDeliveryStats{
+		Flagged:     flagged,
+		SoftBounced: softBounced,
+	}, nil
+}
+
+// Indexes serve Stats
+func (r *emailBounceRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("email_bounces"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "flaggedAt", Value: 1}}},
+		},
+	}}
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrFlaggedMessageNotFound = errors.New("flagged message not found")
+)
+
+type FlaggedMessageRepository interface {
+	Create(ctx context.Context, flagged entity.FlaggedMessage) (string, error)
+	Get(ctx context.Context, flagId string) (entity.FlaggedMessage, error)
+	// GetByStatus returns the flagged messages in the status, oldest first
+	GetByStatus(ctx context.Context, status string, limit int) ([]entity.FlaggedMessage, error)
+	// Review closes a pending flag, it returns ErrFlaggedMessageNotFound when it is already reviewed
+	Review(ctx context.Context, flagId string, status string, reviewerId string) (entity.FlaggedMessage, error)
+	Indexes() []CollectionIndexes
+}
+
+type flaggedMessageRepository struct {
+	db mongo.Database
+}
+
+func NewFlaggedMessageRepository(db mongo.Database) FlaggedMessageRepository {
+	return &flaggedMessageRepository{
+		db: db,
+	}
+}
+
+func (r *flaggedMessageRepository) Create(ctx context.Context, flagged entity.FlaggedMessage) (string, error) {
+	collection := r.db.Collection("flagged_messages")
+	flagged.Id = uuid.New().String()
+	flagged.Status = entity.FlagStatusPending
+	flagged.CreatedAt = time.Now()
+
+	if _, err := collection.InsertOne(ctx, flagged); err != nil {
+		return "", err
+	}
+	return flagged.Id, nil
+}
+
+func (r *flaggedMessageRepository) Get(ctx context.Context, flagId string) (entity.FlaggedMessage, error) {
+	collection := r.db.Collection("flagged_messages")
+
+	var flagged entity.FlaggedMessage
+	err := collection.FindOne(ctx, bson.M{"_id": flagId}).Decode(&flagged)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.FlaggedMessage{}, ErrFlaggedMessageNotFound
+		}
+		return entity.FlaggedMessage{}, err
+	}
+
+	return flagged, nil
+}
+
+func (r *flaggedMessageRepository) GetByStatus(ctx context.Context, status string, limit int) ([]entity.FlaggedMessage, error) {
+	collection := r.db.Collection("flagged_messages")
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	flagged := []entity.FlaggedMessage{}
+	if err := cursor.All(ctx, &flagged); err != nil {
+		return nil, err
+	}
+
+	return flagged, nil
+}
+
+func (r *flaggedMessageRepository) Review(ctx context.Context, flagId string, status string, reviewerId string) (entity.FlaggedMessage, error) {
+	collection := r.db.Collection("flagged_messages")
+	filter := bson.M{"_id": flagId, "status": entity.FlagStatusPending}
+	update := bson.M{"$set": bson.M{
+		"status":     status,
+		"reviewedBy": reviewerId,
+		"reviewedAt": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var flagged entity.FlaggedMessage
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&flagged)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.FlaggedMessage{}, ErrFlaggedMessageNotFound
+		}
+		return entity.FlaggedMessage{}, err
+	}
+
+	return flagged, nil
+}
+
+// Indexes serve the review queue in order
+func (r *flaggedMessageRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("flagged_messages"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		},
+	}}
+}
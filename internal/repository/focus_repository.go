@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// FocusRepository tracks which chat each connection currently has open, entries expire unless refreshed
+type FocusRepository interface {
+	// Focus moves the connection to the chat and returns the chat it was previously focused on, if any
+	Focus(ctx context.Context, userId string, connectionId string, chatId string, expiresAt time.Time) (string, error)
+	// Blur clears the focus of the connection and returns the chat it was focused on, if any
+	Blur(ctx context.Context, userId string, connectionId string) (string, error)
+	GetViewers(ctx context.Context, chatId string) ([]string, error)
+}
+
+type redisFocusRepository struct {
+	client *redis.Client
+}
+
+// NewRedisFocusRepository shares focus across servers
+func NewRedisFocusRepository(client *redis.Client) FocusRepository {
+	return &redisFocusRepository{
+		client: client,
+	}
+}
+
+func connectionFocusKey(connectionId string) string {
+	return "focus:conn:" + connectionId
+}
+
+func chatViewersKey(chatId string) string {
+	return "focus:chat:" + chatId
+}
+
+// viewerMember identifies a connection in the viewers set of a chat
+func viewerMember(userId string, connectionId string) string {
+	return userId + "|" + connectionId
+}
+
+func (r *redisFocusRepository) Focus(ctx context.Context, userId string, connectionId string, chatId string, expiresAt time.Time) (string, error) {
+	previous, err := r.Blur(ctx, userId, connectionId)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := time.Until(expiresAt)
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, connectionFocusKey(connectionId), chatId, ttl)
+	pipe.ZAdd(ctx, chatViewersKey(chatId), redis.Z{
+		Score:  float64(expiresAt.Unix()),
+		Member: viewerMember(userId, connectionId),
+	})
+	pipe.Expire(ctx, chatViewersKey(chatId), ttl)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return previous, nil
+}
+
+func (r *redisFocusRepository) Blur(ctx context.Context, userId string, connectionId string) (string, error) {
+	chatId, err := r.client.GetDel(ctx, connectionFocusKey(connectionId)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	err = r.client.ZRem(ctx, chatViewersKey(chatId), viewerMember(userId, connectionId)).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return chatId, nil
+}
+
+func (r *redisFocusRepository) GetViewers(ctx context.Context, chatId string) ([]string, error) {
+	key := chatViewersKey(chatId)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	// Drop connections that stopped refreshing their focus
+	if err := r.client.ZRemRangeByScore(ctx, key, "-inf", "("+now).Err(); err != nil {
+		return nil, err
+	}
+
+	members, err := r.client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return uniqueViewers(members), nil
+}
+
+type memFocusRepository struct {
+	mu          sync.Mutex
+	connections map[string]string               // connectionId -> chatId
+	viewers     map[string]map[string]time.Time // chatId -> member -> expiresAt
+}
+
+// NewMemFocusRepository keeps focus in memory, for single server deployments without Redis
+func NewMemFocusRepository() FocusRepository {
+	return &memFocusRepository{
+		connections: make(map[string]string),
+		viewers:     make(map[string]map[string]time.Time),
+	}
+}
+
+func (r *memFocusRepository) Focus(ctx context.Context, userId string, connectionId string, chatId string, expiresAt time.Time) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := r.blur(userId, connectionId)
+
+	r.connections[connectionId] = chatId
+	if r.viewers[chatId] == nil {
+		r.viewers[chatId] = make(map[string]time.Time)
+	}
+	r.viewers[chatId][viewerMember(userId, connectionId)] = expiresAt
+
+	return previous, nil
+}
+
+func (r *memFocusRepository) Blur(ctx context.Context, userId string, connectionId string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.blur(userId, connectionId), nil
+}
+
+// blur clears the focus of a connection, the caller must hold the lock
+func (r *memFocusRepository) blur(userId string, connectionId string) string {
+	chatId, ok := r.connections[connectionId]
+	if !ok {
+		return ""
+	}
+
+	delete(r.connections, connectionId)
+	delete(r.viewers[chatId], viewerMember(userId, connectionId))
+	if len(r.viewers[chatId]) == 0 {
+		delete(r.viewers, chatId)
+	}
+
+	return chatId
+}
+
+func (r *memFocusRepository) GetViewers(ctx context.Context, chatId string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	members := make([]string, 0, len(r.viewers[chatId]))
+	for member, expiresAt := range r.viewers[chatId] {
+		if now.After(expiresAt) {
+			delete(r.viewers[chatId], member)
+			continue
+		}
+		members = append(members, member)
+	}
+
+	return uniqueViewers(members), nil
+}
+
+// uniqueViewers returns the user ids of the viewer members, once per user
+func uniqueViewers(members []string) []string {
+	seen := make(map[string]bool)
+	userIds := []string{}
+	for _, member := range members {
+		userId, _, _ := strings.Cut(member, "|")
+		if !seen[userId] {
+			seen[userId] = true
+			userIds = append(userIds, userId)
+		}
+	}
+	return userIds
+}
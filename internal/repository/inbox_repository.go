@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InboxRepository interface {
+	Index(ctx context.Context, userId string, archived bool, fields entity.Fields) ([]entity.InboxEntry, error)
+	HasEntries(ctx context.Context, userId string) (bool, error)
+	Upsert(ctx context.Context, entry entity.InboxEntry) error
+	UpdateParticipantSettings(ctx context.Context, userId string, chatId string, pinnedAt *time.Time, archived bool) error
+	UpdateChatInfo(ctx context.Context, chatId string, name string, avatar string) error
+	ApplyMessage(ctx context.Context, chatId string, message entity.InboxMessage, quietIds []string, updatedAt time.Time) error
+	// ReplaceLastMessage swaps the last message of the entries showing one of messageIds, it is
+	// removed when replacement is nil
+	ReplaceLastMessage(ctx context.Context, chatId string, messageIds []string, replacement *entity.InboxMessage) error
+	DecrementUnread(ctx context.Context, userId string, chatId string) error
+	UpdateUnreadCount(ctx context.Context, userId string, chatId string, count int64) error
+	// GetUnreadCounts returns the entries with unread messages
+	GetUnreadCounts(ctx context.Context) ([]entity.UnreadCount, error)
+	// SetUnreadCount corrects the unread count of an entry left untouched since updatedBefore, it
+	// reports whether the count changed
+	SetUnreadCount(ctx context.Context, userId string, chatId string, count int64, updatedBefore time.Time) (bool, error)
+	Delete(ctx context.Context, userId string, chatId string) error
+	DeleteByChat(ctx context.Context, chatId string) error
+}
+
+type inboxRepository struct {
+	db mongo.Database
+}
+
+func NewInboxRepository(db mongo.Database) InboxRepository {
+	return &inboxRepository{
+		db: db,
+	}
+}
+
+// Index returns the archived or the regular chat list of a user, pinned chats then the most recently active first
+// inboxDerivedFields are the stored fields the computed fields of an entry are made from
+var inboxDerivedFields = map[string][]string{
+	"participantCount": {"chatId"},
+	"onlineCount":      {"chatId"},
+}
+
+func (r *inboxRepository) Index(ctx context.Context, userId string, archived bool, fields entity.Fields) ([]entity.InboxEntry, error) {
+	collection := r.db.Collection("inboxes")
+	filter := bson.M{"userId": userId, "isArchived": archived}
+	if !archived {
+		// Entries written before archiving existed have no flag
+		filter["isArchived"] = bson.M{"$ne": true}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "pinnedAt", Value: -1}, {Key: "updatedAt", Value: -1}})
+	if selected := projection(entity.InboxEntry{}, fields, inboxDerivedFields); selected != nil {
+		opts.SetProjection(selected)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []entity.InboxEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// HasEntries reports whether the read model of the user was built
+func (r *inboxRepository) HasEntries(ctx context.Context, userId string) (bool, error) {
+	collection := r.db.Collection("inboxes")
+
+	count, err := collection.CountDocuments(ctx, bson.M{"userId": userId}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Upsert creates the entry or refreshes its chat details, keeping its last message and unread count
+func (r *inboxRepository) Upsert(ctx context.Context, entry entity.InboxEntry) error {
+	collection := r.db.Collection("inboxes")
+	entry.Id = entity.InboxEntryId(entry.UserId, entry.ChatId)
+
+	filter := bson.M{"_id": entry.Id}
+	set := bson.M{
+		"userId":     entry.UserId,
+		"chatId":     entry.ChatId,
+		"type":       entry.Type,
+		"name":       entry.Name,
+		"avatar":     entry.Avatar,
+		"pinnedAt":   entry.PinnedAt,
+		"isArchived": entry.IsArchived,
+	}
+	setOnInsert := bson.M{
+		"unreadCount": entry.UnreadCount,
+		"updatedAt":   entry.UpdatedAt,
+	}
+	if entry.LastMessage != nil {
+		setOnInsert["lastMessage"] = entry.LastMessage
+	}
+
+	update := bson.M{"$set": set, "$setOnInsert": setOnInsert}
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// UpdateParticipantSettings mirrors the pin and archive state of the user's ChatParticipant
+func (r *inboxRepository) UpdateParticipantSettings(ctx context.Context, userId string, chatId string, pinnedAt *time.Time, archived bool) error {
+	collection := r.db.Collection("inboxes")
+	filter := bson.M{"_id": entity.InboxEntryId(userId, chatId)}
+	update := bson.M{"$set": bson.M{"pinnedAt": pinnedAt, "isArchived": archived}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// UpdateChatInfo renames group and channel entries, personal chat entries are named after the other user
+func (r *inboxRepository) UpdateChatInfo(ctx context.Context, chatId string, name string, avatar string) error {
+	collection := r.db.Collection("inboxes")
+	filter := bson.M{
+		"chatId": chatId,
+		"type":   bson.M{"$ne": entity.ChatTypePersonal},
+	}
+	update := bson.M{"$set": bson.M{"name": name, "avatar": avatar}}
+
+	_, err := collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// ApplyMessage sets the last message of every entry of the chat and counts it as unread for everyone
+// but the sender and the users in quietIds
+func (r *inboxRepository) ApplyMessage(ctx context.Context, chatId string, message entity.InboxMessage, quietIds []string, updatedAt time.Time) error {
+	collection := r.db.Collection("inboxes")
+
+	uncounted := append([]string{message.SenderId}, quietIds...)
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"chatId": chatId, "userId": bson.M{"$nin": uncounted}},
+		bson.M{
+			"$set": bson.M{"lastMessage": message, "updatedAt": updatedAt},
+			"$inc": bson.M{"unreadCount": 1},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.UpdateMany(ctx,
+		bson.M{"chatId": chatId, "userId": bson.M{"$in": uncounted}},
+		bson.M{"$set": bson.M{"lastMessage": message, "updatedAt": updatedAt}},
+	)
+	return err
+}
+
+func (r *inboxRepository) ReplaceLastMessage(ctx context.Context, chatId string, messageIds []string, replacement *entity.InboxMessage) error {
+	collection := r.db.Collection("inboxes")
+
+	update := bson.M{"$unset": bson.M{"lastMessage": ""}}
+	if replacement != nil {
+		update = bson.M{"$set": bson.M{"lastMessage": *replacement}}
+	}
+
+	_, err := collection.UpdateMany(ctx, bson.M{"chatId": chatId, "lastMessage.messageId": bson.M{"$in": messageIds}}, update)
+	return err
+}
+
+func (r *inboxRepository) DecrementUnread(ctx context.Context, userId string, chatId string) error {
+	collection := r.db.Collection("inboxes")
+	filter := bson.M{
+		"_id":         entity.InboxEntryId(userId, chatId),
+		"unreadCount": bson.M{"$gt": 0},
+	}
+	update := bson.M{"$inc": bson.M{"unreadCount": -1}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *inboxRepository) UpdateUnreadCount(ctx context.Context, userId string, chatId string, count int64) error {
+	collection := r.db.Collection("inboxes")
+	filter := bson.M{"_id": entity.InboxEntryId(userId, chatId)}
+	update := bson.M{"$set": bson.M{"unreadCount": count}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *inboxRepository) GetUnreadCounts(ctx context.Context) ([]entity.UnreadCount, error) {
+	collection := r.db.Collection("inboxes")
+	opts := options.Find().SetProjection(bson.M{"_id": 0, "userId": 1, "chatId": 1, "count": "$unreadCount"})
+
+	cursor, err := collection.Find(ctx, bson.M{"unreadCount": bson.M{"$gt": 0}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []entity.UnreadCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (r *inboxRepository) SetUnreadCount(ctx context.Context, userId string, chatId string, count int64, updatedBefore time.Time) (bool, error) {
+	collection := r.db.Collection("inboxes")
+	filter := bson.M{
+		"_id":         entity.InboxEntryId(userId, chatId),
+		"unreadCount": bson.M{"$ne": count},
+		"updatedAt":   bson.M{"$lt": updatedBefore},
+	}
+	update := bson.M{"$set": bson.M{"unreadCount": count}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *inboxRepository) Delete(ctx context.Context, userId string, chatId string) error {
+	collection := r.db.Collection("inboxes")
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": entity.InboxEntryId(userId, chatId)})
+	return err
+}
+
+func (r *inboxRepository) DeleteByChat(ctx context.Context, chatId string) error {
+	collection := r.db.Collection("inboxes")
+	_, err := collection.DeleteMany(ctx, bson.M{"chatId": chatId})
+	return err
+}
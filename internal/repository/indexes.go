@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionIndexes are the indexes a repository needs on one of its collections
+type CollectionIndexes struct {
+	Collection *mongo.Collection
+	Models     []mongo.IndexModel
+}
+
+// IndexedRepository declares the indexes its queries rely on
+type IndexedRepository interface {
+	Indexes() []CollectionIndexes
+}
+
+// EnsureIndexes creates the indexes of every repository. Creating an index that already exists
+// is a no-op, so it runs on every startup. It keeps going after a failure and reports them all
+func EnsureIndexes(ctx context.Context, repos ...IndexedRepository) error {
+	var errs []error
+	for _, repo := range repos {
+		for _, indexes := range repo.Indexes() {
+			if _, err := indexes.Collection.Indexes().CreateMany(ctx, indexes.Models); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", indexes.Collection.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MissingIndexes lists the indexes of the repositories that don't exist in the database, as
+// "collection.index_name" with the name Mongo gives an index by default. Indexes are compared on
+// their keys only, an index with the same keys and different options is not reported
+func MissingIndexes(ctx context.Context, repos ...IndexedRepository) ([]string, error) {
+	var missing []string
+	for _, repo := range repos {
+		for _, indexes := range repo.Indexes() {
+			specs, err := indexes.Collection.Indexes().ListSpecifications(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("list the indexes of %s: %w", indexes.Collection.Name(), err)
+			}
+
+			existing := make(map[string]bool, len(specs))
+			for _, spec := range specs {
+				var keys bson.D
+				if err := bson.Unmarshal(spec.KeysDocument, &keys); err != nil {
+					return nil, fmt.Errorf("read the index %s of %s: %w", spec.Name, indexes.Collection.Name(), err)
+				}
+				existing[indexName(keys)] = true
+			}
+
+			for _, model := range indexes.Models {
+				keys, ok := model.Keys.(bson.D)
+				if !ok {
+					continue
+				}
+				if name := indexName(keys); !existing[name] {
+					missing = append(missing, indexes.Collection.Name()+"."+name)
+				}
+			}
+		}
+	}
+	return missing, nil
+}
+
+// indexName is the default name of an index on the keys, such as chatId_1_timestamp_-1
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrLinkPreviewNotCached = errors.New("link preview not cached")
+)
+
+// LinkPreviewRepository caches the previews of the linked pages so a link shared in many chats
+// is read once. An empty preview remembers a page that could not be previewed
+type LinkPreviewRepository interface {
+	// Get returns ErrLinkPreviewNotCached when the page was not read lately
+	Get(ctx context.Context, url string) (entity.LinkPreview, error)
+	Set(ctx context.Context, url string, preview entity.LinkPreview, ttl time.Duration) error
+}
+
+// linkPreviewKey hashes the URL, links can be long and hold anything
+func linkPreviewKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "linkpreview:" + hex.EncodeToString(sum[:])
+}
+
+type redisLinkPreviewRepository struct {
+	client *redis.Client
+}
+
+func NewRedisLinkPreviewRepository(client *redis.Client) LinkPreviewRepository {
+	return &redisLinkPreviewRepository{
+		client: client,
+	}
+}
+
+func (r *redisLinkPreviewRepository) Get(ctx context.Context, url string) (entity.LinkPreview, error) {
+	encoded, err := r.client.Get(ctx, linkPreviewKey(url)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return entity.LinkPreview{}, ErrLinkPreviewNotCached
+	}
+	if err != nil {
+		return entity.LinkPreview{}, err
+	}
+
+	var preview entity.LinkPreview
+	if err := json.Unmarshal(encoded, &preview); err != nil {
+		return entity.LinkPreview{}, err
+	}
+	return preview, nil
+}
+
+func (r *redisLinkPreviewRepository) Set(ctx context.Context, url string, preview entity.LinkPreview, ttl time.Duration) error {
+	encoded, err := json.Marshal(preview)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, linkPreviewKey(url), encoded, ttl).Err()
+}
+
+type memLinkPreviewRepository struct {
+	cache *cache.MemCache
+}
+
+// NewMemLinkPreviewRepository only caches the previews read by this server
+func NewMemLinkPreviewRepository(cache *cache.MemCache) LinkPreviewRepository {
+	return &memLinkPreviewRepository{
+		cache: cache,
+	}
+}
+
+func (r *memLinkPreviewRepository) Get(ctx context.Context, url string) (entity.LinkPreview, error) {
+	cached, ok := r.cache.Get(linkPreviewKey(url))
+	if !ok {
+		return entity.LinkPreview{}, ErrLinkPreviewNotCached
+	}
+	return cached.(entity.LinkPreview), nil
+}
+
+func (r *memLinkPreviewRepository) Set(ctx context.Context, url string, preview entity.LinkPreview, ttl time.Duration) error {
+	r.cache.Set(linkPreviewKey(url), preview, ttl)
+	return nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maintenanceKey holds the maintenance mode of the deployment, it has no expiry
+const maintenanceKey = "server:maintenance"
+
+// MaintenanceRepository shares the maintenance mode between the servers, Get is called on every
+// API request while it is checked
+type MaintenanceRepository interface {
+	// Get returns a disabled maintenance mode when it was never set
+	Get(ctx context.Context) (entity.MaintenanceMode, error)
+	Set(ctx context.Context, mode entity.MaintenanceMode) error
+}
+
+type redisMaintenanceRepository struct {
+	client *redis.Client
+}
+
+func NewRedisMaintenanceRepository(client *redis.Client) MaintenanceRepository {
+	return &redisMaintenanceRepository{
+		client: client,
+	}
+}
+
+func (r *redisMaintenanceRepository) Get(ctx context.Context) (entity.MaintenanceMode, error) {
+	encoded, err := r.client.Get(ctx, maintenanceKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return entity.MaintenanceMode{}, nil
+	}
+	if err != nil {
+		return entity.MaintenanceMode{}, err
+	}
+
+	var mode entity.MaintenanceMode
+	if err := json.Unmarshal(encoded, &mode); err != nil {
+		return entity.MaintenanceMode{}, err
+	}
+	return mode, nil
+}
+
+func (r *redisMaintenanceRepository) Set(ctx context.Context, mode entity.MaintenanceMode) error {
+	encoded, err := json.Marshal(mode)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, maintenanceKey, encoded, 0).Err()
+}
+
+type memMaintenanceRepository struct {
+	mu   sync.RWMutex
+	mode entity.MaintenanceMode
+}
+
+// NewMemMaintenanceRepository only applies the maintenance mode to this server, it is lost on restart
+func NewMemMaintenanceRepository() MaintenanceRepository {
+	return &memMaintenanceRepository{}
+}
+
+func (r *memMaintenanceRepository) Get(ctx context.Context) (entity.MaintenanceMode, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.mode, nil
+}
+
+func (r *memMaintenanceRepository) Set(ctx context.Context, mode entity.MaintenanceMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mode = mode
+	return nil
+}
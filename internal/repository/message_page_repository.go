@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// messagePageVersionTTL keeps the version of a chat well past any fill reading it
+const messagePageVersionTTL = time.Hour
+
+// MessagePageRepository caches the latest page of messages of the active chats, newest first.
+// Every change to the messages of a chat bumps its version, a page read from the database before
+// a change is not stored after it
+type MessagePageRepository interface {
+	// Get returns the cached page, found is false on a miss
+	Get(ctx context.Context, chatId string) (messages []entity.Message, found bool, err error)
+	// Version returns the version of the messages of the chat, to read before loading a page
+	Version(ctx context.Context, chatId string) (int64, error)
+	// Set stores the page loaded at the version, it is dropped when the version moved since
+	Set(ctx context.Context, chatId string, version int64, messages []entity.Message, ttl time.Duration) error
+	// Invalidate drops the page and bumps the version
+	Invalidate(ctx context.Context, chatId string) error
+}
+
+type redisMessagePageRepository struct {
+	client *redis.Client
+}
+
+// NewRedisMessagePageRepository shares the pages and their invalidations between the servers
+func NewRedisMessagePageRepository(client *redis.Client) MessagePageRepository {
+	return &redisMessagePageRepository{
+		client: client,
+	}
+}
+
+func messagePageKey(chatId string) string {
+	return "msgpage:chat:" + chatId
+}
+
+func messagePageVersionKey(chatId string) string {
+	return "msgpage:chat:" + chatId + ":version"
+}
+
+func (r *redisMessagePageRepository) Get(ctx context.Context, chatId string) ([]entity.Message, bool, error) {
+	encoded, err := r.client.Get(ctx, messagePageKey(chatId)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var messages []entity.Message
+	if err := json.Unmarshal(encoded, &messages); err != nil {
+		return nil, false, err
+	}
+	return messages, true, nil
+}
+
+func (r *redisMessagePageRepository) Version(ctx context.Context, chatId string) (int64, error) {
+	version, err := r.client.Get(ctx, messagePageVersionKey(chatId)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *redisMessagePageRepository) Set(ctx context.Context, chatId string, version int64, messages []entity.Message, ttl time.Duration) error {
+	encoded, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	versionKey := messagePageVersionKey(chatId)
+	err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, versionKey).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != version {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, messagePageKey(chatId), encoded, ttl)
+			return nil
+		})
+		return err
+	}, versionKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		// The messages changed meanwhile, the next read loads the page again
+		return nil
+	}
+	return err
+}
+
+func (r *redisMessagePageRepository) Invalidate(ctx context.Context, chatId string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Incr(ctx, messagePageVersionKey(chatId))
+	pipe.Expire(ctx, messagePageVersionKey(chatId), messagePageVersionTTL)
+	pipe.Del(ctx, messagePageKey(chatId))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+type memMessagePageRepository struct {
+	// mu makes the version check of Set atomic, the cache expires the idle pages and versions
+	mu    sync.Mutex
+	cache *cache.MemCache
+}
+
+// NewMemMessagePageRepository caches the pages of this server only
+func NewMemMessagePageRepository(cache *cache.MemCache) MessagePageRepository {
+	return &memMessagePageRepository{
+		cache: cache,
+	}
+}
+
+func (r *memMessagePageRepository) Get(ctx context.Context, chatId string) ([]entity.Message, bool, error) {
+	cached, ok := r.cache.Get(messagePageKey(chatId))
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]entity.Message(nil), cached.([]entity.Message)...), true, nil
+}
+
+func (r *memMessagePageRepository) Version(ctx context.Context, chatId string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version(chatId), nil
+}
+
+func (r *memMessagePageRepository) Set(ctx context.Context, chatId string, version int64, messages []entity.Message, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.version(chatId) != version {
+		return nil
+	}
+	r.cache.Set(messagePageKey(chatId), append([]entity.Message(nil), messages...), ttl)
+	return nil
+}
+
+func (r *memMessagePageRepository) Invalidate(ctx context.Context, chatId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cache.Delete(messagePageKey(chatId))
+	r.cache.Set(messagePageVersionKey(chatId), r.version(chatId)+1, messagePageVersionTTL)
+	return nil
+}
+
+// version must be called with the lock held, a chat never invalidated is at version 0
+func (r *memMessagePageRepository) version(chatId string) int64 {
+	version, ok := r.cache.Get(messagePageVersionKey(chatId))
+	if !ok {
+		return 0
+	}
+	return version.(int64)
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrMessagePurgeNotFound = errors.New("message purge not found")
+)
+
+type MessagePurgeRepository interface {
+	Create(ctx context.Context, purge entity.MessagePurge) (entity.MessagePurge, error)
+	Get(ctx context.Context, purgeId string) (entity.MessagePurge, error)
+	// Claim claims the oldest pending purge for this server, or a purge whose claim was not renewed
+	// since staleBefore. It returns ErrMessagePurgeNotFound when there is none
+	Claim(ctx context.Context, now time.Time, staleBefore time.Time) (entity.MessagePurge, error)
+	// AddDeleted counts the messages of a batch and renews the claim of the purge
+	AddDeleted(ctx context.Context, purgeId string, deleted int64) (entity.MessagePurge, error)
+	Finish(ctx context.Context, purgeId string) (entity.MessagePurge, error)
+	Indexes() []CollectionIndexes
+}
+
+type messagePurgeRepository struct {
+	db mongo.Database
+}
+
+func NewMessagePurgeRepository(db mongo.Database) MessagePurgeRepository {
+	return &messagePurgeRepository{
+		db: db,
+	}
+}
+
+func (r *messagePurgeRepository) Create(ctx context.Context, purge entity.MessagePurge) (entity.MessagePurge, error) {
+	collection := r.db.Collection("message_purges")
+	now := time.Now()
+	purge.Id = uuid.New().String()
+	purge.Status = entity.MessagePurgeStatusPending
+	purge.CreatedAt = now
+	purge.UpdatedAt = now
+
+	if _, err := collection.InsertOne(ctx, purge); err != nil {
+		return entity.MessagePurge{}, err
+	}
+	return purge, nil
+}
+
+func (r *messagePurgeRepository) Get(ctx context.Context, purgeId string) (entity.MessagePurge, error) {
+	collection := r.db.Collection("message_purges")
+
+	var purge entity.MessagePurge
+	err := collection.FindOne(ctx, bson.M{"_id": purgeId}).Decode(&purge)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.MessagePurge{}, ErrMessagePurgeNotFound
+		}
+		return entity.MessagePurge{}, err
+	}
+
+	return purge, nil
+}
+
+func (r *messagePurgeRepository) Claim(ctx context.Context, now time.Time, staleBefore time.Time) (entity.MessagePurge, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": entity.MessagePurgeStatusPending},
+		bson.M{"status": entity.MessagePurgeStatusRunning, "claimedAt": bson.M{"$lt": staleBefore}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"status":    entity.MessagePurgeStatusRunning,
+		"claimedAt": now,
+		"updatedAt": now,
+	}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	return r.update(ctx, filter, update, opts)
+}
+
+func (r *messagePurgeRepository) AddDeleted(ctx context.Context, purgeId string, deleted int64) (entity.MessagePurge, error) {
+	now := time.Now()
+	filter := bson.M{"_id": purgeId, "status": entity.MessagePurgeStatusRunning}
+	update := bson.M{
+		"$inc": bson.M{"deleted": deleted},
+		"$set": bson.M{"claimedAt": now, "updatedAt": now},
+	}
+
+	return r.update(ctx, filter, update, options.FindOneAndUpdate())
+}
+
+func (r *messagePurgeRepository) Finish(ctx context.Context, purgeId string) (entity.MessagePurge, error) {
+	now := time.Now()
+	filter := bson.M{"_id": purgeId, "status": entity.MessagePurgeStatusRunning}
+	update := bson.M{
+		"$set":   bson.M{"status": entity.MessagePurgeStatusDone, "updatedAt": now, "finishedAt": now},
+		"$unset": bson.M{"claimedAt": ""},
+	}
+
+	return r.update(ctx, filter, update, options.FindOneAndUpdate())
+}
+
+// update returns the purge as it is after the update
+func (r *messagePurgeRepository) update(ctx context.Context, filter bson.M, update bson.M, opts *options.FindOneAndUpdateOptions) (entity.MessagePurge, error) {
+	collection := r.db.Collection("message_purges")
+	opts.SetReturnDocument(options.After)
+
+	var purge entity.MessagePurge
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&purge)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.MessagePurge{}, ErrMessagePurgeNotFound
+		}
+		return entity.MessagePurge{}, err
+	}
+
+	return purge, nil
+}
+
+// Indexes are the indexes backing the claims of the purges
+func (r *messagePurgeRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("message_purges"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+		},
+	}}
+}
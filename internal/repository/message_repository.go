@@ -2,21 +2,62 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"regexp"
+	"time"
 	"wetalk/internal/entity"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrDuplicateMessage is returned by Create when the sender already sent a message with the
+	// same ClientMessageId to the chat
+	ErrDuplicateMessage = errors.New("message already sent")
+)
+
 type MessageRepository interface {
 	Index(ctx context.Context, filter entity.MessageIndexFilter) ([]entity.Message, error)
 	Get(ctx context.Context, messageId string) (entity.Message, error)
-	Create(ctx context.Context, message entity.Message) (string, error)
+	// GetByIds returns the messages among the ids that exist, in no particular order
+	GetByIds(ctx context.Context, messageIds []string) ([]entity.Message, error)
+	// GetByClientMessageId returns the message the sender sent to the chat with the idempotency key
+	GetByClientMessageId(ctx context.Context, chatId string, senderId string, clientMessageId string) (entity.Message, error)
+	// Create stores the message with the next Seq of its chat and returns it as stored
+	Create(ctx context.Context, message entity.Message) (entity.Message, error)
 	Update(ctx context.Context, message entity.Message) error
 	Delete(ctx context.Context, messageId string) error
+	// SetLinkPreview attaches the preview of its first link to a message, it returns
+	// ErrMessageNotFound when the message is gone
+	SetLinkPreview(ctx context.Context, messageId string, preview entity.LinkPreview) error
 	GetByChatId(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, error)
+	DeleteOlderThan(ctx context.Context, chatIds []string, timestamp int64) (int64, error)
+	GetReplies(ctx context.Context, messageId string) ([]entity.Message, error)
+	GetNeighbours(ctx context.Context, message entity.Message, since int64, limit int, newer bool) ([]entity.Message, error)
+	GetStorageByWorkspace(ctx context.Context) ([]entity.WorkspaceStorage, error)
+	Search(ctx context.Context, filter entity.MessageSearchFilter) ([]entity.Message, error)
+	CountByChat(ctx context.Context, chatId string) (int64, error)
+	// CountBySenderSince counts the messages the user sent from the timestamp on
+	CountBySenderSince(ctx context.Context, senderId string, since int64) (int64, error)
+	// GetStorageBySender sums the size of the messages of the user, attachments included
+	GetStorageBySender(ctx context.Context, senderId string) (int64, error)
+	// DeleteExpired deletes up to limit messages whose expiresAt is before the time and returns them,
+	// only their id and chat are read
+	DeleteExpired(ctx context.Context, before time.Time, limit int) ([]entity.Message, error)
+	// CountMatching counts the messages of the chat a purge with the filter deletes
+	CountMatching(ctx context.Context, chatId string, filter entity.MessagePurgeFilter) (int64, error)
+	// DeleteMatching deletes up to limit messages of the chat matching the filter and returns their ids
+	DeleteMatching(ctx context.Context, chatId string, filter entity.MessagePurgeFilter, limit int) ([]string, error)
+	// MoveSender hands the messages of an account over to the one it is merged into
+	MoveSender(ctx context.Context, fromUserId string, toUserId string) (int64, error)
+	// RestoreSender gives the messages MoveSender moved back to their account
+	RestoreSender(ctx context.Context, fromUserId string, toUserId string) (int64, error)
+	Indexes() []CollectionIndexes
 }
 
 type messageRepository struct {
@@ -29,12 +70,28 @@ func NewMessageRepository(db mongo.Database) MessageRepository {
 	}
 }
 
+// chatOrder lists the messages of a chat newest first by their Seq, the clocks of the servers
+// storing them may disagree. The messages without one come last, ordered by time
+var chatOrder = bson.D{{Key: "seq", Value: -1}, {Key: "timestamp", Value: -1}}
+
+// messageDerivedFields are the stored fields the computed fields of a message are made from
+var messageDerivedFields = map[string][]string{
+	"sentAt":        {"timestamp"},
+	"deliveryState": {"senderId"},
+	"receipts":      {"senderId"},
+	"deliveredAt":   {"senderId"},
+	"readAt":        {"senderId"},
+}
+
 func (r *messageRepository) Index(ctx context.Context, filter entity.MessageIndexFilter) ([]entity.Message, error) {
 	collection := r.db.Collection("messages")
 
-	var bsonFilter bson.M
+	bsonFilter := bson.M{"$or": notExpired(time.Now())}
 	if filter.ChatId != "" {
-		bsonFilter = bson.M{"chatId": filter.ChatId}
+		bsonFilter["chatId"] = filter.ChatId
+	}
+	if filter.Since > 0 {
+		bsonFilter["timestamp"] = bson.M{"$gte": filter.Since}
 	}
 
 	opts := options.Find()
@@ -44,7 +101,14 @@ func (r *messageRepository) Index(ctx context.Context, filter entity.MessageInde
 	if filter.Offset > 0 {
 		opts.SetSkip(int64(filter.Offset))
 	}
-	opts.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if filter.ChatId != "" {
+		opts.SetSort(chatOrder)
+	} else {
+		opts.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	}
+	if selected := projection(entity.Message{}, filter.Fields, messageDerivedFields); selected != nil {
+		opts.SetProjection(selected)
+	}
 
 	cursor, err := collection.Find(ctx, bsonFilter, opts)
 	if err != nil {
@@ -67,22 +131,88 @@ func (r *messageRepository) Get(ctx context.Context, messageId string) (entity.M
 	var message entity.Message
 	err := collection.FindOne(ctx, filter).Decode(&message)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.Message{}, ErrMessageNotFound
+		}
 		return entity.Message{}, err
 	}
 
 	return message, nil
 }
 
-func (r *messageRepository) Create(ctx context.Context, message entity.Message) (string, error) {
+func (r *messageRepository) GetByIds(ctx context.Context, messageIds []string) ([]entity.Message, error) {
+	messages := []entity.Message{}
+	if len(messageIds) == 0 {
+		return messages, nil
+	}
+
+	collection := r.db.Collection("messages")
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": messageIds}})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *messageRepository) GetByClientMessageId(ctx context.Context, chatId string, senderId string, clientMessageId string) (entity.Message, error) {
+	collection := r.db.Collection("messages")
+	filter := bson.M{"chatId": chatId, "senderId": senderId, "clientMessageId": clientMessageId}
+
+	var message entity.Message
+	err := collection.FindOne(ctx, filter).Decode(&message)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.Message{}, ErrMessageNotFound
+		}
+		return entity.Message{}, err
+	}
+
+	return message, nil
+}
+
+func (r *messageRepository) Create(ctx context.Context, message entity.Message) (entity.Message, error) {
 	collection := r.db.Collection("messages")
 	message.Id = uuid.New().String()
+	message.HasLink = message.ContainsLink()
+
+	var err error
+	message.Seq, err = r.nextSeq(ctx, message.ChatId)
+	if err != nil {
+		return entity.Message{}, err
+	}
 
-	_, err := collection.InsertOne(ctx, message)
+	_, err = collection.InsertOne(ctx, message)
 	if err != nil {
-		return "", err
+		if mongo.IsDuplicateKeyError(err) {
+			return entity.Message{}, ErrDuplicateMessage
+		}
+		return entity.Message{}, err
 	}
 
-	return message.Id, nil
+	return message, nil
+}
+
+// nextSeq takes the next seq of the chat. The counter is incremented before the message is
+// inserted, so an insert that fails or a duplicate clientMessageId leaves a gap: seq values only
+// increase and can skip numbers. In a transaction the counter of the chat stays locked until it
+// commits, the messages of a chat are stored one after the other
+func (r *messageRepository) nextSeq(ctx context.Context, chatId string) (int64, error) {
+	collection := r.db.Collection("message_sequences")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var sequence struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": chatId}, bson.M{"$inc": bson.M{"seq": 1}}, opts).Decode(&sequence)
+	if err != nil {
+		return 0, err
+	}
+	return sequence.Seq, nil
 }
 
 func (r *messageRepository) Update(ctx context.Context, message entity.Message) error {
@@ -91,6 +221,7 @@ func (r *messageRepository) Update(ctx context.Context, message entity.Message)
 	update := bson.M{
 		"$set": bson.M{
 			"message":   message.Message,
+			"hasLink":   message.ContainsLink(),
 			"isRead":    message.IsRead,
 			"timestamp": message.Timestamp,
 		},
@@ -108,9 +239,44 @@ func (r *messageRepository) Delete(ctx context.Context, messageId string) error
 	return err
 }
 
+func (r *messageRepository) SetLinkPreview(ctx context.Context, messageId string, preview entity.LinkPreview) error {
+	collection := r.db.Collection("messages")
+	filter := bson.M{"_id": messageId}
+	update := bson.M{"$set": bson.M{"linkPreview": preview}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+func (r *messageRepository) MoveSender(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	collection := r.db.Collection("messages")
+
+	result, err := collection.UpdateMany(ctx, bson.M{"senderId": fromUserId}, mergeMove("senderId", fromUserId, toUserId))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *messageRepository) RestoreSender(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	collection := r.db.Collection("messages")
+
+	result, err := collection.UpdateMany(ctx, mergeRestoreFilter("senderId", fromUserId, toUserId), mergeRestore("senderId", fromUserId))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
 func (r *messageRepository) GetByChatId(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, error) {
 	collection := r.db.Collection("messages")
-	filter := bson.M{"chatId": chatId}
+	filter := bson.M{"chatId": chatId, "$or": notExpired(time.Now())}
 
 	opts := options.Find()
 	if limit > 0 {
@@ -119,7 +285,43 @@ func (r *messageRepository) GetByChatId(ctx context.Context, chatId string, limi
 	if offset > 0 {
 		opts.SetSkip(int64(offset))
 	}
-	opts.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	opts.SetSort(chatOrder)
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []entity.Message
+	err = cursor.All(ctx, &messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+// DeleteOlderThan removes messages of the given chats sent before the timestamp
+func (r *messageRepository) DeleteOlderThan(ctx context.Context, chatIds []string, timestamp int64) (int64, error) {
+	collection := r.db.Collection("messages")
+	filter := bson.M{
+		"chatId":    bson.M{"$in": chatIds},
+		"timestamp": bson.M{"$lt": timestamp},
+	}
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// GetReplies returns the direct replies to a message, oldest first
+func (r *messageRepository) GetReplies(ctx context.Context, messageId string) ([]entity.Message, error) {
+	collection := r.db.Collection("messages")
+	filter := bson.M{"replyToMessageId": messageId}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
 
 	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
@@ -133,4 +335,331 @@ func (r *messageRepository) GetByChatId(ctx context.Context, chatId string, limi
 	}
 
 	return messages, nil
-}
\ No newline at end of file
+}
+
+// GetStorageByWorkspace sums the stored messages and their size, attachments included, per workspace of their chat
+func (r *messageRepository) GetStorageByWorkspace(ctx context.Context) ([]entity.WorkspaceStorage, error) {
+	collection := r.db.Collection("messages")
+
+	chatStage := bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: "$chatId"},
+		{Key: "messages", Value: bson.D{{Key: "$sum", Value: 1}}},
+		{Key: "bytes", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$bsonSize", Value: "$$ROOT"}},
+			bson.D{{Key: "$sum", Value: "$attachments.size"}},
+		}}}}}},
+	}}}
+	lookupStage := bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: "chats"},
+		{Key: "localField", Value: "_id"},
+		{Key: "foreignField", Value: "_id"},
+		{Key: "as", Value: "chat"},
+	}}}
+	unwindStage := bson.D{{Key: "$unwind", Value: "$chat"}}
+	workspaceStage := bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$chat.workspaceId", ""}}}},
+		{Key: "messages", Value: bson.D{{Key: "$sum", Value: "$messages"}}},
+		{Key: "bytes", Value: bson.D{{Key: "$sum", Value: "$bytes"}}},
+	}}}
+
+	var storage []entity.WorkspaceStorage
+	err := aggregate(ctx, collection, mongo.Pipeline{chatStage, lookupStage, unwindStage, workspaceStage}, storageMaxTime, &storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// Search returns the messages of the filter scopes matching every set filter, newest first.
+// Without scopes nothing can be read and no message is returned
+func (r *messageRepository) Search(ctx context.Context, filter entity.MessageSearchFilter) ([]entity.Message, error) {
+	collection := r.db.Collection("messages")
+
+	scopes := bson.A{}
+	for _, scope := range filter.Scopes {
+		if len(scope.ChatIds) == 0 {
+			continue
+		}
+
+		clause := bson.M{"chatId": bson.M{"$in": scope.ChatIds}}
+		if scope.Since > 0 {
+			clause["timestamp"] = bson.M{"$gte": scope.Since}
+		}
+		scopes = append(scopes, clause)
+	}
+	if len(scopes) == 0 {
+		return []entity.Message{}, nil
+	}
+
+	conditions := bson.A{bson.M{"$or": scopes}, bson.M{"$or": notExpired(time.Now())}}
+	if filter.ChatId != "" {
+		conditions = append(conditions, bson.M{"chatId": filter.ChatId})
+	}
+	if filter.SenderId != "" {
+		conditions = append(conditions, bson.M{"senderId": filter.SenderId})
+	}
+	if filter.Before > 0 {
+		conditions = append(conditions, bson.M{"timestamp": bson.M{"$lt": filter.Before}})
+	}
+	if filter.After > 0 {
+		conditions = append(conditions, bson.M{"timestamp": bson.M{"$gt": filter.After}})
+	}
+	if filter.HasAttachment != nil {
+		conditions = append(conditions, bson.M{"attachments.0": bson.M{"$exists": *filter.HasAttachment}})
+	}
+	if filter.HasLink != nil {
+		if *filter.HasLink {
+			conditions = append(conditions, bson.M{"hasLink": true})
+		} else {
+			conditions = append(conditions, bson.M{"hasLink": bson.M{"$ne": true}})
+		}
+	}
+	if filter.Query != "" {
+		conditions = append(conditions, bson.M{"message": bson.M{
+			"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(filter.Query), Options: "i"},
+		}})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	if filter.Offset > 0 {
+		opts.SetSkip(int64(filter.Offset))
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"$and": conditions}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []entity.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *messageRepository) CountByChat(ctx context.Context, chatId string) (int64, error) {
+	collection := r.db.Collection("messages")
+	return collection.CountDocuments(ctx, bson.M{"chatId": chatId})
+}
+
+func (r *messageRepository) CountBySenderSince(ctx context.Context, senderId string, since int64) (int64, error) {
+	collection := r.db.Collection("messages")
+	return collection.CountDocuments(ctx, bson.M{"senderId": senderId, "timestamp": bson.M{"$gte": since}})
+}
+
+func (r *messageRepository) GetStorageBySender(ctx context.Context, senderId string) (int64, error) {
+	collection := r.db.Collection("messages")
+
+	matchStage := bson.D{{Key: "$match", Value: bson.D{{Key: "senderId", Value: senderId}}}}
+	groupStage := bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: nil},
+		{Key: "bytes", Value: bson.D{{Key: "$sum", Value: bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$bsonSize", Value: "$$ROOT"}},
+			bson.D{{Key: "$sum", Value: "$attachments.size"}},
+		}}}}}},
+	}}}
+
+	var storage []struct {
+		Bytes int64 `bson:"bytes"`
+	}
+	err := aggregate(ctx, collection, mongo.Pipeline{matchStage, groupStage}, storageMaxTime, &storage)
+	if err != nil {
+		return 0, err
+	}
+	if len(storage) == 0 {
+		return 0, nil
+	}
+
+	return storage[0].Bytes, nil
+}
+
+// GetNeighbours returns up to limit messages of the chat sent right after the message when newer is set,
+// or right before it otherwise, closest first. Messages are ordered by Seq, the ones stored before it
+// existed come first and are ordered by time then id
+func (r *messageRepository) GetNeighbours(ctx context.Context, message entity.Message, since int64, limit int, newer bool) ([]entity.Message, error) {
+	collection := r.db.Collection("messages")
+
+	operator, direction := "$lt", -1
+	if newer {
+		operator, direction = "$gt", 1
+	}
+
+	var position bson.M
+	switch {
+	case message.Seq > 0 && newer:
+		position = bson.M{"seq": bson.M{"$gt": message.Seq}}
+	case message.Seq > 0:
+		position = bson.M{"$or": bson.A{
+			bson.M{"seq": bson.M{"$lt": message.Seq}},
+			bson.M{"seq": bson.M{"$exists": false}},
+		}}
+	default:
+		conditions := bson.A{
+			bson.M{"seq": bson.M{"$exists": false}, "timestamp": bson.M{operator: message.Timestamp}},
+			bson.M{"seq": bson.M{"$exists": false}, "timestamp": message.Timestamp, "_id": bson.M{operator: message.Id}},
+		}
+		if newer {
+			conditions = append(conditions, bson.M{"seq": bson.M{"$exists": true}})
+		}
+		position = bson.M{"$or": conditions}
+	}
+
+	filter := bson.M{
+		"chatId": message.ChatId,
+		"$and": bson.A{
+			position,
+			bson.M{"$or": notExpired(time.Now())},
+		},
+	}
+	if since > 0 {
+		filter["timestamp"] = bson.M{"$gte": since}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "seq", Value: direction}, {Key: "timestamp", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []entity.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *messageRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) ([]entity.Message, error) {
+	collection := r.db.Collection("messages")
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1, "chatId": 1}).
+		SetSort(bson.D{{Key: "expiresAt", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, bson.M{"expiresAt": bson.M{"$lte": before}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []entity.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return messages, nil
+	}
+
+	messageIds := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageIds = append(messageIds, message.Id)
+	}
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": messageIds}}); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *messageRepository) CountMatching(ctx context.Context, chatId string, filter entity.MessagePurgeFilter) (int64, error) {
+	collection := r.db.Collection("messages")
+	return collection.CountDocuments(ctx, purgeFilter(chatId, filter))
+}
+
+func (r *messageRepository) DeleteMatching(ctx context.Context, chatId string, filter entity.MessagePurgeFilter, limit int) ([]string, error) {
+	collection := r.db.Collection("messages")
+	opts := options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, purgeFilter(chatId, filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []entity.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	messageIds := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageIds = append(messageIds, message.Id)
+	}
+	if len(messageIds) == 0 {
+		return messageIds, nil
+	}
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": messageIds}}); err != nil {
+		return nil, err
+	}
+
+	return messageIds, nil
+}
+
+// purgeFilter matches the messages of the chat selected by a purge filter
+func purgeFilter(chatId string, filter entity.MessagePurgeFilter) bson.M {
+	query := bson.M{"chatId": chatId}
+	if filter.SenderId != "" {
+		query["senderId"] = filter.SenderId
+	}
+	timestamp := bson.M{}
+	if filter.After > 0 {
+		timestamp["$gt"] = filter.After
+	}
+	if filter.Before > 0 {
+		timestamp["$lt"] = filter.Before
+	}
+	if len(timestamp) > 0 {
+		query["timestamp"] = timestamp
+	}
+	if filter.HasLink {
+		query["hasLink"] = true
+	}
+	return query
+}
+
+// notExpired matches the messages that have not disappeared at the time, the sweeper deletes the
+// expired ones a few seconds late
+func notExpired(now time.Time) bson.A {
+	return bson.A{
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"expiresAt": bson.M{"$gt": now}},
+	}
+}
+
+// Indexes are the indexes backing chat history and message searches
+func (r *messageRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("messages"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "timestamp", Value: -1}}},
+			{Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "seq", Value: -1}, {Key: "timestamp", Value: -1}}},
+			{Keys: bson.D{{Key: "senderId", Value: 1}, {Key: "chatId", Value: 1}, {Key: "timestamp", Value: -1}}},
+			{Keys: bson.D{{Key: "senderId", Value: 1}, {Key: "timestamp", Value: -1}}},
+			{
+				Keys:    bson.D{{Key: "chatId", Value: 1}, {Key: "hasLink", Value: 1}, {Key: "timestamp", Value: -1}},
+				Options: options.Index().SetPartialFilterExpression(bson.M{"hasLink": true}),
+			},
+			// Retried messages are stored once per idempotency key
+			{
+				Keys: bson.D{{Key: "chatId", Value: 1}, {Key: "senderId", Value: 1}, {Key: "clientMessageId", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"clientMessageId": bson.M{"$type": "string"}}),
+			},
+			// The sweeper deletes the disappearing messages and tells the clients, the TTL monitor
+			// only removes the ones it missed for an hour
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(int32(time.Hour / time.Second)),
+			},
+		},
+	}}
+}
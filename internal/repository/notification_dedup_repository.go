@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"wetalk/infrastructure/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NotificationDedupRepository coordinates push notifications between servers so a message
+// is pushed at most once per device, and not at all once a connected device acked it
+type NotificationDedupRepository interface {
+	MarkAcked(ctx context.Context, userId string, messageId string, ttl time.Duration) error
+	IsAcked(ctx context.Context, userId string, messageId string) (bool, error)
+	// ClaimPush returns true for the single caller allowed to push the message to the device
+	ClaimPush(ctx context.Context, deviceId string, messageId string, ttl time.Duration) (bool, error)
+}
+
+type redisNotificationDedupRepository struct {
+	client *redis.Client
+}
+
+func NewRedisNotificationDedupRepository(client *redis.Client) NotificationDedupRepository {
+	return &redisNotificationDedupRepository{
+		client: client,
+	}
+}
+
+func notificationAckKey(userId string, messageId string) string {
+	return "notify:ack:" + userId + ":" + messageId
+}
+
+func notificationPushKey(deviceId string, messageId string) string {
+	return "notify:push:" + deviceId + ":" + messageId
+}
+
+func (r *redisNotificationDedupRepository) MarkAcked(ctx context.Context, userId string, messageId string, ttl time.Duration) error {
+	return r.client.Set(ctx, notificationAckKey(userId, messageId), 1, ttl).Err()
+}
+
+func (r *redisNotificationDedupRepository) IsAcked(ctx context.Context, userId string, messageId string) (bool, error) {
+	count, err := r.client.Exists(ctx, notificationAckKey(userId, messageId)).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *redisNotificationDedupRepository) ClaimPush(ctx context.Context, deviceId string, messageId string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, notificationPushKey(deviceId, messageId), 1, ttl).Result()
+}
+
+type memNotificationDedupRepository struct {
+	cache *cache.MemCache
+}
+
+// NewMemNotificationDedupRepository only dedups within this server
+func NewMemNotificationDedupRepository(cache *cache.MemCache) NotificationDedupRepository {
+	return &memNotificationDedupRepository{
+		cache: cache,
+	}
+}
+
+func (r *memNotificationDedupRepository) MarkAcked(ctx context.Context, userId string, messageId string, ttl time.Duration) error {
+	r.cache.Set(notificationAckKey(userId, messageId), true, ttl)
+	return nil
+}
+
+func (r *memNotificationDedupRepository) IsAcked(ctx context.Context, userId string, messageId string) (bool, error) {
+	return r.cache.Exists(notificationAckKey(userId, messageId)), nil
+}
+
+func (r *memNotificationDedupRepository) ClaimPush(ctx context.Context, deviceId string, messageId string, ttl time.Duration) (bool, error) {
+	return r.cache.SetNX(notificationPushKey(deviceId, messageId), true, ttl), nil
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotificationPreferencesNotFound = errors.New("notification preferences not found")
+)
+
+type NotificationPreferencesRepository interface {
+	Get(ctx context.Context, userId string) (entity.NotificationPreferences, error)
+	Upsert(ctx context.Context, preferences entity.NotificationPreferences) error
+}
+
+type notificationPreferencesRepository struct {
+	db mongo.Database
+}
+
+func NewNotificationPreferencesRepository(db mongo.Database) NotificationPreferencesRepository {
+	return &notificationPreferencesRepository{
+		db: db,
+	}
+}
+
+// Get returns the notification preferences of a user
+func (r *notificationPreferencesRepository) Get(ctx context.Context, userId string) (entity.NotificationPreferences, error) {
+	collection := r.db.Collection("notification_preferences")
+	filter := bson.M{"_id": userId}
+
+	var preferences entity.NotificationPreferences
+	err := collection.FindOne(ctx, filter).Decode(&preferences)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.NotificationPreferences{}, ErrNotificationPreferencesNotFound
+		}
+		return entity.NotificationPreferences{}, err
+	}
+
+	return preferences, nil
+}
+
+// Upsert creates or replaces the notification preferences of a user
+func (r *notificationPreferencesRepository) Upsert(ctx context.Context, preferences entity.NotificationPreferences) error {
+	collection := r.db.Collection("notification_preferences")
+	filter := bson.M{"_id": preferences.UserId}
+	preferences.UpdatedAt = time.Now()
+
+	_, err := collection.ReplaceOne(ctx, filter, preferences, options.Replace().SetUpsert(true))
+	return err
+}
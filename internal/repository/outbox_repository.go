@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OutboxRepository interface {
+	Create(ctx context.Context, event entity.OutboxEvent) (entity.OutboxEvent, error)
+	ClaimPending(ctx context.Context, kind string, createdBefore time.Time, lease time.Duration) (entity.OutboxEvent, bool, error)
+	MarkSent(ctx context.Context, eventId string) error
+	DeleteSentBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+type outboxRepository struct {
+	db mongo.Database
+}
+
+func NewOutboxRepository(db mongo.Database) OutboxRepository {
+	return &outboxRepository{
+		db: db,
+	}
+}
+
+// Create stores a pending event
+func (r *outboxRepository) Create(ctx context.Context, event entity.OutboxEvent) (entity.OutboxEvent, error) {
+	collection := r.db.Collection("outbox")
+	event.Id = uuid.New().String()
+	if event.Kind == "" {
+		event.Kind = entity.OutboxKindEvent
+	}
+	event.Status = entity.OutboxStatusPending
+	event.CreatedAt = time.Now()
+
+	_, err := collection.InsertOne(ctx, event)
+	if err != nil {
+		return entity.OutboxEvent{}, err
+	}
+
+	return event, nil
+}
+
+// ClaimPending locks the oldest pending event of the kind created before the given time for the
+// lease duration, so only one server delivers it. Returns false when there is nothing left to claim
+func (r *outboxRepository) ClaimPending(ctx context.Context, kind string, createdBefore time.Time, lease time.Duration) (entity.OutboxEvent, bool, error) {
+	collection := r.db.Collection("outbox")
+	now := time.Now()
+
+	filter := bson.M{
+		"status":    entity.OutboxStatusPending,
+		"createdAt": bson.M{"$lt": createdBefore},
+		"$or": []bson.M{
+			{"lockedUntil": bson.M{"$exists": false}},
+			{"lockedUntil": bson.M{"$lt": now}},
+		},
+	}
+	// Events written before kinds existed have none
+	if kind == entity.OutboxKindMessage {
+		filter["kind"] = entity.OutboxKindMessage
+	} else {
+		filter["kind"] = bson.M{"$ne": entity.OutboxKindMessage}
+	}
+	update := bson.M{
+		"$set": bson.M{"lockedUntil": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var event entity.OutboxEvent
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.OutboxEvent{}, false, nil
+		}
+		return entity.OutboxEvent{}, false, err
+	}
+
+	return event, true, nil
+}
+
+func (r *outboxRepository) MarkSent(ctx context.Context, eventId string) error {
+	collection := r.db.Collection("outbox")
+	filter := bson.M{"_id": eventId}
+	update := bson.M{
+		"$set":   bson.M{"status": entity.OutboxStatusSent, "sentAt": time.Now()},
+		"$unset": bson.M{"lockedUntil": ""},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// DeleteSentBefore removes delivered events, they are only kept for troubleshooting
+func (r *outboxRepository) DeleteSentBefore(ctx context.Context, before time.Time) (int64, error) {
+	collection := r.db.Collection("outbox")
+	filter := bson.M{
+		"status": entity.OutboxStatusSent,
+		"sentAt": bson.M{"$lt": before},
+	}
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
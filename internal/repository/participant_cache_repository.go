@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// participantCacheVersionTTL keeps the version of a chat well past any fill reading it
+const participantCacheVersionTTL = time.Hour
+
+// ParticipantCacheRepository caches the active participants of the chats. Every membership change
+// of a chat bumps its version, a list read from the database before a change is not stored after it
+type ParticipantCacheRepository interface {
+	// Get returns the cached participants, found is false on a miss
+	Get(ctx context.Context, chatId string) (participants []entity.ChatParticipant, found bool, err error)
+	// Version returns the version of the participants of the chat, to read before loading them
+	Version(ctx context.Context, chatId string) (int64, error)
+	// Set stores the participants loaded at the version, they are dropped when the version moved since
+	Set(ctx context.Context, chatId string, version int64, participants []entity.ChatParticipant, ttl time.Duration) error
+	// Invalidate drops the participants and bumps the version
+	Invalidate(ctx context.Context, chatId string) error
+}
+
+type redisParticipantCacheRepository struct {
+	client *redis.Client
+}
+
+// NewRedisParticipantCacheRepository shares the participants and their invalidations between the servers
+func NewRedisParticipantCacheRepository(client *redis.Client) ParticipantCacheRepository {
+	return &redisParticipantCacheRepository{
+		client: client,
+	}
+}
+
+func participantCacheKey(chatId string) string {
+	return "participants:chat:" + chatId
+}
+
+func participantCacheVersionKey(chatId string) string {
+	return "participants:chat:" + chatId + ":version"
+}
+
+func (r *redisParticipantCacheRepository) Get(ctx context.Context, chatId string) ([]entity.ChatParticipant, bool, error) {
+	encoded, err := r.client.Get(ctx, participantCacheKey(chatId)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var participants []entity.ChatParticipant
+	if err := json.Unmarshal(encoded, &participants); err != nil {
+		return nil, false, err
+	}
+	return participants, true, nil
+}
+
+func (r *redisParticipantCacheRepository) Version(ctx context.Context, chatId string) (int64, error) {
+	version, err := r.client.Get(ctx, participantCacheVersionKey(chatId)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *redisParticipantCacheRepository) Set(ctx context.Context, chatId string, version int64, participants []entity.ChatParticipant, ttl time.Duration) error {
+	encoded, err := json.Marshal(participants)
+	if err != nil {
+		return err
+	}
+
+	versionKey := participantCacheVersionKey(chatId)
+	err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, versionKey).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != version {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, participantCacheKey(chatId), encoded, ttl)
+			return nil
+		})
+		return err
+	}, versionKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		// The members changed meanwhile, the next read loads them again
+		return nil
+	}
+	return err
+}
+
+func (r *redisParticipantCacheRepository) Invalidate(ctx context.Context, chatId string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Incr(ctx, participantCacheVersionKey(chatId))
+	pipe.Expire(ctx, participantCacheVersionKey(chatId), participantCacheVersionTTL)
+	pipe.Del(ctx, participantCacheKey(chatId))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+type memParticipantCacheRepository struct {
+	// mu makes the version check of Set atomic, the cache expires the idle lists and versions
+	mu    sync.Mutex
+	cache *cache.MemCache
+}
+
+// NewMemParticipantCacheRepository caches the participants for this server only
+func NewMemParticipantCacheRepository(cache *cache.MemCache) ParticipantCacheRepository {
+	return &memParticipantCacheRepository{
+		cache: cache,
+	}
+}
+
+func (r *memParticipantCacheRepository) Get(ctx context.Context, chatId string) ([]entity.ChatParticipant, bool, error) {
+	cached, ok := r.cache.Get(participantCacheKey(chatId))
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]entity.ChatParticipant(nil), cached.([]entity.ChatParticipant)...), true, nil
+}
+
+func (r *memParticipantCacheRepository) Version(ctx context.Context, chatId string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version(chatId), nil
+}
+
+func (r *memParticipantCacheRepository) Set(ctx context.Context, chatId string, version int64, participants []entity.ChatParticipant, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.version(chatId) != version {
+		return nil
+	}
+	r.cache.Set(participantCacheKey(chatId), append([]entity.ChatParticipant(nil), participants...), ttl)
+	return nil
+}
+
+func (r *memParticipantCacheRepository) Invalidate(ctx context.Context, chatId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cache.Delete(participantCacheKey(chatId))
+	r.cache.Set(participantCacheVersionKey(chatId), r.version(chatId)+1, participantCacheVersionTTL)
+	return nil
+}
+
+// version must be called with the lock held, a chat never invalidated is at version 0
+func (r *memParticipantCacheRepository) version(chatId string) int64 {
+	version, ok := r.cache.Get(participantCacheVersionKey(chatId))
+	if !ok {
+		return 0
+	}
+	return version.(int64)
+}
+
+// cachedChatRepository serves Get and the participants, read for every message sent, from the cache.
+// The writes to the chats and their participants go through it so it drops what they change
+type cachedChatRepository struct {
+	ChatRepository
+	participantCache ParticipantCacheRepository
+	recordCache      RecordCacheRepository
+	ttl              time.Duration
+}
+
+// NewCachedChatRepository caches the chats and their participants for ttl. A change made in a
+// transaction is dropped before it commits, a read in between may keep the old one until ttl
+func NewCachedChatRepository(chatRepo ChatRepository, participantCache ParticipantCacheRepository, recordCache RecordCacheRepository, ttl time.Duration) ChatRepository {
+	return &cachedChatRepository{
+		ChatRepository:   chatRepo,
+		participantCache: participantCache,
+		recordCache:      recordCache,
+		ttl:              ttl,
+	}
+}
+
+func (r *cachedChatRepository) Get(ctx context.Context, chatId string) (entity.Chat, error) {
+	return readThrough(ctx, r.recordCache, chatRecordKey(chatId), r.ttl, func() (entity.Chat, error) {
+		return r.ChatRepository.Get(ctx, chatId)
+	})
+}
+
+func (r *cachedChatRepository) Update(ctx context.Context, chat entity.Chat) error {
+	err := r.ChatRepository.Update(ctx, chat)
+	invalidateRecord(ctx, r.recordCache, chatRecordKey(chat.Id))
+	return err
+}
+
+func (r *cachedChatRepository) SetEmptySince(ctx context.Context, chatId string, emptySince *time.Time) error {
+	err := r.ChatRepository.SetEmptySince(ctx, chatId, emptySince)
+	invalidateRecord(ctx, r.recordCache, chatRecordKey(chatId))
+	return err
+}
+
+func (r *cachedChatRepository) SetMessageTTL(ctx context.Context, chatId string, messageTTL int64) error {
+	err := r.ChatRepository.SetMessageTTL(ctx, chatId, messageTTL)
+	invalidateRecord(ctx, r.recordCache, chatRecordKey(chatId))
+	return err
+}
+
+func (r *cachedChatRepository) SetPinLimit(ctx context.Context, chatId string, pinLimit int) error {
+	err := r.ChatRepository.SetPinLimit(ctx, chatId, pinLimit)
+	invalidateRecord(ctx, r.recordCache, chatRecordKey(chatId))
+	return err
+}
+
+func (r *cachedChatRepository) GetParticipants(ctx context.Context, chatId string) ([]entity.ChatParticipant, error) {
+	participants, found, err := r.participantCache.Get(ctx, chatId)
+	if err != nil {
+		// The cache only spares the database
+		slog.ErrorContext(ctx, "Get cached participants error", "chat_id", chatId, "error", err)
+	}
+	if found {
+		// The list may outlive the access of a guest, until the sweeper removes them
+		return slices.DeleteFunc(participants, func(participant entity.ChatParticipant) bool {
+			return participant.IsExpiredAt(time.Now())
+		}), nil
+	}
+
+	version, err := r.participantCache.Version(ctx, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get cached participants version error", "chat_id", chatId, "error", err)
+	}
+	participants, err = r.ChatRepository.GetParticipants(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.participantCache.Set(ctx, chatId, version, participants, r.ttl); err != nil {
+		slog.ErrorContext(ctx, "Cache participants error", "chat_id", chatId, "error", err)
+	}
+	return participants, nil
+}
+
+// GetParticipantByUserAndChat looks the user up in the cached participants, a message sent to a
+// chat checks its sender before anything else
+func (r *cachedChatRepository) GetParticipantByUserAndChat(ctx context.Context, userId, chatId string) (entity.ChatParticipant, error) {
+	participants, err := r.GetParticipants(ctx, chatId)
+	if err != nil {
+		return entity.ChatParticipant{}, err
+	}
+	for _, participant := range participants {
+		if participant.UserId == userId {
+			return participant, nil
+		}
+	}
+	return entity.ChatParticipant{}, ErrNotParticipant
+}
+
+func (r *cachedChatRepository) IsParticipant(ctx context.Context, userId, chatId string) (bool, error) {
+	_, err := r.GetParticipantByUserAndChat(ctx, userId, chatId)
+	if errors.Is(err, ErrNotParticipant) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *cachedChatRepository) Delete(ctx context.Context, chatId string) error {
+	err := r.ChatRepository.Delete(ctx, chatId)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) Restore(ctx context.Context, chatId string) error {
+	err := r.ChatRepository.Restore(ctx, chatId)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) Purge(ctx context.Context, chatId string) error {
+	err := r.ChatRepository.Purge(ctx, chatId)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) AddParticipants(ctx context.Context, chatParticipants []entity.ChatParticipant) error {
+	err := r.ChatRepository.AddParticipants(ctx, chatParticipants)
+	invalidated := make(map[string]bool)
+	for _, participant := range chatParticipants {
+		if !invalidated[participant.ChatId] {
+			invalidated[participant.ChatId] = true
+			r.invalidate(ctx, participant.ChatId)
+		}
+	}
+	return err
+}
+
+func (r *cachedChatRepository) RemoveParticipant(ctx context.Context, userId, chatId string) error {
+	err := r.ChatRepository.RemoveParticipant(ctx, userId, chatId)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) UpdateParticipantRole(ctx context.Context, userId, chatId, role string) error {
+	err := r.ChatRepository.UpdateParticipantRole(ctx, userId, chatId, role)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) SetParticipantMuted(ctx context.Context, userId, chatId string, muted bool, mutedUntil *time.Time) error {
+	err := r.ChatRepository.SetParticipantMuted(ctx, userId, chatId, muted, mutedUntil)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) SetParticipantPinned(ctx context.Context, userId, chatId string, pinnedAt *time.Time) error {
+	err := r.ChatRepository.SetParticipantPinned(ctx, userId, chatId, pinnedAt)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) SetParticipantArchived(ctx context.Context, userId, chatId string, archivedAt *time.Time) error {
+	err := r.ChatRepository.SetParticipantArchived(ctx, userId, chatId, archivedAt)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) SetParticipantNotificationMode(ctx context.Context, userId, chatId, mode string) error {
+	err := r.ChatRepository.SetParticipantNotificationMode(ctx, userId, chatId, mode)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) SetThreadMuted(ctx context.Context, userId, chatId, messageId string, muted bool) error {
+	err := r.ChatRepository.SetThreadMuted(ctx, userId, chatId, messageId, muted)
+	r.invalidate(ctx, chatId)
+	return err
+}
+
+func (r *cachedChatRepository) MoveParticipations(ctx context.Context, fromUserId, toUserId string) ([]string, []string, error) {
+	moved, closed, err := r.ChatRepository.MoveParticipations(ctx, fromUserId, toUserId)
+	for _, chatId := range append(append([]string(nil), moved...), closed...) {
+		r.invalidate(ctx, chatId)
+	}
+	return moved, closed, err
+}
+
+func (r *cachedChatRepository) RestoreParticipations(ctx context.Context, fromUserId, toUserId string) error {
+	if err := r.ChatRepository.RestoreParticipations(ctx, fromUserId, toUserId); err != nil {
+		return err
+	}
+
+	// Every chat the restore touched is one the account takes part in again
+	participations, err := r.ChatRepository.GetParticipationsByUser(ctx, fromUserId)
+	if err != nil {
+		return err
+	}
+	for _, participant := range participations {
+		r.invalidate(ctx, participant.ChatId)
+	}
+	return nil
+}
+
+// invalidate runs after failed writes too, they may have changed some of the participants. The
+// chat goes along, its membership version follows the participants
+func (r *cachedChatRepository) invalidate(ctx context.Context, chatId string) {
+	if err := r.participantCache.Invalidate(context.WithoutCancel(ctx), chatId); err != nil {
+		slog.ErrorContext(ctx, "Invalidate cached participants error", "chat_id", chatId, "error", err)
+	}
+	invalidateRecord(ctx, r.recordCache, chatRecordKey(chatId))
+}
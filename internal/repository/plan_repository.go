@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrPlanNotFound = errors.New("plan assignment not found")
+)
+
+type PlanRepository interface {
+	GetAssignment(ctx context.Context, workspaceId string) (entity.PlanAssignment, error)
+	Assign(ctx context.Context, assignment entity.PlanAssignment) error
+	IncrementUsage(ctx context.Context, workspaceId, metric, period string, delta int64) error
+	GetUsage(ctx context.Context, workspaceId, period string) ([]entity.UsageRecord, error)
+	GetUsageByPeriod(ctx context.Context, period string) ([]entity.UsageRecord, error)
+}
+
+type planRepository struct {
+	db mongo.Database
+}
+
+func NewPlanRepository(db mongo.Database) PlanRepository {
+	return &planRepository{
+		db: db,
+	}
+}
+
+// GetAssignment returns the plan assigned to a workspace
+func (r *planRepository) GetAssignment(ctx context.Context, workspaceId string) (entity.PlanAssignment, error) {
+	collection := r.db.Collection("plan_assignments")
+	filter := bson.M{"_id": workspaceId}
+
+	var assignment entity.PlanAssignment
+	err := collection.FindOne(ctx, filter).Decode(&assignment)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.PlanAssignment{}, ErrPlanNotFound
+		}
+		return entity.PlanAssignment{}, err
+	}
+
+	return assignment, nil
+}
+
+// Assign creates or replaces the plan of a workspace
+func (r *planRepository) Assign(ctx context.Context, assignment entity.PlanAssignment) error {
+	collection := r.db.Collection("plan_assignments")
+	filter := bson.M{"_id": assignment.WorkspaceId}
+	assignment.AssignedAt = time.Now()
+
+	_, err := collection.ReplaceOne(ctx, filter, assignment, options.Replace().SetUpsert(true))
+	return err
+}
+
+// IncrementUsage adds delta to a usage counter, creating it if needed
+func (r *planRepository) IncrementUsage(ctx context.Context, workspaceId, metric, period string, delta int64) error {
+	collection := r.db.Collection("usage_records")
+	filter := bson.M{"_id": usageRecordId(workspaceId, metric, period)}
+
+	update := bson.M{
+		"$inc": bson.M{"value": delta},
+		"$setOnInsert": bson.M{
+			"workspaceId": workspaceId,
+			"metric":      metric,
+			"period":      period,
+		},
+	}
+
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// GetUsage returns all usage counters of a workspace for a period
+func (r *planRepository) GetUsage(ctx context.Context, workspaceId, period string) ([]entity.UsageRecord, error) {
+	collection := r.db.Collection("usage_records")
+	filter := bson.M{
+		"workspaceId": workspaceId,
+		"period":      period,
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []entity.UsageRecord
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetUsageByPeriod returns the usage counters of every workspace for a period
+func (r *planRepository) GetUsageByPeriod(ctx context.Context, period string) ([]entity.UsageRecord, error) {
+	collection := r.db.Collection("usage_records")
+	filter := bson.M{"period": period}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []entity.UsageRecord
+	err = cursor.All(ctx, &records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func usageRecordId(workspaceId, metric, period string) string {
+	return workspaceId + ":" + metric + ":" + period
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"reflect"
+	"strings"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// projection limits a query to the stored fields of the model selected by their JSON names.
+// derived lists the stored fields a computed field is made from, such as the status of a user
+// from isOnline. It is nil, every field, without a selection, and _id is always returned
+func projection(model any, fields entity.Fields, derived map[string][]string) bson.M {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	stored := make(map[string]string)
+	t := reflect.TypeOf(model)
+	for i := 0; i < t.NumField(); i++ {
+		jsonName, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		bsonName, _, _ := strings.Cut(t.Field(i).Tag.Get("bson"), ",")
+		if jsonName != "" && jsonName != "-" && bsonName != "" && bsonName != "-" {
+			stored[jsonName] = bsonName
+		}
+	}
+
+	selected := bson.M{"_id": 1}
+	for _, field := range fields {
+		if name, ok := stored[field]; ok {
+			selected[name] = 1
+		}
+		for _, name := range derived[field] {
+			selected[name] = 1
+		}
+	}
+	return selected
+}
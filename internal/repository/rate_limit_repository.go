@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+	"wetalk/infrastructure/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitRepository counts the requests of a user in fixed windows
+type RateLimitRepository interface {
+	// Hit counts a request in the window starting at windowStart and returns the count of the window
+	Hit(ctx context.Context, userId string, windowStart time.Time, window time.Duration) (int64, error)
+	// Count returns the count of the window without counting a request
+	Count(ctx context.Context, userId string, windowStart time.Time) (int64, error)
+}
+
+type redisRateLimitRepository struct {
+	client *redis.Client
+}
+
+func NewRedisRateLimitRepository(client *redis.Client) RateLimitRepository {
+	return &redisRateLimitRepository{
+		client: client,
+	}
+}
+
+func rateLimitKey(userId string, windowStart time.Time) string {
+	return "ratelimit:" + userId + ":" + strconv.FormatInt(windowStart.Unix(), 10)
+}
+
+func (r *redisRateLimitRepository) Hit(ctx context.Context, userId string, windowStart time.Time, window time.Duration) (int64, error) {
+	key := rateLimitKey(userId, windowStart)
+
+	var incr *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *redisRateLimitRepository) Count(ctx context.Context, userId string, windowStart time.Time) (int64, error) {
+	count, err := r.client.Get(ctx, rateLimitKey(userId, windowStart)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
+
+type memRateLimitRepository struct {
+	// mu makes the first hit of a window atomic
+	mu    sync.Mutex
+	cache *cache.MemCache
+}
+
+// NewMemRateLimitRepository only counts the requests served by this server
+func NewMemRateLimitRepository(cache *cache.MemCache) RateLimitRepository {
+	return &memRateLimitRepository{
+		cache: cache,
+	}
+}
+
+func (r *memRateLimitRepository) Hit(ctx context.Context, userId string, windowStart time.Time, window time.Duration) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := rateLimitKey(userId, windowStart)
+	if !r.cache.Exists(key) {
+		r.cache.Set(key, int64(1), window)
+		return 1, nil
+	}
+	return r.cache.Increment(key, 1)
+}
+
+func (r *memRateLimitRepository) Count(ctx context.Context, userId string, windowStart time.Time) (int64, error) {
+	count, ok := r.cache.Get(rateLimitKey(userId, windowStart))
+	if !ok {
+		return 0, nil
+	}
+	return count.(int64), nil
+}
@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReceiptRepository stores the delivery state of every message for each of its recipients.
+// States only move forward, sent then delivered then read
+type ReceiptRepository interface {
+	// CreateSent writes the sent receipts of the recipients, the ones in quietIds are left out of
+	// their unread count
+	CreateSent(ctx context.Context, message entity.Message, recipientIds []string, quietIds []string) error
+	// MarkDelivered returns the receipt and true when it moved from sent to delivered
+	MarkDelivered(ctx context.Context, messageId string, userId string) (entity.MessageReceipt, bool, error)
+	// MarkRead returns the receipt and true when it was not read yet
+	MarkRead(ctx context.Context, messageId string, userId string) (entity.MessageReceipt, bool, error)
+	GetByMessages(ctx context.Context, messageIds []string) ([]entity.MessageReceipt, error)
+	// MarkChatRead reads every message of the chat sent to the user up to the timestamp, it
+	// returns the number of receipts that were not read yet
+	MarkChatRead(ctx context.Context, chatId string, userId string, upTo int64) (int64, error)
+	// CountUnread counts the messages every user didn't read yet per chat, deleted and quiet messages excluded
+	CountUnread(ctx context.Context) ([]entity.UnreadCount, error)
+	CountUnreadInChat(ctx context.Context, userId string, chatId string) (int64, error)
+	// MoveRecipient hands the receipts of an account in the chats over to the one it is merged into
+	MoveRecipient(ctx context.Context, fromUserId string, toUserId string, chatIds []string) (int64, error)
+	// RestoreRecipient gives the receipts MoveRecipient moved back to their account
+	RestoreRecipient(ctx context.Context, fromUserId string, toUserId string) (int64, error)
+	Indexes() []CollectionIndexes
+}
+
+type receiptRepository struct {
+	db mongo.Database
+}
+
+func NewReceiptRepository(db mongo.Database) ReceiptRepository {
+	return &receiptRepository{
+		db: db,
+	}
+}
+
+func (r *receiptRepository) CreateSent(ctx context.Context, message entity.Message, recipientIds []string, quietIds []string) error {
+	if len(recipientIds) == 0 {
+		return nil
+	}
+
+	collection := r.db.Collection("message_receipts")
+
+	receipts := make([]interface{}, 0, len(recipientIds))
+	for _, userId := range recipientIds {
+		receipts = append(receipts, entity.MessageReceipt{
+			Id:        uuid.New().String(),
+			MessageId: message.Id,
+			ChatId:    message.ChatId,
+			SenderId:  message.SenderId,
+			UserId:    userId,
+			State:     entity.DeliveryStateSent,
+			Timestamp: message.Timestamp,
+			Quiet:     slices.Contains(quietIds, userId),
+		})
+	}
+
+	_, err := collection.InsertMany(ctx, receipts)
+	return err
+}
+
+func (r *receiptRepository) MarkDelivered(ctx context.Context, messageId string, userId string) (entity.MessageReceipt, bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"messageId": messageId,
+		"userId":    userId,
+		"state":     entity.DeliveryStateSent,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"state":       entity.DeliveryStateDelivered,
+			"deliveredAt": now,
+		},
+	}
+
+	return r.advance(ctx, filter, update)
+}
+
+func (r *receiptRepository) MarkRead(ctx context.Context, messageId string, userId string) (entity.MessageReceipt, bool, error) {
+	now := time.Now()
+	filter := bson.M{
+		"messageId": messageId,
+		"userId":    userId,
+		"state":     bson.M{"$ne": entity.DeliveryStateRead},
+	}
+	// A read message was delivered too, keep the earlier delivery time if any
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"state":       entity.DeliveryStateRead,
+			"readAt":      now,
+			"deliveredAt": bson.M{"$ifNull": bson.A{"$deliveredAt", now}},
+		}}},
+	}
+
+	return r.advance(ctx, filter, update)
+}
+
+// advance applies the update when the receipt is still behind, the filter guards the state
+func (r *receiptRepository) advance(ctx context.Context, filter interface{}, update interface{}) (entity.MessageReceipt, bool, error) {
+	collection := r.db.Collection("message_receipts")
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var receipt entity.MessageReceipt
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&receipt)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.MessageReceipt{}, false, nil
+		}
+		return entity.MessageReceipt{}, false, err
+	}
+
+	return receipt, true, nil
+}
+
+func (r *receiptRepository) GetByMessages(ctx context.Context, messageIds []string) ([]entity.MessageReceipt, error) {
+	receipts := []entity.MessageReceipt{}
+	if len(messageIds) == 0 {
+		return receipts, nil
+	}
+
+	collection := r.db.Collection("message_receipts")
+	filter := bson.M{"messageId": bson.M{"$in": messageIds}}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &receipts); err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+// MarkChatRead uses a single update whatever the number of messages. Receipts written before
+// their timestamp was recorded predate the horizons chosen since, they are read along
+func (r *receiptRepository) MarkChatRead(ctx context.Context, chatId string, userId string, upTo int64) (int64, error) {
+	collection := r.db.Collection("message_receipts")
+	now := time.Now()
+
+	filter := bson.M{
+		"chatId": chatId,
+		"userId": userId,
+		"state":  bson.M{"$ne": entity.DeliveryStateRead},
+		"$or": bson.A{
+			bson.M{"timestamp": bson.M{"$lte": upTo}},
+			bson.M{"timestamp": bson.M{"$exists": false}},
+		},
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"state":       entity.DeliveryStateRead,
+			"readAt":      now,
+			"deliveredAt": bson.M{"$ifNull": bson.A{"$deliveredAt", now}},
+		}}},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *receiptRepository) MoveRecipient(ctx context.Context, fromUserId string, toUserId string, chatIds []string) (int64, error) {
+	if len(chatIds) == 0 {
+		return 0, nil
+	}
+	collection := r.db.Collection("message_receipts")
+	filter := bson.M{"userId": fromUserId, "chatId": bson.M{"$in": chatIds}}
+
+	result, err := collection.UpdateMany(ctx, filter, mergeMove("userId", fromUserId, toUserId))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *receiptRepository) RestoreRecipient(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	collection := r.db.Collection("message_receipts")
+
+	result, err := collection.UpdateMany(ctx, mergeRestoreFilter("userId", fromUserId, toUserId), mergeRestore("userId", fromUserId))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *receiptRepository) CountUnread(ctx context.Context) ([]entity.UnreadCount, error) {
+	return r.countUnread(ctx, bson.M{})
+}
+
+func (r *receiptRepository) CountUnreadInChat(ctx context.Context, userId string, chatId string) (int64, error) {
+	counts, err := r.countUnread(ctx, bson.M{"userId": userId, "chatId": chatId})
+	if err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].Count, nil
+}
+
+// countUnread counts the unread receipts matching the filter per user and chat
+func (r *receiptRepository) countUnread(ctx context.Context, filter bson.M) ([]entity.UnreadCount, error) {
+	collection := r.db.Collection("message_receipts")
+	filter["state"] = bson.M{"$ne": entity.DeliveryStateRead}
+	filter["quiet"] = bson.M{"$ne": true}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		// Receipts outlive the messages deleted since they were written
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "messages",
+			"localField":   "messageId",
+			"foreignField": "_id",
+			"as":           "message",
+		}}},
+		{{Key: "$match", Value: bson.M{"message": bson.M{"$ne": bson.A{}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"userId": "$userId", "chatId": "$chatId"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":    0,
+			"userId": "$_id.userId",
+			"chatId": "$_id.chatId",
+			"count":  1,
+		}}},
+	}
+
+	counts := []entity.UnreadCount{}
+	if err := aggregate(ctx, collection, pipeline, unreadCountMaxTime, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// Indexes are the indexes backing the receipt lookups and the chat read horizons
+func (r *receiptRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("message_receipts"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "messageId", Value: 1}, {Key: "userId", Value: 1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "chatId", Value: 1}, {Key: "timestamp", Value: 1}}},
+		},
+	}}
+}
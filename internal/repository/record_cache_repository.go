@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+	"wetalk/infrastructure/cache"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recordCacheVersionTTL keeps the version of a record well past any fill reading it
+const recordCacheVersionTTL = time.Hour
+
+// RecordCacheRepository caches single documents, the chats and the users read by id. Records are
+// stored in BSON so the fields hidden from JSON, such as password hashes, survive the cache. Like
+// ParticipantCacheRepository every write bumps the version of the record, a record read from the
+// database before a write is not stored after it
+type RecordCacheRepository interface {
+	// Get decodes the cached record into out, found is false on a miss
+	Get(ctx context.Context, key string, out any) (found bool, err error)
+	// Version returns the version of the record, to read before loading it
+	Version(ctx context.Context, key string) (int64, error)
+	// Set stores the record loaded at the version, it is dropped when the version moved since
+	Set(ctx context.Context, key string, version int64, record any, ttl time.Duration) error
+	// Invalidate drops the record and bumps its version
+	Invalidate(ctx context.Context, key string) error
+}
+
+func chatRecordKey(chatId string) string {
+	return "records:chat:" + chatId
+}
+
+func userRecordKey(userId string) string {
+	return "records:user:" + userId
+}
+
+func recordVersionKey(key string) string {
+	return key + ":version"
+}
+
+type redisRecordCacheRepository struct {
+	client *redis.Client
+}
+
+// NewRedisRecordCacheRepository shares the records and their invalidations between the servers
+func NewRedisRecordCacheRepository(client *redis.Client) RecordCacheRepository {
+	return &redisRecordCacheRepository{
+		client: client,
+	}
+}
+
+func (r *redisRecordCacheRepository) Get(ctx context.Context, key string, out any) (bool, error) {
+	encoded, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := bson.Unmarshal(encoded, out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *redisRecordCacheRepository) Version(ctx context.Context, key string) (int64, error) {
+	version, err := r.client.Get(ctx, recordVersionKey(key)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+func (r *redisRecordCacheRepository) Set(ctx context.Context, key string, version int64, record any, ttl time.Duration) error {
+	encoded, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	versionKey := recordVersionKey(key)
+	err = r.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, versionKey).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != version {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, encoded, ttl)
+			return nil
+		})
+		return err
+	}, versionKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		// The record changed meanwhile, the next read loads it again
+		return nil
+	}
+	return err
+}
+
+func (r *redisRecordCacheRepository) Invalidate(ctx context.Context, key string) error {
+	pipe := r.client.TxPipeline()
+	pipe.Incr(ctx, recordVersionKey(key))
+	pipe.Expire(ctx, recordVersionKey(key), recordCacheVersionTTL)
+	pipe.Del(ctx, key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+type memRecordCacheRepository struct {
+	// mu makes the version check of Set atomic, the cache expires the idle records and versions
+	mu    sync.Mutex
+	cache *cache.MemCache
+}
+
+// NewMemRecordCacheRepository caches the records for this server only. They are kept encoded so a
+// caller changing the record it got doesn't change the cached one
+func NewMemRecordCacheRepository(cache *cache.MemCache) RecordCacheRepository {
+	return &memRecordCacheRepository{
+		cache: cache,
+	}
+}
+
+func (r *memRecordCacheRepository) Get(ctx context.Context, key string, out any) (bool, error) {
+	cached, ok := r.cache.Get(key)
+	if !ok {
+		return false, nil
+	}
+	if err := bson.Unmarshal(cached.([]byte), out); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *memRecordCacheRepository) Version(ctx context.Context, key string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version(key), nil
+}
+
+func (r *memRecordCacheRepository) Set(ctx context.Context, key string, version int64, record any, ttl time.Duration) error {
+	encoded, err := bson.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.version(key) != version {
+		return nil
+	}
+	r.cache.Set(key, encoded, ttl)
+	return nil
+}
+
+func (r *memRecordCacheRepository) Invalidate(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cache.Delete(key)
+	r.cache.Set(recordVersionKey(key), r.version(key)+1, recordCacheVersionTTL)
+	return nil
+}
+
+// version must be called with the lock held, a record never invalidated is at version 0
+func (r *memRecordCacheRepository) version(key string) int64 {
+	version, ok := r.cache.Get(recordVersionKey(key))
+	if !ok {
+		return 0
+	}
+	return version.(int64)
+}
+
+// readThrough serves the record from the cache, loading and caching it on a miss. The cache only
+// spares the database, its failures are logged and the record is loaded
+func readThrough[T any](ctx context.Context, records RecordCacheRepository, key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	var record T
+	found, err := records.Get(ctx, key, &record)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get cached record error", "key", key, "error", err)
+	}
+	if found {
+		return record, nil
+	}
+
+	version, err := records.Version(ctx, key)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get cached record version error", "key", key, "error", err)
+	}
+	record, err = load()
+	if err != nil {
+		return record, err
+	}
+	if err := records.Set(ctx, key, version, record, ttl); err != nil {
+		slog.ErrorContext(ctx, "Cache record error", "key", key, "error", err)
+	}
+	return record, nil
+}
+
+// invalidateRecord runs after failed writes too, they may have changed the record
+func invalidateRecord(ctx context.Context, records RecordCacheRepository, key string) {
+	if err := records.Invalidate(context.WithoutCancel(ctx), key); err != nil {
+		slog.ErrorContext(ctx, "Invalidate cached record error", "key", key, "error", err)
+	}
+}
@@ -2,22 +2,33 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 	"wetalk/internal/entity"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type RefreshTokenRepository interface {
 	Create(ctx context.Context, refreshToken entity.RefreshToken) error
 	GetByToken(ctx context.Context, token string) (entity.RefreshToken, error)
+	// GetByUserId returns the active tokens of the user, newest first
 	GetByUserId(ctx context.Context, userId string) ([]entity.RefreshToken, error)
+	GetSignIns(ctx context.Context, userId string) ([]entity.RefreshToken, error)
 	Revoke(ctx context.Context, token string) error
 	RevokeAllByUserId(ctx context.Context, userId string) error
-	DeleteExpired(ctx context.Context) error
+	RevokeOthersByUserId(ctx context.Context, userId string, keepToken string) error
+	DeleteExpired(ctx context.Context) (int64, error)
 	IsRevoked(ctx context.Context, token string) (bool, error)
+	// MoveUser hands the tokens of an account over to the one it is merged into, its devices
+	// refresh into the merged account
+	MoveUser(ctx context.Context, fromUserId string, toUserId string) (int64, error)
+	// RestoreUser gives the tokens MoveUser moved back to their account
+	RestoreUser(ctx context.Context, fromUserId string, toUserId string) (int64, error)
+	Indexes() []CollectionIndexes
 }
 
 type refreshTokenRepository struct {
@@ -48,7 +59,7 @@ func (r *refreshTokenRepository) GetByToken(ctx context.Context, token string) (
 	var refreshToken entity.RefreshToken
 	err := collection.FindOne(ctx, filter).Decode(&refreshToken)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.RefreshToken{}, ErrUserNotFound
 		}
 		return entity.RefreshToken{}, err
@@ -64,8 +75,9 @@ func (r *refreshTokenRepository) GetByUserId(ctx context.Context, userId string)
 		"isRevoked": false,
 		"expiresAt": bson.M{"$gt": time.Now()},
 	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
 	
-	cursor, err := collection.Find(ctx, filter)
+	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +91,33 @@ func (r *refreshTokenRepository) GetByUserId(ctx context.Context, userId string)
 	return tokens, nil
 }
 
+// GetSignIns returns the IP address and device of every stored refresh token of the user that
+// recorded them, revoked ones included, so a sign in can be compared with the previous ones
+func (r *refreshTokenRepository) GetSignIns(ctx context.Context, userId string) ([]entity.RefreshToken, error) {
+	collection := r.db.Collection("refresh_tokens")
+	filter := bson.M{
+		"userId": userId,
+		"$or": bson.A{
+			bson.M{"ipAddress": bson.M{"$exists": true}},
+			bson.M{"deviceInfo": bson.M{"$exists": true}},
+		},
+	}
+	opts := options.Find().SetProjection(bson.M{"userId": 1, "ipAddress": 1, "deviceInfo": 1, "createdAt": 1})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var tokens []entity.RefreshToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
 func (r *refreshTokenRepository) Revoke(ctx context.Context, token string) error {
 	collection := r.db.Collection("refresh_tokens")
 	filter := bson.M{"token": token}
@@ -114,14 +153,38 @@ func (r *refreshTokenRepository) RevokeAllByUserId(ctx context.Context, userId s
 	return err
 }
 
-func (r *refreshTokenRepository) DeleteExpired(ctx context.Context) error {
+// RevokeOthersByUserId revokes every refresh token of the user except keepToken
+func (r *refreshTokenRepository) RevokeOthersByUserId(ctx context.Context, userId string, keepToken string) error {
+	collection := r.db.Collection("refresh_tokens")
+	filter := bson.M{
+		"userId":    userId,
+		"isRevoked": false,
+		"token":     bson.M{"$ne": keepToken},
+	}
+	now := time.Now()
+
+	update := bson.M{
+		"$set": bson.M{
+			"isRevoked": true,
+			"revokedAt": now,
+		},
+	}
+
+	_, err := collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (r *refreshTokenRepository) DeleteExpired(ctx context.Context) (int64, error) {
 	collection := r.db.Collection("refresh_tokens")
 	filter := bson.M{
 		"expiresAt": bson.M{"$lt": time.Now()},
 	}
 	
-	_, err := collection.DeleteMany(ctx, filter)
-	return err
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
 }
 
 func (r *refreshTokenRepository) IsRevoked(ctx context.Context, token string) (bool, error) {
@@ -131,4 +194,42 @@ func (r *refreshTokenRepository) IsRevoked(ctx context.Context, token string) (b
 	}
 	
 	return refreshToken.IsRevoked, nil
-}
\ No newline at end of file
+}
+
+func (r *refreshTokenRepository) MoveUser(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	collection := r.db.Collection("refresh_tokens")
+
+	result, err := collection.UpdateMany(ctx, bson.M{"userId": fromUserId}, mergeMove("userId", fromUserId, toUserId))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (r *refreshTokenRepository) RestoreUser(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	collection := r.db.Collection("refresh_tokens")
+
+	result, err := collection.UpdateMany(ctx, mergeRestoreFilter("userId", fromUserId, toUserId), mergeRestore("userId", fromUserId))
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Indexes make tokens unique and let Mongo delete them once they expire
+func (r *refreshTokenRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("refresh_tokens"),
+		Models: []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "token", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+		},
+	}}
+}
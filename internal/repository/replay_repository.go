@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReplayRepository keeps the newest messages of each chat close at hand, newest first,
+// so opening a chat doesn't have to query the message collection. A window only built from
+// pushes is partial, it may lack older messages until it is filled from the collection
+type ReplayRepository interface {
+	// Push adds a message to the window of its chat, keeping the newest size messages
+	Push(ctx context.Context, message entity.Message, size int, ttl time.Duration) error
+	// Fill merges the messages into the window of a chat and marks it complete
+	Fill(ctx context.Context, chatId string, messages []entity.Message, size int, ttl time.Duration) error
+	// GetRecent returns up to limit messages newest first, found is false when the window can't serve them all
+	GetRecent(ctx context.Context, chatId string, limit int) (messages []entity.Message, found bool, err error)
+	Clear(ctx context.Context, chatId string) error
+}
+
+type redisReplayRepository struct {
+	client *redis.Client
+}
+
+// NewRedisReplayRepository stores the windows as capped lists shared by every server
+func NewRedisReplayRepository(client *redis.Client) ReplayRepository {
+	return &redisReplayRepository{
+		client: client,
+	}
+}
+
+func chatReplayKey(chatId string) string {
+	return "replay:chat:" + chatId
+}
+
+// chatReplayCompleteKey marks a window that holds every newest message of its chat
+func chatReplayCompleteKey(chatId string) string {
+	return "replay:chat:" + chatId + ":complete"
+}
+
+func (r *redisReplayRepository) Push(ctx context.Context, message entity.Message, size int, ttl time.Duration) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	key := chatReplayKey(message.ChatId)
+	pipe := r.client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, int64(size-1))
+	pipe.Expire(ctx, key, ttl)
+	pipe.Expire(ctx, chatReplayCompleteKey(message.ChatId), ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisReplayRepository) Fill(ctx context.Context, chatId string, messages []entity.Message, size int, ttl time.Duration) error {
+	key := chatReplayKey(chatId)
+
+	// Merge inside an optimistic transaction so a message pushed meanwhile is not dropped
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		merged, err := mergeReplayWindow(decodeReplayMessages(current), messages, size)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			if len(merged) > 0 {
+				values := make([]any, 0, len(merged))
+				for _, data := range merged {
+					values = append(values, data)
+				}
+				pipe.RPush(ctx, key, values...)
+				pipe.Expire(ctx, key, ttl)
+			}
+			pipe.Set(ctx, chatReplayCompleteKey(chatId), 1, ttl)
+			return nil
+		})
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		// A message was pushed meanwhile, the window stays partial and the next miss fills it again
+		return nil
+	}
+	return err
+}
+
+func (r *redisReplayRepository) GetRecent(ctx context.Context, chatId string, limit int) ([]entity.Message, bool, error) {
+	pipe := r.client.Pipeline()
+	rangeCmd := pipe.LRange(ctx, chatReplayKey(chatId), 0, int64(limit-1))
+	completeCmd := pipe.Exists(ctx, chatReplayCompleteKey(chatId))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, false, err
+	}
+
+	values := rangeCmd.Val()
+	if len(values) < limit && completeCmd.Val() == 0 {
+		return nil, false, nil
+	}
+
+	return decodeReplayMessages(values), true, nil
+}
+
+func (r *redisReplayRepository) Clear(ctx context.Context, chatId string) error {
+	return r.client.Del(ctx, chatReplayKey(chatId), chatReplayCompleteKey(chatId)).Err()
+}
+
+func decodeReplayMessages(values []string) []entity.Message {
+	messages := make([]entity.Message, 0, len(values))
+	for _, value := range values {
+		var message entity.Message
+		if err := json.Unmarshal([]byte(value), &message); err != nil {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// mergeReplayWindow dedups both windows by message id and returns the newest size messages encoded, newest first
+func mergeReplayWindow(current []entity.Message, messages []entity.Message, size int) ([][]byte, error) {
+	window := newestMessages(current, messages, size)
+
+	encoded := make([][]byte, 0, len(window))
+	for _, message := range window {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded, nil
+}
+
+func newestMessages(current []entity.Message, messages []entity.Message, size int) []entity.Message {
+	seen := make(map[string]bool, len(current)+len(messages))
+	window := make([]entity.Message, 0, len(current)+len(messages))
+	for _, list := range [][]entity.Message{current, messages} {
+		for _, message := range list {
+			if seen[message.Id] {
+				continue
+			}
+			seen[message.Id] = true
+			window = append(window, message)
+		}
+	}
+
+	sort.SliceStable(window, func(i, j int) bool {
+		return window[i].Timestamp > window[j].Timestamp
+	})
+	if len(window) > size {
+		window = window[:size]
+	}
+	return window
+}
+
+// memReplayWindow is the cached value of a chat window
+type memReplayWindow struct {
+	messages []entity.Message
+	complete bool
+}
+
+type memReplayRepository struct {
+	// mu makes the read-merge-write of a window atomic, the cache expires idle windows
+	mu    sync.Mutex
+	cache *cache.MemCache
+}
+
+// NewMemReplayRepository keeps the windows of this server only
+func NewMemReplayRepository(cache *cache.MemCache) ReplayRepository {
+	return &memReplayRepository{
+		cache: cache,
+	}
+}
+
+func (r *memReplayRepository) Push(ctx context.Context, message entity.Message, size int, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	window := r.window(message.ChatId)
+	r.cache.Set(chatReplayKey(message.ChatId), memReplayWindow{
+		messages: newestMessages([]entity.Message{message}, window.messages, size),
+		complete: window.complete,
+	}, ttl)
+	return nil
+}
+
+func (r *memReplayRepository) Fill(ctx context.Context, chatId string, messages []entity.Message, size int, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	window := r.window(chatId)
+	r.cache.Set(chatReplayKey(chatId), memReplayWindow{
+		messages: newestMessages(window.messages, messages, size),
+		complete: true,
+	}, ttl)
+	return nil
+}
+
+func (r *memReplayRepository) GetRecent(ctx context.Context, chatId string, limit int) ([]entity.Message, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	window := r.window(chatId)
+	if len(window.messages) < limit && !window.complete {
+		return nil, false, nil
+	}
+
+	messages := window.messages
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return append([]entity.Message(nil), messages...), true, nil
+}
+
+func (r *memReplayRepository) Clear(ctx context.Context, chatId string) error {
+	r.cache.Delete(chatReplayKey(chatId))
+	return nil
+}
+
+// window must be called with the lock held, a missing window is empty and partial
+func (r *memReplayRepository) window(chatId string) memReplayWindow {
+	value, ok := r.cache.Get(chatReplayKey(chatId))
+	if !ok {
+		return memReplayWindow{}
+	}
+	return value.(memReplayWindow)
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrReportNotFound = errors.New("report not found")
+)
+
+type ReportRepository interface {
+	Create(ctx context.Context, report entity.Report) (entity.Report, error)
+	// ExistsByReporter reports whether the reporter already reported the target, whatever the status of the report
+	ExistsByReporter(ctx context.Context, reporterId string, targetType string, targetId string) (bool, error)
+	// List returns the reports matching the filter, oldest first
+	List(ctx context.Context, filter entity.ReportFilter) ([]entity.Report, error)
+	// Resolve closes an open report, it returns ErrReportNotFound when it is already closed
+	Resolve(ctx context.Context, reportId string, status string, resolverId string, note string) (entity.Report, error)
+	Indexes() []CollectionIndexes
+}
+
+type reportRepository struct {
+	db mongo.Database
+}
+
+func NewReportRepository(db mongo.Database) ReportRepository {
+	return &reportRepository{
+		db: db,
+	}
+}
+
+func (r *reportRepository) Create(ctx context.Context, report entity.Report) (entity.Report, error) {
+	collection := r.db.Collection("reports")
+	report.Id = uuid.New().String()
+	report.Status = entity.ReportStatusOpen
+	report.CreatedAt = time.Now()
+
+	if _, err := collection.InsertOne(ctx, report); err != nil {
+		return entity.Report{}, err
+	}
+	return report, nil
+}
+
+func (r *reportRepository) ExistsByReporter(ctx context.Context, reporterId string, targetType string, targetId string) (bool, error) {
+	collection := r.db.Collection("reports")
+	filter := bson.M{"reporterId": reporterId, "targetType": targetType, "targetId": targetId}
+
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *reportRepository) List(ctx context.Context, filter entity.ReportFilter) ([]entity.Report, error) {
+	collection := r.db.Collection("reports")
+
+	bsonFilter := bson.M{}
+	if filter.Status != "" {
+		bsonFilter["status"] = filter.Status
+	}
+	if filter.TargetType != "" {
+		bsonFilter["targetType"] = filter.TargetType
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	if filter.Offset > 0 {
+		opts.SetSkip(int64(filter.Offset))
+	}
+
+	cursor, err := collection.Find(ctx, bsonFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	reports := []entity.Report{}
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (r *reportRepository) Resolve(ctx context.Context, reportId string, status string, resolverId string, note string) (entity.Report, error) {
+	collection := r.db.Collection("reports")
+	filter := bson.M{"_id": reportId, "status": entity.ReportStatusOpen}
+	update := bson.M{"$set": bson.M{
+		"status":     status,
+		"resolvedBy": resolverId,
+		"resolvedAt": time.Now(),
+		"note":       note,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var report entity.Report
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&report)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.Report{}, ErrReportNotFound
+		}
+		return entity.Report{}, err
+	}
+
+	return report, nil
+}
+
+// Indexes serve the admin queue in order and the duplicate check of a reporter
+func (r *reportRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("reports"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: 1}}},
+			{Keys: bson.D{{Key: "reporterId", Value: 1}, {Key: "targetType", Value: 1}, {Key: "targetId", Value: 1}}},
+		},
+	}}
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+)
+
+type ScheduledMessageRepository interface {
+	Create(ctx context.Context, scheduled entity.ScheduledMessage) (entity.ScheduledMessage, error)
+	// ListPending returns the messages of the sender still waiting to be sent, the ones of a chat
+	// when chatId is set, the next due first
+	ListPending(ctx context.Context, senderId string, chatId string) ([]entity.ScheduledMessage, error)
+	CountPending(ctx context.Context, senderId string) (int64, error)
+	// Cancel cancels a pending message of the sender, it returns ErrScheduledMessageNotFound when
+	// it is not pending anymore
+	Cancel(ctx context.Context, scheduledId string, senderId string) (entity.ScheduledMessage, error)
+	// ClaimDue claims a message due at now for this server, and the messages claimed before
+	// staleBefore by a server that never finished them. It returns ErrScheduledMessageNotFound
+	// when there is none
+	ClaimDue(ctx context.Context, now time.Time, staleBefore time.Time) (entity.ScheduledMessage, error)
+	MarkSent(ctx context.Context, scheduledId string, messageId string) error
+	MarkFailed(ctx context.Context, scheduledId string, reason string) error
+	// Release gives a claimed message back, it is claimed again from retryAt
+	Release(ctx context.Context, scheduledId string, retryAt time.Time) error
+	Indexes() []CollectionIndexes
+}
+
+type scheduledMessageRepository struct {
+	db mongo.Database
+}
+
+func NewScheduledMessageRepository(db mongo.Database) ScheduledMessageRepository {
+	return &scheduledMessageRepository{
+		db: db,
+	}
+}
+
+func (r *scheduledMessageRepository) Create(ctx context.Context, scheduled entity.ScheduledMessage) (entity.ScheduledMessage, error) {
+	collection := r.db.Collection("scheduled_messages")
+	now := time.Now()
+	scheduled.Id = uuid.New().String()
+	scheduled.Status = entity.ScheduledMessageStatusPending
+	scheduled.CreatedAt = now
+	scheduled.UpdatedAt = now
+
+	if _, err := collection.InsertOne(ctx, scheduled); err != nil {
+		return entity.ScheduledMessage{}, err
+	}
+	return scheduled, nil
+}
+
+func (r *scheduledMessageRepository) ListPending(ctx context.Context, senderId string, chatId string) ([]entity.ScheduledMessage, error) {
+	collection := r.db.Collection("scheduled_messages")
+
+	filter := bson.M{"senderId": senderId, "status": entity.ScheduledMessageStatusPending}
+	if chatId != "" {
+		filter["chatId"] = chatId
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "scheduledAt", Value: 1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	scheduled := []entity.ScheduledMessage{}
+	if err := cursor.All(ctx, &scheduled); err != nil {
+		return nil, err
+	}
+
+	return scheduled, nil
+}
+
+func (r *scheduledMessageRepository) CountPending(ctx context.Context, senderId string) (int64, error) {
+	collection := r.db.Collection("scheduled_messages")
+	return collection.CountDocuments(ctx, bson.M{"senderId": senderId, "status": entity.ScheduledMessageStatusPending})
+}
+
+func (r *scheduledMessageRepository) Cancel(ctx context.Context, scheduledId string, senderId string) (entity.ScheduledMessage, error) {
+	collection := r.db.Collection("scheduled_messages")
+	filter := bson.M{"_id": scheduledId, "senderId": senderId, "status": entity.ScheduledMessageStatusPending}
+	update := bson.M{"$set": bson.M{"status": entity.ScheduledMessageStatusCanceled, "updatedAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var scheduled entity.ScheduledMessage
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&scheduled)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.ScheduledMessage{}, ErrScheduledMessageNotFound
+		}
+		return entity.ScheduledMessage{}, err
+	}
+
+	return scheduled, nil
+}
+
+func (r *scheduledMessageRepository) ClaimDue(ctx context.Context, now time.Time, staleBefore time.Time) (entity.ScheduledMessage, error) {
+	collection := r.db.Collection("scheduled_messages")
+	filter := bson.M{"$or": bson.A{
+		bson.M{
+			"status":      entity.ScheduledMessageStatusPending,
+			"scheduledAt": bson.M{"$lte": now},
+			"$or":         bson.A{bson.M{"retryAt": bson.M{"$exists": false}}, bson.M{"retryAt": bson.M{"$lte": now}}},
+		},
+		bson.M{"status": entity.ScheduledMessageStatusSending, "claimedAt": bson.M{"$lt": staleBefore}},
+	}}
+	update := bson.M{"$set": bson.M{
+		"status":    entity.ScheduledMessageStatusSending,
+		"claimedAt": now,
+		"updatedAt": now,
+	}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "scheduledAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var scheduled entity.ScheduledMessage
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&scheduled)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.ScheduledMessage{}, ErrScheduledMessageNotFound
+		}
+		return entity.ScheduledMessage{}, err
+	}
+
+	return scheduled, nil
+}
+
+func (r *scheduledMessageRepository) MarkSent(ctx context.Context, scheduledId string, messageId string) error {
+	return r.finish(ctx, scheduledId, bson.M{
+		"status":    entity.ScheduledMessageStatusSent,
+		"messageId": messageId,
+	})
+}
+
+func (r *scheduledMessageRepository) MarkFailed(ctx context.Context, scheduledId string, reason string) error {
+	return r.finish(ctx, scheduledId, bson.M{
+		"status": entity.ScheduledMessageStatusFailed,
+		"error":  reason,
+	})
+}
+
+func (r *scheduledMessageRepository) Release(ctx context.Context, scheduledId string, retryAt time.Time) error {
+	return r.finish(ctx, scheduledId, bson.M{
+		"status":  entity.ScheduledMessageStatusPending,
+		"retryAt": retryAt,
+	})
+}
+
+// finish updates a claimed message and drops its claim
+func (r *scheduledMessageRepository) finish(ctx context.Context, scheduledId string, set bson.M) error {
+	collection := r.db.Collection("scheduled_messages")
+	set["updatedAt"] = time.Now()
+	filter := bson.M{"_id": scheduledId, "status": entity.ScheduledMessageStatusSending}
+	update := bson.M{"$set": set, "$unset": bson.M{"claimedAt": ""}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrScheduledMessageNotFound
+	}
+	return nil
+}
+
+// Indexes serve the pending messages of a sender and the claims of the due ones
+func (r *scheduledMessageRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("scheduled_messages"),
+		Models: []mongo.IndexModel{
+			{Keys: bson.D{{Key: "senderId", Value: 1}, {Key: "status", Value: 1}, {Key: "scheduledAt", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "scheduledAt", Value: 1}}},
+		},
+	}}
+}
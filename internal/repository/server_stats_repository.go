@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ServerStatsRepository shares the stats of every server, a server missing its reports for
+// the ttl is gone from the list
+type ServerStatsRepository interface {
+	Save(ctx context.Context, stats entity.ServerStats, ttl time.Duration) error
+	// List returns the stats of the live servers, ordered by server id
+	List(ctx context.Context) ([]entity.ServerStats, error)
+}
+
+type redisServerStatsRepository struct {
+	client *redis.Client
+}
+
+func NewRedisServerStatsRepository(client *redis.Client) ServerStatsRepository {
+	return &redisServerStatsRepository{
+		client: client,
+	}
+}
+
+func serverStatsKey(serverId string) string {
+	return "server:stats:" + serverId
+}
+
+func (r *redisServerStatsRepository) Save(ctx context.Context, stats entity.ServerStats, ttl time.Duration) error {
+	encoded, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, serverStatsKey(stats.ServerId), encoded, ttl).Err()
+}
+
+func (r *redisServerStatsRepository) List(ctx context.Context) ([]entity.ServerStats, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, serverStatsKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	servers := []entity.ServerStats{}
+	if len(keys) == 0 {
+		return servers, nil
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		// Expired between the scan and the read
+		encoded, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var stats entity.ServerStats
+		if err := json.Unmarshal([]byte(encoded), &stats); err != nil {
+			return nil, err
+		}
+		servers = append(servers, stats)
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].ServerId < servers[j].ServerId
+	})
+	return servers, nil
+}
+
+type memServerStatsRepository struct {
+	mu        sync.Mutex
+	stats     entity.ServerStats
+	expiresAt time.Time
+}
+
+// NewMemServerStatsRepository only knows the stats of this server
+func NewMemServerStatsRepository() ServerStatsRepository {
+	return &memServerStatsRepository{}
+}
+
+func (r *memServerStatsRepository) Save(ctx context.Context, stats entity.ServerStats, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats = stats
+	r.expiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+func (r *memServerStatsRepository) List(ctx context.Context) ([]entity.ServerStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stats.ServerId == "" || time.Now().After(r.expiresAt) {
+		return []entity.ServerStats{}, nil
+	}
+	return []entity.ServerStats{r.stats}, nil
+}
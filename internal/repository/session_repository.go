@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionRepository stores short-lived sessions, entries expire on their own at ExpiresAt
+type SessionRepository interface {
+	Create(ctx context.Context, session entity.Session) error
+	Get(ctx context.Context, sessionId string) (entity.Session, error)
+	Refresh(ctx context.Context, sessionId string, expiresAt time.Time) error
+	Delete(ctx context.Context, sessionId string) error
+	GetBySubject(ctx context.Context, subject string) ([]entity.Session, error)
+	DeleteBySubject(ctx context.Context, subject string) error
+}
+
+type redisSessionRepository struct {
+	client *redis.Client
+}
+
+// NewRedisSessionRepository stores sessions in Redis so every server sees the same sessions
+func NewRedisSessionRepository(client *redis.Client) SessionRepository {
+	return &redisSessionRepository{
+		client: client,
+	}
+}
+
+func sessionKey(sessionId string) string {
+	return "session:" + sessionId
+}
+
+func subjectSessionsKey(subject string) string {
+	return "session:subject:" + subject
+}
+
+func (r *redisSessionRepository) Create(ctx context.Context, session entity.Session) error {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return errors.New("session is already expired")
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	// The subject index lives as long as the longest lived session of the subject
+	indexTtl, err := r.client.TTL(ctx, subjectSessionsKey(session.Subject)).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, sessionKey(session.Id), data, ttl)
+	pipe.SAdd(ctx, subjectSessionsKey(session.Subject), session.Id)
+	if indexTtl < ttl {
+		pipe.Expire(ctx, subjectSessionsKey(session.Subject), ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisSessionRepository) Get(ctx context.Context, sessionId string) (entity.Session, error) {
+	data, err := r.client.Get(ctx, sessionKey(sessionId)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return entity.Session{}, ErrSessionNotFound
+		}
+		return entity.Session{}, err
+	}
+
+	var session entity.Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return entity.Session{}, err
+	}
+
+	return session, nil
+}
+
+func (r *redisSessionRepository) Refresh(ctx context.Context, sessionId string, expiresAt time.Time) error {
+	session, err := r.Get(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	session.ExpiresAt = expiresAt
+	return r.Create(ctx, session)
+}
+
+func (r *redisSessionRepository) Delete(ctx context.Context, sessionId string) error {
+	session, err := r.Get(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, sessionKey(sessionId))
+	pipe.SRem(ctx, subjectSessionsKey(session.Subject), sessionId)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisSessionRepository) GetBySubject(ctx context.Context, subject string) ([]entity.Session, error) {
+	sessionIds, err := r.client.SMembers(ctx, subjectSessionsKey(subject)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]entity.Session, 0, len(sessionIds))
+	for _, sessionId := range sessionIds {
+		session, err := r.Get(ctx, sessionId)
+		if errors.Is(err, ErrSessionNotFound) {
+			// Expired, drop it from the index
+			r.client.SRem(ctx, subjectSessionsKey(subject), sessionId)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func (r *redisSessionRepository) DeleteBySubject(ctx context.Context, subject string) error {
+	sessionIds, err := r.client.SMembers(ctx, subjectSessionsKey(subject)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(sessionIds)+1)
+	for _, sessionId := range sessionIds {
+		keys = append(keys, sessionKey(sessionId))
+	}
+	keys = append(keys, subjectSessionsKey(subject))
+
+	return r.client.Del(ctx, keys...).Err()
+}
+
+type memSessionRepository struct {
+	cache *cache.MemCache
+}
+
+// NewMemSessionRepository keeps sessions in memory, for single server deployments without Redis
+func NewMemSessionRepository(memCache *cache.MemCache) SessionRepository {
+	return &memSessionRepository{
+		cache: memCache,
+	}
+}
+
+func (r *memSessionRepository) Create(ctx context.Context, session entity.Session) error {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return errors.New("session is already expired")
+	}
+
+	r.cache.Set(sessionKey(session.Id), session, ttl)
+	return nil
+}
+
+func (r *memSessionRepository) Get(ctx context.Context, sessionId string) (entity.Session, error) {
+	value, ok := r.cache.Get(sessionKey(sessionId))
+	if !ok {
+		return entity.Session{}, ErrSessionNotFound
+	}
+
+	session, ok := value.(entity.Session)
+	if !ok {
+		return entity.Session{}, ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+func (r *memSessionRepository) Refresh(ctx context.Context, sessionId string, expiresAt time.Time) error {
+	session, err := r.Get(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+
+	session.ExpiresAt = expiresAt
+	return r.Create(ctx, session)
+}
+
+func (r *memSessionRepository) Delete(ctx context.Context, sessionId string) error {
+	if _, err := r.Get(ctx, sessionId); err != nil {
+		return err
+	}
+
+	r.cache.Delete(sessionKey(sessionId))
+	return nil
+}
+
+func (r *memSessionRepository) GetBySubject(ctx context.Context, subject string) ([]entity.Session, error) {
+	sessions := make([]entity.Session, 0)
+	r.cache.Range(func(key, value any) bool {
+		if session, ok := value.(entity.Session); ok && session.Subject == subject {
+			sessions = append(sessions, session)
+		}
+		return true
+	})
+
+	return sessions, nil
+}
+
+func (r *memSessionRepository) DeleteBySubject(ctx context.Context, subject string) error {
+	sessions, err := r.GetBySubject(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		r.cache.Delete(sessionKey(session.Id))
+	}
+	return nil
+}
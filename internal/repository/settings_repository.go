@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrSettingsNotFound = errors.New("workspace settings not found")
+)
+
+type SettingsRepository interface {
+	Get(ctx context.Context, workspaceId string) (entity.WorkspaceSettings, error)
+	Upsert(ctx context.Context, settings entity.WorkspaceSettings) error
+	GetWithRetention(ctx context.Context) ([]entity.WorkspaceSettings, error)
+}
+
+type settingsRepository struct {
+	db mongo.Database
+}
+
+func NewSettingsRepository(db mongo.Database) SettingsRepository {
+	return &settingsRepository{
+		db: db,
+	}
+}
+
+// Get returns the settings of a workspace
+func (r *settingsRepository) Get(ctx context.Context, workspaceId string) (entity.WorkspaceSettings, error) {
+	collection := r.db.Collection("workspace_settings")
+	filter := bson.M{"_id": workspaceId}
+
+	var settings entity.WorkspaceSettings
+	err := collection.FindOne(ctx, filter).Decode(&settings)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.WorkspaceSettings{}, ErrSettingsNotFound
+		}
+		return entity.WorkspaceSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// Upsert creates or replaces the settings of a workspace
+func (r *settingsRepository) Upsert(ctx context.Context, settings entity.WorkspaceSettings) error {
+	collection := r.db.Collection("workspace_settings")
+	filter := bson.M{"_id": settings.WorkspaceId}
+	settings.UpdatedAt = time.Now()
+
+	_, err := collection.ReplaceOne(ctx, filter, settings, options.Replace().SetUpsert(true))
+	return err
+}
+
+// GetWithRetention returns the settings of every workspace that has a retention period
+func (r *settingsRepository) GetWithRetention(ctx context.Context) ([]entity.WorkspaceSettings, error) {
+	collection := r.db.Collection("workspace_settings")
+	filter := bson.M{"retentionDays": bson.M{"$gt": 0}}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings []entity.WorkspaceSettings
+	err = cursor.All(ctx, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
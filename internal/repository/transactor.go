@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs a function in a transaction, the repository calls made with the context it is
+// given are committed or aborted together
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type mongoTransactor struct {
+	client *mongo.Client
+}
+
+// NewMongoTransactor uses Mongo transactions, they need a replica set or a sharded cluster
+func NewMongoTransactor(db mongo.Database) Transactor {
+	return &mongoTransactor{
+		client: db.Client(),
+	}
+}
+
+// WithTransaction runs fn in a session transaction, the driver retries it on transient errors so
+// fn must only touch the database
+func (t *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (any, error) {
+		return nil, fn(sessionCtx)
+	})
+	return err
+}
+
+type noTransactor struct{}
+
+// NewNoTransactor runs the functions as they are, for standalone Mongo servers. A crash halfway
+// leaves the writes made so far
+func NewNoTransactor() Transactor {
+	return noTransactor{}
+}
+
+func (noTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
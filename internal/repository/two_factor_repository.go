@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"wetalk/internal/entity"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrTwoFactorNotFound          = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorChallengeNotFound = errors.New("two-factor challenge not found")
+)
+
+type TwoFactorRepository interface {
+	Get(ctx context.Context, userId string) (entity.TwoFactor, error)
+	Upsert(ctx context.Context, twoFactor entity.TwoFactor) error
+	UseStep(ctx context.Context, userId string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userId string, codeHash string) (bool, error)
+
+	CreateChallenge(ctx context.Context, challenge entity.TwoFactorChallenge) error
+	GetChallenge(ctx context.Context, challengeId string) (entity.TwoFactorChallenge, error)
+	IncrementChallengeAttempts(ctx context.Context, challengeId string) error
+	DeleteChallenge(ctx context.Context, challengeId string) error
+	Indexes() []CollectionIndexes
+}
+
+type twoFactorRepository struct {
+	db mongo.Database
+}
+
+func NewTwoFactorRepository(db mongo.Database) TwoFactorRepository {
+	return &twoFactorRepository{
+		db: db,
+	}
+}
+
+// Get returns the two-factor settings of a user
+func (r *twoFactorRepository) Get(ctx context.Context, userId string) (entity.TwoFactor, error) {
+	collection := r.db.Collection("two_factors")
+	filter := bson.M{"_id": userId}
+
+	var twoFactor entity.TwoFactor
+	err := collection.FindOne(ctx, filter).Decode(&twoFactor)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.TwoFactor{}, ErrTwoFactorNotFound
+		}
+		return entity.TwoFactor{}, err
+	}
+
+	return twoFactor, nil
+}
+
+// Upsert creates or replaces the two-factor settings of a user
+func (r *twoFactorRepository) Upsert(ctx context.Context, twoFactor entity.TwoFactor) error {
+	collection := r.db.Collection("two_factors")
+	filter := bson.M{"_id": twoFactor.UserId}
+
+	_, err := collection.ReplaceOne(ctx, filter, twoFactor, options.Replace().SetUpsert(true))
+	return err
+}
+
+// UseStep records the time step of an accepted code, it reports false when that step or a later one was already used
+func (r *twoFactorRepository) UseStep(ctx context.Context, userId string, step int64) (bool, error) {
+	collection := r.db.Collection("two_factors")
+	filter := bson.M{
+		"_id":          userId,
+		"lastUsedStep": bson.M{"$lt": step},
+	}
+	update := bson.M{"$set": bson.M{"lastUsedStep": step}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// UseRecoveryCode removes a recovery code, it reports false when the user has no such code
+func (r *twoFactorRepository) UseRecoveryCode(ctx context.Context, userId string, codeHash string) (bool, error) {
+	collection := r.db.Collection("two_factors")
+	filter := bson.M{
+		"_id":           userId,
+		"enabled":       true,
+		"recoveryCodes": codeHash,
+	}
+	update := bson.M{"$pull": bson.M{"recoveryCodes": codeHash}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *twoFactorRepository) CreateChallenge(ctx context.Context, challenge entity.TwoFactorChallenge) error {
+	collection := r.db.Collection("two_factor_challenges")
+
+	_, err := collection.InsertOne(ctx, challenge)
+	return err
+}
+
+func (r *twoFactorRepository) GetChallenge(ctx context.Context, challengeId string) (entity.TwoFactorChallenge, error) {
+	collection := r.db.Collection("two_factor_challenges")
+	filter := bson.M{"_id": challengeId}
+
+	var challenge entity.TwoFactorChallenge
+	err := collection.FindOne(ctx, filter).Decode(&challenge)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.TwoFactorChallenge{}, ErrTwoFactorChallengeNotFound
+		}
+		return entity.TwoFactorChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+func (r *twoFactorRepository) IncrementChallengeAttempts(ctx context.Context, challengeId string) error {
+	collection := r.db.Collection("two_factor_challenges")
+	filter := bson.M{"_id": challengeId}
+	update := bson.M{"$inc": bson.M{"attempts": 1}}
+
+	_, err := collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *twoFactorRepository) DeleteChallenge(ctx context.Context, challengeId string) error {
+	collection := r.db.Collection("two_factor_challenges")
+	filter := bson.M{"_id": challengeId}
+
+	_, err := collection.DeleteOne(ctx, filter)
+	return err
+}
+
+// Indexes let Mongo delete the challenges once they expire
+func (r *twoFactorRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("two_factor_challenges"),
+		Models: []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+	}}
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"wetalk/internal/entity"
+)
+
+// cachedUserRepository serves Get, read for the sender of every message and most requests, from
+// the cache. The writes to the users go through it so it drops the profiles they change
+type cachedUserRepository struct {
+	UserRepository
+	recordCache RecordCacheRepository
+	ttl         time.Duration
+}
+
+// NewCachedUserRepository caches the users read by id for ttl, the lookups by email and username
+// used to sign in always read the database
+func NewCachedUserRepository(userRepo UserRepository, recordCache RecordCacheRepository, ttl time.Duration) UserRepository {
+	return &cachedUserRepository{
+		UserRepository: userRepo,
+		recordCache:    recordCache,
+		ttl:            ttl,
+	}
+}
+
+func (r *cachedUserRepository) Get(ctx context.Context, userId string) (entity.User, error) {
+	return readThrough(ctx, r.recordCache, userRecordKey(userId), r.ttl, func() (entity.User, error) {
+		return r.UserRepository.Get(ctx, userId)
+	})
+}
+
+func (r *cachedUserRepository) Update(ctx context.Context, user entity.User) error {
+	err := r.UserRepository.Update(ctx, user)
+	invalidateRecord(ctx, r.recordCache, userRecordKey(user.Id))
+	return err
+}
+
+func (r *cachedUserRepository) UpdateProfile(ctx context.Context, user entity.User) error {
+	err := r.UserRepository.UpdateProfile(ctx, user)
+	invalidateRecord(ctx, r.recordCache, userRecordKey(user.Id))
+	return err
+}
+
+func (r *cachedUserRepository) UpdatePassword(ctx context.Context, userId string, password string) error {
+	err := r.UserRepository.UpdatePassword(ctx, userId, password)
+	invalidateRecord(ctx, r.recordCache, userRecordKey(userId))
+	return err
+}
+
+func (r *cachedUserRepository) SetDeactivated(ctx context.Context, userId string, deactivatedAt *time.Time) error {
+	err := r.UserRepository.SetDeactivated(ctx, userId, deactivatedAt)
+	invalidateRecord(ctx, r.recordCache, userRecordKey(userId))
+	return err
+}
+
+func (r *cachedUserRepository) SetMergedInto(ctx context.Context, userId string, mergedInto string) error {
+	err := r.UserRepository.SetMergedInto(ctx, userId, mergedInto)
+	invalidateRecord(ctx, r.recordCache, userRecordKey(userId))
+	return err
+}
@@ -3,12 +3,15 @@ package repository
 import (
 	"context"
 	"errors"
+	"regexp"
 	"time"
 	"wetalk/internal/entity"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -24,9 +27,18 @@ type UserRepository interface {
 	GetByUsername(ctx context.Context, username string) (entity.User, error)
 	Create(ctx context.Context, user entity.User) (string, error)
 	Update(ctx context.Context, user entity.User) error
+	UpdateProfile(ctx context.Context, user entity.User) error
+	UpdatePassword(ctx context.Context, userId string, password string) error
+	// Search lists the users of every workspace matching the filter, newest first
+	Search(ctx context.Context, filter entity.AdminUserFilter) ([]entity.User, error)
+	// SetDeactivated deactivates the user at the given time, or reactivates them when nil
+	SetDeactivated(ctx context.Context, userId string, deactivatedAt *time.Time) error
+	// SetMergedInto records the account the user was merged into, an empty id clears it
+	SetMergedInto(ctx context.Context, userId string, mergedInto string) error
 	GetOnlineUser(ctx context.Context, userIds []string) ([]entity.User, error)
 	EmailExists(ctx context.Context, email string) (bool, error)
 	UsernameExists(ctx context.Context, username string) (bool, error)
+	Indexes() []CollectionIndexes
 }
 
 type userRepository struct {
@@ -39,6 +51,11 @@ func NewUserRepository(db mongo.Database) UserRepository {
 	}
 }
 
+// userDerivedFields are the stored fields the computed fields of a public profile are made from
+var userDerivedFields = map[string][]string{
+	"status": {"isOnline"},
+}
+
 func (r *userRepository) Index(ctx context.Context, filter entity.UserIndexFilter) ([]entity.User, error) {
 	collection := r.db.Collection("users")
 
@@ -47,7 +64,12 @@ func (r *userRepository) Index(ctx context.Context, filter entity.UserIndexFilte
 		bsonFilter = bson.M{"_id": bson.M{"$in": filter.Ids}}
 	}
 
-	cursor, err := collection.Find(ctx, bsonFilter)
+	opts := options.Find()
+	if selected := projection(entity.User{}, filter.Fields, userDerivedFields); selected != nil {
+		opts.SetProjection(selected)
+	}
+
+	cursor, err := collection.Find(ctx, bsonFilter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +90,7 @@ func (r *userRepository) Get(ctx context.Context, userId string) (entity.User, e
 	var user entity.User
 	err := collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.User{}, ErrUserNotFound
 		}
 		return entity.User{}, err
@@ -84,7 +106,7 @@ func (r *userRepository) GetByEmail(ctx context.Context, email string) (entity.U
 	var user entity.User
 	err := collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.User{}, ErrUserNotFound
 		}
 		return entity.User{}, err
@@ -100,7 +122,7 @@ func (r *userRepository) GetByUsername(ctx context.Context, username string) (en
 	var user entity.User
 	err := collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, mongo.ErrNoDocuments) {
 			return entity.User{}, ErrUserNotFound
 		}
 		return entity.User{}, err
@@ -142,6 +164,161 @@ func (r *userRepository) Update(ctx context.Context, user entity.User) error {
 	return err
 }
 
+// UpdateProfile only writes the self-service profile fields, leaving presence and credentials alone
+func (r *userRepository) UpdateProfile(ctx context.Context, user entity.User) error {
+	collection := r.db.Collection("users")
+	filter := bson.M{"_id": user.Id}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":      user.Name,
+			"username":  user.Username,
+			"bio":       user.Bio,
+			"avatar":    user.Avatar,
+			"timezone":  user.Timezone,
+			"locale":    user.Locale,
+			"updatedAt": time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword stores an already hashed password
+func (r *userRepository) UpdatePassword(ctx context.Context, userId string, password string) error {
+	collection := r.db.Collection("users")
+	filter := bson.M{"_id": userId}
+
+	update := bson.M{
+		"$set": bson.M{
+			"password":  password,
+			"updatedAt": time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *userRepository) Search(ctx context.Context, filter entity.AdminUserFilter) ([]entity.User, error) {
+	collection := r.db.Collection("users")
+
+	bsonFilter := bson.M{}
+	if filter.Query != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Query), Options: "i"}
+		bsonFilter["$or"] = bson.A{
+			bson.M{"username": bson.M{"$regex": pattern}},
+			bson.M{"name": bson.M{"$regex": pattern}},
+			bson.M{"email": bson.M{"$regex": pattern}},
+		}
+	}
+	if filter.Role != "" {
+		bsonFilter["role"] = filter.Role
+	}
+	if filter.WorkspaceId != "" {
+		if filter.WorkspaceId == entity.DefaultWorkspaceId {
+			// Users created before workspaces existed have no workspaceId
+			bsonFilter["workspaceId"] = bson.M{"$in": bson.A{nil, "", filter.WorkspaceId}}
+		} else {
+			bsonFilter["workspaceId"] = filter.WorkspaceId
+		}
+	}
+	if filter.Deactivated != nil {
+		bsonFilter["deactivatedAt"] = bson.M{"$exists": *filter.Deactivated}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	if filter.Offset > 0 {
+		opts.SetSkip(int64(filter.Offset))
+	}
+
+	cursor, err := collection.Find(ctx, bsonFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []entity.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *userRepository) SetDeactivated(ctx context.Context, userId string, deactivatedAt *time.Time) error {
+	collection := r.db.Collection("users")
+	filter := bson.M{"_id": userId}
+
+	update := bson.M{
+		"$set":   bson.M{"updatedAt": time.Now()},
+		"$unset": bson.M{"deactivatedAt": ""},
+	}
+	if deactivatedAt != nil {
+		update = bson.M{
+			"$set": bson.M{
+				"deactivatedAt": *deactivatedAt,
+				"updatedAt":     time.Now(),
+			},
+		}
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (r *userRepository) SetMergedInto(ctx context.Context, userId string, mergedInto string) error {
+	collection := r.db.Collection("users")
+	filter := bson.M{"_id": userId}
+
+	update := bson.M{
+		"$set":   bson.M{"updatedAt": time.Now()},
+		"$unset": bson.M{"mergedInto": ""},
+	}
+	if mergedInto != "" {
+		update = bson.M{
+			"$set": bson.M{
+				"mergedInto": mergedInto,
+				"updatedAt":  time.Now(),
+			},
+		}
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
 func (r *userRepository) GetOnlineUser(ctx context.Context, userIds []string) ([]entity.User, error) {
 	collection := r.db.Collection("users")
 
@@ -190,4 +367,21 @@ func (r *userRepository) UsernameExists(ctx context.Context, username string) (b
 	}
 	
 	return count > 0, nil
-}
\ No newline at end of file
+}
+
+// Indexes make emails and usernames unique, users created without one are left out
+func (r *userRepository) Indexes() []CollectionIndexes {
+	return []CollectionIndexes{{
+		Collection: r.db.Collection("users"),
+		Models: []mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "email", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"email": bson.M{"$gt": ""}}),
+			},
+			{
+				Keys:    bson.D{{Key: "username", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"username": bson.M{"$gt": ""}}),
+			},
+		},
+	}}
+}
@@ -0,0 +1,307 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrAccountSuspended      = errors.New("this account is suspended")
+	ErrAccountMuted          = errors.New("this account is muted")
+	ErrSendRateLimited       = errors.New("this account is rate limited, wait before sending another message")
+	ErrNoRestriction         = errors.New("this account has no active restriction")
+	ErrAppealAlreadySent     = errors.New("an appeal was already submitted for this restriction")
+	ErrInvalidReviewDecision = errors.New("decision must be uphold or lift")
+)
+
+// AbusePolicy configures the auto-moderation rules engine
+type AbusePolicy struct {
+	Rules []entity.AbuseRule
+	// LimitedSendInterval is the minimum time between two messages of a rate limited account
+	LimitedSendInterval time.Duration
+	// The same message sent SpamRepeatThreshold times within SpamRepeatWindow is flagged as spam
+	SpamRepeatThreshold int
+	SpamRepeatWindow    time.Duration
+}
+
+func DefaultAbusePolicy() AbusePolicy {
+	return AbusePolicy{
+		Rules: []entity.AbuseRule{
+			{Signal: entity.AbuseSignalSpam, Threshold: 3, Window: time.Hour, Action: entity.AbuseActionRateLimit, Duration: 24 * time.Hour},
+			{Signal: entity.AbuseSignalModerationHit, Threshold: 5, Window: time.Hour, Action: entity.AbuseActionMute, Duration: time.Hour},
+			{Signal: entity.AbuseSignalModerationHit, Threshold: 20, Window: 24 * time.Hour, Action: entity.AbuseActionSuspend},
+			{Signal: entity.AbuseSignalReport, Threshold: 5, Window: 24 * time.Hour, Action: entity.AbuseActionSuspend},
+		},
+		LimitedSendInterval: 30 * time.Second,
+		SpamRepeatThreshold: 5,
+		SpamRepeatWindow:    time.Minute,
+	}
+}
+
+// AbuseUsecase collects abuse signals and restricts the accounts that cross the configured thresholds
+type AbuseUsecase interface {
+	RecordSignal(ctx context.Context, userId string, kind string, sourceId string) error
+	// ObserveMessage flags users repeatedly sending the same message
+	ObserveMessage(ctx context.Context, message entity.Message)
+	CheckCanSend(ctx context.Context, userId string) error
+	CheckNotSuspended(ctx context.Context, userId string) error
+
+	GetRestriction(ctx context.Context, userId string) (entity.AccountRestriction, error)
+	SubmitAppeal(ctx context.Context, userId string, req entity.AppealRequest) (entity.AccountRestriction, error)
+	GetReviewQueue(ctx context.Context) ([]entity.AccountRestriction, error)
+	Review(ctx context.Context, userId string, adminId string, req entity.ReviewRestrictionRequest) (entity.AccountRestriction, error)
+}
+
+type abuseUsecase struct {
+	abuseRepo repository.AbuseRepository
+	// recentMessages counts the repetitions of a message per sender
+	recentMessages cache.Cache
+	policy         AbusePolicy
+}
+
+func NewAbuseUsecase(abuseRepo repository.AbuseRepository, recentMessages cache.Cache, policy AbusePolicy) AbuseUsecase {
+	return &abuseUsecase{
+		abuseRepo:      abuseRepo,
+		recentMessages: recentMessages,
+		policy:         policy,
+	}
+}
+
+func (a *abuseUsecase) RecordSignal(ctx context.Context, userId string, kind string, sourceId string) error {
+	err := a.abuseRepo.CreateSignal(ctx, entity.AbuseSignal{
+		UserId:   userId,
+		Kind:     kind,
+		SourceId: sourceId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.evaluate(ctx, userId, kind)
+}
+
+// evaluate applies the most severe rule of the signal kind whose threshold is reached
+func (a *abuseUsecase) evaluate(ctx context.Context, userId string, kind string) error {
+	now := time.Now()
+	current, err := a.abuseRepo.GetRestriction(ctx, userId)
+	if err != nil && !errors.Is(err, repository.ErrRestrictionNotFound) {
+		return err
+	}
+	hasCurrent := err == nil
+
+	var fired *entity.AbuseRule
+	var count int64
+	for i, rule := range a.policy.Rules {
+		if rule.Signal != kind {
+			continue
+		}
+
+		// Signals already judged by an admin lifting a restriction don't count again
+		since := now.Add(-rule.Window)
+		if hasCurrent && current.ReviewStatus == entity.ReviewStatusLifted && current.UpdatedAt.After(since) {
+			since = current.UpdatedAt
+		}
+
+		ruleCount, err := a.abuseRepo.CountSignals(ctx, userId, kind, since)
+		if err != nil {
+			return err
+		}
+		if ruleCount < int64(rule.Threshold) {
+			continue
+		}
+		if fired == nil || actionSeverity(rule.Action) > actionSeverity(fired.Action) {
+			fired = &a.policy.Rules[i]
+			count = ruleCount
+		}
+	}
+	if fired == nil {
+		return nil
+	}
+
+	// Never downgrade an active restriction, and respect an admin decision on it
+	if hasCurrent && current.IsActive(now) && (actionSeverity(current.Action) >= actionSeverity(fired.Action) || current.ReviewStatus == entity.ReviewStatusUpheld) {
+		return nil
+	}
+
+	restriction := entity.AccountRestriction{
+		UserId:       userId,
+		Action:       fired.Action,
+		Reason:       fmt.Sprintf("%d %s signals within %s", count, kind, fired.Window),
+		ReviewStatus: entity.ReviewStatusPending,
+		CreatedAt:    now,
+	}
+	if fired.Duration > 0 {
+		until := now.Add(fired.Duration)
+		restriction.Until = &until
+	}
+
+	slog.WarnContext(ctx, "Automatic restriction applied", "action", restriction.Action, "restricted_user_id", userId, "reason", restriction.Reason)
+	return a.abuseRepo.SaveRestriction(ctx, restriction)
+}
+
+func actionSeverity(action string) int {
+	switch action {
+	case entity.AbuseActionRateLimit:
+		return 1
+	case entity.AbuseActionMute:
+		return 2
+	case entity.AbuseActionSuspend:
+		return 3
+	default:
+		return 0
+	}
+}
+
+func (a *abuseUsecase) ObserveMessage(ctx context.Context, message entity.Message) {
+	text := strings.ToLower(strings.TrimSpace(message.Message))
+	if text == "" || a.policy.SpamRepeatThreshold <= 0 {
+		return
+	}
+
+	digest := sha256.Sum256([]byte(text))
+	key := "spam:" + message.SenderId + ":" + hex.EncodeToString(digest[:])
+
+	count, err := a.recentMessages.Increment(ctx, key, 1, a.policy.SpamRepeatWindow)
+	if err != nil {
+		slog.ErrorContext(ctx, "Count repeated message error", "error", err)
+		return
+	}
+
+	if count != int64(a.policy.SpamRepeatThreshold) {
+		return
+	}
+	if err := a.RecordSignal(ctx, message.SenderId, entity.AbuseSignalSpam, message.Id); err != nil {
+		slog.ErrorContext(ctx, "Record spam signal error", "error", err)
+	}
+}
+
+func (a *abuseUsecase) CheckCanSend(ctx context.Context, userId string) error {
+	restriction, err := a.activeRestriction(ctx, userId)
+	if err != nil {
+		if errors.Is(err, ErrNoRestriction) {
+			return nil
+		}
+		return err
+	}
+
+	switch restriction.Action {
+	case entity.AbuseActionSuspend:
+		return ErrAccountSuspended
+	case entity.AbuseActionMute:
+		return ErrAccountMuted
+	case entity.AbuseActionRateLimit:
+		claimed, err := a.abuseRepo.ClaimSendSlot(ctx, userId, a.policy.LimitedSendInterval)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return ErrSendRateLimited
+		}
+	}
+
+	return nil
+}
+
+func (a *abuseUsecase) CheckNotSuspended(ctx context.Context, userId string) error {
+	restriction, err := a.activeRestriction(ctx, userId)
+	if err != nil {
+		if errors.Is(err, ErrNoRestriction) {
+			return nil
+		}
+		return err
+	}
+
+	if restriction.Action == entity.AbuseActionSuspend {
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+func (a *abuseUsecase) activeRestriction(ctx context.Context, userId string) (entity.AccountRestriction, error) {
+	restriction, err := a.abuseRepo.GetRestriction(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrRestrictionNotFound) {
+			return entity.AccountRestriction{}, ErrNoRestriction
+		}
+		return entity.AccountRestriction{}, err
+	}
+	if !restriction.IsActive(time.Now()) {
+		return entity.AccountRestriction{}, ErrNoRestriction
+	}
+
+	return restriction, nil
+}
+
+func (a *abuseUsecase) GetRestriction(ctx context.Context, userId string) (entity.AccountRestriction, error) {
+	return a.activeRestriction(ctx, userId)
+}
+
+// SubmitAppeal puts the restriction back in the admin review queue with the user's explanation
+func (a *abuseUsecase) SubmitAppeal(ctx context.Context, userId string, req entity.AppealRequest) (entity.AccountRestriction, error) {
+	restriction, err := a.activeRestriction(ctx, userId)
+	if err != nil {
+		return entity.AccountRestriction{}, err
+	}
+	if restriction.Appeal != nil {
+		return entity.AccountRestriction{}, ErrAppealAlreadySent
+	}
+
+	restriction.Appeal = &entity.Appeal{
+		Message:     req.Message,
+		Status:      entity.AppealStatusPending,
+		SubmittedAt: time.Now(),
+	}
+	if err := a.abuseRepo.SaveRestriction(ctx, restriction); err != nil {
+		return entity.AccountRestriction{}, err
+	}
+
+	return restriction, nil
+}
+
+func (a *abuseUsecase) GetReviewQueue(ctx context.Context) ([]entity.AccountRestriction, error) {
+	return a.abuseRepo.GetReviewQueue(ctx)
+}
+
+// Review upholds or lifts a restriction, which also settles a pending appeal
+func (a *abuseUsecase) Review(ctx context.Context, userId string, adminId string, req entity.ReviewRestrictionRequest) (entity.AccountRestriction, error) {
+	if req.Decision != entity.ReviewDecisionUphold && req.Decision != entity.ReviewDecisionLift {
+		return entity.AccountRestriction{}, invalidField("decision", ErrInvalidReviewDecision)
+	}
+
+	restriction, err := a.activeRestriction(ctx, userId)
+	if err != nil {
+		return entity.AccountRestriction{}, err
+	}
+
+	now := time.Now()
+	restriction.ReviewedBy = adminId
+	if req.Decision == entity.ReviewDecisionLift {
+		restriction.ReviewStatus = entity.ReviewStatusLifted
+	} else {
+		restriction.ReviewStatus = entity.ReviewStatusUpheld
+	}
+
+	if restriction.Appeal != nil && restriction.Appeal.Status == entity.AppealStatusPending {
+		restriction.Appeal.ReviewedAt = &now
+		if req.Decision == entity.ReviewDecisionLift {
+			restriction.Appeal.Status = entity.AppealStatusApproved
+		} else {
+			restriction.Appeal.Status = entity.AppealStatusRejected
+		}
+	}
+
+	if err := a.abuseRepo.SaveRestriction(ctx, restriction); err != nil {
+		return entity.AccountRestriction{}, err
+	}
+
+	return restriction, nil
+}
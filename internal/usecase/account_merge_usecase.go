@@ -0,0 +1,252 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrAccountMergeNotFound   = errors.New("account merge not found or already rolled back")
+	ErrMergeSameAccount       = errors.New("an account can't be merged into itself")
+	ErrAccountMerged          = errors.New("the account was merged into another one")
+	ErrMergeWorkspaceMismatch = errors.New("both accounts must belong to the same workspace")
+	ErrPrimaryDeactivated     = errors.New("the primary account is deactivated, reactivate it first")
+	ErrMergeNotLatest         = errors.New("the primary account was merged into another one since, roll that merge back first")
+	ErrInvalidMergeReason     = errors.New("reason must be at most 500 characters")
+)
+
+const maxMergeReasonLength = 500
+
+// AccountMergeUsecase folds the duplicate account of a person, such as one registered twice with two
+// emails, into their primary account. It is reserved to the super admins
+type AccountMergeUsecase interface {
+	// Merge moves the chats, messages, receipts and refresh tokens of the duplicate to the primary
+	// account and deactivates the duplicate, all at once when Mongo transactions are enabled. The
+	// chats both took part in keep the participation of the primary account only
+	Merge(ctx context.Context, adminId string, req entity.MergeAccountsRequest) (entity.AccountMerge, error)
+	Get(ctx context.Context, mergeId string) (entity.AccountMerge, error)
+	ListByUser(ctx context.Context, userId string) ([]entity.AccountMerge, error)
+	// Rollback gives the duplicate back what the merge moved and reactivates it. What the primary
+	// account did since stays with it, such as the tokens its devices refreshed since
+	Rollback(ctx context.Context, mergeId string, adminId string, req entity.RollbackAccountMergeRequest) (entity.AccountMerge, error)
+}
+
+type accountMergeUsecase struct {
+	accountMergeRepo repository.AccountMergeRepository
+	userRepo         repository.UserRepository
+	chatRepo         repository.ChatRepository
+	messageRepo      repository.MessageRepository
+	receiptRepo      repository.ReceiptRepository
+	refreshTokenRepo repository.RefreshTokenRepository
+	auditRepo        repository.AuditRepository
+	transactor       repository.Transactor
+}
+
+func NewAccountMergeUsecase(accountMergeRepo repository.AccountMergeRepository, userRepo repository.UserRepository, chatRepo repository.ChatRepository, messageRepo repository.MessageRepository, receiptRepo repository.ReceiptRepository, refreshTokenRepo repository.RefreshTokenRepository, auditRepo repository.AuditRepository, transactor repository.Transactor) AccountMergeUsecase {
+	return &accountMergeUsecase{
+		accountMergeRepo: accountMergeRepo,
+		userRepo:         userRepo,
+		chatRepo:         chatRepo,
+		messageRepo:      messageRepo,
+		receiptRepo:      receiptRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		auditRepo:        auditRepo,
+		transactor:       transactor,
+	}
+}
+
+func (u *accountMergeUsecase) Merge(ctx context.Context, adminId string, req entity.MergeAccountsRequest) (entity.AccountMerge, error) {
+	if req.PrimaryId == req.DuplicateId {
+		return entity.AccountMerge{}, invalidField("duplicateId", ErrMergeSameAccount)
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > maxMergeReasonLength {
+		return entity.AccountMerge{}, invalidField("reason", ErrInvalidMergeReason)
+	}
+
+	primary, err := u.getUser(ctx, req.PrimaryId)
+	if err != nil {
+		return entity.AccountMerge{}, err
+	}
+	duplicate, err := u.getUser(ctx, req.DuplicateId)
+	if err != nil {
+		return entity.AccountMerge{}, err
+	}
+	switch {
+	case primary.MergedInto != "" || duplicate.MergedInto != "":
+		return entity.AccountMerge{}, ErrAccountMerged
+	case primary.DeactivatedAt != nil:
+		return entity.AccountMerge{}, ErrPrimaryDeactivated
+	case primary.GetWorkspaceId() != duplicate.GetWorkspaceId():
+		return entity.AccountMerge{}, ErrMergeWorkspaceMismatch
+	}
+
+	merge := entity.AccountMerge{
+		PrimaryId:   primary.Id,
+		DuplicateId: duplicate.Id,
+		Reason:      req.Reason,
+		MergedBy:    adminId,
+	}
+	err = u.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		merge.MovedChatIds, merge.ClosedChatIds, err = u.chatRepo.MoveParticipations(ctx, duplicate.Id, primary.Id)
+		if err != nil {
+			return err
+		}
+		if merge.MessagesMoved, err = u.messageRepo.MoveSender(ctx, duplicate.Id, primary.Id); err != nil {
+			return err
+		}
+		// The primary account has its own receipts in the chats both took part in
+		if merge.ReceiptsMoved, err = u.receiptRepo.MoveRecipient(ctx, duplicate.Id, primary.Id, merge.MovedChatIds); err != nil {
+			return err
+		}
+		if merge.TokensMoved, err = u.refreshTokenRepo.MoveUser(ctx, duplicate.Id, primary.Id); err != nil {
+			return err
+		}
+
+		merge.DuplicateDeactivated = false
+		if duplicate.DeactivatedAt == nil {
+			now := time.Now()
+			if err := u.userRepo.SetDeactivated(ctx, duplicate.Id, &now); err != nil {
+				return err
+			}
+			merge.DuplicateDeactivated = true
+		}
+		if err := u.userRepo.SetMergedInto(ctx, duplicate.Id, primary.Id); err != nil {
+			return err
+		}
+
+		created, err := u.accountMergeRepo.Create(ctx, merge)
+		if err != nil {
+			return err
+		}
+		merge = created
+		return u.auditRepo.Create(ctx, entity.AuditLog{
+			Action:  entity.AuditActionAccountsMerged,
+			UserId:  duplicate.Id,
+			ActorId: adminId,
+			Reason:  req.Reason,
+		})
+	})
+	if err != nil {
+		// Without transactions the writes made so far stay, they are undone like a rollback. With
+		// them there is nothing left to undo
+		if err := u.restore(context.WithoutCancel(ctx), merge); err != nil {
+			slog.ErrorContext(ctx, "Undo failed account merge error", "primary_id", primary.Id, "duplicate_id", duplicate.Id, "error", err)
+		}
+		return entity.AccountMerge{}, err
+	}
+
+	slog.InfoContext(ctx, "Accounts merged", "merge_id", merge.Id, "primary_id", primary.Id, "duplicate_id", duplicate.Id,
+		"chats", len(merge.MovedChatIds)+len(merge.ClosedChatIds), "messages", merge.MessagesMoved)
+	return merge, nil
+}
+
+func (u *accountMergeUsecase) Get(ctx context.Context, mergeId string) (entity.AccountMerge, error) {
+	merge, err := u.accountMergeRepo.Get(ctx, mergeId)
+	if err != nil {
+		if errors.Is(err, repository.ErrAccountMergeNotFound) {
+			return entity.AccountMerge{}, ErrAccountMergeNotFound
+		}
+		return entity.AccountMerge{}, err
+	}
+	return merge, nil
+}
+
+func (u *accountMergeUsecase) ListByUser(ctx context.Context, userId string) ([]entity.AccountMerge, error) {
+	return u.accountMergeRepo.ListByUser(ctx, userId)
+}
+
+func (u *accountMergeUsecase) Rollback(ctx context.Context, mergeId string, adminId string, req entity.RollbackAccountMergeRequest) (entity.AccountMerge, error) {
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > maxMergeReasonLength {
+		return entity.AccountMerge{}, invalidField("reason", ErrInvalidMergeReason)
+	}
+
+	merge, err := u.Get(ctx, mergeId)
+	if err != nil {
+		return entity.AccountMerge{}, err
+	}
+	if merge.Status != entity.AccountMergeStatusMerged {
+		return entity.AccountMerge{}, ErrAccountMergeNotFound
+	}
+
+	// What the merge moved went on with the primary account when it was merged in turn
+	primary, err := u.getUser(ctx, merge.PrimaryId)
+	if err != nil {
+		return entity.AccountMerge{}, err
+	}
+	if primary.MergedInto != "" {
+		return entity.AccountMerge{}, ErrMergeNotLatest
+	}
+
+	err = u.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		// The merge is closed once restored, a rollback that failed halfway can be run again
+		if err := u.restore(ctx, merge); err != nil {
+			return err
+		}
+		rolledBack, err := u.accountMergeRepo.SetRolledBack(ctx, mergeId, adminId)
+		if err != nil {
+			if errors.Is(err, repository.ErrAccountMergeNotFound) {
+				return ErrAccountMergeNotFound
+			}
+			return err
+		}
+
+		merge = rolledBack
+		return u.auditRepo.Create(ctx, entity.AuditLog{
+			Action:  entity.AuditActionAccountMergeRolledBack,
+			UserId:  merge.DuplicateId,
+			ActorId: adminId,
+			Reason:  req.Reason,
+		})
+	})
+	if err != nil {
+		return entity.AccountMerge{}, err
+	}
+
+	slog.InfoContext(ctx, "Account merge rolled back", "merge_id", merge.Id, "primary_id", merge.PrimaryId, "duplicate_id", merge.DuplicateId)
+	return merge, nil
+}
+
+// restore gives the duplicate of the merge back what was moved from it. Every step only matches
+// what is still marked as moved from the duplicate, so it can run again after a failure
+func (u *accountMergeUsecase) restore(ctx context.Context, merge entity.AccountMerge) error {
+	if err := u.chatRepo.RestoreParticipations(ctx, merge.DuplicateId, merge.PrimaryId); err != nil {
+		return err
+	}
+	if _, err := u.messageRepo.RestoreSender(ctx, merge.DuplicateId, merge.PrimaryId); err != nil {
+		return err
+	}
+	if _, err := u.receiptRepo.RestoreRecipient(ctx, merge.DuplicateId, merge.PrimaryId); err != nil {
+		return err
+	}
+	if _, err := u.refreshTokenRepo.RestoreUser(ctx, merge.DuplicateId, merge.PrimaryId); err != nil {
+		return err
+	}
+
+	if err := u.userRepo.SetMergedInto(ctx, merge.DuplicateId, ""); err != nil {
+		return err
+	}
+	if merge.DuplicateDeactivated {
+		return u.userRepo.SetDeactivated(ctx, merge.DuplicateId, nil)
+	}
+	return nil
+}
+
+func (u *accountMergeUsecase) getUser(ctx context.Context, userId string) (entity.User, error) {
+	user, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return entity.User{}, ErrUserNotFound
+		}
+		return entity.User{}, err
+	}
+	return user, nil
+}
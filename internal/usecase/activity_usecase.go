@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+)
+
+// ActivityStream exports domain events to consumers outside this service, see pkg/kafka for the
+// Kafka implementation
+type ActivityStream interface {
+	Publish(ctx context.Context, event entity.ActivityEvent) error
+}
+
+type noopActivityStream struct{}
+
+// NewNoopActivityStream drops every event, it is used when no export is configured
+func NewNoopActivityStream() ActivityStream {
+	return noopActivityStream{}
+}
+
+func (noopActivityStream) Publish(ctx context.Context, event entity.ActivityEvent) error {
+	return nil
+}
+
+// MemActivityStream keeps the latest events in memory, for development and to inspect the
+// export without a Kafka cluster
+type MemActivityStream struct {
+	mu       sync.Mutex
+	events   []entity.ActivityEvent
+	capacity int
+}
+
+func NewMemActivityStream(capacity int) *MemActivityStream {
+	return &MemActivityStream{
+		events:   make([]entity.ActivityEvent, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+func (s *MemActivityStream) Publish(ctx context.Context, event entity.ActivityEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.events) == s.capacity {
+		s.events = s.events[1:]
+	}
+	s.events = append(s.events, event)
+
+	slog.DebugContext(ctx, "Activity event", "event", event.Type, "chat_id", event.ChatId, "actor_id", event.ActorId)
+	return nil
+}
+
+// Events returns the kept events, oldest first
+func (s *MemActivityStream) Events() []entity.ActivityEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]entity.ActivityEvent, len(s.events))
+	copy(events, s.events)
+	return events
+}
+
+// ActivityExportUsecase forwards the chat activity from the domain event bus to the activity stream
+type ActivityExportUsecase interface {
+	// Subscribe registers the export on the domain event bus
+	Subscribe(bus EventBus)
+}
+
+type activityExportUsecase struct {
+	stream ActivityStream
+}
+
+func NewActivityExportUsecase(stream ActivityStream) ActivityExportUsecase {
+	return &activityExportUsecase{
+		stream: stream,
+	}
+}
+
+func (a *activityExportUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventMessageCreated, a.onMessageCreated)
+	bus.Subscribe(entity.EventChatCreated, a.onChatCreated)
+	bus.Subscribe(entity.EventUserRegistered, a.onUserRegistered)
+	bus.Subscribe(entity.EventInvitationResponded, a.onInvitationResponded)
+}
+
+func (a *activityExportUsecase) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok {
+		return
+	}
+
+	a.publish(ctx, entity.ActivityEvent{
+		Type:    entity.EventMessageCreated,
+		ChatId:  message.ChatId,
+		ActorId: message.SenderId,
+		Data:    message,
+	})
+}
+
+func (a *activityExportUsecase) onChatCreated(ctx context.Context, data any) {
+	chat, ok := data.(entity.Chat)
+	if !ok {
+		return
+	}
+
+	a.publish(ctx, entity.ActivityEvent{
+		Type:        entity.EventChatCreated,
+		WorkspaceId: chat.WorkspaceId,
+		ChatId:      chat.Id,
+		ActorId:     chat.CreatedBy,
+		Data:        chat,
+	})
+}
+
+func (a *activityExportUsecase) onUserRegistered(ctx context.Context, data any) {
+	user, ok := data.(entity.User)
+	if !ok {
+		return
+	}
+
+	a.publish(ctx, entity.ActivityEvent{
+		Type:        entity.EventUserRegistered,
+		WorkspaceId: user.GetWorkspaceId(),
+		ActorId:     user.Id,
+		Data: entity.ActivityUser{
+			Id:           user.Id,
+			Username:     user.Username,
+			Name:         user.Name,
+			IsRestricted: user.IsRestricted,
+		},
+	})
+}
+
+func (a *activityExportUsecase) onInvitationResponded(ctx context.Context, data any) {
+	invitation, ok := data.(entity.ChatInvitation)
+	if !ok {
+		return
+	}
+
+	a.publish(ctx, entity.ActivityEvent{
+		Type:    entity.EventInvitationResponded,
+		ChatId:  invitation.ChatId,
+		ActorId: invitation.InviteeId,
+		Data:    invitation,
+	})
+}
+
+// publish fills the id and time of the event, a failed export is only logged
+func (a *activityExportUsecase) publish(ctx context.Context, event entity.ActivityEvent) {
+	event.Id = uuid.New().String()
+	event.OccurredAt = time.Now()
+
+	if err := a.stream.Publish(ctx, event); err != nil {
+		slog.WarnContext(ctx, "Export activity event error", "event", event.Type, "error", err)
+	}
+}
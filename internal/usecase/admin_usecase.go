@@ -0,0 +1,578 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrCannotDeactivateSelf   = errors.New("cannot deactivate your own account")
+	ErrFlaggedMessageNotFound = errors.New("flagged message not found or already reviewed")
+	ErrInvalidFlagStatus      = errors.New("status must be pending, dismissed or removed")
+	ErrInvalidFlagDecision    = errors.New("decision must be dismiss or remove")
+	ErrUnderMaintenance       = errors.New("the service is under maintenance, try again later")
+	ErrInvalidMaintenanceNote = errors.New("message must be at most 500 characters")
+	ErrEmptyPurgeFilter       = errors.New("at least one of senderId, after, before or hasLink is required")
+	ErrInvalidPurgeRange      = errors.New("after must be before before")
+	ErrMessagePurgeNotFound   = errors.New("message purge not found")
+)
+
+const (
+	defaultAdminUserLimit = 50
+	maxAdminUserLimit     = 200
+	flaggedMessageLimit   = 100
+	maxMaintenanceMessage = 500
+
+	// ServerStatsInterval is how often every server reports its stats, a server is dropped from
+	// the list once it missed a few reports
+	ServerStatsInterval = 15 * time.Second
+	serverStatsTTL      = 3 * ServerStatsInterval
+
+	// MessagePurgeInterval is how often the servers look for message purges to run
+	MessagePurgeInterval = 10 * time.Second
+	// messagePurgeBatchSize is how many messages a purge deletes, and tells the clients about, at once
+	messagePurgeBatchSize   = 200
+	messagePurgeClaimMaxAge = 2 * time.Minute
+)
+
+// ClientCounter counts the connections open on this server
+type ClientCounter interface {
+	GetClientCount() int
+}
+
+// AdminUsecase backs the back-office: it looks across every workspace, so the caller must be a super admin
+type AdminUsecase interface {
+	SearchUsers(ctx context.Context, filter entity.AdminUserFilter) ([]entity.User, error)
+	// DeactivateUser blocks new sign-ins and token refreshes of the user and revokes their refresh
+	// tokens, the access tokens already issued stay valid until they expire
+	DeactivateUser(ctx context.Context, userId string, adminId string, req entity.DeactivateUserRequest) (entity.User, error)
+	ReactivateUser(ctx context.Context, userId string, adminId string) (entity.User, error)
+	// GetChat returns a chat, deleted or not, with its participants and how many messages it holds
+	GetChat(ctx context.Context, chatId string) (entity.AdminChatDetail, error)
+	// RestoreChat brings back a deleted chat with the participants and invitations it had when it
+	// was deleted, until it is purged
+	RestoreChat(ctx context.Context, chatId string, adminId string) (entity.Chat, error)
+	// DeleteMessage removes an abusive message and tells the participants of its chat
+	DeleteMessage(ctx context.Context, messageId string, adminId string, req entity.DeleteMessageRequest) error
+	// GetFlaggedMessages returns the review queue of the messages flagged by the moderation, oldest first
+	GetFlaggedMessages(ctx context.Context, status string) ([]entity.FlaggedMessage, error)
+	// ReviewFlaggedMessage keeps the message of a pending flag or deletes it like DeleteMessage
+	ReviewFlaggedMessage(ctx context.Context, flagId string, adminId string, req entity.ReviewFlaggedMessageRequest) (entity.FlaggedMessage, error)
+
+	// PreviewMessagePurge counts the messages of the chat a purge with the filter would delete
+	PreviewMessagePurge(ctx context.Context, chatId string, req entity.MessagePurgeRequest) (entity.MessagePurgePreview, error)
+	// StartMessagePurge queues the deletion of the messages of the chat matching the filter, the
+	// participants are told about every batch deleted
+	StartMessagePurge(ctx context.Context, chatId string, adminId string, req entity.MessagePurgeRequest) (entity.MessagePurge, error)
+	GetMessagePurge(ctx context.Context, purgeId string) (entity.MessagePurge, error)
+	// RunMessagePurges runs the queued purges to completion and returns how many messages they deleted
+	RunMessagePurges(ctx context.Context) (int64, error)
+
+	// GetMaintenanceMode returns the maintenance mode of the deployment
+	GetMaintenanceMode(ctx context.Context) (entity.MaintenanceMode, error)
+	// SetMaintenanceMode turns the maintenance mode on or off for every server
+	SetMaintenanceMode(ctx context.Context, adminId string, req entity.SetMaintenanceModeRequest) (entity.MaintenanceMode, error)
+
+	// ReportServerStats shares the stats of this server with the others
+	ReportServerStats(ctx context.Context) (int64, error)
+	ListServerStats(ctx context.Context) ([]entity.ServerStats, error)
+}
+
+type adminUsecase struct {
+	userRepo         repository.UserRepository
+	chatRepo         repository.ChatRepository
+	messageRepo      repository.MessageRepository
+	refreshTokenRepo repository.RefreshTokenRepository
+	auditRepo        repository.AuditRepository
+	serverStatsRepo  repository.ServerStatsRepository
+	flaggedRepo      repository.FlaggedMessageRepository
+	maintenanceRepo  repository.MaintenanceRepository
+	purgeRepo        repository.MessagePurgeRepository
+	metricsUc        MetricsUsecase
+	publisher        EventPublisher
+	bus              EventBus
+	clients          ClientCounter
+	serverId         string
+
+	mu       sync.Mutex
+	previous entity.MetricsCounters
+}
+
+func NewAdminUsecase(userRepo repository.UserRepository, chatRepo repository.ChatRepository, messageRepo repository.MessageRepository, refreshTokenRepo repository.RefreshTokenRepository, auditRepo repository.AuditRepository, serverStatsRepo repository.ServerStatsRepository, flaggedRepo repository.FlaggedMessageRepository, maintenanceRepo repository.MaintenanceRepository, purgeRepo repository.MessagePurgeRepository, metricsUc MetricsUsecase, publisher EventPublisher, bus EventBus, clients ClientCounter, serverId string) AdminUsecase {
+	return &adminUsecase{
+		userRepo:         userRepo,
+		chatRepo:         chatRepo,
+		messageRepo:      messageRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		auditRepo:        auditRepo,
+		serverStatsRepo:  serverStatsRepo,
+		flaggedRepo:      flaggedRepo,
+		maintenanceRepo:  maintenanceRepo,
+		purgeRepo:        purgeRepo,
+		metricsUc:        metricsUc,
+		publisher:        publisher,
+		bus:              bus,
+		clients:          clients,
+		serverId:         serverId,
+		previous:         metricsUc.Counters(),
+	}
+}
+
+func (a *adminUsecase) SearchUsers(ctx context.Context, filter entity.AdminUserFilter) ([]entity.User, error) {
+	filter.Limit = AdminUserLimit(filter.Limit)
+	return a.userRepo.Search(ctx, filter)
+}
+
+// AdminUserLimit returns how many users a back-office search returns for the requested limit
+func AdminUserLimit(limit int) int {
+	if limit <= 0 {
+		return defaultAdminUserLimit
+	}
+	if limit > maxAdminUserLimit {
+		return maxAdminUserLimit
+	}
+	return limit
+}
+
+func (a *adminUsecase) DeactivateUser(ctx context.Context, userId string, adminId string, req entity.DeactivateUserRequest) (entity.User, error) {
+	if userId == adminId {
+		return entity.User{}, ErrCannotDeactivateSelf
+	}
+
+	user, err := a.getUser(ctx, userId)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if user.DeactivatedAt != nil {
+		return user, nil
+	}
+
+	now := time.Now()
+	if err := a.userRepo.SetDeactivated(ctx, userId, &now); err != nil {
+		return entity.User{}, err
+	}
+	user.DeactivatedAt = &now
+
+	// Sign the user out of every device once their access tokens expire
+	if err := a.refreshTokenRepo.RevokeAllByUserId(ctx, userId); err != nil {
+		return entity.User{}, err
+	}
+
+	err = a.auditRepo.Create(ctx, entity.AuditLog{
+		Action:  entity.AuditActionUserDeactivated,
+		UserId:  userId,
+		ActorId: adminId,
+		Reason:  req.Reason,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit user deactivation error", "error", err)
+	}
+
+	return user, nil
+}
+
+func (a *adminUsecase) ReactivateUser(ctx context.Context, userId string, adminId string) (entity.User, error) {
+	user, err := a.getUser(ctx, userId)
+	if err != nil {
+		return entity.User{}, err
+	}
+	if user.DeactivatedAt == nil {
+		return user, nil
+	}
+	// Its chats and messages are with the account it was merged into until the merge is rolled back
+	if user.MergedInto != "" {
+		return entity.User{}, ErrAccountMerged
+	}
+
+	if err := a.userRepo.SetDeactivated(ctx, userId, nil); err != nil {
+		return entity.User{}, err
+	}
+	user.DeactivatedAt = nil
+
+	err = a.auditRepo.Create(ctx, entity.AuditLog{
+		Action:  entity.AuditActionUserReactivated,
+		UserId:  userId,
+		ActorId: adminId,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit user reactivation error", "error", err)
+	}
+
+	return user, nil
+}
+
+func (a *adminUsecase) getUser(ctx context.Context, userId string) (entity.User, error) {
+	user, err := a.userRepo.Get(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return entity.User{}, ErrUserNotFound
+		}
+		return entity.User{}, err
+	}
+	return user, nil
+}
+
+func (a *adminUsecase) GetChat(ctx context.Context, chatId string) (entity.AdminChatDetail, error) {
+	chat, err := a.chatRepo.Get(ctx, chatId)
+	if errors.Is(err, repository.ErrChatNotFound) {
+		chat, err = a.chatRepo.GetDeleted(ctx, chatId)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.AdminChatDetail{}, ErrChatNotFound
+		}
+		return entity.AdminChatDetail{}, err
+	}
+
+	participants, err := a.chatRepo.GetParticipants(ctx, chatId)
+	if err != nil {
+		return entity.AdminChatDetail{}, err
+	}
+
+	count, err := a.messageRepo.CountByChat(ctx, chatId)
+	if err != nil {
+		return entity.AdminChatDetail{}, err
+	}
+
+	return entity.AdminChatDetail{
+		Chat:         chat,
+		Participants: participants,
+		MessageCount: count,
+	}, nil
+}
+
+func (a *adminUsecase) RestoreChat(ctx context.Context, chatId string, adminId string) (entity.Chat, error) {
+	if _, err := a.chatRepo.GetDeleted(ctx, chatId); err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+
+	if err := a.chatRepo.Restore(ctx, chatId); err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+
+	chat, err := a.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+	a.bus.Publish(ctx, entity.EventChatRestored, chat)
+
+	err = a.auditRepo.Create(ctx, entity.AuditLog{
+		Action:  entity.AuditActionChatRestored,
+		ChatId:  chatId,
+		ActorId: adminId,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit chat restore error", "error", err)
+	}
+
+	return chat, nil
+}
+
+func (a *adminUsecase) DeleteMessage(ctx context.Context, messageId string, adminId string, req entity.DeleteMessageRequest) error {
+	message, err := a.messageRepo.Get(ctx, messageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return ErrMessageNotFound
+		}
+		return err
+	}
+
+	if err := a.messageRepo.Delete(ctx, messageId); err != nil {
+		return err
+	}
+	if err := a.chatRepo.UnpinMessages(ctx, message.ChatId, []string{messageId}); err != nil {
+		slog.ErrorContext(ctx, "Unpin deleted message error", "chat_id", message.ChatId, "message_id", messageId, "error", err)
+	}
+
+	event := entity.MessageDeleted{
+		ChatId:    message.ChatId,
+		MessageId: messageId,
+		DeletedAt: time.Now(),
+	}
+	participants, err := a.chatRepo.GetParticipants(ctx, message.ChatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get participants for event error", "event", entity.EventMessageDeleted, "chat_id", message.ChatId, "error", err)
+	} else {
+		userIds := make([]string, 0, len(participants))
+		for _, participant := range participants {
+			userIds = append(userIds, participant.UserId)
+		}
+		a.publisher.PublishToUsers(ctx, userIds, entity.EventMessageDeleted, event)
+	}
+	// The inbox previews and replay windows may still show the message
+	a.bus.Publish(ctx, entity.EventMessageDeleted, event)
+
+	err = a.auditRepo.Create(ctx, entity.AuditLog{
+		Action:    entity.AuditActionMessageDeleted,
+		ChatId:    message.ChatId,
+		UserId:    message.SenderId,
+		MessageId: messageId,
+		ActorId:   adminId,
+		Reason:    req.Reason,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit message delete error", "error", err)
+	}
+
+	return nil
+}
+
+func (a *adminUsecase) GetFlaggedMessages(ctx context.Context, status string) ([]entity.FlaggedMessage, error) {
+	switch status {
+	case "":
+		status = entity.FlagStatusPending
+	case entity.FlagStatusPending, entity.FlagStatusDismissed, entity.FlagStatusRemoved:
+	default:
+		return nil, invalidField("status", ErrInvalidFlagStatus)
+	}
+	return a.flaggedRepo.GetByStatus(ctx, status, flaggedMessageLimit)
+}
+
+func (a *adminUsecase) ReviewFlaggedMessage(ctx context.Context, flagId string, adminId string, req entity.ReviewFlaggedMessageRequest) (entity.FlaggedMessage, error) {
+	var status string
+	switch req.Decision {
+	case entity.FlagDecisionDismiss:
+		status = entity.FlagStatusDismissed
+	case entity.FlagDecisionRemove:
+		status = entity.FlagStatusRemoved
+	default:
+		return entity.FlaggedMessage{}, invalidField("decision", ErrInvalidFlagDecision)
+	}
+
+	// Closing the flag first keeps two admins from reviewing it at once
+	flagged, err := a.flaggedRepo.Review(ctx, flagId, status, adminId)
+	if err != nil {
+		if errors.Is(err, repository.ErrFlaggedMessageNotFound) {
+			return entity.FlaggedMessage{}, ErrFlaggedMessageNotFound
+		}
+		return entity.FlaggedMessage{}, err
+	}
+
+	if status == entity.FlagStatusRemoved {
+		err := a.DeleteMessage(ctx, flagged.MessageId, adminId, entity.DeleteMessageRequest{Reason: req.Reason})
+		// The message may have been deleted by its chat in the meantime
+		if err != nil && !errors.Is(err, ErrMessageNotFound) {
+			return entity.FlaggedMessage{}, err
+		}
+	}
+
+	return flagged, nil
+}
+
+func (a *adminUsecase) PreviewMessagePurge(ctx context.Context, chatId string, req entity.MessagePurgeRequest) (entity.MessagePurgePreview, error) {
+	matched, err := a.countPurge(ctx, chatId, req.MessagePurgeFilter)
+	if err != nil {
+		return entity.MessagePurgePreview{}, err
+	}
+
+	return entity.MessagePurgePreview{
+		ChatId:  chatId,
+		Filter:  req.MessagePurgeFilter,
+		Matched: matched,
+	}, nil
+}
+
+func (a *adminUsecase) StartMessagePurge(ctx context.Context, chatId string, adminId string, req entity.MessagePurgeRequest) (entity.MessagePurge, error) {
+	matched, err := a.countPurge(ctx, chatId, req.MessagePurgeFilter)
+	if err != nil {
+		return entity.MessagePurge{}, err
+	}
+
+	purge, err := a.purgeRepo.Create(ctx, entity.MessagePurge{
+		ChatId:      chatId,
+		Filter:      req.MessagePurgeFilter,
+		Reason:      req.Reason,
+		RequestedBy: adminId,
+		Matched:     matched,
+	})
+	if err != nil {
+		return entity.MessagePurge{}, err
+	}
+
+	err = a.auditRepo.Create(ctx, entity.AuditLog{
+		Action:  entity.AuditActionMessagesPurged,
+		ChatId:  chatId,
+		UserId:  req.SenderId,
+		ActorId: adminId,
+		Reason:  req.Reason,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit message purge error", "error", err)
+	}
+
+	return purge, nil
+}
+
+// countPurge checks the filter and the chat before counting the messages matching the filter
+func (a *adminUsecase) countPurge(ctx context.Context, chatId string, filter entity.MessagePurgeFilter) (int64, error) {
+	if filter.IsEmpty() {
+		return 0, invalidField("senderId", ErrEmptyPurgeFilter)
+	}
+	if filter.After > 0 && filter.Before > 0 && filter.After >= filter.Before {
+		return 0, invalidField("after", ErrInvalidPurgeRange)
+	}
+
+	if _, err := a.chatRepo.Get(ctx, chatId); err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return 0, ErrChatNotFound
+		}
+		return 0, err
+	}
+
+	return a.messageRepo.CountMatching(ctx, chatId, filter)
+}
+
+func (a *adminUsecase) GetMessagePurge(ctx context.Context, purgeId string) (entity.MessagePurge, error) {
+	purge, err := a.purgeRepo.Get(ctx, purgeId)
+	if errors.Is(err, repository.ErrMessagePurgeNotFound) {
+		return entity.MessagePurge{}, ErrMessagePurgeNotFound
+	}
+	return purge, err
+}
+
+func (a *adminUsecase) RunMessagePurges(ctx context.Context) (int64, error) {
+	var deleted int64
+	for {
+		now := time.Now()
+		purge, err := a.purgeRepo.Claim(ctx, now, now.Add(-messagePurgeClaimMaxAge))
+		if errors.Is(err, repository.ErrMessagePurgeNotFound) {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, err
+		}
+
+		// A purge that fails is taken over once its claim is stale, it goes on where it stopped
+		n, err := a.runMessagePurge(ctx, purge)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+}
+
+// runMessagePurge deletes the messages of a claimed purge one batch at a time. Every batch is
+// published to the participants so their clients prune their caches, and to the admin who
+// started the purge with its progress
+func (a *adminUsecase) runMessagePurge(ctx context.Context, purge entity.MessagePurge) (int64, error) {
+	participants, err := a.chatRepo.GetParticipants(ctx, purge.ChatId)
+	if err != nil {
+		return 0, err
+	}
+	userIds := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserId)
+	}
+
+	var deleted int64
+	for {
+		messageIds, err := a.messageRepo.DeleteMatching(ctx, purge.ChatId, purge.Filter, messagePurgeBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		if len(messageIds) > 0 {
+			deleted += int64(len(messageIds))
+			if err := a.chatRepo.UnpinMessages(ctx, purge.ChatId, messageIds); err != nil {
+				slog.ErrorContext(ctx, "Unpin purged messages error", "chat_id", purge.ChatId, "error", err)
+			}
+			event := entity.MessagesDeleted{
+				ChatId:     purge.ChatId,
+				MessageIds: messageIds,
+				DeletedAt:  time.Now(),
+			}
+			a.publisher.PublishToUsers(ctx, userIds, entity.EventMessagesDeleted, event)
+			a.bus.Publish(ctx, entity.EventMessagesDeleted, event)
+
+			purge, err = a.purgeRepo.AddDeleted(ctx, purge.Id, int64(len(messageIds)))
+			if err != nil {
+				return deleted, err
+			}
+		}
+
+		if len(messageIds) < messagePurgeBatchSize {
+			purge, err = a.purgeRepo.Finish(ctx, purge.Id)
+			if err != nil {
+				return deleted, err
+			}
+			a.publisher.PublishToUsers(ctx, []string{purge.RequestedBy}, entity.EventMessagePurgeUpdated, purge)
+			return deleted, nil
+		}
+		a.publisher.PublishToUsers(ctx, []string{purge.RequestedBy}, entity.EventMessagePurgeUpdated, purge)
+	}
+}
+
+func (a *adminUsecase) ReportServerStats(ctx context.Context) (int64, error) {
+	counters := a.metricsUc.Counters()
+	a.mu.Lock()
+	live := counters.LiveSince(a.previous)
+	a.previous = counters
+	a.mu.Unlock()
+
+	stats := entity.ServerStats{
+		ServerId:          a.serverId,
+		Clients:           a.clients.GetClientCount(),
+		MessagesPerSecond: live.MessagesPerSecond,
+		ErrorsPerSecond:   live.ErrorsPerSecond,
+		ReportedAt:        counters.At,
+	}
+	if err := a.serverStatsRepo.Save(ctx, stats, serverStatsTTL); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (a *adminUsecase) ListServerStats(ctx context.Context) ([]entity.ServerStats, error) {
+	return a.serverStatsRepo.List(ctx)
+}
+
+func (a *adminUsecase) GetMaintenanceMode(ctx context.Context) (entity.MaintenanceMode, error) {
+	return a.maintenanceRepo.Get(ctx)
+}
+
+func (a *adminUsecase) SetMaintenanceMode(ctx context.Context, adminId string, req entity.SetMaintenanceModeRequest) (entity.MaintenanceMode, error) {
+	message := strings.TrimSpace(req.Message)
+	if utf8.RuneCountInString(message) > maxMaintenanceMessage {
+		return entity.MaintenanceMode{}, invalidField("message", ErrInvalidMaintenanceNote)
+	}
+
+	now := time.Now()
+	mode := entity.MaintenanceMode{
+		Enabled:   req.Enabled,
+		Message:   message,
+		UpdatedBy: adminId,
+		UpdatedAt: &now,
+	}
+	if err := a.maintenanceRepo.Set(ctx, mode); err != nil {
+		return entity.MaintenanceMode{}, err
+	}
+
+	action := entity.AuditActionMaintenanceDisabled
+	if mode.Enabled {
+		action = entity.AuditActionMaintenanceEnabled
+	}
+	err := a.auditRepo.Create(ctx, entity.AuditLog{
+		Action:  action,
+		ActorId: adminId,
+		Reason:  message,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit maintenance mode error", "error", err)
+	}
+
+	return mode, nil
+}
@@ -2,12 +2,22 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"strings"
+	"sync"
 	"time"
 	"wetalk/internal/entity"
 	"wetalk/internal/repository"
+	"wetalk/pkg/captcha"
 	"wetalk/pkg/jwt"
+	"wetalk/pkg/totp"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,39 +28,252 @@ var (
 	ErrInvalidRefreshToken   = errors.New("invalid refresh token")
 	ErrExpiredRefreshToken   = errors.New("refresh token has expired")
 	ErrRevokedRefreshToken   = errors.New("refresh token has been revoked")
+	ErrBirthdateRequired     = errors.New("birthdate is required")
+	ErrInvalidBirthdate      = errors.New("birthdate must be formatted as YYYY-MM-DD")
+	ErrUnderMinimumAge       = errors.New("you do not meet the minimum age requirement")
+	ErrCaptchaRequired       = errors.New("captcha is required")
+	ErrInvalidCaptcha        = errors.New("captcha verification failed")
+	ErrInvalidPassword       = errors.New("current password is incorrect")
+	ErrWeakPassword          = errors.New("password must be at least 6 characters")
+	ErrMissingFields         = errors.New("all fields are required")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotSetUp       = errors.New("two-factor authentication has not been set up")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrInvalidChallenge        = errors.New("two-factor challenge is invalid or expired")
+	ErrAccountDeactivated      = errors.New("this account has been deactivated")
 )
 
+// AgePolicy configures the age gate applied at registration
+type AgePolicy struct {
+	// MinimumAge is the youngest age allowed to register, 0 disables the age gate
+	MinimumAge int
+	// AdultAge is the age below which accounts are put in restricted mode
+	AdultAge int
+}
+
+func DefaultAgePolicy() AgePolicy {
+	return AgePolicy{
+		MinimumAge: 13,
+		AdultAge:   18,
+	}
+}
+
+// CaptchaPolicy configures when clients must solve a captcha, a nil Verifier disables it
+type CaptchaPolicy struct {
+	Verifier captcha.Verifier
+	// LoginFailureThreshold is the number of failed logins after which a captcha is required
+	LoginFailureThreshold int
+	// LoginFailureWindow is how long failed logins are remembered
+	LoginFailureWindow time.Duration
+}
+
+func DefaultCaptchaPolicy() CaptchaPolicy {
+	return CaptchaPolicy{
+		LoginFailureThreshold: 3,
+		LoginFailureWindow:    15 * time.Minute,
+	}
+}
+
+// TwoFactorPolicy configures TOTP two-factor authentication
+type TwoFactorPolicy struct {
+	// Issuer is the account issuer shown in authenticator apps
+	Issuer string
+	// ChallengeTTL is how long the second login step stays open after the password check
+	ChallengeTTL time.Duration
+	// MaxAttempts is the number of wrong codes after which the challenge is dropped
+	MaxAttempts int
+	// RecoveryCodeCount is the number of single-use recovery codes issued when enabling it
+	RecoveryCodeCount int
+	// Skew is the number of 30 second steps accepted on each side to tolerate clock drift
+	Skew int
+}
+
+func DefaultTwoFactorPolicy() TwoFactorPolicy {
+	return TwoFactorPolicy{
+		Issuer:            "Wetalk",
+		ChallengeTTL:      5 * time.Minute,
+		MaxAttempts:       5,
+		RecoveryCodeCount: 10,
+		Skew:              1,
+	}
+}
+
+// SecurityPolicy configures which authentication anomalies are reported as security events
+type SecurityPolicy struct {
+	// FailedLoginThreshold is the number of failed logins on an email within the captcha failure
+	// window after which it is reported, 0 disables the report
+	FailedLoginThreshold int
+}
+
+func DefaultSecurityPolicy() SecurityPolicy {
+	return SecurityPolicy{
+		FailedLoginThreshold: 10,
+	}
+}
+
+// loginFailures counts recent failed logins per email
+type loginFailures struct {
+	mu       sync.Mutex
+	window   time.Duration
+	failures map[string][]time.Time
+}
+
+func newLoginFailures(window time.Duration) *loginFailures {
+	return &loginFailures{
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+func (l *loginFailures) count(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.prune(key))
+}
+
+// add records a failed login and returns the number of failures within the window
+func (l *loginFailures) add(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failures[key] = append(l.prune(key), time.Now())
+	return len(l.failures[key])
+}
+
+func (l *loginFailures) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+}
+
+// prune drops failures outside of the window, the caller must hold the lock
+func (l *loginFailures) prune(key string) []time.Time {
+	cutoff := time.Now().Add(-l.window)
+
+	recent := l.failures[key][:0]
+	for _, failedAt := range l.failures[key] {
+		if failedAt.After(cutoff) {
+			recent = append(recent, failedAt)
+		}
+	}
+
+	if len(recent) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = recent
+	return recent
+}
+
 type AuthUsecase interface {
 	Register(ctx context.Context, req entity.RegisterRequest) (entity.AuthResponse, error)
 	Login(ctx context.Context, req entity.LoginRequest) (entity.AuthResponse, error)
-	RefreshToken(ctx context.Context, refreshToken string) (entity.AuthResponse, error)
+	RefreshToken(ctx context.Context, req entity.RefreshTokenRequest) (entity.AuthResponse, error)
 	Logout(ctx context.Context, refreshToken string) error
 	LogoutAllDevices(ctx context.Context, userId string) error
+	SetupTwoFactor(ctx context.Context, userId string) (entity.TwoFactorSetupResponse, error)
+	EnableTwoFactor(ctx context.Context, userId string, code string) (entity.TwoFactorEnabledResponse, error)
+	CompleteTwoFactorChallenge(ctx context.Context, req entity.TwoFactorChallengeRequest) (entity.AuthResponse, error)
+	ChangePassword(ctx context.Context, userId string, req entity.ChangePasswordRequest) error
 	ValidateAccessToken(token string) (*entity.TokenClaims, error)
+	IsSuperAdmin(ctx context.Context, userId string) (bool, error)
+	// ListSessions returns the signed in devices of the user with the app they reported, newest first
+	ListSessions(ctx context.Context, userId string) ([]entity.LoginSession, error)
+
+	// Maintenance operations
+	PurgeExpiredRefreshTokens(ctx context.Context) (int64, error)
 }
 
 type authUsecase struct {
 	userRepo         repository.UserRepository
 	refreshTokenRepo repository.RefreshTokenRepository
+	twoFactorRepo    repository.TwoFactorRepository
 	jwtManager       *jwt.JWTManager
+	agePolicy        AgePolicy
+	captchaPolicy    CaptchaPolicy
+	twoFactorPolicy  TwoFactorPolicy
+	securityPolicy   SecurityPolicy
+	loginFailures    *loginFailures
+	bus              EventBus
 }
 
 func NewAuthUsecase(
 	userRepo repository.UserRepository,
 	refreshTokenRepo repository.RefreshTokenRepository,
+	twoFactorRepo repository.TwoFactorRepository,
 	jwtManager *jwt.JWTManager,
+	agePolicy AgePolicy,
+	captchaPolicy CaptchaPolicy,
+	twoFactorPolicy TwoFactorPolicy,
+	securityPolicy SecurityPolicy,
+	bus EventBus,
 ) AuthUsecase {
 	return &authUsecase{
 		userRepo:         userRepo,
 		refreshTokenRepo: refreshTokenRepo,
+		twoFactorRepo:    twoFactorRepo,
 		jwtManager:       jwtManager,
+		agePolicy:        agePolicy,
+		captchaPolicy:    captchaPolicy,
+		twoFactorPolicy:  twoFactorPolicy,
+		securityPolicy:   securityPolicy,
+		loginFailures:    newLoginFailures(captchaPolicy.LoginFailureWindow),
+		bus:              bus,
 	}
 }
 
+func (u *authUsecase) verifyCaptcha(ctx context.Context, token string, remoteIp string) error {
+	if token == "" {
+		return invalidField("captchaToken", ErrCaptchaRequired)
+	}
+
+	ok, err := u.captchaPolicy.Verifier.Verify(ctx, token, remoteIp)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCaptcha
+	}
+	return nil
+}
+
 func (u *authUsecase) Register(ctx context.Context, req entity.RegisterRequest) (entity.AuthResponse, error) {
 	// Validate required fields
-	if req.Email == "" || req.Password == "" || req.Username == "" || req.Name == "" {
-		return entity.AuthResponse{}, errors.New("all fields are required")
+	for _, field := range []struct{ name, value string }{
+		{"email", req.Email}, {"password", req.Password}, {"username", req.Username}, {"name", req.Name},
+	} {
+		if field.value == "" {
+			return entity.AuthResponse{}, invalidField(field.name, ErrMissingFields)
+		}
+	}
+
+	if u.captchaPolicy.Verifier != nil {
+		if err := u.verifyCaptcha(ctx, req.CaptchaToken, req.RemoteIp); err != nil {
+			return entity.AuthResponse{}, err
+		}
+	}
+
+	// Apply the age gate
+	var birthdate *time.Time
+	if req.Birthdate != "" {
+		parsed, err := time.Parse("2006-01-02", req.Birthdate)
+		if err != nil {
+			return entity.AuthResponse{}, invalidField("birthdate", ErrInvalidBirthdate)
+		}
+		birthdate = &parsed
+	} else if u.agePolicy.MinimumAge > 0 {
+		return entity.AuthResponse{}, invalidField("birthdate", ErrBirthdateRequired)
+	}
+
+	isRestricted := false
+	if birthdate != nil {
+		age := entity.User{Birthdate: birthdate}.AgeAt(time.Now())
+		if age < u.agePolicy.MinimumAge {
+			return entity.AuthResponse{}, ErrUnderMinimumAge
+		}
+		isRestricted = age < u.agePolicy.AdultAge
 	}
 
 	// Check if email already exists
@@ -84,6 +307,9 @@ func (u *authUsecase) Register(ctx context.Context, req entity.RegisterRequest)
 		Password: string(hashedPassword),
 		Name:     req.Name,
 		IsOnline: false,
+
+		Birthdate:    birthdate,
+		IsRestricted: isRestricted,
 	}
 
 	userId, err := u.userRepo.Create(ctx, user)
@@ -107,9 +333,12 @@ func (u *authUsecase) Register(ctx context.Context, req entity.RegisterRequest)
 
 	// Store refresh token in database
 	refreshToken := entity.RefreshToken{
-		UserId:    userId,
-		Token:     refreshTokenString,
-		ExpiresAt: u.jwtManager.GetRefreshTokenExpiration(),
+		UserId:     userId,
+		Token:      refreshTokenString,
+		ExpiresAt:  u.jwtManager.GetRefreshTokenExpiration(),
+		DeviceInfo: req.UserAgent,
+		IpAddress:  req.RemoteIp,
+		Client:     req.Client.Sanitized(),
 	}
 
 	err = u.refreshTokenRepo.Create(ctx, refreshToken)
@@ -120,6 +349,9 @@ func (u *authUsecase) Register(ctx context.Context, req entity.RegisterRequest)
 	// Remove password from response
 	user.Password = ""
 
+	// Post-registration hooks, such as joining the default chats
+	u.bus.Publish(ctx, entity.EventUserRegistered, user)
+
 	return entity.AuthResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshTokenString,
@@ -128,10 +360,20 @@ func (u *authUsecase) Register(ctx context.Context, req entity.RegisterRequest)
 }
 
 func (u *authUsecase) Login(ctx context.Context, req entity.LoginRequest) (entity.AuthResponse, error) {
+	failureKey := strings.ToLower(req.Email)
+
+	// Require a captcha once the account has too many recent failed logins
+	if u.captchaPolicy.Verifier != nil && u.loginFailures.count(failureKey) >= u.captchaPolicy.LoginFailureThreshold {
+		if err := u.verifyCaptcha(ctx, req.CaptchaToken, req.RemoteIp); err != nil {
+			return entity.AuthResponse{}, err
+		}
+	}
+
 	// Get user by email
 	user, err := u.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
-		if err == repository.ErrUserNotFound {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			u.recordLoginFailure(ctx, failureKey, entity.SecurityEvent{Email: req.Email, RemoteIp: req.RemoteIp, UserAgent: req.UserAgent})
 			return entity.AuthResponse{}, ErrInvalidCredentials
 		}
 		return entity.AuthResponse{}, err
@@ -140,8 +382,92 @@ func (u *authUsecase) Login(ctx context.Context, req entity.LoginRequest) (entit
 	// Compare password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		u.recordLoginFailure(ctx, failureKey, entity.SecurityEvent{UserId: user.Id, Email: user.Email, RemoteIp: req.RemoteIp, UserAgent: req.UserAgent})
 		return entity.AuthResponse{}, ErrInvalidCredentials
 	}
+	u.loginFailures.reset(failureKey)
+
+	if user.DeactivatedAt != nil {
+		return entity.AuthResponse{}, ErrAccountDeactivated
+	}
+
+	// Accounts with two-factor authentication get their tokens after the second step
+	twoFactor, err := u.twoFactorRepo.Get(ctx, user.Id)
+	if err != nil && !errors.Is(err, repository.ErrTwoFactorNotFound) {
+		return entity.AuthResponse{}, err
+	}
+	if twoFactor.Enabled {
+		return u.createTwoFactorChallenge(ctx, user.Id, req.Client.Sanitized())
+	}
+
+	return u.issueTokens(ctx, user, req.RemoteIp, req.UserAgent, req.Client.Sanitized())
+}
+
+// recordLoginFailure counts a failed login and reports the email once it reaches the threshold
+func (u *authUsecase) recordLoginFailure(ctx context.Context, failureKey string, event entity.SecurityEvent) {
+	attempts := u.loginFailures.add(failureKey)
+	if u.securityPolicy.FailedLoginThreshold <= 0 || attempts != u.securityPolicy.FailedLoginThreshold {
+		return
+	}
+
+	event.Type = entity.SecurityEventLoginFailures
+	event.FailedAttempts = attempts
+	u.reportAnomaly(ctx, event)
+}
+
+// reportNewClient reports a sign in from an IP address or a device the user never signed in
+// from, it must run before the refresh token of the sign in is stored
+func (u *authUsecase) reportNewClient(ctx context.Context, user entity.User, remoteIp string, userAgent string) error {
+	if remoteIp == "" && userAgent == "" {
+		return nil
+	}
+
+	signIns, err := u.refreshTokenRepo.GetSignIns(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	// The first sign in has nothing to be compared with
+	if len(signIns) == 0 {
+		return nil
+	}
+
+	knownIp, knownDevice := false, false
+	for _, signIn := range signIns {
+		knownIp = knownIp || signIn.IpAddress == remoteIp
+		knownDevice = knownDevice || signIn.DeviceInfo == userAgent
+	}
+	if knownIp && knownDevice {
+		return nil
+	}
+
+	u.reportAnomaly(ctx, entity.SecurityEvent{
+		Type:      entity.SecurityEventNewClient,
+		UserId:    user.Id,
+		Email:     user.Email,
+		RemoteIp:  remoteIp,
+		UserAgent: userAgent,
+	})
+	return nil
+}
+
+// reportAnomaly hands a security event to the subscribers of the bus, which deliver it
+func (u *authUsecase) reportAnomaly(ctx context.Context, event entity.SecurityEvent) {
+	event.Id = uuid.New().String()
+	event.OccurredAt = time.Now()
+
+	u.bus.Publish(ctx, entity.EventSecurityAlert, event)
+}
+
+// issueTokens signs an access token and stores a new refresh token for the user, recording the
+// client it was issued to
+func (u *authUsecase) issueTokens(ctx context.Context, user entity.User, remoteIp string, userAgent string, client entity.ClientInfo) (entity.AuthResponse, error) {
+	if user.DeactivatedAt != nil {
+		return entity.AuthResponse{}, ErrAccountDeactivated
+	}
+
+	if err := u.reportNewClient(ctx, user, remoteIp, userAgent); err != nil {
+		return entity.AuthResponse{}, err
+	}
 
 	// Generate access token
 	accessToken, err := u.jwtManager.GenerateAccessToken(user)
@@ -157,9 +483,12 @@ func (u *authUsecase) Login(ctx context.Context, req entity.LoginRequest) (entit
 
 	// Store refresh token in database
 	refreshToken := entity.RefreshToken{
-		UserId:    user.Id,
-		Token:     refreshTokenString,
-		ExpiresAt: u.jwtManager.GetRefreshTokenExpiration(),
+		UserId:     user.Id,
+		Token:      refreshTokenString,
+		ExpiresAt:  u.jwtManager.GetRefreshTokenExpiration(),
+		DeviceInfo: userAgent,
+		IpAddress:  remoteIp,
+		Client:     client,
 	}
 
 	err = u.refreshTokenRepo.Create(ctx, refreshToken)
@@ -177,15 +506,200 @@ func (u *authUsecase) Login(ctx context.Context, req entity.LoginRequest) (entit
 	}, nil
 }
 
-func (u *authUsecase) RefreshToken(ctx context.Context, refreshTokenString string) (entity.AuthResponse, error) {
+func (u *authUsecase) createTwoFactorChallenge(ctx context.Context, userId string, client entity.ClientInfo) (entity.AuthResponse, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return entity.AuthResponse{}, err
+	}
+
+	now := time.Now()
+	challenge := entity.TwoFactorChallenge{
+		Id:        base64.RawURLEncoding.EncodeToString(token),
+		UserId:    userId,
+		CreatedAt: now,
+		ExpiresAt: now.Add(u.twoFactorPolicy.ChallengeTTL),
+		Client:    client,
+	}
+	if err := u.twoFactorRepo.CreateChallenge(ctx, challenge); err != nil {
+		return entity.AuthResponse{}, err
+	}
+
+	return entity.AuthResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge.Id,
+	}, nil
+}
+
+// SetupTwoFactor generates a new pending TOTP secret, two-factor authentication is only
+// enabled once a code from it is verified
+func (u *authUsecase) SetupTwoFactor(ctx context.Context, userId string) (entity.TwoFactorSetupResponse, error) {
+	current, err := u.twoFactorRepo.Get(ctx, userId)
+	if err != nil && !errors.Is(err, repository.ErrTwoFactorNotFound) {
+		return entity.TwoFactorSetupResponse{}, err
+	}
+	if current.Enabled {
+		return entity.TwoFactorSetupResponse{}, ErrTwoFactorAlreadyEnabled
+	}
+
+	user, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.TwoFactorSetupResponse{}, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return entity.TwoFactorSetupResponse{}, err
+	}
+
+	twoFactor := entity.TwoFactor{
+		UserId:        userId,
+		Secret:        secret,
+		RecoveryCodes: []string{},
+		CreatedAt:     time.Now(),
+	}
+	if err := u.twoFactorRepo.Upsert(ctx, twoFactor); err != nil {
+		return entity.TwoFactorSetupResponse{}, err
+	}
+
+	return entity.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningUri: totp.ProvisioningURI(secret, u.twoFactorPolicy.Issuer, user.Email),
+	}, nil
+}
+
+// EnableTwoFactor turns two-factor authentication on once the user proves their app produces
+// valid codes, and returns the recovery codes in clear text for the only time
+func (u *authUsecase) EnableTwoFactor(ctx context.Context, userId string, code string) (entity.TwoFactorEnabledResponse, error) {
+	twoFactor, err := u.twoFactorRepo.Get(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrTwoFactorNotFound) {
+			return entity.TwoFactorEnabledResponse{}, ErrTwoFactorNotSetUp
+		}
+		return entity.TwoFactorEnabledResponse{}, err
+	}
+	if twoFactor.Enabled {
+		return entity.TwoFactorEnabledResponse{}, ErrTwoFactorAlreadyEnabled
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now(), u.twoFactorPolicy.Skew)
+	if !ok {
+		return entity.TwoFactorEnabledResponse{}, ErrInvalidTwoFactorCode
+	}
+
+	recoveryCodes := make([]string, 0, u.twoFactorPolicy.RecoveryCodeCount)
+	twoFactor.RecoveryCodes = make([]string, 0, u.twoFactorPolicy.RecoveryCodeCount)
+	for i := 0; i < u.twoFactorPolicy.RecoveryCodeCount; i++ {
+		recoveryCode, err := generateRecoveryCode()
+		if err != nil {
+			return entity.TwoFactorEnabledResponse{}, err
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes, hashRecoveryCode(recoveryCode))
+	}
+
+	now := time.Now()
+	twoFactor.Enabled = true
+	twoFactor.EnabledAt = &now
+	twoFactor.LastUsedStep = step
+	if err := u.twoFactorRepo.Upsert(ctx, twoFactor); err != nil {
+		return entity.TwoFactorEnabledResponse{}, err
+	}
+
+	return entity.TwoFactorEnabledResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// CompleteTwoFactorChallenge is the second login step, it accepts a TOTP code or a single-use recovery code
+func (u *authUsecase) CompleteTwoFactorChallenge(ctx context.Context, req entity.TwoFactorChallengeRequest) (entity.AuthResponse, error) {
+	challenge, err := u.twoFactorRepo.GetChallenge(ctx, req.ChallengeToken)
+	if err != nil {
+		if errors.Is(err, repository.ErrTwoFactorChallengeNotFound) {
+			return entity.AuthResponse{}, ErrInvalidChallenge
+		}
+		return entity.AuthResponse{}, err
+	}
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= u.twoFactorPolicy.MaxAttempts {
+		if err := u.twoFactorRepo.DeleteChallenge(ctx, challenge.Id); err != nil {
+			return entity.AuthResponse{}, err
+		}
+		return entity.AuthResponse{}, ErrInvalidChallenge
+	}
+
+	twoFactor, err := u.twoFactorRepo.Get(ctx, challenge.UserId)
+	if err != nil && !errors.Is(err, repository.ErrTwoFactorNotFound) {
+		return entity.AuthResponse{}, err
+	}
+	if !twoFactor.Enabled {
+		return entity.AuthResponse{}, ErrInvalidChallenge
+	}
+
+	var valid bool
+	if req.RecoveryCode != "" {
+		valid, err = u.twoFactorRepo.UseRecoveryCode(ctx, challenge.UserId, hashRecoveryCode(req.RecoveryCode))
+		if err != nil {
+			return entity.AuthResponse{}, err
+		}
+	} else if step, ok := totp.Validate(twoFactor.Secret, req.Code, time.Now(), u.twoFactorPolicy.Skew); ok {
+		// A code can't be used twice, even within its validity window
+		valid, err = u.twoFactorRepo.UseStep(ctx, challenge.UserId, step)
+		if err != nil {
+			return entity.AuthResponse{}, err
+		}
+	}
+
+	if !valid {
+		if err := u.twoFactorRepo.IncrementChallengeAttempts(ctx, challenge.Id); err != nil {
+			return entity.AuthResponse{}, err
+		}
+		return entity.AuthResponse{}, ErrInvalidTwoFactorCode
+	}
+
+	if err := u.twoFactorRepo.DeleteChallenge(ctx, challenge.Id); err != nil {
+		return entity.AuthResponse{}, err
+	}
+
+	user, err := u.userRepo.Get(ctx, challenge.UserId)
+	if err != nil {
+		return entity.AuthResponse{}, err
+	}
+
+	return u.issueTokens(ctx, user, req.RemoteIp, req.UserAgent, challenge.Client)
+}
+
+// generateRecoveryCode returns a random code formatted as "xxxxx-xxxxx"
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode normalizes a recovery code as typed by the user and hashes it
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func (u *authUsecase) RefreshToken(ctx context.Context, req entity.RefreshTokenRequest) (entity.AuthResponse, error) {
+	refreshTokenString := req.RefreshToken
+
 	// Get refresh token from database
 	refreshToken, err := u.refreshTokenRepo.GetByToken(ctx, refreshTokenString)
 	if err != nil {
 		return entity.AuthResponse{}, ErrInvalidRefreshToken
 	}
 
-	// Check if token is revoked
+	// Check if token is revoked, its client never sends it again so someone else likely holds a copy
 	if refreshToken.IsRevoked {
+		u.reportAnomaly(ctx, entity.SecurityEvent{
+			Type:      entity.SecurityEventRefreshTokenReuse,
+			UserId:    refreshToken.UserId,
+			RemoteIp:  req.RemoteIp,
+			UserAgent: req.UserAgent,
+		})
 		return entity.AuthResponse{}, ErrRevokedRefreshToken
 	}
 
@@ -199,6 +713,9 @@ func (u *authUsecase) RefreshToken(ctx context.Context, refreshTokenString strin
 	if err != nil {
 		return entity.AuthResponse{}, err
 	}
+	if user.DeactivatedAt != nil {
+		return entity.AuthResponse{}, ErrAccountDeactivated
+	}
 
 	// Generate new access token
 	accessToken, err := u.jwtManager.GenerateAccessToken(user)
@@ -218,11 +735,20 @@ func (u *authUsecase) RefreshToken(ctx context.Context, refreshTokenString strin
 		return entity.AuthResponse{}, err
 	}
 
+	// The session stays on the app it was issued to unless it reports itself again
+	client := req.Client.Sanitized()
+	if client.IsEmpty() {
+		client = refreshToken.Client
+	}
+
 	// Store new refresh token
 	newRefreshToken := entity.RefreshToken{
-		UserId:    user.Id,
-		Token:     newRefreshTokenString,
-		ExpiresAt: u.jwtManager.GetRefreshTokenExpiration(),
+		UserId:     user.Id,
+		Token:      newRefreshTokenString,
+		ExpiresAt:  u.jwtManager.GetRefreshTokenExpiration(),
+		DeviceInfo: req.UserAgent,
+		IpAddress:  req.RemoteIp,
+		Client:     client,
 	}
 
 	err = u.refreshTokenRepo.Create(ctx, newRefreshToken)
@@ -260,6 +786,70 @@ func (u *authUsecase) LogoutAllDevices(ctx context.Context, userId string) error
 	return nil
 }
 
+// PurgeExpiredRefreshTokens deletes the refresh tokens past their expiration, they can't be used anymore
+func (u *authUsecase) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	return u.refreshTokenRepo.DeleteExpired(ctx)
+}
+
+// ChangePassword replaces the password of the user and signs out every other device
+func (u *authUsecase) ChangePassword(ctx context.Context, userId string, req entity.ChangePasswordRequest) error {
+	if len(req.NewPassword) < 6 {
+		return invalidField("newPassword", ErrWeakPassword)
+	}
+
+	user, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	// Compare current password
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword))
+	if err != nil {
+		return ErrInvalidPassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := u.userRepo.UpdatePassword(ctx, userId, string(hashedPassword)); err != nil {
+		return err
+	}
+
+	// Keep the session the change was made from
+	return u.refreshTokenRepo.RevokeOthersByUserId(ctx, userId, req.RefreshToken)
+}
+
 func (u *authUsecase) ValidateAccessToken(token string) (*entity.TokenClaims, error) {
 	return u.jwtManager.ValidateAccessToken(token)
-}
\ No newline at end of file
+}
+
+func (u *authUsecase) ListSessions(ctx context.Context, userId string) ([]entity.LoginSession, error) {
+	tokens, err := u.refreshTokenRepo.GetByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]entity.LoginSession, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, entity.LoginSession{
+			Id:        token.Id,
+			Client:    token.Client,
+			UserAgent: token.DeviceInfo,
+			IpAddress: token.IpAddress,
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiresAt,
+		})
+	}
+	return sessions, nil
+}
+
+func (u *authUsecase) IsSuperAdmin(ctx context.Context, userId string) (bool, error) {
+	user, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role == entity.UserRoleSuperAdmin, nil
+}
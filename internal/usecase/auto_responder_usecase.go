@@ -0,0 +1,298 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+	"wetalk/infrastructure/cache"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrTooManyAutoReplyRules = errors.New("too many auto-reply rules")
+	ErrInvalidAutoReplyRule  = errors.New("auto-reply rules need a keyword and a response within the length limits")
+	ErrAutoResponderNotFound = errors.New("this chat has no auto-responder")
+	ErrInvalidAwayMessage    = errors.New("away message needs a message, a valid timezone, start and end as 15:04 and weekdays between 0 and 6")
+)
+
+// AutoResponderPolicy limits the auto-reply rules and how often they fire
+type AutoResponderPolicy struct {
+	MaxRules          int
+	MaxKeywordLength  int
+	MaxResponseLength int
+	// RuleCooldown is how long a rule stays quiet in a chat after it replied
+	RuleCooldown time.Duration
+	// AwayCooldown is how long a sender isn't sent the away message again
+	AwayCooldown time.Duration
+}
+
+func DefaultAutoResponderPolicy() AutoResponderPolicy {
+	return AutoResponderPolicy{
+		MaxRules:          20,
+		MaxKeywordLength:  50,
+		MaxResponseLength: 500,
+		RuleCooldown:      time.Minute,
+		AwayCooldown:      time.Hour,
+	}
+}
+
+// AutoResponderUsecase manages the auto-reply rules of group chats and answers the messages
+// matching them. Auto-replies never trigger other auto-replies, and the cooldowns keep a
+// chatty conversation from being flooded
+type AutoResponderUsecase interface {
+	Get(ctx context.Context, chatId string, userId string) (entity.AutoResponder, error)
+	Update(ctx context.Context, chatId string, userId string, req entity.UpdateAutoResponderRequest) (entity.AutoResponder, error)
+	Delete(ctx context.Context, chatId string, userId string) error
+
+	// Subscribe registers the auto-responder on the domain event bus
+	Subscribe(bus EventBus)
+}
+
+type autoResponderUsecase struct {
+	autoResponderRepo repository.AutoResponderRepository
+	chatRepo          repository.ChatRepository
+	messageRepo       repository.MessageRepository
+	receiptRepo       repository.ReceiptRepository
+	publisher         EventPublisher
+	bus               EventBus
+	policy            AutoResponderPolicy
+
+	// cooldowns holds the rules and away messages that fired recently
+	cooldowns cache.Cache
+}
+
+func NewAutoResponderUsecase(autoResponderRepo repository.AutoResponderRepository, chatRepo repository.ChatRepository, messageRepo repository.MessageRepository, receiptRepo repository.ReceiptRepository, publisher EventPublisher, cooldowns cache.Cache, policy AutoResponderPolicy) AutoResponderUsecase {
+	return &autoResponderUsecase{
+		autoResponderRepo: autoResponderRepo,
+		chatRepo:          chatRepo,
+		messageRepo:       messageRepo,
+		receiptRepo:       receiptRepo,
+		publisher:         publisher,
+		policy:            policy,
+		cooldowns:         cooldowns,
+	}
+}
+
+// Get returns the auto-reply rules of a chat, an empty set when none were defined
+func (a *autoResponderUsecase) Get(ctx context.Context, chatId string, userId string) (entity.AutoResponder, error) {
+	isParticipant, err := a.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return entity.AutoResponder{}, err
+	}
+	if !isParticipant {
+		return entity.AutoResponder{}, ErrNotParticipant
+	}
+
+	autoResponder, err := a.autoResponderRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrAutoResponderNotFound) {
+			return entity.AutoResponder{ChatId: chatId, Rules: []entity.AutoReplyRule{}}, nil
+		}
+		return entity.AutoResponder{}, err
+	}
+
+	return autoResponder, nil
+}
+
+// Update replaces the auto-reply rules of a group chat (admin only)
+func (a *autoResponderUsecase) Update(ctx context.Context, chatId string, userId string, req entity.UpdateAutoResponderRequest) (entity.AutoResponder, error) {
+	if err := a.checkAdmin(ctx, chatId, userId); err != nil {
+		return entity.AutoResponder{}, err
+	}
+
+	if len(req.Rules) > a.policy.MaxRules {
+		return entity.AutoResponder{}, invalidField("rules", ErrTooManyAutoReplyRules)
+	}
+
+	rules := make([]entity.AutoReplyRule, 0, len(req.Rules))
+	for _, rule := range req.Rules {
+		rule.Keyword = strings.TrimSpace(rule.Keyword)
+		rule.Response = strings.TrimSpace(rule.Response)
+		if rule.Keyword == "" || rule.Response == "" ||
+			len([]rune(rule.Keyword)) > a.policy.MaxKeywordLength || len([]rune(rule.Response)) > a.policy.MaxResponseLength {
+			return entity.AutoResponder{}, invalidField("rules", ErrInvalidAutoReplyRule)
+		}
+		rules = append(rules, rule)
+	}
+
+	if req.Away != nil {
+		if err := a.checkAwayMessage(req.Away); err != nil {
+			return entity.AutoResponder{}, err
+		}
+	}
+
+	autoResponder := entity.AutoResponder{
+		ChatId:    chatId,
+		Rules:     rules,
+		Away:      req.Away,
+		UpdatedBy: userId,
+		UpdatedAt: time.Now(),
+	}
+	if err := a.autoResponderRepo.Upsert(ctx, autoResponder); err != nil {
+		return entity.AutoResponder{}, err
+	}
+
+	return autoResponder, nil
+}
+
+// Delete turns the auto-responder of a group chat off (admin only)
+func (a *autoResponderUsecase) Delete(ctx context.Context, chatId string, userId string) error {
+	if err := a.checkAdmin(ctx, chatId, userId); err != nil {
+		return err
+	}
+
+	err := a.autoResponderRepo.Delete(ctx, chatId)
+	if errors.Is(err, repository.ErrAutoResponderNotFound) {
+		return ErrAutoResponderNotFound
+	}
+	return err
+}
+
+func (a *autoResponderUsecase) checkAdmin(ctx context.Context, chatId string, userId string) error {
+	chat, err := a.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return ErrChatNotFound
+		}
+		return err
+	}
+	if chat.Type != entity.ChatTypeGroup {
+		return ErrInvalidChatType
+	}
+
+	isAdmin, err := a.chatRepo.IsAdmin(ctx, userId, chatId)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return ErrNotAdmin
+	}
+	return nil
+}
+
+func (a *autoResponderUsecase) checkAwayMessage(away *entity.AwayMessage) error {
+	away.Message = strings.TrimSpace(away.Message)
+	if away.Message == "" || len([]rune(away.Message)) > a.policy.MaxResponseLength {
+		return invalidField("away.message", ErrInvalidAwayMessage)
+	}
+	if _, err := time.LoadLocation(away.Timezone); err != nil {
+		return invalidField("away.timezone", ErrInvalidAwayMessage)
+	}
+	if _, err := time.Parse("15:04", away.Start); err != nil {
+		return invalidField("away.start", ErrInvalidAwayMessage)
+	}
+	if _, err := time.Parse("15:04", away.End); err != nil {
+		return invalidField("away.end", ErrInvalidAwayMessage)
+	}
+	for _, day := range away.Weekdays {
+		if day < 0 || day > 6 {
+			return invalidField("away.weekdays", ErrInvalidAwayMessage)
+		}
+	}
+	return nil
+}
+
+func (a *autoResponderUsecase) Subscribe(bus EventBus) {
+	a.bus = bus
+	bus.Subscribe(entity.EventMessageCreated, a.onMessageCreated)
+}
+
+func (a *autoResponderUsecase) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok || message.IsAutoReply || message.IsSystem() {
+		return
+	}
+
+	// Reply once the sender's own message went out
+	replyCtx := context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(replyCtx, 10*time.Second)
+		defer cancel()
+
+		if err := a.respond(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Auto-reply error", "message_id", message.Id, "chat_id", message.ChatId, "error", err)
+		}
+	}()
+}
+
+func (a *autoResponderUsecase) respond(ctx context.Context, message entity.Message) error {
+	autoResponder, err := a.autoResponderRepo.Get(ctx, message.ChatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrAutoResponderNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for _, rule := range autoResponder.Rules {
+		if !rule.Matches(message.Message) {
+			continue
+		}
+		if !a.acquire(ctx, "autoreply:rule:"+message.ChatId+":"+strings.ToLower(rule.Keyword), a.policy.RuleCooldown) {
+			return nil
+		}
+		return a.reply(ctx, message, rule.Response)
+	}
+
+	if autoResponder.Away != nil && autoResponder.Away.IsAway(time.Now()) {
+		if !a.acquire(ctx, "autoreply:away:"+message.ChatId+":"+message.SenderId, a.policy.AwayCooldown) {
+			return nil
+		}
+		return a.reply(ctx, message, autoResponder.Away.Message)
+	}
+
+	return nil
+}
+
+// acquire reports whether the key is off cooldown and starts its cooldown. The first to count
+// the key acquires it, on every server; when the cooldowns can't be read nobody does
+func (a *autoResponderUsecase) acquire(ctx context.Context, key string, cooldown time.Duration) bool {
+	count, err := a.cooldowns.Increment(ctx, key, 1, cooldown)
+	if err != nil {
+		slog.ErrorContext(ctx, "Auto-reply cooldown error", "key", key, "error", err)
+		return false
+	}
+	return count == 1
+}
+
+// reply stores the auto-reply as a reply to the message and pushes it to the participants
+func (a *autoResponderUsecase) reply(ctx context.Context, original entity.Message, text string) error {
+	participants, err := a.chatRepo.GetParticipants(ctx, original.ChatId)
+	if err != nil {
+		return err
+	}
+	userIds := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserId)
+	}
+
+	reply := entity.Message{
+		ChatId:           original.ChatId,
+		SenderId:         entity.AutoResponderSenderId,
+		Message:          text,
+		Timestamp:        time.Now().UnixMilli(),
+		IsAutoReply:      true,
+		ReplyToMessageId: original.Id,
+		ReplyTo: &entity.QuotedMessage{
+			MessageId: original.Id,
+			SenderId:  original.SenderId,
+			Snippet:   snippet(original.Message, quoteSnippetLength),
+		},
+	}
+
+	reply, err = a.messageRepo.Create(ctx, reply)
+	if err != nil {
+		return err
+	}
+	if err := a.receiptRepo.CreateSent(ctx, reply, userIds, nil); err != nil {
+		return err
+	}
+	reply.DeliveryState = entity.DeliveryStateSent
+
+	a.bus.Publish(ctx, entity.EventMessageCreated, reply)
+	a.publisher.PublishToUsers(ctx, userIds, entity.EventAutoReply, reply)
+	return nil
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 	"wetalk/internal/entity"
 	"wetalk/internal/repository"
+	"wetalk/pkg/jwt"
 )
 
 var (
@@ -19,12 +24,91 @@ var (
 	ErrAlreadyParticipant    = errors.New("user is already a participant")
 	ErrInvitationNotFound    = errors.New("invitation not found")
 	ErrInvalidInvitation     = errors.New("invalid invitation")
+	ErrInvalidRole           = errors.New("invalid participant role")
+	ErrLastAdmin             = errors.New("the last admin cannot be demoted")
+	ErrCannotRemoveSelf      = errors.New("cannot remove yourself, leave the group instead")
+	ErrMemberNotFound        = errors.New("user is not a member of this chat")
+	ErrMessageNotFound       = errors.New("message not found")
+	ErrInvalidMembershipVersion = errors.New("membership version is ahead of the chat")
+	ErrInvalidMuteDuration   = errors.New("mute duration can't be negative")
+	ErrInvalidNotificationMode = errors.New("notification mode must be all or mentions")
+	ErrInvitationNoteTooLong = errors.New("invitation note must be at most 200 characters")
+	ErrInvalidSearchRange    = errors.New("after must be earlier than before")
+	ErrInvalidHistoryAccess  = errors.New("history access must be none, last_24h or all")
+	ErrInvalidMessageTTL     = errors.New("message TTL must be 0 or between 10 seconds and 30 days")
+	ErrGroupNameRequired     = errors.New("group name is required")
+	ErrParticipantsRequired  = errors.New("at least one participant is required")
+	ErrUnknownUsers          = errors.New("some user IDs are invalid")
+	ErrUnknownParticipant    = errors.New("participant not found")
+	ErrCannotLeavePersonal   = errors.New("cannot leave personal chat")
+	ErrInvitationResponded   = errors.New("invitation has already been responded to")
+	ErrInvitationExpired     = errors.New("invitation has expired")
+	ErrChannelNameRequired   = errors.New("channel name is required")
+	ErrChannelReadOnly       = errors.New("only the admins of this channel can post")
+	ErrLastChannelAdmin      = errors.New("the last admin cannot leave the channel, promote another member first")
+	ErrInvalidGuestExpiry    = errors.New("expiresAt must be in the future and at most a year away")
+	ErrGuestCannotBeAdmin    = errors.New("a guest cannot be made an admin")
+	ErrInvalidInviteLink     = errors.New("invite link is invalid")
+	ErrInviteLinkNotFound    = errors.New("invite link not found")
+	ErrInviteLinkExpired     = errors.New("invite link has expired")
+	ErrInviteLinkRevoked     = errors.New("invite link has been revoked")
+	ErrInvalidInviteLinkExpiry = errors.New("expiresAt must be in the future and at most 90 days away")
+	ErrJoinRequestNotFound   = errors.New("join request not found")
+	ErrJoinRequestResponded  = errors.New("join request has already been answered")
+	ErrInvalidJoinPolicy     = errors.New("join policy must be open, approval or invite_only")
+	ErrInviteOnly            = errors.New("this chat can only be joined by invitation")
+	ErrCannotPinMessage      = errors.New("only the sender of a message or an admin can pin it")
+	ErrMessageAlreadyPinned  = errors.New("message is already pinned")
+	ErrPinNotFound           = errors.New("message is not pinned")
+	ErrPinLimit              = errors.New("this chat has reached its pinned message limit, unpin some first")
+	ErrInvalidPinLimit       = errors.New("pin limit must be between 0 and the pinned message limit of the server")
+	ErrInvalidPinDuration    = errors.New("pin duration must be between 0 and 365 days")
+	ErrInvalidPinOrder       = errors.New("messageIds must list every pinned message of the chat once")
+)
+
+const maxInvitationNoteLength = 200
+
+// maxGuestAccess is the longest a guest can be added to a group for
+const maxGuestAccess = 365 * 24 * time.Hour
+
+// maxInviteLinkTTL is the longest an invite link can be created for
+const maxInviteLinkTTL = 90 * 24 * time.Hour
+
+// expiredGuestBatchSize bounds the guests removed by one run of the sweeper, the next run takes the rest
+const expiredGuestBatchSize = 500
+
+// maxPinDuration is the longest a message can be pinned for before it is unpinned on its own
+const maxPinDuration = 365 * 24 * time.Hour
+
+// expiredPinBatchSize bounds the pins removed by one run of the sweeper, the next run takes the rest
+const expiredPinBatchSize = 500
+
+// Bounds of the MessageTTL of a chat, in seconds
+const (
+	minMessageTTL = 10
+	maxMessageTTL = 30 * 24 * 60 * 60
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	defaultContextAround = 25
+	maxContextAround     = 100
+
+	// deletedChatPurgeLimit is how many deleted chats a run of the purge deletes for good
+	deletedChatPurgeLimit       = 50
+	deletedChatMessageBatchSize = 1000
 )
 
 type ChatUsecase interface {
 	// Chat operations
 	Index(ctx context.Context, userId string) ([]entity.Chat, error)
 	Get(ctx context.Context, chatId string, userId string) (entity.ChatDetailResponse, error)
+	Update(ctx context.Context, chatId string, userId string, req entity.UpdateChatRequest) (entity.Chat, error)
+	// UpdateSettings changes the settings shared by the participants, the admins of a group or
+	// either participant of a personal chat
+	UpdateSettings(ctx context.Context, chatId string, userId string, req entity.ChatSettingsRequest) (entity.Chat, error)
 	Delete(ctx context.Context, chatId string, userId string) error
 
 	// Personal chat operations
@@ -32,41 +116,198 @@ type ChatUsecase interface {
 
 	// Group chat operations
 	CreateGroupChat(ctx context.Context, name string, description string, creatorId string, userIds []string) (string, error)
-	InviteUsersToGroup(ctx context.Context, chatId string, inviterId string, userIds []string) error
+	InviteUsersToGroup(ctx context.Context, chatId string, inviterId string, userIds []string, note string) error
+	// AddGuest adds a user to a group until expiresAt without inviting them (admin only)
+	AddGuest(ctx context.Context, chatId string, adminId string, userId string, expiresAt time.Time) error
 	LeaveGroup(ctx context.Context, chatId string, userId string) error
+	UpdateParticipantRole(ctx context.Context, chatId string, adminId string, targetUserId string, role string) error
+	RemoveMember(ctx context.Context, chatId string, adminId string, targetUserId string) error
+
+	// Channel operations, only the admins of a channel post while the members of its workspace
+	// subscribe and unsubscribe themselves
+	CreateChannel(ctx context.Context, name string, description string, creatorId string) (string, error)
+	SubscribeChannel(ctx context.Context, chatId string, userId string) error
+	UnsubscribeChannel(ctx context.Context, chatId string, userId string) error
+	// CanPost checks that the user may send messages to the chat, it fails with ErrChatNotFound,
+	// ErrNotParticipant or ErrChannelReadOnly. It is served from the cache and cheaper than Get
+	CanPost(ctx context.Context, chatId string, userId string) error
 
 	// Invitation operations
 	GetPendingInvitations(ctx context.Context, userId string) ([]entity.ChatInvitation, error)
 	RespondToInvitation(ctx context.Context, invitationId string, userId string, accept bool) error
+	// GetChatInvitations returns the invitations of a group still waiting for an answer (admin only)
+	GetChatInvitations(ctx context.Context, chatId string, userId string) ([]entity.ChatInvitation, error)
+	// CancelInvitation withdraws a pending invitation, its inviter or an admin of the group may
+	CancelInvitation(ctx context.Context, invitationId string, userId string) error
+
+	// Invite link operations, the links are created and revoked by the admins of a group or a
+	// channel while any user holding the token of a link may follow it
+	CreateInviteLink(ctx context.Context, chatId string, userId string, req entity.CreateInviteLinkRequest) (entity.ChatInviteLink, error)
+	GetInviteLinks(ctx context.Context, chatId string, userId string) ([]entity.ChatInviteLink, error)
+	RevokeInviteLink(ctx context.Context, chatId string, linkId string, userId string) error
+	// JoinByInviteLink joins the chat of the link, or asks to join it when the link requires approval
+	JoinByInviteLink(ctx context.Context, token string, userId string) (entity.JoinChatResult, error)
+
+	// Join request operations, RequestToJoin joins a group right away when its join policy is open
+	RequestToJoin(ctx context.Context, chatId string, userId string) (entity.JoinChatResult, error)
+	// GetJoinRequests and RespondToJoinRequest are admin only
+	GetJoinRequests(ctx context.Context, chatId string, userId string) ([]entity.ChatJoinRequest, error)
+	RespondToJoinRequest(ctx context.Context, chatId string, requestId string, adminId string, approve bool) error
 
 	// Participant operations
-	GetParticipants(ctx context.Context, chatId string, userId string) ([]entity.User, error)
+	// GetParticipants only reads the fields of the profiles in the selection, all of them when empty
+	GetParticipants(ctx context.Context, chatId string, userId string, fields entity.Fields) ([]entity.PublicUser, error)
+	GetMembershipVersion(ctx context.Context, chatId string, userId string) (entity.MembershipVersion, error)
+	GetMembershipDiff(ctx context.Context, chatId string, userId string, sinceVersion int64) (entity.MembershipDiff, error)
+
+	// Participant settings, they only affect the requesting user
+	MuteChat(ctx context.Context, chatId string, userId string, duration time.Duration) error
+	UnmuteChat(ctx context.Context, chatId string, userId string) error
+	SetNotificationMode(ctx context.Context, chatId string, userId string, mode string) error
+	// MuteThread silences the replies to a message of the chat, UnmuteThread brings them back
+	MuteThread(ctx context.Context, chatId string, messageId string, userId string) error
+	UnmuteThread(ctx context.Context, chatId string, messageId string, userId string) error
+	PinChat(ctx context.Context, chatId string, userId string) error
+	UnpinChat(ctx context.Context, chatId string, userId string) error
+	ArchiveChat(ctx context.Context, chatId string, userId string) error
+	UnarchiveChat(ctx context.Context, chatId string, userId string) error
 
 	// Message operations
-	GetMessages(ctx context.Context, chatId string, userId string, limit, offset int) ([]entity.Message, error)
+	// GetMessages only reads the fields of the messages in the selection, all of them when empty
+	GetMessages(ctx context.Context, chatId string, userId string, limit, offset int, fields entity.Fields) ([]entity.Message, error)
+	GetThread(ctx context.Context, chatId string, messageId string, userId string) (entity.MessageThread, error)
+	SearchMessages(ctx context.Context, userId string, filter entity.MessageSearchFilter) ([]entity.Message, error)
+	GetMessageContext(ctx context.Context, chatId string, messageId string, userId string, around int) (entity.MessageContext, error)
+	// PinMessage and UnpinMessage are open to the admins of a group or a channel and to the sender
+	// of the message, a pin with a duration is unpinned once it is over. GetPinnedMessages returns
+	// the pins the participant can read in the order of ReorderPins, open to the admins
+	PinMessage(ctx context.Context, chatId string, messageId string, userId string, duration time.Duration) (entity.MessagePin, error)
+	UnpinMessage(ctx context.Context, chatId string, messageId string, userId string) error
+	GetPinnedMessages(ctx context.Context, chatId string, userId string) ([]entity.MessagePin, error)
+	ReorderPins(ctx context.Context, chatId string, userId string, messageIds []string) ([]entity.MessagePin, error)
+
+	// Maintenance operations
+	PurgeEmptyChats(ctx context.Context) (int, error)
+	// PurgeDeletedChats deletes for good the chats deleted longer than the retention ago, with
+	// their messages, and returns how many chats it purged
+	PurgeDeletedChats(ctx context.Context) (int64, error)
+	ExpireInvitations(ctx context.Context) (int64, error)
+	// ExpireGuests removes the guests whose access ended and tells their groups
+	ExpireGuests(ctx context.Context) (int64, error)
+	// UnpinExpiredMessages removes the pins past their duration and tells their chats
+	UnpinExpiredMessages(ctx context.Context) (int64, error)
+
+	// Subscribe registers the post-registration hooks on the domain event bus
+	Subscribe(bus EventBus)
+}
+
+// ChatPolicy holds the tunable rules applied by the chat usecase
+type ChatPolicy struct {
+	// EmptyChatGracePeriod is how long an empty group chat is kept before it is purged
+	EmptyChatGracePeriod time.Duration
+	// InvitationTTL is how long an invitation stays pending before it expires
+	InvitationTTL time.Duration
+	// InviteLinkTTL is how long an invite link created without an expiry lets users join
+	InviteLinkTTL time.Duration
+	// MaxPinnedMessages is how many messages a chat can have pinned at once, the admins of a chat
+	// may lower it with its PinLimit
+	MaxPinnedMessages int
+	// DeletedChatRetention is how long a deleted chat can be restored before it is purged
+	DeletedChatRetention time.Duration
+	// DefaultChatIds are the group chats new users join, a user only joins the ones of their workspace
+	DefaultChatIds []string
+	// DefaultHistoryAccess applies to the chats whose admins didn't choose a history access
+	DefaultHistoryAccess string
+}
+
+func DefaultChatPolicy() ChatPolicy {
+	return ChatPolicy{
+		EmptyChatGracePeriod: 24 * time.Hour,
+		InvitationTTL:        30 * 24 * time.Hour,
+		InviteLinkTTL:        7 * 24 * time.Hour,
+		MaxPinnedMessages:    50,
+		DeletedChatRetention: 30 * 24 * time.Hour,
+		DefaultHistoryAccess: entity.HistoryAccessAll,
+	}
 }
 
 type chatUsecase struct {
 	chatRepo    repository.ChatRepository
 	userRepo    repository.UserRepository
 	messageRepo repository.MessageRepository
+	receiptRepo repository.ReceiptRepository
+	auditRepo   repository.AuditRepository
+	planUc      PlanUsecase
+	// messageCacheUc serves the newest page of messages, the one loaded when a chat is opened
+	messageCacheUc MessageCacheUsecase
+	inviteLinks    InviteLinkSigner
+	publisher   EventPublisher
+	bus         EventBus
+	// transactor keeps the last admin of a group or a channel from being demoted or leaving
+	// while another admin does the same, and deletes a chat at once
+	transactor repository.Transactor
+	policy     ChatPolicy
+	profile    ProfilePolicy
+}
+
+// InviteLinkSigner signs the join tokens of the invite links, see pkg/jwt
+type InviteLinkSigner interface {
+	GenerateInviteLinkToken(linkId string, expiresAt time.Time) (string, error)
+	// ValidateInviteLinkToken returns the ID of the link, jwt.ErrExpiredToken once it expired
+	ValidateInviteLinkToken(token string) (string, error)
 }
 
-func NewChatUsecase(chatRepo repository.ChatRepository, userRepo repository.UserRepository, messageRepo repository.MessageRepository) ChatUsecase {
+func NewChatUsecase(chatRepo repository.ChatRepository, userRepo repository.UserRepository, messageRepo repository.MessageRepository, receiptRepo repository.ReceiptRepository, auditRepo repository.AuditRepository, planUc PlanUsecase, messageCacheUc MessageCacheUsecase, inviteLinks InviteLinkSigner, publisher EventPublisher, bus EventBus, transactor repository.Transactor, policy ChatPolicy, profile ProfilePolicy) ChatUsecase {
 	return &chatUsecase{
 		chatRepo:    chatRepo,
 		userRepo:    userRepo,
 		messageRepo: messageRepo,
+		receiptRepo: receiptRepo,
+		auditRepo:   auditRepo,
+		planUc:      planUc,
+		messageCacheUc: messageCacheUc,
+		inviteLinks:    inviteLinks,
+		publisher:   publisher,
+		bus:         bus,
+		transactor:  transactor,
+		policy:      policy,
+		profile:     profile,
 	}
 }
 
-// Index returns all chats that a user is participating in
+// Index returns all chats that a user is participating in, pinned chats first
 func (c *chatUsecase) Index(ctx context.Context, userId string) ([]entity.Chat, error) {
 	chats, err := c.chatRepo.Index(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 
+	participations, err := c.chatRepo.GetParticipationsByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	participationByChatId := make(map[string]entity.ChatParticipant, len(participations))
+	for _, participation := range participations {
+		participationByChatId[participation.ChatId] = participation
+	}
+	for i := range chats {
+		applyParticipantSettings(&chats[i], participationByChatId[chats[i].Id])
+	}
+
+	// Most recently pinned first, the others keep their last activity order
+	slices.SortStableFunc(chats, func(a, b entity.Chat) int {
+		switch {
+		case a.PinnedAt != nil && b.PinnedAt != nil:
+			return b.PinnedAt.Compare(*a.PinnedAt)
+		case a.PinnedAt != nil:
+			return -1
+		case b.PinnedAt != nil:
+			return 1
+		default:
+			return 0
+		}
+	})
+
 	// Collect all personal chat IDs
 	var personalChatIds []string
 	for _, chat := range chats {
@@ -141,20 +382,21 @@ func (c *chatUsecase) Index(ctx context.Context, userId string) ([]entity.Chat,
 
 // Get returns a chat with its participants
 func (c *chatUsecase) Get(ctx context.Context, chatId string, userId string) (entity.ChatDetailResponse, error) {
-	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+	participation, err := c.chatRepo.GetParticipantByUserAndChat(ctx, userId, chatId)
 	if err != nil {
+		if errors.Is(err, repository.ErrNotParticipant) {
+			return entity.ChatDetailResponse{}, ErrNotParticipant
+		}
 		return entity.ChatDetailResponse{}, err
 	}
-	if !isParticipant {
-		return entity.ChatDetailResponse{}, ErrNotParticipant
-	}
 
 	chat, err := c.chatRepo.Get(ctx, chatId)
 	if err != nil {
 		return entity.ChatDetailResponse{}, err
 	}
+	applyParticipantSettings(&chat, participation)
 
-	participants, err := c.GetParticipants(ctx, chatId, userId)
+	participants, err := c.GetParticipants(ctx, chatId, userId, nil)
 	if err != nil {
 		return entity.ChatDetailResponse{}, err
 	}
@@ -174,7 +416,276 @@ func (c *chatUsecase) Get(ctx context.Context, chatId string, userId string) (en
 	}, nil
 }
 
-// Delete deletes a chat (only creator/admin can delete)
+// MuteChat silences the push notifications of a chat for the user, a zero duration mutes it until unmuted
+func (c *chatUsecase) MuteChat(ctx context.Context, chatId string, userId string, duration time.Duration) error {
+	if duration < 0 {
+		return invalidField("durationMinutes", ErrInvalidMuteDuration)
+	}
+
+	var mutedUntil *time.Time
+	if duration > 0 {
+		until := time.Now().Add(duration)
+		mutedUntil = &until
+	}
+
+	err := c.chatRepo.SetParticipantMuted(ctx, userId, chatId, true, mutedUntil)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	return err
+}
+
+// UnmuteChat turns the push notifications of a chat back on for the user
+func (c *chatUsecase) UnmuteChat(ctx context.Context, chatId string, userId string) error {
+	err := c.chatRepo.SetParticipantMuted(ctx, userId, chatId, false, nil)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	return err
+}
+
+// SetNotificationMode chooses whether every message of the chat notifies the user or only the ones mentioning them
+func (c *chatUsecase) SetNotificationMode(ctx context.Context, chatId string, userId string, mode string) error {
+	if !entity.IsValidNotificationMode(mode) {
+		return invalidField("mode", ErrInvalidNotificationMode)
+	}
+
+	err := c.chatRepo.SetParticipantNotificationMode(ctx, userId, chatId, mode)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	return err
+}
+
+func (c *chatUsecase) MuteThread(ctx context.Context, chatId string, messageId string, userId string) error {
+	return c.setThreadMuted(ctx, chatId, messageId, userId, true)
+}
+
+func (c *chatUsecase) UnmuteThread(ctx context.Context, chatId string, messageId string, userId string) error {
+	return c.setThreadMuted(ctx, chatId, messageId, userId, false)
+}
+
+// setThreadMuted checks the message belongs to the chat, the replies of a thread point at its first message
+func (c *chatUsecase) setThreadMuted(ctx context.Context, chatId string, messageId string, userId string, muted bool) error {
+	message, err := c.messageRepo.Get(ctx, messageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return ErrMessageNotFound
+		}
+		return err
+	}
+	if message.ChatId != chatId {
+		return ErrMessageNotFound
+	}
+
+	err = c.chatRepo.SetThreadMuted(ctx, userId, chatId, messageId, muted)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	return err
+}
+
+// PinChat keeps a chat at the top of the user's chat list
+func (c *chatUsecase) PinChat(ctx context.Context, chatId string, userId string) error {
+	now := time.Now()
+	err := c.chatRepo.SetParticipantPinned(ctx, userId, chatId, &now)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return err
+	}
+
+	c.publishParticipantUpdated(ctx, chatId, userId)
+	return nil
+}
+
+// UnpinChat puts a chat back in the last activity order of the user's chat list
+func (c *chatUsecase) UnpinChat(ctx context.Context, chatId string, userId string) error {
+	err := c.chatRepo.SetParticipantPinned(ctx, userId, chatId, nil)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return err
+	}
+
+	c.publishParticipantUpdated(ctx, chatId, userId)
+	return nil
+}
+
+// ArchiveChat moves a chat out of the user's default chat list
+func (c *chatUsecase) ArchiveChat(ctx context.Context, chatId string, userId string) error {
+	now := time.Now()
+	err := c.chatRepo.SetParticipantArchived(ctx, userId, chatId, &now)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return err
+	}
+
+	c.publishParticipantUpdated(ctx, chatId, userId)
+	return nil
+}
+
+// UnarchiveChat puts a chat back in the user's default chat list
+func (c *chatUsecase) UnarchiveChat(ctx context.Context, chatId string, userId string) error {
+	err := c.chatRepo.SetParticipantArchived(ctx, userId, chatId, nil)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return ErrNotParticipant
+	}
+	if err != nil {
+		return err
+	}
+
+	c.publishParticipantUpdated(ctx, chatId, userId)
+	return nil
+}
+
+// publishParticipantUpdated lets the read models follow the settings the user just changed
+func (c *chatUsecase) publishParticipantUpdated(ctx context.Context, chatId string, userId string) {
+	participation, err := c.chatRepo.GetParticipantByUserAndChat(ctx, userId, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get updated participant error", "error", err)
+		return
+	}
+
+	c.bus.Publish(ctx, entity.EventParticipantUpdated, participation)
+}
+
+// applyParticipantSettings fills the per-requester fields of a chat
+func applyParticipantSettings(chat *entity.Chat, participation entity.ChatParticipant) {
+	chat.PinnedAt = participation.PinnedAt
+	chat.IsPinned = participation.PinnedAt != nil
+	chat.IsArchived = participation.ArchivedAt != nil
+	chat.IsMuted = participation.IsMutedAt(time.Now())
+	if chat.IsMuted {
+		chat.MutedUntil = participation.MutedUntil
+	}
+	chat.NotificationMode = participation.NotificationMode
+	if chat.NotificationMode == "" {
+		chat.NotificationMode = entity.NotificationModeAll
+	}
+	chat.MutedThreads = participation.MutedThreads
+}
+
+// Update changes the metadata of a group chat (admin only) and notifies its participants
+func (c *chatUsecase) Update(ctx context.Context, chatId string, userId string, req entity.UpdateChatRequest) (entity.Chat, error) {
+	chat, err := c.requireGroupAdmin(ctx, chatId, userId)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+	before := chat
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			return entity.Chat{}, invalidField("name", ErrGroupNameRequired)
+		}
+		chat.Name = *req.Name
+	}
+	if req.Description != nil {
+		chat.Description = *req.Description
+	}
+	if req.Avatar != nil {
+		chat.Avatar = *req.Avatar
+	}
+	if req.KeepWhenEmpty != nil {
+		chat.KeepWhenEmpty = *req.KeepWhenEmpty
+	}
+	if req.HistoryAccess != nil {
+		if !entity.IsValidHistoryAccess(*req.HistoryAccess) {
+			return entity.Chat{}, invalidField("historyAccess", ErrInvalidHistoryAccess)
+		}
+		chat.HistoryAccess = *req.HistoryAccess
+	}
+	if req.JoinPolicy != nil {
+		if chat.Type != entity.ChatTypeGroup {
+			return entity.Chat{}, ErrInvalidChatType
+		}
+		if !entity.IsValidJoinPolicy(*req.JoinPolicy) {
+			return entity.Chat{}, invalidField("joinPolicy", ErrInvalidJoinPolicy)
+		}
+		chat.JoinPolicy = *req.JoinPolicy
+	}
+
+	err = c.chatRepo.Update(ctx, chat)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+	c.postMetadataMessages(ctx, before, chat, userId)
+
+	chat, err = c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+
+	c.publishToParticipants(ctx, chatId, nil, entity.EventChatUpdated, chat)
+	c.bus.Publish(ctx, entity.EventChatUpdated, chat)
+
+	return chat, nil
+}
+
+func (c *chatUsecase) UpdateSettings(ctx context.Context, chatId string, userId string, req entity.ChatSettingsRequest) (entity.Chat, error) {
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+
+	if chat.HasAdmins() {
+		isAdmin, err := c.chatRepo.IsAdmin(ctx, userId, chatId)
+		if err != nil {
+			return entity.Chat{}, err
+		}
+		if !isAdmin {
+			return entity.Chat{}, ErrNotAdmin
+		}
+	} else {
+		isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+		if err != nil {
+			return entity.Chat{}, err
+		}
+		if !isParticipant {
+			return entity.Chat{}, ErrNotParticipant
+		}
+	}
+
+	if req.MessageTTL != nil {
+		ttl := *req.MessageTTL
+		if ttl != 0 && (ttl < minMessageTTL || ttl > maxMessageTTL) {
+			return entity.Chat{}, invalidField("messageTTL", ErrInvalidMessageTTL)
+		}
+		// The messages already sent keep the expiry they were sent with
+		if err := c.chatRepo.SetMessageTTL(ctx, chatId, ttl); err != nil {
+			return entity.Chat{}, err
+		}
+	}
+
+	if req.PinLimit != nil {
+		pinLimit := *req.PinLimit
+		if pinLimit < 0 || pinLimit > c.policy.MaxPinnedMessages {
+			return entity.Chat{}, invalidField("pinLimit", ErrInvalidPinLimit)
+		}
+		if err := c.chatRepo.SetPinLimit(ctx, chatId, pinLimit); err != nil {
+			return entity.Chat{}, err
+		}
+	}
+
+	chat, err = c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+
+	c.publishToParticipants(ctx, chatId, nil, entity.EventChatUpdated, chat)
+	c.bus.Publish(ctx, entity.EventChatUpdated, chat)
+
+	return chat, nil
+}
+
+// Delete deletes a chat (only creator/admin can delete), a super admin may restore it until it is purged
 func (c *chatUsecase) Delete(ctx context.Context, chatId string, userId string) error {
 	// Get chat
 	chat, err := c.chatRepo.Get(ctx, chatId)
@@ -192,14 +703,71 @@ func (c *chatUsecase) Delete(ctx context.Context, chatId string, userId string)
 		}
 	}
 
-	return c.chatRepo.Delete(ctx, chatId)
+	// The participants and invitations are set aside with the chat, who to tell is read beforehand
+	recipientIds, err := c.chatDeletedRecipients(ctx, chatId)
+	if err != nil {
+		return err
+	}
+
+	// A delete failing half way would leave the chat without the admins who could delete it again
+	err = c.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		return c.chatRepo.Delete(ctx, chatId)
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	chat.DeletedAt = &now
+	c.publisher.PublishToUsers(ctx, recipientIds, entity.EventChatDeleted, chat)
+	c.bus.Publish(ctx, entity.EventChatDeleted, chat)
+
+	err = c.auditRepo.Create(ctx, entity.AuditLog{
+		Action:  entity.AuditActionChatDeleted,
+		ChatId:  chatId,
+		ActorId: userId,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Audit chat delete error", "error", err)
+	}
+
+	return nil
+}
+
+// chatDeletedRecipients returns the participants of a chat and the users it has pending
+// invitations for, the users the deletion of the chat is pushed to
+func (c *chatUsecase) chatDeletedRecipients(ctx context.Context, chatId string) ([]string, error) {
+	participants, err := c.chatRepo.GetParticipants(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	invitations, err := c.chatRepo.GetPendingInvitationsByChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := make([]string, 0, len(participants)+len(invitations))
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserId)
+	}
+	for _, invitation := range invitations {
+		userIds = append(userIds, invitation.InviteeId)
+	}
+	return userIds, nil
 }
 
 // CreatePersonalChat creates a 1-on-1 chat between two users
 func (c *chatUsecase) CreatePersonalChat(ctx context.Context, userId string, participantId string) (string, error) {
 	_, err := c.userRepo.Get(ctx, participantId)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return "", invalidField("participantId", ErrUnknownParticipant)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	creator, err := c.userRepo.Get(ctx, userId)
 	if err != nil {
-		return "", fmt.Errorf("participant not found")
+		return "", err
 	}
 
 	existingChat, err := c.chatRepo.GetPersonalChatBetweenUsers(ctx, userId, participantId)
@@ -207,11 +775,16 @@ func (c *chatUsecase) CreatePersonalChat(ctx context.Context, userId string, par
 		// Chat already exists, return its ID
 		return existingChat.Id, nil
 	}
+	// A failed lookup must not create a second chat between the same users
+	if !errors.Is(err, repository.ErrChatNotFound) {
+		return "", err
+	}
 
 	chat := entity.Chat{
-		Name:      "Personal",
-		Type:      entity.ChatTypePersonal,
-		CreatedBy: userId,
+		Name:        "Personal",
+		Type:        entity.ChatTypePersonal,
+		CreatedBy:   userId,
+		WorkspaceId: creator.GetWorkspaceId(),
 	}
 
 	chatId, err := c.chatRepo.Create(ctx, chat)
@@ -223,12 +796,12 @@ func (c *chatUsecase) CreatePersonalChat(ctx context.Context, userId string, par
 		{
 			ChatId: chatId,
 			UserId: userId,
-			Role:   "member",
+			Role:   entity.ParticipantRoleMember,
 		},
 		{
 			ChatId: chatId,
 			UserId: participantId,
-			Role:   "member",
+			Role:   entity.ParticipantRoleMember,
 		},
 	}
 
@@ -237,17 +810,20 @@ func (c *chatUsecase) CreatePersonalChat(ctx context.Context, userId string, par
 		return "", err
 	}
 
+	chat.Id = chatId
+	c.bus.Publish(ctx, entity.EventChatCreated, chat)
+
 	return chatId, nil
 }
 
 // CreateGroupChat creates a group chat with multiple users
 func (c *chatUsecase) CreateGroupChat(ctx context.Context, name string, description string, creatorId string, userIds []string) (string, error) {
 	if name == "" {
-		return "", fmt.Errorf("group name is required")
+		return "", invalidField("name", ErrGroupNameRequired)
 	}
 
 	if len(userIds) == 0 {
-		return "", fmt.Errorf("at least one participant is required")
+		return "", invalidField("userIds", ErrParticipantsRequired)
 	}
 
 	userFilter := entity.UserIndexFilter{
@@ -259,7 +835,20 @@ func (c *chatUsecase) CreateGroupChat(ctx context.Context, name string, descript
 	}
 
 	if len(users) != len(userIds) {
-		return "", fmt.Errorf("some user IDs are invalid")
+		return "", invalidField("userIds", ErrUnknownUsers)
+	}
+
+	creator, err := c.userRepo.Get(ctx, creatorId)
+	if err != nil {
+		return "", err
+	}
+
+	groupSize := len(userIds)
+	if !slices.Contains(userIds, creatorId) {
+		groupSize++
+	}
+	if err := c.planUc.CheckGroupSize(ctx, creator.GetWorkspaceId(), groupSize); err != nil {
+		return "", err
 	}
 
 	chat := entity.Chat{
@@ -267,6 +856,7 @@ func (c *chatUsecase) CreateGroupChat(ctx context.Context, name string, descript
 		Description: description,
 		Type:        entity.ChatTypeGroup,
 		CreatedBy:   creatorId,
+		WorkspaceId: creator.GetWorkspaceId(),
 	}
 
 	chatId, err := c.chatRepo.Create(ctx, chat)
@@ -278,7 +868,7 @@ func (c *chatUsecase) CreateGroupChat(ctx context.Context, name string, descript
 		{
 			ChatId: chatId,
 			UserId: creatorId,
-			Role:   "admin",
+			Role:   entity.ParticipantRoleAdmin,
 		},
 	}
 
@@ -287,7 +877,7 @@ func (c *chatUsecase) CreateGroupChat(ctx context.Context, name string, descript
 			participants = append(participants, entity.ChatParticipant{
 				ChatId: chatId,
 				UserId: userId,
-				Role:   "member",
+				Role:   entity.ParticipantRoleMember,
 			})
 		}
 	}
@@ -297,46 +887,234 @@ func (c *chatUsecase) CreateGroupChat(ctx context.Context, name string, descript
 		return "", err
 	}
 
+	chat.Id = chatId
+	c.bus.Publish(ctx, entity.EventChatCreated, chat)
+
 	return chatId, nil
 }
 
-// InviteUsersToGroup invites users to a group chat
-func (c *chatUsecase) InviteUsersToGroup(ctx context.Context, chatId string, inviterId string, userIds []string) error {
-	chat, err := c.chatRepo.Get(ctx, chatId)
+// CreateChannel creates a channel with its creator as the only admin
+func (c *chatUsecase) CreateChannel(ctx context.Context, name string, description string, creatorId string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", invalidField("name", ErrChannelNameRequired)
+	}
+
+	creator, err := c.userRepo.Get(ctx, creatorId)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if chat.Type != entity.ChatTypeGroup {
-		return ErrCannotInviteToPersonal
+	chat := entity.Chat{
+		Name:        name,
+		Description: description,
+		Type:        entity.ChatTypeChannel,
+		CreatedBy:   creatorId,
+		WorkspaceId: creator.GetWorkspaceId(),
 	}
 
-	isParticipant, err := c.chatRepo.IsParticipant(ctx, inviterId, chatId)
+	chatId, err := c.chatRepo.Create(ctx, chat)
+	if err != nil {
+		return "", err
+	}
+
+	participants := []entity.ChatParticipant{
+		{
+			ChatId: chatId,
+			UserId: creatorId,
+			Role:   entity.ParticipantRoleAdmin,
+		},
+	}
+	err = c.chatRepo.AddParticipants(ctx, participants)
+	if err != nil {
+		return "", err
+	}
+
+	chat.Id = chatId
+	c.bus.Publish(ctx, entity.EventChatCreated, chat)
+
+	return chatId, nil
+}
+
+// SubscribeChannel makes the user a member of a channel of their workspace, subscribing again
+// does nothing. The other members are not told, a channel may have thousands of them
+func (c *chatUsecase) SubscribeChannel(ctx context.Context, chatId string, userId string) error {
+	chat, err := c.getChannel(ctx, chatId)
 	if err != nil {
 		return err
 	}
-	if !isParticipant {
-		return ErrNotParticipant
+
+	user, err := c.userRepo.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	// The channels of other workspaces are not disclosed
+	if user.GetWorkspaceId() != chat.WorkspaceId {
+		return ErrChatNotFound
 	}
 
-	isAdmin, err := c.chatRepo.IsAdmin(ctx, inviterId, chatId)
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
 	if err != nil {
 		return err
 	}
-	if !isAdmin {
-		return ErrNotAdmin
+	if isParticipant {
+		return nil
 	}
 
-	userFilter := entity.UserIndexFilter{
-		Ids: userIds,
+	participants := []entity.ChatParticipant{
+		{
+			ChatId:      chatId,
+			UserId:      userId,
+			Role:        entity.ParticipantRoleMember,
+			HistoryFrom: c.historyFrom(chat, time.Now()),
+		},
 	}
-	users, err := c.userRepo.Index(ctx, userFilter)
-	if err != nil {
+	if err := c.chatRepo.AddParticipants(ctx, participants); err != nil {
 		return err
 	}
 
-	if len(users) != len(userIds) {
-		return fmt.Errorf("some user IDs are invalid")
+	// The chat is no longer empty, cancel any pending purge
+	if err := c.chatRepo.SetEmptySince(ctx, chatId, nil); err != nil {
+		return err
+	}
+
+	memberJoined := entity.MembershipEvent{
+		ChatId: chatId,
+		UserId: userId,
+		Role:   entity.ParticipantRoleMember,
+	}
+	c.publisher.PublishToUsers(ctx, []string{userId}, entity.EventMemberJoined, memberJoined)
+	c.bus.Publish(ctx, entity.EventMemberJoined, memberJoined)
+
+	return nil
+}
+
+// UnsubscribeChannel removes the user from a channel, its last admin has to promote someone first
+func (c *chatUsecase) UnsubscribeChannel(ctx context.Context, chatId string, userId string) error {
+	if _, err := c.getChannel(ctx, chatId); err != nil {
+		return err
+	}
+
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return err
+	}
+	// The admins are counted in the transaction removing the participant, which bumps the
+	// membership version of the channel, so of two last admins leaving at once the second one is
+	// retried and finds itself alone
+	err = c.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if participant.Role == entity.ParticipantRoleAdmin {
+			adminCount, err := c.chatRepo.CountAdmins(ctx, chatId)
+			if err != nil {
+				return err
+			}
+			if adminCount <= 1 {
+				return ErrLastChannelAdmin
+			}
+		}
+		return c.chatRepo.RemoveParticipant(ctx, userId, chatId)
+	})
+	if err != nil {
+		return err
+	}
+
+	memberLeft := entity.MembershipEvent{
+		ChatId: chatId,
+		UserId: userId,
+	}
+	c.publisher.PublishToUsers(ctx, []string{userId}, entity.EventMemberLeft, memberLeft)
+	c.bus.Publish(ctx, entity.EventMemberLeft, memberLeft)
+
+	return c.markEmptyIfNoParticipants(ctx, chatId)
+}
+
+// getChannel returns the chat if it is a channel
+func (c *chatUsecase) getChannel(ctx context.Context, chatId string) (entity.Chat, error) {
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+	if chat.Type != entity.ChatTypeChannel {
+		return entity.Chat{}, ErrInvalidChatType
+	}
+	return chat, nil
+}
+
+// CanPost loads the chat first so a deleted or missing chat fails with ErrChatNotFound before the
+// membership is checked, only the admins of a channel post in it
+func (c *chatUsecase) CanPost(ctx context.Context, chatId string, userId string) error {
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return ErrChatNotFound
+		}
+		return err
+	}
+
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return err
+	}
+	if chat.Type == entity.ChatTypeChannel && participant.Role != entity.ParticipantRoleAdmin {
+		return ErrChannelReadOnly
+	}
+	return nil
+}
+
+// InviteUsersToGroup invites users to a group chat, the optional note is shown in their invite card
+func (c *chatUsecase) InviteUsersToGroup(ctx context.Context, chatId string, inviterId string, userIds []string, note string) error {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > maxInvitationNoteLength {
+		return invalidField("note", ErrInvitationNoteTooLong)
+	}
+
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return err
+	}
+
+	if !chat.HasAdmins() {
+		return ErrCannotInviteToPersonal
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, inviterId, chatId)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		return ErrNotParticipant
+	}
+
+	isAdmin, err := c.chatRepo.IsAdmin(ctx, inviterId, chatId)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return ErrNotAdmin
+	}
+
+	userFilter := entity.UserIndexFilter{
+		Ids: userIds,
+	}
+	users, err := c.userRepo.Index(ctx, userFilter)
+	if err != nil {
+		return err
+	}
+
+	if len(users) != len(userIds) {
+		return invalidField("userIds", ErrUnknownUsers)
+	}
+
+	if err := c.checkGroupCapacity(ctx, chat, len(userIds)); err != nil {
+		return err
+	}
+
+	preview, err := c.invitationPreview(ctx, chat, inviterId)
+	if err != nil {
+		return err
 	}
 
 	for _, userId := range userIds {
@@ -353,18 +1131,94 @@ func (c *chatUsecase) InviteUsersToGroup(ctx context.Context, chatId string, inv
 			continue // Skip if invitation already exists
 		}
 
+		now := time.Now()
+		expiresAt := now.Add(c.policy.InvitationTTL)
 		invitation := entity.ChatInvitation{
 			ChatId:    chatId,
 			InviterId: inviterId,
 			InviteeId: userId,
+			Status:    "pending",
+			CreatedAt: now,
+			ExpiresAt: &expiresAt,
+			Note:      note,
 		}
 
-		_, err = c.chatRepo.CreateInvitation(ctx, invitation)
+		invitation.Id, err = c.chatRepo.CreateInvitation(ctx, invitation)
 		if err != nil {
 			return err
 		}
+		invitation.Preview = &preview
+
+		c.publisher.PublishToUsers(ctx, []string{userId}, entity.EventInvitationReceived, invitation)
+	}
+
+	return nil
+}
+
+// AddGuest adds a user to a group chat for a limited time, such as an external contractor. Past
+// expiresAt they are no longer a participant and ExpireGuests removes them
+func (c *chatUsecase) AddGuest(ctx context.Context, chatId string, adminId string, userId string, expiresAt time.Time) error {
+	now := time.Now()
+	if !expiresAt.After(now) || expiresAt.After(now.Add(maxGuestAccess)) {
+		return invalidField("expiresAt", ErrInvalidGuestExpiry)
+	}
+
+	chat, err := c.requireGroupAdmin(ctx, chatId, adminId)
+	if err != nil {
+		return err
+	}
+	if chat.Type != entity.ChatTypeGroup {
+		return ErrInvalidChatType
+	}
+
+	if _, err := c.userRepo.Get(ctx, userId); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return invalidField("userId", ErrUnknownUsers)
+		}
+		return err
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return err
+	}
+	if isParticipant {
+		return ErrAlreadyParticipant
+	}
+
+	if err := c.checkGroupCapacity(ctx, chat, 1); err != nil {
+		return err
 	}
 
+	expiresAt = expiresAt.UTC()
+	err = c.chatRepo.AddParticipants(ctx, []entity.ChatParticipant{
+		{
+			ChatId:      chatId,
+			UserId:      userId,
+			Role:        entity.ParticipantRoleMember,
+			HistoryFrom: c.historyFrom(chat, now),
+			ExpiresAt:   &expiresAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The chat is no longer empty, cancel any pending purge
+	if err := c.chatRepo.SetEmptySince(ctx, chatId, nil); err != nil {
+		return err
+	}
+
+	memberJoined := entity.MembershipEvent{
+		ChatId:  chatId,
+		UserId:  userId,
+		ActorId: adminId,
+		Role:    entity.ParticipantRoleMember,
+	}
+	c.publishToParticipants(ctx, chatId, nil, entity.EventMemberJoined, memberJoined)
+	c.bus.Publish(ctx, entity.EventMemberJoined, memberJoined)
+	c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s added %s as a guest", c.userName(ctx, adminId), c.userName(ctx, userId)))
+
 	return nil
 }
 
@@ -375,8 +1229,11 @@ func (c *chatUsecase) LeaveGroup(ctx context.Context, chatId string, userId stri
 		return err
 	}
 
+	if chat.Type == entity.ChatTypeChannel {
+		return c.UnsubscribeChannel(ctx, chatId, userId)
+	}
 	if chat.Type != entity.ChatTypeGroup {
-		return fmt.Errorf("cannot leave personal chat")
+		return ErrCannotLeavePersonal
 	}
 
 	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
@@ -387,101 +1244,1679 @@ func (c *chatUsecase) LeaveGroup(ctx context.Context, chatId string, userId stri
 		return ErrNotParticipant
 	}
 
-	return c.chatRepo.RemoveParticipant(ctx, userId, chatId)
-}
+	err = c.chatRepo.RemoveParticipant(ctx, userId, chatId)
+	if err != nil {
+		return err
+	}
 
-// GetPendingInvitations returns all pending invitations for a user
-func (c *chatUsecase) GetPendingInvitations(ctx context.Context, userId string) ([]entity.ChatInvitation, error) {
-	return c.chatRepo.GetPendingInvitations(ctx, userId)
+	memberLeft := entity.MembershipEvent{
+		ChatId: chatId,
+		UserId: userId,
+	}
+	c.publishToParticipants(ctx, chatId, []string{userId}, entity.EventMemberLeft, memberLeft)
+	c.bus.Publish(ctx, entity.EventMemberLeft, memberLeft)
+	c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s left", c.userName(ctx, userId)))
+
+	return c.markEmptyIfNoParticipants(ctx, chatId)
 }
 
-// RespondToInvitation allows a user to accept or reject an invitation
-func (c *chatUsecase) RespondToInvitation(ctx context.Context, invitationId string, userId string, accept bool) error {
-	invitation, err := c.chatRepo.GetInvitation(ctx, invitationId)
+// UpdateParticipantRole promotes or demotes a group participant (admin only)
+func (c *chatUsecase) UpdateParticipantRole(ctx context.Context, chatId string, adminId string, targetUserId string, role string) error {
+	if role != entity.ParticipantRoleAdmin && role != entity.ParticipantRoleMember {
+		return invalidField("role", ErrInvalidRole)
+	}
+
+	chat, err := c.requireGroupAdmin(ctx, chatId, adminId)
 	if err != nil {
 		return err
 	}
 
-	if invitation.InviteeId != userId {
-		return ErrInvalidInvitation
+	target, err := c.chatRepo.GetParticipantByUserAndChat(ctx, targetUserId, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotParticipant) {
+			return ErrMemberNotFound
+		}
+		return err
 	}
 
-	if invitation.Status != "pending" {
-		return fmt.Errorf("invitation has already been responded to")
+	if target.Role == role {
+		return nil
 	}
-
-	status := "rejected"
-	if accept {
-		status = "accepted"
+	if target.IsGuest() && role == entity.ParticipantRoleAdmin {
+		return ErrGuestCannotBeAdmin
 	}
 
-	err = c.chatRepo.UpdateInvitationStatus(ctx, invitationId, status)
+	// A group must always keep at least one admin. The admins are counted in the transaction
+	// changing the role, which bumps the membership version of the group, so of two admins demoted
+	// at once the second demotion is retried and sees the last admin
+	err = c.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if target.Role == entity.ParticipantRoleAdmin && role == entity.ParticipantRoleMember {
+			adminCount, err := c.chatRepo.CountAdmins(ctx, chatId)
+			if err != nil {
+				return err
+			}
+			if adminCount <= 1 {
+				return ErrLastAdmin
+			}
+		}
+		return c.chatRepo.UpdateParticipantRole(ctx, targetUserId, chatId, role)
+	})
 	if err != nil {
 		return err
 	}
 
-	if accept {
-		participants := []entity.ChatParticipant{
-			{
-				ChatId: invitation.ChatId,
-				UserId: userId,
-				Role:   "member",
-			},
-		}
+	c.publishToParticipants(ctx, chatId, nil, entity.EventRoleChanged, entity.MembershipEvent{
+		ChatId:  chatId,
+		UserId:  targetUserId,
+		ActorId: adminId,
+		Role:    role,
+	})
 
-		err = c.chatRepo.AddParticipants(ctx, participants)
-		if err != nil {
-			return err
-		}
+	text := "%s made %s an admin"
+	if role == entity.ParticipantRoleMember {
+		text = "%s removed %s as an admin"
 	}
+	c.postMembershipMessage(ctx, chat, fmt.Sprintf(text, c.userName(ctx, adminId), c.userName(ctx, targetUserId)))
 
 	return nil
 }
 
-// GetParticipants returns all participants of a chat
-func (c *chatUsecase) GetParticipants(ctx context.Context, chatId string, userId string) ([]entity.User, error) {
-	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+// RemoveMember removes another participant from a group chat (admin only)
+func (c *chatUsecase) RemoveMember(ctx context.Context, chatId string, adminId string, targetUserId string) error {
+	if adminId == targetUserId {
+		return ErrCannotRemoveSelf
+	}
+
+	chat, err := c.requireGroupAdmin(ctx, chatId, adminId)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, targetUserId, chatId)
+	if err != nil {
+		return err
 	}
 	if !isParticipant {
-		return nil, ErrNotParticipant
+		return ErrMemberNotFound
+	}
+
+	err = c.chatRepo.RemoveParticipant(ctx, targetUserId, chatId)
+	if err != nil {
+		return err
 	}
 
+	memberRemoved := entity.MembershipEvent{
+		ChatId:  chatId,
+		UserId:  targetUserId,
+		ActorId: adminId,
+	}
+	c.publishToParticipants(ctx, chatId, []string{targetUserId}, entity.EventMemberRemoved, memberRemoved)
+	c.bus.Publish(ctx, entity.EventMemberRemoved, memberRemoved)
+	c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s removed %s", c.userName(ctx, adminId), c.userName(ctx, targetUserId)))
+
+	return c.markEmptyIfNoParticipants(ctx, chatId)
+}
+
+// publishToParticipants sends an event to every participant of the chat plus the extra users,
+// failures are only logged since the change itself already succeeded
+func (c *chatUsecase) publishToParticipants(ctx context.Context, chatId string, extraUserIds []string, eventType string, data any) {
 	participants, err := c.chatRepo.GetParticipants(ctx, chatId)
 	if err != nil {
-		return nil, err
+		slog.ErrorContext(ctx, "Get participants for event error", "event", eventType, "chat_id", chatId, "error", err)
+		return
 	}
 
-	var userIds []string
+	userIds := make([]string, 0, len(participants)+len(extraUserIds))
 	for _, participant := range participants {
 		userIds = append(userIds, participant.UserId)
 	}
+	userIds = append(userIds, extraUserIds...)
 
-	userFilter := entity.UserIndexFilter{
-		Ids: userIds,
+	c.publisher.PublishToUsers(ctx, userIds, eventType, data)
+}
+
+// publishToAdmins is publishToParticipants for the admins of the chat only
+func (c *chatUsecase) publishToAdmins(ctx context.Context, chatId string, extraUserIds []string, eventType string, data any) {
+	participants, err := c.chatRepo.GetParticipants(ctx, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get participants for event error", "event", eventType, "chat_id", chatId, "error", err)
+		return
 	}
-	users, err := c.userRepo.Index(ctx, userFilter)
+
+	userIds := make([]string, 0, len(extraUserIds)+1)
+	for _, participant := range participants {
+		if participant.Role == entity.ParticipantRoleAdmin {
+			userIds = append(userIds, participant.UserId)
+		}
+	}
+	userIds = append(userIds, extraUserIds...)
+
+	c.publisher.PublishToUsers(ctx, userIds, eventType, data)
+}
+
+// requireGroupAdmin checks that the chat is a group or a channel and the user is one of its admins
+func (c *chatUsecase) requireGroupAdmin(ctx context.Context, chatId string, userId string) (entity.Chat, error) {
+	chat, err := c.chatRepo.Get(ctx, chatId)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+
+	if !chat.HasAdmins() {
+		return entity.Chat{}, ErrInvalidChatType
 	}
 
-	for i := range users {
-		users[i].Password = ""
+	isAdmin, err := c.chatRepo.IsAdmin(ctx, userId, chatId)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+	if !isAdmin {
+		return entity.Chat{}, ErrNotAdmin
 	}
 
-	return users, nil
+	return chat, nil
 }
 
-// GetMessages returns messages for a chat
-func (c *chatUsecase) GetMessages(ctx context.Context, chatId string, userId string, limit, offset int) ([]entity.Message, error) {
-	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+// GetPendingInvitations returns all pending invitations for a user with a preview of their group
+func (c *chatUsecase) GetPendingInvitations(ctx context.Context, userId string) ([]entity.ChatInvitation, error) {
+	invitations, err := c.chatRepo.GetPendingInvitations(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
-	if !isParticipant {
-		return nil, ErrNotParticipant
+
+	for i := range invitations {
+		chat, err := c.chatRepo.Get(ctx, invitations[i].ChatId)
+		if err != nil {
+			// The group may have been purged since, the invitation is still listed
+			slog.ErrorContext(ctx, "Get invited chat error", "error", err)
+			continue
+		}
+
+		preview, err := c.invitationPreview(ctx, chat, invitations[i].InviterId)
+		if err != nil {
+			return nil, err
+		}
+		invitations[i].Preview = &preview
+	}
+
+	return invitations, nil
+}
+
+// invitationPreview returns what an invitee sees of the group before joining it
+func (c *chatUsecase) invitationPreview(ctx context.Context, chat entity.Chat, inviterId string) (entity.InvitationPreview, error) {
+	memberCount, err := c.chatRepo.CountParticipants(ctx, chat.Id)
+	if err != nil {
+		return entity.InvitationPreview{}, err
+	}
+
+	preview := entity.InvitationPreview{
+		ChatName:    chat.Name,
+		Description: chat.Description,
+		Avatar:      chat.Avatar,
+		MemberCount: memberCount,
+	}
+
+	inviter, err := c.userRepo.Get(ctx, inviterId)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return entity.InvitationPreview{}, err
+	}
+	preview.InviterName = inviter.Name
+
+	return preview, nil
+}
+
+// RespondToInvitation allows a user to accept or reject an invitation
+func (c *chatUsecase) RespondToInvitation(ctx context.Context, invitationId string, userId string, accept bool) error {
+	invitation, err := c.chatRepo.GetInvitation(ctx, invitationId)
+	if err != nil {
+		return err
+	}
+
+	if invitation.InviteeId != userId {
+		return ErrInvalidInvitation
+	}
+
+	if invitation.Status != "pending" {
+		return ErrInvitationResponded
+	}
+	if invitation.IsExpiredAt(time.Now()) {
+		return ErrInvitationExpired
+	}
+
+	if accept {
+		chat, err := c.chatRepo.Get(ctx, invitation.ChatId)
+		if err != nil {
+			return err
+		}
+		if err := c.checkGroupCapacity(ctx, chat, 1); err != nil {
+			return err
+		}
+	}
+
+	status := "rejected"
+	if accept {
+		status = "accepted"
+	}
+
+	err = c.chatRepo.UpdateInvitationStatus(ctx, invitationId, status)
+	if errors.Is(err, repository.ErrInvitationNotFound) {
+		// Cancelled or expired meanwhile
+		return ErrInvitationResponded
+	}
+	if err != nil {
+		return err
+	}
+
+	if accept {
+		chat, err := c.chatRepo.Get(ctx, invitation.ChatId)
+		if err != nil {
+			return err
+		}
+
+		participants := []entity.ChatParticipant{
+			{
+				ChatId:      invitation.ChatId,
+				UserId:      userId,
+				Role:        entity.ParticipantRoleMember,
+				HistoryFrom: c.historyFrom(chat, time.Now()),
+			},
+		}
+
+		err = c.chatRepo.AddParticipants(ctx, participants)
+		if err != nil {
+			return err
+		}
+
+		// The chat is no longer empty, cancel any pending purge
+		err = c.chatRepo.SetEmptySince(ctx, invitation.ChatId, nil)
+		if err != nil {
+			return err
+		}
+
+		memberJoined := entity.MembershipEvent{
+			ChatId:  invitation.ChatId,
+			UserId:  userId,
+			ActorId: invitation.InviterId,
+			Role:    entity.ParticipantRoleMember,
+		}
+		c.publishToParticipants(ctx, invitation.ChatId, nil, entity.EventMemberJoined, memberJoined)
+		c.bus.Publish(ctx, entity.EventMemberJoined, memberJoined)
+		c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s added %s", c.userName(ctx, invitation.InviterId), c.userName(ctx, userId)))
+	}
+
+	invitation.Status = status
+	c.publisher.PublishToUsers(ctx, []string{invitation.InviterId}, entity.EventInvitationResponded, invitation)
+	c.bus.Publish(ctx, entity.EventInvitationResponded, invitation)
+
+	return nil
+}
+
+func (c *chatUsecase) GetChatInvitations(ctx context.Context, chatId string, userId string) ([]entity.ChatInvitation, error) {
+	if _, err := c.requireGroupAdmin(ctx, chatId, userId); err != nil {
+		return nil, err
+	}
+
+	return c.chatRepo.GetPendingInvitationsByChat(ctx, chatId)
+}
+
+// CancelInvitation tells the invitee, so the invite card goes away
+func (c *chatUsecase) CancelInvitation(ctx context.Context, invitationId string, userId string) error {
+	invitation, err := c.chatRepo.GetInvitation(ctx, invitationId)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvitationNotFound) {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+
+	if invitation.InviterId != userId {
+		isAdmin, err := c.chatRepo.IsAdmin(ctx, userId, invitation.ChatId)
+		if err != nil {
+			return err
+		}
+		if !isAdmin {
+			return ErrInvalidInvitation
+		}
+	}
+
+	if invitation.Status != "pending" {
+		return ErrInvitationResponded
+	}
+
+	err = c.chatRepo.UpdateInvitationStatus(ctx, invitationId, "cancelled")
+	if errors.Is(err, repository.ErrInvitationNotFound) {
+		// Answered meanwhile
+		return ErrInvitationResponded
+	}
+	if err != nil {
+		return err
+	}
+
+	invitation.Status = "cancelled"
+	c.publisher.PublishToUsers(ctx, []string{invitation.InviteeId}, entity.EventInvitationCancelled, invitation)
+
+	return nil
+}
+
+// CreateInviteLink creates an invite link of a group or a channel and returns it with its token,
+// the only time the token is handed out
+func (c *chatUsecase) CreateInviteLink(ctx context.Context, chatId string, userId string, req entity.CreateInviteLinkRequest) (entity.ChatInviteLink, error) {
+	now := time.Now()
+	expiresAt := now.Add(c.policy.InviteLinkTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) || req.ExpiresAt.After(now.Add(maxInviteLinkTTL)) {
+			return entity.ChatInviteLink{}, invalidField("expiresAt", ErrInvalidInviteLinkExpiry)
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	if _, err := c.requireGroupAdmin(ctx, chatId, userId); err != nil {
+		return entity.ChatInviteLink{}, err
+	}
+
+	link, err := c.chatRepo.CreateInviteLink(ctx, entity.ChatInviteLink{
+		ChatId:           chatId,
+		CreatedBy:        userId,
+		RequiresApproval: req.RequiresApproval,
+		ExpiresAt:        expiresAt.UTC(),
+	})
+	if err != nil {
+		return entity.ChatInviteLink{}, err
+	}
+
+	link.Token, err = c.inviteLinks.GenerateInviteLinkToken(link.Id, link.ExpiresAt)
+	if err != nil {
+		return entity.ChatInviteLink{}, fmt.Errorf("sign invite link: %w", err)
+	}
+
+	return link, nil
+}
+
+// GetInviteLinks returns the invite links of a chat still letting users join, without their tokens
+func (c *chatUsecase) GetInviteLinks(ctx context.Context, chatId string, userId string) ([]entity.ChatInviteLink, error) {
+	if _, err := c.requireGroupAdmin(ctx, chatId, userId); err != nil {
+		return nil, err
+	}
+
+	return c.chatRepo.GetActiveInviteLinks(ctx, chatId)
+}
+
+// RevokeInviteLink stops an invite link from letting anyone else join, its tokens are refused
+// from then on
+func (c *chatUsecase) RevokeInviteLink(ctx context.Context, chatId string, linkId string, userId string) error {
+	if _, err := c.requireGroupAdmin(ctx, chatId, userId); err != nil {
+		return err
+	}
+
+	err := c.chatRepo.RevokeInviteLink(ctx, chatId, linkId)
+	if errors.Is(err, repository.ErrInviteLinkNotFound) {
+		return ErrInviteLinkNotFound
+	}
+	return err
+}
+
+// JoinByInviteLink checks the signature of the token before the link it points to, a link that
+// was revoked or expired is refused even while its token is still valid
+func (c *chatUsecase) JoinByInviteLink(ctx context.Context, token string, userId string) (entity.JoinChatResult, error) {
+	linkId, err := c.inviteLinks.ValidateInviteLinkToken(token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrExpiredToken) {
+			return entity.JoinChatResult{}, ErrInviteLinkExpired
+		}
+		return entity.JoinChatResult{}, ErrInvalidInviteLink
+	}
+
+	link, err := c.chatRepo.GetInviteLink(ctx, linkId)
+	if err != nil {
+		if errors.Is(err, repository.ErrInviteLinkNotFound) {
+			return entity.JoinChatResult{}, ErrInvalidInviteLink
+		}
+		return entity.JoinChatResult{}, err
+	}
+	if link.RevokedAt != nil {
+		return entity.JoinChatResult{}, ErrInviteLinkRevoked
+	}
+	if !link.IsUsableAt(time.Now()) {
+		return entity.JoinChatResult{}, ErrInviteLinkExpired
+	}
+
+	chat, err := c.chatRepo.Get(ctx, link.ChatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.JoinChatResult{}, ErrChatNotFound
+		}
+		return entity.JoinChatResult{}, err
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chat.Id)
+	if err != nil {
+		return entity.JoinChatResult{}, err
+	}
+	if isParticipant {
+		return entity.JoinChatResult{}, ErrAlreadyParticipant
+	}
+
+	if link.RequiresApproval {
+		return c.createJoinRequest(ctx, chat, userId, link.Id)
+	}
+
+	if err := c.checkGroupCapacity(ctx, chat, 1); err != nil {
+		return entity.JoinChatResult{}, err
+	}
+	if err := c.addMember(ctx, chat, userId, link.CreatedBy); err != nil {
+		return entity.JoinChatResult{}, err
+	}
+	c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s joined using an invite link", c.userName(ctx, userId)))
+
+	return entity.JoinChatResult{ChatId: chat.Id, Joined: true}, nil
+}
+
+// RequestToJoin lets a user of the workspace of a group join it as its join policy allows. Asking
+// again while a request is pending returns that request
+func (c *chatUsecase) RequestToJoin(ctx context.Context, chatId string, userId string) (entity.JoinChatResult, error) {
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.JoinChatResult{}, ErrChatNotFound
+		}
+		return entity.JoinChatResult{}, err
+	}
+	if chat.Type != entity.ChatTypeGroup {
+		return entity.JoinChatResult{}, ErrInvalidChatType
+	}
+
+	user, err := c.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.JoinChatResult{}, err
+	}
+	// The groups of other workspaces are not disclosed
+	if user.GetWorkspaceId() != chat.WorkspaceId {
+		return entity.JoinChatResult{}, ErrChatNotFound
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return entity.JoinChatResult{}, err
+	}
+	if isParticipant {
+		return entity.JoinChatResult{}, ErrAlreadyParticipant
+	}
+
+	switch chat.JoinPolicy {
+	case entity.JoinPolicyOpen:
+		if err := c.checkGroupCapacity(ctx, chat, 1); err != nil {
+			return entity.JoinChatResult{}, err
+		}
+		if err := c.addMember(ctx, chat, userId, ""); err != nil {
+			return entity.JoinChatResult{}, err
+		}
+		c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s joined", c.userName(ctx, userId)))
+		return entity.JoinChatResult{ChatId: chatId, Joined: true}, nil
+	case entity.JoinPolicyApproval:
+		return c.createJoinRequest(ctx, chat, userId, "")
+	default:
+		return entity.JoinChatResult{}, ErrInviteOnly
+	}
+}
+
+// createJoinRequest leaves a join request for the admins of the chat and tells them, the pending
+// request of the user is returned instead when there is one
+func (c *chatUsecase) createJoinRequest(ctx context.Context, chat entity.Chat, userId string, linkId string) (entity.JoinChatResult, error) {
+	request, err := c.chatRepo.GetPendingJoinRequest(ctx, userId, chat.Id)
+	if errors.Is(err, repository.ErrJoinRequestNotFound) {
+		request, err = c.chatRepo.CreateJoinRequest(ctx, entity.ChatJoinRequest{
+			ChatId: chat.Id,
+			UserId: userId,
+			LinkId: linkId,
+		})
+		if err == nil {
+			c.publishToAdmins(ctx, chat.Id, nil, entity.EventJoinRequestReceived, request)
+		}
+	}
+	if err != nil {
+		return entity.JoinChatResult{}, err
+	}
+
+	return entity.JoinChatResult{ChatId: chat.Id, JoinRequest: &request}, nil
+}
+
+func (c *chatUsecase) GetJoinRequests(ctx context.Context, chatId string, userId string) ([]entity.ChatJoinRequest, error) {
+	if _, err := c.requireGroupAdmin(ctx, chatId, userId); err != nil {
+		return nil, err
+	}
+
+	return c.chatRepo.GetPendingJoinRequestsByChat(ctx, chatId)
+}
+
+// RespondToJoinRequest lets the user in or turns them down, either way they are told
+func (c *chatUsecase) RespondToJoinRequest(ctx context.Context, chatId string, requestId string, adminId string, approve bool) error {
+	chat, err := c.requireGroupAdmin(ctx, chatId, adminId)
+	if err != nil {
+		return err
+	}
+
+	request, err := c.chatRepo.GetJoinRequest(ctx, requestId)
+	if err != nil {
+		if errors.Is(err, repository.ErrJoinRequestNotFound) {
+			return ErrJoinRequestNotFound
+		}
+		return err
+	}
+	if request.ChatId != chatId {
+		return ErrJoinRequestNotFound
+	}
+
+	if request.Status != entity.JoinRequestStatusPending {
+		return ErrJoinRequestResponded
+	}
+
+	if approve {
+		isParticipant, err := c.chatRepo.IsParticipant(ctx, request.UserId, chat.Id)
+		if err != nil {
+			return err
+		}
+		if isParticipant {
+			return ErrAlreadyParticipant
+		}
+		if err := c.checkGroupCapacity(ctx, chat, 1); err != nil {
+			return err
+		}
+	}
+
+	status := entity.JoinRequestStatusDenied
+	if approve {
+		status = entity.JoinRequestStatusApproved
+	}
+
+	err = c.chatRepo.UpdateJoinRequestStatus(ctx, requestId, status, adminId)
+	if errors.Is(err, repository.ErrJoinRequestNotFound) {
+		// Answered by another admin meanwhile
+		return ErrJoinRequestResponded
+	}
+	if err != nil {
+		return err
+	}
+
+	if approve {
+		if err := c.addMember(ctx, chat, request.UserId, adminId); err != nil {
+			return err
+		}
+		c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s added %s", c.userName(ctx, adminId), c.userName(ctx, request.UserId)))
+	}
+
+	now := time.Now()
+	request.Status = status
+	request.RespondedAt = &now
+	request.RespondedBy = adminId
+	c.publishToAdmins(ctx, chat.Id, []string{request.UserId}, entity.EventJoinRequestResponded, request)
+
+	return nil
+}
+
+// addMember adds a user to a group or a channel as a member, after the capacity of the chat was
+// checked. actorId is the admin who let them in, empty when they joined an open group
+func (c *chatUsecase) addMember(ctx context.Context, chat entity.Chat, userId string, actorId string) error {
+	err := c.chatRepo.AddParticipants(ctx, []entity.ChatParticipant{
+		{
+			ChatId:      chat.Id,
+			UserId:      userId,
+			Role:        entity.ParticipantRoleMember,
+			HistoryFrom: c.historyFrom(chat, time.Now()),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The chat is no longer empty, cancel any pending purge
+	if err := c.chatRepo.SetEmptySince(ctx, chat.Id, nil); err != nil {
+		return err
+	}
+
+	memberJoined := entity.MembershipEvent{
+		ChatId:  chat.Id,
+		UserId:  userId,
+		ActorId: actorId,
+		Role:    entity.ParticipantRoleMember,
+	}
+	c.publishToParticipants(ctx, chat.Id, nil, entity.EventMemberJoined, memberJoined)
+	c.bus.Publish(ctx, entity.EventMemberJoined, memberJoined)
+
+	return nil
+}
+
+// GetParticipants returns all participants of a chat
+func (c *chatUsecase) GetParticipants(ctx context.Context, chatId string, userId string, fields entity.Fields) ([]entity.PublicUser, error) {
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if !isParticipant {
+		return nil, ErrNotParticipant
+	}
+
+	participants, err := c.chatRepo.GetParticipants(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	var userIds []string
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserId)
+	}
+
+	userFilter := entity.UserIndexFilter{
+		Ids:    userIds,
+		Fields: fields,
+	}
+	users, err := c.userRepo.Index(ctx, userFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	publicUsers := make([]entity.PublicUser, 0, len(users))
+	for _, user := range users {
+		publicUsers = append(publicUsers, c.profile.Public(user))
+	}
+
+	return publicUsers, nil
+}
+
+// GetMembershipVersion returns the current membership version of a chat
+func (c *chatUsecase) GetMembershipVersion(ctx context.Context, chatId string, userId string) (entity.MembershipVersion, error) {
+	chat, err := c.getChatForParticipant(ctx, chatId, userId)
+	if err != nil {
+		return entity.MembershipVersion{}, err
+	}
+
+	return entity.MembershipVersion{
+		ChatId:  chat.Id,
+		Version: chat.MembershipVersion,
+	}, nil
+}
+
+// GetMembershipDiff returns the member list changes since a version, collapsed to the latest state of each member.
+// A zero version returns the full member list as a reset
+func (c *chatUsecase) GetMembershipDiff(ctx context.Context, chatId string, userId string, sinceVersion int64) (entity.MembershipDiff, error) {
+	chat, err := c.getChatForParticipant(ctx, chatId, userId)
+	if err != nil {
+		return entity.MembershipDiff{}, err
+	}
+
+	if sinceVersion < 0 || sinceVersion > chat.MembershipVersion {
+		return entity.MembershipDiff{}, ErrInvalidMembershipVersion
+	}
+
+	diff := entity.MembershipDiff{
+		ChatId:      chat.Id,
+		FromVersion: sinceVersion,
+		Version:     sinceVersion,
+		Added:       []entity.ChatParticipant{},
+		Updated:     []entity.ChatParticipant{},
+		Removed:     []string{},
+	}
+
+	if sinceVersion == 0 {
+		participants, err := c.chatRepo.GetParticipants(ctx, chatId)
+		if err != nil {
+			return entity.MembershipDiff{}, err
+		}
+
+		diff.Reset = true
+		diff.Version = chat.MembershipVersion
+		diff.Added = participants
+		return diff, nil
+	}
+
+	changes, err := c.chatRepo.GetMembershipChanges(ctx, chatId, sinceVersion)
+	if err != nil {
+		return entity.MembershipDiff{}, err
+	}
+
+	// Keep the latest state of every member, in the order they were last changed
+	latest := make(map[string]entity.MembershipChange)
+	order := []string{}
+	for _, change := range changes {
+		// Stop at a gap, the missing change is still being written
+		if change.Version != diff.Version+1 || change.Version > chat.MembershipVersion {
+			break
+		}
+		diff.Version = change.Version
+
+		previous, seen := latest[change.UserId]
+		if !seen {
+			order = append(order, change.UserId)
+		}
+		// A role change of a member added in this window is still an addition
+		if seen && previous.Action == entity.MembershipChangeAdded && change.Action == entity.MembershipChangeRoleUpdated {
+			change.Action = entity.MembershipChangeAdded
+		}
+		latest[change.UserId] = change
+	}
+
+	for _, memberId := range order {
+		change := latest[memberId]
+		participant := entity.ChatParticipant{
+			ChatId:   chat.Id,
+			UserId:   change.UserId,
+			Role:     change.Role,
+			IsActive: true,
+		}
+
+		switch change.Action {
+		case entity.MembershipChangeAdded:
+			participant.JoinedAt = change.CreatedAt
+			diff.Added = append(diff.Added, participant)
+		case entity.MembershipChangeRoleUpdated:
+			diff.Updated = append(diff.Updated, participant)
+		case entity.MembershipChangeRemoved:
+			diff.Removed = append(diff.Removed, change.UserId)
+		}
+	}
+
+	return diff, nil
+}
+
+// getChatForParticipant returns the chat if the user is one of its participants
+func (c *chatUsecase) getChatForParticipant(ctx context.Context, chatId string, userId string) (entity.Chat, error) {
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, ErrChatNotFound
+		}
+		return entity.Chat{}, err
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return entity.Chat{}, err
+	}
+	if !isParticipant {
+		return entity.Chat{}, ErrNotParticipant
+	}
+
+	return chat, nil
+}
+
+// GetMessages returns messages for a chat
+func (c *chatUsecase) GetMessages(ctx context.Context, chatId string, userId string, limit, offset int, fields entity.Fields) ([]entity.Message, error) {
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older messages are hidden on plans with a limited history and from members who joined later
+	since, err := c.visibleSince(ctx, chat, participant)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, cached, err := c.messageCacheUc.Latest(ctx, chatId, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		// The page is shared by every participant, each only sees their own history
+		visible := make([]entity.Message, 0, len(messages))
+		for _, message := range messages {
+			if message.Timestamp >= since {
+				visible = append(visible, message)
+			}
+		}
+		messages = visible
+	} else {
+		messages, err = c.messageRepo.Index(ctx, entity.MessageIndexFilter{
+			ChatId: chatId,
+			Limit:  limit,
+			Offset: offset,
+			Since:  since,
+			Fields: fields,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !fields.Has("deliveryState", "receipts", "deliveredAt", "readAt") {
+		return messages, nil
+	}
+	if err := c.attachDeliveryStates(ctx, messages, userId); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// SearchLimit returns how many messages a search returns for the requested limit
+func SearchLimit(limit int) int {
+	if limit <= 0 {
+		return defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		return maxSearchLimit
+	}
+	return limit
+}
+
+// SearchMessages searches the messages of one chat of the user, or of all their chats without a chat id.
+// Each chat stays within the history its workspace plan and the user's history marker allow
+func (c *chatUsecase) SearchMessages(ctx context.Context, userId string, filter entity.MessageSearchFilter) ([]entity.Message, error) {
+	if filter.Before > 0 && filter.After > 0 && filter.After >= filter.Before {
+		return nil, invalidField("after", ErrInvalidSearchRange)
+	}
+	filter.Limit = SearchLimit(filter.Limit)
+
+	var chats []entity.Chat
+	if filter.ChatId != "" {
+		chat, err := c.getChatForParticipant(ctx, filter.ChatId, userId)
+		if err != nil {
+			return nil, err
+		}
+		chats = []entity.Chat{chat}
+	} else {
+		userChats, err := c.chatRepo.Index(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		chats = userChats
+	}
+
+	// The members who joined with a limited history only search from their marker
+	participations, err := c.chatRepo.GetParticipationsByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	historyFrom := make(map[string]int64, len(participations))
+	for _, participation := range participations {
+		if participation.HistoryFrom != nil {
+			historyFrom[participation.ChatId] = participation.HistoryFrom.UnixMilli()
+		}
+	}
+
+	// Chats of the same workspace share their plan cutoff, chats with the same cutoff share a scope
+	cutoffByWorkspace := make(map[string]int64)
+	scopeBySince := make(map[int64]*entity.MessageSearchScope)
+	filter.Scopes = nil
+	for _, chat := range chats {
+		cutoff, ok := cutoffByWorkspace[chat.WorkspaceId]
+		if !ok {
+			cutoff, err = c.planUc.HistoryCutoff(ctx, chat.WorkspaceId)
+			if err != nil {
+				return nil, err
+			}
+			cutoffByWorkspace[chat.WorkspaceId] = cutoff
+		}
+
+		since := max(cutoff, historyFrom[chat.Id])
+		scope, ok := scopeBySince[since]
+		if !ok {
+			scope = &entity.MessageSearchScope{Since: since}
+			scopeBySince[since] = scope
+		}
+		scope.ChatIds = append(scope.ChatIds, chat.Id)
+	}
+	for _, scope := range scopeBySince {
+		filter.Scopes = append(filter.Scopes, *scope)
+	}
+
+	messages, err := c.messageRepo.Search(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.attachDeliveryStates(ctx, messages, userId); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// getParticipant returns the membership of the user in the chat
+func (c *chatUsecase) getParticipant(ctx context.Context, chatId string, userId string) (entity.ChatParticipant, error) {
+	participant, err := c.chatRepo.GetParticipantByUserAndChat(ctx, userId, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotParticipant) {
+			return entity.ChatParticipant{}, ErrNotParticipant
+		}
+		return entity.ChatParticipant{}, err
+	}
+	return participant, nil
+}
+
+// historyFrom returns the oldest message a member joining at the given time may read following
+// the history access of the chat, nil for the whole history
+func (c *chatUsecase) historyFrom(chat entity.Chat, joinedAt time.Time) *time.Time {
+	access := chat.HistoryAccess
+	if access == "" {
+		access = c.policy.DefaultHistoryAccess
+	}
+
+	switch access {
+	case entity.HistoryAccessNone:
+		return &joinedAt
+	case entity.HistoryAccessLastDay:
+		from := joinedAt.Add(-24 * time.Hour)
+		return &from
+	default:
+		return nil
+	}
+}
+
+// visibleSince returns the oldest message timestamp (unix millis) the participant may read, the
+// later of the plan history cutoff and the participant's own history marker
+func (c *chatUsecase) visibleSince(ctx context.Context, chat entity.Chat, participant entity.ChatParticipant) (int64, error) {
+	since, err := c.planUc.HistoryCutoff(ctx, chat.WorkspaceId)
+	if err != nil {
+		return 0, err
+	}
+
+	if participant.HistoryFrom != nil {
+		since = max(since, participant.HistoryFrom.UnixMilli())
+	}
+	return since, nil
+}
+
+// attachDeliveryStates fills the delivery state of the messages as seen by the user. The author of a
+// message gets every receipt and the least advanced state, delivered and read once every recipient
+// was, other participants only their own state
+func (c *chatUsecase) attachDeliveryStates(ctx context.Context, messages []entity.Message, userId string) error {
+	messageIds := make([]string, 0, len(messages))
+	for _, message := range messages {
+		messageIds = append(messageIds, message.Id)
+	}
+
+	receipts, err := c.receiptRepo.GetByMessages(ctx, messageIds)
+	if err != nil {
+		return err
+	}
+
+	receiptsByMessage := make(map[string][]entity.MessageReceipt)
+	for _, receipt := range receipts {
+		receiptsByMessage[receipt.MessageId] = append(receiptsByMessage[receipt.MessageId], receipt)
+	}
+
+	for i := range messages {
+		messageReceipts := receiptsByMessage[messages[i].Id]
+
+		if messages[i].SenderId != userId {
+			for _, receipt := range messageReceipts {
+				if receipt.UserId == userId {
+					messages[i].DeliveryState = receipt.State
+					messages[i].DeliveredAt = receipt.DeliveredAt
+					messages[i].ReadAt = receipt.ReadAt
+				}
+			}
+			continue
+		}
+
+		messages[i].Receipts = messageReceipts
+		for _, receipt := range messageReceipts {
+			if messages[i].DeliveryState == "" || entity.DeliveryStateRank(receipt.State) < entity.DeliveryStateRank(messages[i].DeliveryState) {
+				messages[i].DeliveryState = receipt.State
+			}
+		}
+		messages[i].DeliveredAt = lastReceiptTime(messageReceipts, func(receipt entity.MessageReceipt) *time.Time { return receipt.DeliveredAt })
+		messages[i].ReadAt = lastReceiptTime(messageReceipts, func(receipt entity.MessageReceipt) *time.Time { return receipt.ReadAt })
+	}
+
+	return nil
+}
+
+// lastReceiptTime returns the latest time of the receipts, when every recipient reached it. In a
+// personal chat that is the time of the only recipient, nil while any of them is behind
+func lastReceiptTime(receipts []entity.MessageReceipt, at func(entity.MessageReceipt) *time.Time) *time.Time {
+	var last *time.Time
+	for _, receipt := range receipts {
+		reached := at(receipt)
+		if reached == nil {
+			return nil
+		}
+		if last == nil || reached.After(*last) {
+			last = reached
+		}
+	}
+	return last
+}
+
+// GetThread returns a message together with its replies
+func (c *chatUsecase) GetThread(ctx context.Context, chatId string, messageId string, userId string) (entity.MessageThread, error) {
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return entity.MessageThread{}, err
+	}
+
+	root, err := c.messageRepo.Get(ctx, messageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return entity.MessageThread{}, ErrMessageNotFound
+		}
+		return entity.MessageThread{}, err
+	}
+	if root.ChatId != chatId {
+		return entity.MessageThread{}, ErrMessageNotFound
+	}
+
+	// Only the participant's part of the history is shown, including the root itself
+	if participant.HistoryFrom != nil && root.Timestamp < participant.HistoryFrom.UnixMilli() {
+		return entity.MessageThread{}, ErrMessageNotFound
+	}
+
+	replies, err := c.messageRepo.GetReplies(ctx, messageId)
+	if err != nil {
+		return entity.MessageThread{}, err
+	}
+	if replies == nil {
+		replies = []entity.Message{}
+	}
+	if participant.HistoryFrom != nil {
+		from := participant.HistoryFrom.UnixMilli()
+		replies = slices.DeleteFunc(replies, func(reply entity.Message) bool {
+			return reply.Timestamp < from
+		})
+	}
+
+	return entity.MessageThread{
+		Root:    root,
+		Replies: replies,
+	}, nil
+}
+
+// PinMessage pins a message of the chat for all of its participants, for the duration when it
+// isn't zero
+func (c *chatUsecase) PinMessage(ctx context.Context, chatId string, messageId string, userId string, duration time.Duration) (entity.MessagePin, error) {
+	if duration < 0 || duration > maxPinDuration {
+		return entity.MessagePin{}, invalidField("durationDays", ErrInvalidPinDuration)
+	}
+
+	chat, message, err := c.getPinTarget(ctx, chatId, messageId, userId)
+	if err != nil {
+		return entity.MessagePin{}, err
+	}
+
+	var expiresAt *time.Time
+	if duration > 0 {
+		until := time.Now().Add(duration)
+		expiresAt = &until
+	}
+
+	pinLimit := c.policy.MaxPinnedMessages
+	if chat.PinLimit > 0 && chat.PinLimit < pinLimit {
+		pinLimit = chat.PinLimit
+	}
+
+	pin, err := c.chatRepo.PinMessage(ctx, entity.MessagePin{
+		ChatId:           chat.Id,
+		MessageId:        message.Id,
+		PinnedBy:         userId,
+		MessageTimestamp: message.Timestamp,
+		ExpiresAt:        expiresAt,
+	}, pinLimit)
+	if err != nil {
+		if errors.Is(err, repository.ErrAlreadyPinned) {
+			return entity.MessagePin{}, ErrMessageAlreadyPinned
+		}
+		if errors.Is(err, repository.ErrPinLimitReached) {
+			return entity.MessagePin{}, ErrPinLimit
+		}
+		return entity.MessagePin{}, err
+	}
+	pin.Message = &message
+
+	c.publishToParticipants(ctx, chatId, nil, entity.EventMessagePinned, pin)
+
+	return pin, nil
+}
+
+// UnpinMessage takes a message off the pins of the chat
+func (c *chatUsecase) UnpinMessage(ctx context.Context, chatId string, messageId string, userId string) error {
+	_, _, err := c.getPinTarget(ctx, chatId, messageId, userId)
+	if err != nil {
+		return err
+	}
+
+	err = c.chatRepo.UnpinMessage(ctx, chatId, messageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrPinNotFound) {
+			return ErrPinNotFound
+		}
+		return err
+	}
+
+	c.publishToParticipants(ctx, chatId, nil, entity.EventMessageUnpinned, entity.MessagePin{
+		ChatId:    chatId,
+		MessageId: messageId,
+	})
+
+	return nil
+}
+
+// GetPinnedMessages returns the pins of the chat with their messages. The pins go with their
+// messages, a message deleted since the pins were read is left out
+func (c *chatUsecase) GetPinnedMessages(ctx context.Context, chatId string, userId string) ([]entity.MessagePin, error) {
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	pins, err := c.chatRepo.GetPins(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	messageIds := make([]string, 0, len(pins))
+	for _, pin := range pins {
+		messageIds = append(messageIds, pin.MessageId)
+	}
+	messages, err := c.messageRepo.GetByIds(ctx, messageIds)
+	if err != nil {
+		return nil, err
+	}
+	messagesById := make(map[string]entity.Message, len(messages))
+	for _, message := range messages {
+		messagesById[message.Id] = message
+	}
+
+	visible := make([]entity.MessagePin, 0, len(pins))
+	for _, pin := range pins {
+		message, ok := messagesById[pin.MessageId]
+		if !ok {
+			continue
+		}
+		if participant.HistoryFrom != nil && message.Timestamp < participant.HistoryFrom.UnixMilli() {
+			continue
+		}
+
+		pin.Message = &message
+		visible = append(visible, pin)
+	}
+
+	return visible, nil
+}
+
+// ReorderPins puts the pinned messages of the chat in the order of the message ids, which have to
+// list each of them once
+func (c *chatUsecase) ReorderPins(ctx context.Context, chatId string, userId string, messageIds []string) ([]entity.MessagePin, error) {
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return nil, ErrChatNotFound
+		}
+		return nil, err
+	}
+	// Either participant of a personal chat may order the pins, there is no admin to ask
+	if chat.HasAdmins() && participant.Role != entity.ParticipantRoleAdmin {
+		return nil, ErrNotAdmin
+	}
+
+	pins, err := c.chatRepo.GetPins(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	pinned := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		pinned[pin.MessageId] = true
+	}
+	if len(messageIds) != len(pins) {
+		return nil, invalidField("messageIds", ErrInvalidPinOrder)
+	}
+	for _, messageId := range messageIds {
+		if !pinned[messageId] {
+			return nil, invalidField("messageIds", ErrInvalidPinOrder)
+		}
+		// Listed once, a second time is refused
+		delete(pinned, messageId)
+	}
+
+	if err := c.chatRepo.SetPinOrder(ctx, chatId, messageIds); err != nil {
+		return nil, err
+	}
+
+	c.publishToParticipants(ctx, chatId, nil, entity.EventMessagePinsReordered, entity.MessagePinOrder{
+		ChatId:     chatId,
+		MessageIds: messageIds,
+	})
+
+	return c.GetPinnedMessages(ctx, chatId, userId)
+}
+
+// getPinTarget returns the chat and the message the user pins or unpins. The message has to be
+// in their part of the history, and the members who aren't admins only pin their own messages
+func (c *chatUsecase) getPinTarget(ctx context.Context, chatId string, messageId string, userId string) (entity.Chat, entity.Message, error) {
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return entity.Chat{}, entity.Message{}, err
+	}
+
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrChatNotFound) {
+			return entity.Chat{}, entity.Message{}, ErrChatNotFound
+		}
+		return entity.Chat{}, entity.Message{}, err
+	}
+
+	message, err := c.messageRepo.Get(ctx, messageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return entity.Chat{}, entity.Message{}, ErrMessageNotFound
+		}
+		return entity.Chat{}, entity.Message{}, err
+	}
+	if message.ChatId != chatId {
+		return entity.Chat{}, entity.Message{}, ErrMessageNotFound
+	}
+	if participant.HistoryFrom != nil && message.Timestamp < participant.HistoryFrom.UnixMilli() {
+		return entity.Chat{}, entity.Message{}, ErrMessageNotFound
+	}
+
+	// Either participant of a personal chat may pin, there is no admin to ask
+	if !chat.HasAdmins() || message.SenderId == userId || participant.Role == entity.ParticipantRoleAdmin {
+		return chat, message, nil
+	}
+	return entity.Chat{}, entity.Message{}, ErrCannotPinMessage
+}
+
+// GetMessageContext returns a message with up to around messages sent before and after it,
+// so clients can jump to a message deep in the history
+func (c *chatUsecase) GetMessageContext(ctx context.Context, chatId string, messageId string, userId string, around int) (entity.MessageContext, error) {
+	if around <= 0 {
+		around = defaultContextAround
+	}
+	if around > maxContextAround {
+		around = maxContextAround
+	}
+
+	chat, err := c.getChatForParticipant(ctx, chatId, userId)
+	if err != nil {
+		return entity.MessageContext{}, err
+	}
+	participant, err := c.getParticipant(ctx, chatId, userId)
+	if err != nil {
+		return entity.MessageContext{}, err
+	}
+
+	message, err := c.messageRepo.Get(ctx, messageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotFound) {
+			return entity.MessageContext{}, ErrMessageNotFound
+		}
+		return entity.MessageContext{}, err
+	}
+	if message.ChatId != chatId {
+		return entity.MessageContext{}, ErrMessageNotFound
+	}
+
+	// Messages beyond the visible history are hidden, including the target itself
+	since, err := c.visibleSince(ctx, chat, participant)
+	if err != nil {
+		return entity.MessageContext{}, err
+	}
+	if message.Timestamp < since {
+		return entity.MessageContext{}, ErrMessageNotFound
+	}
+
+	// One extra message on each side tells whether there is more to load
+	before, err := c.messageRepo.GetNeighbours(ctx, message, since, around+1, false)
+	if err != nil {
+		return entity.MessageContext{}, err
+	}
+	after, err := c.messageRepo.GetNeighbours(ctx, message, since, around+1, true)
+	if err != nil {
+		return entity.MessageContext{}, err
+	}
+
+	messageContext := entity.MessageContext{
+		HasMoreBefore: len(before) > around,
+		HasMoreAfter:  len(after) > around,
+	}
+	if messageContext.HasMoreBefore {
+		before = before[:around]
+	}
+	if messageContext.HasMoreAfter {
+		after = after[:around]
+	}
+	slices.Reverse(before)
+
+	messages := make([]entity.Message, 0, len(before)+1+len(after))
+	messages = append(messages, before...)
+	messages = append(messages, message)
+	messages = append(messages, after...)
+	if err := c.attachDeliveryStates(ctx, messages, userId); err != nil {
+		return entity.MessageContext{}, err
+	}
+
+	messageContext.Before = messages[:len(before)]
+	messageContext.Message = messages[len(before)]
+	messageContext.After = messages[len(before)+1:]
+
+	return messageContext, nil
+}
+
+// PurgeEmptyChats deletes group chats that stayed empty longer than the grace period,
+// unless an admin flagged them to be kept. Every purge is recorded in the audit log.
+func (c *chatUsecase) PurgeEmptyChats(ctx context.Context) (int, error) {
+	chats, err := c.chatRepo.GetEmptyChatsBefore(ctx, time.Now().Add(-c.policy.EmptyChatGracePeriod))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, chat := range chats {
+		// Someone may have joined since the chat was marked
+		count, err := c.chatRepo.CountParticipants(ctx, chat.Id)
+		if err != nil {
+			return purged, err
+		}
+		if count > 0 {
+			if err := c.chatRepo.SetEmptySince(ctx, chat.Id, nil); err != nil {
+				return purged, err
+			}
+			continue
+		}
+
+		err = c.chatRepo.Delete(ctx, chat.Id)
+		if err != nil {
+			return purged, err
+		}
+		c.bus.Publish(ctx, entity.EventChatDeleted, chat)
+
+		err = c.auditRepo.Create(ctx, entity.AuditLog{
+			Action: entity.AuditActionEmptyChatPurged,
+			ChatId: chat.Id,
+			Reason: fmt.Sprintf("empty since %s", chat.EmptySince.UTC().Format(time.RFC3339)),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Audit empty chat purge error", "error", err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// PurgeDeletedChats deletes the messages of a chat before the chat, a purge that failed half way
+// goes on with the chat at the next run
+func (c *chatUsecase) PurgeDeletedChats(ctx context.Context) (int64, error) {
+	chats, err := c.chatRepo.GetDeletedBefore(ctx, time.Now().Add(-c.policy.DeletedChatRetention), deletedChatPurgeLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, chat := range chats {
+		for {
+			messageIds, err := c.messageRepo.DeleteMatching(ctx, chat.Id, entity.MessagePurgeFilter{}, deletedChatMessageBatchSize)
+			if err != nil {
+				return purged, err
+			}
+			if len(messageIds) < deletedChatMessageBatchSize {
+				break
+			}
+		}
+
+		if err := c.chatRepo.Purge(ctx, chat.Id); err != nil {
+			return purged, err
+		}
+
+		err = c.auditRepo.Create(ctx, entity.AuditLog{
+			Action: entity.AuditActionDeletedChatPurged,
+			ChatId: chat.Id,
+			Reason: fmt.Sprintf("deleted since %s", chat.DeletedAt.UTC().Format(time.RFC3339)),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Audit deleted chat purge error", "error", err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// ExpireInvitations expires the invitations left unanswered past their expiry, the ones sent
+// without one once they are older than the invitation TTL
+func (c *chatUsecase) ExpireInvitations(ctx context.Context) (int64, error) {
+	now := time.Now()
+	return c.chatRepo.ExpireInvitations(ctx, now, now.Add(-c.policy.InvitationTTL))
+}
+
+// ExpireGuests removes the guests past their access. Each group is told with a member_removed
+// event and a system message, the guest gets the event too
+func (c *chatUsecase) ExpireGuests(ctx context.Context) (int64, error) {
+	guests, err := c.chatRepo.GetExpiredGuests(ctx, time.Now(), expiredGuestBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired int64
+	for _, guest := range guests {
+		if err := c.chatRepo.RemoveParticipant(ctx, guest.UserId, guest.ChatId); err != nil {
+			return expired, err
+		}
+		expired++
+
+		memberRemoved := entity.MembershipEvent{
+			ChatId: guest.ChatId,
+			UserId: guest.UserId,
+			Reason: entity.MembershipReasonGuestExpired,
+		}
+		c.publishToParticipants(ctx, guest.ChatId, []string{guest.UserId}, entity.EventMemberRemoved, memberRemoved)
+		c.bus.Publish(ctx, entity.EventMemberRemoved, memberRemoved)
+
+		c.postSystemMessage(ctx, guest.ChatId, fmt.Sprintf("%s's guest access ended", c.userName(ctx, guest.UserId)))
+
+		if err := c.markEmptyIfNoParticipants(ctx, guest.ChatId); err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// UnpinExpiredMessages removes the pins past their duration, each chat is told with a
+// message_unpinned event
+func (c *chatUsecase) UnpinExpiredMessages(ctx context.Context) (int64, error) {
+	pins, err := c.chatRepo.GetExpiredPins(ctx, time.Now(), expiredPinBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var unpinned int64
+	for _, pin := range pins {
+		err := c.chatRepo.UnpinMessage(ctx, pin.ChatId, pin.MessageId)
+		if errors.Is(err, repository.ErrPinNotFound) {
+			// Unpinned by hand in the meantime
+			continue
+		}
+		if err != nil {
+			return unpinned, err
+		}
+		unpinned++
+
+		c.publishToParticipants(ctx, pin.ChatId, nil, entity.EventMessageUnpinned, entity.MessagePin{
+			ChatId:    pin.ChatId,
+			MessageId: pin.MessageId,
+		})
+	}
+
+	return unpinned, nil
+}
+
+// postSystemMessage stores a system message in the history of the chat and sends it to its
+// participants, failures are only logged since the change it tells about already happened
+func (c *chatUsecase) postSystemMessage(ctx context.Context, chatId string, text string) {
+	message := entity.Message{
+		ChatId:    chatId,
+		SenderId:  entity.SystemSenderId,
+		Type:      entity.MessageTypeSystem,
+		Message:   text,
+		Timestamp: time.Now().UnixMilli(),
+	}
+
+	var err error
+	message, err = c.messageRepo.Create(ctx, message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Create system message error", "chat_id", chatId, "error", err)
+		return
+	}
+
+	c.bus.Publish(ctx, entity.EventMessageCreated, message)
+	c.publishToParticipants(ctx, chatId, nil, entity.EventSystemMessage, message)
+}
+
+// postMembershipMessage posts a system message about the members of a group, the members of a
+// channel come and go without one
+func (c *chatUsecase) postMembershipMessage(ctx context.Context, chat entity.Chat, text string) {
+	if chat.Type == entity.ChatTypeChannel {
+		return
+	}
+	c.postSystemMessage(ctx, chat.Id, text)
+}
+
+// postMetadataMessages posts a system message for each change of the name, description and avatar
+// of a group or a channel
+func (c *chatUsecase) postMetadataMessages(ctx context.Context, before entity.Chat, after entity.Chat, userId string) {
+	noun := "group"
+	if after.Type == entity.ChatTypeChannel {
+		noun = "channel"
+	}
+
+	var texts []string
+	if after.Name != before.Name {
+		texts = append(texts, fmt.Sprintf("renamed the %s to %q", noun, after.Name))
+	}
+	if after.Description != before.Description {
+		texts = append(texts, fmt.Sprintf("changed the %s description", noun))
+	}
+	if after.Avatar != before.Avatar {
+		texts = append(texts, fmt.Sprintf("changed the %s photo", noun))
+	}
+	if len(texts) == 0 {
+		return
+	}
+
+	name := c.userName(ctx, userId)
+	for _, text := range texts {
+		c.postSystemMessage(ctx, after.Id, name+" "+text)
+	}
+}
+
+// userName is how a user is named in a system message, a placeholder when they can't be read
+func (c *chatUsecase) userName(ctx context.Context, userId string) string {
+	user, err := c.userRepo.Get(ctx, userId)
+	if err != nil || user.Name == "" {
+		return "Someone"
+	}
+	return user.Name
+}
+
+func (c *chatUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventUserRegistered, c.onUserRegistered)
+}
+
+// onUserRegistered adds a new user to the default chats of their workspace. A chat that can't be
+// joined is skipped so the others are still joined
+func (c *chatUsecase) onUserRegistered(ctx context.Context, data any) {
+	user, ok := data.(entity.User)
+	if !ok {
+		return
+	}
+
+	for _, chatId := range c.policy.DefaultChatIds {
+		if err := c.joinDefaultChat(ctx, chatId, user); err != nil {
+			slog.ErrorContext(ctx, "Join default chat error", "chat_id", chatId, "user_id", user.Id, "error", err)
+		}
+	}
+}
+
+func (c *chatUsecase) joinDefaultChat(ctx context.Context, chatId string, user entity.User) error {
+	chat, err := c.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return fmt.Errorf("load default chat: %w", err)
+	}
+	if !chat.HasAdmins() {
+		return ErrInvalidChatType
+	}
+	if chat.WorkspaceId != user.GetWorkspaceId() {
+		return nil
+	}
+
+	isParticipant, err := c.chatRepo.IsParticipant(ctx, user.Id, chatId)
+	if err != nil {
+		return err
+	}
+	if isParticipant {
+		return nil
+	}
+	if err := c.checkGroupCapacity(ctx, chat, 1); err != nil {
+		return err
+	}
+
+	participants := []entity.ChatParticipant{
+		{
+			ChatId:      chatId,
+			UserId:      user.Id,
+			Role:        entity.ParticipantRoleMember,
+			HistoryFrom: c.historyFrom(chat, time.Now()),
+		},
+	}
+	if err := c.chatRepo.AddParticipants(ctx, participants); err != nil {
+		return fmt.Errorf("add default chat participant: %w", err)
+	}
+
+	// The chat is no longer empty, cancel any pending purge
+	if err := c.chatRepo.SetEmptySince(ctx, chatId, nil); err != nil {
+		return fmt.Errorf("cancel chat purge: %w", err)
+	}
+
+	memberJoined := entity.MembershipEvent{
+		ChatId: chatId,
+		UserId: user.Id,
+		Role:   entity.ParticipantRoleMember,
+	}
+	c.publishToParticipants(ctx, chatId, nil, entity.EventMemberJoined, memberJoined)
+	c.bus.Publish(ctx, entity.EventMemberJoined, memberJoined)
+	c.postMembershipMessage(ctx, chat, fmt.Sprintf("%s joined", user.Name))
+
+	return nil
+}
+
+// checkGroupCapacity fails if adding members to a group would exceed its plan limit, channels
+// have no limit
+func (c *chatUsecase) checkGroupCapacity(ctx context.Context, chat entity.Chat, additional int) error {
+	if chat.Type == entity.ChatTypeChannel {
+		return nil
+	}
+
+	count, err := c.chatRepo.CountParticipants(ctx, chat.Id)
+	if err != nil {
+		return err
+	}
+
+	return c.planUc.CheckGroupSize(ctx, chat.WorkspaceId, int(count)+additional)
+}
+
+// markEmptyIfNoParticipants starts the grace period of a group chat once its last participant is gone
+func (c *chatUsecase) markEmptyIfNoParticipants(ctx context.Context, chatId string) error {
+	count, err := c.chatRepo.CountParticipants(ctx, chatId)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
 	}
 
-	return c.messageRepo.GetByChatId(ctx, chatId, limit, offset)
+	now := time.Now()
+	return c.chatRepo.SetEmptySince(ctx, chatId, &now)
 }
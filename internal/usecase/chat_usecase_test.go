@@ -0,0 +1,911 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/jwt"
+)
+
+// errNotFaked is returned by the methods of the fakes the tests don't go through
+var errNotFaked = errors.New("not faked")
+
+type testChat struct {
+	usecase   ChatUsecase
+	chatRepo  *fakeChatRepository
+	planUc    *fakePlanUsecase
+	publisher *fakePublisher
+	bus       *fakeBus
+	signer    *fakeInviteLinkSigner
+}
+
+// newTestChat serves a group "chat-1" with an admin and a member, and a user "guest" outside of it
+func newTestChat() *testChat {
+	chatRepo := &fakeChatRepository{
+		chats: map[string]entity.Chat{
+			"chat-1": {Id: "chat-1", Type: entity.ChatTypeGroup, Name: "General", WorkspaceId: "workspace-1"},
+		},
+		participants: []entity.ChatParticipant{
+			{ChatId: "chat-1", UserId: "admin", Role: entity.ParticipantRoleAdmin},
+			{ChatId: "chat-1", UserId: "member", Role: entity.ParticipantRoleMember},
+		},
+		links:        make(map[string]entity.ChatInviteLink),
+		joinRequests: make(map[string]entity.ChatJoinRequest),
+	}
+	userRepo := &fakeUserRepository{users: map[string]entity.User{
+		"admin":  {Id: "admin", Name: "Alice"},
+		"member": {Id: "member", Name: "Bob"},
+		"guest":  {Id: "guest", Name: "Carol"},
+	}}
+
+	test := &testChat{
+		chatRepo:  chatRepo,
+		planUc:    &fakePlanUsecase{},
+		publisher: &fakePublisher{},
+		bus:       &fakeBus{},
+		signer:    &fakeInviteLinkSigner{},
+	}
+	test.usecase = NewChatUsecase(
+		chatRepo,
+		userRepo,
+		&fakeMessageRepository{messages: make(map[string]entity.Message)},
+		&fakeReceiptRepository{},
+		&fakeAuditRepository{},
+		test.planUc,
+		&fakeMessageCache{},
+		test.signer,
+		test.publisher,
+		test.bus,
+		repository.NewNoTransactor(),
+		DefaultChatPolicy(),
+		DefaultProfilePolicy(),
+	)
+	return test
+}
+
+// published returns the users an event of the given type was sent to
+func (c *testChat) published(eventType string) [][]string {
+	var userIds [][]string
+	for _, event := range c.publisher.events {
+		if event.eventType == eventType {
+			userIds = append(userIds, event.userIds)
+		}
+	}
+	return userIds
+}
+
+func (c *testChat) createInviteLink(t *testing.T, req entity.CreateInviteLinkRequest) entity.ChatInviteLink {
+	t.Helper()
+
+	link, err := c.usecase.CreateInviteLink(context.Background(), "chat-1", "admin", req)
+	if err != nil {
+		t.Fatalf("CreateInviteLink: %v", err)
+	}
+	return link
+}
+
+func TestCreateInviteLink(t *testing.T) {
+	c := newTestChat()
+
+	before := time.Now()
+	link := c.createInviteLink(t, entity.CreateInviteLinkRequest{})
+
+	want := before.Add(DefaultChatPolicy().InviteLinkTTL)
+	if link.ExpiresAt.Before(want) || link.ExpiresAt.After(want.Add(time.Minute)) {
+		t.Errorf("ExpiresAt = %v, want about %v", link.ExpiresAt, want)
+	}
+	linkId, err := c.signer.ValidateInviteLinkToken(link.Token)
+	if err != nil {
+		t.Fatalf("ValidateInviteLinkToken: %v", err)
+	}
+	if linkId != link.Id {
+		t.Errorf("token is for link %q, want %q", linkId, link.Id)
+	}
+
+	ctx := context.Background()
+	if _, err := c.usecase.CreateInviteLink(ctx, "chat-1", "member", entity.CreateInviteLinkRequest{}); !errors.Is(err, ErrNotAdmin) {
+		t.Errorf("member creates a link: got %v, want %v", err, ErrNotAdmin)
+	}
+
+	for _, expiresAt := range []time.Time{before.Add(-time.Minute), before.Add(maxInviteLinkTTL + time.Hour)} {
+		_, err := c.usecase.CreateInviteLink(ctx, "chat-1", "admin", entity.CreateInviteLinkRequest{ExpiresAt: &expiresAt})
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidInviteLinkExpiry) {
+			t.Errorf("expiring at %v: got %v, want %v", expiresAt, err, ErrInvalidInviteLinkExpiry)
+		}
+	}
+}
+
+func TestJoinByInviteLink(t *testing.T) {
+	c := newTestChat()
+	link := c.createInviteLink(t, entity.CreateInviteLinkRequest{})
+	ctx := context.Background()
+
+	result, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest")
+	if err != nil {
+		t.Fatalf("JoinByInviteLink: %v", err)
+	}
+	if !result.Joined || result.ChatId != "chat-1" || result.JoinRequest != nil {
+		t.Errorf("result = %+v, want joined chat-1", result)
+	}
+
+	participant, err := c.chatRepo.GetParticipantByUserAndChat(ctx, "guest", "chat-1")
+	if err != nil {
+		t.Fatalf("guest is not a participant: %v", err)
+	}
+	if participant.Role != entity.ParticipantRoleMember {
+		t.Errorf("Role = %q, want %q", participant.Role, entity.ParticipantRoleMember)
+	}
+	if got := c.published(entity.EventMemberJoined); len(got) != 1 {
+		t.Errorf("%s published %d times, want once", entity.EventMemberJoined, len(got))
+	}
+	if got := c.published(entity.EventSystemMessage); len(got) != 1 {
+		t.Errorf("%s published %d times, want once", entity.EventSystemMessage, len(got))
+	}
+
+	if _, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest"); !errors.Is(err, ErrAlreadyParticipant) {
+		t.Errorf("joining twice: got %v, want %v", err, ErrAlreadyParticipant)
+	}
+}
+
+func TestJoinByInviteLinkWithApproval(t *testing.T) {
+	c := newTestChat()
+	link := c.createInviteLink(t, entity.CreateInviteLinkRequest{RequiresApproval: true})
+	ctx := context.Background()
+
+	result, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest")
+	if err != nil {
+		t.Fatalf("JoinByInviteLink: %v", err)
+	}
+	if result.Joined || result.JoinRequest == nil {
+		t.Fatalf("result = %+v, want a join request", result)
+	}
+	request := *result.JoinRequest
+	if request.Status != entity.JoinRequestStatusPending || request.LinkId != link.Id {
+		t.Errorf("join request = %+v, want pending through %s", request, link.Id)
+	}
+	if isParticipant, _ := c.chatRepo.IsParticipant(ctx, "guest", "chat-1"); isParticipant {
+		t.Error("guest joined before an admin approved")
+	}
+	if got := c.published(entity.EventJoinRequestReceived); len(got) != 1 || len(got[0]) != 1 || got[0][0] != "admin" {
+		t.Errorf("%s sent to %v, want the admin", entity.EventJoinRequestReceived, got)
+	}
+
+	again, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest")
+	if err != nil {
+		t.Fatalf("JoinByInviteLink again: %v", err)
+	}
+	if again.JoinRequest == nil || again.JoinRequest.Id != request.Id {
+		t.Errorf("asking again returned %+v, want request %s", again.JoinRequest, request.Id)
+	}
+
+	if err := c.usecase.RespondToJoinRequest(ctx, "chat-1", request.Id, "member", true); !errors.Is(err, ErrNotAdmin) {
+		t.Errorf("member approves: got %v, want %v", err, ErrNotAdmin)
+	}
+	if err := c.usecase.RespondToJoinRequest(ctx, "chat-1", request.Id, "admin", true); err != nil {
+		t.Fatalf("RespondToJoinRequest: %v", err)
+	}
+	if isParticipant, _ := c.chatRepo.IsParticipant(ctx, "guest", "chat-1"); !isParticipant {
+		t.Error("guest did not join once approved")
+	}
+	if err := c.usecase.RespondToJoinRequest(ctx, "chat-1", request.Id, "admin", false); !errors.Is(err, ErrJoinRequestResponded) {
+		t.Errorf("answering twice: got %v, want %v", err, ErrJoinRequestResponded)
+	}
+}
+
+func TestJoinByInviteLinkRefused(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("revoked", func(t *testing.T) {
+		c := newTestChat()
+		link := c.createInviteLink(t, entity.CreateInviteLinkRequest{})
+		if err := c.usecase.RevokeInviteLink(ctx, "chat-1", link.Id, "member"); !errors.Is(err, ErrNotAdmin) {
+			t.Errorf("member revokes: got %v, want %v", err, ErrNotAdmin)
+		}
+		if err := c.usecase.RevokeInviteLink(ctx, "chat-1", link.Id, "admin"); err != nil {
+			t.Fatalf("RevokeInviteLink: %v", err)
+		}
+		if err := c.usecase.RevokeInviteLink(ctx, "chat-1", link.Id, "admin"); !errors.Is(err, ErrInviteLinkNotFound) {
+			t.Errorf("revoking twice: got %v, want %v", err, ErrInviteLinkNotFound)
+		}
+
+		if _, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest"); !errors.Is(err, ErrInviteLinkRevoked) {
+			t.Errorf("got %v, want %v", err, ErrInviteLinkRevoked)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		c := newTestChat()
+		link := c.createInviteLink(t, entity.CreateInviteLinkRequest{})
+		token, _ := c.signer.GenerateInviteLinkToken(link.Id, time.Now().Add(-time.Minute))
+
+		if _, err := c.usecase.JoinByInviteLink(ctx, token, "guest"); !errors.Is(err, ErrInviteLinkExpired) {
+			t.Errorf("got %v, want %v", err, ErrInviteLinkExpired)
+		}
+	})
+
+	t.Run("expired link", func(t *testing.T) {
+		c := newTestChat()
+		link := c.createInviteLink(t, entity.CreateInviteLinkRequest{})
+		link.ExpiresAt = time.Now().Add(-time.Minute)
+		c.chatRepo.links[link.Id] = link
+
+		if _, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest"); !errors.Is(err, ErrInviteLinkExpired) {
+			t.Errorf("got %v, want %v", err, ErrInviteLinkExpired)
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		c := newTestChat()
+		unknown, _ := c.signer.GenerateInviteLinkToken("link-404", time.Now().Add(time.Hour))
+
+		for _, token := range []string{"", "not a token", unknown} {
+			if _, err := c.usecase.JoinByInviteLink(ctx, token, "guest"); !errors.Is(err, ErrInvalidInviteLink) {
+				t.Errorf("token %q: got %v, want %v", token, err, ErrInvalidInviteLink)
+			}
+		}
+	})
+
+	t.Run("group full", func(t *testing.T) {
+		c := newTestChat()
+		c.planUc.maxGroupSize = 2
+		link := c.createInviteLink(t, entity.CreateInviteLinkRequest{})
+
+		if _, err := c.usecase.JoinByInviteLink(ctx, link.Token, "guest"); !errors.Is(err, ErrGroupSizeLimit) {
+			t.Errorf("got %v, want %v", err, ErrGroupSizeLimit)
+		}
+		if isParticipant, _ := c.chatRepo.IsParticipant(ctx, "guest", "chat-1"); isParticipant {
+			t.Error("guest joined a full group")
+		}
+	})
+}
+
+// fakeChatRepository keeps chats, participants, invite links and join requests in memory, the
+// other methods fail with errNotFaked
+type fakeChatRepository struct {
+	chats        map[string]entity.Chat
+	participants []entity.ChatParticipant
+	links        map[string]entity.ChatInviteLink
+	joinRequests map[string]entity.ChatJoinRequest
+	lastId       int
+}
+
+func (f *fakeChatRepository) newId(prefix string) string {
+	f.lastId++
+	return fmt.Sprintf("%s-%d", prefix, f.lastId)
+}
+
+func (f *fakeChatRepository) Index(ctx context.Context, userId string) ([]entity.Chat, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) Get(ctx context.Context, chatId string) (entity.Chat, error) {
+	chat, ok := f.chats[chatId]
+	if !ok {
+		return entity.Chat{}, repository.ErrChatNotFound
+	}
+	return chat, nil
+}
+
+func (f *fakeChatRepository) Create(ctx context.Context, chat entity.Chat) (string, error) {
+	return "", errNotFaked
+}
+
+func (f *fakeChatRepository) Update(ctx context.Context, chat entity.Chat) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) Delete(ctx context.Context, chatId string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetDeleted(ctx context.Context, chatId string) (entity.Chat, error) {
+	return entity.Chat{}, errNotFaked
+}
+
+func (f *fakeChatRepository) Restore(ctx context.Context, chatId string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetDeletedBefore(ctx context.Context, before time.Time, limit int64) ([]entity.Chat, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) Purge(ctx context.Context, chatId string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) SetEmptySince(ctx context.Context, chatId string, emptySince *time.Time) error {
+	chat, ok := f.chats[chatId]
+	if !ok {
+		return repository.ErrChatNotFound
+	}
+	chat.EmptySince = emptySince
+	f.chats[chatId] = chat
+	return nil
+}
+
+func (f *fakeChatRepository) SetMessageTTL(ctx context.Context, chatId string, messageTTL int64) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) SetPinLimit(ctx context.Context, chatId string, pinLimit int) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetEmptyChatsBefore(ctx context.Context, before time.Time) ([]entity.Chat, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) GetIdsByWorkspace(ctx context.Context, workspaceId string) ([]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) AddParticipants(ctx context.Context, chatParticipants []entity.ChatParticipant) error {
+	f.participants = append(f.participants, chatParticipants...)
+	return nil
+}
+
+func (f *fakeChatRepository) GetParticipants(ctx context.Context, chatId string) ([]entity.ChatParticipant, error) {
+	var participants []entity.ChatParticipant
+	for _, participant := range f.participants {
+		if participant.ChatId == chatId {
+			participants = append(participants, participant)
+		}
+	}
+	return participants, nil
+}
+
+func (f *fakeChatRepository) GetParticipantByUserAndChat(ctx context.Context, userId, chatId string) (entity.ChatParticipant, error) {
+	for _, participant := range f.participants {
+		if participant.UserId == userId && participant.ChatId == chatId {
+			return participant, nil
+		}
+	}
+	return entity.ChatParticipant{}, repository.ErrNotParticipant
+}
+
+func (f *fakeChatRepository) IsParticipant(ctx context.Context, userId, chatId string) (bool, error) {
+	_, err := f.GetParticipantByUserAndChat(ctx, userId, chatId)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f *fakeChatRepository) IsAdmin(ctx context.Context, userId, chatId string) (bool, error) {
+	participant, err := f.GetParticipantByUserAndChat(ctx, userId, chatId)
+	if errors.Is(err, repository.ErrNotParticipant) {
+		return false, nil
+	}
+	return participant.Role == entity.ParticipantRoleAdmin, err
+}
+
+func (f *fakeChatRepository) RemoveParticipant(ctx context.Context, userId, chatId string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) UpdateParticipantRole(ctx context.Context, userId, chatId, role string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) CountAdmins(ctx context.Context, chatId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeChatRepository) CountParticipants(ctx context.Context, chatId string) (int64, error) {
+	participants, err := f.GetParticipants(ctx, chatId)
+	return int64(len(participants)), err
+}
+
+func (f *fakeChatRepository) GetParticipantIdsByChats(ctx context.Context, chatIds []string) (map[string][]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) GetMembershipChanges(ctx context.Context, chatId string, sinceVersion int64) ([]entity.MembershipChange, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) GetParticipationsByUser(ctx context.Context, userId string) ([]entity.ChatParticipant, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) GetExpiredGuests(ctx context.Context, before time.Time, limit int64) ([]entity.ChatParticipant, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) SetParticipantMuted(ctx context.Context, userId, chatId string, muted bool, mutedUntil *time.Time) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) SetParticipantPinned(ctx context.Context, userId, chatId string, pinnedAt *time.Time) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) SetParticipantArchived(ctx context.Context, userId, chatId string, archivedAt *time.Time) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) SetParticipantNotificationMode(ctx context.Context, userId, chatId, mode string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) SetThreadMuted(ctx context.Context, userId, chatId, messageId string, muted bool) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) MoveParticipations(ctx context.Context, fromUserId, toUserId string) (moved []string, closed []string, err error) {
+	return nil, nil, errNotFaked
+}
+
+func (f *fakeChatRepository) RestoreParticipations(ctx context.Context, fromUserId, toUserId string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetPersonalChatBetweenUsers(ctx context.Context, userId1, userId2 string) (entity.Chat, error) {
+	return entity.Chat{}, errNotFaked
+}
+
+func (f *fakeChatRepository) CreateInvitation(ctx context.Context, invitation entity.ChatInvitation) (string, error) {
+	return "", errNotFaked
+}
+
+func (f *fakeChatRepository) GetInvitation(ctx context.Context, invitationId string) (entity.ChatInvitation, error) {
+	return entity.ChatInvitation{}, errNotFaked
+}
+
+func (f *fakeChatRepository) GetPendingInvitations(ctx context.Context, userId string) ([]entity.ChatInvitation, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) GetPendingInvitationsByChat(ctx context.Context, chatId string) ([]entity.ChatInvitation, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) UpdateInvitationStatus(ctx context.Context, invitationId, status string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetInvitationByUserAndChat(ctx context.Context, userId, chatId string) (entity.ChatInvitation, error) {
+	return entity.ChatInvitation{}, errNotFaked
+}
+
+func (f *fakeChatRepository) ExpireInvitations(ctx context.Context, now time.Time, createdBefore time.Time) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeChatRepository) CreateInviteLink(ctx context.Context, link entity.ChatInviteLink) (entity.ChatInviteLink, error) {
+	link.Id = f.newId("link")
+	link.CreatedAt = time.Now()
+	f.links[link.Id] = link
+	return link, nil
+}
+
+func (f *fakeChatRepository) GetInviteLink(ctx context.Context, linkId string) (entity.ChatInviteLink, error) {
+	link, ok := f.links[linkId]
+	if !ok {
+		return entity.ChatInviteLink{}, repository.ErrInviteLinkNotFound
+	}
+	return link, nil
+}
+
+func (f *fakeChatRepository) GetActiveInviteLinks(ctx context.Context, chatId string) ([]entity.ChatInviteLink, error) {
+	var links []entity.ChatInviteLink
+	for _, link := range f.links {
+		if link.ChatId == chatId && link.IsUsableAt(time.Now()) {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (f *fakeChatRepository) RevokeInviteLink(ctx context.Context, chatId, linkId string) error {
+	link, ok := f.links[linkId]
+	if !ok || link.ChatId != chatId || link.RevokedAt != nil {
+		return repository.ErrInviteLinkNotFound
+	}
+	now := time.Now()
+	link.RevokedAt = &now
+	f.links[linkId] = link
+	return nil
+}
+
+func (f *fakeChatRepository) CreateJoinRequest(ctx context.Context, request entity.ChatJoinRequest) (entity.ChatJoinRequest, error) {
+	request.Id = f.newId("request")
+	request.Status = entity.JoinRequestStatusPending
+	request.CreatedAt = time.Now()
+	f.joinRequests[request.Id] = request
+	return request, nil
+}
+
+func (f *fakeChatRepository) GetJoinRequest(ctx context.Context, requestId string) (entity.ChatJoinRequest, error) {
+	request, ok := f.joinRequests[requestId]
+	if !ok {
+		return entity.ChatJoinRequest{}, repository.ErrJoinRequestNotFound
+	}
+	return request, nil
+}
+
+func (f *fakeChatRepository) GetPendingJoinRequest(ctx context.Context, userId, chatId string) (entity.ChatJoinRequest, error) {
+	for _, request := range f.joinRequests {
+		if request.UserId == userId && request.ChatId == chatId && request.Status == entity.JoinRequestStatusPending {
+			return request, nil
+		}
+	}
+	return entity.ChatJoinRequest{}, repository.ErrJoinRequestNotFound
+}
+
+func (f *fakeChatRepository) GetPendingJoinRequestsByChat(ctx context.Context, chatId string) ([]entity.ChatJoinRequest, error) {
+	var requests []entity.ChatJoinRequest
+	for _, request := range f.joinRequests {
+		if request.ChatId == chatId && request.Status == entity.JoinRequestStatusPending {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
+func (f *fakeChatRepository) UpdateJoinRequestStatus(ctx context.Context, requestId, status, respondedBy string) error {
+	request, ok := f.joinRequests[requestId]
+	if !ok || request.Status != entity.JoinRequestStatusPending {
+		return repository.ErrJoinRequestNotFound
+	}
+	now := time.Now()
+	request.Status = status
+	request.RespondedAt = &now
+	request.RespondedBy = respondedBy
+	f.joinRequests[requestId] = request
+	return nil
+}
+
+func (f *fakeChatRepository) PinMessage(ctx context.Context, pin entity.MessagePin, limit int) (entity.MessagePin, error) {
+	return entity.MessagePin{}, errNotFaked
+}
+
+func (f *fakeChatRepository) UnpinMessage(ctx context.Context, chatId, messageId string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) UnpinMessages(ctx context.Context, chatId string, messageIds []string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) UnpinOlderThan(ctx context.Context, chatIds []string, timestamp int64) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetPins(ctx context.Context, chatId string) ([]entity.MessagePin, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) SetPinOrder(ctx context.Context, chatId string, messageIds []string) error {
+	return errNotFaked
+}
+
+func (f *fakeChatRepository) GetExpiredPins(ctx context.Context, before time.Time, limit int64) ([]entity.MessagePin, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeChatRepository) Indexes() []repository.CollectionIndexes {
+	return nil
+}
+
+type fakeUserRepository struct {
+	users map[string]entity.User
+}
+
+func (f *fakeUserRepository) Index(ctx context.Context, filter entity.UserIndexFilter) ([]entity.User, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeUserRepository) Get(ctx context.Context, userId string) (entity.User, error) {
+	user, ok := f.users[userId]
+	if !ok {
+		return entity.User{}, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUserRepository) GetByEmail(ctx context.Context, email string) (entity.User, error) {
+	return entity.User{}, errNotFaked
+}
+
+func (f *fakeUserRepository) GetByUsername(ctx context.Context, username string) (entity.User, error) {
+	return entity.User{}, errNotFaked
+}
+
+func (f *fakeUserRepository) Create(ctx context.Context, user entity.User) (string, error) {
+	return "", errNotFaked
+}
+
+func (f *fakeUserRepository) Update(ctx context.Context, user entity.User) error {
+	return errNotFaked
+}
+
+func (f *fakeUserRepository) UpdateProfile(ctx context.Context, user entity.User) error {
+	return errNotFaked
+}
+
+func (f *fakeUserRepository) UpdatePassword(ctx context.Context, userId string, password string) error {
+	return errNotFaked
+}
+
+func (f *fakeUserRepository) Search(ctx context.Context, filter entity.AdminUserFilter) ([]entity.User, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeUserRepository) SetDeactivated(ctx context.Context, userId string, deactivatedAt *time.Time) error {
+	return errNotFaked
+}
+
+func (f *fakeUserRepository) SetMergedInto(ctx context.Context, userId string, mergedInto string) error {
+	return errNotFaked
+}
+
+func (f *fakeUserRepository) GetOnlineUser(ctx context.Context, userIds []string) ([]entity.User, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	return false, errNotFaked
+}
+
+func (f *fakeUserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	return false, errNotFaked
+}
+
+func (f *fakeUserRepository) Indexes() []repository.CollectionIndexes {
+	return nil
+}
+
+// fakeMessageRepository keeps the messages created by the usecase, the system messages among them
+type fakeMessageRepository struct {
+	messages map[string]entity.Message
+}
+
+func (f *fakeMessageRepository) Index(ctx context.Context, filter entity.MessageIndexFilter) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) Get(ctx context.Context, messageId string) (entity.Message, error) {
+	message, ok := f.messages[messageId]
+	if !ok {
+		return entity.Message{}, repository.ErrMessageNotFound
+	}
+	return message, nil
+}
+
+func (f *fakeMessageRepository) GetByIds(ctx context.Context, messageIds []string) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) GetByClientMessageId(ctx context.Context, chatId string, senderId string, clientMessageId string) (entity.Message, error) {
+	return entity.Message{}, errNotFaked
+}
+
+func (f *fakeMessageRepository) Create(ctx context.Context, message entity.Message) (entity.Message, error) {
+	message.Id = fmt.Sprintf("message-%d", len(f.messages)+1)
+	f.messages[message.Id] = message
+	return message, nil
+}
+
+func (f *fakeMessageRepository) Update(ctx context.Context, message entity.Message) error {
+	return errNotFaked
+}
+
+func (f *fakeMessageRepository) Delete(ctx context.Context, messageId string) error {
+	return errNotFaked
+}
+
+func (f *fakeMessageRepository) SetLinkPreview(ctx context.Context, messageId string, preview entity.LinkPreview) error {
+	return errNotFaked
+}
+
+func (f *fakeMessageRepository) GetByChatId(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) DeleteOlderThan(ctx context.Context, chatIds []string, timestamp int64) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) GetReplies(ctx context.Context, messageId string) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) GetNeighbours(ctx context.Context, message entity.Message, since int64, limit int, newer bool) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) GetStorageByWorkspace(ctx context.Context) ([]entity.WorkspaceStorage, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) Search(ctx context.Context, filter entity.MessageSearchFilter) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) CountByChat(ctx context.Context, chatId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) CountBySenderSince(ctx context.Context, senderId string, since int64) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) GetStorageBySender(ctx context.Context, senderId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) ([]entity.Message, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) CountMatching(ctx context.Context, chatId string, filter entity.MessagePurgeFilter) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) DeleteMatching(ctx context.Context, chatId string, filter entity.MessagePurgeFilter, limit int) ([]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeMessageRepository) MoveSender(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) RestoreSender(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeMessageRepository) Indexes() []repository.CollectionIndexes {
+	return nil
+}
+
+type fakeReceiptRepository struct{}
+
+func (f *fakeReceiptRepository) CreateSent(ctx context.Context, message entity.Message, recipientIds []string, quietIds []string) error {
+	return errNotFaked
+}
+
+func (f *fakeReceiptRepository) MarkDelivered(ctx context.Context, messageId string, userId string) (entity.MessageReceipt, bool, error) {
+	return entity.MessageReceipt{}, false, errNotFaked
+}
+
+func (f *fakeReceiptRepository) MarkRead(ctx context.Context, messageId string, userId string) (entity.MessageReceipt, bool, error) {
+	return entity.MessageReceipt{}, false, errNotFaked
+}
+
+func (f *fakeReceiptRepository) GetByMessages(ctx context.Context, messageIds []string) ([]entity.MessageReceipt, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeReceiptRepository) MarkChatRead(ctx context.Context, chatId string, userId string, upTo int64) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeReceiptRepository) CountUnread(ctx context.Context) ([]entity.UnreadCount, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakeReceiptRepository) CountUnreadInChat(ctx context.Context, userId string, chatId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeReceiptRepository) MoveRecipient(ctx context.Context, fromUserId string, toUserId string, chatIds []string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeReceiptRepository) RestoreRecipient(ctx context.Context, fromUserId string, toUserId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+func (f *fakeReceiptRepository) Indexes() []repository.CollectionIndexes {
+	return nil
+}
+
+type fakeAuditRepository struct{}
+
+func (f *fakeAuditRepository) Create(ctx context.Context, auditLog entity.AuditLog) error {
+	return errNotFaked
+}
+
+func (f *fakeAuditRepository) GetByChatId(ctx context.Context, chatId string) ([]entity.AuditLog, error) {
+	return nil, errNotFaked
+}
+
+// fakePlanUsecase allows groups of up to maxGroupSize participants, any size when it is zero
+type fakePlanUsecase struct {
+	maxGroupSize int
+}
+
+func (f *fakePlanUsecase) GetWorkspacePlan(ctx context.Context, workspaceId string) (entity.WorkspacePlan, error) {
+	return entity.WorkspacePlan{}, errNotFaked
+}
+
+func (f *fakePlanUsecase) GetUserWorkspacePlan(ctx context.Context, userId string) (entity.WorkspacePlan, error) {
+	return entity.WorkspacePlan{}, errNotFaked
+}
+
+func (f *fakePlanUsecase) AssignPlan(ctx context.Context, adminId string, workspaceId string, plan string) (entity.WorkspacePlan, error) {
+	return entity.WorkspacePlan{}, errNotFaked
+}
+
+func (f *fakePlanUsecase) RecordUsage(ctx context.Context, workspaceId string, metric string, delta int64) error {
+	return errNotFaked
+}
+
+func (f *fakePlanUsecase) CheckGroupSize(ctx context.Context, workspaceId string, size int) error {
+	if f.maxGroupSize > 0 && size > f.maxGroupSize {
+		return ErrGroupSizeLimit
+	}
+	return nil
+}
+
+func (f *fakePlanUsecase) CheckAttachmentSize(ctx context.Context, workspaceId string, size int64) error {
+	return errNotFaked
+}
+
+func (f *fakePlanUsecase) HistoryCutoff(ctx context.Context, workspaceId string) (int64, error) {
+	return 0, errNotFaked
+}
+
+type fakeMessageCache struct{}
+
+func (f *fakeMessageCache) Latest(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, bool, error) {
+	return nil, false, errNotFaked
+}
+
+func (f *fakeMessageCache) Subscribe(bus EventBus) {
+}
+
+// fakeInviteLinkSigner hands out tokens made of the link id and the expiry, unsigned
+type fakeInviteLinkSigner struct{}
+
+func (f *fakeInviteLinkSigner) GenerateInviteLinkToken(linkId string, expiresAt time.Time) (string, error) {
+	return fmt.Sprintf("%s.%d", linkId, expiresAt.UnixMilli()), nil
+}
+
+func (f *fakeInviteLinkSigner) ValidateInviteLinkToken(token string) (string, error) {
+	linkId, expiresAt, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", jwt.ErrInvalidToken
+	}
+	expiresAtMilli, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil {
+		return "", jwt.ErrInvalidToken
+	}
+	if time.Now().UnixMilli() >= expiresAtMilli {
+		return "", jwt.ErrExpiredToken
+	}
+	return linkId, nil
+}
+
+type publishedEvent struct {
+	userIds   []string
+	eventType string
+}
+
+type fakePublisher struct {
+	events []publishedEvent
+}
+
+func (f *fakePublisher) PublishToUsers(ctx context.Context, userIds []string, eventType string, data any) {
+	f.events = append(f.events, publishedEvent{userIds: userIds, eventType: eventType})
+}
+
+type fakeBus struct {
+	eventTypes []string
+}
+
+func (f *fakeBus) Subscribe(eventType string, handler EventHandler) {
+}
+
+func (f *fakeBus) Publish(ctx context.Context, eventType string, data any) {
+	f.eventTypes = append(f.eventTypes, eventType)
+}
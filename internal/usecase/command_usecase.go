@@ -0,0 +1,244 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+)
+
+// ErrCommandHandled is returned instead of a stored message when the text was a command that
+// only answered its issuer
+var ErrCommandHandled = errors.New("the message was a command and was not stored")
+
+const (
+	maxPollOptions = 10
+	// maxCommandMuteDuration keeps typos such as "/mute 1000d" from muting a chat for years
+	maxCommandMuteDuration = 365 * 24 * time.Hour
+)
+
+// CommandHandler runs a slash command. Errors of the usecases are shown to the issuer when
+// they are meant for users, usage problems should be answered with a Reply instead
+type CommandHandler func(ctx context.Context, call entity.CommandCall) (entity.CommandResult, error)
+
+// CommandUsecase runs the slash commands typed in chats before the message is stored.
+// Texts starting with an unregistered command, or with "//", are regular messages
+type CommandUsecase interface {
+	// Register adds a command, usage is shown by /help
+	Register(name string, usage string, handler CommandHandler)
+	// Execute runs the command of a message, if any. It returns the message to store, which is
+	// the given one when it isn't a command, or ErrCommandHandled when there is nothing to store
+	Execute(ctx context.Context, message entity.Message) (entity.Message, error)
+}
+
+type command struct {
+	usage   string
+	handler CommandHandler
+}
+
+type commandUsecase struct {
+	chatUc      ChatUsecase
+	ephemeralUc EphemeralUsecase
+
+	mu       sync.RWMutex
+	commands map[string]command
+}
+
+// NewCommandUsecase returns the command usecase with the built-in commands registered
+func NewCommandUsecase(chatUc ChatUsecase, ephemeralUc EphemeralUsecase) CommandUsecase {
+	c := &commandUsecase{
+		chatUc:      chatUc,
+		ephemeralUc: ephemeralUc,
+		commands:    make(map[string]command),
+	}
+
+	c.Register("help", "/help - List the available commands", c.help)
+	c.Register("leave", "/leave - Leave this group", c.leave)
+	c.Register("mute", "/mute [duration] - Mute this chat, for a duration such as 30m, 8h or 2d", c.mute)
+	c.Register("unmute", "/unmute - Unmute this chat", c.unmute)
+	c.Register("poll", "/poll question | option | option... - Ask the chat a question", c.poll)
+
+	return c
+}
+
+func (c *commandUsecase) Register(name string, usage string, handler CommandHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commands[strings.ToLower(name)] = command{
+		usage:   usage,
+		handler: handler,
+	}
+}
+
+func (c *commandUsecase) Execute(ctx context.Context, message entity.Message) (entity.Message, error) {
+	call, ok := parseCommand(message)
+	if !ok {
+		return message, nil
+	}
+
+	c.mu.RLock()
+	cmd, ok := c.commands[call.Name]
+	c.mu.RUnlock()
+	if !ok {
+		return message, nil
+	}
+
+	kind := entity.EphemeralKindCommandResult
+	result, err := cmd.handler(ctx, call)
+	if err != nil {
+		kind = entity.EphemeralKindError
+		result = entity.CommandResult{Reply: commandErrorReply(ctx, call, err)}
+	}
+
+	if result.Reply != "" {
+		c.ephemeralUc.Send(ctx, call.UserId, entity.EphemeralMessage{
+			ChatId:  call.ChatId,
+			Kind:    kind,
+			Command: call.Name,
+			Message: result.Reply,
+		})
+	}
+
+	if result.Message == "" {
+		return entity.Message{}, ErrCommandHandled
+	}
+	message.Message = result.Message
+	return message, nil
+}
+
+// parseCommand splits "/name args" messages, messages with attachments are never commands
+func parseCommand(message entity.Message) (entity.CommandCall, bool) {
+	text := strings.TrimSpace(message.Message)
+	if len(message.Attachments) > 0 || !strings.HasPrefix(text, "/") || strings.HasPrefix(text, "//") {
+		return entity.CommandCall{}, false
+	}
+
+	name, args, _ := strings.Cut(text[1:], " ")
+	if name == "" {
+		return entity.CommandCall{}, false
+	}
+
+	return entity.CommandCall{
+		ChatId: message.ChatId,
+		UserId: message.SenderId,
+		Name:   strings.ToLower(name),
+		Args:   strings.TrimSpace(args),
+	}, true
+}
+
+// commandErrorReply shows the errors meant for users as they are and hides the others
+func commandErrorReply(ctx context.Context, call entity.CommandCall, err error) string {
+	if IsAny(err, ErrNotParticipant, ErrNotAdmin, ErrChatNotFound, ErrInvalidMuteDuration) {
+		return err.Error()
+	}
+
+	slog.ErrorContext(ctx, "Command error", "command", call.Name, "chat_id", call.ChatId, "error", err)
+	return fmt.Sprintf("/%s failed, please try again", call.Name)
+}
+
+func (c *commandUsecase) help(ctx context.Context, call entity.CommandCall) (entity.CommandResult, error) {
+	c.mu.RLock()
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, c.commands[name].usage)
+	}
+	c.mu.RUnlock()
+
+	return entity.CommandResult{Reply: strings.Join(lines, "\n")}, nil
+}
+
+func (c *commandUsecase) leave(ctx context.Context, call entity.CommandCall) (entity.CommandResult, error) {
+	detail, err := c.chatUc.Get(ctx, call.ChatId, call.UserId)
+	if err != nil {
+		return entity.CommandResult{}, err
+	}
+	if !detail.Chat.HasAdmins() {
+		return entity.CommandResult{Reply: "Only groups and channels can be left"}, nil
+	}
+
+	if err := c.chatUc.LeaveGroup(ctx, call.ChatId, call.UserId); err != nil {
+		return entity.CommandResult{}, err
+	}
+	return entity.CommandResult{Reply: "You left the " + string(detail.Chat.Type)}, nil
+}
+
+func (c *commandUsecase) mute(ctx context.Context, call entity.CommandCall) (entity.CommandResult, error) {
+	var duration time.Duration
+	if call.Args != "" {
+		parsed, ok := parseCommandDuration(call.Args)
+		if !ok || parsed <= 0 || parsed > maxCommandMuteDuration {
+			return entity.CommandResult{Reply: "Usage: /mute [duration], such as /mute 30m, /mute 8h or /mute 2d"}, nil
+		}
+		duration = parsed
+	}
+
+	if err := c.chatUc.MuteChat(ctx, call.ChatId, call.UserId, duration); err != nil {
+		return entity.CommandResult{}, err
+	}
+
+	if duration == 0 {
+		return entity.CommandResult{Reply: "Notifications of this chat are muted until you unmute it"}, nil
+	}
+	return entity.CommandResult{Reply: fmt.Sprintf("Notifications of this chat are muted for %s", call.Args)}, nil
+}
+
+func (c *commandUsecase) unmute(ctx context.Context, call entity.CommandCall) (entity.CommandResult, error) {
+	if err := c.chatUc.UnmuteChat(ctx, call.ChatId, call.UserId); err != nil {
+		return entity.CommandResult{}, err
+	}
+	return entity.CommandResult{Reply: "Notifications of this chat are back on"}, nil
+}
+
+// poll posts the question with numbered options, members answer with the number of their choice
+func (c *commandUsecase) poll(ctx context.Context, call entity.CommandCall) (entity.CommandResult, error) {
+	parts := strings.Split(call.Args, "|")
+
+	question := strings.TrimSpace(parts[0])
+	options := make([]string, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		if option := strings.TrimSpace(part); option != "" {
+			options = append(options, option)
+		}
+	}
+
+	if question == "" || len(options) < 2 || len(options) > maxPollOptions {
+		return entity.CommandResult{Reply: fmt.Sprintf("Usage: /poll question | option | option, with 2 to %d options", maxPollOptions)}, nil
+	}
+
+	lines := []string{"Poll: " + question}
+	for i, option := range options {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, option))
+	}
+	return entity.CommandResult{Message: strings.Join(lines, "\n")}, nil
+}
+
+// parseCommandDuration reads Go durations such as 90m or 1h30m, and whole days such as 2d
+func parseCommandDuration(value string) (time.Duration, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(count) * 24 * time.Hour, true
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return duration, true
+}
@@ -0,0 +1,126 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrInvalidDocumentType = errors.New("invalid legal document type")
+	ErrOutdatedVersion     = errors.New("only the latest document version can be accepted")
+	ErrDocumentNotFound    = errors.New("legal document not found")
+)
+
+var legalDocumentTypes = []string{entity.LegalDocumentTerms, entity.LegalDocumentPrivacy}
+
+type ConsentUsecase interface {
+	GetLatestDocuments(ctx context.Context) ([]entity.LegalDocument, error)
+	GetPendingDocuments(ctx context.Context, userId string) ([]entity.LegalDocument, error)
+	Accept(ctx context.Context, userId string, req entity.AcceptDocumentRequest, ipAddress string) error
+	PublishDocument(ctx context.Context, req entity.PublishDocumentRequest) (entity.LegalDocument, error)
+}
+
+type consentUsecase struct {
+	consentRepo repository.ConsentRepository
+}
+
+func NewConsentUsecase(consentRepo repository.ConsentRepository) ConsentUsecase {
+	return &consentUsecase{
+		consentRepo: consentRepo,
+	}
+}
+
+// GetLatestDocuments returns the current version of every published legal document
+func (c *consentUsecase) GetLatestDocuments(ctx context.Context) ([]entity.LegalDocument, error) {
+	documents := []entity.LegalDocument{}
+	for _, documentType := range legalDocumentTypes {
+		document, err := c.consentRepo.GetLatestDocument(ctx, documentType)
+		if err != nil {
+			if errors.Is(err, repository.ErrDocumentNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	return documents, nil
+}
+
+// GetPendingDocuments returns the latest documents the user hasn't accepted yet
+func (c *consentUsecase) GetPendingDocuments(ctx context.Context, userId string) ([]entity.LegalDocument, error) {
+	documents, err := c.GetLatestDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []entity.LegalDocument{}
+	for _, document := range documents {
+		accepted, err := c.consentRepo.HasAccepted(ctx, userId, document.Type, document.Version)
+		if err != nil {
+			return nil, err
+		}
+		if !accepted {
+			pending = append(pending, document)
+		}
+	}
+
+	return pending, nil
+}
+
+// Accept records the user's consent to the latest version of a document
+func (c *consentUsecase) Accept(ctx context.Context, userId string, req entity.AcceptDocumentRequest, ipAddress string) error {
+	if !slices.Contains(legalDocumentTypes, req.Type) {
+		return invalidField("type", ErrInvalidDocumentType)
+	}
+
+	latest, err := c.consentRepo.GetLatestDocument(ctx, req.Type)
+	if err != nil {
+		if errors.Is(err, repository.ErrDocumentNotFound) {
+			return ErrDocumentNotFound
+		}
+		return err
+	}
+	if latest.Version != req.Version {
+		return ErrOutdatedVersion
+	}
+
+	accepted, err := c.consentRepo.HasAccepted(ctx, userId, req.Type, req.Version)
+	if err != nil {
+		return err
+	}
+	if accepted {
+		return nil
+	}
+
+	return c.consentRepo.CreateConsent(ctx, entity.ConsentRecord{
+		UserId:       userId,
+		DocumentType: req.Type,
+		Version:      req.Version,
+		IpAddress:    ipAddress,
+	})
+}
+
+// PublishDocument makes a new document version current, every user has to accept it again
+func (c *consentUsecase) PublishDocument(ctx context.Context, req entity.PublishDocumentRequest) (entity.LegalDocument, error) {
+	if !slices.Contains(legalDocumentTypes, req.Type) {
+		return entity.LegalDocument{}, invalidField("type", ErrInvalidDocumentType)
+	}
+
+	document := entity.LegalDocument{
+		Type:    req.Type,
+		Version: req.Version,
+		Url:     req.Url,
+		Content: req.Content,
+	}
+
+	_, err := c.consentRepo.CreateDocument(ctx, document)
+	if err != nil {
+		return entity.LegalDocument{}, err
+	}
+
+	return c.consentRepo.GetLatestDocument(ctx, req.Type)
+}
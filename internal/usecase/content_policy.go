@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"errors"
+	"strings"
+	"wetalk/internal/entity"
+)
+
+var (
+	ErrMessageRejected        = errors.New("message contains blocked content")
+	ErrAttachmentTooLarge     = errors.New("attachment exceeds the maximum size")
+	ErrAttachmentTypeRejected = errors.New("attachment type is not allowed")
+)
+
+// ContentPolicy enforces the attachment rules configured for a workspace, the text of the
+// messages goes through the ModerationUsecase
+type ContentPolicy interface {
+	CheckAttachment(settings entity.WorkspaceSettings, attachment entity.Attachment) error
+}
+
+type workspaceContentPolicy struct{}
+
+// NewWorkspaceContentPolicy returns the default ContentPolicy that checks the size and the type
+// of the attachments
+func NewWorkspaceContentPolicy() ContentPolicy {
+	return &workspaceContentPolicy{}
+}
+
+func (p *workspaceContentPolicy) CheckAttachment(settings entity.WorkspaceSettings, attachment entity.Attachment) error {
+	if settings.Attachments.MaxSizeBytes > 0 && attachment.Size > settings.Attachments.MaxSizeBytes {
+		return ErrAttachmentTooLarge
+	}
+
+	if len(settings.Attachments.AllowedMimeTypes) == 0 {
+		return nil
+	}
+
+	for _, mimeType := range settings.Attachments.AllowedMimeTypes {
+		// Support wildcards such as "image/*"
+		if prefix, ok := strings.CutSuffix(mimeType, "/*"); ok {
+			if strings.HasPrefix(attachment.MimeType, prefix+"/") {
+				return nil
+			}
+			continue
+		}
+		if strings.EqualFold(mimeType, attachment.MimeType) {
+			return nil
+		}
+	}
+
+	return ErrAttachmentTypeRejected
+}
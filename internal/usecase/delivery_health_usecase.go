@@ -0,0 +1,203 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/mail"
+	"wetalk/pkg/metrics"
+	"wetalk/pkg/push"
+)
+
+const (
+	deliveryBounceSoft = "soft"
+	deliveryBounceHard = "hard"
+)
+
+// DeliveryHealthPolicy holds the thresholds of the push and email failure tracking
+type DeliveryHealthPolicy struct {
+	// DeviceFailureThreshold is the number of pushes failing in a row that deactivates a device
+	DeviceFailureThreshold int
+	// EmailBounceThreshold is the number of soft bounces in a row that flags an address
+	EmailBounceThreshold int
+	// DeactivatedDeviceRetention is how long a deactivated device is kept before it is deleted,
+	// registering its token again meanwhile reactivates it
+	DeactivatedDeviceRetention time.Duration
+}
+
+func DefaultDeliveryHealthPolicy() DeliveryHealthPolicy {
+	return DeliveryHealthPolicy{
+		DeviceFailureThreshold:     5,
+		EmailBounceThreshold:       3,
+		DeactivatedDeviceRetention: 30 * 24 * time.Hour,
+	}
+}
+
+// DeliveryHealthUsecase keeps the notification pipeline healthy: it deactivates the devices the
+// push providers keep refusing and flags the email addresses the mail relay bounces, so nothing
+// is sent to them anymore
+type DeliveryHealthUsecase interface {
+	// RecordPush tracks the outcome of a push to the device, err is nil when it was delivered.
+	// A device the provider no longer knows is deactivated at once
+	RecordPush(ctx context.Context, device entity.Device, err error)
+	// RecordEmail tracks the outcome of an email to the address of the user, err is nil when the
+	// relay took it
+	RecordEmail(ctx context.Context, userId string, email string, err error)
+	// CanEmail reports whether the address still gets emails
+	CanEmail(ctx context.Context, email string) (bool, error)
+	// ClearEmailBounces lets the emails to the user go out again, once their address was fixed
+	ClearEmailBounces(ctx context.Context, userId string) error
+	GetStats(ctx context.Context) (entity.DeliveryHealth, error)
+	// PurgeDeactivatedDevices deletes the devices deactivated for longer than the retention
+	PurgeDeactivatedDevices(ctx context.Context) (int64, error)
+}
+
+type deliveryHealthUsecase struct {
+	deviceRepo      repository.DeviceRepository
+	emailBounceRepo repository.EmailBounceRepository
+	userRepo        repository.UserRepository
+	policy          DeliveryHealthPolicy
+
+	pushFailures  *metrics.Vec
+	emailBounces  *metrics.Vec
+	deactivations *metrics.Vec
+
+	mu          sync.Mutex
+	lastCleanup *entity.DeliveryCleanup
+}
+
+func NewDeliveryHealthUsecase(registry *metrics.Registry, deviceRepo repository.DeviceRepository, emailBounceRepo repository.EmailBounceRepository, userRepo repository.UserRepository, policy DeliveryHealthPolicy) DeliveryHealthUsecase {
+	return &deliveryHealthUsecase{
+		deviceRepo:      deviceRepo,
+		emailBounceRepo: emailBounceRepo,
+		userRepo:        userRepo,
+		policy:          policy,
+		pushFailures:    registry.NewCounter("wetalk_push_failures_total", "Pushes the provider refused, by whether the token is gone for good.", "kind"),
+		emailBounces:    registry.NewCounter("wetalk_email_bounces_total", "Emails the relay refused, by whether the address is gone for good.", "kind"),
+		deactivations:   registry.NewCounter("wetalk_device_deactivations_total", "Devices deactivated after failed pushes, by reason.", "reason"),
+	}
+}
+
+func (d *deliveryHealthUsecase) RecordPush(ctx context.Context, device entity.Device, err error) {
+	if err == nil {
+		if device.Failures == 0 {
+			return
+		}
+		if err := d.deviceRepo.ResetFailures(ctx, device.Id); err != nil {
+			slog.ErrorContext(ctx, "Reset device failures error", "device_id", device.Id, "error", err)
+		}
+		return
+	}
+
+	if errors.Is(err, push.ErrUnregisteredDevice) {
+		d.pushFailures.Inc(deliveryBounceHard)
+		slog.InfoContext(ctx, "Deactivating unregistered device", "device_id", device.Id, "platform", device.Platform)
+		if err := d.deviceRepo.Deactivate(ctx, device.Id, entity.DeviceDeactivatedUnregistered); err != nil {
+			slog.ErrorContext(ctx, "Deactivate unregistered device error", "device_id", device.Id, "error", err)
+			return
+		}
+		d.deactivations.Inc(entity.DeviceDeactivatedUnregistered)
+		return
+	}
+
+	d.pushFailures.Inc(deliveryBounceSoft)
+	updated, deactivated, err := d.deviceRepo.RecordFailure(ctx, device.Id, err.Error(), d.policy.DeviceFailureThreshold)
+	if err != nil {
+		// The device may have been removed meanwhile
+		if !errors.Is(err, repository.ErrDeviceNotFound) {
+			slog.ErrorContext(ctx, "Record device failure error", "device_id", device.Id, "error", err)
+		}
+		return
+	}
+	if deactivated {
+		slog.InfoContext(ctx, "Deactivating failing device", "device_id", device.Id, "platform", device.Platform, "failures", updated.Failures)
+		d.deactivations.Inc(entity.DeviceDeactivatedFailing)
+	}
+}
+
+func (d *deliveryHealthUsecase) RecordEmail(ctx context.Context, userId string, email string, err error) {
+	if err == nil {
+		// Most addresses never bounced, a delete of nothing is as cheap as a lookup
+		if err := d.emailBounceRepo.Clear(ctx, email); err != nil {
+			slog.ErrorContext(ctx, "Clear email bounces error", "user_id", userId, "error", err)
+		}
+		return
+	}
+	// Only the refusals of the relay are bounces, a timeout says nothing about the address
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	permanent := errors.Is(err, mail.ErrHardBounce)
+	if permanent {
+		d.emailBounces.Inc(deliveryBounceHard)
+	} else {
+		d.emailBounces.Inc(deliveryBounceSoft)
+	}
+
+	bounce, err := d.emailBounceRepo.RecordBounce(ctx, email, userId, err.Error(), permanent, d.policy.EmailBounceThreshold)
+	if err != nil {
+		slog.ErrorContext(ctx, "Record email bounce error", "user_id", userId, "error", err)
+		return
+	}
+	if bounce.IsFlagged() {
+		slog.InfoContext(ctx, "Email address flagged", "user_id", userId, "permanent", permanent, "soft_bounces", bounce.SoftBounces)
+	}
+}
+
+func (d *deliveryHealthUsecase) CanEmail(ctx context.Context, email string) (bool, error) {
+	flagged, err := d.emailBounceRepo.IsFlagged(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	return !flagged, nil
+}
+
+func (d *deliveryHealthUsecase) ClearEmailBounces(ctx context.Context, userId string) error {
+	user, err := d.userRepo.Get(ctx, userId)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	return d.emailBounceRepo.Clear(ctx, user.Email)
+}
+
+func (d *deliveryHealthUsecase) GetStats(ctx context.Context) (entity.DeliveryHealth, error) {
+	devices, err := d.deviceRepo.Stats(ctx)
+	if err != nil {
+		return entity.DeliveryHealth{}, err
+	}
+	emails, err := d.emailBounceRepo.Stats(ctx)
+	if err != nil {
+		return entity.DeliveryHealth{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return entity.DeliveryHealth{
+		Devices:     devices,
+		Emails:      emails,
+		LastCleanup: d.lastCleanup,
+	}, nil
+}
+
+func (d *deliveryHealthUsecase) PurgeDeactivatedDevices(ctx context.Context) (int64, error) {
+	deleted, err := d.deviceRepo.DeleteDeactivatedBefore(ctx, time.Now().Add(-d.policy.DeactivatedDeviceRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastCleanup = &entity.DeliveryCleanup{
+		DevicesDeleted: deleted,
+		RanAt:          time.Now(),
+	}
+	return deleted, nil
+}
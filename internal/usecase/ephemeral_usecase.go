@@ -0,0 +1,38 @@
+package usecase
+
+import (
+	"context"
+	"time"
+	"wetalk/internal/entity"
+
+	"github.com/google/uuid"
+)
+
+// EphemeralUsecase delivers server generated messages, such as command results, error notices
+// and bot prompts, to a single user. They skip the outbox and are never stored, a user without
+// a live connection doesn't get them
+type EphemeralUsecase interface {
+	Send(ctx context.Context, userId string, message entity.EphemeralMessage)
+}
+
+type ephemeralUsecase struct {
+	publisher EventPublisher
+}
+
+// NewEphemeralUsecase returns the usecase, the publisher must push to the hub directly
+func NewEphemeralUsecase(publisher EventPublisher) EphemeralUsecase {
+	return &ephemeralUsecase{
+		publisher: publisher,
+	}
+}
+
+// Send fills the id and the timestamp of the message and pushes it to the user's devices
+func (e *ephemeralUsecase) Send(ctx context.Context, userId string, message entity.EphemeralMessage) {
+	message.Id = entity.EphemeralIdPrefix + uuid.New().String()
+	if message.Timestamp == 0 {
+		message.Timestamp = time.Now().UnixMilli()
+	}
+	message.SentAt = entity.FormatTimestamp(message.Timestamp)
+
+	e.publisher.PublishToUsers(ctx, []string{userId}, entity.EventEphemeralMessage, message)
+}
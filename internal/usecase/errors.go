@@ -0,0 +1,45 @@
+package usecase
+
+import (
+	"errors"
+	"wetalk/internal/repository"
+)
+
+// Errors returned by the usecases are sentinel errors, possibly wrapped with fmt.Errorf("...: %w", err)
+// or in a ValidationError, so callers compare them with errors.Is and never with ==.
+
+// ErrCanceled and ErrTimeout are heavy queries stopped because the caller went away or because they
+// ran past their time limit
+var (
+	ErrCanceled = repository.ErrQueryCanceled
+	ErrTimeout  = repository.ErrQueryTimeout
+)
+
+// ValidationError is a request field rejected by a usecase, it wraps the sentinel error describing the problem
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// invalidField reports err as a problem with the given request field
+func invalidField(field string, err error) error {
+	return &ValidationError{Field: field, Err: err}
+}
+
+// IsAny reports whether err matches one of the targets
+func IsAny(err error, targets ...error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
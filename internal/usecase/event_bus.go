@@ -0,0 +1,45 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+)
+
+// EventHandler reacts to a domain event, it is responsible for logging its own failures
+type EventHandler func(ctx context.Context, data any)
+
+// EventBus dispatches domain events to in-process consumers such as read models.
+// Unlike EventPublisher, nothing published here is sent to clients
+type EventBus interface {
+	Subscribe(eventType string, handler EventHandler)
+	Publish(ctx context.Context, eventType string, data any)
+}
+
+type eventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]EventHandler
+}
+
+func NewEventBus() EventBus {
+	return &eventBus{
+		handlers: make(map[string][]EventHandler),
+	}
+}
+
+func (b *eventBus) Subscribe(eventType string, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish runs the handlers synchronously, so the read models are up to date when the request returns
+func (b *eventBus) Publish(ctx context.Context, eventType string, data any) {
+	b.mu.RLock()
+	handlers := b.handlers[eventType]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(ctx, data)
+	}
+}
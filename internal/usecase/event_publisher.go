@@ -0,0 +1,8 @@
+package usecase
+
+import "context"
+
+// EventPublisher pushes real-time events to users that are currently connected
+type EventPublisher interface {
+	PublishToUsers(ctx context.Context, userIds []string, eventType string, data any)
+}
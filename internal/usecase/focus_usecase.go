@@ -0,0 +1,134 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+// focusTTL is how long a focus lasts unless the client sends it again
+const focusTTL = 90 * time.Second
+
+// FocusUsecase tracks which chat users are currently looking at. It is ephemeral presence,
+// so changes are pushed directly to connected participants instead of through the outbox
+type FocusUsecase interface {
+	Focus(ctx context.Context, userId string, connectionId string, chatId string) error
+	Blur(ctx context.Context, userId string, connectionId string) error
+	GetViewers(ctx context.Context, chatId string, userId string) (entity.ChatViewers, error)
+	// IsViewing reports whether the user has the chat open on any device
+	IsViewing(ctx context.Context, userId string, chatId string) (bool, error)
+}
+
+type focusUsecase struct {
+	focusRepo repository.FocusRepository
+	chatRepo  repository.ChatRepository
+	publisher EventPublisher
+}
+
+func NewFocusUsecase(focusRepo repository.FocusRepository, chatRepo repository.ChatRepository, publisher EventPublisher) FocusUsecase {
+	return &focusUsecase{
+		focusRepo: focusRepo,
+		chatRepo:  chatRepo,
+		publisher: publisher,
+	}
+}
+
+func (f *focusUsecase) Focus(ctx context.Context, userId string, connectionId string, chatId string) error {
+	isParticipant, err := f.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		return ErrNotParticipant
+	}
+
+	viewers, err := f.focusRepo.GetViewers(ctx, chatId)
+	if err != nil {
+		return err
+	}
+	wasViewing := slices.Contains(viewers, userId)
+
+	previous, err := f.focusRepo.Focus(ctx, userId, connectionId, chatId, time.Now().Add(focusTTL))
+	if err != nil {
+		return err
+	}
+
+	if previous != "" && previous != chatId {
+		f.broadcastViewers(ctx, previous)
+	}
+	// A refresh of an existing focus changes nothing for the other participants
+	if !wasViewing {
+		f.broadcastViewers(ctx, chatId)
+	}
+
+	return nil
+}
+
+func (f *focusUsecase) Blur(ctx context.Context, userId string, connectionId string) error {
+	previous, err := f.focusRepo.Blur(ctx, userId, connectionId)
+	if err != nil {
+		return err
+	}
+
+	if previous != "" {
+		f.broadcastViewers(ctx, previous)
+	}
+	return nil
+}
+
+func (f *focusUsecase) GetViewers(ctx context.Context, chatId string, userId string) (entity.ChatViewers, error) {
+	isParticipant, err := f.chatRepo.IsParticipant(ctx, userId, chatId)
+	if err != nil {
+		return entity.ChatViewers{}, err
+	}
+	if !isParticipant {
+		return entity.ChatViewers{}, ErrNotParticipant
+	}
+
+	viewers, err := f.focusRepo.GetViewers(ctx, chatId)
+	if err != nil {
+		return entity.ChatViewers{}, err
+	}
+
+	return entity.ChatViewers{
+		ChatId:  chatId,
+		UserIds: viewers,
+	}, nil
+}
+
+func (f *focusUsecase) IsViewing(ctx context.Context, userId string, chatId string) (bool, error) {
+	viewers, err := f.focusRepo.GetViewers(ctx, chatId)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(viewers, userId), nil
+}
+
+// broadcastViewers pushes the current viewers of a chat to its participants
+func (f *focusUsecase) broadcastViewers(ctx context.Context, chatId string) {
+	viewers, err := f.focusRepo.GetViewers(ctx, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get chat viewers error", "error", err)
+		return
+	}
+
+	participants, err := f.chatRepo.GetParticipants(ctx, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get participants for chat viewers error", "error", err)
+		return
+	}
+
+	userIds := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserId)
+	}
+
+	f.publisher.PublishToUsers(ctx, userIds, entity.EventChatViewers, entity.ChatViewers{
+		ChatId:  chatId,
+		UserIds: viewers,
+	})
+}
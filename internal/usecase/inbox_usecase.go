@@ -0,0 +1,379 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+// InboxUsecase maintains the per-user chat list read model from domain events
+type InboxUsecase interface {
+	Index(ctx context.Context, userId string, archived bool, fields entity.Fields) ([]entity.InboxEntry, error)
+	Rebuild(ctx context.Context, userId string) error
+	Subscribe(bus EventBus)
+}
+
+type inboxUsecase struct {
+	inboxRepo   repository.InboxRepository
+	chatRepo    repository.ChatRepository
+	userRepo    repository.UserRepository
+	messageRepo repository.MessageRepository
+	receiptRepo repository.ReceiptRepository
+	presence    PresenceChecker
+}
+
+func NewInboxUsecase(inboxRepo repository.InboxRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, messageRepo repository.MessageRepository, receiptRepo repository.ReceiptRepository, presence PresenceChecker) InboxUsecase {
+	return &inboxUsecase{
+		inboxRepo:   inboxRepo,
+		chatRepo:    chatRepo,
+		userRepo:    userRepo,
+		messageRepo: messageRepo,
+		receiptRepo: receiptRepo,
+		presence:    presence,
+	}
+}
+
+// Index returns the archived or the regular chat list of a user, building it on first use for users that predate the read model
+func (i *inboxUsecase) Index(ctx context.Context, userId string, archived bool, fields entity.Fields) ([]entity.InboxEntry, error) {
+	built, err := i.inboxRepo.HasEntries(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !built {
+		if err := i.Rebuild(ctx, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := i.inboxRepo.Index(ctx, userId, archived, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fields.Has("participantCount", "onlineCount") {
+		return entries, nil
+	}
+	if err := i.attachMemberCounts(ctx, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// attachMemberCounts fills the participant and online counts of the entries
+func (i *inboxUsecase) attachMemberCounts(ctx context.Context, entries []entity.InboxEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	chatIds := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		chatIds = append(chatIds, entry.ChatId)
+	}
+
+	userIdsByChat, err := i.chatRepo.GetParticipantIdsByChats(ctx, chatIds)
+	if err != nil {
+		return err
+	}
+
+	// The same users often share several chats, check each of them once
+	online := make(map[string]bool)
+	for idx := range entries {
+		userIds := userIdsByChat[entries[idx].ChatId]
+		entries[idx].ParticipantCount = len(userIds)
+
+		for _, userId := range userIds {
+			isOnline, checked := online[userId]
+			if !checked {
+				isOnline = i.presence.IsConnected(userId)
+				online[userId] = isOnline
+			}
+			if isOnline {
+				entries[idx].OnlineCount++
+			}
+		}
+	}
+
+	return nil
+}
+
+// Rebuild recreates the entries of a user from the chats they participate in
+func (i *inboxUsecase) Rebuild(ctx context.Context, userId string) error {
+	chats, err := i.chatRepo.Index(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, chat := range chats {
+		entry, err := i.buildEntry(ctx, chat, userId)
+		if err != nil {
+			return err
+		}
+
+		messages, err := i.messageRepo.GetByChatId(ctx, chat.Id, 1, 0)
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			entry.LastMessage = inboxMessage(messages[0])
+			entry.UpdatedAt = time.UnixMilli(messages[0].Timestamp)
+		}
+
+		if err := i.inboxRepo.Upsert(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *inboxUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventChatCreated, i.onChatCreated)
+	bus.Subscribe(entity.EventChatUpdated, i.onChatUpdated)
+	bus.Subscribe(entity.EventChatDeleted, i.onChatDeleted)
+	// A restored chat is back in the inbox of its participants like a new one
+	bus.Subscribe(entity.EventChatRestored, i.onChatCreated)
+	bus.Subscribe(entity.EventMemberJoined, i.onMemberJoined)
+	bus.Subscribe(entity.EventMemberLeft, i.onMemberGone)
+	bus.Subscribe(entity.EventMemberRemoved, i.onMemberGone)
+	bus.Subscribe(entity.EventMessageCreated, i.onMessageCreated)
+	bus.Subscribe(entity.EventMessageDeleted, i.onMessageDeleted)
+	bus.Subscribe(entity.EventMessagesDeleted, i.onMessagesDeleted)
+	bus.Subscribe(entity.EventMessageRead, i.onMessageRead)
+	bus.Subscribe(entity.EventChatRead, i.onChatRead)
+	bus.Subscribe(entity.EventParticipantUpdated, i.onParticipantUpdated)
+}
+
+func (i *inboxUsecase) onChatCreated(ctx context.Context, data any) {
+	chat, ok := data.(entity.Chat)
+	if !ok {
+		return
+	}
+
+	participants, err := i.chatRepo.GetParticipants(ctx, chat.Id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox chat created error", "error", err)
+		return
+	}
+
+	for _, participant := range participants {
+		i.upsertEntry(ctx, chat, participant.UserId)
+	}
+}
+
+func (i *inboxUsecase) onChatUpdated(ctx context.Context, data any) {
+	chat, ok := data.(entity.Chat)
+	if !ok {
+		return
+	}
+
+	if err := i.inboxRepo.UpdateChatInfo(ctx, chat.Id, chat.Name, chat.Avatar); err != nil {
+		slog.ErrorContext(ctx, "Inbox chat updated error", "error", err)
+	}
+}
+
+func (i *inboxUsecase) onChatDeleted(ctx context.Context, data any) {
+	chat, ok := data.(entity.Chat)
+	if !ok {
+		return
+	}
+
+	if err := i.inboxRepo.DeleteByChat(ctx, chat.Id); err != nil {
+		slog.ErrorContext(ctx, "Inbox chat deleted error", "error", err)
+	}
+}
+
+func (i *inboxUsecase) onMemberJoined(ctx context.Context, data any) {
+	event, ok := data.(entity.MembershipEvent)
+	if !ok {
+		return
+	}
+
+	chat, err := i.chatRepo.Get(ctx, event.ChatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox member joined error", "error", err)
+		return
+	}
+
+	i.upsertEntry(ctx, chat, event.UserId)
+}
+
+func (i *inboxUsecase) onMemberGone(ctx context.Context, data any) {
+	event, ok := data.(entity.MembershipEvent)
+	if !ok {
+		return
+	}
+
+	if err := i.inboxRepo.Delete(ctx, event.UserId, event.ChatId); err != nil {
+		slog.ErrorContext(ctx, "Inbox member gone error", "error", err)
+	}
+}
+
+func (i *inboxUsecase) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok {
+		return
+	}
+
+	// The participants the message doesn't notify only see it as the last message of the chat, system
+	// messages notify nobody
+	participants, err := i.chatRepo.GetParticipants(ctx, message.ChatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox message created error", "error", err)
+		return
+	}
+	var quietIds []string
+	for _, participant := range participants {
+		if participant.UserId != message.SenderId && (message.IsSystem() || !participant.Notifies(message)) {
+			quietIds = append(quietIds, participant.UserId)
+		}
+	}
+
+	err = i.inboxRepo.ApplyMessage(ctx, message.ChatId, *inboxMessage(message), quietIds, time.UnixMilli(message.Timestamp))
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox message created error", "error", err)
+	}
+}
+
+// onMessageDeleted falls back to the previous message of the chat in the entries showing the deleted one
+func (i *inboxUsecase) onMessageDeleted(ctx context.Context, data any) {
+	event, ok := data.(entity.MessageDeleted)
+	if !ok {
+		return
+	}
+
+	i.replaceLastMessage(ctx, event.ChatId, []string{event.MessageId})
+}
+
+func (i *inboxUsecase) onMessagesDeleted(ctx context.Context, data any) {
+	event, ok := data.(entity.MessagesDeleted)
+	if !ok {
+		return
+	}
+
+	i.replaceLastMessage(ctx, event.ChatId, event.MessageIds)
+}
+
+// replaceLastMessage shows the latest message left in the chat on the entries that showed one of
+// the deleted messages
+func (i *inboxUsecase) replaceLastMessage(ctx context.Context, chatId string, deletedIds []string) {
+	messages, err := i.messageRepo.GetByChatId(ctx, chatId, 1, 0)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox message deleted error", "error", err)
+		return
+	}
+	var replacement *entity.InboxMessage
+	if len(messages) > 0 {
+		replacement = inboxMessage(messages[0])
+	}
+
+	if err := i.inboxRepo.ReplaceLastMessage(ctx, chatId, deletedIds, replacement); err != nil {
+		slog.ErrorContext(ctx, "Inbox message deleted error", "error", err)
+	}
+}
+
+func (i *inboxUsecase) onMessageRead(ctx context.Context, data any) {
+	receipt, ok := data.(entity.ReadReceipt)
+	if !ok || receipt.Quiet {
+		return
+	}
+
+	if err := i.inboxRepo.DecrementUnread(ctx, receipt.ReaderId, receipt.ChatId); err != nil {
+		slog.ErrorContext(ctx, "Inbox message read error", "error", err)
+	}
+}
+
+// onChatRead counts what the reader has left to read, the messages sent after the horizon
+func (i *inboxUsecase) onChatRead(ctx context.Context, data any) {
+	horizon, ok := data.(entity.ReadHorizon)
+	if !ok {
+		return
+	}
+
+	unread, err := i.receiptRepo.CountUnreadInChat(ctx, horizon.ReaderId, horizon.ChatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox chat read error", "error", err)
+		return
+	}
+	if err := i.inboxRepo.UpdateUnreadCount(ctx, horizon.ReaderId, horizon.ChatId, unread); err != nil {
+		slog.ErrorContext(ctx, "Inbox chat read error", "error", err)
+	}
+}
+
+func (i *inboxUsecase) onParticipantUpdated(ctx context.Context, data any) {
+	participant, ok := data.(entity.ChatParticipant)
+	if !ok {
+		return
+	}
+
+	err := i.inboxRepo.UpdateParticipantSettings(ctx, participant.UserId, participant.ChatId, participant.PinnedAt, participant.ArchivedAt != nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Inbox participant updated error", "error", err)
+	}
+}
+
+func (i *inboxUsecase) upsertEntry(ctx context.Context, chat entity.Chat, userId string) {
+	entry, err := i.buildEntry(ctx, chat, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Build inbox entry error", "error", err)
+		return
+	}
+
+	if err := i.inboxRepo.Upsert(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "Upsert inbox entry error", "error", err)
+	}
+}
+
+// buildEntry returns the inbox entry of a chat as seen by the user, personal chats are named after the other user
+func (i *inboxUsecase) buildEntry(ctx context.Context, chat entity.Chat, userId string) (entity.InboxEntry, error) {
+	entry := entity.InboxEntry{
+		UserId:    userId,
+		ChatId:    chat.Id,
+		Type:      chat.Type,
+		Name:      chat.Name,
+		Avatar:    chat.Avatar,
+		UpdatedAt: chat.UpdatedAt,
+	}
+
+	participation, err := i.chatRepo.GetParticipantByUserAndChat(ctx, userId, chat.Id)
+	if err != nil && !errors.Is(err, repository.ErrNotParticipant) {
+		return entity.InboxEntry{}, err
+	}
+	entry.PinnedAt = participation.PinnedAt
+	entry.IsArchived = participation.ArchivedAt != nil
+
+	if chat.Type != entity.ChatTypePersonal {
+		return entry, nil
+	}
+
+	participants, err := i.chatRepo.GetParticipants(ctx, chat.Id)
+	if err != nil {
+		return entity.InboxEntry{}, err
+	}
+	for _, participant := range participants {
+		if participant.UserId == userId {
+			continue
+		}
+
+		other, err := i.userRepo.Get(ctx, participant.UserId)
+		if err != nil {
+			return entity.InboxEntry{}, err
+		}
+		entry.Name = other.Name
+		break
+	}
+
+	return entry, nil
+}
+
+func inboxMessage(message entity.Message) *entity.InboxMessage {
+	return &entity.InboxMessage{
+		MessageId: message.Id,
+		SenderId:  message.SenderId,
+		Snippet:   snippet(message.Message, quoteSnippetLength),
+		Timestamp: message.Timestamp,
+	}
+}
@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/logger"
+)
+
+// LinkPreviewFetcher reads the preview of the page a link points to, see pkg/linkpreview
+type LinkPreviewFetcher interface {
+	Fetch(ctx context.Context, url string) (entity.LinkPreview, error)
+}
+
+// LinkPreviewPolicy holds the limits of the link preview workers
+type LinkPreviewPolicy struct {
+	// QueueSize bounds the links waiting for a worker, messages get no preview once it is full
+	QueueSize int
+	// Workers is the number of pages read concurrently
+	Workers int
+	// CacheTTL is how long a preview is reused for the same link
+	CacheTTL time.Duration
+	// FailureTTL is how long a page that could not be previewed is not read again
+	FailureTTL time.Duration
+	// FetchTimeout bounds the reading of a page and the update of its message
+	FetchTimeout time.Duration
+}
+
+func DefaultLinkPreviewPolicy() LinkPreviewPolicy {
+	return LinkPreviewPolicy{
+		QueueSize:    256,
+		Workers:      4,
+		CacheTTL:     24 * time.Hour,
+		FailureTTL:   time.Hour,
+		FetchTimeout: 30 * time.Second,
+	}
+}
+
+// linkPreviewJob is a message waiting for the preview of its first link
+type linkPreviewJob struct {
+	message entity.Message
+	url     string
+}
+
+// LinkPreviewUsecase previews the first link of the stored messages in the background, the
+// message is sent without it and the participants get a link_preview event once it is ready
+type LinkPreviewUsecase interface {
+	Subscribe(bus EventBus)
+	// Run reads the queued links until the process exits
+	Run()
+}
+
+type linkPreviewUsecase struct {
+	linkPreviewRepo repository.LinkPreviewRepository
+	messageRepo     repository.MessageRepository
+	chatRepo        repository.ChatRepository
+	fetcher         LinkPreviewFetcher
+	publisher       EventPublisher
+	policy          LinkPreviewPolicy
+	// bus is set by Subscribe, the updated messages are published on it
+	bus   EventBus
+	queue chan linkPreviewJob
+}
+
+func NewLinkPreviewUsecase(linkPreviewRepo repository.LinkPreviewRepository, messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, fetcher LinkPreviewFetcher, publisher EventPublisher, policy LinkPreviewPolicy) LinkPreviewUsecase {
+	return &linkPreviewUsecase{
+		linkPreviewRepo: linkPreviewRepo,
+		messageRepo:     messageRepo,
+		chatRepo:        chatRepo,
+		fetcher:         fetcher,
+		publisher:       publisher,
+		policy:          policy,
+		queue:           make(chan linkPreviewJob, policy.QueueSize),
+	}
+}
+
+func (l *linkPreviewUsecase) Subscribe(bus EventBus) {
+	l.bus = bus
+	bus.Subscribe(entity.EventMessageCreated, l.onMessageCreated)
+}
+
+func (l *linkPreviewUsecase) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok {
+		return
+	}
+	url := message.FirstLink()
+	if url == "" {
+		return
+	}
+
+	select {
+	case l.queue <- linkPreviewJob{message: message, url: url}:
+	default:
+		slog.WarnContext(ctx, "Link preview queue is full, dropping preview", "message_id", message.Id)
+	}
+}
+
+func (l *linkPreviewUsecase) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < l.policy.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range l.queue {
+				l.preview(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// preview attaches the preview of the link to the message and tells the participants
+func (l *linkPreviewUsecase) preview(job linkPreviewJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.policy.FetchTimeout)
+	defer cancel()
+	ctx = logger.With(ctx, slog.String("message_id", job.message.Id), slog.String("chat_id", job.message.ChatId))
+
+	preview := l.get(ctx, job.url)
+	if preview.IsEmpty() {
+		return
+	}
+
+	if err := l.messageRepo.SetLinkPreview(ctx, job.message.Id, preview); err != nil {
+		// The message may have been deleted or have disappeared meanwhile
+		if !errors.Is(err, repository.ErrMessageNotFound) {
+			slog.ErrorContext(ctx, "Set link preview error", "error", err)
+		}
+		return
+	}
+	message := job.message
+	message.LinkPreview = &preview
+	l.bus.Publish(ctx, entity.EventMessageUpdated, message)
+
+	participants, err := l.chatRepo.GetParticipants(ctx, job.message.ChatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get participants for link preview error", "error", err)
+		return
+	}
+	userIds := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		userIds = append(userIds, participant.UserId)
+	}
+	l.publisher.PublishToUsers(ctx, userIds, entity.EventLinkPreview, entity.LinkPreviewReady{
+		ChatId:      job.message.ChatId,
+		MessageId:   job.message.Id,
+		LinkPreview: preview,
+	})
+}
+
+// get returns the cached preview of the link, or reads the page. A page that can't be read is
+// remembered as an empty preview so it isn't read again for every message sharing it
+func (l *linkPreviewUsecase) get(ctx context.Context, url string) entity.LinkPreview {
+	preview, err := l.linkPreviewRepo.Get(ctx, url)
+	if err == nil {
+		return preview
+	}
+	if !errors.Is(err, repository.ErrLinkPreviewNotCached) {
+		// The cache only spares reading the page again
+		slog.WarnContext(ctx, "Get cached link preview error", "error", err)
+	}
+
+	ttl := l.policy.CacheTTL
+	preview, err = l.fetcher.Fetch(ctx, url)
+	if err != nil {
+		slog.InfoContext(ctx, "Link not previewed", "error", err)
+		preview, ttl = entity.LinkPreview{}, l.policy.FailureTTL
+	}
+
+	if err := l.linkPreviewRepo.Set(ctx, url, preview, ttl); err != nil {
+		slog.WarnContext(ctx, "Cache link preview error", "error", err)
+	}
+	return preview
+}
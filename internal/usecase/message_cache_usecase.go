@@ -0,0 +1,166 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/metrics"
+)
+
+const (
+	messageCacheHit  = "hit"
+	messageCacheMiss = "miss"
+)
+
+// MessageCachePolicy sizes the cached page of messages
+type MessageCachePolicy struct {
+	// PageSize is the number of newest messages cached per chat, bigger pages and the next
+	// pages are read from the database
+	PageSize int
+	// TTL drops the page of a chat nobody opened for that long
+	TTL time.Duration
+}
+
+func DefaultMessageCachePolicy() MessageCachePolicy {
+	return MessageCachePolicy{
+		PageSize: 100,
+		TTL:      10 * time.Minute,
+	}
+}
+
+// MessageCacheUsecase serves the newest page of messages of a chat, the one loaded when a chat is
+// opened, from a cache filled from the database on a miss. The page is dropped whenever a message
+// of the chat is sent, read or deleted, so it never serves a stale message
+type MessageCacheUsecase interface {
+	// Latest returns up to limit of the newest messages of the chat, newest first. It reports
+	// false when the request is not for the newest page, the caller reads the database then
+	Latest(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, bool, error)
+	Subscribe(bus EventBus)
+}
+
+type messageCacheUsecase struct {
+	messagePageRepo repository.MessagePageRepository
+	messageRepo     repository.MessageRepository
+	policy          MessageCachePolicy
+
+	requests *metrics.Vec
+}
+
+func NewMessageCacheUsecase(registry *metrics.Registry, messagePageRepo repository.MessagePageRepository, messageRepo repository.MessageRepository, policy MessageCachePolicy) MessageCacheUsecase {
+	return &messageCacheUsecase{
+		messagePageRepo: messagePageRepo,
+		messageRepo:     messageRepo,
+		policy:          policy,
+		requests:        registry.NewCounter("wetalk_message_cache_requests_total", "Reads of the newest page of a chat, by whether the cache served them.", "result"),
+	}
+}
+
+func (m *messageCacheUsecase) Latest(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, bool, error) {
+	if offset > 0 || limit <= 0 || limit > m.policy.PageSize {
+		return nil, false, nil
+	}
+
+	messages, found, err := m.messagePageRepo.Get(ctx, chatId)
+	if err != nil {
+		// The cache only spares the database
+		slog.ErrorContext(ctx, "Get cached messages error", "chat_id", chatId, "error", err)
+	}
+	if found && m.expired(messages) {
+		// The database no longer returns the disappeared messages, the page is loaded again
+		found = false
+	}
+	if found {
+		m.requests.Inc(messageCacheHit)
+	} else {
+		m.requests.Inc(messageCacheMiss)
+		messages, err = m.fill(ctx, chatId)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, true, nil
+}
+
+// fill loads the page from the database and caches it, unless a message changed meanwhile
+func (m *messageCacheUsecase) fill(ctx context.Context, chatId string) ([]entity.Message, error) {
+	version, err := m.messagePageRepo.Version(ctx, chatId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get cached messages version error", "chat_id", chatId, "error", err)
+	}
+
+	messages, err := m.messageRepo.Index(ctx, entity.MessageIndexFilter{
+		ChatId: chatId,
+		Limit:  m.policy.PageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.messagePageRepo.Set(ctx, chatId, version, messages, m.policy.TTL); err != nil {
+		slog.ErrorContext(ctx, "Cache messages error", "chat_id", chatId, "error", err)
+	}
+	return messages, nil
+}
+
+// expired reports whether a message of the page is past its expiresAt
+func (m *messageCacheUsecase) expired(messages []entity.Message) bool {
+	now := time.Now()
+	for _, message := range messages {
+		if message.ExpiresAt != nil && !message.ExpiresAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *messageCacheUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventMessageCreated, m.onMessageChanged)
+	bus.Subscribe(entity.EventMessageUpdated, m.onMessageChanged)
+	bus.Subscribe(entity.EventMessageRead, m.onMessageRead)
+	bus.Subscribe(entity.EventMessageDeleted, m.onMessageDeleted)
+	bus.Subscribe(entity.EventMessagesDeleted, m.onMessagesDeleted)
+	bus.Subscribe(entity.EventChatDeleted, m.onChatDeleted)
+}
+
+func (m *messageCacheUsecase) onMessageChanged(ctx context.Context, data any) {
+	if message, ok := data.(entity.Message); ok {
+		m.invalidate(ctx, message.ChatId)
+	}
+}
+
+// onMessageRead drops the page for the read flag of the message
+func (m *messageCacheUsecase) onMessageRead(ctx context.Context, data any) {
+	if receipt, ok := data.(entity.ReadReceipt); ok {
+		m.invalidate(ctx, receipt.ChatId)
+	}
+}
+
+func (m *messageCacheUsecase) onMessageDeleted(ctx context.Context, data any) {
+	if event, ok := data.(entity.MessageDeleted); ok {
+		m.invalidate(ctx, event.ChatId)
+	}
+}
+
+func (m *messageCacheUsecase) onMessagesDeleted(ctx context.Context, data any) {
+	if event, ok := data.(entity.MessagesDeleted); ok {
+		m.invalidate(ctx, event.ChatId)
+	}
+}
+
+func (m *messageCacheUsecase) onChatDeleted(ctx context.Context, data any) {
+	if chat, ok := data.(entity.Chat); ok {
+		m.invalidate(ctx, chat.Id)
+	}
+}
+
+func (m *messageCacheUsecase) invalidate(ctx context.Context, chatId string) {
+	if err := m.messagePageRepo.Invalidate(ctx, chatId); err != nil {
+		slog.ErrorContext(ctx, "Invalidate cached messages error", "chat_id", chatId, "error", err)
+	}
+}
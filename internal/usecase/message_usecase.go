@@ -2,29 +2,78 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
 	"wetalk/internal/entity"
 	"wetalk/internal/repository"
 )
 
+var (
+	ErrInvalidReply       = errors.New("replied message does not belong to this chat")
+	ErrInvalidReadHorizon = errors.New("upTo must be a unix timestamp in milliseconds")
+	ErrInvalidClientId    = errors.New("clientMessageId must be at most 64 characters")
+)
+
+// quoteSnippetLength is the number of characters of the original message kept in a reply
+const quoteSnippetLength = 100
+
+// maxClientMessageIdLength bounds the idempotency keys, clients usually send a UUID
+const maxClientMessageIdLength = 64
+
+// disappearedBatchSize bounds the messages deleted by one run of the sweeper, the next run takes the rest
+const disappearedBatchSize = 1000
+
 type MessageUsecase interface {
 	GetReceiver(ctx context.Context, chatId string) ([]string, error)
-	SaveMessage(ctx context.Context, message entity.Message) (string, error)
+	SaveMessage(ctx context.Context, message entity.Message) (entity.Message, error)
 	GetMessagesByChatId(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, error)
 	GetMessage(ctx context.Context, messageId string) (entity.Message, error)
-	MarkAsRead(ctx context.Context, messageId string) error
+	MarkAsRead(ctx context.Context, messageId string, readerId string) error
+	// MarkChatAsRead reads the chat up to a message or a timestamp at once, see entity.MarkChatReadRequest
+	MarkChatAsRead(ctx context.Context, chatId string, readerId string, req entity.MarkChatReadRequest) (entity.ReadHorizon, error)
+	// MarkDelivered records that the message reached a connection of the recipient and acks it to the sender
+	MarkDelivered(ctx context.Context, messageId string, recipientId string) error
+	PurgeExpiredMessages(ctx context.Context) (int64, error)
+	// DeleteDisappearedMessages deletes the messages past the MessageTTL of their chat and tells
+	// the participants to remove them from view
+	DeleteDisappearedMessages(ctx context.Context) (int64, error)
 }
 
 type messageUsecase struct {
-	messageRepo repository.MessageRepository
-	chatRepo    repository.ChatRepository
-	userRepo    repository.UserRepository
+	messageRepo   repository.MessageRepository
+	receiptRepo   repository.ReceiptRepository
+	chatRepo      repository.ChatRepository
+	userRepo      repository.UserRepository
+	settingsUc    SettingsUsecase
+	planUc        PlanUsecase
+	contentPolicy ContentPolicy
+	moderationUc  ModerationUsecase
+	publisher     EventPublisher
+	bus           EventBus
+	abuseUc       AbuseUsecase
+	commandUc     CommandUsecase
+	transactor    repository.Transactor
+	outbox        MessageOutbox
 }
 
-func NewMessageUseCase(messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository) MessageUsecase {
+func NewMessageUseCase(messageRepo repository.MessageRepository, receiptRepo repository.ReceiptRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, settingsUc SettingsUsecase, planUc PlanUsecase, contentPolicy ContentPolicy, moderationUc ModerationUsecase, publisher EventPublisher, bus EventBus, abuseUc AbuseUsecase, commandUc CommandUsecase, transactor repository.Transactor, outbox MessageOutbox) MessageUsecase {
 	return &messageUsecase{
-		messageRepo: messageRepo,
-		chatRepo:    chatRepo,
-		userRepo:    userRepo,
+		messageRepo:   messageRepo,
+		receiptRepo:   receiptRepo,
+		chatRepo:      chatRepo,
+		userRepo:      userRepo,
+		settingsUc:    settingsUc,
+		planUc:        planUc,
+		contentPolicy: contentPolicy,
+		moderationUc:  moderationUc,
+		publisher:     publisher,
+		bus:           bus,
+		abuseUc:       abuseUc,
+		commandUc:     commandUc,
+		transactor:    transactor,
+		outbox:        outbox,
 	}
 }
 
@@ -42,8 +91,194 @@ func (m *messageUsecase) GetReceiver(ctx context.Context, chatId string) ([]stri
 	return userIds, nil
 }
 
-func (m *messageUsecase) SaveMessage(ctx context.Context, message entity.Message) (string, error) {
-	return m.messageRepo.Create(ctx, message)
+// SaveMessage runs the slash command of the message, if any, then applies the moderation and the
+// content policy of the chat's workspace and stores the message. It returns ErrCommandHandled when a command left
+// nothing to store
+func (m *messageUsecase) SaveMessage(ctx context.Context, message entity.Message) (entity.Message, error) {
+	// A retry is answered with the message stored by the first try, before it counts against the
+	// rate limits
+	if message.ClientMessageId != "" {
+		if len(message.ClientMessageId) > maxClientMessageIdLength {
+			return entity.Message{}, invalidField("clientMessageId", ErrInvalidClientId)
+		}
+		sent, err := m.messageRepo.GetByClientMessageId(ctx, message.ChatId, message.SenderId, message.ClientMessageId)
+		if err == nil {
+			return sent, nil
+		}
+		if !errors.Is(err, repository.ErrMessageNotFound) {
+			return entity.Message{}, err
+		}
+	}
+
+	// Muted, suspended and rate limited accounts are stopped before anything else
+	if err := m.abuseUc.CheckCanSend(ctx, message.SenderId); err != nil {
+		return entity.Message{}, err
+	}
+
+	message, err := m.commandUc.Execute(ctx, message)
+	if err != nil {
+		return entity.Message{}, err
+	}
+
+	chat, err := m.chatRepo.Get(ctx, message.ChatId)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	if chat.Type == entity.ChatTypeChannel {
+		isAdmin, err := m.chatRepo.IsAdmin(ctx, message.SenderId, message.ChatId)
+		if err != nil {
+			return entity.Message{}, err
+		}
+		if !isAdmin {
+			return entity.Message{}, ErrChannelReadOnly
+		}
+	}
+
+	settings, err := m.settingsUc.GetWorkspaceSettings(ctx, chat.WorkspaceId)
+	if err != nil {
+		return entity.Message{}, err
+	}
+
+	sender, err := m.userRepo.Get(ctx, message.SenderId)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	if sender.IsRestricted {
+		settings = settings.ForRestrictedUser()
+	}
+
+	message, verdict, err := m.moderationUc.Moderate(ctx, settings, message)
+	if err != nil {
+		if errors.Is(err, ErrMessageRejected) {
+			if err := m.abuseUc.RecordSignal(ctx, message.SenderId, entity.AbuseSignalModerationHit, message.ChatId); err != nil {
+				slog.ErrorContext(ctx, "Record moderation hit error", "error", err)
+			}
+		}
+		return entity.Message{}, err
+	}
+
+	var attachmentBytes int64
+	for _, attachment := range message.Attachments {
+		if err := m.contentPolicy.CheckAttachment(settings, attachment); err != nil {
+			return entity.Message{}, err
+		}
+		if err := m.planUc.CheckAttachmentSize(ctx, chat.WorkspaceId, attachment.Size); err != nil {
+			return entity.Message{}, err
+		}
+		attachmentBytes += attachment.Size
+	}
+
+	// The clocks of the clients can't be trusted, the message is dated when the server got it
+	now := time.Now()
+	message.Timestamp = now.UnixMilli()
+	if chat.MessageTTL > 0 {
+		expiresAt := now.Add(time.Duration(chat.MessageTTL) * time.Second).UTC()
+		message.ExpiresAt = &expiresAt
+	}
+
+	if message.ReplyToMessageId != "" {
+		original, err := m.messageRepo.Get(ctx, message.ReplyToMessageId)
+		if err != nil {
+			if errors.Is(err, repository.ErrMessageNotFound) {
+				return entity.Message{}, ErrInvalidReply
+			}
+			return entity.Message{}, err
+		}
+		if original.ChatId != message.ChatId {
+			return entity.Message{}, ErrInvalidReply
+		}
+
+		message.ReplyTo = &entity.QuotedMessage{
+			MessageId: original.Id,
+			SenderId:  original.SenderId,
+			Snippet:   snippet(original.Message, quoteSnippetLength),
+		}
+	}
+
+	participants, err := m.chatRepo.GetParticipants(ctx, message.ChatId)
+	if err != nil {
+		return entity.Message{}, err
+	}
+	message.Mentions = mentionedParticipants(message, participants)
+
+	// The recipients the message doesn't notify still get it, it is only left out of their unread badge
+	recipientIds := make([]string, 0, len(participants))
+	var quietIds []string
+	for _, participant := range participants {
+		if participant.UserId == message.SenderId {
+			continue
+		}
+		recipientIds = append(recipientIds, participant.UserId)
+		if !participant.Notifies(message) {
+			quietIds = append(quietIds, participant.UserId)
+		}
+	}
+
+	// The message, its receipts and its fan-out are stored together, so a crash can't leave a
+	// message that is never delivered
+	err = m.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		message, err = m.messageRepo.Create(ctx, message)
+		if err != nil {
+			return err
+		}
+		// Every other participant starts in the sent state, channel posts are not tracked per
+		// member since a channel may have thousands of them
+		broadcast := chat.Type == entity.ChatTypeChannel
+		if !broadcast {
+			if err := m.receiptRepo.CreateSent(ctx, message, recipientIds, quietIds); err != nil {
+				return err
+			}
+		}
+		return m.outbox.EnqueueMessage(ctx, entity.MessageFanout{
+			Message:    message,
+			SenderName: sender.Name,
+			Broadcast:  broadcast,
+		}, recipientIds)
+	})
+	if errors.Is(err, repository.ErrDuplicateMessage) {
+		// A retry sent at the same time as the first try stored it first
+		return m.messageRepo.GetByClientMessageId(ctx, message.ChatId, message.SenderId, message.ClientMessageId)
+	}
+	if err != nil {
+		return entity.Message{}, err
+	}
+	m.outbox.Notify()
+	message.DeliveryState = entity.DeliveryStateSent
+
+	// The message is already delivered, a lost flag only spares it the review
+	if verdict != nil {
+		if err := m.moderationUc.Flag(ctx, message, chat.WorkspaceId, *verdict); err != nil {
+			slog.ErrorContext(ctx, "Flag message error", "message_id", message.Id, "error", err)
+		}
+	}
+
+	// Metering failures must not lose the message
+	if err := m.planUc.RecordUsage(ctx, chat.WorkspaceId, entity.UsageMetricMessages, 1); err != nil {
+		slog.ErrorContext(ctx, "Record message usage error", "error", err)
+	}
+	if attachmentBytes > 0 {
+		if err := m.planUc.RecordUsage(ctx, chat.WorkspaceId, entity.UsageMetricAttachmentBytes, attachmentBytes); err != nil {
+			slog.ErrorContext(ctx, "Record attachment usage error", "error", err)
+		}
+	}
+
+	m.bus.Publish(ctx, entity.EventMessageCreated, message)
+	m.abuseUc.ObserveMessage(ctx, message)
+
+	return message, nil
+}
+
+// mentionedParticipants keeps the mentions of the message that are participants of the chat other
+// than its sender, once each
+func mentionedParticipants(message entity.Message, participants []entity.ChatParticipant) []string {
+	var mentions []string
+	for _, participant := range participants {
+		if participant.UserId != message.SenderId && slices.Contains(message.Mentions, participant.UserId) {
+			mentions = append(mentions, participant.UserId)
+		}
+	}
+	return mentions
 }
 
 func (m *messageUsecase) GetMessagesByChatId(ctx context.Context, chatId string, limit, offset int) ([]entity.Message, error) {
@@ -54,12 +289,207 @@ func (m *messageUsecase) GetMessage(ctx context.Context, messageId string) (enti
 	return m.messageRepo.Get(ctx, messageId)
 }
 
-func (m *messageUsecase) MarkAsRead(ctx context.Context, messageId string) error {
+// MarkAsRead marks a message as read and sends a read receipt to its author
+func (m *messageUsecase) MarkAsRead(ctx context.Context, messageId string, readerId string) error {
 	message, err := m.messageRepo.Get(ctx, messageId)
 	if err != nil {
 		return err
 	}
 
+	if message.SenderId == readerId {
+		return nil
+	}
+
+	isParticipant, err := m.chatRepo.IsParticipant(ctx, readerId, message.ChatId)
+	if err != nil {
+		return err
+	}
+	if !isParticipant {
+		return ErrNotParticipant
+	}
+
 	message.IsRead = true
-	return m.messageRepo.Update(ctx, message)
-}
\ No newline at end of file
+	err = m.messageRepo.Update(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	read, advanced, err := m.receiptRepo.MarkRead(ctx, message.Id, readerId)
+	if err != nil {
+		return err
+	}
+	// Already read, or a channel post that has no receipts, the sender has nothing new to learn
+	if !advanced {
+		return nil
+	}
+
+	receipt := entity.ReadReceipt{
+		ChatId:    message.ChatId,
+		MessageId: message.Id,
+		ReaderId:  readerId,
+		ReadAt:    time.Now(),
+		Quiet:     read.Quiet,
+	}
+	m.publisher.PublishToUsers(ctx, []string{message.SenderId}, entity.EventMessageRead, receipt)
+	m.bus.Publish(ctx, entity.EventMessageRead, receipt)
+
+	return nil
+}
+
+// MarkChatAsRead marks every message of the chat up to the horizon as read with a single update,
+// then tells the other participants where the reader stopped instead of acking every message
+func (m *messageUsecase) MarkChatAsRead(ctx context.Context, chatId string, readerId string, req entity.MarkChatReadRequest) (entity.ReadHorizon, error) {
+	isParticipant, err := m.chatRepo.IsParticipant(ctx, readerId, chatId)
+	if err != nil {
+		return entity.ReadHorizon{}, err
+	}
+	if !isParticipant {
+		return entity.ReadHorizon{}, ErrNotParticipant
+	}
+
+	now := time.Now()
+	upTo := now.UnixMilli()
+	switch {
+	case req.MessageId != "":
+		message, err := m.messageRepo.Get(ctx, req.MessageId)
+		if err != nil {
+			if errors.Is(err, repository.ErrMessageNotFound) {
+				return entity.ReadHorizon{}, ErrMessageNotFound
+			}
+			return entity.ReadHorizon{}, err
+		}
+		if message.ChatId != chatId {
+			return entity.ReadHorizon{}, ErrMessageNotFound
+		}
+		upTo = message.Timestamp
+	case req.UpTo < 0:
+		return entity.ReadHorizon{}, invalidField("upTo", ErrInvalidReadHorizon)
+	case req.UpTo > 0 && req.UpTo < upTo:
+		// Later horizons would read the messages sent after the request
+		upTo = req.UpTo
+	}
+
+	read, err := m.receiptRepo.MarkChatRead(ctx, chatId, readerId, upTo)
+	if err != nil {
+		return entity.ReadHorizon{}, err
+	}
+
+	horizon := entity.ReadHorizon{
+		ChatId:   chatId,
+		ReaderId: readerId,
+		ReadUpTo: upTo,
+		ReadAt:   now,
+	}
+	if read == 0 {
+		return horizon, nil
+	}
+
+	participantIds, err := m.GetReceiver(ctx, chatId)
+	if err != nil {
+		return entity.ReadHorizon{}, err
+	}
+	others := make([]string, 0, len(participantIds))
+	for _, userId := range participantIds {
+		if userId != readerId {
+			others = append(others, userId)
+		}
+	}
+
+	m.publisher.PublishToUsers(ctx, others, entity.EventChatRead, horizon)
+	m.bus.Publish(ctx, entity.EventChatRead, horizon)
+
+	return horizon, nil
+}
+
+// MarkDelivered only succeeds for recipients that have a receipt, so it needs no participation check.
+// The sender is acked once per recipient, the first time the message reaches them
+func (m *messageUsecase) MarkDelivered(ctx context.Context, messageId string, recipientId string) error {
+	receipt, advanced, err := m.receiptRepo.MarkDelivered(ctx, messageId, recipientId)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return nil
+	}
+
+	ack := entity.DeliveryReceipt{
+		ChatId:      receipt.ChatId,
+		MessageId:   receipt.MessageId,
+		RecipientId: receipt.UserId,
+		DeliveredAt: *receipt.DeliveredAt,
+	}
+	m.publisher.PublishToUsers(ctx, []string{receipt.SenderId}, entity.EventMessageDelivered, ack)
+
+	return nil
+}
+
+// PurgeExpiredMessages deletes messages older than the retention period of their workspace
+func (m *messageUsecase) PurgeExpiredMessages(ctx context.Context) (int64, error) {
+	workspaces, err := m.settingsUc.GetWorkspacesWithRetention(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, settings := range workspaces {
+		chatIds, err := m.chatRepo.GetIdsByWorkspace(ctx, settings.WorkspaceId)
+		if err != nil {
+			return deleted, err
+		}
+		if len(chatIds) == 0 {
+			continue
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -settings.RetentionDays).UnixMilli()
+		count, err := m.messageRepo.DeleteOlderThan(ctx, chatIds, cutoff)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+
+		if err := m.chatRepo.UnpinOlderThan(ctx, chatIds, cutoff); err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *messageUsecase) DeleteDisappearedMessages(ctx context.Context) (int64, error) {
+	messages, err := m.messageRepo.DeleteExpired(ctx, time.Now(), disappearedBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := map[string][]string{}
+	for _, message := range messages {
+		expired[message.ChatId] = append(expired[message.ChatId], message.Id)
+	}
+	for chatId, messageIds := range expired {
+		if err := m.chatRepo.UnpinMessages(ctx, chatId, messageIds); err != nil {
+			slog.ErrorContext(ctx, "Unpin expired messages error", "chat_id", chatId, "error", err)
+		}
+
+		receivers, err := m.GetReceiver(ctx, chatId)
+		if err != nil {
+			// The clients still drop the messages once they are past their expiresAt
+			slog.ErrorContext(ctx, "Get receivers of expired messages error", "chat_id", chatId, "error", err)
+			continue
+		}
+		m.publisher.PublishToUsers(ctx, receivers, entity.EventMessagesExpired, entity.MessagesExpired{
+			ChatId:     chatId,
+			MessageIds: messageIds,
+		})
+	}
+
+	return int64(len(messages)), nil
+}
+
+// snippet shortens text to at most n characters
+func snippet(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
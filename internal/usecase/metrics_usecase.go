@@ -0,0 +1,208 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/metrics"
+)
+
+var (
+	ErrInvalidPeriod = errors.New("period must look like 2006-01")
+)
+
+// MetricsUsecase tags the key metrics with the workspace they belong to, they are
+// scraped by Prometheus and reported per tenant for chargeback and capacity planning
+type MetricsUsecase interface {
+	RecordUsage(workspaceId string, metric string, delta int64)
+	ConnectionOpened(workspaceId string)
+	ConnectionClosed(workspaceId string)
+	// RecordError counts a failure served to a client, source tells the transport it happened on
+	RecordError(source string)
+	// RecordCancellation counts a request abandoned by its client before it was answered, apart from the errors
+	RecordCancellation(source string)
+	// Counters reads the live counters of this server for the admin dashboard
+	Counters() entity.MetricsCounters
+	// RefreshStorage measures what the messages of every workspace take in the database
+	RefreshStorage(ctx context.Context) error
+
+	GetTenantUsage(ctx context.Context, workspaceId string, period string) (entity.TenantUsage, error)
+	ListTenantUsage(ctx context.Context, period string) ([]entity.TenantUsage, error)
+}
+
+type metricsUsecase struct {
+	messageRepo repository.MessageRepository
+	planRepo    repository.PlanRepository
+
+	usage             map[string]*metrics.Vec
+	activeConnections *metrics.Vec
+	storageBytes      *metrics.Vec
+	storedMessages    *metrics.Vec
+	errors            *metrics.Vec
+	cancellations     *metrics.Vec
+
+	mu                sync.RWMutex
+	storageMeasuredAt *time.Time
+}
+
+func NewMetricsUsecase(registry *metrics.Registry, messageRepo repository.MessageRepository, planRepo repository.PlanRepository) MetricsUsecase {
+	return &metricsUsecase{
+		messageRepo: messageRepo,
+		planRepo:    planRepo,
+		usage: map[string]*metrics.Vec{
+			entity.UsageMetricMessages:        registry.NewCounter("wetalk_messages_total", "Messages sent.", "workspace"),
+			entity.UsageMetricAttachmentBytes: registry.NewCounter("wetalk_attachment_bytes_total", "Bytes of attachments sent.", "workspace"),
+		},
+		activeConnections: registry.NewGauge("wetalk_active_connections", "Open websocket and event stream connections on this server.", "workspace"),
+		storageBytes:      registry.NewGauge("wetalk_storage_bytes", "Bytes taken by the stored messages, attachments included.", "workspace"),
+		storedMessages:    registry.NewGauge("wetalk_stored_messages", "Messages stored.", "workspace"),
+		errors:            registry.NewCounter("wetalk_errors_total", "Server errors returned to clients.", "source"),
+		cancellations:     registry.NewCounter("wetalk_canceled_requests_total", "Requests abandoned by their client before they were answered.", "source"),
+	}
+}
+
+func (m *metricsUsecase) RecordUsage(workspaceId string, metric string, delta int64) {
+	if vec, ok := m.usage[metric]; ok {
+		vec.Add(float64(delta), workspaceOrDefault(workspaceId))
+	}
+}
+
+func (m *metricsUsecase) ConnectionOpened(workspaceId string) {
+	m.activeConnections.Inc(workspaceOrDefault(workspaceId))
+}
+
+func (m *metricsUsecase) ConnectionClosed(workspaceId string) {
+	m.activeConnections.Dec(workspaceOrDefault(workspaceId))
+}
+
+func (m *metricsUsecase) RecordError(source string) {
+	m.errors.Inc(source)
+}
+
+func (m *metricsUsecase) RecordCancellation(source string) {
+	m.cancellations.Inc(source)
+}
+
+func (m *metricsUsecase) Counters() entity.MetricsCounters {
+	return entity.MetricsCounters{
+		At:          time.Now(),
+		Connections: m.activeConnections.Total(),
+		Messages:    m.usage[entity.UsageMetricMessages].Total(),
+		Errors:      m.errors.Total(),
+	}
+}
+
+func (m *metricsUsecase) RefreshStorage(ctx context.Context) error {
+	storage, err := m.messageRepo.GetStorageByWorkspace(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, workspace := range storage {
+		workspaceId := workspaceOrDefault(workspace.WorkspaceId)
+		m.storageBytes.Set(float64(workspace.Bytes), workspaceId)
+		m.storedMessages.Set(float64(workspace.Messages), workspaceId)
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	m.storageMeasuredAt = &now
+	m.mu.Unlock()
+
+	return nil
+}
+
+// GetTenantUsage returns the metered usage of a workspace for a billing period, the current one when empty
+func (m *metricsUsecase) GetTenantUsage(ctx context.Context, workspaceId string, period string) (entity.TenantUsage, error) {
+	period, err := usagePeriod(period)
+	if err != nil {
+		return entity.TenantUsage{}, err
+	}
+
+	workspaceId = workspaceOrDefault(workspaceId)
+	records, err := m.planRepo.GetUsage(ctx, workspaceId, period)
+	if err != nil {
+		return entity.TenantUsage{}, err
+	}
+
+	usage := m.tenantUsage(workspaceId, period)
+	for _, record := range records {
+		usage.Usage[record.Metric] = record.Value
+	}
+
+	return usage, nil
+}
+
+// ListTenantUsage returns the usage of every workspace that was metered in the period or holds data
+func (m *metricsUsecase) ListTenantUsage(ctx context.Context, period string) ([]entity.TenantUsage, error) {
+	period, err := usagePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := m.planRepo.GetUsageByPeriod(ctx, period)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]entity.TenantUsage)
+	for _, record := range records {
+		workspaceId := workspaceOrDefault(record.WorkspaceId)
+		tenant, ok := tenants[workspaceId]
+		if !ok {
+			tenant = m.tenantUsage(workspaceId, period)
+		}
+		tenant.Usage[record.Metric] = record.Value
+		tenants[workspaceId] = tenant
+	}
+
+	// Workspaces that only hold data or connections this period are reported too
+	for _, values := range []map[string]float64{m.storageBytes.Values("workspace"), m.activeConnections.Values("workspace")} {
+		for workspaceId := range values {
+			if _, ok := tenants[workspaceId]; !ok {
+				tenants[workspaceId] = m.tenantUsage(workspaceId, period)
+			}
+		}
+	}
+
+	usage := make([]entity.TenantUsage, 0, len(tenants))
+	for _, tenant := range tenants {
+		usage = append(usage, tenant)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].WorkspaceId < usage[j].WorkspaceId
+	})
+
+	return usage, nil
+}
+
+// tenantUsage fills the storage and connections of a workspace from the live metrics
+func (m *metricsUsecase) tenantUsage(workspaceId string, period string) entity.TenantUsage {
+	m.mu.RLock()
+	measuredAt := m.storageMeasuredAt
+	m.mu.RUnlock()
+
+	return entity.TenantUsage{
+		WorkspaceId:       workspaceId,
+		Period:            period,
+		Usage:             make(map[string]int64),
+		StoredMessages:    int64(m.storedMessages.Value(workspaceId)),
+		StorageBytes:      int64(m.storageBytes.Value(workspaceId)),
+		StorageMeasuredAt: measuredAt,
+		ActiveConnections: int64(m.activeConnections.Value(workspaceId)),
+	}
+}
+
+func usagePeriod(period string) (string, error) {
+	if period == "" {
+		return currentPeriod(), nil
+	}
+	if _, err := time.Parse("2006-01", period); err != nil {
+		return "", invalidField("period", ErrInvalidPeriod)
+	}
+	return period, nil
+}
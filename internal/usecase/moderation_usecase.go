@@ -0,0 +1,188 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrModerationUnavailable = errors.New("message moderation is unavailable, try again later")
+)
+
+// Moderator inspects the text of a message before it is stored, see NewKeywordModerator for the
+// built-in word lists and pkg/moderation for an external HTTP moderation service
+type Moderator interface {
+	// Name identifies the moderator in the verdicts and in the logs
+	Name() string
+	Moderate(ctx context.Context, settings entity.WorkspaceSettings, message entity.Message) (entity.ModerationVerdict, error)
+}
+
+// defaultProfanity is the built-in list enforced by the workspaces blocking profanity
+var defaultProfanity = []string{
+	"asshole", "bastard", "bitch", "bullshit", "cunt", "dickhead", "fuck", "fucking", "motherfucker", "shit",
+}
+
+type keywordModerator struct{}
+
+// NewKeywordModerator returns the Moderator matching the blocked words of the workspace, and the
+// built-in profanity list when it is enabled, case-insensitively on word boundaries
+func NewKeywordModerator() Moderator {
+	return &keywordModerator{}
+}
+
+func (m *keywordModerator) Name() string {
+	return "keywords"
+}
+
+func (m *keywordModerator) Moderate(ctx context.Context, settings entity.WorkspaceSettings, message entity.Message) (entity.ModerationVerdict, error) {
+	var verdict entity.ModerationVerdict
+	match := func(words []string, category string) {
+		for _, word := range words {
+			if word == "" {
+				continue
+			}
+			found := wordPattern(word).FindAllString(message.Message, -1)
+			if len(found) == 0 {
+				continue
+			}
+			if !slices.Contains(verdict.Categories, category) {
+				verdict.Categories = append(verdict.Categories, category)
+			}
+			verdict.Matches = append(verdict.Matches, found...)
+		}
+	}
+
+	match(settings.Moderation.BlockedWords, entity.ModerationCategoryKeyword)
+	if settings.Moderation.BlockProfanity {
+		match(defaultProfanity, entity.ModerationCategoryProfanity)
+	}
+	return verdict, nil
+}
+
+// wordPattern matches the text case-insensitively, on word boundaries at the ends that are
+// letters or digits so that a word is not found inside another
+func wordPattern(text string) *regexp.Regexp {
+	pattern := `(?i)` + regexp.QuoteMeta(text)
+	if first, _ := utf8.DecodeRuneInString(text); isWordRune(first) {
+		pattern = `\b` + pattern
+	}
+	if last, _ := utf8.DecodeLastRuneInString(text); isWordRune(last) {
+		pattern += `\b`
+	}
+	return regexp.MustCompile(pattern)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// ModerationPolicy is what the server does when a moderator can't be reached
+type ModerationPolicy struct {
+	// FailOpen stores the messages a failing moderator could not inspect, otherwise they are
+	// refused with ErrModerationUnavailable
+	FailOpen bool
+}
+
+// ModerationUsecase runs the moderators on the messages before they are stored and applies the
+// moderation action of the workspace to what they find
+type ModerationUsecase interface {
+	// Moderate returns the message to store, its text masked with the mask action, or
+	// ErrMessageRejected. The verdict is returned when the message must be flagged once stored
+	Moderate(ctx context.Context, settings entity.WorkspaceSettings, message entity.Message) (entity.Message, *entity.ModerationVerdict, error)
+	// Flag queues a stored message for an admin review
+	Flag(ctx context.Context, message entity.Message, workspaceId string, verdict entity.ModerationVerdict) error
+}
+
+type moderationUsecase struct {
+	moderators  []Moderator
+	flaggedRepo repository.FlaggedMessageRepository
+	policy      ModerationPolicy
+}
+
+// NewModerationUsecase runs the moderators in order, a message is clean when none of them flags it
+func NewModerationUsecase(moderators []Moderator, flaggedRepo repository.FlaggedMessageRepository, policy ModerationPolicy) ModerationUsecase {
+	return &moderationUsecase{
+		moderators:  moderators,
+		flaggedRepo: flaggedRepo,
+		policy:      policy,
+	}
+}
+
+func (m *moderationUsecase) Moderate(ctx context.Context, settings entity.WorkspaceSettings, message entity.Message) (entity.Message, *entity.ModerationVerdict, error) {
+	if message.Message == "" {
+		return message, nil, nil
+	}
+
+	var verdict entity.ModerationVerdict
+	for _, moderator := range m.moderators {
+		found, err := moderator.Moderate(ctx, settings, message)
+		if err != nil {
+			if !m.policy.FailOpen {
+				return entity.Message{}, nil, fmt.Errorf("%s moderator: %w", moderator.Name(), errors.Join(ErrModerationUnavailable, err))
+			}
+			slog.WarnContext(ctx, "Moderator failed, the message is stored unchecked", "moderator", moderator.Name(), "error", err)
+			continue
+		}
+		if !found.Flagged() {
+			continue
+		}
+
+		verdict.Moderators = append(verdict.Moderators, moderator.Name())
+		for _, category := range found.Categories {
+			if !slices.Contains(verdict.Categories, category) {
+				verdict.Categories = append(verdict.Categories, category)
+			}
+		}
+		verdict.Matches = append(verdict.Matches, found.Matches...)
+	}
+
+	if !verdict.Flagged() {
+		return message, nil, nil
+	}
+
+	switch settings.Moderation.Action {
+	case entity.ModerationActionFlag:
+		return message, &verdict, nil
+	case entity.ModerationActionMask:
+		// A verdict on the whole text leaves nothing to mask
+		if len(verdict.Matches) > 0 {
+			message.Message = maskMatches(message.Message, verdict.Matches)
+			return message, nil, nil
+		}
+	}
+	return entity.Message{}, nil, ErrMessageRejected
+}
+
+// maskMatches replaces every occurrence of the matches with asterisks
+func maskMatches(text string, matches []string) string {
+	for _, match := range matches {
+		if match == "" {
+			continue
+		}
+		text = wordPattern(match).ReplaceAllStringFunc(text, func(found string) string {
+			return strings.Repeat("*", len(found))
+		})
+	}
+	return text
+}
+
+func (m *moderationUsecase) Flag(ctx context.Context, message entity.Message, workspaceId string, verdict entity.ModerationVerdict) error {
+	_, err := m.flaggedRepo.Create(ctx, entity.FlaggedMessage{
+		MessageId:   message.Id,
+		ChatId:      message.ChatId,
+		SenderId:    message.SenderId,
+		WorkspaceId: workspaceId,
+		Text:        message.Message,
+		Verdict:     verdict,
+	})
+	return err
+}
@@ -0,0 +1,315 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/logger"
+	"wetalk/pkg/push"
+)
+
+var (
+	ErrInvalidDevice  = errors.New("platform must be android, ios or web and pushToken is required")
+	ErrDeviceNotFound = errors.New("device not found")
+)
+
+const (
+	// notificationSnippetLength is how much of the message is shown in a push notification
+	notificationSnippetLength = 100
+	// hiddenPreviewBody replaces the message text for users that turned previews off
+	hiddenPreviewBody = "New message"
+)
+
+// Notifier delivers a push notification to a device, see pkg/push for the FCM, APNs and webhook implementations
+type Notifier interface {
+	Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error
+}
+
+// PresenceChecker tells whether a user holds a live connection, the websocket hub implements it
+type PresenceChecker interface {
+	IsConnected(userId string) bool
+}
+
+type logNotifier struct{}
+
+// NewLogNotifier only logs the notifications, it is used until a push provider is configured
+func NewLogNotifier() Notifier {
+	return logNotifier{}
+}
+
+func (logNotifier) Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error {
+	slog.InfoContext(ctx, "Push notification", "message_id", notification.MessageId, "platform", device.Platform, "device_id", device.Id)
+	return nil
+}
+
+// NotificationPolicy holds the timings of the push notification deduplication
+type NotificationPolicy struct {
+	// AckWindow is how long a connected device has to ack a message before it is pushed
+	AckWindow time.Duration
+	// DedupTTL is how long a push is remembered so it is never sent twice to a device
+	DedupTTL time.Duration
+	// QueueSize bounds the pushes waiting for a worker, pushes are dropped once it is full
+	QueueSize int
+	// Workers is the number of pushes sent concurrently
+	Workers int
+}
+
+func DefaultNotificationPolicy() NotificationPolicy {
+	return NotificationPolicy{
+		AckWindow: 5 * time.Second,
+		DedupTTL:  24 * time.Hour,
+		QueueSize: 1024,
+		Workers:   4,
+	}
+}
+
+// pushJob is a notification waiting to be sent to one device
+type pushJob struct {
+	device       entity.Device
+	notification entity.PushNotification
+}
+
+// NotificationUsecase pushes new messages to the registered devices of recipients that did not get them in real time
+type NotificationUsecase interface {
+	RegisterDevice(ctx context.Context, userId string, req entity.RegisterDeviceRequest) (entity.Device, error)
+	GetDevices(ctx context.Context, userId string) ([]entity.Device, error)
+	RemoveDevice(ctx context.Context, userId string, deviceId string) error
+	// AckDelivery records that a connected device of the user received the message
+	AckDelivery(ctx context.Context, userId string, messageId string) error
+	GetPreferences(ctx context.Context, userId string) (entity.NotificationPreferences, error)
+	UpdatePreferences(ctx context.Context, userId string, req entity.UpdateNotificationPreferencesRequest) (entity.NotificationPreferences, error)
+	Subscribe(bus EventBus)
+	// Run sends the queued pushes until the process exits
+	Run()
+}
+
+type notificationUsecase struct {
+	deviceRepo      repository.DeviceRepository
+	dedupRepo       repository.NotificationDedupRepository
+	preferencesRepo repository.NotificationPreferencesRepository
+	chatRepo        repository.ChatRepository
+	userRepo        repository.UserRepository
+	presence        PresenceChecker
+	notifier        Notifier
+	deliveryHealth  DeliveryHealthUsecase
+	policy          NotificationPolicy
+	queue           chan pushJob
+}
+
+func NewNotificationUsecase(deviceRepo repository.DeviceRepository, dedupRepo repository.NotificationDedupRepository, preferencesRepo repository.NotificationPreferencesRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, presence PresenceChecker, notifier Notifier, deliveryHealth DeliveryHealthUsecase, policy NotificationPolicy) NotificationUsecase {
+	return &notificationUsecase{
+		deviceRepo:      deviceRepo,
+		dedupRepo:       dedupRepo,
+		preferencesRepo: preferencesRepo,
+		chatRepo:        chatRepo,
+		userRepo:        userRepo,
+		presence:        presence,
+		notifier:        notifier,
+		deliveryHealth:  deliveryHealth,
+		policy:          policy,
+		queue:           make(chan pushJob, policy.QueueSize),
+	}
+}
+
+func (n *notificationUsecase) RegisterDevice(ctx context.Context, userId string, req entity.RegisterDeviceRequest) (entity.Device, error) {
+	switch req.Platform {
+	case entity.DevicePlatformAndroid, entity.DevicePlatformIOS, entity.DevicePlatformWeb:
+	default:
+		return entity.Device{}, invalidField("platform", ErrInvalidDevice)
+	}
+	if req.PushToken == "" {
+		return entity.Device{}, invalidField("pushToken", ErrInvalidDevice)
+	}
+
+	return n.deviceRepo.Upsert(ctx, entity.Device{
+		UserId:    userId,
+		Platform:  req.Platform,
+		PushToken: req.PushToken,
+	})
+}
+
+func (n *notificationUsecase) GetDevices(ctx context.Context, userId string) ([]entity.Device, error) {
+	return n.deviceRepo.GetByUser(ctx, userId)
+}
+
+func (n *notificationUsecase) RemoveDevice(ctx context.Context, userId string, deviceId string) error {
+	err := n.deviceRepo.Delete(ctx, deviceId, userId)
+	if errors.Is(err, repository.ErrDeviceNotFound) {
+		return ErrDeviceNotFound
+	}
+	return err
+}
+
+func (n *notificationUsecase) AckDelivery(ctx context.Context, userId string, messageId string) error {
+	return n.dedupRepo.MarkAcked(ctx, userId, messageId, n.policy.DedupTTL)
+}
+
+func (n *notificationUsecase) GetPreferences(ctx context.Context, userId string) (entity.NotificationPreferences, error) {
+	preferences, err := n.preferencesRepo.Get(ctx, userId)
+	if errors.Is(err, repository.ErrNotificationPreferencesNotFound) {
+		return entity.DefaultNotificationPreferences(userId), nil
+	}
+	if err != nil {
+		return entity.NotificationPreferences{}, err
+	}
+
+	return preferences, nil
+}
+
+func (n *notificationUsecase) UpdatePreferences(ctx context.Context, userId string, req entity.UpdateNotificationPreferencesRequest) (entity.NotificationPreferences, error) {
+	preferences := entity.NotificationPreferences{
+		UserId:      userId,
+		Enabled:     req.Enabled,
+		ShowPreview: req.ShowPreview,
+	}
+	if err := n.preferencesRepo.Upsert(ctx, preferences); err != nil {
+		return entity.NotificationPreferences{}, err
+	}
+
+	return n.GetPreferences(ctx, userId)
+}
+
+func (n *notificationUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventMessageCreated, n.onMessageCreated)
+}
+
+// onMessageCreated gives the connected devices the ack window before pushing to the others
+func (n *notificationUsecase) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok || message.IsAutoReply || message.IsSystem() {
+		return
+	}
+
+	// The request that saved the message is long gone when the window ends
+	pushCtx := context.WithoutCancel(ctx)
+	time.AfterFunc(n.policy.AckWindow, func() {
+		ctx, cancel := context.WithTimeout(pushCtx, time.Minute)
+		defer cancel()
+
+		if err := n.pushMessage(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "Push message error", "message_id", message.Id, "error", err)
+		}
+	})
+}
+
+func (n *notificationUsecase) pushMessage(ctx context.Context, message entity.Message) error {
+	participants, err := n.chatRepo.GetParticipants(ctx, message.ChatId)
+	if err != nil {
+		return err
+	}
+
+	sender, err := n.userRepo.Get(ctx, message.SenderId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, participant := range participants {
+		if participant.UserId == message.SenderId || participant.IsMutedAt(now) || !participant.Notifies(message) {
+			continue
+		}
+
+		acked, err := n.dedupRepo.IsAcked(ctx, participant.UserId, message.Id)
+		if err != nil {
+			slog.ErrorContext(ctx, "Check delivery ack error", "error", err)
+			continue
+		}
+		if acked {
+			continue
+		}
+
+		// Online recipients get the message over their connection
+		if n.presence.IsConnected(participant.UserId) {
+			continue
+		}
+
+		preferences, err := n.GetPreferences(ctx, participant.UserId)
+		if err != nil {
+			slog.ErrorContext(ctx, "Get notification preferences error", "error", err)
+			continue
+		}
+		if !preferences.Enabled {
+			continue
+		}
+
+		body := snippet(message.Message, notificationSnippetLength)
+		if body == "" && len(message.Attachments) > 0 {
+			body = "Sent an attachment"
+		}
+		if !preferences.ShowPreview {
+			body = hiddenPreviewBody
+		}
+
+		notification := entity.PushNotification{
+			UserId:    participant.UserId,
+			ChatId:    message.ChatId,
+			MessageId: message.Id,
+			Title:     sender.Name,
+			Body:      body,
+		}
+		n.pushToDevices(ctx, notification)
+	}
+
+	return nil
+}
+
+// pushToDevices queues the notification once per device, whichever server claims it first sends it
+func (n *notificationUsecase) pushToDevices(ctx context.Context, notification entity.PushNotification) {
+	devices, err := n.deviceRepo.GetByUser(ctx, notification.UserId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get devices error", "error", err)
+		return
+	}
+
+	for _, device := range devices {
+		if !device.IsActive() {
+			continue
+		}
+		claimed, err := n.dedupRepo.ClaimPush(ctx, device.Id, notification.MessageId, n.policy.DedupTTL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Claim push error", "error", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		select {
+		case n.queue <- pushJob{device: device, notification: notification}:
+		default:
+			slog.WarnContext(ctx, "Push queue is full, dropping notification", "message_id", notification.MessageId, "device_id", device.Id)
+		}
+	}
+}
+
+func (n *notificationUsecase) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < n.policy.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range n.queue {
+				n.send(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// send delivers a queued push, the failures are tracked so the devices that keep failing and the
+// ones the provider no longer knows are deactivated
+func (n *notificationUsecase) send(job pushJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctx = logger.With(ctx, slog.String("message_id", job.notification.MessageId), slog.String("device_id", job.device.Id))
+
+	err := n.notifier.Send(ctx, job.device, job.notification)
+	if err != nil && !errors.Is(err, push.ErrUnregisteredDevice) {
+		slog.ErrorContext(ctx, "Send push notification error", "error", err)
+	}
+	n.deliveryHealth.RecordPush(ctx, job.device, err)
+}
@@ -0,0 +1,227 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+const (
+	// outboxReplayDelay leaves time for the server that wrote an event to deliver it itself
+	outboxReplayDelay = 30 * time.Second
+	// outboxLease is how long a claimed event is hidden from other servers
+	outboxLease = time.Minute
+	// outboxSentRetention is how long delivered events are kept
+	outboxSentRetention = 24 * time.Hour
+	// outboxDispatchInterval is how often the dispatcher looks for message fan-outs written by
+	// other servers or left behind by a crash
+	outboxDispatchInterval = time.Second
+	// outboxDispatchWorkers is the number of message fan-outs a server delivers at once
+	outboxDispatchWorkers = 8
+	// outboxWorkerQueueSize bounds the claimed fan-outs waiting for a worker, claiming stops while
+	// the queue is full so they are delivered well within their lease
+	outboxWorkerQueueSize = 16
+)
+
+// outboxDelivery is a claimed message fan-out waiting for a worker
+type outboxDelivery struct {
+	event  entity.OutboxEvent
+	fanout entity.MessageFanout
+}
+
+// MessageOutbox stores the fan-out of new messages, EnqueueMessage is meant to run in the
+// transaction that stores the message and Notify once it is committed
+type MessageOutbox interface {
+	EnqueueMessage(ctx context.Context, fanout entity.MessageFanout, recipientIds []string) error
+	Notify()
+}
+
+// MessageDeliverer hands a new message to the connections of its recipients at once and acks the
+// delivery to the ones a connection took it for. The websocket delivery implements it
+type MessageDeliverer interface {
+	DeliverMessage(ctx context.Context, recipientIds []string, fanout entity.MessageFanout)
+}
+
+// OutboxUsecase is an EventPublisher that persists every event before handing it to the hub,
+// events that were never handed over are replayed by ReplayPending. Message fan-outs are only
+// delivered by DispatchMessages
+type OutboxUsecase interface {
+	EventPublisher
+	MessageOutbox
+	ReplayPending(ctx context.Context) (int, error)
+	DispatchMessages(ctx context.Context, deliverer MessageDeliverer)
+	PurgeSent(ctx context.Context) (int64, error)
+}
+
+type outboxUsecase struct {
+	outboxRepo repository.OutboxRepository
+	publisher  EventPublisher
+	wake       chan struct{}
+}
+
+func NewOutboxUsecase(outboxRepo repository.OutboxRepository, publisher EventPublisher) OutboxUsecase {
+	return &outboxUsecase{
+		outboxRepo: outboxRepo,
+		publisher:  publisher,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (o *outboxUsecase) PublishToUsers(ctx context.Context, userIds []string, eventType string, data any) {
+	if len(userIds) == 0 {
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		slog.ErrorContext(ctx, "Marshal outbox event error", "event", eventType, "error", err)
+		return
+	}
+
+	event, err := o.outboxRepo.Create(ctx, entity.OutboxEvent{
+		Recipients: userIds,
+		Type:       eventType,
+		Data:       string(encoded),
+	})
+	if err != nil {
+		// Still deliver it, it just won't be replayed
+		slog.ErrorContext(ctx, "Store outbox event error", "event", eventType, "error", err)
+		o.publisher.PublishToUsers(ctx, userIds, eventType, json.RawMessage(encoded))
+		return
+	}
+
+	o.deliver(ctx, event)
+}
+
+// ReplayPending delivers the events that were stored but never marked as sent
+func (o *outboxUsecase) ReplayPending(ctx context.Context) (int, error) {
+	replayed := 0
+	createdBefore := time.Now().Add(-outboxReplayDelay)
+
+	for {
+		event, ok, err := o.outboxRepo.ClaimPending(ctx, entity.OutboxKindEvent, createdBefore, outboxLease)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			return replayed, nil
+		}
+
+		o.deliver(ctx, event)
+		replayed++
+	}
+}
+
+// EnqueueMessage stores the fan-out of a message to its recipients
+func (o *outboxUsecase) EnqueueMessage(ctx context.Context, fanout entity.MessageFanout, recipientIds []string) error {
+	if len(recipientIds) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(fanout)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.outboxRepo.Create(ctx, entity.OutboxEvent{
+		Kind:       entity.OutboxKindMessage,
+		Recipients: recipientIds,
+		Data:       string(encoded),
+	})
+	return err
+}
+
+// Notify wakes the dispatcher of this server up, it never blocks
+func (o *outboxUsecase) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// DispatchMessages delivers the pending message fan-outs until the context is done. Every server
+// runs it, a fan-out is claimed by one of them at a time and claimed again once its lease expires
+// if that server died before marking it as sent. The fan-outs are delivered by a pool of workers,
+// the ones of a chat always by the same worker so its messages arrive in order
+func (o *outboxUsecase) DispatchMessages(ctx context.Context, deliverer MessageDeliverer) {
+	queues := make([]chan outboxDelivery, outboxDispatchWorkers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan outboxDelivery, outboxWorkerQueueSize)
+		wg.Add(1)
+		go func(queue chan outboxDelivery) {
+			defer wg.Done()
+			for delivery := range queue {
+				deliverer.DeliverMessage(ctx, delivery.event.Recipients, delivery.fanout)
+				o.markSent(ctx, delivery.event)
+			}
+		}(queues[i])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	ticker := time.NewTicker(outboxDispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+
+		for {
+			event, ok, err := o.outboxRepo.ClaimPending(ctx, entity.OutboxKindMessage, time.Now(), outboxLease)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.ErrorContext(ctx, "Claim outbox message error", "error", err)
+				}
+				break
+			}
+			if !ok {
+				break
+			}
+
+			var fanout entity.MessageFanout
+			if err := json.Unmarshal([]byte(event.Data), &fanout); err != nil {
+				// It will never decode, don't claim it again
+				slog.ErrorContext(ctx, "Decode outbox message error", "outbox_event_id", event.Id, "error", err)
+				o.markSent(ctx, event)
+				continue
+			}
+			queues[dispatchWorker(fanout.Message.ChatId, len(queues))] <- outboxDelivery{event: event, fanout: fanout}
+		}
+	}
+}
+
+// dispatchWorker picks the worker delivering the fan-outs of a chat
+func dispatchWorker(chatId string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(chatId))
+	return int(h.Sum32() % uint32(workers))
+}
+
+func (o *outboxUsecase) PurgeSent(ctx context.Context) (int64, error) {
+	return o.outboxRepo.DeleteSentBefore(ctx, time.Now().Add(-outboxSentRetention))
+}
+
+func (o *outboxUsecase) deliver(ctx context.Context, event entity.OutboxEvent) {
+	o.publisher.PublishToUsers(ctx, event.Recipients, event.Type, json.RawMessage(event.Data))
+	o.markSent(ctx, event)
+}
+
+func (o *outboxUsecase) markSent(ctx context.Context, event entity.OutboxEvent) {
+	if err := o.outboxRepo.MarkSent(ctx, event.Id); err != nil {
+		slog.ErrorContext(ctx, "Mark outbox event as sent error", "outbox_event_id", event.Id, "error", err)
+	}
+}
@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrInvalidPlan         = errors.New("invalid plan")
+	ErrGroupSizeLimit      = errors.New("group size exceeds the plan limit")
+	ErrAttachmentSizeLimit = errors.New("attachment size exceeds the plan limit")
+)
+
+type PlanUsecase interface {
+	GetWorkspacePlan(ctx context.Context, workspaceId string) (entity.WorkspacePlan, error)
+	GetUserWorkspacePlan(ctx context.Context, userId string) (entity.WorkspacePlan, error)
+	AssignPlan(ctx context.Context, adminId string, workspaceId string, plan string) (entity.WorkspacePlan, error)
+
+	// Metering
+	RecordUsage(ctx context.Context, workspaceId string, metric string, delta int64) error
+
+	// Enforcement hooks
+	CheckGroupSize(ctx context.Context, workspaceId string, size int) error
+	CheckAttachmentSize(ctx context.Context, workspaceId string, size int64) error
+	HistoryCutoff(ctx context.Context, workspaceId string) (int64, error)
+}
+
+type planUsecase struct {
+	planRepo  repository.PlanRepository
+	userRepo  repository.UserRepository
+	metricsUc MetricsUsecase
+}
+
+func NewPlanUsecase(planRepo repository.PlanRepository, userRepo repository.UserRepository, metricsUc MetricsUsecase) PlanUsecase {
+	return &planUsecase{
+		planRepo:  planRepo,
+		userRepo:  userRepo,
+		metricsUc: metricsUc,
+	}
+}
+
+// GetWorkspacePlan returns the plan, limits and current usage of a workspace
+func (p *planUsecase) GetWorkspacePlan(ctx context.Context, workspaceId string) (entity.WorkspacePlan, error) {
+	workspaceId = workspaceOrDefault(workspaceId)
+
+	plan, limits, err := p.getPlan(ctx, workspaceId)
+	if err != nil {
+		return entity.WorkspacePlan{}, err
+	}
+
+	period := currentPeriod()
+	records, err := p.planRepo.GetUsage(ctx, workspaceId, period)
+	if err != nil {
+		return entity.WorkspacePlan{}, err
+	}
+
+	usage := make(map[string]int64)
+	for _, record := range records {
+		usage[record.Metric] = record.Value
+	}
+
+	return entity.WorkspacePlan{
+		WorkspaceId: workspaceId,
+		Plan:        plan,
+		Limits:      limits,
+		Period:      period,
+		Usage:       usage,
+	}, nil
+}
+
+// GetUserWorkspacePlan returns the plan of the workspace the user belongs to
+func (p *planUsecase) GetUserWorkspacePlan(ctx context.Context, userId string) (entity.WorkspacePlan, error) {
+	user, err := p.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.WorkspacePlan{}, err
+	}
+
+	return p.GetWorkspacePlan(ctx, user.GetWorkspaceId())
+}
+
+// AssignPlan changes the plan of a workspace
+func (p *planUsecase) AssignPlan(ctx context.Context, adminId string, workspaceId string, plan string) (entity.WorkspacePlan, error) {
+	if _, ok := entity.GetPlanLimits(plan); !ok {
+		return entity.WorkspacePlan{}, invalidField("plan", ErrInvalidPlan)
+	}
+
+	err := p.planRepo.Assign(ctx, entity.PlanAssignment{
+		WorkspaceId: workspaceId,
+		Plan:        plan,
+		AssignedBy:  adminId,
+	})
+	if err != nil {
+		return entity.WorkspacePlan{}, err
+	}
+
+	return p.GetWorkspacePlan(ctx, workspaceId)
+}
+
+// RecordUsage meters usage of a workspace for the current billing period
+func (p *planUsecase) RecordUsage(ctx context.Context, workspaceId string, metric string, delta int64) error {
+	p.metricsUc.RecordUsage(workspaceId, metric, delta)
+	return p.planRepo.IncrementUsage(ctx, workspaceOrDefault(workspaceId), metric, currentPeriod(), delta)
+}
+
+// CheckGroupSize fails if a group of the given size is not allowed by the plan
+func (p *planUsecase) CheckGroupSize(ctx context.Context, workspaceId string, size int) error {
+	_, limits, err := p.getPlan(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxGroupSize > 0 && size > limits.MaxGroupSize {
+		return ErrGroupSizeLimit
+	}
+
+	return nil
+}
+
+// CheckAttachmentSize fails if an attachment of the given size is not allowed by the plan
+func (p *planUsecase) CheckAttachmentSize(ctx context.Context, workspaceId string, size int64) error {
+	_, limits, err := p.getPlan(ctx, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxAttachmentBytes > 0 && size > limits.MaxAttachmentBytes {
+		return ErrAttachmentSizeLimit
+	}
+
+	return nil
+}
+
+// HistoryCutoff returns the oldest message timestamp (unix millis) visible on the plan, 0 if unlimited
+func (p *planUsecase) HistoryCutoff(ctx context.Context, workspaceId string) (int64, error) {
+	_, limits, err := p.getPlan(ctx, workspaceId)
+	if err != nil {
+		return 0, err
+	}
+
+	if limits.HistoryDays == 0 {
+		return 0, nil
+	}
+
+	return time.Now().AddDate(0, 0, -limits.HistoryDays).UnixMilli(), nil
+}
+
+// getPlan resolves the plan of a workspace, workspaces without an assignment are on the free plan
+func (p *planUsecase) getPlan(ctx context.Context, workspaceId string) (string, entity.PlanLimits, error) {
+	plan := entity.PlanFree
+
+	assignment, err := p.planRepo.GetAssignment(ctx, workspaceOrDefault(workspaceId))
+	if err == nil {
+		plan = assignment.Plan
+	} else if !errors.Is(err, repository.ErrPlanNotFound) {
+		return "", entity.PlanLimits{}, err
+	}
+
+	limits, ok := entity.GetPlanLimits(plan)
+	if !ok {
+		return "", entity.PlanLimits{}, ErrInvalidPlan
+	}
+
+	return plan, limits, nil
+}
+
+func currentPeriod() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+func workspaceOrDefault(workspaceId string) string {
+	if workspaceId == "" {
+		return entity.DefaultWorkspaceId
+	}
+	return workspaceId
+}
@@ -0,0 +1,110 @@
+package usecase
+
+import (
+	"context"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+// RateLimitPolicy is the API rate limit of every user, Requests per Window
+type RateLimitPolicy struct {
+	// Requests is the number of requests allowed per window, 0 disables the rate limit
+	Requests int64
+	Window   time.Duration
+}
+
+func (p RateLimitPolicy) Enabled() bool {
+	return p.Requests > 0 && p.Window > 0
+}
+
+// QuotaUsecase tracks the API rate limit of the users and reports their quota usage
+type QuotaUsecase interface {
+	// Hit counts an API request of the user. The rate limit is nil when the API is not rate limited
+	Hit(ctx context.Context, userId string) (*entity.RateLimit, error)
+	GetUserLimits(ctx context.Context, userId string) (entity.UserLimits, error)
+}
+
+type quotaUsecase struct {
+	rateLimitRepo repository.RateLimitRepository
+	messageRepo   repository.MessageRepository
+	userRepo      repository.UserRepository
+	planUc        PlanUsecase
+	policy        RateLimitPolicy
+}
+
+func NewQuotaUsecase(rateLimitRepo repository.RateLimitRepository, messageRepo repository.MessageRepository, userRepo repository.UserRepository, planUc PlanUsecase, policy RateLimitPolicy) QuotaUsecase {
+	return &quotaUsecase{
+		rateLimitRepo: rateLimitRepo,
+		messageRepo:   messageRepo,
+		userRepo:      userRepo,
+		planUc:        planUc,
+		policy:        policy,
+	}
+}
+
+func (q *quotaUsecase) Hit(ctx context.Context, userId string) (*entity.RateLimit, error) {
+	if !q.policy.Enabled() {
+		return nil, nil
+	}
+
+	windowStart := time.Now().Truncate(q.policy.Window)
+	count, err := q.rateLimitRepo.Hit(ctx, userId, windowStart, q.policy.Window)
+	if err != nil {
+		return nil, err
+	}
+	return q.rateLimit(windowStart, count), nil
+}
+
+func (q *quotaUsecase) rateLimit(windowStart time.Time, count int64) *entity.RateLimit {
+	return &entity.RateLimit{
+		Limit:     q.policy.Requests,
+		Remaining: max(q.policy.Requests-count, 0),
+		ResetAt:   windowStart.Add(q.policy.Window),
+		Exceeded:  count > q.policy.Requests,
+	}
+}
+
+func (q *quotaUsecase) GetUserLimits(ctx context.Context, userId string) (entity.UserLimits, error) {
+	user, err := q.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.UserLimits{}, err
+	}
+
+	plan, err := q.planUc.GetUserWorkspacePlan(ctx, userId)
+	if err != nil {
+		return entity.UserLimits{}, err
+	}
+
+	// The day of the user, not the day of the server
+	now := time.Now().In(user.Location())
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := midnight.AddDate(0, 0, 1)
+	messagesToday, err := q.messageRepo.CountBySenderSince(ctx, userId, midnight.UnixMilli())
+	if err != nil {
+		return entity.UserLimits{}, err
+	}
+
+	storageBytes, err := q.messageRepo.GetStorageBySender(ctx, userId)
+	if err != nil {
+		return entity.UserLimits{}, err
+	}
+
+	limits := entity.UserLimits{
+		Plan:               plan.Plan,
+		MessagesToday:      entity.QuotaUsage{Used: messagesToday, ResetAt: &tomorrow},
+		Storage:            entity.QuotaUsage{Used: storageBytes},
+		MaxAttachmentBytes: plan.Limits.MaxAttachmentBytes,
+	}
+
+	if q.policy.Enabled() {
+		windowStart := time.Now().Truncate(q.policy.Window)
+		count, err := q.rateLimitRepo.Count(ctx, userId, windowStart)
+		if err != nil {
+			return entity.UserLimits{}, err
+		}
+		limits.RateLimit = q.rateLimit(windowStart, count)
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,189 @@
+package usecase
+
+import (
+	"context"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/metrics"
+)
+
+// unreadSettleTime is how long an inbox entry must stay untouched before its unread count is
+// corrected, the updates of a message being stored would otherwise count it twice
+const unreadSettleTime = time.Minute
+
+// Kinds of corrections counted by the repair jobs
+const (
+	repairSharedPresence = "shared_presence"
+	repairStaleOnline    = "stale_online"
+	repairMissingOnline  = "missing_online"
+	repairUnreadCount    = "unread_count"
+)
+
+// PresenceRegistry is the presence known to the websocket hub of this server, the hub implements it
+type PresenceRegistry interface {
+	PresenceChecker
+	LocalUserIds() []string
+	Reconcile(ctx context.Context) (int, error)
+}
+
+// RepairUsecase reconciles the state updated incrementally with its source of truth, fixing the
+// drift left by servers that crashed between two updates
+type RepairUsecase interface {
+	// RepairPresence matches the presence shared between servers and the online flag of the users
+	// to the live connections
+	RepairPresence(ctx context.Context) (int64, error)
+	// RepairUnreadCounts recomputes the unread counts of the inboxes from the message receipts
+	RepairUnreadCounts(ctx context.Context) (int64, error)
+}
+
+type repairUsecase struct {
+	userRepo    repository.UserRepository
+	inboxRepo   repository.InboxRepository
+	receiptRepo repository.ReceiptRepository
+	presence    PresenceRegistry
+
+	corrections *metrics.Vec
+}
+
+func NewRepairUsecase(registry *metrics.Registry, userRepo repository.UserRepository, inboxRepo repository.InboxRepository, receiptRepo repository.ReceiptRepository, presence PresenceRegistry) RepairUsecase {
+	return &repairUsecase{
+		userRepo:    userRepo,
+		inboxRepo:   inboxRepo,
+		receiptRepo: receiptRepo,
+		presence:    presence,
+		corrections: registry.NewCounter("wetalk_repair_corrections_total", "Drifted values corrected by the repair jobs.", "kind"),
+	}
+}
+
+func (r *repairUsecase) RepairPresence(ctx context.Context) (int64, error) {
+	shared, err := r.presence.Reconcile(ctx)
+	r.record(repairSharedPresence, shared)
+	if err != nil {
+		return int64(shared), err
+	}
+
+	stale, err := r.markStaleUsersOffline(ctx)
+	r.record(repairStaleOnline, stale)
+	if err != nil {
+		return int64(shared + stale), err
+	}
+
+	missing, err := r.markLocalUsersOnline(ctx)
+	r.record(repairMissingOnline, missing)
+	return int64(shared + stale + missing), err
+}
+
+// markStaleUsersOffline marks offline the users stored as online without a live connection,
+// which happens when a server dies before unregistering its clients
+func (r *repairUsecase) markStaleUsersOffline(ctx context.Context) (int, error) {
+	users, err := r.userRepo.GetOnlineUser(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	fixed := 0
+	for _, user := range users {
+		if r.presence.IsConnected(user.Id) {
+			continue
+		}
+
+		user.IsOnline = false
+		if err := r.userRepo.Update(ctx, user); err != nil {
+			return fixed, err
+		}
+		fixed++
+	}
+
+	return fixed, nil
+}
+
+// markLocalUsersOnline marks online the users connected to this server but stored as offline,
+// which happens when a server unregistering a connection of theirs raced with a new one
+func (r *repairUsecase) markLocalUsersOnline(ctx context.Context) (int, error) {
+	userIds := r.presence.LocalUserIds()
+	if len(userIds) == 0 {
+		return 0, nil
+	}
+
+	users, err := r.userRepo.Index(ctx, entity.UserIndexFilter{Ids: userIds})
+	if err != nil {
+		return 0, err
+	}
+
+	fixed := 0
+	for _, user := range users {
+		if user.IsOnline || !r.presence.IsConnected(user.Id) {
+			continue
+		}
+
+		user.IsOnline = true
+		if err := r.userRepo.Update(ctx, user); err != nil {
+			return fixed, err
+		}
+		fixed++
+	}
+
+	return fixed, nil
+}
+
+func (r *repairUsecase) RepairUnreadCounts(ctx context.Context) (int64, error) {
+	settled := time.Now().Add(-unreadSettleTime)
+
+	stored, err := r.inboxRepo.GetUnreadCounts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	counts, err := r.receiptRepo.CountUnread(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	storedCounts := make(map[string]int64, len(stored))
+	for _, count := range stored {
+		storedCounts[entity.InboxEntryId(count.UserId, count.ChatId)] = count.Count
+	}
+
+	fixed := 0
+	defer func() { r.record(repairUnreadCount, fixed) }()
+
+	for _, count := range counts {
+		id := entity.InboxEntryId(count.UserId, count.ChatId)
+		storedCount := storedCounts[id]
+		delete(storedCounts, id)
+		if storedCount == count.Count {
+			continue
+		}
+
+		changed, err := r.inboxRepo.SetUnreadCount(ctx, count.UserId, count.ChatId, count.Count, settled)
+		if err != nil {
+			return int64(fixed), err
+		}
+		if changed {
+			fixed++
+		}
+	}
+
+	// The entries left have unread messages the receipts don't know of
+	for _, count := range stored {
+		if _, ok := storedCounts[entity.InboxEntryId(count.UserId, count.ChatId)]; !ok {
+			continue
+		}
+
+		changed, err := r.inboxRepo.SetUnreadCount(ctx, count.UserId, count.ChatId, 0, settled)
+		if err != nil {
+			return int64(fixed), err
+		}
+		if changed {
+			fixed++
+		}
+	}
+
+	return int64(fixed), nil
+}
+
+func (r *repairUsecase) record(kind string, count int) {
+	if count > 0 {
+		r.corrections.Add(float64(count), kind)
+	}
+}
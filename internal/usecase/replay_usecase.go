@@ -0,0 +1,172 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+// ReplayPolicy sizes the window of recent messages kept per chat
+type ReplayPolicy struct {
+	// WindowSize is the number of newest messages kept per chat, it also caps a replay
+	WindowSize int
+	// TTL drops the window of a chat that had no new message for that long
+	TTL time.Duration
+}
+
+func DefaultReplayPolicy() ReplayPolicy {
+	return ReplayPolicy{
+		WindowSize: 50,
+		TTL:        24 * time.Hour,
+	}
+}
+
+// ReplayUsecase serves the most recent messages of a chat from a capped window instead of the
+// message collection. Replayed messages are snapshots taken when they were sent, read and
+// delivery states are not attached
+type ReplayUsecase interface {
+	// GetRecent returns up to limit messages newest first, the whole window when limit is zero
+	GetRecent(ctx context.Context, chatId string, userId string, limit int) ([]entity.Message, error)
+	Subscribe(bus EventBus)
+}
+
+type replayUsecase struct {
+	replayRepo  repository.ReplayRepository
+	chatRepo    repository.ChatRepository
+	messageRepo repository.MessageRepository
+	planUc      PlanUsecase
+	policy      ReplayPolicy
+}
+
+func NewReplayUsecase(replayRepo repository.ReplayRepository, chatRepo repository.ChatRepository, messageRepo repository.MessageRepository, planUc PlanUsecase, policy ReplayPolicy) ReplayUsecase {
+	return &replayUsecase{
+		replayRepo:  replayRepo,
+		chatRepo:    chatRepo,
+		messageRepo: messageRepo,
+		planUc:      planUc,
+		policy:      policy,
+	}
+}
+
+func (r *replayUsecase) GetRecent(ctx context.Context, chatId string, userId string, limit int) ([]entity.Message, error) {
+	if limit <= 0 || limit > r.policy.WindowSize {
+		limit = r.policy.WindowSize
+	}
+
+	participant, err := r.chatRepo.GetParticipantByUserAndChat(ctx, userId, chatId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotParticipant) {
+			return nil, ErrNotParticipant
+		}
+		return nil, err
+	}
+
+	chat, err := r.chatRepo.Get(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Older messages are hidden on plans with a limited history and from members who joined later
+	since, err := r.planUc.HistoryCutoff(ctx, chat.WorkspaceId)
+	if err != nil {
+		return nil, err
+	}
+	if participant.HistoryFrom != nil {
+		since = max(since, participant.HistoryFrom.UnixMilli())
+	}
+
+	messages, found, err := r.replayRepo.GetRecent(ctx, chatId, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Get replay window error", "error", err)
+	}
+	if !found {
+		messages, err = r.fill(ctx, chatId, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	visible := make([]entity.Message, 0, len(messages))
+	for _, message := range messages {
+		if message.Timestamp >= since {
+			visible = append(visible, message)
+		}
+	}
+
+	return visible, nil
+}
+
+// fill loads the window of a chat from the message collection after a miss
+func (r *replayUsecase) fill(ctx context.Context, chatId string, limit int) ([]entity.Message, error) {
+	messages, err := r.messageRepo.Index(ctx, entity.MessageIndexFilter{
+		ChatId: chatId,
+		Limit:  r.policy.WindowSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.replayRepo.Fill(ctx, chatId, messages, r.policy.WindowSize, r.policy.TTL); err != nil {
+		slog.ErrorContext(ctx, "Fill replay window error", "error", err)
+	}
+
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (r *replayUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventMessageCreated, r.onMessageCreated)
+	bus.Subscribe(entity.EventMessageDeleted, r.onMessageDeleted)
+	bus.Subscribe(entity.EventMessagesDeleted, r.onMessagesDeleted)
+	bus.Subscribe(entity.EventChatDeleted, r.onChatDeleted)
+}
+
+func (r *replayUsecase) onMessageCreated(ctx context.Context, data any) {
+	message, ok := data.(entity.Message)
+	if !ok {
+		return
+	}
+
+	if err := r.replayRepo.Push(ctx, message, r.policy.WindowSize, r.policy.TTL); err != nil {
+		slog.ErrorContext(ctx, "Push to replay window error", "error", err)
+	}
+}
+
+// onMessageDeleted drops the window of the chat, the next replay fills it again from the database
+func (r *replayUsecase) onMessageDeleted(ctx context.Context, data any) {
+	event, ok := data.(entity.MessageDeleted)
+	if !ok {
+		return
+	}
+
+	if err := r.replayRepo.Clear(ctx, event.ChatId); err != nil {
+		slog.ErrorContext(ctx, "Clear replay window error", "error", err)
+	}
+}
+
+func (r *replayUsecase) onMessagesDeleted(ctx context.Context, data any) {
+	event, ok := data.(entity.MessagesDeleted)
+	if !ok {
+		return
+	}
+
+	if err := r.replayRepo.Clear(ctx, event.ChatId); err != nil {
+		slog.ErrorContext(ctx, "Clear replay window error", "error", err)
+	}
+}
+
+func (r *replayUsecase) onChatDeleted(ctx context.Context, data any) {
+	chat, ok := data.(entity.Chat)
+	if !ok {
+		return
+	}
+
+	if err := r.replayRepo.Clear(ctx, chat.Id); err != nil {
+		slog.ErrorContext(ctx, "Clear replay window error", "error", err)
+	}
+}
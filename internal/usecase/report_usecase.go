@@ -0,0 +1,177 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrInvalidReportTarget   = errors.New("report either a messageId or a userId")
+	ErrInvalidReportReason   = errors.New("reason is required and must be at most 1000 characters")
+	ErrCannotReportSelf      = errors.New("cannot report yourself")
+	ErrAlreadyReported       = errors.New("you already reported this")
+	ErrReportNotFound        = errors.New("report not found or already resolved")
+	ErrInvalidReportStatus   = errors.New("status must be open, resolved or dismissed")
+	ErrInvalidReportDecision = errors.New("decision must be resolve or dismiss")
+)
+
+const (
+	maxReportReasonLength = 1000
+	defaultReportLimit    = 50
+	maxReportLimit        = 200
+)
+
+// ReportUsecase lets users report abusive messages and users to the admins, every report counts
+// as an abuse signal against the reported user
+type ReportUsecase interface {
+	// Create reports a message of a chat of the reporter, or a user. A reported message is
+	// copied into the report so it survives an edit or a deletion
+	Create(ctx context.Context, reporterId string, req entity.CreateReportRequest) (entity.Report, error)
+	List(ctx context.Context, filter entity.ReportFilter) ([]entity.Report, error)
+	Resolve(ctx context.Context, reportId string, adminId string, req entity.ResolveReportRequest) (entity.Report, error)
+}
+
+type reportUsecase struct {
+	reportRepo  repository.ReportRepository
+	messageRepo repository.MessageRepository
+	chatRepo    repository.ChatRepository
+	userRepo    repository.UserRepository
+	abuseUc     AbuseUsecase
+}
+
+func NewReportUsecase(reportRepo repository.ReportRepository, messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, abuseUc AbuseUsecase) ReportUsecase {
+	return &reportUsecase{
+		reportRepo:  reportRepo,
+		messageRepo: messageRepo,
+		chatRepo:    chatRepo,
+		userRepo:    userRepo,
+		abuseUc:     abuseUc,
+	}
+}
+
+func (u *reportUsecase) Create(ctx context.Context, reporterId string, req entity.CreateReportRequest) (entity.Report, error) {
+	if (req.MessageId == "") == (req.UserId == "") {
+		return entity.Report{}, invalidField("messageId", ErrInvalidReportTarget)
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || utf8.RuneCountInString(req.Reason) > maxReportReasonLength {
+		return entity.Report{}, invalidField("reason", ErrInvalidReportReason)
+	}
+
+	report := entity.Report{
+		ReporterId: reporterId,
+		Reason:     req.Reason,
+	}
+	if req.MessageId != "" {
+		message, err := u.messageRepo.Get(ctx, req.MessageId)
+		if err != nil {
+			if errors.Is(err, repository.ErrMessageNotFound) {
+				return entity.Report{}, ErrMessageNotFound
+			}
+			return entity.Report{}, err
+		}
+
+		// Only the messages the reporter could read can be reported
+		isParticipant, err := u.chatRepo.IsParticipant(ctx, reporterId, message.ChatId)
+		if err != nil {
+			return entity.Report{}, err
+		}
+		if !isParticipant {
+			return entity.Report{}, ErrNotParticipant
+		}
+
+		report.TargetType = entity.ReportTargetMessage
+		report.TargetId = message.Id
+		report.ReportedUserId = message.SenderId
+		report.ChatId = message.ChatId
+		report.Message = &message
+	} else {
+		if _, err := u.userRepo.Get(ctx, req.UserId); err != nil {
+			if errors.Is(err, repository.ErrUserNotFound) {
+				return entity.Report{}, ErrUserNotFound
+			}
+			return entity.Report{}, err
+		}
+
+		report.TargetType = entity.ReportTargetUser
+		report.TargetId = req.UserId
+		report.ReportedUserId = req.UserId
+	}
+	if report.ReportedUserId == reporterId {
+		return entity.Report{}, ErrCannotReportSelf
+	}
+
+	// A single user reporting the same thing again must not add up to a restriction
+	exists, err := u.reportRepo.ExistsByReporter(ctx, reporterId, report.TargetType, report.TargetId)
+	if err != nil {
+		return entity.Report{}, err
+	}
+	if exists {
+		return entity.Report{}, ErrAlreadyReported
+	}
+
+	report, err = u.reportRepo.Create(ctx, report)
+	if err != nil {
+		return entity.Report{}, err
+	}
+
+	// The report is stored, the restriction rules can be evaluated again later
+	if err := u.abuseUc.RecordSignal(ctx, report.ReportedUserId, entity.AbuseSignalReport, report.Id); err != nil {
+		slog.ErrorContext(ctx, "Record report signal error", "report_id", report.Id, "error", err)
+	}
+
+	return report, nil
+}
+
+func (u *reportUsecase) List(ctx context.Context, filter entity.ReportFilter) ([]entity.Report, error) {
+	switch filter.Status {
+	case "", entity.ReportStatusOpen, entity.ReportStatusResolved, entity.ReportStatusDismissed:
+	default:
+		return nil, invalidField("status", ErrInvalidReportStatus)
+	}
+	switch filter.TargetType {
+	case "", entity.ReportTargetMessage, entity.ReportTargetUser:
+	default:
+		return nil, invalidField("targetType", ErrInvalidReportTarget)
+	}
+
+	filter.Limit = ReportLimit(filter.Limit)
+	return u.reportRepo.List(ctx, filter)
+}
+
+// ReportLimit returns how many reports a list returns for the requested limit
+func ReportLimit(limit int) int {
+	if limit <= 0 {
+		return defaultReportLimit
+	}
+	if limit > maxReportLimit {
+		return maxReportLimit
+	}
+	return limit
+}
+
+func (u *reportUsecase) Resolve(ctx context.Context, reportId string, adminId string, req entity.ResolveReportRequest) (entity.Report, error) {
+	var status string
+	switch req.Decision {
+	case entity.ReportDecisionResolve:
+		status = entity.ReportStatusResolved
+	case entity.ReportDecisionDismiss:
+		status = entity.ReportStatusDismissed
+	default:
+		return entity.Report{}, invalidField("decision", ErrInvalidReportDecision)
+	}
+
+	report, err := u.reportRepo.Resolve(ctx, reportId, status, adminId, strings.TrimSpace(req.Note))
+	if err != nil {
+		if errors.Is(err, repository.ErrReportNotFound) {
+			return entity.Report{}, ErrReportNotFound
+		}
+		return entity.Report{}, err
+	}
+	return report, nil
+}
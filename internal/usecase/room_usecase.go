@@ -0,0 +1,90 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+// ChatRooms groups the connections of the participants of a chat, so a message to the chat is a
+// single send. The websocket hub implements it
+type ChatRooms interface {
+	JoinRoom(ctx context.Context, roomId string, userId string)
+	LeaveRoom(ctx context.Context, roomId string, userId string)
+}
+
+// RoomUsecase keeps the users in the rooms of the chats they take part in: a connection joins
+// the rooms of every chat of its user, then the rooms follow the membership events
+type RoomUsecase interface {
+	// Rooms returns the chats whose rooms a connection of the user joins
+	Rooms(ctx context.Context, userId string) ([]string, error)
+	Subscribe(bus EventBus)
+}
+
+type roomUsecase struct {
+	chatRepo repository.ChatRepository
+	rooms    ChatRooms
+}
+
+func NewRoomUsecase(chatRepo repository.ChatRepository, rooms ChatRooms) RoomUsecase {
+	return &roomUsecase{
+		chatRepo: chatRepo,
+		rooms:    rooms,
+	}
+}
+
+func (r *roomUsecase) Rooms(ctx context.Context, userId string) ([]string, error) {
+	participations, err := r.chatRepo.GetParticipationsByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	chatIds := make([]string, 0, len(participations))
+	for _, participant := range participations {
+		chatIds = append(chatIds, participant.ChatId)
+	}
+	return chatIds, nil
+}
+
+func (r *roomUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventChatCreated, r.onChatCreated)
+	bus.Subscribe(entity.EventMemberJoined, r.onMemberJoined)
+	bus.Subscribe(entity.EventMemberLeft, r.onMemberGone)
+	bus.Subscribe(entity.EventMemberRemoved, r.onMemberGone)
+}
+
+func (r *roomUsecase) onChatCreated(ctx context.Context, data any) {
+	chat, ok := data.(entity.Chat)
+	if !ok {
+		return
+	}
+
+	participants, err := r.chatRepo.GetParticipants(ctx, chat.Id)
+	if err != nil {
+		slog.ErrorContext(ctx, "Room chat created error", "chat_id", chat.Id, "error", err)
+		return
+	}
+
+	for _, participant := range participants {
+		r.rooms.JoinRoom(ctx, chat.Id, participant.UserId)
+	}
+}
+
+func (r *roomUsecase) onMemberJoined(ctx context.Context, data any) {
+	event, ok := data.(entity.MembershipEvent)
+	if !ok {
+		return
+	}
+
+	r.rooms.JoinRoom(ctx, event.ChatId, event.UserId)
+}
+
+func (r *roomUsecase) onMemberGone(ctx context.Context, data any) {
+	event, ok := data.(entity.MembershipEvent)
+	if !ok {
+		return
+	}
+
+	r.rooms.LeaveRoom(ctx, event.ChatId, event.UserId)
+}
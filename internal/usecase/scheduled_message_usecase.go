@@ -0,0 +1,173 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrInvalidScheduleTime       = errors.New("scheduledAt must be in the future and within a year")
+	ErrScheduledMessageLimit     = errors.New("too many scheduled messages, cancel some first")
+	ErrScheduledMessageNotFound  = errors.New("scheduled message not found or already sent")
+	errScheduledSenderNotAllowed = errors.New("the sender is no longer a participant of the chat")
+)
+
+const (
+	// ScheduledMessageInterval is how often the due messages are sent, a message goes out at most
+	// this late
+	ScheduledMessageInterval = 10 * time.Second
+
+	maxScheduleAhead       = 365 * 24 * time.Hour
+	maxPendingScheduled    = 100
+	scheduledClaimStaleAge = 5 * time.Minute
+	scheduledRetryDelay    = time.Minute
+	// scheduledBatchSize bounds the messages sent by one run, the next run takes the rest
+	scheduledBatchSize = 500
+)
+
+// ScheduledMessageUsecase sends messages later: a message sent with a scheduledAt is kept until
+// it is due, then stored and fanned out by SaveMessage like any other
+type ScheduledMessageUsecase interface {
+	// Schedule checks the sender can write to the chat, the message itself is only checked when
+	// it is sent
+	Schedule(ctx context.Context, message entity.Message, scheduledAt time.Time) (entity.ScheduledMessage, error)
+	ListPending(ctx context.Context, senderId string, chatId string) ([]entity.ScheduledMessage, error)
+	Cancel(ctx context.Context, scheduledId string, senderId string) (entity.ScheduledMessage, error)
+	// SendDue sends the messages that are due. A message the server refuses is marked failed and
+	// its sender told, one that failed for another reason is tried again a minute later
+	SendDue(ctx context.Context) (int64, error)
+}
+
+type scheduledMessageUsecase struct {
+	scheduledRepo repository.ScheduledMessageRepository
+	chatRepo      repository.ChatRepository
+	messageUc     MessageUsecase
+	publisher     EventPublisher
+}
+
+func NewScheduledMessageUsecase(scheduledRepo repository.ScheduledMessageRepository, chatRepo repository.ChatRepository, messageUc MessageUsecase, publisher EventPublisher) ScheduledMessageUsecase {
+	return &scheduledMessageUsecase{
+		scheduledRepo: scheduledRepo,
+		chatRepo:      chatRepo,
+		messageUc:     messageUc,
+		publisher:     publisher,
+	}
+}
+
+func (s *scheduledMessageUsecase) Schedule(ctx context.Context, message entity.Message, scheduledAt time.Time) (entity.ScheduledMessage, error) {
+	now := time.Now()
+	if !scheduledAt.After(now) || scheduledAt.Sub(now) > maxScheduleAhead {
+		return entity.ScheduledMessage{}, invalidField("scheduledAt", ErrInvalidScheduleTime)
+	}
+
+	isParticipant, err := s.chatRepo.IsParticipant(ctx, message.SenderId, message.ChatId)
+	if err != nil {
+		return entity.ScheduledMessage{}, err
+	}
+	if !isParticipant {
+		return entity.ScheduledMessage{}, ErrNotParticipant
+	}
+
+	pending, err := s.scheduledRepo.CountPending(ctx, message.SenderId)
+	if err != nil {
+		return entity.ScheduledMessage{}, err
+	}
+	if pending >= maxPendingScheduled {
+		return entity.ScheduledMessage{}, ErrScheduledMessageLimit
+	}
+
+	return s.scheduledRepo.Create(ctx, entity.ScheduledMessage{
+		ChatId:           message.ChatId,
+		SenderId:         message.SenderId,
+		Message:          message.Message,
+		Attachments:      message.Attachments,
+		ReplyToMessageId: message.ReplyToMessageId,
+		ScheduledAt:      scheduledAt.UTC(),
+	})
+}
+
+func (s *scheduledMessageUsecase) ListPending(ctx context.Context, senderId string, chatId string) ([]entity.ScheduledMessage, error) {
+	return s.scheduledRepo.ListPending(ctx, senderId, chatId)
+}
+
+func (s *scheduledMessageUsecase) Cancel(ctx context.Context, scheduledId string, senderId string) (entity.ScheduledMessage, error) {
+	scheduled, err := s.scheduledRepo.Cancel(ctx, scheduledId, senderId)
+	if err != nil {
+		if errors.Is(err, repository.ErrScheduledMessageNotFound) {
+			return entity.ScheduledMessage{}, ErrScheduledMessageNotFound
+		}
+		return entity.ScheduledMessage{}, err
+	}
+	return scheduled, nil
+}
+
+func (s *scheduledMessageUsecase) SendDue(ctx context.Context) (int64, error) {
+	var sent int64
+	for range scheduledBatchSize {
+		now := time.Now()
+		scheduled, err := s.scheduledRepo.ClaimDue(ctx, now, now.Add(-scheduledClaimStaleAge))
+		if errors.Is(err, repository.ErrScheduledMessageNotFound) {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		delivered, err := s.send(ctx, scheduled)
+		if err != nil {
+			return sent, err
+		}
+		if delivered {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// send stores a claimed message and reports whether it was sent, only the errors of the
+// scheduled messages store stop the run
+func (s *scheduledMessageUsecase) send(ctx context.Context, scheduled entity.ScheduledMessage) (bool, error) {
+	// The handlers check the participation before saving, so does the scheduler
+	isParticipant, err := s.chatRepo.IsParticipant(ctx, scheduled.SenderId, scheduled.ChatId)
+	if err != nil {
+		return false, s.release(ctx, scheduled, err)
+	}
+	if !isParticipant {
+		return false, s.fail(ctx, scheduled, errScheduledSenderNotAllowed)
+	}
+
+	message, err := s.messageUc.SaveMessage(ctx, scheduled.ToMessage(time.Now()))
+	switch {
+	case errors.Is(err, ErrCommandHandled):
+		// The command answered its issuer, there is no message to point to
+		return true, s.scheduledRepo.MarkSent(ctx, scheduled.Id, "")
+	case IsAny(err, ErrMessageRejected, ErrAttachmentTooLarge, ErrAttachmentTypeRejected, ErrAttachmentSizeLimit, ErrInvalidReply, ErrAccountSuspended, ErrChannelReadOnly, repository.ErrChatNotFound):
+		return false, s.fail(ctx, scheduled, err)
+	case err != nil:
+		// Muted and rate limited senders, or an unavailable moderation, get another try
+		return false, s.release(ctx, scheduled, err)
+	}
+
+	return true, s.scheduledRepo.MarkSent(ctx, scheduled.Id, message.Id)
+}
+
+func (s *scheduledMessageUsecase) fail(ctx context.Context, scheduled entity.ScheduledMessage, reason error) error {
+	slog.WarnContext(ctx, "Scheduled message refused", "scheduled_id", scheduled.Id, "error", reason)
+	if err := s.scheduledRepo.MarkFailed(ctx, scheduled.Id, reason.Error()); err != nil {
+		return err
+	}
+
+	scheduled.Status = entity.ScheduledMessageStatusFailed
+	scheduled.Error = reason.Error()
+	s.publisher.PublishToUsers(ctx, []string{scheduled.SenderId}, entity.EventScheduledMessageFailed, scheduled)
+	return nil
+}
+
+func (s *scheduledMessageUsecase) release(ctx context.Context, scheduled entity.ScheduledMessage, reason error) error {
+	slog.WarnContext(ctx, "Send scheduled message error, retrying later", "scheduled_id", scheduled.Id, "error", reason)
+	return s.scheduledRepo.Release(ctx, scheduled.Id, time.Now().Add(scheduledRetryDelay))
+}
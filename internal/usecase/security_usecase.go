@@ -0,0 +1,152 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+	"wetalk/pkg/locale"
+	"wetalk/pkg/metrics"
+)
+
+// securityDeliveryTimeout bounds the delivery of a security event to the sink and by email
+const securityDeliveryTimeout = 30 * time.Second
+
+// SecuritySink receives the security events, such as the webhook of a SIEM, see pkg/siem for the
+// webhook implementation
+type SecuritySink interface {
+	Report(ctx context.Context, event entity.SecurityEvent) error
+}
+
+// Mailer sends a plain text email, see pkg/mail for the SMTP implementation
+type Mailer interface {
+	Send(ctx context.Context, to string, subject string, body string) error
+}
+
+type logSecuritySink struct{}
+
+// NewLogSecuritySink only logs the security events, it is used until a webhook is configured
+func NewLogSecuritySink() SecuritySink {
+	return logSecuritySink{}
+}
+
+func (logSecuritySink) Report(ctx context.Context, event entity.SecurityEvent) error {
+	slog.WarnContext(ctx, "Security event", "event", event.Type, "user_id", event.UserId, "remote_ip", event.RemoteIp, "user_agent", event.UserAgent)
+	return nil
+}
+
+// SecurityAlertUsecase delivers the security events raised by the authentication to the sink and,
+// when a mailer is set, to the owner of the account
+type SecurityAlertUsecase interface {
+	// Subscribe registers the delivery on the domain event bus
+	Subscribe(bus EventBus)
+}
+
+type securityAlertUsecase struct {
+	userRepo repository.UserRepository
+	sink     SecuritySink
+	// mailer is nil when the users are not notified
+	mailer         Mailer
+	deliveryHealth DeliveryHealthUsecase
+
+	events   *metrics.Vec
+	failures *metrics.Vec
+}
+
+func NewSecurityAlertUsecase(registry *metrics.Registry, userRepo repository.UserRepository, sink SecuritySink, mailer Mailer, deliveryHealth DeliveryHealthUsecase) SecurityAlertUsecase {
+	return &securityAlertUsecase{
+		userRepo:       userRepo,
+		sink:           sink,
+		mailer:         mailer,
+		deliveryHealth: deliveryHealth,
+		events:         registry.NewCounter("wetalk_security_events_total", "Authentication anomalies reported as security events.", "type"),
+		failures:       registry.NewCounter("wetalk_security_delivery_failures_total", "Security events that couldn't be delivered.", "channel"),
+	}
+}
+
+func (s *securityAlertUsecase) Subscribe(bus EventBus) {
+	bus.Subscribe(entity.EventSecurityAlert, s.onSecurityAlert)
+}
+
+// onSecurityAlert delivers the event in the background, the login it was raised by doesn't wait on the webhook
+func (s *securityAlertUsecase) onSecurityAlert(ctx context.Context, data any) {
+	event, ok := data.(entity.SecurityEvent)
+	if !ok {
+		return
+	}
+	s.events.Inc(event.Type)
+
+	deliveryCtx := context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(deliveryCtx, securityDeliveryTimeout)
+		defer cancel()
+
+		if err := s.sink.Report(ctx, event); err != nil {
+			s.failures.Inc("sink")
+			slog.ErrorContext(ctx, "Report security event error", "event", event.Type, "event_id", event.Id, "error", err)
+		}
+
+		if s.mailer != nil && event.UserId != "" {
+			if err := s.notifyUser(ctx, event); err != nil {
+				s.failures.Inc("email")
+				slog.ErrorContext(ctx, "Security email error", "event", event.Type, "event_id", event.Id, "user_id", event.UserId, "error", err)
+			}
+		}
+	}()
+}
+
+// notifyUser emails the owner of the account about the event, unless their address keeps bouncing
+func (s *securityAlertUsecase) notifyUser(ctx context.Context, event entity.SecurityEvent) error {
+	user, err := s.userRepo.Get(ctx, event.UserId)
+	if err != nil {
+		return err
+	}
+	canEmail, err := s.deliveryHealth.CanEmail(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+	if !canEmail {
+		slog.InfoContext(ctx, "Security email skipped, the address bounced", "event", event.Type, "user_id", user.Id)
+		return nil
+	}
+
+	subject, body := securityEmail(event, user)
+	err = s.mailer.Send(ctx, user.Email, subject, body)
+	s.deliveryHealth.RecordEmail(ctx, user.Id, user.Email, err)
+	return err
+}
+
+// securityEmail writes the email telling a user about an event on their account, the time is
+// written in their timezone and locale
+func securityEmail(event entity.SecurityEvent, user entity.User) (subject string, body string) {
+	occurredAt := locale.Format(event.OccurredAt.In(user.Location()), user.Locale)
+	client := fmt.Sprintf("IP address: %s\nDevice: %s\nTime: %s\n", orUnknown(event.RemoteIp), orUnknown(event.UserAgent), occurredAt)
+
+	switch event.Type {
+	case entity.SecurityEventNewClient:
+		subject = "New sign in to your account"
+		body = "Your account was signed in to from a new device or location.\n\n" + client +
+			"\nIf this was you, you can ignore this email. Otherwise change your password and sign out of every device."
+	case entity.SecurityEventLoginFailures:
+		subject = "Failed sign in attempts on your account"
+		body = fmt.Sprintf("There were %d failed attempts to sign in to your account.\n\n", event.FailedAttempts) + client +
+			"\nIf this wasn't you, consider changing your password and enabling two-factor authentication."
+	case entity.SecurityEventRefreshTokenReuse:
+		subject = "Suspicious activity on your account"
+		body = "A signed out session of your account was used again, someone may have copied it.\n\n" + client +
+			"\nSign out of every device and change your password."
+	default:
+		subject = "Security alert on your account"
+		body = client
+	}
+	return subject, body
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
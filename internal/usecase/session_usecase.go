@@ -0,0 +1,124 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrInvalidSessionKind = errors.New("invalid session kind")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrSubjectRequired    = errors.New("session subject is required")
+)
+
+// SessionPolicy configures the lifetime of short-lived sessions
+type SessionPolicy struct {
+	TTL time.Duration
+	// Sliding extends the session on every successful validation
+	Sliding bool
+}
+
+func DefaultSessionPolicy() SessionPolicy {
+	return SessionPolicy{
+		TTL:     24 * time.Hour,
+		Sliding: true,
+	}
+}
+
+type SessionUsecase interface {
+	Create(ctx context.Context, req entity.CreateSessionRequest) (entity.Session, error)
+	Validate(ctx context.Context, sessionId string) (entity.Session, error)
+	GetBySubject(ctx context.Context, subject string) ([]entity.Session, error)
+	Invalidate(ctx context.Context, sessionId string) error
+	InvalidateSubject(ctx context.Context, subject string) error
+}
+
+type sessionUsecase struct {
+	sessionRepo repository.SessionRepository
+	policy      SessionPolicy
+}
+
+func NewSessionUsecase(sessionRepo repository.SessionRepository, policy SessionPolicy) SessionUsecase {
+	return &sessionUsecase{
+		sessionRepo: sessionRepo,
+		policy:      policy,
+	}
+}
+
+// Create issues a new session, its id is the opaque token handed to the client
+func (s *sessionUsecase) Create(ctx context.Context, req entity.CreateSessionRequest) (entity.Session, error) {
+	if req.Kind != entity.SessionKindGuest && req.Kind != entity.SessionKindWidget {
+		return entity.Session{}, invalidField("kind", ErrInvalidSessionKind)
+	}
+	if req.Subject == "" {
+		return entity.Session{}, invalidField("subject", ErrSubjectRequired)
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return entity.Session{}, err
+	}
+
+	now := time.Now()
+	session := entity.Session{
+		Id:          base64.RawURLEncoding.EncodeToString(token),
+		Kind:        req.Kind,
+		Subject:     req.Subject,
+		DisplayName: req.DisplayName,
+		WorkspaceId: req.WorkspaceId,
+		Metadata:    req.Metadata,
+		RemoteIp:    req.RemoteIp,
+		UserAgent:   req.UserAgent,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.policy.TTL),
+	}
+
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return entity.Session{}, err
+	}
+
+	return session, nil
+}
+
+// Validate returns the session behind a token, extending it when the policy is sliding
+func (s *sessionUsecase) Validate(ctx context.Context, sessionId string) (entity.Session, error) {
+	session, err := s.sessionRepo.Get(ctx, sessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return entity.Session{}, ErrSessionNotFound
+		}
+		return entity.Session{}, err
+	}
+
+	if s.policy.Sliding {
+		session.ExpiresAt = time.Now().Add(s.policy.TTL)
+		if err := s.sessionRepo.Refresh(ctx, sessionId, session.ExpiresAt); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			return entity.Session{}, err
+		}
+	}
+
+	return session, nil
+}
+
+func (s *sessionUsecase) GetBySubject(ctx context.Context, subject string) ([]entity.Session, error) {
+	return s.sessionRepo.GetBySubject(ctx, subject)
+}
+
+// Invalidate ends a single session on every server
+func (s *sessionUsecase) Invalidate(ctx context.Context, sessionId string) error {
+	err := s.sessionRepo.Delete(ctx, sessionId)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+// InvalidateSubject ends every session issued for the subject
+func (s *sessionUsecase) InvalidateSubject(ctx context.Context, subject string) error {
+	return s.sessionRepo.DeleteBySubject(ctx, subject)
+}
@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"wetalk/internal/entity"
+	"wetalk/internal/repository"
+)
+
+var (
+	ErrInvalidSettings   = errors.New("invalid workspace settings")
+	ErrNotWorkspaceAdmin = errors.New("you are not an admin of this workspace")
+)
+
+type SettingsUsecase interface {
+	GetWorkspaceSettings(ctx context.Context, workspaceId string) (entity.WorkspaceSettings, error)
+	GetUserWorkspaceSettings(ctx context.Context, userId string) (entity.WorkspaceSettings, error)
+	UpdateUserWorkspaceSettings(ctx context.Context, userId string, req entity.UpdateWorkspaceSettingsRequest) (entity.WorkspaceSettings, error)
+	GetWorkspacesWithRetention(ctx context.Context) ([]entity.WorkspaceSettings, error)
+}
+
+type settingsUsecase struct {
+	settingsRepo repository.SettingsRepository
+	userRepo     repository.UserRepository
+}
+
+func NewSettingsUsecase(settingsRepo repository.SettingsRepository, userRepo repository.UserRepository) SettingsUsecase {
+	return &settingsUsecase{
+		settingsRepo: settingsRepo,
+		userRepo:     userRepo,
+	}
+}
+
+// GetWorkspaceSettings returns the settings of a workspace, or the defaults if none were saved
+func (s *settingsUsecase) GetWorkspaceSettings(ctx context.Context, workspaceId string) (entity.WorkspaceSettings, error) {
+	if workspaceId == "" {
+		workspaceId = entity.DefaultWorkspaceId
+	}
+
+	settings, err := s.settingsRepo.Get(ctx, workspaceId)
+	if err != nil {
+		if errors.Is(err, repository.ErrSettingsNotFound) {
+			return entity.DefaultWorkspaceSettings(workspaceId), nil
+		}
+		return entity.WorkspaceSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// GetUserWorkspaceSettings returns the settings of the workspace the user belongs to
+func (s *settingsUsecase) GetUserWorkspaceSettings(ctx context.Context, userId string) (entity.WorkspaceSettings, error) {
+	user, err := s.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.WorkspaceSettings{}, err
+	}
+
+	return s.GetWorkspaceSettings(ctx, user.GetWorkspaceId())
+}
+
+// UpdateUserWorkspaceSettings replaces the settings of the user's workspace (workspace admins only)
+func (s *settingsUsecase) UpdateUserWorkspaceSettings(ctx context.Context, userId string, req entity.UpdateWorkspaceSettingsRequest) (entity.WorkspaceSettings, error) {
+	user, err := s.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.WorkspaceSettings{}, err
+	}
+
+	if user.Role != entity.UserRoleAdmin {
+		return entity.WorkspaceSettings{}, ErrNotWorkspaceAdmin
+	}
+
+	if req.RetentionDays < 0 {
+		return entity.WorkspaceSettings{}, invalidField("retentionDays", ErrInvalidSettings)
+	}
+	if req.Attachments.MaxSizeBytes < 0 {
+		return entity.WorkspaceSettings{}, invalidField("attachments.maxSizeBytes", ErrInvalidSettings)
+	}
+
+	switch req.Moderation.Action {
+	case "":
+		req.Moderation.Action = entity.ModerationActionReject
+	case entity.ModerationActionRedact:
+		req.Moderation.Action = entity.ModerationActionMask
+	}
+	if !entity.IsValidModerationAction(req.Moderation.Action) {
+		return entity.WorkspaceSettings{}, invalidField("moderation.action", ErrInvalidSettings)
+	}
+
+	if req.Moderation.BlockedWords == nil {
+		req.Moderation.BlockedWords = []string{}
+	}
+	if req.Moderation.RestrictedBlockedWords == nil {
+		req.Moderation.RestrictedBlockedWords = []string{}
+	}
+	if req.Attachments.AllowedMimeTypes == nil {
+		req.Attachments.AllowedMimeTypes = []string{}
+	}
+
+	settings := entity.WorkspaceSettings{
+		WorkspaceId:   user.GetWorkspaceId(),
+		Moderation:    req.Moderation,
+		Attachments:   req.Attachments,
+		RetentionDays: req.RetentionDays,
+	}
+
+	err = s.settingsRepo.Upsert(ctx, settings)
+	if err != nil {
+		return entity.WorkspaceSettings{}, err
+	}
+
+	return s.settingsRepo.Get(ctx, settings.WorkspaceId)
+}
+
+// GetWorkspacesWithRetention returns the settings of workspaces that expire old messages
+func (s *settingsUsecase) GetWorkspacesWithRetention(ctx context.Context) ([]entity.WorkspaceSettings, error) {
+	return s.settingsRepo.GetWithRetention(ctx)
+}
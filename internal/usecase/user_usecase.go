@@ -2,41 +2,130 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
 	"wetalk/internal/entity"
 	"wetalk/internal/repository"
+	"wetalk/pkg/locale"
 )
 
+var (
+	ErrRestrictedAccount = errors.New("this feature is not available for restricted accounts")
+	ErrNameRequired      = errors.New("name cannot be empty")
+	ErrInvalidUsername   = errors.New("username must be at least 3 characters")
+	ErrBioTooLong        = errors.New("bio must be at most 160 characters")
+	ErrInvalidTimezone   = errors.New("timezone must be an IANA time zone name such as Europe/Paris")
+	ErrInvalidLocale     = errors.New("locale must be a language tag such as en-US")
+)
+
+const maxBioLength = 160
+
+// ProfilePolicy decides what other users can see of a profile
+type ProfilePolicy struct {
+	// ExposeEmail lists the email address in the public profile
+	ExposeEmail bool
+}
+
+func DefaultProfilePolicy() ProfilePolicy {
+	return ProfilePolicy{
+		ExposeEmail: false,
+	}
+}
+
+// Public returns the profile of the user as seen by other users
+func (p ProfilePolicy) Public(user entity.User) entity.PublicUser {
+	public := entity.PublicUser{
+		Id:       user.Id,
+		Username: user.Username,
+		Name:     user.Name,
+		Avatar:   user.Avatar,
+		Bio:      user.Bio,
+		Status:   entity.UserStatusOffline,
+	}
+	if user.IsOnline {
+		public.Status = entity.UserStatusOnline
+	}
+	if p.ExposeEmail {
+		public.Email = user.Email
+	}
+	return public
+}
+
 type UserUsecase interface {
-	Index(ctx context.Context) ([]entity.User, error)
+	Index(ctx context.Context, userId string) ([]entity.PublicUser, error)
+	GetComplianceReport(ctx context.Context) ([]entity.ComplianceStatus, error)
 	Get(ctx context.Context, userId string) (entity.User, error)
+	GetPublic(ctx context.Context, userId string) (entity.PublicUser, error)
 	Create(ctx context.Context, name string) (string, error)
 	Update(ctx context.Context, user entity.User) error
+	UpdateProfile(ctx context.Context, userId string, req entity.UpdateProfileRequest) (entity.User, error)
 	GetOnlineUser(ctx context.Context, userIds []string) ([]entity.User, error)
 	HandleUnregisterClient(ctx context.Context, userId string) (string, error)
 }
 
 type userUsecase struct {
-	userRepo repository.UserRepository
+	userRepo      repository.UserRepository
+	profilePolicy ProfilePolicy
 }
 
-func NewUserUseCase(userRepo repository.UserRepository) UserUsecase {
+func NewUserUseCase(userRepo repository.UserRepository, profilePolicy ProfilePolicy) UserUsecase {
 	return &userUsecase{
-		userRepo: userRepo,
+		userRepo:      userRepo,
+		profilePolicy: profilePolicy,
 	}
 }
 
-func (u *userUsecase) Index(ctx context.Context) ([]entity.User, error) {
+// Index lists the user directory. Restricted accounts can't browse it and are never listed in it
+func (u *userUsecase) Index(ctx context.Context, userId string) ([]entity.PublicUser, error) {
+	requester, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if requester.IsRestricted {
+		return nil, ErrRestrictedAccount
+	}
+
 	users, err := u.userRepo.Index(ctx, entity.UserIndexFilter{})
 	if err != nil {
 		return nil, err
 	}
 
-	// Don't expose passwords
-	for i := range users {
-		users[i].Password = ""
+	visible := make([]entity.PublicUser, 0, len(users))
+	for _, user := range users {
+		if user.IsRestricted {
+			continue
+		}
+
+		visible = append(visible, u.profilePolicy.Public(user))
 	}
 
-	return users, nil
+	return visible, nil
+}
+
+func (u *userUsecase) GetComplianceReport(ctx context.Context) ([]entity.ComplianceStatus, error) {
+	users, err := u.userRepo.Index(ctx, entity.UserIndexFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	report := make([]entity.ComplianceStatus, 0, len(users))
+	for _, user := range users {
+		status := entity.ComplianceStatus{
+			UserId:       user.Id,
+			Username:     user.Username,
+			HasBirthdate: user.Birthdate != nil,
+			IsRestricted: user.IsRestricted,
+		}
+		if status.HasBirthdate {
+			status.Age = user.AgeAt(now)
+		}
+		report = append(report, status)
+	}
+
+	return report, nil
 }
 
 func (u *userUsecase) Get(ctx context.Context, userId string) (entity.User, error) {
@@ -50,6 +139,16 @@ func (u *userUsecase) Get(ctx context.Context, userId string) (entity.User, erro
 	return user, nil
 }
 
+// GetPublic returns the profile of a user as seen by other users
+func (u *userUsecase) GetPublic(ctx context.Context, userId string) (entity.PublicUser, error) {
+	user, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.PublicUser{}, err
+	}
+
+	return u.profilePolicy.Public(user), nil
+}
+
 func (u *userUsecase) Create(ctx context.Context, name string) (string, error) {
 	user := entity.User{
 		Name:     name,
@@ -63,6 +162,69 @@ func (u *userUsecase) Update(ctx context.Context, user entity.User) error {
 	return u.userRepo.Update(ctx, user)
 }
 
+// UpdateProfile changes the self-service profile of a user, usernames stay unique
+func (u *userUsecase) UpdateProfile(ctx context.Context, userId string, req entity.UpdateProfileRequest) (entity.User, error) {
+	user, err := u.userRepo.Get(ctx, userId)
+	if err != nil {
+		return entity.User{}, err
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return entity.User{}, invalidField("name", ErrNameRequired)
+		}
+		user.Name = name
+	}
+	if req.Username != nil && strings.TrimSpace(*req.Username) != user.Username {
+		username := strings.TrimSpace(*req.Username)
+		if len(username) < 3 {
+			return entity.User{}, invalidField("username", ErrInvalidUsername)
+		}
+
+		exists, err := u.userRepo.UsernameExists(ctx, username)
+		if err != nil {
+			return entity.User{}, err
+		}
+		if exists {
+			return entity.User{}, ErrUsernameAlreadyTaken
+		}
+		user.Username = username
+	}
+	if req.Bio != nil {
+		if utf8.RuneCountInString(*req.Bio) > maxBioLength {
+			return entity.User{}, invalidField("bio", ErrBioTooLong)
+		}
+		user.Bio = *req.Bio
+	}
+	if req.Avatar != nil {
+		user.Avatar = *req.Avatar
+	}
+	if req.Timezone != nil {
+		timezone := strings.TrimSpace(*req.Timezone)
+		// LoadLocation also accepts "Local", which is the server's and not a preference
+		if _, err := time.LoadLocation(timezone); timezone != "" && (err != nil || timezone == "Local") {
+			return entity.User{}, invalidField("timezone", ErrInvalidTimezone)
+		}
+		user.Timezone = timezone
+	}
+	if req.Locale != nil {
+		tag := strings.TrimSpace(*req.Locale)
+		if tag != "" && !locale.Valid(tag) {
+			return entity.User{}, invalidField("locale", ErrInvalidLocale)
+		}
+		user.Locale = tag
+	}
+
+	if err := u.userRepo.UpdateProfile(ctx, user); err != nil {
+		return entity.User{}, err
+	}
+
+	// Don't expose password
+	user.Password = ""
+	return user, nil
+}
+
 func (u *userUsecase) GetOnlineUser(ctx context.Context, userIds []string) ([]entity.User, error) {
 	users, err := u.userRepo.GetOnlineUser(ctx, userIds)
 	if err != nil {
@@ -1,7 +1,45 @@
 package main
 
-import "wetalk/cmd/server"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"wetalk/cmd/server"
+	"wetalk/pkg/config"
+	"wetalk/pkg/logger"
+
+	"github.com/joho/godotenv"
+)
 
 func main() {
-	server.Run()
+	check := flag.Bool("check", false, "validate the configuration, check the connections to MongoDB, Redis and NATS and the indexes, print a report and exit")
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		fmt.Println("godotenv: error loading .env file")
+	}
+
+	// The self-check reports the invalid settings itself, it exits 1 when the server couldn't start
+	if *check {
+		if !server.Check(context.Background(), os.Stdout, os.Getenv) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("Load config error", "error", err)
+		os.Exit(1)
+	}
+
+	slog.SetDefault(logger.New(os.Stderr, cfg.Log.Format, cfg.Log.Level))
+
+	if err := server.NewServer(cfg).Run(); err != nil {
+		slog.Error("Server error", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,97 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderReCaptcha = "recaptcha"
+
+	hCaptchaVerifyUrl  = "https://api.hcaptcha.com/siteverify"
+	reCaptchaVerifyUrl = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown captcha provider")
+)
+
+// Verifier checks a captcha token solved by the client
+type Verifier interface {
+	Verify(ctx context.Context, token string, remoteIp string) (bool, error)
+}
+
+// siteVerifier talks to the siteverify endpoint shared by hCaptcha and reCAPTCHA
+type siteVerifier struct {
+	verifyUrl  string
+	secret     string
+	httpClient *http.Client
+}
+
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// NewVerifier creates the verifier of the given provider
+func NewVerifier(provider string, secret string) (Verifier, error) {
+	switch provider {
+	case ProviderHCaptcha:
+		return newSiteVerifier(hCaptchaVerifyUrl, secret), nil
+	case ProviderReCaptcha:
+		return newSiteVerifier(reCaptchaVerifyUrl, secret), nil
+	default:
+		return nil, ErrUnknownProvider
+	}
+}
+
+func newSiteVerifier(verifyUrl string, secret string) Verifier {
+	return &siteVerifier{
+		verifyUrl:  verifyUrl,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (v *siteVerifier) Verify(ctx context.Context, token string, remoteIp string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	form := url.Values{}
+	form.Set("secret", v.secret)
+	form.Set("response", token)
+	if remoteIp != "" {
+		form.Set("remoteip", remoteIp)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("captcha verification failed with status %d", resp.StatusCode)
+	}
+
+	var result siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+
+	return result.Success, nil
+}
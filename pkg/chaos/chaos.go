@@ -0,0 +1,146 @@
+// Package chaos injects faults into the Mongo, Redis and websocket layers so resilience tests
+// can exercise the outbox, the retries and the client reconnection logic. It is meant for test
+// environments only, a nil Injector injects nothing
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// disconnectCheckInterval is how often a connection rolls for a disconnect
+const disconnectCheckInterval = 10 * time.Second
+
+// ErrInjected is returned by the operations failed on purpose
+var ErrInjected = errors.New("chaos: injected failure")
+
+type Config struct {
+	// MongoLatency is added before a share of the Mongo commands, given by MongoLatencyRate
+	MongoLatency     time.Duration
+	MongoLatencyRate float64
+	// RedisPublishFailureRate is the share of Redis PUBLISH and XADD commands that fail
+	RedisPublishFailureRate float64
+	// WebsocketDisconnectRate is the chance a websocket is dropped on every check, every ten seconds
+	WebsocketDisconnectRate float64
+}
+
+type Injector struct {
+	cfg Config
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New returns an injector for the configuration, rates are chances between 0 and 1
+func New(cfg Config) *Injector {
+	return &Injector{
+		cfg:  cfg,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// roll reports whether an event of the given chance happens
+func (i *Injector) roll(rate float64) bool {
+	if i == nil || rate <= 0 {
+		return false
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rand.Float64() < rate
+}
+
+// MongoMonitor delays the commands as they are started, nil when no latency is configured
+func (i *Injector) MongoMonitor() *event.CommandMonitor {
+	if i == nil || i.cfg.MongoLatency <= 0 || i.cfg.MongoLatencyRate <= 0 {
+		return nil
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, _ *event.CommandStartedEvent) {
+			if !i.roll(i.cfg.MongoLatencyRate) {
+				return
+			}
+
+			timer := time.NewTimer(i.cfg.MongoLatency)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+		},
+	}
+}
+
+// RedisHooks returns the hooks failing Redis publishes, to channels or streams, none when no
+// failure rate is configured
+func (i *Injector) RedisHooks() []redis.Hook {
+	if i == nil || i.cfg.RedisPublishFailureRate <= 0 {
+		return nil
+	}
+	return []redis.Hook{publishFailureHook{injector: i}}
+}
+
+// DisconnectRandomly calls disconnect at random until the context is done, it returns once
+// it disconnected or the context is done
+func (i *Injector) DisconnectRandomly(ctx context.Context, disconnect func()) {
+	if i == nil || i.cfg.WebsocketDisconnectRate <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(disconnectCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if i.roll(i.cfg.WebsocketDisconnectRate) {
+				disconnect()
+				return
+			}
+		}
+	}
+}
+
+type publishFailureHook struct {
+	injector *Injector
+}
+
+func (h publishFailureHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h publishFailureHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if isPublish(cmd) && h.injector.roll(h.injector.cfg.RedisPublishFailureRate) {
+			cmd.SetErr(ErrInjected)
+			return ErrInjected
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h publishFailureHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if isPublish(cmd) && h.injector.roll(h.injector.cfg.RedisPublishFailureRate) {
+				cmd.SetErr(ErrInjected)
+				return ErrInjected
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func isPublish(cmd redis.Cmder) bool {
+	return strings.EqualFold(cmd.Name(), "publish") || strings.EqualFold(cmd.Name(), "xadd")
+}
@@ -0,0 +1,897 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wetalk/pkg/captcha"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+
+	// developmentJWTSecret is only accepted outside of production
+	developmentJWTSecret = "your-secret-key-change-this-in-production"
+)
+
+type Config struct {
+	Env         string
+	Log         LogConfig
+	Server      ServerConfig
+	Mongo       MongoConfig
+	Redis       RedisConfig
+	NATS        NATSConfig
+	Websocket   WebsocketConfig
+	JWT         JWTConfig
+	CORS        CORSConfig
+	API         APIConfig
+	Age         AgeConfig
+	Captcha     CaptchaConfig
+	TwoFactor   TwoFactorConfig
+	Profile     ProfileConfig
+	Chat        ChatConfig
+	Session     SessionConfig
+	Abuse       AbuseConfig
+	Push        PushConfig
+	Metrics     MetricsConfig
+	Chaos       ChaosConfig
+	Tracing     TracingConfig
+	Activity    ActivityConfig
+	Security    SecurityConfig
+	Moderation  ModerationConfig
+	LinkPreview LinkPreviewConfig
+}
+
+type LogConfig struct {
+	// Format is "text" or "json"
+	Format string
+	Level  slog.Level
+}
+
+type ServerConfig struct {
+	Port     string
+	ServerId string
+	TLS      TLSConfig
+	// GRPCPort serves the gRPC API when set, the binary must be built with the "grpc" tag
+	GRPCPort string
+}
+
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+type MongoConfig struct {
+	URI      string
+	Database string
+	// Transactions stores a message and its fan-out atomically, it needs a replica set
+	Transactions bool
+}
+
+type RedisConfig struct {
+	Addr string
+	// HubTransport is how servers exchange messages, "pubsub" or "streams" which survives
+	// short disconnections from Redis
+	HubTransport string
+	// HubShadowTransport is published to as well and only compared with HubTransport, to validate
+	// it before switching to it. Empty disables the shadow mode
+	HubShadowTransport string
+}
+
+// Enabled reports whether the Redis hub and stores should be used
+func (r RedisConfig) Enabled() bool {
+	return r.Addr != ""
+}
+
+type NATSConfig struct {
+	// URL is nats:// or tls://, credentials go in the user info
+	URL string
+}
+
+// Enabled reports whether the NATS hub should be used, it takes over from the Redis hub
+func (n NATSConfig) Enabled() bool {
+	return n.URL != ""
+}
+
+type WebsocketConfig struct {
+	// PingInterval is how often the server pings every websocket
+	PingInterval time.Duration
+	// PongTimeout is how long a silent websocket stays open, it must leave room for a ping and its pong
+	PongTimeout time.Duration
+	// SendQueueSize bounds the frames waiting for a connection, newer frames are dropped beyond
+	SendQueueSize int
+	// SlowClientTimeout is how long a connection may keep a full queue before it is closed, never when zero
+	SlowClientTimeout time.Duration
+	// CoalescedEvents are the event types replaced in the queue by a newer one about the same chat
+	CoalescedEvents []string
+	// EnvelopeV2MinAppVersion is the first app version reading chat messages in the v2 envelope,
+	// every connection keeps the v1 envelope when empty
+	EnvelopeV2MinAppVersion string
+}
+
+type JWTConfig struct {
+	Secret               string
+	AccessTokenDuration  time.Duration
+	RefreshTokenDuration time.Duration
+}
+
+type CORSConfig struct {
+	// AllowedOrigins may contain "*" and wildcard subdomains such as "https://*.example.com"
+	AllowedOrigins   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+	// ExcludedPaths are path prefixes served without CORS headers
+	ExcludedPaths []string
+}
+
+type APIConfig struct {
+	// DefaultVersion is the response format of the requests without an API-Version header
+	DefaultVersion string
+	// RateLimitRequests are allowed per user and RateLimitWindow, 0 disables the rate limit
+	RateLimitRequests int
+	RateLimitWindow   time.Duration
+	// RateLimitEnforce answers the requests over the limit with 429, otherwise the limit is only
+	// advertised in the X-RateLimit headers
+	RateLimitEnforce bool
+}
+
+type AgeConfig struct {
+	MinimumAge int
+	AdultAge   int
+}
+
+type CaptchaConfig struct {
+	Provider       string
+	Secret         string
+	LoginThreshold int
+}
+
+type TwoFactorConfig struct {
+	// Issuer is the account issuer shown in authenticator apps
+	Issuer string
+}
+
+type ProfileConfig struct {
+	// ExposeEmail shows email addresses to other users
+	ExposeEmail bool
+}
+
+type ChatConfig struct {
+	EmptyChatGracePeriod time.Duration
+	// InvitationTTL is how long an invitation stays pending before the maintenance job expires it
+	InvitationTTL time.Duration
+	// InviteLinkTTL is how long an invite link created without an expiry lets users join
+	InviteLinkTTL time.Duration
+	// MaxPinnedMessages is how many messages a chat can have pinned at once
+	MaxPinnedMessages int
+	// DeletedChatRetention is how long a deleted chat can be restored before it is purged with its messages
+	DeletedChatRetention time.Duration
+	// ReplayWindowSize is the number of recent messages kept per chat for instant replays
+	ReplayWindowSize int
+	// DefaultChatIds are the group chats every new user joins, such as #general or #announcements
+	DefaultChatIds []string
+	// DefaultHistoryAccess is the history new members of a group read unless its admins chose
+	// otherwise: "none", "last_24h" or "all"
+	DefaultHistoryAccess string
+}
+
+type SessionConfig struct {
+	GuestSessionTTL time.Duration
+}
+
+type AbuseConfig struct {
+	// Rules replace the built-in auto-moderation rules when set
+	Rules               []AbuseRule
+	LimitedSendInterval time.Duration
+}
+
+type MetricsConfig struct {
+	// Token is the bearer token Prometheus scrapes /metrics with, the endpoint is disabled when empty
+	Token string
+	// StorageInterval is how often the storage of every workspace is measured
+	StorageInterval time.Duration
+}
+
+// ChaosConfig injects faults for resilience tests, it must stay disabled in production
+type ChaosConfig struct {
+	Enabled          bool
+	MongoLatency     time.Duration
+	MongoLatencyRate float64
+	// RedisPublishFailureRate is the share of Redis publishes that fail
+	RedisPublishFailureRate float64
+	// WebsocketDisconnectRate is the chance a websocket is dropped every ten seconds
+	WebsocketDisconnectRate float64
+}
+
+// TracingConfig exports traces to an OpenTelemetry collector, it uses the standard OTEL_* variables
+type TracingConfig struct {
+	ServiceName string
+	// Endpoint is the OTLP/HTTP base URL of the collector, tracing is disabled when empty
+	Endpoint string
+	Headers  map[string]string
+	// SampleRate is the share of new traces recorded
+	SampleRate float64
+}
+
+// Enabled reports whether traces should be exported
+func (t TracingConfig) Enabled() bool {
+	return t.Endpoint != ""
+}
+
+// TracesURL is the OTLP/HTTP traces URL under the endpoint
+func (t TracingConfig) TracesURL() string {
+	return strings.TrimSuffix(t.Endpoint, "/") + "/v1/traces"
+}
+
+// ActivityConfig exports the chat activity (messages, chats, registrations and invitation
+// answers) to the pipelines outside the service
+type ActivityConfig struct {
+	// Export is "none", "memory" which keeps the latest events in memory, or "kafka"
+	Export string
+	// KafkaBrokers are the bootstrap brokers as host:port
+	KafkaBrokers []string
+	KafkaTopic   string
+	// KafkaTLS connects to the brokers over TLS
+	KafkaTLS bool
+	// KafkaSASLMechanism is "PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512", the brokers are not
+	// authenticated against when empty
+	KafkaSASLMechanism string
+	KafkaUsername      string
+	KafkaPassword      string
+}
+
+// SecurityConfig reports the authentication anomalies, such as a login from a new device, events
+// are only logged when no webhook is set
+type SecurityConfig struct {
+	// WebhookURL receives every security event, such as the HTTP collector of a SIEM
+	WebhookURL    string
+	WebhookSecret string
+	// FailedLoginThreshold is the number of failed logins on an email after which it is reported, 0 disables it
+	FailedLoginThreshold int
+	// NotifyUsers emails the owner of the account about each event through the SMTP relay
+	NotifyUsers bool
+	SMTP        SMTPConfig
+}
+
+type SMTPConfig struct {
+	// Addr is the relay as host:port
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// ModerationConfig adds an external moderation service to the built-in word lists, it is not
+// called when no URL is set
+type ModerationConfig struct {
+	HTTPURL     string
+	HTTPToken   string
+	HTTPTimeout time.Duration
+	// FailOpen stores the messages unchecked while the service fails, otherwise they are refused
+	FailOpen bool
+}
+
+// LinkPreviewConfig previews the first link of the messages, the server reads the linked pages
+// itself so it can be turned off where that is not wanted
+type LinkPreviewConfig struct {
+	Enabled bool
+	// Timeout bounds the reading of one page, redirects included
+	Timeout time.Duration
+}
+
+// PushConfig enables the push providers, notifications are only logged when none is set
+type PushConfig struct {
+	// FCMCredentialsFile is the Firebase service account key, used for Android and web devices
+	FCMCredentialsFile string
+	APNs               APNsConfig
+	// WebhookURL receives the notifications of the platforms without a configured provider
+	WebhookURL    string
+	WebhookSecret string
+}
+
+type APNsConfig struct {
+	KeyFile string
+	KeyId   string
+	TeamId  string
+	Topic   string
+	Sandbox bool
+}
+
+// Enabled reports whether iOS devices should be pushed through APNs
+func (a APNsConfig) Enabled() bool {
+	return a.KeyFile != ""
+}
+
+type AbuseRule struct {
+	Signal    string
+	Threshold int
+	Window    time.Duration
+	Action    string
+	// Duration of the action, zero keeps it until an admin lifts it
+	Duration time.Duration
+}
+
+// Load reads the configuration from the environment
+func Load() (Config, error) {
+	return LoadFrom(os.Getenv)
+}
+
+// LoadFrom reads the configuration through the given lookup function, every invalid
+// setting is reported at once
+func LoadFrom(getenv func(string) string) (Config, error) {
+	p := &parser{getenv: getenv}
+
+	cfg := Config{
+		Env: p.string("APP_ENV", EnvDevelopment),
+		Log: LogConfig{
+			Format: p.string("LOG_FORMAT", "text"),
+			Level:  p.level("LOG_LEVEL", slog.LevelInfo),
+		},
+		Server: ServerConfig{
+			Port:     p.string("PORT", "8080"),
+			ServerId: p.string("SERVER_ID", "server-1"),
+			TLS: TLSConfig{
+				CertFile: p.string("TLS_CERT_FILE", ""),
+				KeyFile:  p.string("TLS_KEY_FILE", ""),
+			},
+			GRPCPort: p.string("GRPC_PORT", ""),
+		},
+		Mongo: MongoConfig{
+			URI:          p.string("MONGODB_URI", "mongodb://localhost:27017"),
+			Database:     p.string("MONGODB_DATABASE", ""),
+			Transactions: p.bool("MONGODB_TRANSACTIONS", false),
+		},
+		Redis: RedisConfig{
+			Addr:               p.string("REDIS_ADDR", ""),
+			HubTransport:       p.string("REDIS_HUB_TRANSPORT", "pubsub"),
+			HubShadowTransport: p.string("REDIS_HUB_SHADOW_TRANSPORT", ""),
+		},
+		NATS: NATSConfig{
+			URL: p.string("NATS_URL", ""),
+		},
+		Websocket: WebsocketConfig{
+			PingInterval: p.duration("WS_PING_INTERVAL", 54*time.Second),
+			PongTimeout:  p.duration("WS_PONG_TIMEOUT", 60*time.Second),
+
+			SendQueueSize:     p.int("WS_SEND_QUEUE_SIZE", 1024),
+			SlowClientTimeout: p.duration("WS_SLOW_CLIENT_TIMEOUT", 30*time.Second),
+			CoalescedEvents:   p.list("WS_COALESCED_EVENTS", []string{"chat_viewers"}),
+
+			EnvelopeV2MinAppVersion: p.string("WS_ENVELOPE_V2_MIN_APP_VERSION", ""),
+		},
+		JWT: JWTConfig{
+			Secret:               p.string("JWT_SECRET", ""),
+			AccessTokenDuration:  p.duration("JWT_ACCESS_TOKEN_DURATION", 15*time.Minute),
+			RefreshTokenDuration: p.duration("JWT_REFRESH_TOKEN_DURATION", 30*24*time.Hour),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   p.list("CORS_ALLOWED_ORIGINS", []string{"http://localhost:3000"}),
+			AllowCredentials: p.bool("CORS_ALLOW_CREDENTIALS", true),
+			MaxAge:           p.duration("CORS_MAX_AGE", 10*time.Minute),
+			ExcludedPaths:    p.list("CORS_EXCLUDED_PATHS", []string{}),
+		},
+		API: APIConfig{
+			DefaultVersion:    p.string("API_DEFAULT_VERSION", "1"),
+			RateLimitRequests: p.int("RATE_LIMIT_REQUESTS", 600),
+			RateLimitWindow:   p.duration("RATE_LIMIT_WINDOW", time.Minute),
+			RateLimitEnforce:  p.bool("RATE_LIMIT_ENFORCE", false),
+		},
+		Age: AgeConfig{
+			MinimumAge: p.int("MINIMUM_AGE", 13),
+			AdultAge:   p.int("ADULT_AGE", 18),
+		},
+		Captcha: CaptchaConfig{
+			Provider:       p.string("CAPTCHA_PROVIDER", ""),
+			Secret:         p.string("CAPTCHA_SECRET", ""),
+			LoginThreshold: p.int("CAPTCHA_LOGIN_THRESHOLD", 3),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer: p.string("TWO_FACTOR_ISSUER", "Wetalk"),
+		},
+		Profile: ProfileConfig{
+			ExposeEmail: p.bool("PROFILE_EXPOSE_EMAIL", false),
+		},
+		Chat: ChatConfig{
+			EmptyChatGracePeriod: p.duration("EMPTY_CHAT_GRACE_PERIOD", 24*time.Hour),
+			InvitationTTL:        p.duration("INVITATION_TTL", 30*24*time.Hour),
+			InviteLinkTTL:        p.duration("INVITE_LINK_TTL", 7*24*time.Hour),
+			MaxPinnedMessages:    p.int("MAX_PINNED_MESSAGES", 50),
+			DeletedChatRetention: p.duration("DELETED_CHAT_RETENTION", 30*24*time.Hour),
+			ReplayWindowSize:     p.int("CHAT_REPLAY_WINDOW_SIZE", 50),
+			DefaultChatIds:       p.list("DEFAULT_CHAT_IDS", []string{}),
+			DefaultHistoryAccess: p.string("CHAT_DEFAULT_HISTORY_ACCESS", "all"),
+		},
+		Session: SessionConfig{
+			GuestSessionTTL: p.duration("GUEST_SESSION_TTL", 24*time.Hour),
+		},
+		Abuse: AbuseConfig{
+			Rules:               p.abuseRules("ABUSE_RULES"),
+			LimitedSendInterval: p.duration("ABUSE_LIMITED_SEND_INTERVAL", 30*time.Second),
+		},
+		Push: PushConfig{
+			FCMCredentialsFile: p.string("PUSH_FCM_CREDENTIALS_FILE", ""),
+			APNs: APNsConfig{
+				KeyFile: p.string("PUSH_APNS_KEY_FILE", ""),
+				KeyId:   p.string("PUSH_APNS_KEY_ID", ""),
+				TeamId:  p.string("PUSH_APNS_TEAM_ID", ""),
+				Topic:   p.string("PUSH_APNS_TOPIC", ""),
+				Sandbox: p.bool("PUSH_APNS_SANDBOX", false),
+			},
+			WebhookURL:    p.string("PUSH_WEBHOOK_URL", ""),
+			WebhookSecret: p.string("PUSH_WEBHOOK_SECRET", ""),
+		},
+		Metrics: MetricsConfig{
+			Token:           p.string("METRICS_TOKEN", ""),
+			StorageInterval: p.duration("METRICS_STORAGE_INTERVAL", 15*time.Minute),
+		},
+		Chaos: ChaosConfig{
+			Enabled:                 p.bool("CHAOS_ENABLED", false),
+			MongoLatency:            p.duration("CHAOS_MONGO_LATENCY", 0),
+			MongoLatencyRate:        p.rate("CHAOS_MONGO_LATENCY_RATE", 0),
+			RedisPublishFailureRate: p.rate("CHAOS_REDIS_PUBLISH_FAILURE_RATE", 0),
+			WebsocketDisconnectRate: p.rate("CHAOS_WS_DISCONNECT_RATE", 0),
+		},
+		Tracing: TracingConfig{
+			ServiceName: p.string("OTEL_SERVICE_NAME", "wetalk"),
+			Endpoint:    p.string("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+			Headers:     p.pairs("OTEL_EXPORTER_OTLP_HEADERS"),
+			SampleRate:  p.rate("OTEL_TRACES_SAMPLER_ARG", 1),
+		},
+		Activity: ActivityConfig{
+			Export:       p.string("ACTIVITY_EXPORT", "none"),
+			KafkaBrokers: p.list("KAFKA_BROKERS", []string{}),
+			KafkaTopic:   p.string("KAFKA_ACTIVITY_TOPIC", "wetalk.activity"),
+			KafkaTLS:     p.bool("KAFKA_TLS", false),
+
+			KafkaSASLMechanism: p.string("KAFKA_SASL_MECHANISM", ""),
+			KafkaUsername:      p.string("KAFKA_USERNAME", ""),
+			KafkaPassword:      p.string("KAFKA_PASSWORD", ""),
+		},
+		Security: SecurityConfig{
+			WebhookURL:           p.string("SECURITY_WEBHOOK_URL", ""),
+			WebhookSecret:        p.string("SECURITY_WEBHOOK_SECRET", ""),
+			FailedLoginThreshold: p.int("SECURITY_FAILED_LOGIN_THRESHOLD", 10),
+			NotifyUsers:          p.bool("SECURITY_NOTIFY_USERS", false),
+			SMTP: SMTPConfig{
+				Addr:     p.string("SMTP_ADDR", ""),
+				Username: p.string("SMTP_USERNAME", ""),
+				Password: p.string("SMTP_PASSWORD", ""),
+				From:     p.string("SMTP_FROM", ""),
+			},
+		},
+		Moderation: ModerationConfig{
+			HTTPURL:     p.string("MODERATION_HTTP_URL", ""),
+			HTTPToken:   p.string("MODERATION_HTTP_TOKEN", ""),
+			HTTPTimeout: p.duration("MODERATION_HTTP_TIMEOUT", 2*time.Second),
+			FailOpen:    p.bool("MODERATION_FAIL_OPEN", true),
+		},
+		LinkPreview: LinkPreviewConfig{
+			Enabled: p.bool("LINK_PREVIEWS_ENABLED", true),
+			Timeout: p.duration("LINK_PREVIEW_TIMEOUT", 5*time.Second),
+		},
+	}
+
+	if cfg.JWT.Secret == "" && cfg.Env != EnvProduction {
+		cfg.JWT.Secret = developmentJWTSecret
+	}
+
+	p.errs = append(p.errs, cfg.validate()...)
+	if len(p.errs) > 0 {
+		return Config{}, fmt.Errorf("invalid configuration: %w", errors.Join(p.errs...))
+	}
+
+	return cfg, nil
+}
+
+// UsesDevelopmentSecret reports whether the JWT secret is the insecure development default
+func (c Config) UsesDevelopmentSecret() bool {
+	return c.JWT.Secret == developmentJWTSecret
+}
+
+func (c Config) validate() []error {
+	var errs []error
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		errs = append(errs, fmt.Errorf("APP_ENV must be %q or %q", EnvDevelopment, EnvProduction))
+	}
+
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, errors.New("LOG_FORMAT must be text or json"))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, errors.New("PORT must be a number between 1 and 65535"))
+	}
+	if c.Server.GRPCPort != "" {
+		if port, err := strconv.Atoi(c.Server.GRPCPort); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, errors.New("GRPC_PORT must be a number between 1 and 65535"))
+		} else if c.Server.GRPCPort == c.Server.Port {
+			errs = append(errs, errors.New("GRPC_PORT must differ from PORT"))
+		}
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	if c.Mongo.Database == "" {
+		errs = append(errs, errors.New("MONGODB_DATABASE is required"))
+	}
+	if _, err := url.Parse(c.Mongo.URI); err != nil || !strings.HasPrefix(c.Mongo.URI, "mongodb") {
+		errs = append(errs, errors.New("MONGODB_URI must be a mongodb:// or mongodb+srv:// URI"))
+	}
+
+	if c.Redis.HubTransport != "pubsub" && c.Redis.HubTransport != "streams" {
+		errs = append(errs, errors.New("REDIS_HUB_TRANSPORT must be pubsub or streams"))
+	}
+	if c.Redis.HubShadowTransport != "" {
+		if c.Redis.HubShadowTransport != "pubsub" && c.Redis.HubShadowTransport != "streams" {
+			errs = append(errs, errors.New("REDIS_HUB_SHADOW_TRANSPORT must be pubsub or streams"))
+		} else if c.Redis.HubShadowTransport == c.Redis.HubTransport {
+			errs = append(errs, errors.New("REDIS_HUB_SHADOW_TRANSPORT must differ from REDIS_HUB_TRANSPORT"))
+		}
+	}
+	if c.NATS.Enabled() {
+		if u, err := url.Parse(c.NATS.URL); err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			errs = append(errs, errors.New("NATS_URL must be a nats:// or tls:// URL"))
+		}
+	}
+
+	if c.Websocket.PingInterval <= 0 {
+		errs = append(errs, errors.New("WS_PING_INTERVAL must be positive"))
+	} else if c.Websocket.PongTimeout <= c.Websocket.PingInterval {
+		errs = append(errs, errors.New("WS_PONG_TIMEOUT must be longer than WS_PING_INTERVAL"))
+	}
+	if c.Websocket.SendQueueSize <= 0 {
+		errs = append(errs, errors.New("WS_SEND_QUEUE_SIZE must be positive"))
+	}
+	if c.Websocket.SlowClientTimeout < 0 {
+		errs = append(errs, errors.New("WS_SLOW_CLIENT_TIMEOUT must not be negative"))
+	}
+
+	if c.JWT.Secret == "" {
+		errs = append(errs, errors.New("JWT_SECRET is required in production"))
+	}
+	if c.JWT.AccessTokenDuration <= 0 || c.JWT.RefreshTokenDuration <= 0 {
+		errs = append(errs, errors.New("JWT token durations must be positive"))
+	} else if c.JWT.AccessTokenDuration >= c.JWT.RefreshTokenDuration {
+		errs = append(errs, errors.New("JWT_ACCESS_TOKEN_DURATION must be shorter than JWT_REFRESH_TOKEN_DURATION"))
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS must list at least one origin"))
+	}
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS has an invalid origin %q", origin))
+			continue
+		}
+		if strings.Contains(parsed.Host, "*") && (!strings.HasPrefix(parsed.Host, "*.") || strings.Count(parsed.Host, "*") > 1) {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS wildcards must look like https://*.example.com, got %q", origin))
+		}
+	}
+	for _, path := range c.CORS.ExcludedPaths {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("CORS_EXCLUDED_PATHS entries must start with /, got %q", path))
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("CORS_MAX_AGE can't be negative"))
+	}
+
+	if c.API.RateLimitRequests < 0 {
+		errs = append(errs, errors.New("RATE_LIMIT_REQUESTS can't be negative"))
+	}
+	if c.API.RateLimitWindow <= 0 {
+		errs = append(errs, errors.New("RATE_LIMIT_WINDOW must be positive"))
+	}
+
+	if c.Age.MinimumAge < 0 || c.Age.AdultAge < c.Age.MinimumAge {
+		errs = append(errs, errors.New("ADULT_AGE must be at least MINIMUM_AGE and ages can't be negative"))
+	}
+
+	switch c.Captcha.Provider {
+	case "":
+	case captcha.ProviderHCaptcha, captcha.ProviderReCaptcha:
+		if c.Captcha.Secret == "" {
+			errs = append(errs, errors.New("CAPTCHA_SECRET is required when CAPTCHA_PROVIDER is set"))
+		}
+	default:
+		errs = append(errs, errors.New(`CAPTCHA_PROVIDER must be "hcaptcha" or "recaptcha"`))
+	}
+	if c.Captcha.LoginThreshold < 0 {
+		errs = append(errs, errors.New("CAPTCHA_LOGIN_THRESHOLD can't be negative"))
+	}
+
+	if c.Chat.EmptyChatGracePeriod < 0 {
+		errs = append(errs, errors.New("EMPTY_CHAT_GRACE_PERIOD can't be negative"))
+	}
+	if c.Chat.InvitationTTL <= 0 {
+		errs = append(errs, errors.New("INVITATION_TTL must be positive"))
+	}
+	if c.Chat.InviteLinkTTL <= 0 {
+		errs = append(errs, errors.New("INVITE_LINK_TTL must be positive"))
+	}
+	if c.Chat.MaxPinnedMessages <= 0 {
+		errs = append(errs, errors.New("MAX_PINNED_MESSAGES must be positive"))
+	}
+	if c.Chat.DeletedChatRetention <= 0 {
+		errs = append(errs, errors.New("DELETED_CHAT_RETENTION must be positive"))
+	}
+	if c.Chat.ReplayWindowSize <= 0 {
+		errs = append(errs, errors.New("CHAT_REPLAY_WINDOW_SIZE must be positive"))
+	}
+	if c.Chat.DefaultHistoryAccess != "none" && c.Chat.DefaultHistoryAccess != "last_24h" && c.Chat.DefaultHistoryAccess != "all" {
+		errs = append(errs, errors.New("CHAT_DEFAULT_HISTORY_ACCESS must be none, last_24h or all"))
+	}
+	if c.Session.GuestSessionTTL <= 0 {
+		errs = append(errs, errors.New("GUEST_SESSION_TTL must be positive"))
+	}
+
+	for _, rule := range c.Abuse.Rules {
+		switch rule.Signal {
+		case "report", "spam", "moderation_hit":
+		default:
+			errs = append(errs, fmt.Errorf("ABUSE_RULES signal must be report, spam or moderation_hit, got %q", rule.Signal))
+		}
+		switch rule.Action {
+		case "rate_limit", "mute", "suspend":
+		default:
+			errs = append(errs, fmt.Errorf("ABUSE_RULES action must be rate_limit, mute or suspend, got %q", rule.Action))
+		}
+		if rule.Threshold <= 0 || rule.Window <= 0 || rule.Duration < 0 {
+			errs = append(errs, errors.New("ABUSE_RULES thresholds and windows must be positive and durations can't be negative"))
+		}
+	}
+	if c.Abuse.LimitedSendInterval <= 0 {
+		errs = append(errs, errors.New("ABUSE_LIMITED_SEND_INTERVAL must be positive"))
+	}
+
+	if c.Push.APNs.Enabled() && (c.Push.APNs.KeyId == "" || c.Push.APNs.TeamId == "" || c.Push.APNs.Topic == "") {
+		errs = append(errs, errors.New("PUSH_APNS_KEY_ID, PUSH_APNS_TEAM_ID and PUSH_APNS_TOPIC are required when PUSH_APNS_KEY_FILE is set"))
+	}
+	if c.Push.WebhookURL != "" {
+		if parsed, err := url.Parse(c.Push.WebhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, errors.New("PUSH_WEBHOOK_URL must be an http or https URL"))
+		}
+	}
+
+	if c.Metrics.StorageInterval <= 0 {
+		errs = append(errs, errors.New("METRICS_STORAGE_INTERVAL must be positive"))
+	}
+
+	if c.Chaos.MongoLatency < 0 {
+		errs = append(errs, errors.New("CHAOS_MONGO_LATENCY can't be negative"))
+	}
+
+	if c.Tracing.Enabled() {
+		if parsed, err := url.Parse(c.Tracing.Endpoint); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, errors.New("OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL"))
+		}
+	}
+
+	switch c.Activity.Export {
+	case "none", "memory":
+	case "kafka":
+		if len(c.Activity.KafkaBrokers) == 0 {
+			errs = append(errs, errors.New("KAFKA_BROKERS is required when ACTIVITY_EXPORT is kafka"))
+		}
+		if c.Activity.KafkaTopic == "" {
+			errs = append(errs, errors.New("KAFKA_ACTIVITY_TOPIC is required when ACTIVITY_EXPORT is kafka"))
+		}
+		switch c.Activity.KafkaSASLMechanism {
+		case "":
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if c.Activity.KafkaUsername == "" {
+				errs = append(errs, errors.New("KAFKA_USERNAME is required when KAFKA_SASL_MECHANISM is set"))
+			}
+		default:
+			errs = append(errs, errors.New("KAFKA_SASL_MECHANISM must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512"))
+		}
+	default:
+		errs = append(errs, errors.New("ACTIVITY_EXPORT must be none, memory or kafka"))
+	}
+
+	if c.Security.WebhookURL != "" {
+		if parsed, err := url.Parse(c.Security.WebhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, errors.New("SECURITY_WEBHOOK_URL must be an http or https URL"))
+		}
+	}
+	if c.Security.FailedLoginThreshold < 0 {
+		errs = append(errs, errors.New("SECURITY_FAILED_LOGIN_THRESHOLD can't be negative"))
+	}
+	if c.Security.NotifyUsers {
+		if _, _, err := net.SplitHostPort(c.Security.SMTP.Addr); err != nil {
+			errs = append(errs, errors.New("SMTP_ADDR must be host:port when SECURITY_NOTIFY_USERS is set"))
+		}
+		if c.Security.SMTP.From == "" {
+			errs = append(errs, errors.New("SMTP_FROM is required when SECURITY_NOTIFY_USERS is set"))
+		}
+	}
+
+	if c.Moderation.HTTPURL != "" {
+		if parsed, err := url.Parse(c.Moderation.HTTPURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, errors.New("MODERATION_HTTP_URL must be an http or https URL"))
+		}
+	}
+	if c.Moderation.HTTPTimeout <= 0 {
+		errs = append(errs, errors.New("MODERATION_HTTP_TIMEOUT must be positive"))
+	}
+	if c.LinkPreview.Timeout <= 0 {
+		errs = append(errs, errors.New("LINK_PREVIEW_TIMEOUT must be positive"))
+	}
+
+	return errs
+}
+
+// parser reads typed values and collects the parse errors
+type parser struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (p *parser) string(key string, fallback string) string {
+	if value := strings.TrimSpace(p.getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (p *parser) int(key string, fallback int) int {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be an integer, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (p *parser) bool(key string, fallback bool) bool {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be true or false, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// rate reads a chance between 0 and 1
+func (p *parser) rate(key string, fallback float64) float64 {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > 1 {
+		p.errs = append(p.errs, fmt.Errorf("%s must be a number between 0 and 1, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+// level reads a log level such as debug, info, warn or error
+func (p *parser) level(key string, fallback slog.Level) slog.Level {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(value)); err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be debug, info, warn or error, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (p *parser) duration(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be a duration such as 15m or 24h, got %q", key, value))
+		return fallback
+	}
+	return parsed
+}
+
+func (p *parser) list(key string, fallback []string) []string {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// pairs reads comma separated key=value pairs such as "api-key=secret,tenant=wetalk"
+func (p *parser) pairs(key string) map[string]string {
+	pairs := map[string]string{}
+	for _, item := range p.list(key, nil) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			p.errs = append(p.errs, fmt.Errorf("%s must be comma separated key=value pairs, got %q", key, item))
+			continue
+		}
+		pairs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return pairs
+}
+
+// abuseRules reads a JSON array such as [{"signal":"report","threshold":5,"window":"24h","action":"suspend"}]
+func (p *parser) abuseRules(key string) []AbuseRule {
+	value := strings.TrimSpace(p.getenv(key))
+	if value == "" {
+		return nil
+	}
+
+	var raw []struct {
+		Signal    string `json:"signal"`
+		Threshold int    `json:"threshold"`
+		Window    string `json:"window"`
+		Action    string `json:"action"`
+		Duration  string `json:"duration"`
+	}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s must be a JSON array of rules: %w", key, err))
+		return nil
+	}
+
+	rules := make([]AbuseRule, 0, len(raw))
+	for _, r := range raw {
+		rule := AbuseRule{
+			Signal:    r.Signal,
+			Threshold: r.Threshold,
+			Action:    r.Action,
+		}
+
+		var err error
+		if rule.Window, err = time.ParseDuration(r.Window); err != nil {
+			p.errs = append(p.errs, fmt.Errorf("%s has an invalid window %q", key, r.Window))
+		}
+		if r.Duration != "" {
+			if rule.Duration, err = time.ParseDuration(r.Duration); err != nil {
+				p.errs = append(p.errs, fmt.Errorf("%s has an invalid duration %q", key, r.Duration))
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+	return rules
+}
@@ -94,4 +94,46 @@ func (m *JWTManager) ValidateAccessToken(tokenString string) (*entity.TokenClaim
 		Email:    claims.Email,
 		Username: claims.Username,
 	}, nil
-}
\ No newline at end of file
+}
+
+// inviteLinkKey signs the invite link tokens, a key of their own keeps them from ever passing
+// for an access token
+func (m *JWTManager) inviteLinkKey() []byte {
+	return []byte(m.secretKey + ":invite_link")
+}
+
+// GenerateInviteLinkToken signs a join token pointing to an invite link, it expires with the link
+func (m *JWTManager) GenerateInviteLinkToken(linkId string, expiresAt time.Time) (string, error) {
+	claims := jwt.RegisteredClaims{
+		ID:        linkId,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(m.inviteLinkKey())
+}
+
+// ValidateInviteLinkToken validates a join token and returns the ID of its invite link
+func (m *JWTManager) ValidateInviteLinkToken(tokenString string) (string, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidToken
+		}
+		return m.inviteLinkKey(), nil
+	})
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return "", ErrExpiredToken
+		}
+		return "", ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	if !ok || !token.Valid || claims.ID == "" {
+		return "", ErrInvalidToken
+	}
+
+	return claims.ID, nil
+}
@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"wetalk/internal/entity"
+)
+
+var ErrQueueFull = errors.New("kafka: producer queue is full")
+
+// ActivityStream publishes the activity events as JSON records to a topic. Records are keyed by
+// chat, or by actor for the events outside of a chat, so the events of a chat stay in order
+type ActivityStream struct {
+	producer *Producer
+	topic    string
+}
+
+func NewActivityStream(producer *Producer, topic string) *ActivityStream {
+	return &ActivityStream{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (s *ActivityStream) Publish(ctx context.Context, event entity.ActivityEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	key := event.ChatId
+	if key == "" {
+		key = event.ActorId
+	}
+
+	if !s.producer.Send(Record{Topic: s.topic, Key: []byte(key), Value: value, Time: event.OccurredAt}) {
+		return ErrQueueFull
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package kafka produces records to Kafka with the franz-go client: records are queued without
+// blocking, batched and compressed per partition and acknowledged by every in-sync replica.
+// Consumers use any regular Kafka client.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// SASL mechanisms of Options.SASLMechanism
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+type Options struct {
+	// Brokers are the bootstrap brokers as host:port, the others are discovered
+	Brokers  []string
+	ClientId string
+	// TLS connects to the brokers over TLS, verified against the system roots
+	TLS bool
+	// SASLMechanism authenticates with Username and Password, no authentication when empty
+	SASLMechanism string
+	Username      string
+	Password      string
+	// FlushInterval is the longest a record waits for its batch to fill
+	FlushInterval time.Duration
+	// QueueSize bounds the records waiting to be sent, Send drops records once it is full
+	QueueSize int
+	// Timeout bounds the delivery of a record, retries included
+	Timeout time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		ClientId:      "wetalk",
+		FlushInterval: time.Second,
+		QueueSize:     10000,
+		Timeout:       10 * time.Second,
+	}
+}
+
+type Record struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// Producer sends the records from the goroutines of the client, Send never blocks
+type Producer struct {
+	client    *kgo.Client
+	queueSize int64
+	dropped   atomic.Int64
+	// failing is set from the first delivery failure to the next success, so an unreachable
+	// cluster is logged once rather than for every record
+	failing atomic.Bool
+}
+
+func NewProducer(options Options) (*Producer, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(options.Brokers...),
+		kgo.ClientID(options.ClientId),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.ProducerLinger(options.FlushInterval),
+		kgo.MaxBufferedRecords(options.QueueSize),
+		kgo.RecordDeliveryTimeout(options.Timeout),
+		kgo.ProducerBatchCompression(kgo.ZstdCompression(), kgo.SnappyCompression(), kgo.NoCompression()),
+	}
+	if options.TLS {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+
+	switch options.SASLMechanism {
+	case "":
+	case SASLPlain:
+		opts = append(opts, kgo.SASL(plain.Auth{User: options.Username, Pass: options.Password}.AsMechanism()))
+	case SASLScramSHA256:
+		opts = append(opts, kgo.SASL(scram.Auth{User: options.Username, Pass: options.Password}.AsSha256Mechanism()))
+	case SASLScramSHA512:
+		opts = append(opts, kgo.SASL(scram.Auth{User: options.Username, Pass: options.Password}.AsSha512Mechanism()))
+	default:
+		return nil, fmt.Errorf("kafka: unknown SASL mechanism %q", options.SASLMechanism)
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Kafka producer started", "brokers", options.Brokers)
+
+	return &Producer{
+		client:    client,
+		queueSize: int64(options.QueueSize),
+	}, nil
+}
+
+// Send queues a record without blocking, it reports false when the queue is full and the record
+// was dropped. The records that Kafka still refuses after the retries are dropped later on
+func (p *Producer) Send(record Record) bool {
+	if p.client.BufferedProduceRecords() >= p.queueSize {
+		p.dropped.Add(1)
+		return false
+	}
+
+	p.client.TryProduce(context.Background(), &kgo.Record{
+		Topic:     record.Topic,
+		Key:       record.Key,
+		Value:     record.Value,
+		Timestamp: record.Time,
+	}, p.delivered)
+	return true
+}
+
+func (p *Producer) delivered(record *kgo.Record, err error) {
+	if err == nil {
+		if p.failing.CompareAndSwap(true, false) {
+			slog.Info("Kafka records are delivered again")
+		}
+		return
+	}
+
+	p.dropped.Add(1)
+	if p.failing.CompareAndSwap(false, true) {
+		slog.Error("Kafka records dropped", "topic", record.Topic, "error", err)
+	}
+}
+
+// Dropped returns the number of records dropped because the queue was full or Kafka kept failing
+func (p *Producer) Dropped() int64 {
+	return p.dropped.Load()
+}
@@ -0,0 +1,241 @@
+// Package linkpreview reads the OpenGraph metadata of the web pages linked in messages. The
+// links come from users, so only public addresses on the standard ports are ever requested
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"wetalk/internal/entity"
+)
+
+var (
+	// ErrBlockedAddress is returned for links to private, loopback or otherwise internal addresses
+	ErrBlockedAddress = errors.New("link preview: the address is not public")
+	ErrNotHTML        = errors.New("link preview: the page is not HTML")
+)
+
+const (
+	// maxPageSize bounds what is read of a page, the metadata is in its head
+	maxPageSize = 512 << 10
+	// maxRedirects bounds the redirects followed, each target is checked like the link itself
+	maxRedirects = 3
+
+	maxTitleLength       = 200
+	maxDescriptionLength = 500
+	maxURLLength         = 2048
+
+	userAgent = "WeTalkBot/1.0 (link preview)"
+)
+
+// blockedPrefixes are the ranges not covered by the netip checks that still reach internal
+// networks: carrier-grade NAT, the benchmarking range, IETF protocol assignments and NAT64
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+var (
+	metaPattern      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// Fetcher reads the preview of a page. Every connection is checked once the host is resolved, so
+// a name resolving to an internal address, or a redirect to one, is refused as well
+type Fetcher struct {
+	httpClient *http.Client
+}
+
+// NewFetcher gives up on a page that took longer than the timeout, redirects included
+func NewFetcher(timeout time.Duration) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address)
+		},
+	}
+
+	transport := &http.Transport{
+		// A proxy from the environment would make the connections to it the only ones checked
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       time.Minute,
+	}
+
+	return &Fetcher{
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return fmt.Errorf("link preview: more than %d redirects", maxRedirects)
+				}
+				return checkURL(req.URL)
+			},
+		},
+	}
+}
+
+// Fetch reads the preview of the page at the URL, the preview is empty when the page has no metadata
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (entity.LinkPreview, error) {
+	if len(rawURL) > maxURLLength {
+		return entity.LinkPreview{}, errors.New("link preview: the URL is too long")
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return entity.LinkPreview{}, err
+	}
+	if err := checkURL(target); err != nil {
+		return entity.LinkPreview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return entity.LinkPreview{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return entity.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return entity.LinkPreview{}, fmt.Errorf("link preview: the page answered with status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return entity.LinkPreview{}, ErrNotHTML
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return entity.LinkPreview{}, err
+	}
+
+	// The preview points to where the redirects ended
+	return parse(resp.Request.URL, string(page)), nil
+}
+
+// parse reads the OpenGraph tags of the page, falling back to its title and description
+func parse(pageURL *url.URL, page string) entity.LinkPreview {
+	meta := map[string]string{}
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		attributes := map[string]string{}
+		for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(match[1])] = match[2] + match[3] + match[4]
+		}
+
+		key := attributes["property"]
+		if key == "" {
+			key = attributes["name"]
+		}
+		key = strings.ToLower(key)
+		// The first of repeated tags wins, as for the crawlers of the other apps
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = clean(attributes["content"])
+		}
+	}
+
+	preview := entity.LinkPreview{
+		Url:         pageURL.String(),
+		Title:       meta["og:title"],
+		Description: meta["og:description"],
+		SiteName:    meta["og:site_name"],
+	}
+	if preview.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			preview.Title = clean(match[1])
+		}
+	}
+	if preview.Description == "" {
+		preview.Description = meta["description"]
+	}
+	if image, err := pageURL.Parse(meta["og:image"]); err == nil && meta["og:image"] != "" && (image.Scheme == "http" || image.Scheme == "https") {
+		preview.Image = image.String()
+	}
+
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	preview.Description = truncate(preview.Description, maxDescriptionLength)
+	preview.SiteName = truncate(preview.SiteName, maxTitleLength)
+	return preview
+}
+
+// checkURL only lets http and https URLs on their standard port through, to hosts that are not
+// written as internal addresses. Names are checked once resolved, see checkAddress
+func checkURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("link preview: unsupported scheme %q", target.Scheme)
+	}
+	if target.User != nil {
+		return errors.New("link preview: URLs with credentials are not fetched")
+	}
+	if port := target.Port(); port != "" && port != "80" && port != "443" {
+		return fmt.Errorf("link preview: port %s is not fetched", port)
+	}
+	if addr, err := netip.ParseAddr(target.Hostname()); err == nil && !isPublic(addr) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+// checkAddress refuses a connection to an address that is not public, it runs on the resolved
+// address so DNS answers can't point the fetcher inside the network
+func checkAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublic(addrPort.Addr()) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// clean decodes the entities of a value and collapses its whitespace
+func clean(value string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(value)), " ")
+}
+
+// truncate shortens text to at most n characters
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
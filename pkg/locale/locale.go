@@ -0,0 +1,58 @@
+// Package locale formats the dates of the content written for people, such as emails and system
+// messages, after their locale (a BCP 47 tag like "en-US"). Only the layout follows the locale,
+// month names are English or left out, so unknown languages fall back to an ISO-8601 like layout.
+package locale
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// tagPattern accepts the well-formed language tags, a language followed by script, region or variant subtags
+var tagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+const defaultLayout = "2006-01-02 15:04 MST"
+
+// layouts are keyed by language, or language and region when the region writes dates differently
+var layouts = map[string]string{
+	"en":    "2 January 2006, 15:04 MST",
+	"en-us": "January 2, 2006, 3:04 PM MST",
+	"en-ca": "January 2, 2006, 3:04 PM MST",
+	"de":    "02.01.2006, 15:04 MST",
+	"ru":    "02.01.2006, 15:04 MST",
+	"fr":    "02/01/2006 15:04 MST",
+	"es":    "02/01/2006 15:04 MST",
+	"it":    "02/01/2006 15:04 MST",
+	"pt":    "02/01/2006 15:04 MST",
+	"id":    "02/01/2006 15.04 MST",
+	"nl":    "02-01-2006 15:04 MST",
+	"ja":    "2006/01/02 15:04 MST",
+	"zh":    "2006/01/02 15:04 MST",
+	"ko":    "2006. 01. 02. 15:04 MST",
+}
+
+// Valid reports whether the tag is a well-formed language tag, it may still be unknown to Format
+func Valid(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// Format writes the date and time of t, in its location, the way the locale reads them
+func Format(t time.Time, tag string) string {
+	tag = strings.ToLower(tag)
+	language, rest, _ := strings.Cut(tag, "-")
+
+	// The region is the first subtag of two letters or three digits, after the script if any
+	for _, subtag := range strings.Split(rest, "-") {
+		if len(subtag) == 2 || (len(subtag) == 3 && subtag[0] >= '0' && subtag[0] <= '9') {
+			if layout, ok := layouts[language+"-"+subtag]; ok {
+				return t.Format(layout)
+			}
+			break
+		}
+	}
+	if layout, ok := layouts[language]; ok {
+		return t.Format(layout)
+	}
+	return t.Format(defaultLayout)
+}
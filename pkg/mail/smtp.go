@@ -0,0 +1,92 @@
+// Package mail sends plain text emails through an SMTP relay
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+var (
+	ErrLineBreak = errors.New("mail: recipient and subject can't contain line breaks")
+	// ErrHardBounce means the relay refused the recipient for good, such as an unknown mailbox.
+	// Other failures may pass when tried again
+	ErrHardBounce = errors.New("mail: recipient rejected permanently")
+)
+
+// Options configures the SMTP relay, Username and Password are optional
+type Options struct {
+	// Addr is the relay as host:port
+	Addr     string
+	Username string
+	Password string
+	// From is the sender address of every email
+	From string
+}
+
+// SMTPSender sends emails through the relay with STARTTLS when it offers it
+type SMTPSender struct {
+	options Options
+}
+
+func NewSMTPSender(options Options) *SMTPSender {
+	return &SMTPSender{options: options}
+}
+
+func (s *SMTPSender) Send(ctx context.Context, to string, subject string, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return ErrLineBreak
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.options.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+
+	// net/smtp has no context, the send runs aside and is abandoned once the context is done
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.options.Addr, s.auth(), s.options.From, []string{to}, []byte(message))
+	}()
+
+	select {
+	case err := <-done:
+		return classify(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// classify wraps the replies refusing the mailbox itself in ErrHardBounce: 550 mailbox unavailable,
+// 551 user not local and 553 mailbox name not allowed. Every other reply may be temporary or the
+// fault of the relay, such as a failed authentication
+func classify(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && (reply.Code == 550 || reply.Code == 551 || reply.Code == 553) {
+		return fmt.Errorf("%w: %d %s", ErrHardBounce, reply.Code, reply.Msg)
+	}
+	return err
+}
+
+func (s *SMTPSender) auth() smtp.Auth {
+	if s.options.Username == "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(s.options.Addr)
+	if err != nil {
+		host = s.options.Addr
+	}
+	return smtp.PlainAuth("", s.options.Username, s.options.Password, host)
+}
@@ -0,0 +1,180 @@
+// Package metrics keeps labelled counters and gauges in a Prometheus client registry, served to
+// scrapers by its handler, and reads them back for the reports built from the same numbers
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Registry holds every metric exposed by the process, along with the Go runtime and process ones
+type Registry struct {
+	registry *prometheus.Registry
+
+	mu         sync.RWMutex
+	collectors []func()
+}
+
+func NewRegistry() *Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return &Registry{registry: registry}
+}
+
+// Vec is a family of series sharing a name and label names, told apart by their label values.
+// It is either a counter or a gauge
+type Vec struct {
+	labelNames []string
+	counter    *prometheus.CounterVec
+	gauge      *prometheus.GaugeVec
+}
+
+// NewCounter registers a counter, it only goes up
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Vec {
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	r.registry.MustRegister(counter)
+	return &Vec{labelNames: labelNames, counter: counter}
+}
+
+// NewGauge registers a gauge, it can go up and down or be set
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Vec {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	r.registry.MustRegister(gauge)
+	return &Vec{labelNames: labelNames, gauge: gauge}
+}
+
+// OnCollect registers a function run before every scrape, it refreshes gauges that
+// mirror state kept elsewhere instead of being updated as it changes
+func (r *Registry) OnCollect(collect func()) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, collect)
+	r.mu.Unlock()
+}
+
+// Handler serves the metrics to a Prometheus scraper
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.GathererFunc(r.gather), promhttp.HandlerOpts{})
+}
+
+func (r *Registry) gather() ([]*dto.MetricFamily, error) {
+	r.mu.RLock()
+	collectors := append([]func(){}, r.collectors...)
+	r.mu.RUnlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+	return r.registry.Gather()
+}
+
+// Add adds delta to the series with the given label values, in the order of the label names.
+// Counters ignore negative deltas
+func (v *Vec) Add(delta float64, labelValues ...string) {
+	if v.counter != nil {
+		if delta >= 0 {
+			v.counter.WithLabelValues(labelValues...).Add(delta)
+		}
+		return
+	}
+	v.gauge.WithLabelValues(labelValues...).Add(delta)
+}
+
+func (v *Vec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Dec lowers a gauge by one
+func (v *Vec) Dec(labelValues ...string) {
+	if v.gauge != nil {
+		v.gauge.WithLabelValues(labelValues...).Dec()
+	}
+}
+
+// Set replaces the value of a gauge
+func (v *Vec) Set(value float64, labelValues ...string) {
+	if v.gauge != nil {
+		v.gauge.WithLabelValues(labelValues...).Set(value)
+	}
+}
+
+// Reset drops every series, collectors use it so series of things that are gone stop being exposed
+func (v *Vec) Reset() {
+	if v.counter != nil {
+		v.counter.Reset()
+		return
+	}
+	v.gauge.Reset()
+}
+
+// Value returns the current value of a series, zero if it was never touched
+func (v *Vec) Value(labelValues ...string) float64 {
+	value := 0.0
+	v.each(func(labels map[string]string, seriesValue float64) {
+		for i, name := range v.labelNames {
+			if i >= len(labelValues) || labels[name] != labelValues[i] {
+				return
+			}
+		}
+		value = seriesValue
+	})
+	return value
+}
+
+// Total returns the sum of every series
+func (v *Vec) Total() float64 {
+	total := 0.0
+	v.each(func(_ map[string]string, value float64) {
+		total += value
+	})
+	return total
+}
+
+// Values returns every series keyed by the value of the given label, series sharing it are summed
+func (v *Vec) Values(labelName string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, name := range v.labelNames {
+		if name != labelName {
+			continue
+		}
+		v.each(func(labels map[string]string, value float64) {
+			values[labels[labelName]] += value
+		})
+	}
+	return values
+}
+
+// each calls fn with the labels and value of every series of the vec
+func (v *Vec) each(fn func(labels map[string]string, value float64)) {
+	var collector prometheus.Collector = v.gauge
+	if v.counter != nil {
+		collector = v.counter
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+
+		labels := make(map[string]string, len(m.GetLabel()))
+		for _, pair := range m.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		if v.counter != nil {
+			fn(labels, m.GetCounter().GetValue())
+		} else {
+			fn(labels, m.GetGauge().GetValue())
+		}
+	}
+}
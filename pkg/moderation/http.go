@@ -0,0 +1,101 @@
+// Package moderation asks an external moderation service whether the text of a message can be
+// stored, through a JSON HTTP API
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"wetalk/internal/entity"
+)
+
+// maxResponseSize bounds the verdict read from the service
+const maxResponseSize = 1 << 20
+
+// HTTPModerator posts the text of every message to a moderation service and reads its verdict.
+//
+// The request is {"text", "chatId", "senderId", "workspaceId"} and the service answers
+// {"flagged": bool, "categories": [...], "matches": [...]}, where matches are the passages of the
+// text to mask. A flagged verdict without categories is reported in the "external" category.
+type HTTPModerator struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+// NewHTTPModerator sends the token as a bearer token when it is set, a service slower than the
+// timeout fails the moderation of the message
+func NewHTTPModerator(url string, token string, timeout time.Duration) *HTTPModerator {
+	return &HTTPModerator{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+type moderationRequest struct {
+	Text        string `json:"text"`
+	ChatId      string `json:"chatId"`
+	SenderId    string `json:"senderId"`
+	WorkspaceId string `json:"workspaceId"`
+}
+
+type moderationResponse struct {
+	Flagged    bool     `json:"flagged"`
+	Categories []string `json:"categories"`
+	Matches    []string `json:"matches"`
+}
+
+func (m *HTTPModerator) Name() string {
+	return "http"
+}
+
+func (m *HTTPModerator) Moderate(ctx context.Context, settings entity.WorkspaceSettings, message entity.Message) (entity.ModerationVerdict, error) {
+	body, err := json.Marshal(moderationRequest{
+		Text:        message.Message,
+		ChatId:      message.ChatId,
+		SenderId:    message.SenderId,
+		WorkspaceId: settings.WorkspaceId,
+	})
+	if err != nil {
+		return entity.ModerationVerdict{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return entity.ModerationVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return entity.ModerationVerdict{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return entity.ModerationVerdict{}, fmt.Errorf("moderation service failed with status %d", resp.StatusCode)
+	}
+
+	var result moderationResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return entity.ModerationVerdict{}, fmt.Errorf("read moderation verdict: %w", err)
+	}
+	if !result.Flagged {
+		return entity.ModerationVerdict{}, nil
+	}
+
+	categories := result.Categories
+	if len(categories) == 0 {
+		categories = []string{entity.ModerationCategoryExternal}
+	}
+	return entity.ModerationVerdict{Categories: categories, Matches: result.Matches}, nil
+}
@@ -0,0 +1,50 @@
+// Package naming converts the field names of decoded JSON documents between conventions, such as
+// the camelCase of the API and the snake_case some clients expect.
+package naming
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SnakeCase turns a camelCase name into snake_case. A run of capitals is one word, so "chatID"
+// and "chatId" both become "chat_id" and "HTMLBody" becomes "html_body"
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	b.Grow(len(name) + 4)
+
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			previousLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			// The last capital of a run starts the next word, as the H of "HTMLBody" doesn't
+			endOfRun := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if previousLower || endOfRun {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// RenameKeys renames the keys of every object of a document decoded into any, the values are
+// left untouched. Objects are copied, lists are updated in place
+func RenameKeys(document any, rename func(string) string) any {
+	switch value := document.(type) {
+	case map[string]any:
+		renamed := make(map[string]any, len(value))
+		for key, item := range value {
+			renamed[rename(key)] = RenameKeys(item, rename)
+		}
+		return renamed
+	case []any:
+		for i, item := range value {
+			value[i] = RenameKeys(item, rename)
+		}
+		return value
+	}
+	return document
+}
@@ -0,0 +1,81 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"wetalk/internal/entity"
+
+	"github.com/sideshow/apns2"
+	"github.com/sideshow/apns2/payload"
+	"github.com/sideshow/apns2/token"
+)
+
+type APNsOptions struct {
+	// KeyFile is the .p8 signing key created in the Apple developer account
+	KeyFile string
+	KeyId   string
+	TeamId  string
+	// Topic is the bundle id of the app
+	Topic   string
+	Sandbox bool
+}
+
+// APNsSender sends notifications through the Apple Push Notification service with token based
+// auth, the provider token is refreshed by the client within the limits set by Apple
+type APNsSender struct {
+	client *apns2.Client
+	topic  string
+}
+
+func NewAPNsSender(options APNsOptions) (*APNsSender, error) {
+	authKey, err := token.AuthKeyFromFile(options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs key: %w", err)
+	}
+
+	client := apns2.NewTokenClient(&token.Token{
+		AuthKey: authKey,
+		KeyID:   options.KeyId,
+		TeamID:  options.TeamId,
+	})
+	if options.Sandbox {
+		client = client.Development()
+	} else {
+		client = client.Production()
+	}
+
+	return &APNsSender{
+		client: client,
+		topic:  options.Topic,
+	}, nil
+}
+
+func (s *APNsSender) Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error {
+	resp, err := s.client.PushWithContext(ctx, &apns2.Notification{
+		DeviceToken: device.PushToken,
+		Topic:       s.topic,
+		PushType:    apns2.PushTypeAlert,
+		// Collapse duplicates of the same message on the device
+		CollapseID: notification.MessageId,
+		Payload: payload.NewPayload().
+			AlertTitle(notification.Title).
+			AlertBody(notification.Body).
+			Sound("default").
+			ThreadID(notification.ChatId).
+			Custom("chatId", notification.ChatId).
+			Custom("messageId", notification.MessageId),
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Sent() {
+		return nil
+	}
+	if resp.StatusCode == http.StatusGone || resp.Reason == apns2.ReasonBadDeviceToken || resp.Reason == apns2.ReasonUnregistered {
+		return ErrUnregisteredDevice
+	}
+	return fmt.Errorf("apns send failed with status %d: %s", resp.StatusCode, resp.Reason)
+}
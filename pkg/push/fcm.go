@@ -0,0 +1,76 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"wetalk/internal/entity"
+
+	"golang.org/x/oauth2/google"
+	fcm "google.golang.org/api/fcm/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// FCMSender sends notifications through the Firebase Cloud Messaging HTTP v1 API
+type FCMSender struct {
+	messages *fcm.ProjectsMessagesService
+	// parent is the project the messages are sent from, as projects/{project_id}
+	parent string
+}
+
+// NewFCMSender reads the service account key file downloaded from the Firebase console
+func NewFCMSender(credentialsFile string) (*FCMSender, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	credentials, err := google.CredentialsFromJSON(ctx, data, fcm.FirebaseMessagingScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM credentials: %w", err)
+	}
+	if credentials.ProjectID == "" {
+		return nil, errors.New("invalid FCM credentials: project_id is required")
+	}
+
+	service, err := fcm.NewService(ctx, option.WithCredentials(credentials))
+	if err != nil {
+		return nil, err
+	}
+
+	return &FCMSender{
+		messages: service.Projects.Messages,
+		parent:   "projects/" + credentials.ProjectID,
+	}, nil
+}
+
+func (s *FCMSender) Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error {
+	_, err := s.messages.Send(s.parent, &fcm.SendMessageRequest{
+		Message: &fcm.Message{
+			Token: device.PushToken,
+			Notification: &fcm.Notification{
+				Title: notification.Title,
+				Body:  notification.Body,
+			},
+			Data: map[string]string{
+				"chatId":    notification.ChatId,
+				"messageId": notification.MessageId,
+			},
+		},
+	}).Context(ctx).Do()
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		// UNREGISTERED, the app was uninstalled or the token rotated
+		return ErrUnregisteredDevice
+	}
+	return fmt.Errorf("fcm send failed: %w", err)
+}
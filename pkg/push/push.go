@@ -0,0 +1,46 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"wetalk/internal/entity"
+)
+
+var (
+	// ErrUnregisteredDevice means the provider no longer knows the push token, the device should be forgotten
+	ErrUnregisteredDevice = errors.New("device is no longer registered with the push provider")
+	ErrNoProvider         = errors.New("no push provider configured for this platform")
+)
+
+// Sender delivers a notification to a device through one push provider
+type Sender interface {
+	Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error
+}
+
+// Router sends each notification through the provider of the device platform,
+// the fallback (usually a webhook) handles the platforms without a dedicated provider
+type Router struct {
+	senders  map[string]Sender
+	fallback Sender
+}
+
+func NewRouter(senders map[string]Sender, fallback Sender) *Router {
+	return &Router{
+		senders:  senders,
+		fallback: fallback,
+	}
+}
+
+func (r *Router) Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error {
+	sender, ok := r.senders[device.Platform]
+	if !ok {
+		sender = r.fallback
+	}
+	if sender == nil {
+		return fmt.Errorf("%w: %s", ErrNoProvider, device.Platform)
+	}
+
+	return sender.Send(ctx, device, notification)
+}
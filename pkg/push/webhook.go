@@ -0,0 +1,78 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"wetalk/internal/entity"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the body, keyed with the webhook secret
+const signatureHeader = "X-Wetalk-Signature"
+
+// WebhookSender posts notifications to an HTTP endpoint that forwards them to any provider
+type WebhookSender struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+func NewWebhookSender(url string, secret string) *WebhookSender {
+	return &WebhookSender{
+		url:        url,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type webhookPayload struct {
+	DeviceId     string                  `json:"deviceId"`
+	Platform     string                  `json:"platform"`
+	PushToken    string                  `json:"pushToken"`
+	Notification entity.PushNotification `json:"notification"`
+}
+
+func (s *WebhookSender) Send(ctx context.Context, device entity.Device, notification entity.PushNotification) error {
+	body, err := json.Marshal(webhookPayload{
+		DeviceId:     device.Id,
+		Platform:     device.Platform,
+		PushToken:    device.PushToken,
+		Notification: notification,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusGone:
+		return ErrUnregisteredDevice
+	default:
+		return fmt.Errorf("push webhook failed with status %d", resp.StatusCode)
+	}
+}
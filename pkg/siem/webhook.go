@@ -0,0 +1,64 @@
+// Package siem forwards the security events of the server to a security information and event
+// management system through a signed JSON webhook
+package siem
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"wetalk/internal/entity"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the body, keyed with the webhook secret
+const signatureHeader = "X-Wetalk-Signature"
+
+// WebhookSink posts every security event as a JSON object to an HTTP endpoint
+type WebhookSink struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+func NewWebhookSink(url string, secret string) *WebhookSink {
+	return &WebhookSink{
+		url:        url,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Report(ctx context.Context, event entity.SecurityEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("security webhook failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MongoMonitor records a span for every Mongo command, under the span of the repository call's context
+func MongoMonitor(tracer trace.Tracer) *event.CommandMonitor {
+	// The driver reports the end of a command without its context, match them by request id
+	var inFlight sync.Map
+	finish := func(e event.CommandFinishedEvent, err error) {
+		key := fmt.Sprintf("%s/%d", e.ConnectionID, e.RequestID)
+		if value, ok := inFlight.LoadAndDelete(key); ok {
+			span := value.(trace.Span)
+			RecordError(span, err)
+			span.End()
+		}
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			_, span := tracer.Start(ctx, "mongo."+e.CommandName, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+				attribute.String("db.system", "mongodb"),
+				attribute.String("db.name", e.DatabaseName),
+				attribute.String("db.operation", e.CommandName),
+			))
+			inFlight.Store(fmt.Sprintf("%s/%d", e.ConnectionID, e.RequestID), span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finish(e.CommandFinishedEvent, nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finish(e.CommandFinishedEvent, errors.New(e.Failure))
+		},
+	}
+}
+
+// RedisHooks returns the hook recording a span for every Redis PUBLISH and XADD
+func RedisHooks(tracer trace.Tracer) []redis.Hook {
+	return []redis.Hook{publishHook{tracer: tracer}}
+}
+
+type publishHook struct {
+	tracer trace.Tracer
+}
+
+func (h publishHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h publishHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		name := strings.ToLower(cmd.Name())
+		if name != "publish" && name != "xadd" {
+			return next(ctx, cmd)
+		}
+
+		ctx, span := h.tracer.Start(ctx, "redis."+name, trace.WithSpanKind(trace.SpanKindProducer))
+		defer span.End()
+		span.SetAttributes(attribute.String("db.system", "redis"))
+		if args := cmd.Args(); len(args) > 1 {
+			span.SetAttributes(attribute.String("messaging.destination.name", fmt.Sprint(args[1])))
+		}
+
+		err := next(ctx, cmd)
+		RecordError(span, err)
+		return err
+	}
+}
+
+func (h publishHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
@@ -0,0 +1,87 @@
+// Package tracing sets up OpenTelemetry tracing: spans are batched and exported to a collector by
+// the OTLP/HTTP exporter, and trace context travels between services in the W3C traceparent
+// format. Instrumented code records spans through the trace.Tracer it is given, a no-op one when
+// tracing is disabled
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Name is the instrumentation scope of the spans of the service
+const Name = "wetalk"
+
+type Config struct {
+	ServiceName string
+	// InstanceId tells the servers of a cluster apart
+	InstanceId string
+	// Endpoint is the OTLP/HTTP traces URL, such as http://localhost:4318/v1/traces
+	Endpoint string
+	// Headers are sent with every export, usually to authenticate against the collector
+	Headers map[string]string
+	// SampleRate is the share of new traces recorded, traces started elsewhere follow their caller
+	SampleRate float64
+}
+
+// New returns a tracer provider exporting to the configured endpoint, its Shutdown exports the
+// spans still queued
+func New(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(cfg.Endpoint),
+		otlptracehttp.WithHeaders(cfg.Headers),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := []attribute.KeyValue{semconv.ServiceName(cfg.ServiceName)}
+	if cfg.InstanceId != "" {
+		attributes = append(attributes, semconv.ServiceInstanceID(cfg.InstanceId))
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attributes...)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRate))),
+	), nil
+}
+
+// Disabled returns a tracer recording nothing, for servers without a collector
+func Disabled() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(Name)
+}
+
+// RecordError marks the span as failed, nil errors are ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceParent returns the traceparent header of the span in ctx, empty when there is none
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns a context whose next span continues the trace of a traceparent header,
+// invalid headers are ignored
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
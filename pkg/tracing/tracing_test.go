@@ -0,0 +1,30 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	ctx := WithTraceParent(context.Background(), traceParent)
+	if got := TraceParent(ctx); got != traceParent {
+		t.Errorf("TraceParent = %q, want %q", got, traceParent)
+	}
+}
+
+func TestWithTraceParentIgnoresInvalidHeaders(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"not a traceparent",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		ctx := WithTraceParent(context.Background(), header)
+		if got := TraceParent(ctx); got != "" {
+			t.Errorf("TraceParent after %q = %q, want none", header, got)
+		}
+	}
+}